@@ -1,6 +1,8 @@
 use criterion::{criterion_group, criterion_main, Criterion, BenchmarkGroup, measurement::WallTime};
 use rand::{Rng, rngs::OsRng};
 use rq_library::processor::{ProcessorConfig, RaptorQProcessor};
+use rq_library::{symbol_id, BlockLayout, LayoutFormat, RaptorQLayout, SymbolCodec, LAYOUT_VERSION};
+use std::collections::BTreeMap;
 use std::fs::{self, File};
 use std::io::{self, Write};
 use std::path::{Path, PathBuf};
@@ -650,6 +652,99 @@ fn bench_create_metadata_1gb(group: &mut BenchmarkGroup<WallTime>) {
     println!("Average bytes allocated: {}; Average number of allocations: {}", bytes_to_mb_or_gb(total_bytes / counter), total_allocations / counter);
 }
 
+// Benchmark repeated encodes of a 10MB file, with or without reusing the block buffers
+fn bench_encode_10mb_repeated(group: &mut BenchmarkGroup<WallTime>, buffer_pool: bool) {
+    let processor = RaptorQProcessor::builder()
+        .buffer_pool(buffer_pool)
+        .build()
+        .expect("Failed to create processor");
+
+    let mut total_allocations = 0;
+    let mut total_bytes = 0;
+    let mut counter = 0;
+
+    let name = if buffer_pool { "encode_10mb_buffer_pool" } else { "encode_10mb_no_buffer_pool" };
+    group.bench_function(name, |b| {
+        let (temp_dir, input_file, output_dir) = setup_test_env(SIZE_10MB);
+
+        // The first encode fills the pool
+        encode_file_for_decoding(&processor, &input_file, &output_dir);
+
+        b.iter(|| {
+            let info = allocation_counter::measure(|| {
+                encode_file_for_decoding(&processor, &input_file, &output_dir);
+            });
+            total_allocations += info.count_total;
+            total_bytes += info.bytes_total;
+            counter += 1;
+        });
+
+        drop(temp_dir);
+    });
+
+    println!("Buffer pool {}: {} B/op; {} allocs/op", if buffer_pool { "on" } else { "off" }, total_bytes / counter, total_allocations / counter);
+}
+
+// Group the encodes with and without the buffer pool, to compare their allocations
+fn buffer_pool_benchmarks(c: &mut Criterion) {
+    let mut group = c.benchmark_group("Buffer Pool");
+
+    group.measurement_time(Duration::from_secs(40));
+    group.sample_size(20);
+    bench_encode_10mb_repeated(&mut group, false);
+    bench_encode_10mb_repeated(&mut group, true);
+    println!();
+
+    group.finish();
+}
+
+// Layout of an object of `block_count` blocks of 16KB, with 24 symbols of 1KB each
+fn create_layout_for_benchmarking(block_count: usize) -> RaptorQLayout {
+    let blocks = (0..block_count).map(|block_id| BlockLayout {
+        block_id,
+        encoder_parameters: vec![0, 0, 0, 64, 0, 0, 4, 0, 1, 0, 1, 8],
+        original_offset: block_id as u64 * 16 * 1024,
+        size: 16 * 1024,
+        symbols: (0..24u64).map(|i| symbol_id(&(block_id as u64 * 24 + i).to_le_bytes())).collect(),
+        hash: symbol_id(&(block_id as u64).to_be_bytes()),
+    }).collect();
+    RaptorQLayout {
+        layout_version: LAYOUT_VERSION,
+        blocks,
+        object_sha256: "0".repeat(64),
+        symbol_codec: SymbolCodec::None,
+        symbol_key_id: String::new(),
+        symbol_crc: false,
+        metadata: BTreeMap::new(),
+    }
+}
+
+// Benchmark parsing the layout of a 10k-block object in both formats
+fn bench_parse_layout_10k_blocks(group: &mut BenchmarkGroup<WallTime>) {
+    let layout = create_layout_for_benchmarking(10_000);
+
+    for (name, format) in [("parse_layout_10k_blocks_json", LayoutFormat::Json), ("parse_layout_10k_blocks_binary", LayoutFormat::Binary)] {
+        let content = layout.to_bytes(format).expect("Failed to serialize the layout");
+        println!("{:?} layout: {} bytes", format, content.len());
+
+        group.bench_function(name, |b| {
+            b.iter(|| RaptorQLayout::parse(&content).expect("Failed to parse the layout"));
+        });
+    }
+}
+
+// Group the layout benchmarks
+fn layout_benchmarks(c: &mut Criterion) {
+    let mut group = c.benchmark_group("Layout");
+
+    group.measurement_time(Duration::from_secs(20));
+    group.sample_size(50);
+    bench_parse_layout_10k_blocks(&mut group);
+    println!();
+
+    group.finish();
+}
+
 // Group encoding benchmarks
 fn encoding_benchmarks(c: &mut Criterion) {
     // Create a benchmark group with specific configuration for encoding
@@ -753,5 +848,5 @@ fn metadata_benchmarks(c: &mut Criterion) {
 }
 
 // criterion_group!(benches, encoding_benchmarks, decoding_benchmarks, metadata_benchmarks);
-criterion_group!(benches, encoding_benchmarks);
+criterion_group!(benches, encoding_benchmarks, buffer_pool_benchmarks, layout_benchmarks);
 criterion_main!(benches);
\ No newline at end of file
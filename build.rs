@@ -1,5 +1,6 @@
 use std::env;
 use std::path::PathBuf;
+use std::process::Command;
 
 fn main() {
     let crate_dir = env::var("CARGO_MANIFEST_DIR").unwrap();
@@ -22,6 +23,28 @@ fn main() {
 
     println!("cargo:rerun-if-changed=src/lib.rs");
     println!("cargo:rerun-if-changed=src/processor.rs");
+
+    // Commit of the build, reported by raptorq_version_info
+    if let Some(hash) = build_hash(&crate_dir) {
+        println!("cargo:rustc-env=RQ_LIBRARY_BUILD_HASH={}", hash);
+    }
+    println!("cargo:rerun-if-changed=.git/HEAD");
+    println!("cargo:rerun-if-env-changed=RQ_LIBRARY_BUILD_HASH");
+}
+
+// Commit checked out in the crate directory, or RQ_LIBRARY_BUILD_HASH when it is set
+// (builds from a source archive)
+fn build_hash(crate_dir: &str) -> Option<String> {
+    if let Ok(hash) = env::var("RQ_LIBRARY_BUILD_HASH") {
+        return Some(hash);
+    }
+    let output = Command::new("git")
+        .args(["rev-parse", "--short=12", "HEAD"])
+        .current_dir(crate_dir)
+        .output()
+        .ok()?;
+    let hash = String::from_utf8(output.stdout).ok()?.trim().to_string();
+    (output.status.success() && !hash.is_empty()).then_some(hash)
 }
 
 fn target_dir() -> PathBuf {
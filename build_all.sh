@@ -38,17 +38,23 @@ else
     echo "To build for Android, please install the Android SDK and NDK."
 fi
 
+# Cross-build for Windows if MinGW is available
+if command -v x86_64-w64-mingw32-gcc &> /dev/null; then
+    echo "MinGW detected, building for Windows..."
+    ./build_windows.sh
+else
+    echo "MinGW not found, skipping Windows build."
+    echo "To build for Windows, install mingw-w64 or run ./build_windows.ps1 on a Windows machine."
+fi
+
 # Print platform-specific instructions
 echo ""
 echo "Build process completed."
 echo ""
-echo "To build for Windows, run the PowerShell script on a Windows machine:"
-echo "    ./build_windows.ps1"
-echo ""
 echo "Final libraries available in the following locations:"
 echo "- MacOS:   dist/lib/darwin/amd64 (Intel) and dist/lib/darwin/arm64 (Apple Silicon)"
 echo "- Linux:   dist/lib/linux/amd64 (x86_64) and dist/lib/linux/arm64 (aarch64)"
 echo "- iOS:     dist/lib/ios/arm64"
 echo "- Android: dist/lib/android/arm64"
 echo "- WASM:    dist/lib/wasm/emscripten"
-echo "- Windows: dist/lib/windows/x64 (64-bit) and dist/lib/windows/x86 (32-bit)"
\ No newline at end of file
+echo "- Windows: dist/lib/windows/amd64 (MinGW, for Go), dist/lib/windows/x64 and dist/lib/windows/x86 (MSVC)"
\ No newline at end of file
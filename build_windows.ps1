@@ -30,6 +30,21 @@ Copy-Item -Path "target\x86_64-pc-windows-msvc\release\rq_library.dll" -Destinat
 Copy-Item -Path "target\x86_64-pc-windows-msvc\release\rq_library.lib" -Destination "dist\lib\windows\x64\" -Force
 Copy-Item -Path "target\x86_64-pc-windows-msvc\release\rq_library.dll.lib" -Destination "dist\lib\windows\x64\" -Force -ErrorAction SilentlyContinue
 
+# Build for x86_64 with MinGW, the toolchain of cgo, for the Go bindings
+# Requires MinGW-w64 gcc in the PATH (e.g. from MSYS2: pacman -S mingw-w64-x86_64-gcc)
+Write-Host "Building for x86_64-pc-windows-gnu..."
+rustup target add x86_64-pc-windows-gnu
+cargo build --target x86_64-pc-windows-gnu --release
+
+# Create the output directory if it doesn't exist
+EnsureDirectory -Directory "dist\lib\windows\amd64"
+
+# Copy the built library files for the Go bindings
+Write-Host "Copying built files to dist\lib\windows\amd64\"
+Copy-Item -Path "target\x86_64-pc-windows-gnu\release\librq_library.a" -Destination "dist\lib\windows\amd64\" -Force
+Copy-Item -Path "target\x86_64-pc-windows-gnu\release\rq_library.dll" -Destination "dist\lib\windows\amd64\" -Force
+Copy-Item -Path "target\x86_64-pc-windows-gnu\release\librq_library.dll.a" -Destination "dist\lib\windows\amd64\" -Force
+
 # Build for i686 (32-bit Windows)
 Write-Host "Building for i686-pc-windows-msvc..."
 rustup target add i686-pc-windows-msvc
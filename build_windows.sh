@@ -0,0 +1,42 @@
+#!/bin/bash
+set -e
+
+# Cross-builds the RQ Library for Windows (x86_64, MinGW) from Linux or macOS.
+# The MinGW target gives the librq_library.a that cgo links on windows/amd64.
+#
+#   ./build_windows.sh          build and copy the libraries to dist/lib/windows/amd64
+#   ./build_windows.sh --check  only check that the library and its tests compile for
+#                               windows/amd64, no MinGW toolchain needed
+
+TARGET=x86_64-pc-windows-gnu
+
+rustup target add $TARGET
+
+if [ "$1" = "--check" ]; then
+    echo "Checking that RQ Library compiles for Windows ($TARGET)..."
+    cargo check --target $TARGET --lib --tests
+    echo "RQ Library compiles for Windows."
+    exit 0
+fi
+
+echo "Building RQ Library for Windows ($TARGET)..."
+
+if ! command -v x86_64-w64-mingw32-gcc &> /dev/null; then
+    echo "Error: x86_64-w64-mingw32-gcc is not installed."
+    echo "  macOS - brew install mingw-w64"
+    echo "  Linux - sudo apt install gcc-mingw-w64-x86-64 g++-mingw-w64-x86-64"
+    exit 1
+fi
+
+cargo build --target $TARGET --release
+
+# Create the output directory if it doesn't exist
+mkdir -p dist/lib/windows/amd64
+
+# Copy the built library files: the static library for cgo, the DLL and its import library
+echo "Copying built files to dist/lib/windows/amd64/"
+cp target/$TARGET/release/librq_library.a dist/lib/windows/amd64/
+cp target/$TARGET/release/rq_library.dll dist/lib/windows/amd64/
+cp target/$TARGET/release/librq_library.dll.a dist/lib/windows/amd64/
+
+echo "Windows build completed. Output files in dist/lib/windows/amd64/"
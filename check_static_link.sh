@@ -0,0 +1,40 @@
+#!/bin/bash
+set -e
+
+# Links examples/c/roundtrip.c with the static RQ Library for the host and checks
+# the program has no runtime dependency on the shared library: it must not list
+# librq_library among its dynamic dependencies and must run without LD_LIBRARY_PATH
+# (DYLD_LIBRARY_PATH on macOS).
+
+TARGET_DIR=${CARGO_TARGET_DIR:-target}
+OUT_DIR=$TARGET_DIR/static_link
+
+echo "Building the static library..."
+cargo build --release --lib
+
+# System libraries the static library needs, as reported by rustc
+NATIVE_LIBS=$(cargo rustc --release --lib --crate-type staticlib -- --print native-static-libs 2>&1 \
+    | sed -n 's/.*native-static-libs: //p' | tail -n 1)
+echo "Native libraries: $NATIVE_LIBS"
+
+mkdir -p "$OUT_DIR"
+cc -O2 -Iinclude examples/c/roundtrip.c "$TARGET_DIR/release/librq_library.a" $NATIVE_LIBS -o "$OUT_DIR/roundtrip"
+
+echo "Checking the dynamic dependencies..."
+if [ "$(uname -s)" = "Darwin" ]; then
+    DEPENDENCIES=$(otool -L "$OUT_DIR/roundtrip")
+else
+    DEPENDENCIES=$(ldd "$OUT_DIR/roundtrip" || true)
+fi
+echo "$DEPENDENCIES"
+if echo "$DEPENDENCIES" | grep -q "rq_library"; then
+    echo "Error: the program depends on the shared RQ Library"
+    exit 1
+fi
+
+echo "Running without LD_LIBRARY_PATH..."
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+env -u LD_LIBRARY_PATH -u DYLD_LIBRARY_PATH "$OUT_DIR/roundtrip" "$WORK_DIR"
+
+echo "Static linking check passed."
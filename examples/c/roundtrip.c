@@ -0,0 +1,104 @@
+/*
+ * Encodes a file and decodes it back through the C API.
+ *
+ * Used by check_static_link.sh to verify a program linked with the static
+ * library runs without librq_library.so:
+ *
+ *     roundtrip <work_dir>
+ */
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+#include "rq-library.h"
+
+#define DATA_LEN 100000
+
+static int write_file(const char *path, const unsigned char *data, size_t len) {
+    FILE *file = fopen(path, "wb");
+    if (file == NULL) {
+        return -1;
+    }
+    size_t written = fwrite(data, 1, len, file);
+    fclose(file);
+    return written == len ? 0 : -1;
+}
+
+static long read_file(const char *path, unsigned char *data, size_t len) {
+    FILE *file = fopen(path, "rb");
+    if (file == NULL) {
+        return -1;
+    }
+    size_t read = fread(data, 1, len, file);
+    fclose(file);
+    return (long)read;
+}
+
+int main(int argc, char **argv) {
+    if (argc != 2) {
+        fprintf(stderr, "usage: %s <work_dir>\n", argv[0]);
+        return 2;
+    }
+
+    char input_path[4096], symbols_dir[4096], layout_path[4096], output_path[4096];
+    snprintf(input_path, sizeof(input_path), "%s/input.bin", argv[1]);
+    snprintf(symbols_dir, sizeof(symbols_dir), "%s/symbols", argv[1]);
+    snprintf(layout_path, sizeof(layout_path), "%s/symbols/_raptorq_layout.json", argv[1]);
+    snprintf(output_path, sizeof(output_path), "%s/output.bin", argv[1]);
+
+    static unsigned char data[DATA_LEN], decoded[DATA_LEN + 1];
+    for (size_t i = 0; i < DATA_LEN; i++) {
+        data[i] = (unsigned char)(i * 31 % 251);
+    }
+    if (write_file(input_path, data, DATA_LEN) != 0) {
+        fprintf(stderr, "failed to write %s\n", input_path);
+        return 1;
+    }
+
+    char abi_error[256];
+    if (raptorq_check_abi(RAPTORQ_ABI_VERSION, abi_error, sizeof(abi_error)) != 0) {
+        fprintf(stderr, "%s\n", abi_error);
+        return 1;
+    }
+
+    char version[64];
+    if (raptorq_version(version, sizeof(version)) != 0) {
+        fprintf(stderr, "raptorq_version failed\n");
+        return 1;
+    }
+
+    char self_test_error[256];
+    if (raptorq_self_test(self_test_error, sizeof(self_test_error)) != 0) {
+        fprintf(stderr, "raptorq_self_test failed: %s\n", self_test_error);
+        return 1;
+    }
+
+    uintptr_t session = raptorq_init_session(1024, 12, 1024, 4);
+    if (session == 0) {
+        fprintf(stderr, "raptorq_init_session failed\n");
+        return 1;
+    }
+
+    static char result[1 << 20];
+    int32_t code = raptorq_encode_file(session, input_path, symbols_dir, 0, result, sizeof(result));
+    if (code != 0) {
+        fprintf(stderr, "raptorq_encode_file returned %d\n", code);
+        return 1;
+    }
+
+    code = raptorq_decode_symbols(session, symbols_dir, output_path, layout_path);
+    if (code != 0) {
+        fprintf(stderr, "raptorq_decode_symbols returned %d\n", code);
+        return 1;
+    }
+    raptorq_free_session(session);
+
+    long len = read_file(output_path, decoded, sizeof(decoded));
+    if (len != DATA_LEN || memcmp(data, decoded, DATA_LEN) != 0) {
+        fprintf(stderr, "decoded data differs from the input\n");
+        return 1;
+    }
+
+    printf("%s: encoded and decoded %d bytes\n", version, DATA_LEN);
+    return 0;
+}
@@ -0,0 +1,77 @@
+//! Decodes symbol files made of arbitrary bytes with a valid layout
+//!
+//! The decode may fail, but must return an error instead of panicking. Each symbol of
+//! the layout is either its genuine content or bytes of the input, as told by a control
+//! byte of the input, so the fuzzer explores decodes mixing valid and malformed files.
+//!
+//! Run with `cargo +nightly fuzz run decode_symbols` from the repository root.
+
+#![no_main]
+
+use libfuzzer_sys::fuzz_target;
+use rq_library::{symbol_id, ProcessorConfig, RaptorQLayout, RaptorQProcessor};
+use std::collections::HashMap;
+use std::sync::OnceLock;
+use tempfile::TempDir;
+
+// An encoded object with its layout file, shared by all the runs
+struct Object {
+    processor: RaptorQProcessor,
+    layout: RaptorQLayout,
+    layout_path: String,
+    symbols: HashMap<String, Vec<u8>>,
+    _dir: TempDir,
+}
+
+fn object() -> &'static Object {
+    static OBJECT: OnceLock<Object> = OnceLock::new();
+    OBJECT.get_or_init(|| {
+        let processor = RaptorQProcessor::new(ProcessorConfig { symbol_size: 64, ..ProcessorConfig::default() });
+        let data: Vec<u8> = (0..2000).map(|i| (i * 31 / 7 % 251) as u8).collect();
+        let (result, symbols) = processor.encode_bytes(&data, 0).expect("Failed to encode the object");
+        let layout_content = result.layout_content.expect("The layout is returned");
+
+        let dir = tempfile::tempdir().expect("Failed to create the temp directory");
+        let layout_path = dir.path().join("layout.json");
+        std::fs::write(&layout_path, &layout_content).expect("Failed to write the layout");
+        Object {
+            processor,
+            layout: RaptorQLayout::parse(layout_content.as_bytes()).expect("The layout is valid"),
+            layout_path: layout_path.to_string_lossy().to_string(),
+            symbols: symbols.into_iter().map(|symbol| (symbol_id(&symbol), symbol)).collect(),
+            _dir: dir,
+        }
+    })
+}
+
+fuzz_target!(|data: &[u8]| {
+    let object = object();
+    let dir = tempfile::tempdir().expect("Failed to create the temp directory");
+    let symbols_dir = dir.path().join("symbols");
+    std::fs::create_dir(&symbols_dir).expect("Failed to create the symbols directory");
+
+    // For each symbol, an odd control byte keeps it, an even one writes the next
+    // `control / 2` bytes of the input instead, and the input running out leaves it missing
+    let mut rest = data;
+    for id in &object.layout.blocks[0].symbols {
+        let Some((&control, tail)) = rest.split_first() else { break };
+        rest = tail;
+        let content = if control & 1 == 1 {
+            object.symbols[id].as_slice()
+        } else {
+            let (content, tail) = rest.split_at((control as usize / 2).min(rest.len()));
+            rest = tail;
+            content
+        };
+        std::fs::write(symbols_dir.join(id), content).expect("Failed to write a symbol");
+    }
+    // The remaining bytes end up in a file that isn't named like a symbol
+    std::fs::write(symbols_dir.join("not a symbol"), rest).expect("Failed to write a file");
+
+    let output_path = dir.path().join("output.bin");
+    let _ = object.processor.decode_symbols(
+        &symbols_dir.to_string_lossy(),
+        &output_path.to_string_lossy(),
+        &object.layout_path,
+    );
+});
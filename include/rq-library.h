@@ -1,148 +1,3103 @@
 /* RQ Library - C API
  * Generated with cbindgen
+ *
+ * Paths are UTF-8 strings on every platform. On Windows they are converted to
+ * UTF-16 for the system calls, so paths with any Unicode character work.
+ */
+
+
+#ifndef RQ_LIB_H
+#define RQ_LIB_H
+
+/* Generated with cbindgen:0.28.0 */
+
+#include <stdint.h>
+#include <stdbool.h>
+
+#ifdef __cplusplus
+namespace RQLibrary {
+#endif  // __cplusplus
+
+/**
+ * Success
+ */
+#define RAPTORQ_OK 0
+
+/**
+ * Generic error
+ */
+#define RAPTORQ_ERR_GENERIC -1
+
+/**
+ * Invalid parameters (NULL pointer, invalid UTF-8 string, ...)
+ */
+#define RAPTORQ_ERR_INVALID_PARAMS -2
+
+/**
+ * The result could not be serialized
+ */
+#define RAPTORQ_ERR_INVALID_RESPONSE -3
+
+/**
+ * The result buffer is too small for the result
+ */
+#define RAPTORQ_ERR_BUFFER_TOO_SMALL -4
+
+/**
+ * The session doesn't exist
+ */
+#define RAPTORQ_ERR_INVALID_SESSION -5
+
+/**
+ * The library has another C interface than the program expects, see raptorq_check_abi
+ */
+#define RAPTORQ_ERR_ABI_MISMATCH -6
+
+/**
+ * The session was created by a previous load of the library, see raptorq_session_status
+ */
+#define RAPTORQ_ERR_SESSION_INVALIDATED -7
+
+/**
+ * IO error
+ */
+#define RAPTORQ_ERR_IO -11
+
+/**
+ * File not found
+ */
+#define RAPTORQ_ERR_FILE_NOT_FOUND -12
+
+/**
+ * Invalid path
+ */
+#define RAPTORQ_ERR_INVALID_PATH -13
+
+/**
+ * Encoding failed
+ */
+#define RAPTORQ_ERR_ENCODING_FAILED -14
+
+/**
+ * Decoding failed
+ */
+#define RAPTORQ_ERR_DECODING_FAILED -15
+
+/**
+ * Memory limit exceeded
+ */
+#define RAPTORQ_ERR_MEMORY_LIMIT_EXCEEDED -16
+
+/**
+ * Concurrency limit reached
+ */
+#define RAPTORQ_ERR_CONCURRENCY_LIMIT_REACHED -17
+
+/**
+ * Not enough symbols to decode some blocks
+ */
+#define RAPTORQ_ERR_INSUFFICIENT_SYMBOLS -18
+
+/**
+ * The operation was cancelled with raptorq_cancel
+ */
+#define RAPTORQ_ERR_CANCELLED -19
+
+/**
+ * The symbol is not stored and can't be generated
+ */
+#define RAPTORQ_ERR_SYMBOL_NOT_FOUND -20
+
+/**
+ * The operation ran over the timeout set with raptorq_set_timeout
+ */
+#define RAPTORQ_ERR_TIMED_OUT -21
+
+/**
+ * The symbols directory holds the symbols of another object than the layout
+ */
+#define RAPTORQ_ERR_OBJECT_MISMATCH -22
+
+/**
+ * The layout is of a newer version than the library supports, see raptorq_migrate_layout
+ */
+#define RAPTORQ_ERR_UNSUPPORTED_LAYOUT_VERSION -23
+
+/**
+ * Version of the C interface of the library, raised on every change of the functions,
+ * their arguments or their results that breaks the programs built against an older one
+ */
+#define RAPTORQ_ABI_VERSION 1
+
+/**
+ * Level of the start of an operation, passed to a log callback
+ */
+#define RAPTORQ_LOG_DEBUG 1
+
+/**
+ * Level of the end of an operation, passed to a log callback
+ */
+#define RAPTORQ_LOG_INFO 2
+
+/**
+ * Symbol files written as they are, see raptorq_set_symbol_codec
+ */
+#define RAPTORQ_CODEC_NONE 0
+
+/**
+ * Symbol files compressed with gzip
+ */
+#define RAPTORQ_CODEC_GZIP 1
+
+/**
+ * Symbol files compressed with zstd
+ */
+#define RAPTORQ_CODEC_ZSTD 2
+
+/**
+ * Source not hashed by the encodes, see raptorq_set_content_hash
+ */
+#define RAPTORQ_HASH_NONE 0
+
+/**
+ * Source hashed with SHA-256, recorded in the layouts
+ */
+#define RAPTORQ_HASH_SHA256 1
+
+/**
+ * Source hashed with BLAKE3
+ */
+#define RAPTORQ_HASH_BLAKE3 2
+
+/**
+ * Layout files written as JSON, see raptorq_set_layout_format
+ */
+#define RAPTORQ_LAYOUT_JSON 0
+
+/**
+ * Layout files written in the compact binary format
+ */
+#define RAPTORQ_LAYOUT_BINARY 1
+
+/**
+ * Largest number of repair symbols per block, so the ESIs of the symbols of any
+ * block fit the 24 bits of the payload ID
+ */
+#define MAX_REPAIR_SYMBOLS_PER_BLOCK (MAX_ENCODING_SYMBOL_ID + 1 - MAX_SOURCE_SYMBOLS)
+
+/**
+ * Version of the layout format written by this library, see `RaptorQLayout::layout_version`
+ */
+#define LAYOUT_VERSION 1
+
+/**
+ * Default symbol size in bytes.
+ * Largest value allowed by RFC 6330, where the symbol size is a 16-bit field:
+ * large symbols keep the number of symbols and the per-symbol overhead low.
+ * The encoder aligns it down to a multiple of 8, so symbols are 65528 bytes.
+ */
+#define DEFAULT_SYMBOL_SIZE_B 65535
+
+/**
+ * Symbols needed on top of the source symbols for a decode to succeed with high
+ * probability, with K + 2 symbols RaptorQ fails less than once in a million.
+ */
+#define DECODE_SYMBOL_OVERHEAD 2
+
+/**
+ * Number of standard deviations of margin used by `get_recommended_redundancy`,
+ * the probability of falling below the mean by more is about one in a million.
+ */
+#define REDUNDANCY_Z_SCORE 4.75
+
+/**
+ * Smallest symbol size accepted, the encoder aligns symbols to 8 bytes.
+ */
+#define MIN_SYMBOL_SIZE_B 8
+
+/**
+ * Default redundancy factor: about 4 times the source data is generated,
+ * so the data can be decoded with up to 3/4 of the symbols lost.
+ */
+#define DEFAULT_REDUNDANCY_FACTOR 4
+
+/**
+ * Default memory limit in MB (16 GB), files needing more are split into blocks.
+ */
+#define DEFAULT_MAX_MEMORY_MB (16 * 1024)
+
+/**
+ * Default number of operations that can run at the same time on a processor.
+ */
+#define DEFAULT_CONCURRENCY_LIMIT 4
+
+/**
+ * Memory limit presets in MB, for the `max_memory_mb` configuration.
+ * Files smaller than limit / 1.5 are encoded as a single block.
+ */
+#define MAX_MEMORY_MB_1GB 1024
+
+#define MAX_MEMORY_MB_2GB (2 * 1024)
+
+/**
+ * Enough to encode files up to about 2.7 GB as a single block,
+ * a good fit for most hosts.
+ */
+#define MAX_MEMORY_MB_4GB (4 * 1024)
+
+#define MAX_MEMORY_MB_8GB (8 * 1024)
+
+#define MAX_MEMORY_MB_16GB (16 * 1024)
+
+/**
+ * Longest path accepted in bytes, PATH_MAX of Linux without the terminating NUL.
+ * The symbols of an encode are written in a block directory and a symbol id below the
+ * output directory, which should leave about 64 bytes for them.
+ */
+#define MAX_PATH_LEN_B 4095
+
+/**
+ * Longest file or directory name accepted in bytes, NAME_MAX of most file systems.
+ */
+#define MAX_PATH_COMPONENT_LEN_B 255
+
+/**
+ * Smallest block size `get_recommended_block_size_for` splits a file into to encode
+ * its blocks in parallel, below it the cost per block outweighs the parallelism.
+ */
+#define MIN_PARALLEL_BLOCK_SIZE_B (4 * 1024 * 1024)
+
+/**
+ * Callback receiving an operation event of a session as a JSON object
+ *
+ * `level` is RAPTORQ_LOG_DEBUG or RAPTORQ_LOG_INFO. `event_json` is a null
+ * terminated string only valid during the call.
+ */
+typedef void (*RaptorQLogCallback)(void *context, int32_t level, const char *event_json);
+
+/**
+ * Callback selecting the symbols to write, from their block id, encoding symbol ID
+ * and whether they are repair symbols
+ *
+ * Returns true to write the symbol, false to skip it.
+ */
+typedef bool (*RaptorQSymbolFilterCallback)(void *context, uintptr_t block_id, uint32_t esi, bool is_repair);
+
+/**
+ * Callback receiving the symbols of an encode, with their block id, encoding symbol
+ * ID and whether they are repair symbols
+ *
+ * The buffer holds the serialized packet and is only valid during the call.
+ * Returns 0 to continue, any other value stops the encode.
+ */
+typedef int32_t (*RaptorQEncodedSymbolCallback)(void *context, uintptr_t block_id, uint32_t esi, bool is_repair, const uint8_t *buffer, uintptr_t buffer_len);
+
+/**
+ * Callback reading the next bytes of a stream into `buffer`
+ *
+ * Returns the number of bytes written into the buffer (at most `buffer_len`),
+ * 0 at the end of the stream, or a negative value on read error.
+ */
+typedef intptr_t (*RaptorQReadCallback)(void *context, uint8_t *buffer, uintptr_t buffer_len);
+
+/**
+ * Callback reading bytes at an offset of the data to encode into `buffer`,
+ * returning the number of bytes read, 0 at the end of the data, or a negative
+ * value on error
+ */
+typedef intptr_t (*RaptorQReadAtCallback)(void *context, uint64_t offset, uint8_t *buffer, uintptr_t buffer_len);
+
+/**
+ * Callback receiving a problem found by raptorq_scrub_symbols as a JSON object
+ *
+ * `issue_json` is a null terminated string only valid during the call.
+ */
+typedef void (*RaptorQIssueCallback)(void *context, const char *issue_json);
+
+/**
+ * Callback writing the bytes of `buffer` to a stream
+ *
+ * Returns the number of bytes consumed (at most `buffer_len`),
+ * or a negative value on write error.
+ */
+typedef intptr_t (*RaptorQWriteCallback)(void *context, const uint8_t *buffer, uintptr_t buffer_len);
+
+/**
+ * Callback receiving the progress of a decode, the bytes written so far and the
+ * size of the original data
+ */
+typedef void (*RaptorQProgressCallback)(void *context, uint64_t bytes_written, uint64_t bytes_total);
+
+/**
+ * Callback writing the bytes of `buffer` at an offset of the destination
+ *
+ * Returns the number of bytes written (at most `buffer_len`),
+ * or a negative value on write error.
+ */
+typedef intptr_t (*RaptorQWriteAtCallback)(void *context, uint64_t offset, const uint8_t *buffer, uintptr_t buffer_len);
+
+/**
+ * Callback giving the next symbol to decode
+ *
+ * Writes the symbol into `buffer` and the id of its block into `block_id`.
+ * `buffer_len` is large enough for any symbol. Returns the length of the symbol,
+ * 0 once there are no more symbols, or a negative value on error.
+ */
+typedef intptr_t (*RaptorQSymbolCallback)(void *context, uintptr_t *block_id, uint8_t *buffer, uintptr_t buffer_len);
+
+/**
+ * Callback reading a whole file of a store into `buffer`
+ *
+ * `path` is relative to the store, slash separated. Returns the size of the file and
+ * copies it into `buffer` if `buffer_len` is large enough; otherwise the callback is
+ * called again with a buffer of that size. Returns -1 if there is no file at `path`,
+ * or another negative value on error.
+ */
+typedef intptr_t (*RaptorQFileCallback)(void *context, const char *path, uint8_t *buffer, uintptr_t buffer_len);
+
+#ifdef __cplusplus
+extern "C" {
+#endif // __cplusplus
+
+/**
+ * Initializes a RaptorQ session with the given configuration
+ * Returns a session ID on success, or 0 on failure
+ *
+ * A session can be used from several threads at once: up to `concurrency_limit`
+ * operations run at the same time, the ones started beyond return -17.
+ *
+ * The configuration is rejected if raptorq_validate_config would fail on it.
+ * raptorq_check_abi tells whether the library matches the header beforehand.
+ */
+uintptr_t raptorq_init_session(uint16_t symbol_size,
+                               uint8_t redundancy_factor,
+                               uint64_t max_memory_mb,
+                               uint64_t concurrency_limit);
+
+/**
+ * Creates a new session with the same configuration and options as an existing one
+ *
+ * Every option set on the original is copied: the symbol key, codec and CRC, the layout
+ * format and metadata, the repair symbols per block, the content hash, the timeout,
+ * the temp directory, the log callback and the other encoding options. Metrics are
+ * enabled on the clone if they are on the original, starting from zero.
+ *
+ * The new session is fully independent: it doesn't share errors, cancellation,
+ * metrics or the concurrency limit with the original, and must be freed separately
+ * with raptorq_free_session. Options set on either session afterwards don't affect
+ * the other.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ *
+ * Returns:
+ * * The ID of the new session, or 0 if the session doesn't exist
+ */
+uintptr_t raptorq_clone_session(uintptr_t session_id);
+
+/**
+ * Validates a session configuration without creating a session
+ *
+ * Arguments:
+ * * `symbol_size` - Symbol size in bytes, at least 8
+ * * `redundancy_factor` - Redundancy factor, at least 1
+ * * `max_memory_mb` - Memory limit in MB, at least 1
+ * * `concurrency_limit` - Maximum concurrent operations, at least 1
+ * * `error_buffer` - Optional buffer to store the reason the configuration is invalid
+ * * `error_buffer_len` - Length of the error buffer
+ *
+ * Returns:
+ * *   0 if the configuration is valid
+ * *  -2 if the configuration is invalid
+ */
+int32_t raptorq_validate_config(uint16_t symbol_size,
+                                uint8_t redundancy_factor,
+                                uint64_t max_memory_mb,
+                                uint64_t concurrency_limit,
+                                char *error_buffer,
+                                uintptr_t error_buffer_len);
+
+/**
+ * Checks that the library has the C interface the program was built for
+ *
+ * To be called before creating the first session with the RAPTORQ_ABI_VERSION of the
+ * header the program was built with: a library with another interface may misread the
+ * arguments of its functions instead of failing.
+ *
+ * Arguments:
+ * * `expected_abi_version` - ABI version the program was built for
+ * * `error_buffer` - Optional buffer to store the reason of a mismatch
+ * * `error_buffer_len` - Length of the error buffer
+ *
+ * Returns:
+ * *   0 if the library has the expected ABI version
+ * *  -6 if it has another one
+ */
+int32_t raptorq_check_abi(uint32_t expected_abi_version,
+                          char *error_buffer,
+                          uintptr_t error_buffer_len);
+
+/**
+ * Frees a RaptorQ session
+ *
+ * Returns false both if the session doesn't exist and if freeing it failed,
+ * raptorq_close_session tells them apart.
+ */
+bool raptorq_free_session(uintptr_t session_id);
+
+/**
+ * Frees a RaptorQ session, succeeding if it was already freed
+ *
+ * Closing a session twice is harmless, so a wrapper can close it both explicitly
+ * and when it is garbage collected. Operations in progress on the session finish
+ * normally, the session can't be used afterwards.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ *
+ * Returns:
+ * *   0 if the session was freed, by this call or an earlier one
+ * *  -1 if freeing the session failed
+ * *  -5 if no session was ever created with this ID
+ * *  -7 if the session was created by a previous load of the library
+ */
+int32_t raptorq_close_session(uintptr_t session_id);
+
+/**
+ * Gets the status of a session ID
+ *
+ * Tells apart the sessions lost to a reload of the library (-7) from the ones never
+ * created or already freed (-5), as the functions taking a session do when it
+ * doesn't exist. After a reload, e.g. by a plugin host, the sessions of the previous
+ * load are gone: recreate them with raptorq_init_session and the same configuration,
+ * then set their options again, and retry the operations that failed.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ *
+ * Returns:
+ * *   0 if the session exists
+ * *  -5 if the session was never created or is freed
+ * *  -7 if the session was created by a previous load of the library
+ */
+int32_t raptorq_session_status(uintptr_t session_id);
+
+/**
+ * Number of sessions created and not freed yet
+ *
+ * Lets tests and long running programs check that every session they create is
+ * freed: sessions are only freed by raptorq_free_session or raptorq_close_session.
+ */
+uintptr_t raptorq_active_session_count(void);
+
+/**
+ * Creates a pool of sessions for services encoding and decoding in parallel
+ *
+ * The pool creates `size` sessions of the configuration up front and grows on demand
+ * up to `max_size`, so requests don't pay for creating a session. A session acquired
+ * with raptorq_pool_acquire is used by one thread at a time, its last error and
+ * shortfalls come from the operations of that thread. raptorq_pool_release returns it
+ * to the pool, reset and without the options set on it.
+ *
+ * Arguments:
+ * * `symbol_size` - Symbol size in bytes, at least 8
+ * * `redundancy_factor` - Redundancy factor, at least 1
+ * * `max_memory_mb` - Memory limit of each session in MB, at least 1
+ * * `concurrency_limit` - Maximum concurrent operations of each session, at least 1
+ * * `size` - Number of sessions created up front
+ * * `max_size` - Maximum number of sessions of the pool, at least 1
+ *
+ * Returns:
+ * * The ID of the pool, or 0 if the configuration is invalid or `size` is larger
+ *   than `max_size`
+ */
+uintptr_t raptorq_pool_create(uint16_t symbol_size,
+                              uint8_t redundancy_factor,
+                              uint64_t max_memory_mb,
+                              uint64_t concurrency_limit,
+                              uintptr_t size,
+                              uintptr_t max_size);
+
+/**
+ * Acquires a session from a pool
+ *
+ * Takes an idle session, or creates one if the pool can still grow. Otherwise the
+ * call waits until a session is released if `wait` is true, and returns -17 if not.
+ * The session works with every function taking a session, and is returned with
+ * raptorq_pool_release instead of being freed.
+ *
+ * Arguments:
+ * * `pool_id` - Pool ID returned from raptorq_pool_create
+ * * `wait` - Whether to wait for a session when none is available
+ * * `session_id` - Receives the ID of the session
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters
+ * *  -5 on invalid pool
+ * *  -7 if the pool was created by a previous load of the library
+ * * -17 if no session is available and `wait` is false
+ */
+int32_t raptorq_pool_acquire(uintptr_t pool_id, bool wait, uintptr_t *session_id);
+
+/**
+ * Returns a session acquired with raptorq_pool_acquire to its pool
+ *
+ * The session is reset and its options are dropped, the next thread acquiring it
+ * gets it as the pool created it, under a new ID. Its ID can't be used afterwards.
+ * A session freed with raptorq_free_session instead is replaced by a new one.
+ *
+ * Arguments:
+ * * `pool_id` - Pool ID returned from raptorq_pool_create
+ * * `session_id` - Session ID returned from raptorq_pool_acquire
+ *
+ * Returns:
+ * *   0 on success
+ * *  -5 on invalid pool, or a session not acquired from the pool
+ * *  -7 if the pool was created by a previous load of the library
+ * * -17 if operations or encode jobs are still in progress on the session
+ */
+int32_t raptorq_pool_release(uintptr_t pool_id, uintptr_t session_id);
+
+/**
+ * Frees a pool with all of its sessions
+ *
+ * The sessions acquired and not released are freed too, operations in progress on
+ * them finish normally. No call may be waiting in raptorq_pool_acquire on the pool.
+ *
+ * Returns false if the pool doesn't exist.
+ */
+bool raptorq_pool_free(uintptr_t pool_id);
+
+/**
+ * Cancels the operations in progress on a session
+ *
+ * They stop before processing their next block and return -19.
+ * Operations started after this call are not affected.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ *
+ * Returns:
+ * *   0 on success
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ */
+int32_t raptorq_cancel(uintptr_t session_id);
+
+/**
+ * Sets the wall-clock limit of each operation started afterwards on a session
+ *
+ * An operation over its limit stops like a cancelled one, before processing its
+ * next block, and returns -21. The output written before is kept: an encode leaves
+ * the symbols of the blocks done but no layout file, unless raptorq_encode_file
+ * removes them (see raptorq_set_cleanup_on_error), and a decode leaves a partial
+ * output file, which should be removed or written again.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `timeout_ms` - Limit in milliseconds, 0 for no limit (the default)
+ *
+ * Returns:
+ * *   0 on success
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ */
+int32_t raptorq_set_timeout(uintptr_t session_id, uint64_t timeout_ms);
+
+/**
+ * Sets the number of repair symbols generated for every block by the encodes started
+ * afterwards on a session, instead of deriving it from the redundancy factor
+ *
+ * The number applies to every block, the last and smaller one included, and is
+ * reflected in the layout and the counts of the result.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `repair_symbols` - Repair symbols per block, 0 to derive them from the redundancy
+ *   factor again (the default)
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 if `repair_symbols` exceeds the limit of the encoding symbol IDs
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ */
+int32_t raptorq_set_repair_symbols_per_block(uintptr_t session_id, uint32_t repair_symbols);
+
+/**
+ * Sets whether raptorq_encode_file removes the symbols it wrote when it fails
+ *
+ * On by default: the block directories of the blocks the encode started are removed
+ * whatever the failure, including a cancellation or a timeout, so no partial set of
+ * symbols is left behind. Off, they are kept without a layout file.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `enabled` - Whether to remove the symbols of a failed encode
+ *
+ * Returns:
+ * *   0 on success
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ */
+int32_t raptorq_set_cleanup_on_error(uintptr_t session_id, bool enabled);
+
+/**
+ * Sets whether the encodes of a session write all symbols directly in the output
+ * directory instead of a `block_<block_id>` directory per block
+ *
+ * Off by default. Symbol ids are hashes of the symbols, so the symbols of all blocks
+ * can share a directory. Decodes find the symbols in either layout, whatever this option.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `enabled` - Whether to write the symbols without block directories
+ *
+ * Returns:
+ * *   0 on success
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ */
+int32_t raptorq_set_flat_symbol_layout(uintptr_t session_id, bool enabled);
+
+/**
+ * Sets whether the encodes of a session fit the symbol size to each block
+ *
+ * Off by default. Each block keeps the number of source symbols of the session symbol
+ * size with the smallest symbols holding it, so a small tail block isn't padded to a
+ * whole symbol. The layout records the symbol size of each block, which decodes read
+ * whatever this option.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `enabled` - Whether to fit the symbol size to each block
+ *
+ * Returns:
+ * *   0 on success
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ */
+int32_t raptorq_set_auto_symbol_size(uintptr_t session_id, bool enabled);
+
+/**
+ * Sets whether the encodes of a session keep their block buffer for the next encode
+ *
+ * Off by default. With the pool, repeated encodes of objects of similar sizes reuse
+ * the buffers of the previous ones instead of allocating a buffer of the block size.
+ * Disabling it or resetting the session releases the buffers.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `enabled` - Whether to keep the block buffers between encodes
+ *
+ * Returns:
+ * *   0 on success
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ */
+int32_t raptorq_set_buffer_pool(uintptr_t session_id, bool enabled);
+
+/**
+ * Sets the compression of the symbol files written by the encodes of a session
+ *
+ * RAPTORQ_CODEC_NONE by default. Each symbol is compressed on its own and the codec is
+ * recorded in the layout, so decodes decompress the symbols of every layout whatever
+ * the codec of their session. Symbol ids are the hashes of the uncompressed symbols.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `codec` - RAPTORQ_CODEC_NONE, RAPTORQ_CODEC_GZIP or RAPTORQ_CODEC_ZSTD
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on an unknown codec
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ */
+int32_t raptorq_set_symbol_codec(uintptr_t session_id, uint32_t codec);
+
+/**
+ * Sets the hash of the source computed by the encodes of a session
+ *
+ * RAPTORQ_HASH_SHA256 by default. The source is hashed as it is read for encoding and
+ * the hash is returned in lowercase hex as `content_hash` in the result JSON of the
+ * encodes. Only SHA-256 is recorded in the layouts, as `object_sha256`, which checked
+ * decodes rely on; RAPTORQ_HASH_NONE saves the cost of hashing on large files.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `hash` - RAPTORQ_HASH_NONE, RAPTORQ_HASH_SHA256 or RAPTORQ_HASH_BLAKE3
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on an unknown hash
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ */
+int32_t raptorq_set_content_hash(uintptr_t session_id, uint32_t hash);
+
+/**
+ * Sets the format of the layout files written by a session
+ *
+ * RAPTORQ_LAYOUT_JSON by default. The binary format holds the same data in a smaller
+ * file that is faster to parse for objects with thousands of blocks, but JSON tools
+ * can't read it. The format of a layout file is detected when it is read,
+ * so every function taking a layout path reads both formats whatever the format of
+ * its session; raptorq_parse_layout describes a binary layout as JSON. The layouts
+ * returned in the result JSON of the encodes stay JSON.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `format` - RAPTORQ_LAYOUT_JSON or RAPTORQ_LAYOUT_BINARY
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on an unknown format
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ */
+int32_t raptorq_set_layout_format(uintptr_t session_id, uint32_t format);
+
+/**
+ * Sets the directory of the scratch files of the operations of a session
+ *
+ * The temp directory of the system by default. Decodes from symbols in any order
+ * (raptorq_decode_from_source, raptorq_decode_from_tar, raptorq_decode_from_files...)
+ * write the blocks decoded before the blocks preceding them to a scratch file once
+ * they take more than the memory limit of the session. Scratch files are removed when
+ * the operation ends.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `temp_dir` - Directory of the scratch files, created if needed, or NULL for the
+ *   temp directory of the system
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on an invalid path
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ */
+int32_t raptorq_set_temp_dir(uintptr_t session_id, const char *temp_dir);
+
+/**
+ * Sets the most symbols raptorq_decode_from_source pulls from its callback
+ *
+ * A block that doesn't decode gets more symbols until it does, so a callback that
+ * keeps returning undecodable symbols would be called forever. Once the limit is
+ * reached before every block is decoded, the decode fails with -18 as if the symbols
+ * had run out. Every symbol pulled counts, including the ones ignored.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `max_symbols` - Most symbols pulled by a decode, 0 to pull until the callback
+ *   returns 0 (the default)
+ *
+ * Returns:
+ * *   0 on success
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ */
+int32_t raptorq_set_max_source_symbols(uintptr_t session_id, uint64_t max_symbols);
+
+/**
+ * Sets the key encrypting the symbol files written by the encodes of a session, and
+ * decrypting the ones of the layouts encrypted with it
+ *
+ * Symbols are encrypted with AES-GCM after their compression, AES-128 for a key of 16
+ * bytes and AES-256 for 32 bytes, with a nonce derived from the key and the symbol and
+ * written at the start of the file. Tampered symbols fail authentication and are skipped.
+ * The layout records the id of the key, not the key, and stays in the clear. Decoding
+ * encrypted symbols without their key fails with -2. The key is copied.
+ *
+ * The symbol files are still named by the unkeyed BLAKE3 hashes of the plain symbols,
+ * which the layout lists too: anyone seeing them can confirm a guess of the content by
+ * encoding it with the same parameters. Encrypt the data before encoding it when
+ * this matters.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `key` - The key, may be NULL when `key_len` is 0
+ * * `key_len` - Length of the key, 0 writes the symbols in the clear
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on a NULL key or a key neither 16 nor 32 bytes long
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ */
+int32_t raptorq_set_symbol_key(uintptr_t session_id, const uint8_t *key, uintptr_t key_len);
+
+/**
+ * Sets whether the symbol files written by the encodes of a session start with a
+ * header holding their length and CRC32
+ *
+ * Off by default. Decodes skip the truncated or bit-flipped files like missing symbols,
+ * which the repair symbols cover. The layout records whether the files have headers, so
+ * decodes read them whatever this option.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `enabled` - Whether to write the headers
+ *
+ * Returns:
+ * *   0 on success
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ */
+int32_t raptorq_set_symbol_crc(uintptr_t session_id, bool enabled);
+
+/**
+ * Sets the metadata of the application recorded in the layouts written by a session
+ *
+ * The metadata is a JSON object of strings, e.g. `{"object_id":"3f2a","content_type":"application/json"}`,
+ * recorded in the `metadata` of the layouts as given and returned by raptorq_parse_layout;
+ * the library doesn't interpret it. `{}` records none, the default.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `metadata_json` - JSON object mapping the keys to their values
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters, including JSON that isn't an object of strings
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ */
+int32_t raptorq_set_layout_metadata(uintptr_t session_id, const char *metadata_json);
+
+/**
+ * Resets a session so it can be reused for a new operation
+ *
+ * Clears the last error and its code, shortfalls and corrupt symbols, and releases the
+ * block buffers kept by raptorq_set_buffer_pool, which is cheaper than freeing the
+ * session and creating a new one. The options of the session are kept. Operations in
+ * progress are not affected, call raptorq_cancel first to stop them.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ *
+ * Returns:
+ * *   0 on success
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ */
+int32_t raptorq_reset_session(uintptr_t session_id);
+
+/**
+ * Sets the callback receiving the events of the encode and decode operations of a session
+ *
+ * raptorq_encode_file and raptorq_decode_symbols report their start at debug level,
+ * then their end at info level with the object size, number of blocks and symbols,
+ * duration, or the error, e.g.
+ * `{"operation":"encode_file","stage":"completed","path":"in.bin","object_size":1024,
+ * "blocks":1,"symbols":12,"duration_ms":3}`. The callback is called from the thread
+ * running the operation. Sessions log nothing by default.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `log_callback` - Callback receiving the events, NULL to stop logging
+ * * `context` - Opaque pointer passed to every call of the callback
+ *
+ * Returns:
+ * *   0 on success
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ */
+int32_t raptorq_set_log_callback(uintptr_t session_id,
+                                 RaptorQLogCallback log_callback,
+                                 void *context);
+
+/**
+ * Encodes a file using RaptorQ - streaming implementation
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `input_path` - Path to the input file
+ * * `output_dir` - Directory where symbols will be written
+ * * `block_size` - Size of blocks to process at once (0 = auto)
+ * * `result_buffer` - Buffer to store the result (JSON metadata)
+ * * `result_buffer_len` - Length of the result buffer
+ *
+ * Returns:
+ * *   0 on success
+ * *  -1 on generic error
+ * *  -2 on invalid parameters
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size
+ * *  -4 on encoding failure
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error
+ * * -12 on File not found
+ * * -13 on Invalid path
+ * * -14 on Encoding failed
+ * * -16 on Memory limit exceeded
+ * * -17 on Concurrency limit reached
+ */
+int32_t raptorq_create_metadata(uintptr_t session_id,
+                                const char *input_path,
+                                const char *layout_file,
+                                uintptr_t block_size,
+                                char *result_buffer,
+                                uintptr_t result_buffer_len);
+
+/**
+ * Encodes a file using RaptorQ - streaming implementation
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `input_path` - Path to the input file
+ * * `output_dir` - Directory where symbols will be written
+ * * `block_size` - Size of blocks to process at once (0 = auto)
+ * * `result_buffer` - Buffer to store the result (JSON metadata)
+ * * `result_buffer_len` - Length of the result buffer
+ *
+ * The result grows with the number of blocks, use raptorq_encode_file_alloc when
+ * its size can't be bounded up front.
+ *
+ * Returns:
+ * *   0 on success
+ * *  -1 on generic error
+ * *  -2 on invalid parameters
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size, the symbols are written nonetheless
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error
+ * * -12 on File not found
+ * * -13 on Invalid Path
+ * * -14 on Encoding failed
+ * * -16 on Memory limit exceeded
+ * * -17 on Concurrency limit reached
+ */
+int32_t raptorq_encode_file(uintptr_t session_id,
+                            const char *input_path,
+                            const char *output_dir,
+                            uintptr_t block_size,
+                            char *result_buffer,
+                            uintptr_t result_buffer_len);
+
+/**
+ * Encodes a file using RaptorQ with symbols of the given size instead of the one of
+ * the session
+ *
+ * The symbol size is recorded in the layout, so the symbols are decoded by any session.
+ * The symbol size of the session is read with raptorq_get_config.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `input_path` - Path to the input file
+ * * `output_dir` - Directory where symbols will be written
+ * * `symbol_size` - Size of the symbols in bytes, at least 8
+ * * `block_size` - Size of blocks to process at once (0 = auto)
+ * * `result_buffer` - Buffer to store the result (JSON metadata)
+ * * `result_buffer_len` - Length of the result buffer
+ *
+ * Returns:
+ * *   0 on success
+ * *  -1 on generic error
+ * *  -2 on invalid parameters, including a symbol size below 8 or a block too large for it
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size, the symbols are written nonetheless
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error
+ * * -12 on File not found
+ * * -13 on Invalid Path
+ * * -14 on Encoding failed
+ * * -16 on Memory limit exceeded
+ * * -17 on Concurrency limit reached
+ */
+int32_t raptorq_encode_file_with_symbol_size(uintptr_t session_id,
+                                             const char *input_path,
+                                             const char *output_dir,
+                                             uint16_t symbol_size,
+                                             uintptr_t block_size,
+                                             char *result_buffer,
+                                             uintptr_t result_buffer_len);
+
+/**
+ * Encodes a file using RaptorQ, writing only the symbols selected by a callback
+ *
+ * Symbols for which `keep_callback` returns false are not written, so a node can store
+ * its share of the symbols of an object. The layout still lists all symbols, while the
+ * symbol counts of the result only include the written ones. The callback is called
+ * from the calling thread only.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `input_path` - Path to the input file
+ * * `output_dir` - Directory where the kept symbols will be written
+ * * `block_size` - Size of blocks to process at once (0 = auto)
+ * * `keep_callback` - Callback selecting the symbols to write
+ * * `context` - Opaque pointer passed to every call of the callback
+ * * `result_buffer` - Buffer to store the result (JSON metadata)
+ * * `result_buffer_len` - Length of the result buffer
+ *
+ * Returns:
+ * *   0 on success
+ * *  -1 on generic error
+ * *  -2 on invalid parameters
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size, the symbols are written nonetheless
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error
+ * * -12 on File not found
+ * * -13 on Invalid Path
+ * * -14 on Encoding failed
+ * * -16 on Memory limit exceeded
+ * * -17 on Concurrency limit reached
+ */
+int32_t raptorq_encode_file_with_filter(uintptr_t session_id,
+                                        const char *input_path,
+                                        const char *output_dir,
+                                        uintptr_t block_size,
+                                        RaptorQSymbolFilterCallback keep_callback,
+                                        void *context,
+                                        char *result_buffer,
+                                        uintptr_t result_buffer_len);
+
+/**
+ * Encodes a file using RaptorQ, passing each symbol to a callback instead of writing it
+ *
+ * Symbols are passed block after block in the order of the layout, the source symbols
+ * of a block before its repair symbols are generated. They are not compressed nor
+ * encrypted and can be decoded with raptorq_decode_from_source. The callback is
+ * called from the calling thread only, while the next symbols are encoded.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `input_path` - Path to the input file
+ * * `block_size` - Size of blocks to process at once (0 = auto)
+ * * `symbol_callback` - Callback receiving the symbols
+ * * `context` - Opaque pointer passed to every call of the callback
+ * * `result_buffer` - Buffer to store the result (JSON metadata with the layout)
+ * * `result_buffer_len` - Length of the result buffer
+ *
+ * Returns:
+ * *   0 on success
+ * *  -1 on generic error
+ * *  -2 on invalid parameters
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error
+ * * -12 on File not found
+ * * -13 on Invalid Path
+ * * -14 on Encoding failed
+ * * -16 on Memory limit exceeded
+ * * -17 on Concurrency limit reached
+ * * -19 on Cancelled, also when the callback stops the encode
+ */
+int32_t raptorq_encode_to_callback(uintptr_t session_id,
+                                   const char *input_path,
+                                   uintptr_t block_size,
+                                   RaptorQEncodedSymbolCallback symbol_callback,
+                                   void *context,
+                                   char *result_buffer,
+                                   uintptr_t result_buffer_len);
+
+/**
+ * Encodes a file using RaptorQ, returning the result in a buffer allocated by the library
+ *
+ * Same as raptorq_encode_file, but the result has no size limit: the JSON metadata
+ * grows with the number of blocks and may not fit a fixed buffer, and encoding
+ * again with a larger one would write all symbols again.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `input_path` - Path to the input file
+ * * `output_dir` - Directory where symbols will be written
+ * * `block_size` - Size of blocks to process at once (0 = auto)
+ * * `result_buffer` - Receives the null terminated result (JSON metadata), to be
+ *   released with raptorq_free_buffer; NULL on error
+ * * `result_buffer_len` - Receives the length of the result, terminating null included
+ *
+ * Returns:
+ * *   0 on success
+ * *  -1 on generic error
+ * *  -2 on invalid parameters
+ * *  -3 on invalid response
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error
+ * * -12 on File not found
+ * * -13 on Invalid Path
+ * * -14 on Encoding failed
+ * * -16 on Memory limit exceeded
+ * * -17 on Concurrency limit reached
+ */
+int32_t raptorq_encode_file_alloc(uintptr_t session_id,
+                                  const char *input_path,
+                                  const char *output_dir,
+                                  uintptr_t block_size,
+                                  uint8_t **result_buffer,
+                                  uintptr_t *result_buffer_len);
+
+/**
+ * Resumes an interrupted raptorq_encode_file, encoding only the blocks that are not
+ * completely written yet
+ *
+ * The blocks whose symbols were all written by the interrupted encode are kept if the
+ * file still has the same data, the others are encoded. The result is the one
+ * raptorq_encode_file would give. A failed encode leaves nothing to resume unless
+ * raptorq_set_cleanup_on_error disabled the cleanup.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `input_path` - Path to the input file
+ * * `output_dir` - Directory of the interrupted encode
+ * * `block_size` - Block size of the interrupted encode (0 = auto)
+ * * `result_buffer` - Buffer to store the result (JSON metadata)
+ * * `result_buffer_len` - Length of the result buffer
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error
+ * * -12 on File not found
+ * * -13 on Invalid Path
+ * * -14 on Encoding failed
+ * * -16 on Memory limit exceeded
+ * * -17 on Concurrency limit reached
+ * * -19 on Cancelled
+ */
+int32_t raptorq_resume_encode(uintptr_t session_id,
+                              const char *input_path,
+                              const char *output_dir,
+                              uintptr_t block_size,
+                              char *result_buffer,
+                              uintptr_t result_buffer_len);
+
+/**
+ * Encodes the data appended to an encoded object as new blocks, keeping its blocks
+ *
+ * The input is the object as it is now, starting with the object of the layout: the
+ * bytes after it are encoded as new blocks with the symbol size and symbol format of
+ * the layout, the existing blocks are only read to check that their data is unchanged.
+ * The new symbols and the layout listing all blocks, with the metadata of the layout,
+ * are written to `output_dir`, usually the symbols directory of the layout. The result is the JSON metadata of the
+ * whole object, as raptorq_encode_file gives.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `layout_path` - Path to the layout file of the object encoded so far
+ * * `input_path` - Path to the object with the appended data
+ * * `output_dir` - Directory where the new symbols and the layout will be written
+ * * `block_size` - Size of the new blocks (0 = auto)
+ * * `result_buffer` - Buffer to store the result (JSON metadata)
+ * * `result_buffer_len` - Length of the result buffer
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters, including an input that is not the object of the
+ *       layout with data appended
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error
+ * * -12 on File not found
+ * * -13 on Invalid Path
+ * * -14 on Encoding failed
+ * * -15 on Decoding failed (malformed layout or wrong symbol key)
+ * * -16 on Memory limit exceeded
+ * * -17 on Concurrency limit reached
+ * * -19 on Cancelled
+ */
+int32_t raptorq_append_encode(uintptr_t session_id,
+                              const char *layout_path,
+                              const char *input_path,
+                              const char *output_dir,
+                              uintptr_t block_size,
+                              char *result_buffer,
+                              uintptr_t result_buffer_len);
+
+/**
+ * Encodes again a single block of a file already encoded, writing only its symbols
+ *
+ * The block is read from the byte range given by the layout and encoded with its
+ * encoder parameters, so the symbols are the ones listed in the layout. This
+ * regenerates the symbols of a damaged block without encoding the whole file.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `input_path` - Path to the original file
+ * * `output_dir` - Directory where the `block_<block_id>` directory will be written
+ * * `layout_path` - Path to the layout file of the file
+ * * `block_id` - Identifier of the block in the layout
+ * * `result_buffer` - Buffer to store the result (JSON metadata)
+ * * `result_buffer_len` - Length of the result buffer
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error
+ * * -12 on File not found
+ * * -14 if the block is not in the layout or the file doesn't match it
+ * * -15 if the layout is invalid
+ * * -17 on Concurrency limit reached
+ */
+int32_t raptorq_encode_block(uintptr_t session_id,
+                             const char *input_path,
+                             const char *output_dir,
+                             const char *layout_path,
+                             uintptr_t block_id,
+                             char *result_buffer,
+                             uintptr_t result_buffer_len);
+
+/**
+ * Generates new repair symbols for a block of a file already encoded and adds them to the layout
+ *
+ * The new symbols continue the encoding symbol IDs after the symbols of the layout,
+ * so they never collide with existing ones. The result is a JSON array of the ids
+ * of the new symbols, which are appended to the block in the layout file.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `input_path` - Path to the original file
+ * * `output_dir` - Directory where the `block_<block_id>` directory will be written
+ * * `layout_path` - Path to the layout file of the file, updated with the new symbols
+ * * `block_id` - Identifier of the block in the layout
+ * * `count` - Number of repair symbols to generate
+ * * `result_buffer` - Buffer to store the JSON array
+ * * `result_buffer_len` - Length of the result buffer
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters, including too many symbols for the block or a block
+ *       split into several source blocks
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error
+ * * -12 on File not found
+ * * -14 if the block is not in the layout or the file doesn't match it
+ * * -15 if the layout is invalid
+ * * -17 on Concurrency limit reached
+ */
+int32_t raptorq_generate_repair_symbols(uintptr_t session_id,
+                                        const char *input_path,
+                                        const char *output_dir,
+                                        const char *layout_path,
+                                        uintptr_t block_id,
+                                        uint32_t count,
+                                        char *result_buffer,
+                                        uintptr_t result_buffer_len);
+
+/**
+ * Gets a symbol of a block by its encoding symbol ID (ESI)
+ *
+ * The symbol is read from the symbols directory if it is stored there. Otherwise it
+ * is generated from the data of the block, read from the original file if `input_path`
+ * is given, or else decoded from the stored symbols of the block. The symbol is copied
+ * with its FEC payload ID, as stored in symbol files.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `symbols_dir` - Directory containing the symbols
+ * * `layout_path` - Path to the layout file
+ * * `block_id` - Identifier of the block in the layout
+ * * `esi` - Encoding symbol ID of the symbol, source symbols come first
+ * * `input_path` - Path to the original file, NULL if it is not available
+ * * `symbol_buffer` - Buffer to store the symbol, the symbol size plus 4 bytes is enough
+ * * `symbol_buffer_len` - Length of the symbol buffer
+ * * `symbol_len` - Receives the length of the symbol, also when the buffer is too small
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters, including a block not in the layout or split into several
+ *       source blocks
+ * *  -4 on bad symbol buffer size
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error
+ * * -12 on File not found
+ * * -14 if the original file doesn't match the layout
+ * * -15 if the layout is invalid
+ * * -17 on Concurrency limit reached
+ * * -20 if the symbol is not stored and the block has too few symbols to generate it
+ */
+int32_t raptorq_get_symbol(uintptr_t session_id,
+                           const char *symbols_dir,
+                           const char *layout_path,
+                           uintptr_t block_id,
+                           uint32_t esi,
+                           const char *input_path,
+                           uint8_t *symbol_buffer,
+                           uintptr_t symbol_buffer_len,
+                           uintptr_t *symbol_len);
+
+/**
+ * Encodes a file using RaptorQ into a single tar archive holding all symbols and the layout
+ *
+ * The archive has the symbols of each block as `block_<id>/<symbol_id>` entries,
+ * block after block, then the layout as a `_raptorq_layout.json` entry,
+ * see docs/SYMBOL_ARCHIVE_FORMAT.md. It is read back by raptorq_decode_from_archive.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `input_path` - Path to the input file
+ * * `archive_path` - Path of the archive to write
+ * * `block_size` - Size of blocks to process at once (0 = auto)
+ * * `result_buffer` - Buffer to store the result (JSON metadata, including the layout)
+ * * `result_buffer_len` - Length of the result buffer
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error
+ * * -12 on File not found
+ * * -14 on Encoding failed
+ * * -16 on Memory limit exceeded
+ * * -17 on Concurrency limit reached
+ * * -19 if the session was cancelled
+ */
+int32_t raptorq_encode_file_to_archive(uintptr_t session_id,
+                                       const char *input_path,
+                                       const char *archive_path,
+                                       uintptr_t block_size,
+                                       char *result_buffer,
+                                       uintptr_t result_buffer_len);
+
+/**
+ * Encodes several files using RaptorQ, in parallel up to the concurrency limit
+ *
+ * The jobs are a JSON array of `{"input_path", "output_dir", "block_size"}` objects,
+ * `block_size` being optional (0 = auto). The result is a JSON array with one
+ * `{"error_code", "error", "result"}` object per job, in the order of the jobs:
+ * `error_code` is 0 and `result` holds the JSON metadata of the file on success,
+ * otherwise `error_code` is the error raptorq_encode_file would have returned
+ * and `error` its message. A file that fails does not stop the others.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `jobs_json` - JSON array of the files to encode
+ * * `result_buffer` - Buffer to store the results (JSON array)
+ * * `result_buffer_len` - Length of the result buffer
+ *
+ * Returns:
+ * *   0 on success, even if some files failed
+ * *  -2 on invalid parameters, including malformed jobs
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ */
+int32_t raptorq_encode_files(uintptr_t session_id,
+                             const char *jobs_json,
+                             char *result_buffer,
+                             uintptr_t result_buffer_len);
+
+/**
+ * Decodes several objects using RaptorQ, in parallel up to the concurrency limit
+ *
+ * The jobs are a JSON array of `{"symbols_dir", "output_path", "layout_path"}` objects.
+ * The result is a JSON array with one `{"error_code", "error"}` object per job, in the
+ * order of the jobs: `error_code` is 0 on success, otherwise the error
+ * raptorq_decode_symbols would have returned and `error` its message. An object that
+ * fails does not stop the others.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `jobs_json` - JSON array of the objects to decode
+ * * `result_buffer` - Buffer to store the results (JSON array)
+ * * `result_buffer_len` - Length of the result buffer
+ *
+ * Returns:
+ * *   0 on success, even if some objects failed
+ * *  -2 on invalid parameters, including malformed jobs
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ */
+int32_t raptorq_decode_files(uintptr_t session_id,
+                             const char *jobs_json,
+                             char *result_buffer,
+                             uintptr_t result_buffer_len);
+
+/**
+ * Encodes a stream using RaptorQ, block by block
+ *
+ * The stream is read through `read_callback` until it returns 0, only one block
+ * is held in memory at a time. The total size doesn't need to be known up front.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `read_callback` - Callback reading the next bytes of the stream
+ * * `context` - Opaque pointer passed to every call of the callback
+ * * `output_dir` - Directory where symbols will be written
+ * * `block_size` - Size of blocks to process at once (0 = bounded by the memory limit)
+ * * `result_buffer` - Buffer to store the result (JSON metadata)
+ * * `result_buffer_len` - Length of the result buffer
+ *
+ * Returns:
+ * *   0 on success
+ * *  -1 on generic error
+ * *  -2 on invalid parameters
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error (including a read error of the callback)
+ * * -14 on Encoding failed
+ * * -17 on Concurrency limit reached
+ */
+int32_t raptorq_encode_stream(uintptr_t session_id,
+                              RaptorQReadCallback read_callback,
+                              void *context,
+                              const char *output_dir,
+                              uintptr_t block_size,
+                              char *result_buffer,
+                              uintptr_t result_buffer_len);
+
+/**
+ * Encodes data read at random offsets using RaptorQ, encoding its blocks in parallel
+ *
+ * Each block is read from its own range through `read_callback`, so blocks are
+ * encoded on threads taking the free slots of the session's concurrency limit.
+ * The callback is called from several threads at once and must be thread-safe.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `read_callback` - Callback reading the data at an offset
+ * * `context` - Opaque pointer passed to every call of the callback
+ * * `size` - Size of the data
+ * * `output_dir` - Directory where symbols will be written
+ * * `block_size` - Size of blocks to process at once (0 = auto)
+ * * `result_buffer` - Buffer to store the result (JSON metadata)
+ * * `result_buffer_len` - Length of the result buffer
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error (including a read error of the callback, or data shorter than `size`)
+ * * -14 on Encoding failed
+ * * -17 on Concurrency limit reached
+ * * -19 on Cancelled
+ */
+int32_t raptorq_encode_read_at(uintptr_t session_id,
+                               RaptorQReadAtCallback read_callback,
+                               void *context,
+                               uint64_t size,
+                               const char *output_dir,
+                               uintptr_t block_size,
+                               char *result_buffer,
+                               uintptr_t result_buffer_len);
+
+/**
+ * Encodes data held in memory using RaptorQ, without touching the filesystem
+ *
+ * The symbols are returned through a buffer allocated by the library. It is packed as
+ * a sequence of symbols, each one prefixed with its length as a 4 bytes little-endian
+ * integer, in the order of the blocks and symbol ids of the layout in the result JSON.
+ *
+ * Memory ownership:
+ * * On success, `*symbols_buffer` and `*symbols_buffer_len` describe a buffer owned by
+ *   the caller, which must be released with raptorq_free_buffer exactly once
+ * * On any error, `*symbols_buffer` is set to NULL and `*symbols_buffer_len` to 0,
+ *   nothing is allocated and there is nothing to free
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `data` - Data to encode
+ * * `data_len` - Length of the data
+ * * `block_size` - Size of blocks to process at once (0 = auto)
+ * * `result_buffer` - Buffer to store the result (JSON metadata, including the layout)
+ * * `result_buffer_len` - Length of the result buffer
+ * * `symbols_buffer` - Receives the pointer to the packed symbols
+ * * `symbols_buffer_len` - Receives the length of the packed symbols
+ *
+ * Returns:
+ * *   0 on success
+ * *  -1 on generic error
+ * *  -2 on invalid parameters
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -14 on Encoding failed
+ * * -16 on Memory limit exceeded
+ * * -17 on Concurrency limit reached
+ */
+int32_t raptorq_encode_bytes(uintptr_t session_id,
+                             const uint8_t *data,
+                             uintptr_t data_len,
+                             uintptr_t block_size,
+                             char *result_buffer,
+                             uintptr_t result_buffer_len,
+                             uint8_t **symbols_buffer,
+                             uintptr_t *symbols_buffer_len);
+
+/**
+ * Frees a buffer allocated by the library
+ *
+ * Arguments:
+ * * `buffer` - Pointer returned by the library (NULL is ignored)
+ * * `buffer_len` - Length returned together with the pointer
+ */
+void raptorq_free_buffer(uint8_t *buffer, uintptr_t buffer_len);
+
+/**
+ * Starts encoding a file block by block
+ *
+ * Each block is then encoded by a call to raptorq_encode_next_block, so the caller
+ * can report progress between blocks, and raptorq_encode_finish writes the layout.
+ * A job must be released by raptorq_encode_finish or raptorq_encode_abort.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `input_path` - Path to the input file
+ * * `output_dir` - Directory where symbols will be written
+ * * `block_size` - Size of blocks to process at once (0 = auto)
+ * * `job_id` - Receives the ID of the encode job
+ * * `blocks_total` - Receives the number of blocks to encode (can be NULL)
+ *
+ * Returns:
+ * *   0 on success
+ * *  -1 on generic error
+ * *  -2 on invalid parameters
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error
+ * * -12 on File not found
+ * * -14 on Encoding failed
+ * * -17 on Concurrency limit reached
+ */
+int32_t raptorq_encode_begin(uintptr_t session_id,
+                             const char *input_path,
+                             const char *output_dir,
+                             uintptr_t block_size,
+                             uintptr_t *job_id,
+                             uintptr_t *blocks_total);
+
+/**
+ * Encodes the next block of a job started with raptorq_encode_begin
+ *
+ * Does nothing once all blocks are encoded. The job is kept on error,
+ * it must still be released with raptorq_encode_abort.
+ *
+ * Arguments:
+ * * `job_id` - Job ID returned from raptorq_encode_begin
+ * * `blocks_done` - Receives the number of blocks encoded so far (can be NULL)
+ * * `bytes_processed` - Receives the number of bytes encoded so far (can be NULL)
+ *
+ * Returns:
+ * *   0 on success
+ * *  -1 on generic error
+ * *  -5 on invalid job
+ * * -11 on IO error
+ * * -14 on Encoding failed
+ * * -17 on Concurrency limit reached
+ * * -19 on Cancelled
+ */
+int32_t raptorq_encode_next_block(uintptr_t job_id,
+                                  uintptr_t *blocks_done,
+                                  uint64_t *bytes_processed);
+
+/**
+ * Writes the layout of a job whose blocks were all encoded and releases the job
+ *
+ * The job is released once its result is copied to the buffer. It is kept if some
+ * blocks are not encoded yet or the buffer is too small, so the call can be made
+ * again, the layout being written only once. A job failing to write its layout is
+ * released, as it can't be finished anymore.
+ *
+ * Arguments:
+ * * `job_id` - Job ID returned from raptorq_encode_begin
+ * * `result_buffer` - Buffer to store the result (JSON metadata)
+ * * `result_buffer_len` - Length of the result buffer
+ *
+ * Returns:
+ * *   0 on success
+ * *  -1 on generic error
+ * *  -2 on invalid parameters
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size
+ * *  -5 on invalid job
+ * * -11 on IO error
+ * * -14 on Encoding failed (including blocks not encoded yet)
+ */
+int32_t raptorq_encode_finish(uintptr_t job_id, char *result_buffer, uintptr_t result_buffer_len);
+
+/**
+ * Releases a job started with raptorq_encode_begin without finishing it
+ *
+ * Symbols of the blocks already encoded are left in the output directory.
+ *
+ * Returns true if the job existed
+ */
+bool raptorq_encode_abort(uintptr_t job_id);
+
+/**
+ * Gets the last error message from the processor
+ *
+ * The last error is kept per thread, as are its code, the shortfalls and the corrupt
+ * symbols: a thread gets the error of the last operation it called on the session,
+ * even while other threads run operations on the same session. They are dropped when
+ * the thread exits.
+ *
+ * The thread is the OS thread, so callers whose tasks move between OS threads must
+ * keep the operation and the reads of its error on one thread. From Go, call
+ * `runtime.LockOSThread()` before the operation and `runtime.UnlockOSThread()` once
+ * its error is read, otherwise the error of another call, or none, may be returned.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `error_buffer` - Buffer to store the error message
+ * * `error_buffer_len` - Length of the error buffer
+ *
+ * Returns:
+ * * 0 on success
+ * * -1 on error
+ */
+int32_t raptorq_get_last_error(uintptr_t session_id,
+                               char *error_buffer,
+                               uintptr_t error_buffer_len);
+
+/**
+ * Gets the last error of a session with its code
+ *
+ * The code is the one returned by the last operation of the session that failed:
+ * * -11 to -23 when the operation itself failed (IO error, file not found, invalid
+ *   path, encoding or decoding failed, memory limit, concurrency limit, missing
+ *   symbols, cancelled, symbol not found, timed out, object mismatch, unsupported
+ *   layout version), the message then gives the details
+ * * -2 when the operation rejected its configuration or parameters
+ *
+ * Failures of the call itself (-1 to -5: NULL arguments, result buffer too small,
+ * unknown session) are returned directly and not recorded. The code is 0 if no
+ * operation failed since the session was created or reset.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `error_code` - Receives the code of the last error (can be NULL)
+ * * `error_buffer` - Buffer to store the error message
+ * * `error_buffer_len` - Length of the error buffer
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ */
+int32_t raptorq_get_last_error_detail(uintptr_t session_id,
+                                      int32_t *error_code,
+                                      char *error_buffer,
+                                      uintptr_t error_buffer_len);
+
+/**
+ * Decodes RaptorQ symbols back to the original file
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `symbols_dir` - Directory containing the symbols
+ * * `output_path` - Path where the decoded file will be written
+ * * `layout_path` - Path to the layout file (containing encoder parameters and block information)
+ *
+ * Returns:
+ * *   0 on success
+ * *  -1 on generic error
+ * *  -2 on invalid parameters
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size
+ * *  -4 on encoding failure
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error
+ * * -12 on File not found
+ * * -13 on Invalid Path
+ * * -15 on Decoding failed
+ * * -16 on Memory limit exceeded
+ * * -17 on Concurrency limit reached
+ * * -18 on Insufficient symbols (see raptorq_get_last_shortfalls)
+ * * -22 if the symbols directory holds the layout of another object
+ */
+int32_t raptorq_decode_symbols(uintptr_t session_id,
+                               const char *symbols_dir,
+                               const char *output_path,
+                               const char *layout_path);
+
+/**
+ * Decodes RaptorQ symbols to recreate the original file, then writes back the source
+ * symbols missing from the symbols directory
+ *
+ * The missing source symbols are encoded again from the decoded file and written
+ * where decodes look them up, so later decodes find them. Lost repair symbols are not
+ * regenerated and the layout file is not modified.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `symbols_dir` - Path to the directory containing the symbol files, repaired in place
+ * * `output_path` - Path where the decoded file will be written
+ * * `layout_path` - Path to the layout file
+ * * `repaired` - Receives the number of source symbols written back
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error
+ * * -12 on File not found
+ * * -15 on Decoding failed
+ * * -17 on Concurrency limit reached
+ * * -18 on Insufficient symbols (see raptorq_get_last_shortfalls), the directory is left untouched
+ * * -22 if the symbols directory holds the layout of another object
+ */
+int32_t raptorq_decode_and_repair(uintptr_t session_id,
+                                  const char *symbols_dir,
+                                  const char *output_path,
+                                  const char *layout_path,
+                                  uint64_t *repaired);
+
+/**
+ * Encodes a file without a session, as raptorq_encode_file does with a session of
+ * the given configuration created and freed by the call
+ *
+ * Arguments:
+ * * `symbol_size`, `redundancy_factor`, `max_memory_mb`, `concurrency_limit` -
+ *   Configuration, as given to raptorq_init_session
+ * * `input_path` - Path to the input file
+ * * `output_dir` - Directory where symbols will be written
+ * * `block_size` - Size of blocks to process at once (0 = auto)
+ * * `result_buffer` - Buffer to store the result (JSON metadata)
+ * * `result_buffer_len` - Length of the result buffer
+ * * `error_buffer` - Optional buffer to store the error message, as there is no
+ *   session to read it from
+ * * `error_buffer_len` - Length of the error buffer
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters, including an invalid configuration
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size, the symbols are written nonetheless
+ * * Other codes of raptorq_encode_file on failure
+ */
+int32_t raptorq_encode_file_oneshot(uint16_t symbol_size,
+                                    uint8_t redundancy_factor,
+                                    uint64_t max_memory_mb,
+                                    uint64_t concurrency_limit,
+                                    const char *input_path,
+                                    const char *output_dir,
+                                    uintptr_t block_size,
+                                    char *result_buffer,
+                                    uintptr_t result_buffer_len,
+                                    char *error_buffer,
+                                    uintptr_t error_buffer_len);
+
+/**
+ * Decodes symbols without a session, as raptorq_decode_symbols does with a session
+ * of the given configuration created and freed by the call
+ *
+ * Arguments:
+ * * `symbol_size`, `redundancy_factor`, `max_memory_mb`, `concurrency_limit` -
+ *   Configuration, as given to raptorq_init_session
+ * * `symbols_dir` - Directory containing the symbols
+ * * `output_path` - Path where the decoded file will be written
+ * * `layout_path` - Path to the layout file
+ * * `error_buffer` - Optional buffer to store the error message, as there is no
+ *   session to read it from
+ * * `error_buffer_len` - Length of the error buffer
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters, including an invalid configuration
+ * * Other codes of raptorq_decode_symbols on failure
+ */
+int32_t raptorq_decode_symbols_oneshot(uint16_t symbol_size,
+                                       uint8_t redundancy_factor,
+                                       uint64_t max_memory_mb,
+                                       uint64_t concurrency_limit,
+                                       const char *symbols_dir,
+                                       const char *output_path,
+                                       const char *layout_path,
+                                       char *error_buffer,
+                                       uintptr_t error_buffer_len);
+
+/**
+ * Decodes the symbols of a directory with the layout file it holds
+ *
+ * The `_raptorq_layout.json` written next to the symbols by raptorq_encode_file is
+ * used when present, so the symbols can't be decoded with the layout of another
+ * object. The given layout file is only used for directories without one.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `symbols_dir` - Directory containing the symbols
+ * * `output_path` - Path where the decoded file will be written
+ * * `layout_path` - Path to the layout file to use if the directory has none, or NULL
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error
+ * * -12 if the directory has no layout file and none is given, or on File not found
+ * * -13 on Invalid Path
+ * * -15 on Decoding failed
+ * * -16 on Memory limit exceeded
+ * * -17 on Concurrency limit reached
+ * * -18 on Insufficient symbols (see raptorq_get_last_shortfalls)
+ * * -23 if the layout is of a newer version than the library supports
+ */
+int32_t raptorq_decode_dir(uintptr_t session_id,
+                           const char *symbols_dir,
+                           const char *output_path,
+                           const char *layout_path);
+
+/**
+ * Decodes RaptorQ symbols read from a tar archive back to the original file
+ *
+ * The archive holds the symbols as `block_<id>/<symbol_id>` entries, like the
+ * symbols directory, and is read without being extracted.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `tar_path` - Path to the tar archive
+ * * `output_path` - Path where the decoded file will be written
+ * * `layout_path` - Path to the layout file, or NULL to read the `_raptorq_layout.json`
+ *   entry of the archive
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error, including a truncated or corrupt archive
+ * * -12 if the archive or the layout is not found
+ * * -15 on Decoding failed
+ * * -17 on Concurrency limit reached
+ * * -18 on Insufficient symbols (see raptorq_get_last_shortfalls)
+ * * -19 if the session was cancelled
+ */
+int32_t raptorq_decode_from_tar(uintptr_t session_id,
+                                const char *tar_path,
+                                const char *output_path,
+                                const char *layout_path);
+
+/**
+ * Decodes an archive written by raptorq_encode_file_to_archive back to the original file
+ *
+ * Same as raptorq_decode_from_tar with the layout of the archive.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `archive_path` - Path to the archive
+ * * `output_path` - Path where the decoded file will be written
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error, including a truncated or corrupt archive
+ * * -12 if the archive or its layout is not found
+ * * -15 on Decoding failed
+ * * -17 on Concurrency limit reached
+ * * -18 on Insufficient symbols (see raptorq_get_last_shortfalls)
+ * * -19 if the session was cancelled
+ */
+int32_t raptorq_decode_from_archive(uintptr_t session_id,
+                                    const char *archive_path,
+                                    const char *output_path);
+
+/**
+ * Decodes RaptorQ symbols back to the original file, checking every symbol first
+ *
+ * The id of a symbol is the hash of its content: symbol files whose content
+ * doesn't match their name are skipped and the other symbols of the block are
+ * used instead. The skipped symbols are listed by raptorq_get_last_corrupt_symbols.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `symbols_dir` - Directory containing the symbols
+ * * `output_path` - Path where the decoded file will be written
+ * * `layout_path` - Path to the layout file (containing encoder parameters and block information)
+ *
+ * Returns:
+ * *   0 on success, even if corrupt symbols were skipped
+ * *  -2 on invalid parameters
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error
+ * * -12 on File not found
+ * * -13 on Invalid Path
+ * * -15 on Decoding failed
+ * * -16 on Memory limit exceeded
+ * * -17 on Concurrency limit reached
+ * * -18 on Insufficient symbols (see raptorq_get_last_shortfalls)
+ * * -22 if the symbols directory holds the layout of another object
+ */
+int32_t raptorq_decode_symbols_verified(uintptr_t session_id,
+                                        const char *symbols_dir,
+                                        const char *output_path,
+                                        const char *layout_path);
+
+/**
+ * Decodes RaptorQ symbols back to the original file, decoding its blocks in parallel
+ *
+ * Blocks are decoded on threads taking the free slots of the session's concurrency
+ * limit and written at their offsets as they complete.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `symbols_dir` - Directory containing the symbols
+ * * `output_path` - Path where the decoded file will be written
+ * * `layout_path` - Path to the layout file (containing encoder parameters and block information)
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error
+ * * -12 on File not found
+ * * -13 on Invalid Path
+ * * -15 on Decoding failed
+ * * -16 on Memory limit exceeded
+ * * -17 on Concurrency limit reached
+ * * -18 on Insufficient symbols (see raptorq_get_last_shortfalls)
+ * * -19 on Cancelled
+ * * -22 if the symbols directory holds the layout of another object
+ */
+int32_t raptorq_decode_symbols_parallel(uintptr_t session_id,
+                                        const char *symbols_dir,
+                                        const char *output_path,
+                                        const char *layout_path);
+
+/**
+ * Decodes RaptorQ symbols spread over several directories back to the original file
+ *
+ * The directories are used as one, each symbol being read from the first directory
+ * having it, so they don't need to be copied into one directory first.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `symbols_dirs_json` - JSON array of the directories containing the symbols
+ * * `output_path` - Path where the decoded file will be written
+ * * `layout_path` - Path to the layout file (containing encoder parameters and block information)
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters, including an empty or malformed array
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error
+ * * -12 on File not found
+ * * -13 on Invalid Path, including a directory that doesn't exist
+ * * -15 on Decoding failed
+ * * -16 on Memory limit exceeded
+ * * -17 on Concurrency limit reached
+ * * -18 on Insufficient symbols (see raptorq_get_last_shortfalls)
+ * * -19 on Cancelled
+ * * -22 if the symbols directory holds the layout of another object
+ */
+int32_t raptorq_decode_from_dirs(uintptr_t session_id,
+                                 const char *symbols_dirs_json,
+                                 const char *output_path,
+                                 const char *layout_path);
+
+/**
+ * Copies the symbols of an object into several shard directories, dealing the symbols
+ * of each block in turn to the shards
+ *
+ * Every shard holds about the same share of the symbols of every block, in block
+ * directories, and the map of all shards in `_raptorq_shards.json`. The shards decode
+ * together with raptorq_decode_from_dirs. The result is the map as a JSON object, for
+ * example `{"shard_dirs":["a","b"],"symbols":{"<symbol_id>":0},"decodable_shards":[]}`,
+ * with the shards holding enough symbols to decode every block on their own. Symbol
+ * files that don't hold the symbol of their id are not copied.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `symbols_dir` - Directory containing the symbols
+ * * `layout_path` - Path to the layout file
+ * * `shard_dirs_json` - JSON array of the distinct shard directories, created if needed
+ * * `result_buffer` - Buffer to store the JSON map
+ * * `result_buffer_len` - Length of the result buffer
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters, including an empty, malformed or repeated array and
+ *       symbols encrypted with another key
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size, the shards and their maps are written nonetheless
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error
+ * * -12 if the layout file is not found
+ * * -13 if the symbols directory doesn't exist
+ * * -15 if the layout can't be parsed
+ * * -17 on Concurrency limit reached
+ * * -19 on Cancelled
+ */
+int32_t raptorq_shard_symbols(uintptr_t session_id,
+                              const char *symbols_dir,
+                              const char *layout_path,
+                              const char *shard_dirs_json,
+                              char *result_buffer,
+                              uintptr_t result_buffer_len);
+
+/**
+ * Decodes the given symbol files only back to the original file
+ *
+ * Symbol files are named after their symbol id, so the layout gives the block of
+ * each of them and no directory is listed. Files that are not in the layout, missing
+ * or unreadable are skipped.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `symbol_paths_json` - JSON array of the paths of the symbol files
+ * * `output_path` - Path where the decoded file will be written
+ * * `layout_path` - Path to the layout file (containing encoder parameters and block information)
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters, including a malformed array
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error
+ * * -12 on File not found
+ * * -15 on Decoding failed
+ * * -16 on Memory limit exceeded
+ * * -17 on Concurrency limit reached
+ * * -18 on Insufficient symbols (see raptorq_get_last_shortfalls)
+ * * -19 on Cancelled
+ */
+int32_t raptorq_decode_from_files(uintptr_t session_id,
+                                  const char *symbol_paths_json,
+                                  const char *output_path,
+                                  const char *layout_path);
+
+/**
+ * Decodes RaptorQ symbols back to the original file and checks the whole file
+ * against the SHA-256 recorded in the layout by raptorq_encode_file
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `symbols_dir` - Directory containing the symbols
+ * * `output_path` - Path where the decoded file will be written
+ * * `layout_path` - Path to the layout file, with an `object_sha256`
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error
+ * * -12 on File not found
+ * * -13 on Invalid Path
+ * * -15 on Decoding failed, also if the layout has no object hash or the decoded
+ *       file doesn't match it (the file is kept, see raptorq_get_last_error)
+ * * -16 on Memory limit exceeded
+ * * -17 on Concurrency limit reached
+ * * -18 on Insufficient symbols (see raptorq_get_last_shortfalls)
+ * * -22 if the symbols directory holds the layout of another object
+ */
+int32_t raptorq_decode_symbols_checked(uintptr_t session_id,
+                                       const char *symbols_dir,
+                                       const char *output_path,
+                                       const char *layout_path);
+
+/**
+ * Checks whether the symbols of a directory are likely enough to decode a layout,
+ * without decoding it
+ *
+ * Each block needs the number of symbols given by raptorq_min_symbols_for_block,
+ * or all of its symbols if the layout has fewer.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `symbols_dir` - Directory containing the symbols
+ * * `layout_path` - Path to the layout file
+ *
+ * Returns:
+ * *   1 if every block has enough symbols
+ * *   0 if a block is missing symbols or the symbols directory does not exist
+ * *  -2 on invalid parameters
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error
+ * * -12 if the layout file is not found
+ * * -15 if the layout is invalid
+ */
+int32_t raptorq_can_decode(uintptr_t session_id, const char *symbols_dir, const char *layout_path);
+
+/**
+ * Counts, for each block of a layout, how many more symbols a directory needs
+ *
+ * The result is a JSON object mapping each block id to the number of symbols missing
+ * to reach raptorq_min_symbols_for_block, zero or negative if the block already has
+ * enough, for example `{"0":-108,"1":7}`.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `symbols_dir` - Directory containing the symbols
+ * * `layout_path` - Path to the layout file
+ * * `result_buffer` - Buffer to store the JSON object
+ * * `result_buffer_len` - Length of the result buffer
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error
+ * * -12 if the layout file is not found
+ * * -15 if the layout is invalid
+ */
+int32_t raptorq_missing_symbols(uintptr_t session_id,
+                                const char *symbols_dir,
+                                const char *layout_path,
+                                char *result_buffer,
+                                uintptr_t result_buffer_len);
+
+/**
+ * Checks a layout and the symbols of a directory and describes every problem found
+ *
+ * The result is a JSON array of issues, empty if the layout can be decoded with all its
+ * symbols. Each issue has a `kind`, a `message` and, unless it is about the whole
+ * layout, a `block_id`, for example
+ * `[{"block_id":1,"kind":"insufficient_symbols","message":"Block 1 has 5 of its 12 symbols on disk, 11 are needed to decode it"}]`.
+ * The kinds are `no_blocks`, `duplicate_block`, `missing_block`, `invalid_encoder_parameters`,
+ * `size_mismatch`, `offset_mismatch`, `too_few_symbols`, `missing_symbols_directory`,
+ * `missing_symbols` and `insufficient_symbols`; only `missing_symbols` still allows decoding.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `symbols_dir` - Directory containing the symbols
+ * * `layout_path` - Path to the layout file
+ * * `result_buffer` - Buffer to store the JSON array
+ * * `result_buffer_len` - Length of the result buffer
+ *
+ * Returns:
+ * *   0 on success, even if issues were found
+ * *  -2 on invalid parameters
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error
+ * * -12 if the layout file is not found
+ * * -15 if the layout can't be parsed
+ */
+int32_t raptorq_validate_layout(uintptr_t session_id,
+                                const char *symbols_dir,
+                                const char *layout_path,
+                                char *result_buffer,
+                                uintptr_t result_buffer_len);
+
+/**
+ * Reads every symbol of a layout from its directory and passes each problem found
+ * to a callback as soon as it is found
+ *
+ * Unlike raptorq_validate_layout, the symbol files are read one at a time with the
+ * symbol format of the layout and checked to hold a whole symbol of their block under
+ * the id they are named after, so a large directory is scrubbed with the memory of one
+ * symbol. Each issue has the fields of raptorq_validate_layout and a `symbol_id`, for example
+ * `{"block_id":0,"symbol_id":"4Nd...","kind":"symbol_size_mismatch","message":"..."}`,
+ * with the kinds `missing_symbol`, `unreadable_symbol`, `symbol_size_mismatch` and
+ * `symbol_id_mismatch`, and `missing_symbols_directory` or `invalid_encoder_parameters`
+ * without a `symbol_id`. The callback is called from the calling thread.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `symbols_dir` - Directory containing the symbols
+ * * `layout_path` - Path to the layout file
+ * * `issue_callback` - Callback receiving the issues
+ * * `context` - Opaque pointer passed to every call of the callback
+ *
+ * Returns:
+ * *   0 on success, even if issues were found
+ * *  -2 on invalid parameters, including symbols encrypted with another key
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error
+ * * -12 if the layout file is not found
+ * * -15 if the layout can't be parsed
+ * * -17 on Concurrency limit reached
+ * * -23 if the layout is of a newer version than the library supports
+ */
+int32_t raptorq_scrub_symbols(uintptr_t session_id,
+                              const char *symbols_dir,
+                              const char *layout_path,
+                              RaptorQIssueCallback issue_callback,
+                              void *context);
+
+/**
+ * Reports how many symbols of each block a directory holds against the number needed
+ * to decode it, without decoding nor writing anything
+ *
+ * The result is a JSON object with `recoverable`, `min_margin`, the number of symbols
+ * the weakest block can still lose, and `blocks`, for example
+ * `{"recoverable":true,"min_margin":3,"blocks":[{"block_id":0,"listed":15,"available":15,"source_symbols":10,"threshold":12,"margin":3}]}`.
+ * A missing symbols directory has no symbols.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `symbols_dir` - Directory containing the symbols
+ * * `layout_path` - Path to the layout file
+ * * `result_buffer` - Buffer to store the JSON report
+ * * `result_buffer_len` - Length of the result buffer
+ *
+ * Returns:
+ * *   0 on success, even if the object isn't recoverable
+ * *  -2 on invalid parameters
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error
+ * * -12 if the layout file is not found
+ * * -15 if the layout can't be parsed
+ */
+int32_t raptorq_audit_object(uintptr_t session_id,
+                             const char *symbols_dir,
+                             const char *layout_path,
+                             char *result_buffer,
+                             uintptr_t result_buffer_len);
+
+/**
+ * Counts the source and repair symbols of each block a directory holds, without
+ * decoding nor writing anything
+ *
+ * The result is a JSON object mapping each block id to its counts, for example
+ * `{"0":{"source_present":10,"repair_present":3,"source_expected":10,"repair_expected":5}}`.
+ * A missing symbols directory has no symbols.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `symbols_dir` - Directory containing the symbols
+ * * `layout_path` - Path to the layout file
+ * * `result_buffer` - Buffer to store the JSON inventory
+ * * `result_buffer_len` - Length of the result buffer
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error
+ * * -12 if the layout file is not found
+ * * -15 if the layout can't be parsed
+ */
+int32_t raptorq_symbol_inventory(uintptr_t session_id,
+                                 const char *symbols_dir,
+                                 const char *layout_path,
+                                 char *result_buffer,
+                                 uintptr_t result_buffer_len);
+
+/**
+ * Rebuilds the layout of an object from its symbols directory, when the layout file was lost
+ *
+ * The blocks are laid out as raptorq_encode_file splits an object of `object_size`
+ * bytes with `block_size` and the session's configuration, and their symbols are
+ * read with the session's symbol codec and key. The size of the object can't be read
+ * from the symbols, and a wrong size gives a layout decoding to wrong data. The hashes
+ * of the blocks and of the object are left empty, so decodes can't verify the data.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `symbols_dir` - Directory containing the symbols
+ * * `object_size` - Size of the original data in bytes
+ * * `block_size` - Block size of the encode (0 = auto)
+ * * `result_buffer` - Buffer to store the layout JSON, as written in layout files
+ * * `result_buffer_len` - Length of the result buffer
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters, including an empty object or more blocks in the
+ *    directory than in the object
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error
+ * * -13 if the symbols directory does not exist
+ * * -15 if a block has no usable symbol
+ */
+int32_t raptorq_reconstruct_layout(uintptr_t session_id,
+                                   const char *symbols_dir,
+                                   uint64_t object_size,
+                                   uintptr_t block_size,
+                                   char *result_buffer,
+                                   uintptr_t result_buffer_len);
+
+/**
+ * Enables or disables the metrics of a session
+ *
+ * Once enabled, raptorq_encode_file and raptorq_decode_symbols count their bytes,
+ * blocks, symbols, successes and failures and measure their latency, and every
+ * block encoded or decoded by the session is timed. Read them with raptorq_get_metrics.
+ * Enabling metrics again keeps the current values, disabling them drops them.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `enabled` - Whether the session keeps metrics
+ *
+ * Returns:
+ * *   0 on success
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ */
+int32_t raptorq_enable_metrics(uintptr_t session_id, bool enabled);
+
+/**
+ * Gets the metrics of a session
+ *
+ * The result is a JSON object with:
+ * * `operations` - per operation name (`encode_file`, `decode_symbols`), the
+ *   `succeeded` and `failed` counts, the `bytes`, `blocks` and `symbols` of the
+ *   operations that succeeded, and their `latency`
+ * * `encoded_blocks`, `decoded_blocks` - the number of `blocks`, their `bytes` and
+ *   `symbols`, their `latency` and the `slowest` block with its `block_id`
+ *
+ * A latency is a histogram: `buckets` counts the durations up to 1, 5, 10, 25, 50,
+ * 100, 250, 500, 1000, 2500, 5000 and 10000 ms, then above; with `count` and `sum_ms`.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `result_buffer` - Buffer to store the JSON object
+ * * `result_buffer_len` - Length of the result buffer
+ *
+ * Returns:
+ * *   0 on success
+ * *  -1 if metrics are not enabled on the session
+ * *  -2 on invalid parameters
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ */
+int32_t raptorq_get_metrics(uintptr_t session_id, char *result_buffer, uintptr_t result_buffer_len);
+
+/**
+ * Gets the per-block symbol shortfalls of the last failed decode
+ *
+ * The result is a JSON array of `{"block_id", "present", "required"}` objects,
+ * one per block that could not be decoded. It is empty if the last decode
+ * did not fail with -18.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `result_buffer` - Buffer to store the JSON array
+ * * `result_buffer_len` - Length of the result buffer
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ */
+int32_t raptorq_get_last_shortfalls(uintptr_t session_id,
+                                    char *result_buffer,
+                                    uintptr_t result_buffer_len);
+
+/**
+ * Gets the symbols found corrupt by the last verified decode
+ *
+ * The result is a JSON array of `{"block_id", "symbol_id"}` objects, filled by
+ * raptorq_decode_symbols_verified whether the decode succeeded or not.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `result_buffer` - Buffer to store the JSON array
+ * * `result_buffer_len` - Length of the result buffer
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ */
+int32_t raptorq_get_last_corrupt_symbols(uintptr_t session_id,
+                                         char *result_buffer,
+                                         uintptr_t result_buffer_len);
+
+/**
+ * Gets the minimum number of symbols to fetch for a block to decode
+ *
+ * This is the number of source symbols of the block plus a small overhead,
+ * with which the decode succeeds with high probability.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `layout_path` - Path to the layout file
+ * * `block_id` - Identifier of the block in the layout
+ * * `min_symbols` - Receives the number of symbols
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -12 if the layout file is not found
+ * * -15 if the layout is invalid or does not contain the block
+ */
+int32_t raptorq_min_symbols_for_block(uintptr_t session_id,
+                                      const char *layout_path,
+                                      uintptr_t block_id,
+                                      uint64_t *min_symbols);
+
+/**
+ * Gets the minimum number of symbols to fetch for each block of a layout to decode
+ *
+ * The result is a JSON object mapping each block id to its number of symbols,
+ * computed as in raptorq_min_symbols_for_block.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `layout_path` - Path to the layout file
+ * * `result_buffer` - Buffer to store the JSON object
+ * * `result_buffer_len` - Length of the result buffer
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -12 if the layout file is not found
+ * * -15 if the layout is invalid
+ */
+int32_t raptorq_min_symbols_per_block(uintptr_t session_id,
+                                      const char *layout_path,
+                                      char *result_buffer,
+                                      uintptr_t result_buffer_len);
+
+/**
+ * Gets the RFC 6330 Object Transmission Information of every block of a layout
+ *
+ * Each block is a separate RaptorQ object, which a standard decoder can decode
+ * from its OTI and its symbols. The result is a JSON array with one object per
+ * block: `block_id`, `oti` (the 12 bytes Common and Scheme-Specific FEC OTI),
+ * `transfer_length` (F), `symbol_size` (T), `source_blocks` (Z),
+ * `sub_blocks` (N) and `symbol_alignment` (Al).
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `layout_path` - Path to the layout file
+ * * `result_buffer` - Buffer to store the JSON array
+ * * `result_buffer_len` - Length of the result buffer
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -12 if the layout file is not found
+ * * -15 if the layout is invalid
+ */
+int32_t raptorq_get_oti(uintptr_t session_id,
+                        const char *layout_path,
+                        char *result_buffer,
+                        uintptr_t result_buffer_len);
+
+/**
+ * Decodes RaptorQ symbols held in memory back to the original data
+ *
+ * The symbols are passed packed the same way raptorq_encode_bytes returns them:
+ * each symbol prefixed with its length as a 4 bytes little-endian integer.
+ * They can be in any order, symbols unknown to the layout are ignored.
+ *
+ * Memory ownership:
+ * * The input buffers stay owned by the caller and are not retained after the call
+ * * On success, `*output_buffer` and `*output_buffer_len` describe a buffer owned by
+ *   the caller, which must be released with raptorq_free_buffer exactly once;
+ *   copy the data out of it before freeing it
+ * * On any error, `*output_buffer` is set to NULL and `*output_buffer_len` to 0,
+ *   nothing is allocated and there is nothing to free
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `symbols` - Packed symbols
+ * * `symbols_len` - Length of the packed symbols
+ * * `layout` - Content of the layout JSON
+ * * `layout_len` - Length of the layout content
+ * * `output_buffer` - Receives the pointer to the decoded data
+ * * `output_buffer_len` - Receives the length of the decoded data
+ *
+ * Returns:
+ * *   0 on success
+ * *  -1 on generic error
+ * *  -2 on invalid parameters
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -15 on Decoding failed
+ * * -16 on Memory limit exceeded
+ * * -17 on Concurrency limit reached
+ * * -18 on Insufficient symbols (see raptorq_get_last_shortfalls)
+ */
+int32_t raptorq_decode_bytes(uintptr_t session_id,
+                             const uint8_t *symbols,
+                             uintptr_t symbols_len,
+                             const uint8_t *layout,
+                             uintptr_t layout_len,
+                             uint8_t **output_buffer,
+                             uintptr_t *output_buffer_len);
+
+/**
+ * Decodes a RaptorQ object from its RFC 6330 OTI and encoding packets
+ *
+ * No layout is needed, so objects from other RFC 6330 encoders can be decoded,
+ * as well as single blocks of this library (see raptorq_get_oti). Each packet is
+ * a 4 bytes FEC payload ID followed by the symbol, the packets are packed as for
+ * raptorq_decode_bytes. The output buffer is handled as in raptorq_decode_bytes.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `oti` - The 12 bytes Common and Scheme-Specific FEC OTI of the object
+ * * `oti_len` - Length of the OTI
+ * * `packets` - Packed encoding packets
+ * * `packets_len` - Length of the packed packets
+ * * `output_buffer` - Receives the pointer to the decoded object
+ * * `output_buffer_len` - Receives the length of the decoded object
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters, including an invalid OTI
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -15 on Decoding failed
+ * * -16 on Memory limit exceeded
+ * * -17 on Concurrency limit reached
+ * * -18 on Insufficient symbols (see raptorq_get_last_shortfalls)
+ */
+int32_t raptorq_decode_with_oti(uintptr_t session_id,
+                                const uint8_t *oti,
+                                uintptr_t oti_len,
+                                const uint8_t *packets,
+                                uintptr_t packets_len,
+                                uint8_t **output_buffer,
+                                uintptr_t *output_buffer_len);
+
+/**
+ * Decodes RaptorQ symbols and streams the original data to a callback
+ *
+ * Blocks are decoded one by one and their data is passed to `write_callback`
+ * in order, so no temporary file is needed and memory stays bounded to a block.
+ * On error, the data of the blocks decoded before may have been written already.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `symbols_dir` - Directory containing the symbols
+ * * `layout_path` - Path to the layout file
+ * * `write_callback` - Callback receiving the decoded data
+ * * `context` - Opaque pointer passed to every call of the callback
+ *
+ * Returns:
+ * *   0 on success
+ * *  -1 on generic error
+ * *  -2 on invalid parameters
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error (including a write error of the callback)
+ * * -12 on File not found
+ * * -13 on Invalid Path
+ * * -15 on Decoding failed
+ * * -17 on Concurrency limit reached
+ * * -18 on Insufficient symbols (see raptorq_get_last_shortfalls)
+ * * -22 if the symbols directory holds the layout of another object
+ */
+int32_t raptorq_decode_to_writer(uintptr_t session_id,
+                                 const char *symbols_dir,
+                                 const char *layout_path,
+                                 RaptorQWriteCallback write_callback,
+                                 void *context);
+
+/**
+ * Decodes RaptorQ symbols and streams the original data to a callback, reporting
+ * the progress after each block
+ *
+ * As raptorq_decode_to_writer, blocks are decoded one by one and their data is passed
+ * to `write_callback` in order. Once a block is written, `progress_callback` receives
+ * the number of bytes written so far and the size of the original data. On error, the
+ * data of the blocks decoded before may have been written already.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `symbols_dir` - Directory containing the symbols
+ * * `layout_path` - Path to the layout file
+ * * `write_callback` - Callback receiving the decoded data
+ * * `context` - Opaque pointer passed to every call of the write callback
+ * * `progress_callback` - Callback receiving the progress after each block
+ * * `progress_context` - Opaque pointer passed to every call of the progress callback
+ *
+ * Returns:
+ * *   0 on success
+ * *  -1 on generic error
+ * *  -2 on invalid parameters
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error (including a write error of the callback)
+ * * -12 on File not found
+ * * -13 on Invalid Path
+ * * -15 on Decoding failed
+ * * -17 on Concurrency limit reached
+ * * -18 on Insufficient symbols (see raptorq_get_last_shortfalls)
+ * * -22 if the symbols directory holds the layout of another object
+ */
+int32_t raptorq_decode_to_writer_with_progress(uintptr_t session_id,
+                                               const char *symbols_dir,
+                                               const char *layout_path,
+                                               RaptorQWriteCallback write_callback,
+                                               void *context,
+                                               RaptorQProgressCallback progress_callback,
+                                               void *progress_context);
+
+/**
+ * Decodes RaptorQ symbols and writes each block at its offset through a callback
+ *
+ * Blocks are decoded in parallel and passed to `write_callback` at their original
+ * offset as they complete, in any order, so the destination can be a preallocated
+ * file or a memory mapping. The callback is only called from the calling thread.
+ * On error, the data of the blocks decoded before may have been written already.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `symbols_dir` - Directory containing the symbols
+ * * `layout_path` - Path to the layout file
+ * * `write_callback` - Callback writing the decoded data at an offset
+ * * `context` - Opaque pointer passed to every call of the callback
+ *
+ * Returns:
+ * *   0 on success
+ * *  -1 on generic error
+ * *  -2 on invalid parameters
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error (including a write error of the callback)
+ * * -12 on File not found
+ * * -13 on Invalid Path
+ * * -15 on Decoding failed (including blocks not adding up to the size of the data)
+ * * -17 on Concurrency limit reached
+ * * -18 on Insufficient symbols (see raptorq_get_last_shortfalls)
+ * * -22 if the symbols directory holds the layout of another object
+ */
+int32_t raptorq_decode_to_writer_at(uintptr_t session_id,
+                                    const char *symbols_dir,
+                                    const char *layout_path,
+                                    RaptorQWriteAtCallback write_callback,
+                                    void *context);
+
+/**
+ * Decodes a byte range of the original data and streams it to a callback
+ *
+ * Only the blocks overlapping `[offset, offset + length)` are decoded, and exactly
+ * the bytes of the range are passed to `write_callback`, in order. This serves
+ * range requests on large objects without decoding them entirely.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `symbols_dir` - Directory containing the symbols
+ * * `layout_path` - Path to the layout file
+ * * `offset` - Offset of the range in the original data
+ * * `length` - Length of the range, 0 writes nothing
+ * * `write_callback` - Callback receiving the decoded range
+ * * `context` - Opaque pointer passed to every call of the callback
+ *
+ * Returns:
+ * *   0 on success
+ * *  -1 on generic error
+ * *  -2 on invalid parameters, including a range ending past the data
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error (including a write error of the callback)
+ * * -12 on File not found
+ * * -13 on Invalid Path
+ * * -15 on Decoding failed
+ * * -17 on Concurrency limit reached
+ * * -18 on Insufficient symbols (see raptorq_get_last_shortfalls)
+ * * -22 if the symbols directory holds the layout of another object
+ */
+int32_t raptorq_decode_range(uintptr_t session_id,
+                             const char *symbols_dir,
+                             const char *layout_path,
+                             uint64_t offset,
+                             uint64_t length,
+                             RaptorQWriteCallback write_callback,
+                             void *context);
+
+/**
+ * Decodes RaptorQ symbols pulled one by one from a callback and streams the
+ * original data to a callback
+ *
+ * Symbols can be given in any order, each with the id of its block, for example
+ * as they are fetched from a remote storage. `symbol_callback` is not called any
+ * more once every block is decoded, so the remaining symbols don't need to be
+ * fetched. Symbols not in the layout of their block are ignored. Decoded blocks
+ * are written in order; a block is held in memory until the ones before it are written.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `symbol_callback` - Callback giving the next symbol
+ * * `symbol_context` - Opaque pointer passed to every call of the symbol callback
+ * * `layout_path` - Path to the layout file
+ * * `write_callback` - Callback receiving the decoded data
+ * * `write_context` - Opaque pointer passed to every call of the write callback
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error (including an error of a callback)
+ * * -12 if the layout file is not found
+ * * -15 on Decoding failed
+ * * -17 on Concurrency limit reached
+ * * -18 on Insufficient symbols when the symbols run out or the limit set with
+ *       raptorq_set_max_source_symbols is reached (see raptorq_get_last_shortfalls)
+ * * -19 if the session was cancelled
+ */
+int32_t raptorq_decode_from_source(uintptr_t session_id,
+                                   RaptorQSymbolCallback symbol_callback,
+                                   void *symbol_context,
+                                   const char *layout_path,
+                                   RaptorQWriteCallback write_callback,
+                                   void *write_context);
+
+/**
+ * Decodes symbols and a layout read through a callback and streams the original
+ * data to a callback
+ *
+ * Same as raptorq_decode_to_writer, with every file read through
+ * `file_callback` instead of the local filesystem, for example from an embedded
+ * filesystem or an object storage bucket. The symbols of a block are read from
+ * `<symbols_dir>/block_<id>/<symbol_id>`, or `<symbols_dir>/<symbol_id>` if the first
+ * one is missing.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `file_callback` - Callback reading a file of the store
+ * * `file_context` - Opaque pointer passed to every call of the file callback
+ * * `symbols_dir` - Path of the symbols directory in the store, "." for its root
+ * * `layout_path` - Path of the layout file in the store
+ * * `write_callback` - Callback receiving the decoded data
+ * * `write_context` - Opaque pointer passed to every call of the write callback
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error (including an error of a callback)
+ * * -12 if the store has no layout at `layout_path`
+ * * -15 on Decoding failed
+ * * -17 on Concurrency limit reached
+ * * -18 on Insufficient symbols (see raptorq_get_last_shortfalls)
+ * * -19 if the session was cancelled
+ */
+int32_t raptorq_decode_from_store(uintptr_t session_id,
+                                  RaptorQFileCallback file_callback,
+                                  void *file_context,
+                                  const char *symbols_dir,
+                                  const char *layout_path,
+                                  RaptorQWriteCallback write_callback,
+                                  void *write_context);
+
+/**
+ * Gets the version and build of the library
+ *
+ * The result is a JSON object `{"version", "build_hash", "abi_version"}`: the semantic
+ * version, the commit the library was built from and the version of the C interface.
+ * A program can compare `abi_version` to the RAPTORQ_ABI_VERSION of the header it was
+ * built with to detect a mismatched library when it starts.
+ *
+ * Arguments:
+ * * `result_buffer` - Buffer to store the result (JSON)
+ * * `result_buffer_len` - Length of the result buffer
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size
+ */
+int32_t raptorq_version_info(char *result_buffer, uintptr_t result_buffer_len);
+
+/**
+ * Gets the path of a symbol relative to the symbols directory
+ *
+ * Symbols are stored as `block_<block_id>/<symbol_id>`, the symbol id being
+ * the Base58 encoded BLAKE3 hash of the symbol as listed in the layout. The names
+ * don't carry the ESI, see `raptorq_get_symbol_esi` to read it from the symbol.
+ *
+ * Arguments:
+ * * `block_id` - Identifier of the block of the symbol
+ * * `symbol_id` - Identifier of the symbol
+ * * `path_buffer` - Buffer to store the path
+ * * `path_buffer_len` - Length of the path buffer
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters
+ * *  -4 if the buffer is too small
  */
+int32_t raptorq_get_symbol_path(uintptr_t block_id,
+                                const char *symbol_id,
+                                char *path_buffer,
+                                uintptr_t path_buffer_len);
 
+/**
+ * Parses the path of a symbol into its block id and symbol id
+ *
+ * Only the last two components are used, so the path may include the symbols directory.
+ *
+ * Arguments:
+ * * `path` - Path of the symbol, `[...]/block_<block_id>/<symbol_id>`
+ * * `block_id` - Receives the block id
+ * * `symbol_id_buffer` - Buffer to store the symbol id
+ * * `symbol_id_buffer_len` - Length of the symbol id buffer
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters
+ * *  -4 if the buffer is too small
+ * * -13 if the path is not a symbol path
+ */
+int32_t raptorq_parse_symbol_path(const char *path,
+                                  uintptr_t *block_id,
+                                  char *symbol_id_buffer,
+                                  uintptr_t symbol_id_buffer_len);
 
-#ifndef RQ_LIB_H
-#define RQ_LIB_H
-
-/* Generated with cbindgen:0.28.0 */
-
-#include <stdint.h>
-#include <stdbool.h>
-
-#ifdef __cplusplus
-namespace RQLibrary {
-#endif  // __cplusplus
+/**
+ * Checks a path before passing it to the other functions
+ *
+ * The functions taking paths return -2 for a path that is empty or isn't valid UTF-8
+ * and -13 for one that can't be opened: longer than 4095 bytes or with a name longer
+ * than 255 bytes. This function returns the same code and tells why, so wrappers can
+ * report a clear error instead of a bare code. Spaces and any Unicode character are fine.
+ *
+ * Arguments:
+ * * `path` - Path to check
+ * * `error_buffer` - Receives the reason the path is rejected, empty if it isn't (can be NULL)
+ * * `error_buffer_len` - Length of the error buffer
+ *
+ * Returns:
+ * *   0 if the path can be used
+ * *  -2 if the path is NULL, empty or not valid UTF-8
+ * *  -4 if the error buffer is too small
+ * * -13 if the path is rejected
+ */
+int32_t raptorq_check_path(const char *path, char *error_buffer, uintptr_t error_buffer_len);
 
-#ifdef __cplusplus
-extern "C" {
-#endif // __cplusplus
+/**
+ * Gets the encoding symbol ID (ESI) of a symbol from its FEC payload ID
+ *
+ * The ESIs number the symbols of each source block, the first byte of the symbol
+ * numbering its source block: the ESIs below the number of source symbols of the
+ * source block are source symbols, the others repair symbols. A block has a single
+ * source block unless it holds more source symbols than a source block can.
+ *
+ * Arguments:
+ * * `symbol` - Symbol data
+ * * `symbol_len` - Length of the symbol data
+ * * `esi` - Receives the encoding symbol ID
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters, including a symbol shorter than its payload ID
+ */
+int32_t raptorq_get_symbol_esi(const uint8_t *symbol, uintptr_t symbol_len, uint32_t *esi);
 
 /**
- * Initializes a RaptorQ session with the given configuration
- * Returns a session ID on success, or 0 on failure
+ * Gets the id of a symbol, the name of its file and its entry in the layouts
+ *
+ * Arguments:
+ * * `symbol` - Symbol data, with its FEC payload ID
+ * * `symbol_len` - Length of the symbol data
+ * * `result_buffer` - Buffer to store the id
+ * * `result_buffer_len` - Length of the result buffer
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters
+ * *  -4 on bad return buffer size
  */
-uintptr_t raptorq_init_session(uint16_t symbol_size,
-                               uint8_t redundancy_factor,
-                               uint64_t max_memory_mb,
-                               uint64_t concurrency_limit);
+int32_t raptorq_get_symbol_id(const uint8_t *symbol,
+                              uintptr_t symbol_len,
+                              char *result_buffer,
+                              uintptr_t result_buffer_len);
 
 /**
- * Frees a RaptorQ session
+ * Builds the layout of an object encoded by a standard RaptorQ encoder as one RaptorQ
+ * object per block, from the OTI of its blocks
+ *
+ * The OTIs are a JSON array in the format of raptorq_get_oti, in the order of the
+ * data of the blocks; the 12 bytes of each `oti` are used and the other fields must
+ * match them. The result is the layout JSON, whose blocks list no symbols and have no
+ * hash: add the id of each symbol (see raptorq_get_symbol_id) to the `symbols` of its
+ * block, source symbols first, and store the symbols under these names to decode them.
+ *
+ * Arguments:
+ * * `oti_json` - JSON array of the OTIs of the blocks
+ * * `object_size` - Size of the object, the sum of the transfer lengths of the blocks
+ * * `result_buffer` - Buffer to store the layout JSON
+ * * `result_buffer_len` - Length of the result buffer
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters, including malformed OTIs or OTIs not adding up to the
+ *       object size
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size
  */
-bool raptorq_free_session(uintptr_t session_id);
+int32_t raptorq_oti_to_layout(const char *oti_json,
+                              uint64_t object_size,
+                              char *result_buffer,
+                              uintptr_t result_buffer_len);
 
 /**
- * Encodes a file using RaptorQ - streaming implementation
+ * Parses a layout file without a session
+ *
+ * The result is a JSON object with the `layout_version` of the layout file (0 for
+ * layouts written before the format was versioned), the `total_size` of the original
+ * data, the `symbols_count` of all blocks and the `blocks`, each with its `block_id`,
+ * `original_offset`, `size`, `symbol_size`, `symbols_count`, `source_symbols_count`
+ * and `repair_symbols_count`, and the `metadata` of the application if the layout
+ * has any (see raptorq_set_layout_metadata).
  *
  * Arguments:
- * * `session_id` - Session ID returned from raptorq_init_session
- * * `input_path` - Path to the input file
- * * `output_dir` - Directory where symbols will be written
- * * `block_size` - Size of blocks to process at once (0 = auto)
- * * `result_buffer` - Buffer to store the result (JSON metadata)
+ * * `layout_path` - Path to the layout file
+ * * `result_buffer` - Buffer to store the JSON object
  * * `result_buffer_len` - Length of the result buffer
  *
  * Returns:
  * *   0 on success
- * *  -1 on generic error
  * *  -2 on invalid parameters
  * *  -3 on invalid response
  * *  -4 on bad return buffer size
- * *  -4 on encoding failure
- * *  -5 on invalid session
- * * -11 on IO error
  * * -12 on File not found
- * * -13 on Invalid path
- * * -14 on Encoding failed
- * * -16 on Memory limit exceeded
- * * -17 on Concurrency limit reached
+ * * -15 if the layout can't be read or parsed
+ * * -23 if the layout is of a newer version than the library supports
  */
-int32_t raptorq_create_metadata(uintptr_t session_id,
-                                const char *input_path,
-                                const char *layout_file,
-                                uintptr_t block_size,
-                                char *result_buffer,
-                                uintptr_t result_buffer_len);
+int32_t raptorq_parse_layout(const char *layout_path,
+                             char *result_buffer,
+                             uintptr_t result_buffer_len);
 
 /**
- * Encodes a file using RaptorQ - streaming implementation
+ * Parses the content of a layout without a session
+ *
+ * Same as raptorq_parse_layout for a layout held in memory, such as the
+ * `layout_content` returned by raptorq_create_metadata with an empty layout file,
+ * in the JSON or binary format.
  *
  * Arguments:
- * * `session_id` - Session ID returned from raptorq_init_session
- * * `input_path` - Path to the input file
- * * `output_dir` - Directory where symbols will be written
- * * `block_size` - Size of blocks to process at once (0 = auto)
- * * `result_buffer` - Buffer to store the result (JSON metadata)
+ * * `layout` - Content of the layout
+ * * `layout_len` - Length of the layout content
+ * * `result_buffer` - Buffer to store the JSON object
  * * `result_buffer_len` - Length of the result buffer
  *
  * Returns:
  * *   0 on success
- * *  -1 on generic error
  * *  -2 on invalid parameters
  * *  -3 on invalid response
  * *  -4 on bad return buffer size
- * *  -4 on encoding failure
- * *  -5 on invalid session
+ * * -15 if the layout can't be parsed
+ * * -23 if the layout is of a newer version than the library supports
+ */
+int32_t raptorq_parse_layout_content(const uint8_t *layout,
+                                     uintptr_t layout_len,
+                                     char *result_buffer,
+                                     uintptr_t result_buffer_len);
+
+/**
+ * Upgrades a layout file to the version of the layouts written by this library
+ *
+ * The file is replaced if it is of an older version, by a file written and synced next
+ * to it, and left as it is if it is already current. Layouts of older versions still decode, this is for the
+ * tools reading layout files that expect the current version.
+ *
+ * Arguments:
+ * * `layout_path` - Path to the layout file
+ *
+ * Returns:
+ * *   0 on success, whether the layout was upgraded or already current
+ * *  -2 on invalid parameters
  * * -11 on IO error
  * * -12 on File not found
- * * -13 on Invalid Path
- * * -14 on Encoding failed
- * * -16 on Memory limit exceeded
- * * -17 on Concurrency limit reached
+ * * -15 if the layout can't be read or parsed
+ * * -23 if the layout is of a newer version than the library supports
  */
-int32_t raptorq_encode_file(uintptr_t session_id,
-                            const char *input_path,
-                            const char *output_dir,
-                            uintptr_t block_size,
-                            char *result_buffer,
-                            uintptr_t result_buffer_len);
+int32_t raptorq_migrate_layout(const char *layout_path);
 
 /**
- * Gets the last error message from the processor
+ * Gets the configuration of a session
  *
  * Arguments:
  * * `session_id` - Session ID returned from raptorq_init_session
- * * `error_buffer` - Buffer to store the error message
- * * `error_buffer_len` - Length of the error buffer
+ * * `symbol_size` - Receives the symbol size (can be NULL)
+ * * `redundancy_factor` - Receives the redundancy factor (can be NULL)
+ * * `max_memory_mb` - Receives the memory limit in MB (can be NULL)
+ * * `concurrency_limit` - Receives the concurrency limit (can be NULL)
  *
  * Returns:
- * * 0 on success
- * * -1 on error
+ * *   0 on success
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
  */
-int32_t raptorq_get_last_error(uintptr_t session_id,
-                               char *error_buffer,
-                               uintptr_t error_buffer_len);
+int32_t raptorq_get_config(uintptr_t session_id,
+                           uint16_t *symbol_size,
+                           uint8_t *redundancy_factor,
+                           uint64_t *max_memory_mb,
+                           uint64_t *concurrency_limit);
 
 /**
- * Decodes RaptorQ symbols back to the original file
+ * Gets the number of task slots of a session free right now, out of its concurrency limit
+ *
+ * Parallel operations run their extra workers on free slots only, so the threads of a
+ * session never exceed its concurrency limit. A program running its own workers, e.g.
+ * a goroutine per file, can size them with this count. Operations of other threads may
+ * take the slots at any time, so it is a hint, not a reservation.
  *
  * Arguments:
  * * `session_id` - Session ID returned from raptorq_init_session
- * * `symbols_dir` - Directory containing the symbols
- * * `output_path` - Path where the decoded file will be written
- * * `layout_path` - Path to the layout file (containing encoder parameters and block information)
+ * * `available_slots` - Receives the number of free task slots
  *
  * Returns:
  * *   0 on success
- * *  -1 on generic error
  * *  -2 on invalid parameters
- * *  -3 on invalid response
- * *  -4 on bad return buffer size
- * *  -4 on encoding failure
  * *  -5 on invalid session
- * * -11 on IO error
- * * -12 on File not found
- * * -13 on Invalid Path
- * * -15 on Decoding failed
- * * -16 on Memory limit exceeded
- * * -17 on Concurrency limit reached
+ * *  -7 if the session was created by a previous load of the library
  */
-int32_t raptorq_decode_symbols(uintptr_t session_id,
-                               const char *symbols_dir,
-                               const char *output_path,
-                               const char *layout_path);
+int32_t raptorq_get_available_task_slots(uintptr_t session_id, uint64_t *available_slots);
 
 /**
  * Gets a recommended block size based on file size and available memory
@@ -157,6 +3112,200 @@ int32_t raptorq_decode_symbols(uintptr_t session_id,
  */
 uintptr_t raptorq_get_recommended_block_size(uintptr_t session_id, uint64_t file_size);
 
+/**
+ * Gets the largest block size the session can encode
+ *
+ * It depends on the symbol size, about 14 GB with 1024-byte symbols, and on the
+ * platform. Encodes with a larger block size fail with -2, while recommended block
+ * sizes never exceed it.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ *
+ * Returns:
+ * * Largest block size in bytes
+ * * 0 on error
+ */
+uint64_t raptorq_get_max_block_size(uintptr_t session_id);
+
+/**
+ * Gets a recommended block size based on file size, available memory and cores
+ *
+ * With several cores the file is split into a block per core, capped by the session's
+ * concurrency limit, but not smaller than 4 MiB, so the blocks can be encoded in
+ * parallel; with one core it is split only if it doesn't fit in the memory. Blocks
+ * are small enough for each core to hold one in the memory.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `file_size` - Size of the file to process
+ * * `available_memory_mb` - Memory available in MB (0 = the session's memory limit)
+ * * `cores` - Number of cores available (0 = the cores of the host)
+ *
+ * Returns:
+ * * Recommended block size in bytes
+ * * 0 if it should not block or on error
+ */
+uintptr_t raptorq_get_recommended_block_size_for(uintptr_t session_id,
+                                                 uint64_t file_size,
+                                                 uint64_t available_memory_mb,
+                                                 uint32_t cores);
+
+/**
+ * Gets a recommended redundancy factor for a file, given the fraction of its
+ * symbols expected to be lost
+ *
+ * Symbols are assumed to be lost independently, the factor is the smallest one
+ * giving each block its source symbols plus a small overhead with a failure
+ * probability around one in a million. It is clamped to 255.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `file_size` - Size of the file to process
+ * * `expected_loss_fraction` - Expected fraction of lost symbols, in [0, 1)
+ * * `redundancy_factor` - Receives the recommended redundancy factor
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters, including an empty file or a fraction out of range
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ */
+int32_t raptorq_get_recommended_redundancy(uintptr_t session_id,
+                                           uint64_t file_size,
+                                           double expected_loss_fraction,
+                                           uint8_t *redundancy_factor);
+
+/**
+ * Estimates the peak memory, in bytes, used to encode a file of the given size
+ *
+ * The estimate covers the largest block with the RaptorQ working memory and all of
+ * its symbols. Each operation of the session needs its own memory, so up to the
+ * concurrency limit times the estimate is used when operations run in parallel.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `file_size` - Size of the file to encode
+ * * `block_size` - Size of blocks the file will be encoded with (0 = recommended)
+ * * `peak_memory` - Receives the estimated peak memory in bytes
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters, including an empty file
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ */
+int32_t raptorq_estimate_peak_memory(uintptr_t session_id,
+                                     uint64_t file_size,
+                                     uintptr_t block_size,
+                                     uint64_t *peak_memory);
+
+/**
+ * Estimates the storage used by the symbol files of a file of the given size
+ *
+ * Covers the source and repair symbols of every block with their payload IDs, before
+ * compression, and the nonce and tag of each file with a symbol key. The layout file
+ * is not included.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `file_size` - Size of the file to encode
+ * * `block_size` - Size of blocks the file will be encoded with (0 = recommended)
+ * * `symbol_bytes` - Receives the bytes of all symbol files
+ * * `overhead_fraction` - Receives the bytes stored in addition to the file, as a
+ *   fraction of its size (can be NULL)
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters, including an empty file
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ */
+int32_t raptorq_estimate_storage(uintptr_t session_id,
+                                 uint64_t file_size,
+                                 uintptr_t block_size,
+                                 uint64_t *symbol_bytes,
+                                 double *overhead_fraction);
+
+/**
+ * Plans how a file would be encoded, without reading its data nor writing symbols
+ *
+ * The result is a JSON object in the format of raptorq_parse_layout: the `total_size`,
+ * the `symbols_count` and the `blocks` the file would be split into, with their symbol
+ * counts. Much cheaper than raptorq_create_metadata, which encodes every block.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `input_path` - Path to the input file
+ * * `block_size` - Size of blocks the file would be encoded with (0 = auto)
+ * * `result_buffer` - Buffer to store the JSON object
+ * * `result_buffer_len` - Length of the result buffer
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters
+ * *  -3 on invalid response
+ * *  -4 on bad return buffer size
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error
+ * * -12 on File not found
+ * * -13 on Invalid path
+ */
+int32_t raptorq_plan_encode(uintptr_t session_id,
+                            const char *input_path,
+                            uintptr_t block_size,
+                            char *result_buffer,
+                            uintptr_t result_buffer_len);
+
+/**
+ * Describes how a file would be encoded, as lines of text for logs and support
+ *
+ * The plan of raptorq_plan_encode with the parameters the encoder would use: the
+ * settings of the session, then the RaptorQ parameters, symbol counts and padding of
+ * each block. Meant to be read, the format may change; programs use raptorq_plan_encode.
+ *
+ * Arguments:
+ * * `session_id` - Session ID returned from raptorq_init_session
+ * * `input_path` - Path to the input file
+ * * `block_size` - Size of blocks the file would be encoded with (0 = auto)
+ * * `result_buffer` - Buffer to store the description
+ * * `result_buffer_len` - Length of the result buffer
+ *
+ * Returns:
+ * *   0 on success
+ * *  -2 on invalid parameters
+ * *  -4 on bad return buffer size
+ * *  -5 on invalid session
+ * *  -7 if the session was created by a previous load of the library
+ * * -11 on IO error
+ * * -12 on File not found
+ * * -13 on Invalid path
+ */
+int32_t raptorq_describe_encode(uintptr_t session_id,
+                                const char *input_path,
+                                uintptr_t block_size,
+                                char *result_buffer,
+                                uintptr_t result_buffer_len);
+
+/**
+ * Checks that the library works by encoding a small buffer in memory and decoding it back
+ *
+ * A cheap check for readiness probes that runs the encoder and the decoder, see
+ * `self_test`. No session is needed.
+ *
+ * Arguments:
+ * * `error_buffer` - Optional buffer to store the reason of a failure
+ * * `error_buffer_len` - Length of the error buffer
+ *
+ * Returns:
+ * *   0 if the library works
+ * *  -1 on generic error
+ * * -14 on Encoding failed
+ * * -15 on Decoding failed, also if the decoded data differs
+ */
+int32_t raptorq_self_test(char *error_buffer, uintptr_t error_buffer_len);
+
 /**
  * Version information
  */
@@ -0,0 +1,95 @@
+//! Checksums of the symbol files
+//!
+//! A processor with symbol CRCs starts each symbol file with a header of 12 bytes: the
+//! magic `RQS1`, then the length and the CRC32 of the rest of the file, both little
+//! endian. The header covers the file as written, after the compression and encryption
+//! of the symbol, so decodes reject a truncated or bit-flipped file before decrypting or
+//! decoding it, and skip it like a missing symbol for the repair symbols to cover.
+//!
+//! The layout records whether the files have headers, so the symbols of layouts written
+//! without them still decode. Symbol ids remain the hashes of the plain symbols.
+
+use std::io;
+
+const MAGIC: [u8; 4] = *b"RQS1";
+
+/// Header of the symbol files with the length and CRC32 of their content
+pub struct SymbolHeader;
+
+impl SymbolHeader {
+    /// Bytes the header adds to a symbol file
+    pub const LEN: usize = 12;
+
+    /// Content of a symbol file, the header followed by the given content
+    pub fn write(content: &[u8]) -> Vec<u8> {
+        let mut file = Vec::with_capacity(Self::LEN + content.len());
+        file.extend_from_slice(&MAGIC);
+        file.extend_from_slice(&(content.len() as u32).to_le_bytes());
+        file.extend_from_slice(&crc32(content).to_le_bytes());
+        file.extend_from_slice(content);
+        file
+    }
+
+    /// Content of a symbol file after its header, once checked
+    ///
+    /// # Returns
+    /// * `Err(io::ErrorKind::InvalidData)` if the file has no header, is truncated or
+    ///   longer than its header records, or fails the CRC32 check
+    pub fn read(file: &[u8]) -> io::Result<&[u8]> {
+        if file.len() < Self::LEN || file[..4] != MAGIC {
+            return Err(io::Error::new(io::ErrorKind::InvalidData, "Symbol file has no checksum header"));
+        }
+        let len = u32::from_le_bytes(file[4..8].try_into().expect("The header is 12 bytes"));
+        let crc = u32::from_le_bytes(file[8..12].try_into().expect("The header is 12 bytes"));
+        let content = &file[Self::LEN..];
+        if content.len() != len as usize {
+            return Err(io::Error::new(
+                io::ErrorKind::InvalidData,
+                format!("Symbol file holds {} bytes, its header records {}", content.len(), len),
+            ));
+        }
+        if crc32(content) != crc {
+            return Err(io::Error::new(io::ErrorKind::InvalidData, "Symbol file fails its CRC32 check"));
+        }
+        Ok(content)
+    }
+}
+
+fn crc32(data: &[u8]) -> u32 {
+    let mut crc = flate2::Crc::new();
+    crc.update(data);
+    crc.sum()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_symbol_header_round_trip() {
+        let content: Vec<u8> = (0..1028).map(|i| (i * 31 / 7 % 251) as u8).collect();
+        let file = SymbolHeader::write(&content);
+        assert_eq!(file.len(), content.len() + SymbolHeader::LEN);
+        assert!(file.starts_with(b"RQS1"));
+        assert_eq!(SymbolHeader::read(&file).unwrap(), &content[..]);
+        assert_eq!(SymbolHeader::read(&SymbolHeader::write(&[])).unwrap(), &[] as &[u8]);
+    }
+
+    #[test]
+    fn test_symbol_header_rejects_corruption() {
+        let file = SymbolHeader::write(b"symbol data");
+        for index in [0, 4, 8, SymbolHeader::LEN, file.len() - 1] {
+            let mut flipped = file.clone();
+            flipped[index] ^= 1;
+            assert_eq!(SymbolHeader::read(&flipped).unwrap_err().kind(), io::ErrorKind::InvalidData, "{}", index);
+        }
+        assert!(SymbolHeader::read(&file[..file.len() - 1]).is_err());
+        assert!(SymbolHeader::read(&[file.as_slice(), b"!"].concat()).is_err());
+        assert!(SymbolHeader::read(b"symbol data").is_err());
+    }
+
+    #[test]
+    fn test_crc32() {
+        assert_eq!(crc32(b"123456789"), 0xcbf43926);
+    }
+}
@@ -0,0 +1,118 @@
+//! Compression of the symbol files
+//!
+//! A processor with a `SymbolCodec` compresses each symbol when it writes its file,
+//! and the layout records the codec so decodes decompress the symbols they read. Symbol
+//! ids are the hashes of the uncompressed symbols, so they don't depend on the codec.
+//! Symbols of random data don't compress, structured data (logs, JSON) shrinks a lot.
+
+use serde::{Deserialize, Serialize};
+use std::io::{self, Read, Write};
+
+/// Compression of the symbol files of an object
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default, Serialize, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum SymbolCodec {
+    /// Symbols are written as they are, the default
+    #[default]
+    None,
+    Gzip,
+    /// Not available in the browser, where it fails with `io::ErrorKind::Unsupported`
+    Zstd,
+}
+
+impl SymbolCodec {
+    pub fn is_none(&self) -> bool {
+        *self == SymbolCodec::None
+    }
+
+    /// Compress a symbol for its file
+    pub fn compress(&self, symbol: &[u8]) -> io::Result<Vec<u8>> {
+        match self {
+            SymbolCodec::None => Ok(symbol.to_vec()),
+            SymbolCodec::Gzip => {
+                let mut encoder = flate2::write::GzEncoder::new(Vec::new(), flate2::Compression::default());
+                encoder.write_all(symbol)?;
+                encoder.finish()
+            },
+            #[cfg(not(target_arch = "wasm32"))]
+            SymbolCodec::Zstd => zstd::encode_all(symbol, 0),
+            #[cfg(target_arch = "wasm32")]
+            SymbolCodec::Zstd => Err(zstd_unsupported()),
+        }
+    }
+
+    /// Decompress the content of a symbol file, of at most `limit` bytes once decompressed
+    ///
+    /// Symbol files come from untrusted storage, the limit stops a small file that
+    /// decompresses to gigabytes before it is held in memory. Content decompressing to
+    /// more than `limit` bytes fails with `io::ErrorKind::InvalidData`.
+    pub fn decompress(&self, content: &[u8], limit: usize) -> io::Result<Vec<u8>> {
+        let mut symbol = Vec::new();
+        match self {
+            SymbolCodec::None => symbol.extend_from_slice(content),
+            SymbolCodec::Gzip => {
+                flate2::read::GzDecoder::new(content).take(limit as u64 + 1).read_to_end(&mut symbol)?;
+            },
+            #[cfg(not(target_arch = "wasm32"))]
+            SymbolCodec::Zstd => {
+                zstd::stream::read::Decoder::new(content)?.take(limit as u64 + 1).read_to_end(&mut symbol)?;
+            },
+            #[cfg(target_arch = "wasm32")]
+            SymbolCodec::Zstd => return Err(zstd_unsupported()),
+        }
+        if symbol.len() > limit {
+            return Err(io::Error::new(
+                io::ErrorKind::InvalidData,
+                format!("Symbol decompresses to more than {} bytes", limit),
+            ));
+        }
+        Ok(symbol)
+    }
+}
+
+#[cfg(target_arch = "wasm32")]
+fn zstd_unsupported() -> io::Error {
+    io::Error::new(io::ErrorKind::Unsupported, "zstd symbols are not supported in the browser")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_symbol_codec_round_trip() {
+        let symbol: Vec<u8> = b"{\"level\":\"info\",\"message\":\"ok\"}".repeat(32);
+        for codec in [SymbolCodec::None, SymbolCodec::Gzip, SymbolCodec::Zstd] {
+            let content = codec.compress(&symbol).unwrap();
+            assert_eq!(codec.decompress(&content, symbol.len()).unwrap(), symbol, "{:?}", codec);
+        }
+        assert_eq!(SymbolCodec::None.compress(&symbol).unwrap(), symbol);
+        assert!(SymbolCodec::Gzip.compress(&symbol).unwrap().starts_with(&[0x1f, 0x8b]));
+        assert!(SymbolCodec::Zstd.compress(&symbol).unwrap().starts_with(&[0x28, 0xb5, 0x2f, 0xfd]));
+    }
+
+    #[test]
+    fn test_symbol_codec_rejects_other_content() {
+        assert!(SymbolCodec::Gzip.decompress(b"not compressed", 1024).is_err());
+        assert!(SymbolCodec::Zstd.decompress(b"not compressed", 1024).is_err());
+    }
+
+    #[test]
+    fn test_symbol_codec_bounds_decompression() {
+        // Content decompressing past the limit is rejected, whatever its compression ratio
+        let bomb = vec![0u8; 1 << 20];
+        for codec in [SymbolCodec::None, SymbolCodec::Gzip, SymbolCodec::Zstd] {
+            let content = codec.compress(&bomb).unwrap();
+            let err = codec.decompress(&content, 1024).unwrap_err();
+            assert_eq!(err.kind(), io::ErrorKind::InvalidData, "{:?}", codec);
+            assert_eq!(codec.decompress(&content, bomb.len()).unwrap(), bomb, "{:?}", codec);
+        }
+    }
+
+    #[test]
+    fn test_symbol_codec_serialization() {
+        assert_eq!(serde_json::to_string(&SymbolCodec::Gzip).unwrap(), "\"gzip\"");
+        assert_eq!(serde_json::from_str::<SymbolCodec>("\"zstd\"").unwrap(), SymbolCodec::Zstd);
+        assert!(SymbolCodec::default().is_none());
+    }
+}
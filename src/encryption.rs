@@ -0,0 +1,172 @@
+//! Encryption of the symbol files
+//!
+//! A processor with a symbol key encrypts each symbol file with AES-GCM, AES-128 or
+//! AES-256 depending on the length of the key, after compressing it (see the `codec`
+//! module). The tag authenticates the symbol, so a tampered file fails to decrypt and
+//! is skipped like a missing symbol.
+//!
+//! Nonces are 96 bits, the first 12 bytes of the BLAKE3 hash of the symbol keyed with
+//! a key derived from the symbol key. Distinct symbols get distinct nonces, while
+//! encoding the same symbol again writes the same file, so `encode_block` reproduces
+//! the files of an encode. A file holds the nonce followed by the ciphertext and the
+//! 16-byte tag.
+//!
+//! Encryption hides the content of the symbols, not their ids. Symbol ids, which name
+//! the symbol files and are listed in the layout, remain the unkeyed BLAKE3 hashes of
+//! the plain symbols, and the block hashes of the layout are unkeyed as well, so
+//! decodes find and verify symbols the same way with or without a key. Anyone seeing
+//! the file names or the layout can therefore confirm a guess of the content: encoding
+//! a candidate file with the same parameters gives the same ids. They can also tell
+//! which symbols and blocks are identical across objects. Applications for which
+//! this leak matters have to encrypt their data before encoding it.
+//!
+//! The layout records the id of the key, which is derived from it and doesn't reveal
+//! it, so a decode with a missing or wrong key fails at once. The layout itself isn't
+//! encrypted: it lists the sizes, offsets and hashes of the blocks but none of the
+//! data, and can be stored apart from the symbols in the clear, or encrypted by the
+//! application like any other file.
+
+use aes_gcm::aead::{Aead, KeyInit};
+use aes_gcm::{Aes128Gcm, Aes256Gcm, Nonce};
+use std::fmt;
+use std::io;
+
+const NONCE_LEN: usize = 12;
+const TAG_LEN: usize = 16;
+// Contexts deriving the keys of the nonces and of the key id from the symbol key
+const NONCE_KEY_CONTEXT: &str = "rq-library symbol encryption nonce key";
+const KEY_ID_CONTEXT: &str = "rq-library symbol encryption key id";
+
+#[derive(Clone)]
+enum Cipher {
+    Aes128(Aes128Gcm),
+    Aes256(Aes256Gcm),
+}
+
+/// Encrypts and decrypts symbols with a key of 16 or 32 bytes
+#[derive(Clone)]
+pub struct SymbolCipher {
+    cipher: Cipher,
+    nonce_key: [u8; 32],
+    key_id: String,
+}
+
+impl SymbolCipher {
+    /// Bytes an encrypted symbol file holds in addition to the symbol: its nonce and tag
+    pub const OVERHEAD: usize = NONCE_LEN + TAG_LEN;
+
+    /// Create the cipher of a key
+    ///
+    /// # Returns
+    /// * `Err(String)` if the key is neither 16 bytes (AES-128) nor 32 bytes (AES-256) long
+    pub fn new(key: &[u8]) -> Result<Self, String> {
+        let cipher = match key.len() {
+            16 => Aes128Gcm::new_from_slice(key).ok().map(Cipher::Aes128),
+            32 => Aes256Gcm::new_from_slice(key).ok().map(Cipher::Aes256),
+            _ => None,
+        };
+        let Some(cipher) = cipher else {
+            return Err(format!("symbol key must be 16 or 32 bytes, got {}", key.len()));
+        };
+        let key_id = blake3::derive_key(KEY_ID_CONTEXT, key);
+        Ok(Self {
+            cipher,
+            nonce_key: blake3::derive_key(NONCE_KEY_CONTEXT, key),
+            key_id: bs58::encode(&key_id[..16]).into_string(),
+        })
+    }
+
+    /// Id of the key recorded in the layouts, which doesn't reveal the key
+    pub fn key_id(&self) -> &str {
+        &self.key_id
+    }
+
+    /// Encrypt a symbol, or its compressed content, for its file
+    pub fn encrypt(&self, symbol: &[u8]) -> io::Result<Vec<u8>> {
+        let nonce_hash = blake3::keyed_hash(&self.nonce_key, symbol);
+        let nonce = Nonce::from_slice(&nonce_hash.as_bytes()[..NONCE_LEN]);
+        let ciphertext = match &self.cipher {
+            Cipher::Aes128(cipher) => cipher.encrypt(nonce, symbol),
+            Cipher::Aes256(cipher) => cipher.encrypt(nonce, symbol),
+        }
+        .map_err(|_| io::Error::new(io::ErrorKind::Other, "Failed to encrypt the symbol"))?;
+
+        let mut content = Vec::with_capacity(NONCE_LEN + ciphertext.len());
+        content.extend_from_slice(&nonce_hash.as_bytes()[..NONCE_LEN]);
+        content.extend_from_slice(&ciphertext);
+        Ok(content)
+    }
+
+    /// Decrypt the content of a symbol file
+    ///
+    /// # Returns
+    /// * `Err(io::ErrorKind::InvalidData)` if the file is too short, was tampered
+    ///   with or encrypted with another key
+    pub fn decrypt(&self, content: &[u8]) -> io::Result<Vec<u8>> {
+        if content.len() < NONCE_LEN + TAG_LEN {
+            return Err(io::Error::new(io::ErrorKind::InvalidData, "Encrypted symbol is truncated"));
+        }
+        let (nonce, ciphertext) = content.split_at(NONCE_LEN);
+        let nonce = Nonce::from_slice(nonce);
+        match &self.cipher {
+            Cipher::Aes128(cipher) => cipher.decrypt(nonce, ciphertext),
+            Cipher::Aes256(cipher) => cipher.decrypt(nonce, ciphertext),
+        }
+        .map_err(|_| io::Error::new(io::ErrorKind::InvalidData, "Symbol failed authentication"))
+    }
+}
+
+// The key stays out of logs
+impl fmt::Debug for SymbolCipher {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.debug_struct("SymbolCipher").field("key_id", &self.key_id).finish_non_exhaustive()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_symbol_cipher_round_trip() {
+        let symbol: Vec<u8> = (0..1028).map(|i| (i * 31 / 7 % 251) as u8).collect();
+        for key in [vec![7u8; 16], vec![7u8; 32]] {
+            let cipher = SymbolCipher::new(&key).unwrap();
+            let content = cipher.encrypt(&symbol).unwrap();
+            assert_eq!(content.len(), symbol.len() + SymbolCipher::OVERHEAD);
+            assert_ne!(&content[NONCE_LEN..NONCE_LEN + symbol.len()], &symbol[..]);
+            assert_eq!(cipher.decrypt(&content).unwrap(), symbol);
+
+            // The same symbol gives the same file, another symbol another nonce
+            assert_eq!(cipher.encrypt(&symbol).unwrap(), content);
+            let other = cipher.encrypt(&symbol[1..]).unwrap();
+            assert_ne!(&other[..NONCE_LEN], &content[..NONCE_LEN]);
+        }
+    }
+
+    #[test]
+    fn test_symbol_cipher_rejects_tampering() {
+        let cipher = SymbolCipher::new(&[1u8; 32]).unwrap();
+        let content = cipher.encrypt(b"symbol data").unwrap();
+        for index in [0, NONCE_LEN, content.len() - 1] {
+            let mut tampered = content.clone();
+            tampered[index] ^= 1;
+            assert_eq!(cipher.decrypt(&tampered).unwrap_err().kind(), io::ErrorKind::InvalidData);
+        }
+        assert!(cipher.decrypt(&content[..NONCE_LEN + TAG_LEN - 1]).is_err());
+
+        let other = SymbolCipher::new(&[2u8; 32]).unwrap();
+        assert!(other.decrypt(&content).is_err());
+        assert_ne!(other.key_id(), cipher.key_id());
+    }
+
+    #[test]
+    fn test_symbol_cipher_key_length() {
+        for len in [0, 8, 24, 33] {
+            assert!(SymbolCipher::new(&vec![0u8; len]).is_err(), "{} bytes", len);
+        }
+        let cipher = SymbolCipher::new(&[9u8; 16]).unwrap();
+        assert_eq!(cipher.key_id(), SymbolCipher::new(&[9u8; 16]).unwrap().key_id());
+        assert!(!format!("{:?}", cipher).contains("9, 9"));
+    }
+}
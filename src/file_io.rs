@@ -5,7 +5,15 @@
 //! - `FileWriter`: For efficient, chunked file writing
 //! - `DirManager`: For directory creation
 //!
-//! Implementations are provided in platform-specific modules.
+//! `SequentialReader` and `SequentialWriter` adapt them to `std::io::Read` and `std::io::Write`.
+//! `ReadAt` reads through a shared reference, for sources read from several threads,
+//! and `WriteAt` writes through one, for destinations filled at given offsets.
+//!
+//! Implementations are provided in platform-specific modules,
+//! plus a platform-independent in-memory reader.
+pub mod memory;
+pub use memory::MemoryFileReader;
+
 #[cfg(all(not(target_arch = "wasm32"), not(feature = "browser-wasm")))]
 pub mod native;
 #[cfg(all(not(target_arch = "wasm32"), not(feature = "browser-wasm")))]
@@ -16,9 +24,10 @@ pub mod wasm;
 #[cfg(all(target_arch = "wasm32", feature = "browser-wasm"))]
 pub use wasm::*;
 
+use std::io;
 
 /// Trait for platform-abstracted, memory-efficient file reading.
-pub trait FileReader {
+pub trait FileReader: Send {
     /// Returns the total size of the file in bytes.
     fn file_size(&self) -> Result<u64, String>;
 
@@ -34,6 +43,11 @@ pub trait FileWriter {
 
     /// Flushes any buffered data to the file (optional for buffered writers).
     fn flush(&mut self) -> Result<(), String>;
+
+    /// Flushes the data through to the storage device, defaults to `flush`.
+    fn sync(&mut self) -> Result<(), String> {
+        self.flush()
+    }
 }
 
 /// Trait for platform-abstracted directory creation.
@@ -46,6 +60,150 @@ pub trait DirManager {
 
     /// Returns the number of files in the given directory.
     fn count_files(&self, path: &str) -> Result<usize, String>;
+
+    /// Returns the names of the files in the given directory, sorted.
+    fn list_files(&self, path: &str) -> Result<Vec<String>, String> {
+        Err(format!("Listing the directory {:?} is not supported on this platform", path))
+    }
+
+    /// Removes a directory with all of its content, succeeds if there is none.
+    fn remove_dir_all(&self, path: &str) -> Result<(), String> {
+        Err(format!("Removing the directory {:?} is not supported on this platform", path))
+    }
+
+    /// Removes a file, succeeds if there is none.
+    fn remove_file(&self, path: &str) -> Result<(), String> {
+        Err(format!("Removing the file {:?} is not supported on this platform", path))
+    }
+
+    /// Renames a file, replacing the destination if it exists.
+    fn rename(&self, from: &str, to: &str) -> Result<(), String> {
+        Err(format!("Renaming the file {:?} to {:?} is not supported on this platform", from, to))
+    }
+}
+
+/// Reads a `FileReader` from the start to the end, as `io::Read`.
+pub struct SequentialReader {
+    reader: Box<dyn FileReader>,
+    offset: u64,
+}
+
+impl SequentialReader {
+    pub fn new(reader: Box<dyn FileReader>) -> Self {
+        Self { reader, offset: 0 }
+    }
+}
+
+impl io::Read for SequentialReader {
+    fn read(&mut self, buf: &mut [u8]) -> io::Result<usize> {
+        let n = self.reader.read_chunk(self.offset, buf)
+            .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
+        self.offset += n as u64;
+        Ok(n)
+    }
+}
+
+/// Writes to a `FileWriter` from the start, as `io::Write`.
+pub struct SequentialWriter {
+    writer: Box<dyn FileWriter>,
+    offset: usize,
+}
+
+impl SequentialWriter {
+    pub fn new(writer: Box<dyn FileWriter>) -> Self {
+        Self { writer, offset: 0 }
+    }
+}
+
+impl io::Write for SequentialWriter {
+    fn write(&mut self, buf: &[u8]) -> io::Result<usize> {
+        self.writer.write_chunk(self.offset, buf)
+            .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
+        self.offset += buf.len();
+        Ok(buf.len())
+    }
+
+    fn flush(&mut self) -> io::Result<()> {
+        self.writer.flush().map_err(|e| io::Error::new(io::ErrorKind::Other, e))
+    }
+}
+
+/// Random-access reading through a shared reference, so disjoint ranges can be
+/// read from several threads at once, like `std::os::unix::fs::FileExt`.
+pub trait ReadAt {
+    /// Reads bytes at the given offset, returns the number of bytes read, 0 at the end.
+    fn read_at(&self, offset: u64, buf: &mut [u8]) -> io::Result<usize>;
+
+    /// Reads exactly `buf.len()` bytes at the given offset,
+    /// `io::ErrorKind::UnexpectedEof` if the data ends before.
+    fn read_exact_at(&self, mut offset: u64, mut buf: &mut [u8]) -> io::Result<()> {
+        while !buf.is_empty() {
+            match self.read_at(offset, buf) {
+                Ok(0) => return Err(io::Error::new(io::ErrorKind::UnexpectedEof, format!("No data at offset {}", offset))),
+                Ok(n) => {
+                    offset += n as u64;
+                    buf = &mut buf[n..];
+                },
+                Err(e) if e.kind() == io::ErrorKind::Interrupted => {},
+                Err(e) => return Err(e),
+            }
+        }
+        Ok(())
+    }
+}
+
+impl<R: ReadAt + ?Sized> ReadAt for &R {
+    fn read_at(&self, offset: u64, buf: &mut [u8]) -> io::Result<usize> {
+        (**self).read_at(offset, buf)
+    }
+}
+
+impl ReadAt for [u8] {
+    fn read_at(&self, offset: u64, buf: &mut [u8]) -> io::Result<usize> {
+        if offset >= self.len() as u64 {
+            return Ok(0);
+        }
+        let start = offset as usize;
+        let end = std::cmp::min(start + buf.len(), self.len());
+        buf[..end - start].copy_from_slice(&self[start..end]);
+        Ok(end - start)
+    }
+}
+
+impl ReadAt for Vec<u8> {
+    fn read_at(&self, offset: u64, buf: &mut [u8]) -> io::Result<usize> {
+        self.as_slice().read_at(offset, buf)
+    }
+}
+
+/// Random-access writing through a shared reference, so disjoint ranges can be
+/// written in any order, like `std::os::unix::fs::FileExt`.
+pub trait WriteAt {
+    /// Writes bytes at the given offset, returns the number of bytes written.
+    fn write_at(&self, offset: u64, buf: &[u8]) -> io::Result<usize>;
+
+    /// Writes all of `buf` at the given offset,
+    /// `io::ErrorKind::WriteZero` if the destination stops accepting bytes.
+    fn write_all_at(&self, mut offset: u64, mut buf: &[u8]) -> io::Result<()> {
+        while !buf.is_empty() {
+            match self.write_at(offset, buf) {
+                Ok(0) => return Err(io::Error::new(io::ErrorKind::WriteZero, format!("No byte written at offset {}", offset))),
+                Ok(n) => {
+                    offset += n as u64;
+                    buf = &buf[n..];
+                },
+                Err(e) if e.kind() == io::ErrorKind::Interrupted => {},
+                Err(e) => return Err(e),
+            }
+        }
+        Ok(())
+    }
+}
+
+impl<W: WriteAt + ?Sized> WriteAt for &W {
+    fn write_at(&self, offset: u64, buf: &[u8]) -> io::Result<usize> {
+        (**self).write_at(offset, buf)
+    }
 }
 
 /// Opens a platform-appropriate file reader.
@@ -84,6 +242,26 @@ pub fn open_file_writer(path: &str) -> Result<Box<dyn FileWriter>, String> {
     Ok(Box::new(wasm::BrowserFileWriter::new(path)))
 }
 
+/// Creates a new private file and opens a platform-appropriate writer of it.
+///
+/// On native platforms, fails with `io::ErrorKind::AlreadyExists` if the path exists,
+/// and the file is readable by its owner only on Unix.
+/// On WASM/browser, uses the JavaScript file system API.
+#[cfg(all(not(target_arch = "wasm32"), not(feature = "browser-wasm")))]
+pub fn create_new_file_writer(path: &str) -> io::Result<Box<dyn FileWriter>> {
+    Ok(Box::new(native::NativeFileWriter::create_new(path)?))
+}
+
+/// Creates a new private file and opens a platform-appropriate writer of it.
+///
+/// On native platforms, fails with `io::ErrorKind::AlreadyExists` if the path exists,
+/// and the file is readable by its owner only on Unix.
+/// On WASM/browser, uses the JavaScript file system API.
+#[cfg(all(target_arch = "wasm32", feature = "browser-wasm"))]
+pub fn create_new_file_writer(path: &str) -> io::Result<Box<dyn FileWriter>> {
+    Ok(Box::new(wasm::BrowserFileWriter::new(path)))
+}
+
 /// Creates a platform-appropriate directory manager.
 /// 
 /// On native platforms, uses std::fs.
@@ -160,6 +338,69 @@ mod tests {
         remove_file(&path).unwrap();
     }
 
+    #[test]
+    fn test_memory_reader() {
+        let data = b"abcdefghij";
+        let mut reader = MemoryFileReader::new(data);
+        assert_eq!(reader.file_size().unwrap(), data.len() as u64);
+        let mut buf = [0u8; 4];
+        assert_eq!(reader.read_chunk(0, &mut buf).unwrap(), 4);
+        assert_eq!(&buf, b"abcd");
+        assert_eq!(reader.read_chunk(8, &mut buf).unwrap(), 2);
+        assert_eq!(&buf[..2], b"ij");
+        assert_eq!(reader.read_chunk(10, &mut buf).unwrap(), 0);
+    }
+
+    #[test]
+    fn test_sequential_reader_and_writer() {
+        use std::io::Read;
+
+        let data: Vec<u8> = (0..10_000).map(|i| (i % 251) as u8).collect();
+        let path = write_test_file(b"");
+        let mut writer = SequentialWriter::new(open_file_writer(&path).unwrap());
+        for chunk in data.chunks(777) {
+            writer.write_all(chunk).unwrap();
+        }
+        writer.flush().unwrap();
+        drop(writer);
+
+        let mut reader = SequentialReader::new(open_file_reader(&path).unwrap());
+        let mut read_back = Vec::new();
+        reader.read_to_end(&mut read_back).unwrap();
+        assert_eq!(read_back, data);
+        remove_file(&path).unwrap();
+    }
+
+    #[test]
+    fn test_read_at() {
+        let data = b"abcdefghij";
+        let mut buf = [0u8; 4];
+        assert_eq!(data.as_slice().read_at(8, &mut buf).unwrap(), 2);
+        assert_eq!(&buf[..2], b"ij");
+        assert_eq!(data.to_vec().read_at(10, &mut buf).unwrap(), 0);
+
+        let path = write_test_file(data);
+        let file = std::fs::File::open(&path).unwrap();
+        file.read_exact_at(3, &mut buf).unwrap();
+        assert_eq!(&buf, b"defg");
+        let err = file.read_exact_at(8, &mut buf).unwrap_err();
+        assert_eq!(err.kind(), io::ErrorKind::UnexpectedEof);
+        drop(file);
+        remove_file(&path).unwrap();
+    }
+
+    #[test]
+    fn test_list_files() {
+        let dir = tempfile::tempdir().unwrap();
+        for name in ["b", "a", "c"] {
+            std::fs::write(dir.path().join(name), name).unwrap();
+        }
+        std::fs::create_dir(dir.path().join("subdir")).unwrap();
+        let dir_manager = get_dir_manager();
+        assert_eq!(dir_manager.list_files(&dir.path().to_string_lossy()).unwrap(), ["a", "b", "c"]);
+        assert!(dir_manager.list_files(&dir.path().join("missing").to_string_lossy()).is_err());
+    }
+
     #[test]
     fn test_trait_object_usage() {
         let data = b"trait object test";
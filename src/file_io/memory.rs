@@ -0,0 +1,30 @@
+//! In-memory implementation of the FileReader trait, shared by all platforms.
+
+use super::FileReader;
+
+/// Implementation of FileReader over a borrowed byte slice.
+pub struct MemoryFileReader<'a> {
+    data: &'a [u8],
+}
+
+impl<'a> MemoryFileReader<'a> {
+    pub fn new(data: &'a [u8]) -> Self {
+        Self { data }
+    }
+}
+
+impl<'a> FileReader for MemoryFileReader<'a> {
+    fn file_size(&self) -> Result<u64, String> {
+        Ok(self.data.len() as u64)
+    }
+
+    fn read_chunk(&mut self, offset: u64, buf: &mut [u8]) -> Result<usize, String> {
+        if offset >= self.data.len() as u64 {
+            return Ok(0);
+        }
+        let start = offset as usize;
+        let end = std::cmp::min(start + buf.len(), self.data.len());
+        buf[..end - start].copy_from_slice(&self.data[start..end]);
+        Ok(end - start)
+    }
+}
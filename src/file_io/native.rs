@@ -4,7 +4,7 @@ use std::fs::{File, OpenOptions};
 use std::io::{Read, Seek, SeekFrom, Write};
 use std::path::Path;
 
-use super::{FileReader, FileWriter, DirManager};
+use super::{FileReader, FileWriter, DirManager, ReadAt, WriteAt};
 
 /// Native implementation of FileReader using std::fs::File.
 pub struct NativeFileReader {
@@ -32,6 +32,36 @@ impl FileReader for NativeFileReader {
     }
 }
 
+#[cfg(unix)]
+impl ReadAt for File {
+    fn read_at(&self, offset: u64, buf: &mut [u8]) -> std::io::Result<usize> {
+        std::os::unix::fs::FileExt::read_at(self, buf, offset)
+    }
+}
+
+// The file position moves with each read, which doesn't matter when the file
+// is only read at given offsets
+#[cfg(windows)]
+impl ReadAt for File {
+    fn read_at(&self, offset: u64, buf: &mut [u8]) -> std::io::Result<usize> {
+        std::os::windows::fs::FileExt::seek_read(self, buf, offset)
+    }
+}
+
+#[cfg(unix)]
+impl WriteAt for File {
+    fn write_at(&self, offset: u64, buf: &[u8]) -> std::io::Result<usize> {
+        std::os::unix::fs::FileExt::write_at(self, buf, offset)
+    }
+}
+
+#[cfg(windows)]
+impl WriteAt for File {
+    fn write_at(&self, offset: u64, buf: &[u8]) -> std::io::Result<usize> {
+        std::os::windows::fs::FileExt::seek_write(self, buf, offset)
+    }
+}
+
 /// Native implementation of FileWriter using std::fs::File.
 pub struct NativeFileWriter {
     file: File,
@@ -47,6 +77,18 @@ impl NativeFileWriter {
             .map_err(|e| e.to_string())?;
         Ok(Self { file })
     }
+
+    /// Creates a new file, readable and writable by its owner only on Unix
+    ///
+    /// Fails with `io::ErrorKind::AlreadyExists` if the path exists, a symlink included,
+    /// so nothing planted at the path is written through.
+    pub fn create_new(path: &str) -> std::io::Result<Self> {
+        let mut options = OpenOptions::new();
+        options.write(true).create_new(true);
+        #[cfg(unix)]
+        std::os::unix::fs::OpenOptionsExt::mode(&mut options, 0o600);
+        Ok(Self { file: options.open(path)? })
+    }
 }
 
 impl FileWriter for NativeFileWriter {
@@ -58,6 +100,11 @@ impl FileWriter for NativeFileWriter {
     fn flush(&mut self) -> Result<(), String> {
         self.file.flush().map_err(|e| e.to_string())
     }
+
+    fn sync(&mut self) -> Result<(), String> {
+        self.file.flush().map_err(|e| e.to_string())?;
+        self.file.sync_all().map_err(|e| e.to_string())
+    }
 }
 
 /// Native implementation of DirManager using std::fs::create_dir_all.
@@ -88,4 +135,37 @@ impl DirManager for NativeDirManager {
         }
         Ok(count)
     }
+
+    fn list_files(&self, path: &str) -> Result<Vec<String>, String> {
+        let entries = std::fs::read_dir(Path::new(path))
+            .map_err(|e| format!("Failed to read directory: {}", e))?;
+
+        let mut names = Vec::new();
+        for entry in entries {
+            let entry = entry.map_err(|e| format!("Failed to access directory entry: {}", e))?;
+            if entry.path().is_file() {
+                names.push(entry.file_name().to_string_lossy().to_string());
+            }
+        }
+        names.sort();
+        Ok(names)
+    }
+
+    fn remove_dir_all(&self, path: &str) -> Result<(), String> {
+        match std::fs::remove_dir_all(path) {
+            Err(e) if e.kind() != std::io::ErrorKind::NotFound => Err(e.to_string()),
+            _ => Ok(()),
+        }
+    }
+
+    fn remove_file(&self, path: &str) -> Result<(), String> {
+        match std::fs::remove_file(path) {
+            Err(e) if e.kind() != std::io::ErrorKind::NotFound => Err(e.to_string()),
+            _ => Ok(()),
+        }
+    }
+
+    fn rename(&self, from: &str, to: &str) -> Result<(), String> {
+        std::fs::rename(from, to).map_err(|e| e.to_string())
+    }
 }
\ No newline at end of file
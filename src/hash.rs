@@ -0,0 +1,105 @@
+//! Hash of the content of the encoded objects
+//!
+//! Encodes hash the source as they read it, so applications get the hash of an object
+//! without reading it again. SHA-256 is the default and is recorded in the layout as
+//! `object_sha256`, which `decode_symbols_checked` checks and which tells the symbols of
+//! different objects apart. BLAKE3 is several times faster on large objects but is only
+//! returned in the result, and the hash can be disabled to save its cost altogether.
+
+use serde::{Deserialize, Serialize};
+use sha2::{Digest, Sha256};
+
+/// Hash of the source computed by the encodes
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default, Serialize, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum ContentHash {
+    /// The source isn't hashed, the layouts have no `object_sha256`
+    None,
+    /// SHA-256, the default, recorded in the layouts as `object_sha256`
+    #[default]
+    Sha256,
+    /// BLAKE3, returned in the results only
+    Blake3,
+}
+
+impl ContentHash {
+    pub fn is_none(&self) -> bool {
+        *self == ContentHash::None
+    }
+
+    // Hasher of the source of an encode
+    pub(crate) fn hasher(&self) -> ContentHasher {
+        match self {
+            ContentHash::None => ContentHasher::None,
+            ContentHash::Sha256 => ContentHasher::Sha256(Sha256::new()),
+            ContentHash::Blake3 => ContentHasher::Blake3(Box::new(blake3::Hasher::new())),
+        }
+    }
+}
+
+// Running hash of the source of an encode, fed in the order of the offsets
+pub(crate) enum ContentHasher {
+    None,
+    Sha256(Sha256),
+    Blake3(Box<blake3::Hasher>),
+}
+
+impl ContentHasher {
+    pub(crate) fn content_hash(&self) -> ContentHash {
+        match self {
+            ContentHasher::None => ContentHash::None,
+            ContentHasher::Sha256(_) => ContentHash::Sha256,
+            ContentHasher::Blake3(_) => ContentHash::Blake3,
+        }
+    }
+
+    pub(crate) fn update(&mut self, data: &[u8]) {
+        match self {
+            ContentHasher::None => {},
+            ContentHasher::Sha256(hasher) => hasher.update(data),
+            ContentHasher::Blake3(hasher) => {
+                hasher.update(data);
+            },
+        }
+    }
+
+    // Hash in lowercase hex, empty if the source isn't hashed
+    pub(crate) fn finalize_hex(self) -> String {
+        match self {
+            ContentHasher::None => String::new(),
+            ContentHasher::Sha256(hasher) => hasher.finalize().iter().map(|b| format!("{:02x}", b)).collect(),
+            ContentHasher::Blake3(hasher) => hasher.finalize().to_hex().to_string(),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_content_hasher() {
+        let data: Vec<u8> = (0..10000).map(|i| (i * 31 / 7 % 251) as u8).collect();
+        for content_hash in [ContentHash::None, ContentHash::Sha256, ContentHash::Blake3] {
+            let mut hasher = content_hash.hasher();
+            assert_eq!(hasher.content_hash(), content_hash);
+            for chunk in data.chunks(3000) {
+                hasher.update(chunk);
+            }
+            let expected = match content_hash {
+                ContentHash::None => String::new(),
+                ContentHash::Sha256 => Sha256::digest(&data).iter().map(|b| format!("{:02x}", b)).collect(),
+                ContentHash::Blake3 => blake3::hash(&data).to_hex().to_string(),
+            };
+            assert_eq!(hasher.finalize_hex(), expected, "{:?}", content_hash);
+        }
+    }
+
+    #[test]
+    fn test_content_hash_serialization() {
+        assert_eq!(serde_json::to_string(&ContentHash::Blake3).unwrap(), "\"blake3\"");
+        assert_eq!(serde_json::from_str::<ContentHash>("\"none\"").unwrap(), ContentHash::None);
+        assert_eq!(ContentHash::default(), ContentHash::Sha256);
+        assert!(!ContentHash::default().is_none());
+    }
+}
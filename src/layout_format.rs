@@ -0,0 +1,285 @@
+//! Serialization of the layout files
+//!
+//! Layouts are written as pretty-printed JSON by default. Objects with thousands of
+//! blocks have layouts of megabytes, mostly quoted symbol ids and indentation; the
+//! binary format holds the same data without them, for a smaller file that parses
+//! faster (see the `parse_layout_10k_blocks_json` and `parse_layout_10k_blocks_binary`
+//! benchmarks). It starts with the magic `RQLB`, which no
+//! JSON document starts with, so `RaptorQLayout::parse` reads both formats whatever the
+//! format of the processor.
+//!
+//! After the magic comes the layout version as a little endian u32, which versions the
+//! binary format as well: the fields are the ones of the layouts of that version. Then
+//! come the fields of the layout in the order of `RaptorQLayout`, with the blocks in the
+//! order of `BlockLayout`. Integers, lengths and counts are LEB128 varints, strings and
+//! byte arrays are their length followed by their bytes, booleans and the symbol codec
+//! are a byte.
+
+use crate::codec::SymbolCodec;
+use crate::processor::{BlockLayout, RaptorQLayout};
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeMap;
+
+const MAGIC: [u8; 4] = *b"RQLB";
+// Fewest bytes a block takes: its id, encoder parameters, offset, size, symbol count
+// and hash, each at least a byte
+const MIN_BLOCK_LEN: usize = 6;
+// Fewest bytes an entry of the metadata takes: the lengths of its key and value
+const MIN_METADATA_ENTRY_LEN: usize = 2;
+
+/// Format of the layout files written by a processor
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default, Serialize, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum LayoutFormat {
+    /// Pretty-printed JSON, the default, readable by any JSON tool
+    #[default]
+    Json,
+    /// Compact binary encoding of the same data, see the module documentation
+    Binary,
+}
+
+impl LayoutFormat {
+    /// Format of the content of a layout file, told by its first bytes
+    pub fn detect(content: &[u8]) -> Self {
+        if content.starts_with(&MAGIC) {
+            LayoutFormat::Binary
+        } else {
+            LayoutFormat::Json
+        }
+    }
+}
+
+/// Binary encoding of a layout
+pub fn encode_binary(layout: &RaptorQLayout) -> Vec<u8> {
+    let symbols_len: usize = layout.blocks.iter()
+        .flat_map(|b| &b.symbols)
+        .map(|s| s.len() + 1)
+        .sum();
+    let mut out = Vec::with_capacity(64 + layout.blocks.len() * 64 + symbols_len);
+    out.extend_from_slice(&MAGIC);
+    out.extend_from_slice(&layout.layout_version.to_le_bytes());
+
+    write_varint(&mut out, layout.blocks.len() as u64);
+    for block in &layout.blocks {
+        write_varint(&mut out, block.block_id as u64);
+        write_bytes(&mut out, &block.encoder_parameters);
+        write_varint(&mut out, block.original_offset);
+        write_varint(&mut out, block.size);
+        write_varint(&mut out, block.symbols.len() as u64);
+        for symbol_id in &block.symbols {
+            write_bytes(&mut out, symbol_id.as_bytes());
+        }
+        write_bytes(&mut out, block.hash.as_bytes());
+    }
+
+    write_bytes(&mut out, layout.object_sha256.as_bytes());
+    out.push(match layout.symbol_codec {
+        SymbolCodec::None => 0,
+        SymbolCodec::Gzip => 1,
+        SymbolCodec::Zstd => 2,
+    });
+    write_bytes(&mut out, layout.symbol_key_id.as_bytes());
+    out.push(layout.symbol_crc as u8);
+    write_varint(&mut out, layout.metadata.len() as u64);
+    for (key, value) in &layout.metadata {
+        write_bytes(&mut out, key.as_bytes());
+        write_bytes(&mut out, value.as_bytes());
+    }
+    out
+}
+
+/// Layout version of a binary layout, None if the content is not one
+pub fn binary_version(content: &[u8]) -> Option<u32> {
+    if !content.starts_with(&MAGIC) {
+        return None;
+    }
+    let version = content.get(MAGIC.len()..MAGIC.len() + 4)?;
+    Some(u32::from_le_bytes(version.try_into().expect("The version is 4 bytes")))
+}
+
+/// Layout of a binary layout file, whose version was checked by the caller
+///
+/// # Returns
+/// * `Err` with the reason if the content has no magic, is truncated, has bytes past
+///   the layout or holds an invalid value
+pub fn decode_binary(content: &[u8]) -> Result<RaptorQLayout, String> {
+    let layout_version = binary_version(content).ok_or("Layout has no binary header")?;
+    let mut reader = Reader { content, offset: MAGIC.len() + 4 };
+
+    // The counts are not trusted to size the vectors, a block takes far more memory
+    // than the bytes bounding its count
+    let block_count = reader.count(MIN_BLOCK_LEN)?;
+    let mut blocks = Vec::new();
+    for _ in 0..block_count {
+        let block_id = reader.varint()? as usize;
+        let encoder_parameters = reader.bytes()?.to_vec();
+        let original_offset = reader.varint()?;
+        let size = reader.varint()?;
+        let symbol_count = reader.count(1)?;
+        let mut symbols = Vec::new();
+        for _ in 0..symbol_count {
+            symbols.push(reader.string()?);
+        }
+        let hash = reader.string()?;
+        blocks.push(BlockLayout { block_id, encoder_parameters, original_offset, size, symbols, hash });
+    }
+
+    let object_sha256 = reader.string()?;
+    let symbol_codec = match reader.byte()? {
+        0 => SymbolCodec::None,
+        1 => SymbolCodec::Gzip,
+        2 => SymbolCodec::Zstd,
+        codec => return Err(format!("Unknown symbol codec {}", codec)),
+    };
+    let symbol_key_id = reader.string()?;
+    let symbol_crc = match reader.byte()? {
+        0 => false,
+        1 => true,
+        value => return Err(format!("Invalid symbol CRC flag {}", value)),
+    };
+    let mut metadata = BTreeMap::new();
+    for _ in 0..reader.count(MIN_METADATA_ENTRY_LEN)? {
+        let key = reader.string()?;
+        metadata.insert(key, reader.string()?);
+    }
+
+    if reader.offset != content.len() {
+        return Err(format!("{} bytes past the end of the layout", content.len() - reader.offset));
+    }
+    Ok(RaptorQLayout { layout_version, blocks, object_sha256, symbol_codec, symbol_key_id, symbol_crc, metadata })
+}
+
+fn write_varint(out: &mut Vec<u8>, mut value: u64) {
+    while value >= 0x80 {
+        out.push(value as u8 | 0x80);
+        value >>= 7;
+    }
+    out.push(value as u8);
+}
+
+fn write_bytes(out: &mut Vec<u8>, bytes: &[u8]) {
+    write_varint(out, bytes.len() as u64);
+    out.extend_from_slice(bytes);
+}
+
+// Reads the fields of a binary layout from its start
+struct Reader<'a> {
+    content: &'a [u8],
+    offset: usize,
+}
+
+impl<'a> Reader<'a> {
+    fn byte(&mut self) -> Result<u8, String> {
+        let byte = *self.content.get(self.offset).ok_or("Layout is truncated")?;
+        self.offset += 1;
+        Ok(byte)
+    }
+
+    fn varint(&mut self) -> Result<u64, String> {
+        let mut value = 0u64;
+        for shift in (0..64).step_by(7) {
+            let byte = self.byte()?;
+            value |= ((byte & 0x7f) as u64) << shift;
+            if byte & 0x80 == 0 {
+                return Ok(value);
+            }
+        }
+        Err(format!("Integer at offset {} is too long", self.offset))
+    }
+
+    // Number of items following, each taking at least `item_len` bytes, so a corrupt
+    // count fails before the decode loops over items the content can't hold
+    fn count(&mut self, item_len: usize) -> Result<usize, String> {
+        let count = self.varint()?;
+        if count > ((self.content.len() - self.offset) / item_len) as u64 {
+            return Err(format!("Count {} at offset {} exceeds the layout", count, self.offset));
+        }
+        Ok(count as usize)
+    }
+
+    fn bytes(&mut self) -> Result<&'a [u8], String> {
+        let len = self.count(1)?;
+        let bytes = &self.content[self.offset..self.offset + len];
+        self.offset += len;
+        Ok(bytes)
+    }
+
+    fn string(&mut self) -> Result<String, String> {
+        let offset = self.offset;
+        String::from_utf8(self.bytes()?.to_vec())
+            .map_err(|e| format!("String at offset {} is invalid UTF-8: {}", offset, e))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::processor::LAYOUT_VERSION;
+
+    fn test_layout() -> RaptorQLayout {
+        RaptorQLayout {
+            layout_version: LAYOUT_VERSION,
+            blocks: (0..3).map(|block_id| BlockLayout {
+                block_id,
+                encoder_parameters: (0..12).map(|i| (i * 31 / 7 % 251) as u8).collect(),
+                original_offset: block_id as u64 * 300_000,
+                size: 300_000,
+                symbols: (0..block_id * 5).map(|i| format!("symbol{}", i)).collect(),
+                hash: format!("hash{}", block_id),
+            }).collect(),
+            object_sha256: "ab".repeat(32),
+            symbol_codec: SymbolCodec::Zstd,
+            symbol_key_id: "key".to_string(),
+            symbol_crc: true,
+            metadata: BTreeMap::from([("object".to_string(), "é".to_string())]),
+        }
+    }
+
+    #[test]
+    fn test_binary_layout_round_trip() {
+        let layout = test_layout();
+        let content = encode_binary(&layout);
+        assert!(content.starts_with(b"RQLB"));
+        assert_eq!(LayoutFormat::detect(&content), LayoutFormat::Binary);
+        assert_eq!(binary_version(&content), Some(LAYOUT_VERSION));
+        assert_eq!(decode_binary(&content).unwrap(), layout);
+        assert!(content.len() < serde_json::to_vec(&layout).unwrap().len());
+
+        let empty = RaptorQLayout { blocks: Vec::new(), metadata: BTreeMap::new(), ..layout };
+        assert_eq!(decode_binary(&encode_binary(&empty)).unwrap(), empty);
+        assert_eq!(LayoutFormat::detect(b"{\"blocks\":[]}"), LayoutFormat::Json);
+    }
+
+    #[test]
+    fn test_binary_layout_rejects_corruption() {
+        let content = encode_binary(&test_layout());
+        for len in [0, 4, 8, 20, content.len() - 1] {
+            assert!(decode_binary(&content[..len]).is_err(), "{}", len);
+        }
+        assert!(decode_binary(&[content.as_slice(), &[0]].concat()).unwrap_err().contains("past the end"));
+
+        // A huge block count is rejected before allocating
+        let mut huge = content[..8].to_vec();
+        write_varint(&mut huge, u64::MAX >> 1);
+        assert!(decode_binary(&huge).unwrap_err().contains("exceeds"));
+
+        // So is a count of more blocks than the bytes left can hold
+        let mut short = content[..8].to_vec();
+        write_varint(&mut short, 10);
+        short.extend_from_slice(&[0; 20]);
+        assert!(decode_binary(&short).unwrap_err().contains("Count 10 at offset 9 exceeds"));
+    }
+
+    #[test]
+    fn test_varint() {
+        for value in [0, 1, 127, 128, 300, u32::MAX as u64, u64::MAX] {
+            let mut out = Vec::new();
+            write_varint(&mut out, value);
+            let mut reader = Reader { content: &out, offset: 0 };
+            assert_eq!(reader.varint().unwrap(), value);
+            assert_eq!(reader.offset, out.len());
+        }
+        let mut reader = Reader { content: &[0xff; 11], offset: 0 };
+        assert!(reader.varint().is_err());
+    }
+}
@@ -1,12 +1,38 @@
 pub mod processor;
 pub mod file_io;
+pub mod pool;
+pub mod logging;
+pub mod metrics;
+pub mod store;
+pub mod codec;
+pub mod hash;
+pub mod encryption;
+pub mod checksum;
+pub mod layout_format;
 
 // Import wasm_browser module
 #[cfg(all(target_arch = "wasm32", feature = "browser-wasm"))]
 pub mod wasm_browser;
 
 // Re-export key types for simpler imports
-pub use processor::{ProcessorConfig, RaptorQProcessor, ProcessResult, ProcessError};
+pub use processor::{ProcessorConfig, ProcessorBuilder, RaptorQProcessor, ProcessResult, ProcessError, BlockShortfall, EncodeProgress, FileEncodeJob, BatchEncodeJob, BatchDecodeJob, BlockOti, CorruptSymbol, SymbolFilter, EncodedSymbol};
+pub use processor::{block_dir_name, symbol_path, parse_symbol_path, check_path, symbol_esi, symbol_id};
+pub use processor::{RaptorQLayout, BlockLayout, LayoutSummary, BlockSummary, StorageEstimate, LayoutIssue, LayoutIssueKind, AuditReport, BlockAudit, BlockInventory, ShardMap};
+pub use processor::{
+    LAYOUT_VERSION, DEFAULT_SYMBOL_SIZE_B, DEFAULT_REDUNDANCY_FACTOR, DEFAULT_MAX_MEMORY_MB, DEFAULT_CONCURRENCY_LIMIT, MIN_SYMBOL_SIZE_B, DECODE_SYMBOL_OVERHEAD, REDUNDANCY_Z_SCORE,
+    MAX_MEMORY_MB_1GB, MAX_MEMORY_MB_2GB, MAX_MEMORY_MB_4GB, MAX_MEMORY_MB_8GB, MAX_MEMORY_MB_16GB,
+    MIN_PARALLEL_BLOCK_SIZE_B, MAX_REPAIR_SYMBOLS_PER_BLOCK, MAX_PATH_LEN_B, MAX_PATH_COMPONENT_LEN_B,
+};
+pub use pool::{ProcessorPool, PooledProcessor};
+pub use logging::{ProcessorLogger, NoopLogger, OperationEvent, OperationStage};
+pub use metrics::{ProcessorMetrics, MetricsCollector, MetricsSnapshot};
+pub use store::{SymbolStore, MemoryStore};
+pub use codec::SymbolCodec;
+pub use hash::ContentHash;
+pub use encryption::SymbolCipher;
+pub use checksum::SymbolHeader;
+pub use layout_format::LayoutFormat;
+pub use file_io::{ReadAt, WriteAt};
 
 // Re-export RaptorQSession for WASM builds
 #[cfg(all(target_arch = "wasm32", feature = "browser-wasm"))]
@@ -14,23 +40,215 @@ pub use wasm_browser::browser_wasm::RaptorQSession;
 
 use once_cell::sync::Lazy;
 use parking_lot::Mutex;
-use std::collections::HashMap;
-use std::ffi::{c_char, CStr, CString};
+use std::cell::RefCell;
+use std::collections::{BTreeMap, HashMap, HashSet};
+use std::ffi::{c_char, c_void, CStr, CString};
+use std::io;
+use std::panic::{self, AssertUnwindSafe};
+use std::path::PathBuf;
 use std::ptr;
 use std::sync::atomic::{AtomicUsize, Ordering};
+use std::sync::Arc;
+use std::time::Duration;
+use serde::Serialize;
 
 // Global session counter for unique IDs
 static SESSION_COUNTER: AtomicUsize = AtomicUsize::new(1);
 
+// Bits of the session and job IDs holding the tag of the load of the library
+const LOAD_TAG_BITS: u32 = usize::BITS / 4;
+const LOAD_TAG_SHIFT: u32 = usize::BITS - LOAD_TAG_BITS;
+
+// Random tag of this load of the library, never 0, in the high bits of the session and
+// job IDs: after the library is reloaded, the IDs of the previous load are not taken for
+// the ones of the new load but reported as invalidated, see raptorq_session_status
+static LOAD_TAG: Lazy<usize> = Lazy::new(|| {
+    use std::hash::{BuildHasher, Hasher};
+    let mut hasher = std::collections::hash_map::RandomState::new().build_hasher();
+    hasher.write_usize(&SESSION_COUNTER as *const AtomicUsize as usize);
+    (hasher.finish() as usize) % ((1 << LOAD_TAG_BITS) - 1) + 1
+});
+
+// ID of the given number in this load of the library
+fn tagged_id(number: usize) -> usize {
+    (*LOAD_TAG << LOAD_TAG_SHIFT) | number
+}
+
 // Global processor storage
-static PROCESSORS: Lazy<Mutex<HashMap<usize, RaptorQProcessor>>> = Lazy::new(|| {
+static PROCESSORS: Lazy<Mutex<HashMap<usize, Arc<RaptorQProcessor>>>> = Lazy::new(|| {
     // Initialize logging
     env_logger::init();
     Mutex::new(HashMap::new())
 });
 
+// Return codes: -1 to -7 report a problem with the call itself (arguments, result
+// buffer, session, library), -11 to -23 an operation that failed, see raptorq_get_last_error_detail
+
+/// Success
+pub const RAPTORQ_OK: i32 = 0;
+/// Generic error
+pub const RAPTORQ_ERR_GENERIC: i32 = -1;
+/// Invalid parameters (NULL pointer, invalid UTF-8 string, ...)
+pub const RAPTORQ_ERR_INVALID_PARAMS: i32 = -2;
+/// The result could not be serialized
+pub const RAPTORQ_ERR_INVALID_RESPONSE: i32 = -3;
+/// The result buffer is too small for the result
+pub const RAPTORQ_ERR_BUFFER_TOO_SMALL: i32 = -4;
+/// The session doesn't exist
+pub const RAPTORQ_ERR_INVALID_SESSION: i32 = -5;
+/// The library has another C interface than the program expects, see raptorq_check_abi
+pub const RAPTORQ_ERR_ABI_MISMATCH: i32 = -6;
+/// The session was created by a previous load of the library, see raptorq_session_status
+pub const RAPTORQ_ERR_SESSION_INVALIDATED: i32 = -7;
+/// IO error
+pub const RAPTORQ_ERR_IO: i32 = -11;
+/// File not found
+pub const RAPTORQ_ERR_FILE_NOT_FOUND: i32 = -12;
+/// Invalid path
+pub const RAPTORQ_ERR_INVALID_PATH: i32 = -13;
+/// Encoding failed
+pub const RAPTORQ_ERR_ENCODING_FAILED: i32 = -14;
+/// Decoding failed
+pub const RAPTORQ_ERR_DECODING_FAILED: i32 = -15;
+/// Memory limit exceeded
+pub const RAPTORQ_ERR_MEMORY_LIMIT_EXCEEDED: i32 = -16;
+/// Concurrency limit reached
+pub const RAPTORQ_ERR_CONCURRENCY_LIMIT_REACHED: i32 = -17;
+/// Not enough symbols to decode some blocks
+pub const RAPTORQ_ERR_INSUFFICIENT_SYMBOLS: i32 = -18;
+/// The operation was cancelled with raptorq_cancel
+pub const RAPTORQ_ERR_CANCELLED: i32 = -19;
+/// The symbol is not stored and can't be generated
+pub const RAPTORQ_ERR_SYMBOL_NOT_FOUND: i32 = -20;
+/// The operation ran over the timeout set with raptorq_set_timeout
+pub const RAPTORQ_ERR_TIMED_OUT: i32 = -21;
+/// The symbols directory holds the symbols of another object than the layout
+pub const RAPTORQ_ERR_OBJECT_MISMATCH: i32 = -22;
+/// The layout is of a newer version than the library supports, see raptorq_migrate_layout
+pub const RAPTORQ_ERR_UNSUPPORTED_LAYOUT_VERSION: i32 = -23;
+
+/// Version of the C interface of the library, raised on every change of the functions,
+/// their arguments or their results that breaks the programs built against an older one
+pub const RAPTORQ_ABI_VERSION: u32 = 1;
+
+/// Level of the start of an operation, passed to a log callback
+pub const RAPTORQ_LOG_DEBUG: i32 = 1;
+/// Level of the end of an operation, passed to a log callback
+pub const RAPTORQ_LOG_INFO: i32 = 2;
+
+/// Symbol files written as they are, see raptorq_set_symbol_codec
+pub const RAPTORQ_CODEC_NONE: u32 = 0;
+/// Symbol files compressed with gzip
+pub const RAPTORQ_CODEC_GZIP: u32 = 1;
+/// Symbol files compressed with zstd
+pub const RAPTORQ_CODEC_ZSTD: u32 = 2;
+
+/// Source not hashed by the encodes, see raptorq_set_content_hash
+pub const RAPTORQ_HASH_NONE: u32 = 0;
+/// Source hashed with SHA-256, recorded in the layouts
+pub const RAPTORQ_HASH_SHA256: u32 = 1;
+/// Source hashed with BLAKE3
+pub const RAPTORQ_HASH_BLAKE3: u32 = 2;
+
+/// Layout files written as JSON, see raptorq_set_layout_format
+pub const RAPTORQ_LAYOUT_JSON: u32 = 0;
+/// Layout files written in the compact binary format
+pub const RAPTORQ_LAYOUT_BINARY: u32 = 1;
+
+// Maps a processor error to its FFI return code
+fn error_code(error: &ProcessError) -> i32 {
+    match error {
+        ProcessError::IOError(_) => RAPTORQ_ERR_IO,
+        ProcessError::FileNotFound(_) => RAPTORQ_ERR_FILE_NOT_FOUND,
+        ProcessError::InvalidPath(_) => RAPTORQ_ERR_INVALID_PATH,
+        ProcessError::EncodingFailed(_) => RAPTORQ_ERR_ENCODING_FAILED,
+        ProcessError::DecodingFailed(_) => RAPTORQ_ERR_DECODING_FAILED,
+        ProcessError::InsufficientSymbols(_) => RAPTORQ_ERR_INSUFFICIENT_SYMBOLS,
+        ProcessError::SymbolNotFound { .. } => RAPTORQ_ERR_SYMBOL_NOT_FOUND,
+        ProcessError::MemoryLimitExceeded { .. } => RAPTORQ_ERR_MEMORY_LIMIT_EXCEEDED,
+        ProcessError::ConcurrencyLimitReached => RAPTORQ_ERR_CONCURRENCY_LIMIT_REACHED,
+        ProcessError::Cancelled => RAPTORQ_ERR_CANCELLED,
+        ProcessError::TimedOut(_) => RAPTORQ_ERR_TIMED_OUT,
+        ProcessError::ObjectMismatch { .. } => RAPTORQ_ERR_OBJECT_MISMATCH,
+        ProcessError::UnsupportedLayoutVersion { .. } => RAPTORQ_ERR_UNSUPPORTED_LAYOUT_VERSION,
+        ProcessError::InvalidConfig(_) => RAPTORQ_ERR_INVALID_PARAMS,
+        ProcessError::InvalidParameter(_) => RAPTORQ_ERR_INVALID_PARAMS,
+    }
+}
+
+// Maps an operation error to its FFI return code, recorded as the last error code of the processor
+fn operation_error(processor: &RaptorQProcessor, error: &ProcessError) -> i32 {
+    let code = error_code(error);
+    processor.set_last_error_code(code);
+    code
+}
+
+// Global counter for unique encode job IDs
+static JOB_COUNTER: AtomicUsize = AtomicUsize::new(1);
+
+// File encodings started with raptorq_encode_begin
+static ENCODE_JOBS: Lazy<Mutex<HashMap<usize, Arc<EncodeJobEntry>>>> = Lazy::new(|| Mutex::new(HashMap::new()));
+
+// Global counter for unique pool IDs
+static POOL_COUNTER: AtomicUsize = AtomicUsize::new(1);
+
+// Pools created with raptorq_pool_create
+static POOLS: Lazy<Mutex<HashMap<usize, Arc<PoolEntry>>>> = Lazy::new(|| Mutex::new(HashMap::new()));
+
+// A pool with the sessions acquired from it and not released yet
+struct PoolEntry {
+    pool: ProcessorPool,
+    sessions: Mutex<HashSet<usize>>,
+}
+
+// An encode job with the processor of the session it was started on
+struct EncodeJobEntry {
+    processor: Arc<RaptorQProcessor>,
+    job: Mutex<Option<FileEncodeJob>>,
+    // Result of the finished job, kept until a buffer large enough receives it
+    result: Mutex<Option<CString>>,
+}
+
+// Looks up the processor of a session, without keeping the sessions locked
+// during the operation so other sessions are not blocked
+fn get_processor(session_id: usize) -> Option<Arc<RaptorQProcessor>> {
+    PROCESSORS.lock().get(&session_id).cloned()
+}
+
+// Runs the body of an FFI function, returning `on_panic` if it panics: a panic
+// can't unwind into the caller and would abort the whole process
+fn ffi_guard<T>(on_panic: T, body: impl FnOnce() -> T) -> T {
+    match panic::catch_unwind(AssertUnwindSafe(body)) {
+        Ok(value) => value,
+        Err(payload) => {
+            let message = payload.downcast_ref::<&str>().copied()
+                .or_else(|| payload.downcast_ref::<String>().map(String::as_str))
+                .unwrap_or("unknown panic");
+            log::error!("FFI call panicked: {}", message);
+            on_panic
+        }
+    }
+}
+
+// Reads a path argument, None if it is NULL, not valid UTF-8 or empty
+fn c_path_arg<'a>(path: *const c_char) -> Option<&'a str> {
+    if path.is_null() {
+        return None;
+    }
+    unsafe { CStr::from_ptr(path) }.to_str().ok().filter(|s| !s.is_empty())
+}
+
+// Metrics of the sessions they were enabled on, read by raptorq_get_metrics
+static SESSION_METRICS: Lazy<Mutex<HashMap<usize, Arc<MetricsCollector>>>> = Lazy::new(|| Mutex::new(HashMap::new()));
+
 /// Initializes a RaptorQ session with the given configuration
 /// Returns a session ID on success, or 0 on failure
+///
+/// A session can be used from several threads at once: up to `concurrency_limit`
+/// operations run at the same time, the ones started beyond return -17.
+///
+/// The configuration is rejected if raptorq_validate_config would fail on it.
+/// raptorq_check_abi tells whether the library matches the header beforehand.
 #[unsafe(no_mangle)]
 pub extern "C" fn raptorq_init_session(
     symbol_size: u16,
@@ -38,1115 +256,11655 @@ pub extern "C" fn raptorq_init_session(
     max_memory_mb: u64,
     concurrency_limit: u64,
 ) -> usize {
-    let session_id = SESSION_COUNTER.fetch_add(1, Ordering::SeqCst);
+    ffi_guard(0, || {
+        let config = ProcessorConfig {
+            symbol_size,
+            redundancy_factor,
+            max_memory_mb,
+            concurrency_limit,
+        };
+        if config.validate().is_err() {
+            return 0;
+        }
 
-    let config = ProcessorConfig {
-        symbol_size,
-        redundancy_factor,
-        max_memory_mb,
-        concurrency_limit,
-    };
+        create_session(config)
+    })
+}
 
-    let processor = RaptorQProcessor::new(config);
+// Registers a new session with its own processor, returns its ID
+fn create_session(config: ProcessorConfig) -> usize {
+    register_session(RaptorQProcessor::new(config))
+}
+
+// Registers a new session for the processor, returns its ID
+fn register_session(processor: RaptorQProcessor) -> usize {
+    let session_id = tagged_id(SESSION_COUNTER.fetch_add(1, Ordering::SeqCst));
 
     let mut processors = PROCESSORS.lock();
-    processors.insert(session_id, processor);
+    processors.insert(session_id, Arc::new(processor));
 
     session_id
 }
 
-/// Frees a RaptorQ session
-#[unsafe(no_mangle)]
-pub extern "C" fn raptorq_free_session(session_id: usize) -> bool {
-    let mut processors = PROCESSORS.lock();
-    processors.remove(&session_id).is_some()
-}
-
-/// Encodes a file using RaptorQ - streaming implementation
+/// Creates a new session with the same configuration and options as an existing one
+///
+/// Every option set on the original is copied: the symbol key, codec and CRC, the layout
+/// format and metadata, the repair symbols per block, the content hash, the timeout,
+/// the temp directory, the log callback and the other encoding options. Metrics are
+/// enabled on the clone if they are on the original, starting from zero.
+///
+/// The new session is fully independent: it doesn't share errors, cancellation,
+/// metrics or the concurrency limit with the original, and must be freed separately
+/// with raptorq_free_session. Options set on either session afterwards don't affect
+/// the other.
 ///
 /// Arguments:
 /// * `session_id` - Session ID returned from raptorq_init_session
-/// * `input_path` - Path to the input file
-/// * `output_dir` - Directory where symbols will be written
-/// * `block_size` - Size of blocks to process at once (0 = auto)
-/// * `result_buffer` - Buffer to store the result (JSON metadata)
-/// * `result_buffer_len` - Length of the result buffer
 ///
 /// Returns:
-/// *   0 on success
-/// *  -1 on generic error
-/// *  -2 on invalid parameters
-/// *  -3 on invalid response
-/// *  -4 on bad return buffer size
-/// *  -4 on encoding failure
-/// *  -5 on invalid session
-/// * -11 on IO error
-/// * -12 on File not found
-/// * -13 on Invalid path
-/// * -14 on Encoding failed
-/// * -16 on Memory limit exceeded
-/// * -17 on Concurrency limit reached
+/// * The ID of the new session, or 0 if the session doesn't exist
 #[unsafe(no_mangle)]
-pub extern "C" fn raptorq_create_metadata(
-    session_id: usize,
-    input_path: *const c_char,
-    layout_file: *const c_char,
-    block_size: usize,
-    result_buffer: *mut c_char,
-    result_buffer_len: usize,
-) -> i32 {
-    // Basic null pointer checks
-    if input_path.is_null() || layout_file.is_null() || result_buffer.is_null() {
-        return -2;
-    }
-
-    let input_path_str = match unsafe { CStr::from_ptr(input_path) }.to_str() {
-        Ok(s) => s,
-        Err(_) => return -2,
-    };
-
-    let layout_file_str = match unsafe { CStr::from_ptr(layout_file) }.to_str() {
-        Ok(s) => s,
-        Err(_) => return -2,
-    };
-
-    let processors = PROCESSORS.lock();
-    let processor = match processors.get(&session_id) {
-        Some(p) => p,
-        None => return -5,
-    };
-
-    match processor.create_metadata(input_path_str, layout_file_str, block_size) {
-        Ok(result) => {
-            // Serialize result to JSON
-            let result_json = match serde_json::to_string(&result) {
-                Ok(j) => j,
-                Err(_) => return -3,
-            };
-
-            // Copy result to result buffer
-            let c_result = match CString::new(result_json) {
-                Ok(s) => s,
-                Err(_) => return -3,
-            };
-
-            let result_bytes = c_result.as_bytes_with_nul();
-            if result_bytes.len() > result_buffer_len {
-                return -4;
-            }
-
-            unsafe {
-                ptr::copy_nonoverlapping(
-                    result_bytes.as_ptr() as *const c_char,
-                    result_buffer,
-                    result_bytes.len(),
-                );
-            }
+pub extern "C" fn raptorq_clone_session(session_id: usize) -> usize {
+    ffi_guard(0, || {
+        let Some(processor) = get_processor(session_id) else { return 0 };
+        let clone = RaptorQProcessor::new(processor.get_config().clone());
+        clone.copy_options_from(&processor);
 
-            0
-        },
-        Err(e) => match e {
-            ProcessError::IOError(_) => -11,
-            ProcessError::FileNotFound(_) => -12,
-            ProcessError::InvalidPath(_) => -13,
-            ProcessError::EncodingFailed(_) => -14,
-            ProcessError::MemoryLimitExceeded { .. } => -16,
-            ProcessError::ConcurrencyLimitReached => -17,
-            _ => -1,
-        },
-    }
+        // Metrics are counted apart for each session
+        let metrics = SESSION_METRICS.lock().contains_key(&session_id).then(|| Arc::new(MetricsCollector::new()));
+        clone.set_metrics(metrics.clone().map(|m| m as Arc<dyn ProcessorMetrics>));
+        let clone_id = register_session(clone);
+        if let Some(metrics) = metrics {
+            SESSION_METRICS.lock().insert(clone_id, metrics);
+        }
+        clone_id
+    })
 }
 
-/// Encodes a file using RaptorQ - streaming implementation
+/// Validates a session configuration without creating a session
 ///
 /// Arguments:
-/// * `session_id` - Session ID returned from raptorq_init_session
-/// * `input_path` - Path to the input file
-/// * `output_dir` - Directory where symbols will be written
-/// * `block_size` - Size of blocks to process at once (0 = auto)
-/// * `result_buffer` - Buffer to store the result (JSON metadata)
-/// * `result_buffer_len` - Length of the result buffer
+/// * `symbol_size` - Symbol size in bytes, at least 8
+/// * `redundancy_factor` - Redundancy factor, at least 1
+/// * `max_memory_mb` - Memory limit in MB, at least 1
+/// * `concurrency_limit` - Maximum concurrent operations, at least 1
+/// * `error_buffer` - Optional buffer to store the reason the configuration is invalid
+/// * `error_buffer_len` - Length of the error buffer
 ///
 /// Returns:
-/// *   0 on success
-/// *  -1 on generic error
-/// *  -2 on invalid parameters
-/// *  -3 on invalid response
-/// *  -4 on bad return buffer size
-/// *  -4 on encoding failure
-/// *  -5 on invalid session
-/// * -11 on IO error
-/// * -12 on File not found
-/// * -13 on Invalid Path
-/// * -14 on Encoding failed
-/// * -16 on Memory limit exceeded
-/// * -17 on Concurrency limit reached
+/// *   0 if the configuration is valid
+/// *  -2 if the configuration is invalid
 #[unsafe(no_mangle)]
-pub extern "C" fn raptorq_encode_file(
-    session_id: usize,
-    input_path: *const c_char,
-    output_dir: *const c_char,
-    block_size: usize,
-    result_buffer: *mut c_char,
-    result_buffer_len: usize,
+pub extern "C" fn raptorq_validate_config(
+    symbol_size: u16,
+    redundancy_factor: u8,
+    max_memory_mb: u64,
+    concurrency_limit: u64,
+    error_buffer: *mut c_char,
+    error_buffer_len: usize,
 ) -> i32 {
-    // Basic null pointer checks
-    if input_path.is_null() || output_dir.is_null() || result_buffer.is_null() {
-        return -2;
-    }
-
-    let input_path_str = match unsafe { CStr::from_ptr(input_path) }.to_str() {
-        Ok(s) => s,
-        Err(_) => return -2,
-    };
-
-    let output_dir_str = match unsafe { CStr::from_ptr(output_dir) }.to_str() {
-        Ok(s) => s,
-        Err(_) => return -2,
-    };
-
-    let processors = PROCESSORS.lock();
-    let processor = match processors.get(&session_id) {
-        Some(p) => p,
-        None => return -5,
-    };
+    ffi_guard(-1, || {
+        let config = ProcessorConfig {
+            symbol_size,
+            redundancy_factor,
+            max_memory_mb,
+            concurrency_limit,
+        };
 
-    match processor.encode_file(input_path_str, output_dir_str, block_size, false) {
-        Ok(result) => {
-            // Serialize result to JSON
-            let result_json = match serde_json::to_string(&result) {
-                Ok(j) => j,
-                Err(_) => return -3,
-            };
-
-            // Copy result to result buffer
-            let c_result = match CString::new(result_json) {
-                Ok(s) => s,
-                Err(_) => return -3,
-            };
-
-            let result_bytes = c_result.as_bytes_with_nul();
-            if result_bytes.len() > result_buffer_len {
-                return -4;
-            }
-
-            unsafe {
-                ptr::copy_nonoverlapping(
-                    result_bytes.as_ptr() as *const c_char,
-                    result_buffer,
-                    result_bytes.len(),
-                );
-            }
+        let error = match config.validate() {
+            Ok(()) => return RAPTORQ_OK,
+            Err(e) => e,
+        };
 
-            0
-        },
-        Err(e) => match e {
-            ProcessError::IOError(_) => -11,
-            ProcessError::FileNotFound(_) => -12,
-            ProcessError::InvalidPath(_) => -13,
-            ProcessError::EncodingFailed(_) => -14,
-            ProcessError::MemoryLimitExceeded { .. } => -16,
-            ProcessError::ConcurrencyLimitReached => -17,
-            _ => -1,
-        },
-    }
+        write_error_message(&error.to_string(), error_buffer, error_buffer_len);
+        error_code(&error)
+    })
 }
 
-/// Gets the last error message from the processor
+/// Checks that the library has the C interface the program was built for
+///
+/// To be called before creating the first session with the RAPTORQ_ABI_VERSION of the
+/// header the program was built with: a library with another interface may misread the
+/// arguments of its functions instead of failing.
 ///
 /// Arguments:
-/// * `session_id` - Session ID returned from raptorq_init_session
-/// * `error_buffer` - Buffer to store the error message
+/// * `expected_abi_version` - ABI version the program was built for
+/// * `error_buffer` - Optional buffer to store the reason of a mismatch
 /// * `error_buffer_len` - Length of the error buffer
 ///
 /// Returns:
-/// * 0 on success
-/// * -1 on error
+/// *   0 if the library has the expected ABI version
+/// *  -6 if it has another one
 #[unsafe(no_mangle)]
-pub extern "C" fn raptorq_get_last_error(
-    session_id: usize,
+pub extern "C" fn raptorq_check_abi(
+    expected_abi_version: u32,
     error_buffer: *mut c_char,
     error_buffer_len: usize,
 ) -> i32 {
-    if error_buffer.is_null() {
-        return -1;
-    }
-
-    let processors = PROCESSORS.lock();
-    let processor = match processors.get(&session_id) {
-        Some(p) => p,
-        None => return -1,
-    };
+    ffi_guard(-1, || {
+        if expected_abi_version == RAPTORQ_ABI_VERSION {
+            return RAPTORQ_OK;
+        }
 
-    let error_msg = processor.get_last_error();
-    let c_error = match CString::new(error_msg) {
-        Ok(s) => s,
-        Err(_) => return -1,
-    };
+        let message = format!(
+            "library ABI {} incompatible with wrapper ABI {}",
+            RAPTORQ_ABI_VERSION, expected_abi_version
+        );
+        log::error!("{}", message);
+        write_error_message(&message, error_buffer, error_buffer_len);
+        RAPTORQ_ERR_ABI_MISMATCH
+    })
+}
 
-    let error_bytes = c_error.as_bytes_with_nul();
-    if error_bytes.len() > error_buffer_len {
-        // Error message too long, truncate
-        unsafe {
-            ptr::copy_nonoverlapping(
-                error_bytes.as_ptr() as *const c_char,
-                error_buffer,
-                error_buffer_len - 1,
-            );
-            *error_buffer.add(error_buffer_len - 1) = 0;
-        }
-    } else {
+// Copy an error message to an optional C buffer, truncated to the buffer
+fn write_error_message(message: &str, buffer: *mut c_char, buffer_len: usize) {
+    if buffer.is_null() || buffer_len == 0 {
+        return;
+    }
+    if let Ok(c_message) = CString::new(message) {
+        let message_bytes = c_message.as_bytes_with_nul();
+        let len = message_bytes.len().min(buffer_len);
         unsafe {
-            ptr::copy_nonoverlapping(
-                error_bytes.as_ptr() as *const c_char,
-                error_buffer,
-                error_bytes.len(),
-            );
+            ptr::copy_nonoverlapping(message_bytes.as_ptr() as *const c_char, buffer, len);
+            *buffer.add(len - 1) = 0;
         }
     }
+}
 
-    0
+/// Frees a RaptorQ session
+///
+/// Returns false both if the session doesn't exist and if freeing it failed,
+/// raptorq_close_session tells them apart.
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_free_session(session_id: usize) -> bool {
+    ffi_guard(false, || remove_session(session_id))
 }
 
-/// Decodes RaptorQ symbols back to the original file
+/// Frees a RaptorQ session, succeeding if it was already freed
+///
+/// Closing a session twice is harmless, so a wrapper can close it both explicitly
+/// and when it is garbage collected. Operations in progress on the session finish
+/// normally, the session can't be used afterwards.
 ///
 /// Arguments:
 /// * `session_id` - Session ID returned from raptorq_init_session
-/// * `symbols_dir` - Directory containing the symbols
-/// * `output_path` - Path where the decoded file will be written
-/// * `layout_path` - Path to the layout file (containing encoder parameters and block information)
 ///
 /// Returns:
-/// *   0 on success
-/// *  -1 on generic error
-/// *  -2 on invalid parameters
-/// *  -3 on invalid response
-/// *  -4 on bad return buffer size
-/// *  -4 on encoding failure
-/// *  -5 on invalid session
-/// * -11 on IO error
-/// * -12 on File not found
-/// * -13 on Invalid Path
-/// * -15 on Decoding failed
-/// * -16 on Memory limit exceeded
-/// * -17 on Concurrency limit reached
+/// *   0 if the session was freed, by this call or an earlier one
+/// *  -1 if freeing the session failed
+/// *  -5 if no session was ever created with this ID
+/// *  -7 if the session was created by a previous load of the library
 #[unsafe(no_mangle)]
-pub extern "C" fn raptorq_decode_symbols(
-    session_id: usize,
-    symbols_dir: *const c_char,
-    output_path: *const c_char,
-    layout_path: *const c_char,
-) -> i32 {
-    // Basic null pointer checks
-    if symbols_dir.is_null() || output_path.is_null() || layout_path.is_null() {
-        return -2;
-    }
+pub extern "C" fn raptorq_close_session(session_id: usize) -> i32 {
+    ffi_guard(-1, || {
+        if remove_session(session_id) {
+            return RAPTORQ_OK;
+        }
+        if !is_issued_session(session_id) {
+            return missing_session(session_id);
+        }
+        RAPTORQ_OK
+    })
+}
 
-    let symbols_dir_str = match unsafe { CStr::from_ptr(symbols_dir) }.to_str() {
-        Ok(s) => s,
-        Err(_) => return -2,
-    };
+// Code of a session that doesn't exist: -7 if a previous load of the library created
+// it, so the caller knows to recreate it, -5 otherwise
+fn missing_session(session_id: usize) -> i32 {
+    let load_tag = session_id >> LOAD_TAG_SHIFT;
+    if load_tag != 0 && load_tag != *LOAD_TAG {
+        RAPTORQ_ERR_SESSION_INVALIDATED
+    } else {
+        RAPTORQ_ERR_INVALID_SESSION
+    }
+}
 
-    let output_path_str = match unsafe { CStr::from_ptr(output_path) }.to_str() {
-        Ok(s) => s,
-        Err(_) => return -2,
-    };
+// Whether an ID was given to a session by this load of the library, which numbers
+// them in increasing order from 1
+fn is_issued_session(session_id: usize) -> bool {
+    let number = session_id & ((1 << LOAD_TAG_SHIFT) - 1);
+    session_id >> LOAD_TAG_SHIFT == *LOAD_TAG && number != 0 && number < SESSION_COUNTER.load(Ordering::SeqCst)
+}
 
-    let layout_path_str = match unsafe { CStr::from_ptr(layout_path) }.to_str() {
-        Ok(s) => s,
-        Err(_) => return -2,
-    };
+/// Gets the status of a session ID
+///
+/// Tells apart the sessions lost to a reload of the library (-7) from the ones never
+/// created or already freed (-5), as the functions taking a session do when it
+/// doesn't exist. After a reload, e.g. by a plugin host, the sessions of the previous
+/// load are gone: recreate them with raptorq_init_session and the same configuration,
+/// then set their options again, and retry the operations that failed.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+///
+/// Returns:
+/// *   0 if the session exists
+/// *  -5 if the session was never created or is freed
+/// *  -7 if the session was created by a previous load of the library
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_session_status(session_id: usize) -> i32 {
+    ffi_guard(-1, || {
+        match get_processor(session_id) {
+            Some(_) => RAPTORQ_OK,
+            None => missing_session(session_id),
+        }
+    })
+}
 
-    let processors = PROCESSORS.lock();
-    let processor = match processors.get(&session_id) {
-        Some(p) => p,
-        None => return -5,
-    };
+/// Number of sessions created and not freed yet
+///
+/// Lets tests and long running programs check that every session they create is
+/// freed: sessions are only freed by raptorq_free_session or raptorq_close_session.
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_active_session_count() -> usize {
+    ffi_guard(0, || PROCESSORS.lock().len())
+}
 
-    match processor.decode_symbols(symbols_dir_str, output_path_str, layout_path_str) {
-        Ok(_) => 0,
-        Err(e) => match e {
-            ProcessError::IOError(_) => -11,
-            ProcessError::FileNotFound(_) => -12,
-            ProcessError::InvalidPath(_) => -13,
-            ProcessError::DecodingFailed(_) => -15,
-            _ => -1, // Generic error for unhandled cases
-        },
-    }
+// Removes a session with its metrics, false if it doesn't exist
+fn remove_session(session_id: usize) -> bool {
+    SESSION_METRICS.lock().remove(&session_id);
+    let mut processors = PROCESSORS.lock();
+    processors.remove(&session_id).is_some()
 }
 
-/// Gets a recommended block size based on file size and available memory
+/// Creates a pool of sessions for services encoding and decoding in parallel
+///
+/// The pool creates `size` sessions of the configuration up front and grows on demand
+/// up to `max_size`, so requests don't pay for creating a session. A session acquired
+/// with raptorq_pool_acquire is used by one thread at a time, its last error and
+/// shortfalls come from the operations of that thread. raptorq_pool_release returns it
+/// to the pool, reset and without the options set on it.
 ///
 /// Arguments:
-/// * `session_id` - Session ID returned from raptorq_init_session
-/// * `file_size` - Size of the file to process
+/// * `symbol_size` - Symbol size in bytes, at least 8
+/// * `redundancy_factor` - Redundancy factor, at least 1
+/// * `max_memory_mb` - Memory limit of each session in MB, at least 1
+/// * `concurrency_limit` - Maximum concurrent operations of each session, at least 1
+/// * `size` - Number of sessions created up front
+/// * `max_size` - Maximum number of sessions of the pool, at least 1
 ///
 /// Returns:
-/// * Recommended block size in bytes
-/// * 0 if it should not block or on error
+/// * The ID of the pool, or 0 if the configuration is invalid or `size` is larger
+///   than `max_size`
 #[unsafe(no_mangle)]
-pub extern "C" fn raptorq_get_recommended_block_size(
-    session_id: usize,
-    file_size: u64,
+pub extern "C" fn raptorq_pool_create(
+    symbol_size: u16,
+    redundancy_factor: u8,
+    max_memory_mb: u64,
+    concurrency_limit: u64,
+    size: usize,
+    max_size: usize,
 ) -> usize {
-    let processors = PROCESSORS.lock();
-    let processor = match processors.get(&session_id) {
-        Some(p) => p,
-        None => return 0,
-    };
+    ffi_guard(0, || {
+        let config = ProcessorConfig {
+            symbol_size,
+            redundancy_factor,
+            max_memory_mb,
+            concurrency_limit,
+        };
+        let pool = match ProcessorPool::new(size, max_size, config) {
+            Ok(p) => p,
+            Err(_) => return 0,
+        };
 
-    processor.get_recommended_block_size(file_size as usize)
+        let pool_id = tagged_id(POOL_COUNTER.fetch_add(1, Ordering::SeqCst));
+        POOLS.lock().insert(pool_id, Arc::new(PoolEntry { pool, sessions: Mutex::new(HashSet::new()) }));
+        pool_id
+    })
 }
 
-/// Version information
-#[unsafe(no_mangle)]
-pub extern "C" fn raptorq_version(
-    version_buffer: *mut c_char,
-    version_buffer_len: usize,
-) -> i32 {
-    if version_buffer.is_null() {
-        return -1;
+/// Acquires a session from a pool
+///
+/// Takes an idle session, or creates one if the pool can still grow. Otherwise the
+/// call waits until a session is released if `wait` is true, and returns -17 if not.
+/// The session works with every function taking a session, and is returned with
+/// raptorq_pool_release instead of being freed.
+///
+/// Arguments:
+/// * `pool_id` - Pool ID returned from raptorq_pool_create
+/// * `wait` - Whether to wait for a session when none is available
+/// * `session_id` - Receives the ID of the session
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters
+/// *  -5 on invalid pool
+/// *  -7 if the pool was created by a previous load of the library
+/// * -17 if no session is available and `wait` is false
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_pool_acquire(pool_id: usize, wait: bool, session_id: *mut usize) -> i32 {
+    ffi_guard(-1, || {
+        if session_id.is_null() {
+            return -2;
+        }
+
+        // The pool isn't kept locked while waiting, so sessions can be released
+        let entry = match POOLS.lock().get(&pool_id).cloned() {
+            Some(e) => e,
+            None => return missing_session(pool_id),
+        };
+        let processor = match entry.pool.take(wait) {
+            Some(p) => p,
+            None => return RAPTORQ_ERR_CONCURRENCY_LIMIT_REACHED,
+        };
+
+        let acquired_id = register_session(processor);
+        entry.sessions.lock().insert(acquired_id);
+        // A pool freed meanwhile didn't free this session
+        if !POOLS.lock().contains_key(&pool_id) {
+            remove_session(acquired_id);
+            return missing_session(pool_id);
+        }
+        unsafe { *session_id = acquired_id; }
+        RAPTORQ_OK
+    })
+}
+
+/// Returns a session acquired with raptorq_pool_acquire to its pool
+///
+/// The session is reset and its options are dropped, the next thread acquiring it
+/// gets it as the pool created it, under a new ID. Its ID can't be used afterwards.
+/// A session freed with raptorq_free_session instead is replaced by a new one.
+///
+/// Arguments:
+/// * `pool_id` - Pool ID returned from raptorq_pool_create
+/// * `session_id` - Session ID returned from raptorq_pool_acquire
+///
+/// Returns:
+/// *   0 on success
+/// *  -5 on invalid pool, or a session not acquired from the pool
+/// *  -7 if the pool was created by a previous load of the library
+/// * -17 if operations or encode jobs are still in progress on the session
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_pool_release(pool_id: usize, session_id: usize) -> i32 {
+    ffi_guard(-1, || {
+        let entry = match POOLS.lock().get(&pool_id).cloned() {
+            Some(e) => e,
+            None => return missing_session(pool_id),
+        };
+
+        let mut sessions = entry.sessions.lock();
+        if !sessions.contains(&session_id) {
+            return RAPTORQ_ERR_INVALID_SESSION;
+        }
+        // The operations take the processor of a session while it is locked
+        let processor = {
+            let mut processors = PROCESSORS.lock();
+            match processors.get(&session_id) {
+                Some(p) if Arc::strong_count(p) > 1 => return RAPTORQ_ERR_CONCURRENCY_LIMIT_REACHED,
+                _ => processors.remove(&session_id),
+            }
+        };
+        sessions.remove(&session_id);
+        drop(sessions);
+        SESSION_METRICS.lock().remove(&session_id);
+
+        let processor = processor
+            .and_then(|p| Arc::try_unwrap(p).ok())
+            .unwrap_or_else(|| RaptorQProcessor::new(entry.pool.get_config().clone()));
+        entry.pool.put(processor);
+        RAPTORQ_OK
+    })
+}
+
+/// Frees a pool with all of its sessions
+///
+/// The sessions acquired and not released are freed too, operations in progress on
+/// them finish normally. No call may be waiting in raptorq_pool_acquire on the pool.
+///
+/// Returns false if the pool doesn't exist.
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_pool_free(pool_id: usize) -> bool {
+    ffi_guard(false, || {
+        let Some(entry) = POOLS.lock().remove(&pool_id) else { return false };
+        for session_id in entry.sessions.lock().drain() {
+            remove_session(session_id);
+        }
+        true
+    })
+}
+
+/// Cancels the operations in progress on a session
+///
+/// They stop before processing their next block and return -19.
+/// Operations started after this call are not affected.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+///
+/// Returns:
+/// *   0 on success
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_cancel(session_id: usize) -> i32 {
+    ffi_guard(-1, || {
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        processor.cancel();
+        0
+    })
+}
+
+/// Sets the wall-clock limit of each operation started afterwards on a session
+///
+/// An operation over its limit stops like a cancelled one, before processing its
+/// next block, and returns -21. The output written before is kept: an encode leaves
+/// the symbols of the blocks done but no layout file, unless raptorq_encode_file
+/// removes them (see raptorq_set_cleanup_on_error), and a decode leaves a partial
+/// output file, which should be removed or written again.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `timeout_ms` - Limit in milliseconds, 0 for no limit (the default)
+///
+/// Returns:
+/// *   0 on success
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_set_timeout(session_id: usize, timeout_ms: u64) -> i32 {
+    ffi_guard(-1, || {
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let timeout = (timeout_ms > 0).then(|| Duration::from_millis(timeout_ms));
+        processor.set_timeout(timeout);
+        0
+    })
+}
+
+/// Sets the number of repair symbols generated for every block by the encodes started
+/// afterwards on a session, instead of deriving it from the redundancy factor
+///
+/// The number applies to every block, the last and smaller one included, and is
+/// reflected in the layout and the counts of the result.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `repair_symbols` - Repair symbols per block, 0 to derive them from the redundancy
+///   factor again (the default)
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 if `repair_symbols` exceeds the limit of the encoding symbol IDs
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_set_repair_symbols_per_block(session_id: usize, repair_symbols: u32) -> i32 {
+    ffi_guard(-1, || {
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        match processor.set_repair_symbols_per_block((repair_symbols > 0).then_some(repair_symbols)) {
+            Ok(()) => 0,
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Sets whether raptorq_encode_file removes the symbols it wrote when it fails
+///
+/// On by default: the block directories of the blocks the encode started are removed
+/// whatever the failure, including a cancellation or a timeout, so no partial set of
+/// symbols is left behind. Off, they are kept without a layout file.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `enabled` - Whether to remove the symbols of a failed encode
+///
+/// Returns:
+/// *   0 on success
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_set_cleanup_on_error(session_id: usize, enabled: bool) -> i32 {
+    ffi_guard(-1, || {
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        processor.set_cleanup_on_error(enabled);
+        0
+    })
+}
+
+/// Sets whether the encodes of a session write all symbols directly in the output
+/// directory instead of a `block_<block_id>` directory per block
+///
+/// Off by default. Symbol ids are hashes of the symbols, so the symbols of all blocks
+/// can share a directory. Decodes find the symbols in either layout, whatever this option.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `enabled` - Whether to write the symbols without block directories
+///
+/// Returns:
+/// *   0 on success
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_set_flat_symbol_layout(session_id: usize, enabled: bool) -> i32 {
+    ffi_guard(-1, || {
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        processor.set_flat_symbol_layout(enabled);
+        0
+    })
+}
+
+/// Sets whether the encodes of a session fit the symbol size to each block
+///
+/// Off by default. Each block keeps the number of source symbols of the session symbol
+/// size with the smallest symbols holding it, so a small tail block isn't padded to a
+/// whole symbol. The layout records the symbol size of each block, which decodes read
+/// whatever this option.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `enabled` - Whether to fit the symbol size to each block
+///
+/// Returns:
+/// *   0 on success
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_set_auto_symbol_size(session_id: usize, enabled: bool) -> i32 {
+    ffi_guard(-1, || {
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        processor.set_auto_symbol_size(enabled);
+        0
+    })
+}
+
+/// Sets whether the encodes of a session keep their block buffer for the next encode
+///
+/// Off by default. With the pool, repeated encodes of objects of similar sizes reuse
+/// the buffers of the previous ones instead of allocating a buffer of the block size.
+/// Disabling it or resetting the session releases the buffers.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `enabled` - Whether to keep the block buffers between encodes
+///
+/// Returns:
+/// *   0 on success
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_set_buffer_pool(session_id: usize, enabled: bool) -> i32 {
+    ffi_guard(-1, || {
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        processor.set_buffer_pool(enabled);
+        0
+    })
+}
+
+/// Sets the compression of the symbol files written by the encodes of a session
+///
+/// RAPTORQ_CODEC_NONE by default. Each symbol is compressed on its own and the codec is
+/// recorded in the layout, so decodes decompress the symbols of every layout whatever
+/// the codec of their session. Symbol ids are the hashes of the uncompressed symbols.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `codec` - RAPTORQ_CODEC_NONE, RAPTORQ_CODEC_GZIP or RAPTORQ_CODEC_ZSTD
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on an unknown codec
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_set_symbol_codec(session_id: usize, codec: u32) -> i32 {
+    ffi_guard(-1, || {
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let symbol_codec = match codec {
+            RAPTORQ_CODEC_NONE => SymbolCodec::None,
+            RAPTORQ_CODEC_GZIP => SymbolCodec::Gzip,
+            RAPTORQ_CODEC_ZSTD => SymbolCodec::Zstd,
+            _ => return -2,
+        };
+        processor.set_symbol_codec(symbol_codec);
+        0
+    })
+}
+
+/// Sets the hash of the source computed by the encodes of a session
+///
+/// RAPTORQ_HASH_SHA256 by default. The source is hashed as it is read for encoding and
+/// the hash is returned in lowercase hex as `content_hash` in the result JSON of the
+/// encodes. Only SHA-256 is recorded in the layouts, as `object_sha256`, which checked
+/// decodes rely on; RAPTORQ_HASH_NONE saves the cost of hashing on large files.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `hash` - RAPTORQ_HASH_NONE, RAPTORQ_HASH_SHA256 or RAPTORQ_HASH_BLAKE3
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on an unknown hash
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_set_content_hash(session_id: usize, hash: u32) -> i32 {
+    ffi_guard(-1, || {
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let content_hash = match hash {
+            RAPTORQ_HASH_NONE => ContentHash::None,
+            RAPTORQ_HASH_SHA256 => ContentHash::Sha256,
+            RAPTORQ_HASH_BLAKE3 => ContentHash::Blake3,
+            _ => return -2,
+        };
+        processor.set_content_hash(content_hash);
+        0
+    })
+}
+
+/// Sets the format of the layout files written by a session
+///
+/// RAPTORQ_LAYOUT_JSON by default. The binary format holds the same data in a smaller
+/// file that is faster to parse for objects with thousands of blocks, but JSON tools
+/// can't read it. The format of a layout file is detected when it is read,
+/// so every function taking a layout path reads both formats whatever the format of
+/// its session; raptorq_parse_layout describes a binary layout as JSON. The layouts
+/// returned in the result JSON of the encodes stay JSON.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `format` - RAPTORQ_LAYOUT_JSON or RAPTORQ_LAYOUT_BINARY
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on an unknown format
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_set_layout_format(session_id: usize, format: u32) -> i32 {
+    ffi_guard(-1, || {
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let layout_format = match format {
+            RAPTORQ_LAYOUT_JSON => LayoutFormat::Json,
+            RAPTORQ_LAYOUT_BINARY => LayoutFormat::Binary,
+            _ => return -2,
+        };
+        processor.set_layout_format(layout_format);
+        0
+    })
+}
+
+/// Sets the directory of the scratch files of the operations of a session
+///
+/// The temp directory of the system by default. Decodes from symbols in any order
+/// (raptorq_decode_from_source, raptorq_decode_from_tar, raptorq_decode_from_files...)
+/// write the blocks decoded before the blocks preceding them to a scratch file once
+/// they take more than the memory limit of the session. Scratch files are removed when
+/// the operation ends.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `temp_dir` - Directory of the scratch files, created if needed, or NULL for the
+///   temp directory of the system
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on an invalid path
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_set_temp_dir(session_id: usize, temp_dir: *const c_char) -> i32 {
+    ffi_guard(-1, || {
+        let temp_dir = if temp_dir.is_null() {
+            None
+        } else {
+            match c_path_arg(temp_dir) {
+                Some(s) => Some(PathBuf::from(s)),
+                None => return -2,
+            }
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        processor.set_temp_dir(temp_dir);
+        0
+    })
+}
+
+/// Sets the most symbols raptorq_decode_from_source pulls from its callback
+///
+/// A block that doesn't decode gets more symbols until it does, so a callback that
+/// keeps returning undecodable symbols would be called forever. Once the limit is
+/// reached before every block is decoded, the decode fails with -18 as if the symbols
+/// had run out. Every symbol pulled counts, including the ones ignored.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `max_symbols` - Most symbols pulled by a decode, 0 to pull until the callback
+///   returns 0 (the default)
+///
+/// Returns:
+/// *   0 on success
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_set_max_source_symbols(session_id: usize, max_symbols: u64) -> i32 {
+    ffi_guard(-1, || {
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        processor.set_max_source_symbols((max_symbols > 0).then_some(max_symbols));
+        0
+    })
+}
+
+/// Sets the key encrypting the symbol files written by the encodes of a session, and
+/// decrypting the ones of the layouts encrypted with it
+///
+/// Symbols are encrypted with AES-GCM after their compression, AES-128 for a key of 16
+/// bytes and AES-256 for 32 bytes, with a nonce derived from the key and the symbol and
+/// written at the start of the file. Tampered symbols fail authentication and are skipped.
+/// The layout records the id of the key, not the key, and stays in the clear. Decoding
+/// encrypted symbols without their key fails with -2. The key is copied.
+///
+/// The symbol files are still named by the unkeyed BLAKE3 hashes of the plain symbols,
+/// which the layout lists too: anyone seeing them can confirm a guess of the content by
+/// encoding it with the same parameters. Encrypt the data before encoding it when
+/// this matters.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `key` - The key, may be NULL when `key_len` is 0
+/// * `key_len` - Length of the key, 0 writes the symbols in the clear
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on a NULL key or a key neither 16 nor 32 bytes long
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_set_symbol_key(session_id: usize, key: *const u8, key_len: usize) -> i32 {
+    ffi_guard(-1, || {
+        if key.is_null() && key_len > 0 {
+            return -2;
+        }
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let key = (key_len > 0).then(|| unsafe { std::slice::from_raw_parts(key, key_len) });
+        match processor.set_symbol_key(key) {
+            Ok(()) => 0,
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Sets whether the symbol files written by the encodes of a session start with a
+/// header holding their length and CRC32
+///
+/// Off by default. Decodes skip the truncated or bit-flipped files like missing symbols,
+/// which the repair symbols cover. The layout records whether the files have headers, so
+/// decodes read them whatever this option.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `enabled` - Whether to write the headers
+///
+/// Returns:
+/// *   0 on success
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_set_symbol_crc(session_id: usize, enabled: bool) -> i32 {
+    ffi_guard(-1, || {
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        processor.set_symbol_crc(enabled);
+        0
+    })
+}
+
+/// Sets the metadata of the application recorded in the layouts written by a session
+///
+/// The metadata is a JSON object of strings, e.g. `{"object_id":"3f2a","content_type":"application/json"}`,
+/// recorded in the `metadata` of the layouts as given and returned by raptorq_parse_layout;
+/// the library doesn't interpret it. `{}` records none, the default.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `metadata_json` - JSON object mapping the keys to their values
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters, including JSON that isn't an object of strings
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_set_layout_metadata(session_id: usize, metadata_json: *const c_char) -> i32 {
+    ffi_guard(-1, || {
+        if metadata_json.is_null() {
+            return -2;
+        }
+
+        let metadata_str = match unsafe { CStr::from_ptr(metadata_json) }.to_str() {
+            Ok(s) => s,
+            Err(_) => return -2,
+        };
+
+        let metadata: BTreeMap<String, String> = match serde_json::from_str(metadata_str) {
+            Ok(m) => m,
+            Err(_) => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        processor.set_layout_metadata(metadata);
+        0
+    })
+}
+
+/// Resets a session so it can be reused for a new operation
+///
+/// Clears the last error and its code, shortfalls and corrupt symbols, and releases the
+/// block buffers kept by raptorq_set_buffer_pool, which is cheaper than freeing the
+/// session and creating a new one. The options of the session are kept. Operations in
+/// progress are not affected, call raptorq_cancel first to stop them.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+///
+/// Returns:
+/// *   0 on success
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_reset_session(session_id: usize) -> i32 {
+    ffi_guard(-1, || {
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        processor.reset();
+        0
+    })
+}
+
+/// Callback receiving an operation event of a session as a JSON object
+///
+/// `level` is RAPTORQ_LOG_DEBUG or RAPTORQ_LOG_INFO. `event_json` is a null
+/// terminated string only valid during the call.
+pub type RaptorQLogCallback = extern "C" fn(context: *mut c_void, level: i32, event_json: *const c_char);
+
+// Adapts a log callback to ProcessorLogger
+struct CallbackLogger {
+    callback: RaptorQLogCallback,
+    context: *mut c_void,
+}
+
+// The caller of raptorq_set_log_callback guarantees the callback and its
+// context can be used from the threads running the operations
+unsafe impl Send for CallbackLogger {}
+unsafe impl Sync for CallbackLogger {}
+
+impl CallbackLogger {
+    fn log(&self, level: i32, event: &OperationEvent) {
+        if let Ok(json) = CString::new(event.to_json()) {
+            (self.callback)(self.context, level, json.as_ptr());
+        }
     }
+}
 
-    let version = "RaptorQ Library v0.1.0";
-    let c_version = match CString::new(version) {
-        Ok(s) => s,
-        Err(_) => return -1,
-    };
+impl ProcessorLogger for CallbackLogger {
+    fn debug(&self, event: &OperationEvent) {
+        self.log(RAPTORQ_LOG_DEBUG, event);
+    }
+
+    fn info(&self, event: &OperationEvent) {
+        self.log(RAPTORQ_LOG_INFO, event);
+    }
+}
+
+/// Sets the callback receiving the events of the encode and decode operations of a session
+///
+/// raptorq_encode_file and raptorq_decode_symbols report their start at debug level,
+/// then their end at info level with the object size, number of blocks and symbols,
+/// duration, or the error, e.g.
+/// `{"operation":"encode_file","stage":"completed","path":"in.bin","object_size":1024,
+/// "blocks":1,"symbols":12,"duration_ms":3}`. The callback is called from the thread
+/// running the operation. Sessions log nothing by default.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `log_callback` - Callback receiving the events, NULL to stop logging
+/// * `context` - Opaque pointer passed to every call of the callback
+///
+/// Returns:
+/// *   0 on success
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_set_log_callback(
+    session_id: usize,
+    log_callback: Option<RaptorQLogCallback>,
+    context: *mut c_void,
+) -> i32 {
+    ffi_guard(-1, || {
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let logger = log_callback.map(|callback| {
+            Arc::new(CallbackLogger { callback, context }) as Arc<dyn ProcessorLogger>
+        });
+        processor.set_logger(logger);
+        0
+    })
+}
+
+/// Encodes a file using RaptorQ - streaming implementation
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `input_path` - Path to the input file
+/// * `output_dir` - Directory where symbols will be written
+/// * `block_size` - Size of blocks to process at once (0 = auto)
+/// * `result_buffer` - Buffer to store the result (JSON metadata)
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -1 on generic error
+/// *  -2 on invalid parameters
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size
+/// *  -4 on encoding failure
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error
+/// * -12 on File not found
+/// * -13 on Invalid path
+/// * -14 on Encoding failed
+/// * -16 on Memory limit exceeded
+/// * -17 on Concurrency limit reached
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_create_metadata(
+    session_id: usize,
+    input_path: *const c_char,
+    layout_file: *const c_char,
+    block_size: usize,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        // Basic null pointer checks
+        if input_path.is_null() || layout_file.is_null() || result_buffer.is_null() {
+            return -2;
+        }
+
+        let input_path_str = match c_path_arg(input_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        // An empty layout file returns the layout in the result instead
+        let layout_file_str = match unsafe { CStr::from_ptr(layout_file) }.to_str() {
+            Ok(s) => s,
+            Err(_) => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        match processor.create_metadata(input_path_str, layout_file_str, block_size) {
+            Ok(result) => {
+                // Serialize result to JSON
+                let result_json = match serde_json::to_string(&result) {
+                    Ok(j) => j,
+                    Err(_) => return -3,
+                };
+
+                // Copy result to result buffer
+                let c_result = match CString::new(result_json) {
+                    Ok(s) => s,
+                    Err(_) => return -3,
+                };
+
+                let result_bytes = c_result.as_bytes_with_nul();
+                if result_bytes.len() > result_buffer_len {
+                    return -4;
+                }
+
+                unsafe {
+                    ptr::copy_nonoverlapping(
+                        result_bytes.as_ptr() as *const c_char,
+                        result_buffer,
+                        result_bytes.len(),
+                    );
+                }
+
+                0
+            },
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Encodes a file using RaptorQ - streaming implementation
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `input_path` - Path to the input file
+/// * `output_dir` - Directory where symbols will be written
+/// * `block_size` - Size of blocks to process at once (0 = auto)
+/// * `result_buffer` - Buffer to store the result (JSON metadata)
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// The result grows with the number of blocks, use raptorq_encode_file_alloc when
+/// its size can't be bounded up front.
+///
+/// Returns:
+/// *   0 on success
+/// *  -1 on generic error
+/// *  -2 on invalid parameters
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size, the symbols are written nonetheless
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error
+/// * -12 on File not found
+/// * -13 on Invalid Path
+/// * -14 on Encoding failed
+/// * -16 on Memory limit exceeded
+/// * -17 on Concurrency limit reached
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_encode_file(
+    session_id: usize,
+    input_path: *const c_char,
+    output_dir: *const c_char,
+    block_size: usize,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        // Basic null pointer checks
+        if input_path.is_null() || output_dir.is_null() || result_buffer.is_null() {
+            return -2;
+        }
+
+        let input_path_str = match c_path_arg(input_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let output_dir_str = match c_path_arg(output_dir) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        match processor.encode_file(input_path_str, output_dir_str, block_size, false) {
+            Ok(result) => {
+                // Serialize result to JSON
+                let result_json = match serde_json::to_string(&result) {
+                    Ok(j) => j,
+                    Err(_) => return -3,
+                };
+
+                // Copy result to result buffer
+                let c_result = match CString::new(result_json) {
+                    Ok(s) => s,
+                    Err(_) => return -3,
+                };
+
+                let result_bytes = c_result.as_bytes_with_nul();
+                if result_bytes.len() > result_buffer_len {
+                    return -4;
+                }
+
+                unsafe {
+                    ptr::copy_nonoverlapping(
+                        result_bytes.as_ptr() as *const c_char,
+                        result_buffer,
+                        result_bytes.len(),
+                    );
+                }
+
+                0
+            },
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Encodes a file using RaptorQ with symbols of the given size instead of the one of
+/// the session
+///
+/// The symbol size is recorded in the layout, so the symbols are decoded by any session.
+/// The symbol size of the session is read with raptorq_get_config.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `input_path` - Path to the input file
+/// * `output_dir` - Directory where symbols will be written
+/// * `symbol_size` - Size of the symbols in bytes, at least 8
+/// * `block_size` - Size of blocks to process at once (0 = auto)
+/// * `result_buffer` - Buffer to store the result (JSON metadata)
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -1 on generic error
+/// *  -2 on invalid parameters, including a symbol size below 8 or a block too large for it
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size, the symbols are written nonetheless
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error
+/// * -12 on File not found
+/// * -13 on Invalid Path
+/// * -14 on Encoding failed
+/// * -16 on Memory limit exceeded
+/// * -17 on Concurrency limit reached
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_encode_file_with_symbol_size(
+    session_id: usize,
+    input_path: *const c_char,
+    output_dir: *const c_char,
+    symbol_size: u16,
+    block_size: usize,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        if result_buffer.is_null() {
+            return -2;
+        }
+        let (Some(input_path_str), Some(output_dir_str)) = (c_path_arg(input_path), c_path_arg(output_dir)) else {
+            return -2;
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        match processor.encode_file_with_symbol_size(input_path_str, output_dir_str, symbol_size, block_size) {
+            Ok(result) => {
+                let result_json = match serde_json::to_string(&result) {
+                    Ok(j) => j,
+                    Err(_) => return -3,
+                };
+                write_c_string(&result_json, result_buffer, result_buffer_len)
+            },
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Callback selecting the symbols to write, from their block id, encoding symbol ID
+/// and whether they are repair symbols
+///
+/// Returns true to write the symbol, false to skip it.
+pub type RaptorQSymbolFilterCallback = extern "C" fn(context: *mut c_void, block_id: usize, esi: u32, is_repair: bool) -> bool;
+
+/// Encodes a file using RaptorQ, writing only the symbols selected by a callback
+///
+/// Symbols for which `keep_callback` returns false are not written, so a node can store
+/// its share of the symbols of an object. The layout still lists all symbols, while the
+/// symbol counts of the result only include the written ones. The callback is called
+/// from the calling thread only.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `input_path` - Path to the input file
+/// * `output_dir` - Directory where the kept symbols will be written
+/// * `block_size` - Size of blocks to process at once (0 = auto)
+/// * `keep_callback` - Callback selecting the symbols to write
+/// * `context` - Opaque pointer passed to every call of the callback
+/// * `result_buffer` - Buffer to store the result (JSON metadata)
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -1 on generic error
+/// *  -2 on invalid parameters
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size, the symbols are written nonetheless
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error
+/// * -12 on File not found
+/// * -13 on Invalid Path
+/// * -14 on Encoding failed
+/// * -16 on Memory limit exceeded
+/// * -17 on Concurrency limit reached
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_encode_file_with_filter(
+    session_id: usize,
+    input_path: *const c_char,
+    output_dir: *const c_char,
+    block_size: usize,
+    keep_callback: Option<RaptorQSymbolFilterCallback>,
+    context: *mut c_void,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        let callback = match keep_callback {
+            Some(c) => c,
+            None => return -2,
+        };
+        if input_path.is_null() || output_dir.is_null() || result_buffer.is_null() {
+            return -2;
+        }
+
+        let input_path_str = match c_path_arg(input_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let output_dir_str = match c_path_arg(output_dir) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let keep = |block_id: usize, esi: u32, is_repair: bool| callback(context, block_id, esi, is_repair);
+        match processor.encode_file_with_filter(input_path_str, output_dir_str, block_size, keep) {
+            Ok(result) => {
+                let result_json = match serde_json::to_string(&result) {
+                    Ok(j) => j,
+                    Err(_) => return -3,
+                };
+                write_c_string(&result_json, result_buffer, result_buffer_len)
+            },
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Callback receiving the symbols of an encode, with their block id, encoding symbol
+/// ID and whether they are repair symbols
+///
+/// The buffer holds the serialized packet and is only valid during the call.
+/// Returns 0 to continue, any other value stops the encode.
+pub type RaptorQEncodedSymbolCallback = extern "C" fn(context: *mut c_void, block_id: usize, esi: u32, is_repair: bool, buffer: *const u8, buffer_len: usize) -> i32;
+
+/// Encodes a file using RaptorQ, passing each symbol to a callback instead of writing it
+///
+/// Symbols are passed block after block in the order of the layout, the source symbols
+/// of a block before its repair symbols are generated. They are not compressed nor
+/// encrypted and can be decoded with raptorq_decode_from_source. The callback is
+/// called from the calling thread only, while the next symbols are encoded.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `input_path` - Path to the input file
+/// * `block_size` - Size of blocks to process at once (0 = auto)
+/// * `symbol_callback` - Callback receiving the symbols
+/// * `context` - Opaque pointer passed to every call of the callback
+/// * `result_buffer` - Buffer to store the result (JSON metadata with the layout)
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -1 on generic error
+/// *  -2 on invalid parameters
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error
+/// * -12 on File not found
+/// * -13 on Invalid Path
+/// * -14 on Encoding failed
+/// * -16 on Memory limit exceeded
+/// * -17 on Concurrency limit reached
+/// * -19 on Cancelled, also when the callback stops the encode
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_encode_to_callback(
+    session_id: usize,
+    input_path: *const c_char,
+    block_size: usize,
+    symbol_callback: Option<RaptorQEncodedSymbolCallback>,
+    context: *mut c_void,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        let callback = match symbol_callback {
+            Some(c) => c,
+            None => return -2,
+        };
+        if input_path.is_null() || result_buffer.is_null() {
+            return -2;
+        }
+
+        let input_path_str = match c_path_arg(input_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        // The encode runs on its own thread so the callback is called from this one
+        let (sender, receiver) = std::sync::mpsc::sync_channel::<EncodedSymbol>(16);
+        let owner = processor::operation_thread();
+        let encoded = std::thread::scope(|s| {
+            let encode = s.spawn(|| processor::work_for(&owner, || processor.encode_to_channel(input_path_str, block_size, sender)));
+            for symbol in receiver.iter() {
+                let code = callback(context, symbol.block_id, symbol.esi, symbol.is_repair, symbol.data.as_ptr(), symbol.data.len());
+                if code != 0 {
+                    break;
+                }
+            }
+            drop(receiver);
+            encode.join().unwrap_or_else(|payload| std::panic::resume_unwind(payload))
+        });
+
+        match encoded {
+            Ok(result) => {
+                let result_json = match serde_json::to_string(&result) {
+                    Ok(j) => j,
+                    Err(_) => return -3,
+                };
+                write_c_string(&result_json, result_buffer, result_buffer_len)
+            },
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Encodes a file using RaptorQ, returning the result in a buffer allocated by the library
+///
+/// Same as raptorq_encode_file, but the result has no size limit: the JSON metadata
+/// grows with the number of blocks and may not fit a fixed buffer, and encoding
+/// again with a larger one would write all symbols again.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `input_path` - Path to the input file
+/// * `output_dir` - Directory where symbols will be written
+/// * `block_size` - Size of blocks to process at once (0 = auto)
+/// * `result_buffer` - Receives the null terminated result (JSON metadata), to be
+///   released with raptorq_free_buffer; NULL on error
+/// * `result_buffer_len` - Receives the length of the result, terminating null included
+///
+/// Returns:
+/// *   0 on success
+/// *  -1 on generic error
+/// *  -2 on invalid parameters
+/// *  -3 on invalid response
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error
+/// * -12 on File not found
+/// * -13 on Invalid Path
+/// * -14 on Encoding failed
+/// * -16 on Memory limit exceeded
+/// * -17 on Concurrency limit reached
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_encode_file_alloc(
+    session_id: usize,
+    input_path: *const c_char,
+    output_dir: *const c_char,
+    block_size: usize,
+    result_buffer: *mut *mut u8,
+    result_buffer_len: *mut usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        if result_buffer.is_null() || result_buffer_len.is_null() {
+            return -2;
+        }
+
+        unsafe {
+            *result_buffer = ptr::null_mut();
+            *result_buffer_len = 0;
+        }
+
+        let input_path_str = match c_path_arg(input_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let output_dir_str = match c_path_arg(output_dir) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        match processor.encode_file(input_path_str, output_dir_str, block_size, false) {
+            Ok(result) => {
+                let result_json = match serde_json::to_string(&result) {
+                    Ok(j) => j,
+                    Err(_) => return -3,
+                };
+
+                let c_result = match CString::new(result_json) {
+                    Ok(s) => s,
+                    Err(_) => return -3,
+                };
+
+                let (buffer, len) = into_raw_buffer(c_result.into_bytes_with_nul());
+                unsafe {
+                    *result_buffer = buffer;
+                    *result_buffer_len = len;
+                }
+
+                0
+            },
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Resumes an interrupted raptorq_encode_file, encoding only the blocks that are not
+/// completely written yet
+///
+/// The blocks whose symbols were all written by the interrupted encode are kept if the
+/// file still has the same data, the others are encoded. The result is the one
+/// raptorq_encode_file would give. A failed encode leaves nothing to resume unless
+/// raptorq_set_cleanup_on_error disabled the cleanup.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `input_path` - Path to the input file
+/// * `output_dir` - Directory of the interrupted encode
+/// * `block_size` - Block size of the interrupted encode (0 = auto)
+/// * `result_buffer` - Buffer to store the result (JSON metadata)
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error
+/// * -12 on File not found
+/// * -13 on Invalid Path
+/// * -14 on Encoding failed
+/// * -16 on Memory limit exceeded
+/// * -17 on Concurrency limit reached
+/// * -19 on Cancelled
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_resume_encode(
+    session_id: usize,
+    input_path: *const c_char,
+    output_dir: *const c_char,
+    block_size: usize,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        if result_buffer.is_null() {
+            return -2;
+        }
+
+        let input_path_str = match c_path_arg(input_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let output_dir_str = match c_path_arg(output_dir) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        match processor.resume_encode(input_path_str, output_dir_str, block_size) {
+            Ok(result) => {
+                let result_json = match serde_json::to_string(&result) {
+                    Ok(j) => j,
+                    Err(_) => return -3,
+                };
+                write_c_string(&result_json, result_buffer, result_buffer_len)
+            },
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Encodes the data appended to an encoded object as new blocks, keeping its blocks
+///
+/// The input is the object as it is now, starting with the object of the layout: the
+/// bytes after it are encoded as new blocks with the symbol size and symbol format of
+/// the layout, the existing blocks are only read to check that their data is unchanged.
+/// The new symbols and the layout listing all blocks, with the metadata of the layout,
+/// are written to `output_dir`, usually the symbols directory of the layout. The result is the JSON metadata of the
+/// whole object, as raptorq_encode_file gives.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `layout_path` - Path to the layout file of the object encoded so far
+/// * `input_path` - Path to the object with the appended data
+/// * `output_dir` - Directory where the new symbols and the layout will be written
+/// * `block_size` - Size of the new blocks (0 = auto)
+/// * `result_buffer` - Buffer to store the result (JSON metadata)
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters, including an input that is not the object of the
+///       layout with data appended
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error
+/// * -12 on File not found
+/// * -13 on Invalid Path
+/// * -14 on Encoding failed
+/// * -15 on Decoding failed (malformed layout or wrong symbol key)
+/// * -16 on Memory limit exceeded
+/// * -17 on Concurrency limit reached
+/// * -19 on Cancelled
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_append_encode(
+    session_id: usize,
+    layout_path: *const c_char,
+    input_path: *const c_char,
+    output_dir: *const c_char,
+    block_size: usize,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        if result_buffer.is_null() {
+            return -2;
+        }
+
+        let (Some(layout_path_str), Some(input_path_str), Some(output_dir_str)) =
+            (c_path_arg(layout_path), c_path_arg(input_path), c_path_arg(output_dir))
+        else {
+            return -2;
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        match processor.append_encode(layout_path_str, input_path_str, output_dir_str, block_size) {
+            Ok(result) => {
+                let result_json = match serde_json::to_string(&result) {
+                    Ok(j) => j,
+                    Err(_) => return -3,
+                };
+                write_c_string(&result_json, result_buffer, result_buffer_len)
+            },
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Encodes again a single block of a file already encoded, writing only its symbols
+///
+/// The block is read from the byte range given by the layout and encoded with its
+/// encoder parameters, so the symbols are the ones listed in the layout. This
+/// regenerates the symbols of a damaged block without encoding the whole file.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `input_path` - Path to the original file
+/// * `output_dir` - Directory where the `block_<block_id>` directory will be written
+/// * `layout_path` - Path to the layout file of the file
+/// * `block_id` - Identifier of the block in the layout
+/// * `result_buffer` - Buffer to store the result (JSON metadata)
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error
+/// * -12 on File not found
+/// * -14 if the block is not in the layout or the file doesn't match it
+/// * -15 if the layout is invalid
+/// * -17 on Concurrency limit reached
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_encode_block(
+    session_id: usize,
+    input_path: *const c_char,
+    output_dir: *const c_char,
+    layout_path: *const c_char,
+    block_id: usize,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        // Basic null pointer checks
+        if input_path.is_null() || output_dir.is_null() || layout_path.is_null() || result_buffer.is_null() {
+            return -2;
+        }
+
+        let input_path_str = match c_path_arg(input_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let output_dir_str = match c_path_arg(output_dir) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let layout_path_str = match c_path_arg(layout_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        match processor.encode_block(input_path_str, output_dir_str, layout_path_str, block_id) {
+            Ok(result) => {
+                // Serialize result to JSON
+                let result_json = match serde_json::to_string(&result) {
+                    Ok(j) => j,
+                    Err(_) => return -3,
+                };
+
+                // Copy result to result buffer
+                let c_result = match CString::new(result_json) {
+                    Ok(s) => s,
+                    Err(_) => return -3,
+                };
+
+                let result_bytes = c_result.as_bytes_with_nul();
+                if result_bytes.len() > result_buffer_len {
+                    return -4;
+                }
+
+                unsafe {
+                    ptr::copy_nonoverlapping(
+                        result_bytes.as_ptr() as *const c_char,
+                        result_buffer,
+                        result_bytes.len(),
+                    );
+                }
+
+                0
+            },
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Generates new repair symbols for a block of a file already encoded and adds them to the layout
+///
+/// The new symbols continue the encoding symbol IDs after the symbols of the layout,
+/// so they never collide with existing ones. The result is a JSON array of the ids
+/// of the new symbols, which are appended to the block in the layout file.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `input_path` - Path to the original file
+/// * `output_dir` - Directory where the `block_<block_id>` directory will be written
+/// * `layout_path` - Path to the layout file of the file, updated with the new symbols
+/// * `block_id` - Identifier of the block in the layout
+/// * `count` - Number of repair symbols to generate
+/// * `result_buffer` - Buffer to store the JSON array
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters, including too many symbols for the block or a block
+///       split into several source blocks
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error
+/// * -12 on File not found
+/// * -14 if the block is not in the layout or the file doesn't match it
+/// * -15 if the layout is invalid
+/// * -17 on Concurrency limit reached
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_generate_repair_symbols(
+    session_id: usize,
+    input_path: *const c_char,
+    output_dir: *const c_char,
+    layout_path: *const c_char,
+    block_id: usize,
+    count: u32,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        // Basic null pointer checks
+        if input_path.is_null() || output_dir.is_null() || layout_path.is_null() || result_buffer.is_null() {
+            return -2;
+        }
+
+        let input_path_str = match c_path_arg(input_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let output_dir_str = match c_path_arg(output_dir) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let layout_path_str = match c_path_arg(layout_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let symbol_ids = match processor.generate_repair_symbols(input_path_str, output_dir_str, layout_path_str, block_id, count) {
+            Ok(ids) => ids,
+            Err(e) => return operation_error(&processor, &e),
+        };
+
+        let result_json = match serde_json::to_string(&symbol_ids) {
+            Ok(j) => j,
+            Err(_) => return -3,
+        };
+
+        let c_result = match CString::new(result_json) {
+            Ok(s) => s,
+            Err(_) => return -3,
+        };
+
+        let result_bytes = c_result.as_bytes_with_nul();
+        if result_bytes.len() > result_buffer_len {
+            return -4;
+        }
+
+        unsafe {
+            ptr::copy_nonoverlapping(
+                result_bytes.as_ptr() as *const c_char,
+                result_buffer,
+                result_bytes.len(),
+            );
+        }
+
+        0
+    })
+}
+
+/// Gets a symbol of a block by its encoding symbol ID (ESI)
+///
+/// The symbol is read from the symbols directory if it is stored there. Otherwise it
+/// is generated from the data of the block, read from the original file if `input_path`
+/// is given, or else decoded from the stored symbols of the block. The symbol is copied
+/// with its FEC payload ID, as stored in symbol files.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `symbols_dir` - Directory containing the symbols
+/// * `layout_path` - Path to the layout file
+/// * `block_id` - Identifier of the block in the layout
+/// * `esi` - Encoding symbol ID of the symbol, source symbols come first
+/// * `input_path` - Path to the original file, NULL if it is not available
+/// * `symbol_buffer` - Buffer to store the symbol, the symbol size plus 4 bytes is enough
+/// * `symbol_buffer_len` - Length of the symbol buffer
+/// * `symbol_len` - Receives the length of the symbol, also when the buffer is too small
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters, including a block not in the layout or split into several
+///       source blocks
+/// *  -4 on bad symbol buffer size
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error
+/// * -12 on File not found
+/// * -14 if the original file doesn't match the layout
+/// * -15 if the layout is invalid
+/// * -17 on Concurrency limit reached
+/// * -20 if the symbol is not stored and the block has too few symbols to generate it
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_get_symbol(
+    session_id: usize,
+    symbols_dir: *const c_char,
+    layout_path: *const c_char,
+    block_id: usize,
+    esi: u32,
+    input_path: *const c_char,
+    symbol_buffer: *mut u8,
+    symbol_buffer_len: usize,
+    symbol_len: *mut usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        // Basic null pointer checks
+        if symbols_dir.is_null() || layout_path.is_null() || symbol_buffer.is_null() || symbol_len.is_null() {
+            return -2;
+        }
+
+        let symbols_dir_str = match c_path_arg(symbols_dir) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let layout_path_str = match c_path_arg(layout_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let input_path_str = if input_path.is_null() {
+            None
+        } else {
+            match c_path_arg(input_path) {
+                Some(s) => Some(s),
+                None => return -2,
+            }
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let symbol = match processor.get_symbol(symbols_dir_str, layout_path_str, block_id, esi, input_path_str) {
+            Ok(s) => s,
+            Err(e) => return operation_error(&processor, &e),
+        };
+
+        unsafe { *symbol_len = symbol.len(); }
+        if symbol.len() > symbol_buffer_len {
+            return -4;
+        }
+
+        unsafe {
+            ptr::copy_nonoverlapping(symbol.as_ptr(), symbol_buffer, symbol.len());
+        }
+
+        0
+    })
+}
+
+/// Encodes a file using RaptorQ into a single tar archive holding all symbols and the layout
+///
+/// The archive has the symbols of each block as `block_<id>/<symbol_id>` entries,
+/// block after block, then the layout as a `_raptorq_layout.json` entry,
+/// see docs/SYMBOL_ARCHIVE_FORMAT.md. It is read back by raptorq_decode_from_archive.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `input_path` - Path to the input file
+/// * `archive_path` - Path of the archive to write
+/// * `block_size` - Size of blocks to process at once (0 = auto)
+/// * `result_buffer` - Buffer to store the result (JSON metadata, including the layout)
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error
+/// * -12 on File not found
+/// * -14 on Encoding failed
+/// * -16 on Memory limit exceeded
+/// * -17 on Concurrency limit reached
+/// * -19 if the session was cancelled
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_encode_file_to_archive(
+    session_id: usize,
+    input_path: *const c_char,
+    archive_path: *const c_char,
+    block_size: usize,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        // Basic null pointer checks
+        if input_path.is_null() || archive_path.is_null() || result_buffer.is_null() {
+            return -2;
+        }
+
+        let input_path_str = match c_path_arg(input_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let archive_path_str = match c_path_arg(archive_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        match processor.encode_file_to_archive(input_path_str, archive_path_str, block_size) {
+            Ok(result) => {
+                // Serialize result to JSON
+                let result_json = match serde_json::to_string(&result) {
+                    Ok(j) => j,
+                    Err(_) => return -3,
+                };
+
+                // Copy result to result buffer
+                let c_result = match CString::new(result_json) {
+                    Ok(s) => s,
+                    Err(_) => return -3,
+                };
+
+                let result_bytes = c_result.as_bytes_with_nul();
+                if result_bytes.len() > result_buffer_len {
+                    return -4;
+                }
+
+                unsafe {
+                    ptr::copy_nonoverlapping(
+                        result_bytes.as_ptr() as *const c_char,
+                        result_buffer,
+                        result_bytes.len(),
+                    );
+                }
+
+                0
+            },
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+// Outcome of a file encoded by raptorq_encode_files
+#[derive(Serialize)]
+struct BatchEncodeOutcome {
+    error_code: i32,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    error: Option<String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    result: Option<ProcessResult>,
+}
+
+/// Encodes several files using RaptorQ, in parallel up to the concurrency limit
+///
+/// The jobs are a JSON array of `{"input_path", "output_dir", "block_size"}` objects,
+/// `block_size` being optional (0 = auto). The result is a JSON array with one
+/// `{"error_code", "error", "result"}` object per job, in the order of the jobs:
+/// `error_code` is 0 and `result` holds the JSON metadata of the file on success,
+/// otherwise `error_code` is the error raptorq_encode_file would have returned
+/// and `error` its message. A file that fails does not stop the others.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `jobs_json` - JSON array of the files to encode
+/// * `result_buffer` - Buffer to store the results (JSON array)
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// Returns:
+/// *   0 on success, even if some files failed
+/// *  -2 on invalid parameters, including malformed jobs
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_encode_files(
+    session_id: usize,
+    jobs_json: *const c_char,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        if jobs_json.is_null() || result_buffer.is_null() {
+            return -2;
+        }
+
+        let jobs_str = match unsafe { CStr::from_ptr(jobs_json) }.to_str() {
+            Ok(s) => s,
+            Err(_) => return -2,
+        };
+
+        let jobs: Vec<BatchEncodeJob> = match serde_json::from_str(jobs_str) {
+            Ok(jobs) => jobs,
+            Err(_) => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let outcomes: Vec<BatchEncodeOutcome> = processor
+            .encode_files(&jobs)
+            .into_iter()
+            .map(|result| match result {
+                Ok(result) => BatchEncodeOutcome { error_code: 0, error: None, result: Some(result) },
+                Err(e) => BatchEncodeOutcome { error_code: error_code(&e), error: Some(e.to_string()), result: None },
+            })
+            .collect();
+
+        let result_json = match serde_json::to_string(&outcomes) {
+            Ok(j) => j,
+            Err(_) => return -3,
+        };
+
+        let c_result = match CString::new(result_json) {
+            Ok(s) => s,
+            Err(_) => return -3,
+        };
+
+        let result_bytes = c_result.as_bytes_with_nul();
+        if result_bytes.len() > result_buffer_len {
+            return -4;
+        }
+
+        unsafe {
+            ptr::copy_nonoverlapping(
+                result_bytes.as_ptr() as *const c_char,
+                result_buffer,
+                result_bytes.len(),
+            );
+        }
+
+        0
+    })
+}
+
+// Outcome of an object decoded by raptorq_decode_files
+#[derive(Serialize)]
+struct BatchDecodeOutcome {
+    error_code: i32,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    error: Option<String>,
+}
+
+/// Decodes several objects using RaptorQ, in parallel up to the concurrency limit
+///
+/// The jobs are a JSON array of `{"symbols_dir", "output_path", "layout_path"}` objects.
+/// The result is a JSON array with one `{"error_code", "error"}` object per job, in the
+/// order of the jobs: `error_code` is 0 on success, otherwise the error
+/// raptorq_decode_symbols would have returned and `error` its message. An object that
+/// fails does not stop the others.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `jobs_json` - JSON array of the objects to decode
+/// * `result_buffer` - Buffer to store the results (JSON array)
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// Returns:
+/// *   0 on success, even if some objects failed
+/// *  -2 on invalid parameters, including malformed jobs
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_decode_files(
+    session_id: usize,
+    jobs_json: *const c_char,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        if jobs_json.is_null() || result_buffer.is_null() {
+            return -2;
+        }
+
+        let jobs_str = match unsafe { CStr::from_ptr(jobs_json) }.to_str() {
+            Ok(s) => s,
+            Err(_) => return -2,
+        };
+
+        let jobs: Vec<BatchDecodeJob> = match serde_json::from_str(jobs_str) {
+            Ok(jobs) => jobs,
+            Err(_) => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let outcomes: Vec<BatchDecodeOutcome> = processor
+            .decode_files(&jobs)
+            .into_iter()
+            .map(|result| match result {
+                Ok(()) => BatchDecodeOutcome { error_code: 0, error: None },
+                Err(e) => BatchDecodeOutcome { error_code: error_code(&e), error: Some(e.to_string()) },
+            })
+            .collect();
+
+        let result_json = match serde_json::to_string(&outcomes) {
+            Ok(j) => j,
+            Err(_) => return -3,
+        };
+
+        write_c_string(&result_json, result_buffer, result_buffer_len)
+    })
+}
+
+/// Callback reading the next bytes of a stream into `buffer`
+///
+/// Returns the number of bytes written into the buffer (at most `buffer_len`),
+/// 0 at the end of the stream, or a negative value on read error.
+pub type RaptorQReadCallback = extern "C" fn(context: *mut c_void, buffer: *mut u8, buffer_len: usize) -> isize;
+
+// Adapts a read callback to io::Read
+struct CallbackReader {
+    callback: RaptorQReadCallback,
+    context: *mut c_void,
+}
+
+impl io::Read for CallbackReader {
+    fn read(&mut self, buf: &mut [u8]) -> io::Result<usize> {
+        let read = (self.callback)(self.context, buf.as_mut_ptr(), buf.len());
+        if read < 0 {
+            return Err(io::Error::new(io::ErrorKind::Other, format!("read callback returned {}", read)));
+        }
+        Ok((read as usize).min(buf.len()))
+    }
+}
+
+/// Encodes a stream using RaptorQ, block by block
+///
+/// The stream is read through `read_callback` until it returns 0, only one block
+/// is held in memory at a time. The total size doesn't need to be known up front.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `read_callback` - Callback reading the next bytes of the stream
+/// * `context` - Opaque pointer passed to every call of the callback
+/// * `output_dir` - Directory where symbols will be written
+/// * `block_size` - Size of blocks to process at once (0 = bounded by the memory limit)
+/// * `result_buffer` - Buffer to store the result (JSON metadata)
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -1 on generic error
+/// *  -2 on invalid parameters
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error (including a read error of the callback)
+/// * -14 on Encoding failed
+/// * -17 on Concurrency limit reached
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_encode_stream(
+    session_id: usize,
+    read_callback: Option<RaptorQReadCallback>,
+    context: *mut c_void,
+    output_dir: *const c_char,
+    block_size: usize,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        // Basic null pointer checks
+        let callback = match read_callback {
+            Some(c) => c,
+            None => return -2,
+        };
+        if output_dir.is_null() || result_buffer.is_null() {
+            return -2;
+        }
+
+        let output_dir_str = match c_path_arg(output_dir) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let reader = CallbackReader { callback, context };
+        match processor.encode_stream(reader, output_dir_str, block_size) {
+            Ok(result) => {
+                // Serialize result to JSON
+                let result_json = match serde_json::to_string(&result) {
+                    Ok(j) => j,
+                    Err(_) => return -3,
+                };
+
+                // Copy result to result buffer
+                let c_result = match CString::new(result_json) {
+                    Ok(s) => s,
+                    Err(_) => return -3,
+                };
+
+                let result_bytes = c_result.as_bytes_with_nul();
+                if result_bytes.len() > result_buffer_len {
+                    return -4;
+                }
+
+                unsafe {
+                    ptr::copy_nonoverlapping(
+                        result_bytes.as_ptr() as *const c_char,
+                        result_buffer,
+                        result_bytes.len(),
+                    );
+                }
+
+                0
+            },
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Callback reading bytes at an offset of the data to encode into `buffer`,
+/// returning the number of bytes read, 0 at the end of the data, or a negative
+/// value on error
+pub type RaptorQReadAtCallback = extern "C" fn(context: *mut c_void, offset: u64, buffer: *mut u8, buffer_len: usize) -> isize;
+
+// Adapts a read-at callback to ReadAt
+struct CallbackReadAt {
+    callback: RaptorQReadAtCallback,
+    context: *mut c_void,
+}
+
+// The caller of raptorq_encode_read_at guarantees the callback and its
+// context can be used from several threads at once
+unsafe impl Sync for CallbackReadAt {}
+
+impl ReadAt for CallbackReadAt {
+    fn read_at(&self, offset: u64, buf: &mut [u8]) -> io::Result<usize> {
+        let read = (self.callback)(self.context, offset, buf.as_mut_ptr(), buf.len());
+        if read < 0 {
+            return Err(io::Error::new(io::ErrorKind::Other, format!("read callback returned {} at offset {}", read, offset)));
+        }
+        Ok((read as usize).min(buf.len()))
+    }
+}
+
+/// Encodes data read at random offsets using RaptorQ, encoding its blocks in parallel
+///
+/// Each block is read from its own range through `read_callback`, so blocks are
+/// encoded on threads taking the free slots of the session's concurrency limit.
+/// The callback is called from several threads at once and must be thread-safe.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `read_callback` - Callback reading the data at an offset
+/// * `context` - Opaque pointer passed to every call of the callback
+/// * `size` - Size of the data
+/// * `output_dir` - Directory where symbols will be written
+/// * `block_size` - Size of blocks to process at once (0 = auto)
+/// * `result_buffer` - Buffer to store the result (JSON metadata)
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error (including a read error of the callback, or data shorter than `size`)
+/// * -14 on Encoding failed
+/// * -17 on Concurrency limit reached
+/// * -19 on Cancelled
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_encode_read_at(
+    session_id: usize,
+    read_callback: Option<RaptorQReadAtCallback>,
+    context: *mut c_void,
+    size: u64,
+    output_dir: *const c_char,
+    block_size: usize,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        let callback = match read_callback {
+            Some(c) => c,
+            None => return -2,
+        };
+        if result_buffer.is_null() {
+            return -2;
+        }
+
+        let output_dir_str = match c_path_arg(output_dir) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let reader = CallbackReadAt { callback, context };
+        match processor.encode_reader_at(&reader, size, output_dir_str, block_size) {
+            Ok(result) => {
+                let result_json = match serde_json::to_string(&result) {
+                    Ok(j) => j,
+                    Err(_) => return -3,
+                };
+                write_c_string(&result_json, result_buffer, result_buffer_len)
+            },
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Encodes data held in memory using RaptorQ, without touching the filesystem
+///
+/// The symbols are returned through a buffer allocated by the library. It is packed as
+/// a sequence of symbols, each one prefixed with its length as a 4 bytes little-endian
+/// integer, in the order of the blocks and symbol ids of the layout in the result JSON.
+///
+/// Memory ownership:
+/// * On success, `*symbols_buffer` and `*symbols_buffer_len` describe a buffer owned by
+///   the caller, which must be released with raptorq_free_buffer exactly once
+/// * On any error, `*symbols_buffer` is set to NULL and `*symbols_buffer_len` to 0,
+///   nothing is allocated and there is nothing to free
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `data` - Data to encode
+/// * `data_len` - Length of the data
+/// * `block_size` - Size of blocks to process at once (0 = auto)
+/// * `result_buffer` - Buffer to store the result (JSON metadata, including the layout)
+/// * `result_buffer_len` - Length of the result buffer
+/// * `symbols_buffer` - Receives the pointer to the packed symbols
+/// * `symbols_buffer_len` - Receives the length of the packed symbols
+///
+/// Returns:
+/// *   0 on success
+/// *  -1 on generic error
+/// *  -2 on invalid parameters
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -14 on Encoding failed
+/// * -16 on Memory limit exceeded
+/// * -17 on Concurrency limit reached
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_encode_bytes(
+    session_id: usize,
+    data: *const u8,
+    data_len: usize,
+    block_size: usize,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+    symbols_buffer: *mut *mut u8,
+    symbols_buffer_len: *mut usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        // Basic null pointer checks
+        if data.is_null() || result_buffer.is_null() || symbols_buffer.is_null() || symbols_buffer_len.is_null() {
+            return -2;
+        }
+
+        // Nothing is handed over to the caller unless encoding fully succeeds
+        unsafe {
+            *symbols_buffer = ptr::null_mut();
+            *symbols_buffer_len = 0;
+        }
+
+        let data_slice = unsafe { std::slice::from_raw_parts(data, data_len) };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        match processor.encode_bytes(data_slice, block_size) {
+            Ok((result, symbols)) => {
+                // Serialize result to JSON
+                let result_json = match serde_json::to_string(&result) {
+                    Ok(j) => j,
+                    Err(_) => return -3,
+                };
+
+                // Copy result to result buffer
+                let c_result = match CString::new(result_json) {
+                    Ok(s) => s,
+                    Err(_) => return -3,
+                };
+
+                let result_bytes = c_result.as_bytes_with_nul();
+                if result_bytes.len() > result_buffer_len {
+                    return -4;
+                }
+
+                unsafe {
+                    ptr::copy_nonoverlapping(
+                        result_bytes.as_ptr() as *const c_char,
+                        result_buffer,
+                        result_bytes.len(),
+                    );
+                }
+
+                let (buffer, len) = into_raw_buffer(pack_symbols(&symbols));
+                unsafe {
+                    *symbols_buffer = buffer;
+                    *symbols_buffer_len = len;
+                }
+
+                0
+            },
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Frees a buffer allocated by the library
+///
+/// Arguments:
+/// * `buffer` - Pointer returned by the library (NULL is ignored)
+/// * `buffer_len` - Length returned together with the pointer
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_free_buffer(buffer: *mut u8, buffer_len: usize) {
+    ffi_guard((), || {
+        if buffer.is_null() {
+            return;
+        }
+
+        unsafe {
+            drop(Box::from_raw(ptr::slice_from_raw_parts_mut(buffer, buffer_len)));
+        }
+    })
+}
+
+// Hands the ownership of the data over to the caller, to be released with raptorq_free_buffer
+fn into_raw_buffer(data: Vec<u8>) -> (*mut u8, usize) {
+    let boxed = data.into_boxed_slice();
+    let len = boxed.len();
+    (Box::into_raw(boxed) as *mut u8, len)
+}
+
+// Records the truncated records of packed symbols as the last error of the processor
+fn truncated_records_error(processor: &RaptorQProcessor, what: &str) -> i32 {
+    let err = ProcessError::InvalidParameter(format!("Packed {} are truncated", what));
+    processor.set_last_error(err.to_string());
+    operation_error(processor, &err)
+}
+
+// Packs the symbols as records prefixed with their length (4 bytes, little-endian)
+fn pack_symbols(symbols: &[Vec<u8>]) -> Vec<u8> {
+    let packed_len = symbols.iter().map(|s| 4 + s.len()).sum();
+    let mut packed = Vec::with_capacity(packed_len);
+    for symbol in symbols {
+        packed.extend_from_slice(&(symbol.len() as u32).to_le_bytes());
+        packed.extend_from_slice(symbol);
+    }
+    packed
+}
+
+// Splits symbols packed by pack_symbols, None if the records are truncated
+fn unpack_symbols(packed: &[u8]) -> Option<Vec<&[u8]>> {
+    let mut symbols = Vec::new();
+    let mut rest = packed;
+    while !rest.is_empty() {
+        if rest.len() < 4 {
+            return None;
+        }
+        let len = u32::from_le_bytes([rest[0], rest[1], rest[2], rest[3]]) as usize;
+        if rest.len() - 4 < len {
+            return None;
+        }
+        symbols.push(&rest[4..4 + len]);
+        rest = &rest[4 + len..];
+    }
+    Some(symbols)
+}
+
+/// Starts encoding a file block by block
+///
+/// Each block is then encoded by a call to raptorq_encode_next_block, so the caller
+/// can report progress between blocks, and raptorq_encode_finish writes the layout.
+/// A job must be released by raptorq_encode_finish or raptorq_encode_abort.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `input_path` - Path to the input file
+/// * `output_dir` - Directory where symbols will be written
+/// * `block_size` - Size of blocks to process at once (0 = auto)
+/// * `job_id` - Receives the ID of the encode job
+/// * `blocks_total` - Receives the number of blocks to encode (can be NULL)
+///
+/// Returns:
+/// *   0 on success
+/// *  -1 on generic error
+/// *  -2 on invalid parameters
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error
+/// * -12 on File not found
+/// * -14 on Encoding failed
+/// * -17 on Concurrency limit reached
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_encode_begin(
+    session_id: usize,
+    input_path: *const c_char,
+    output_dir: *const c_char,
+    block_size: usize,
+    job_id: *mut usize,
+    blocks_total: *mut usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        // Basic null pointer checks
+        if input_path.is_null() || output_dir.is_null() || job_id.is_null() {
+            return -2;
+        }
+
+        let input_path_str = match c_path_arg(input_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let output_dir_str = match c_path_arg(output_dir) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        match processor.begin_encode_file(input_path_str, output_dir_str, block_size) {
+            Ok(job) => {
+                let id = tagged_id(JOB_COUNTER.fetch_add(1, Ordering::SeqCst));
+                unsafe {
+                    *job_id = id;
+                    if !blocks_total.is_null() {
+                        *blocks_total = job.progress().blocks_total;
+                    }
+                }
+
+                let entry = EncodeJobEntry {
+                    processor,
+                    job: Mutex::new(Some(job)),
+                    result: Mutex::new(None),
+                };
+                ENCODE_JOBS.lock().insert(id, Arc::new(entry));
+
+                0
+            },
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Encodes the next block of a job started with raptorq_encode_begin
+///
+/// Does nothing once all blocks are encoded. The job is kept on error,
+/// it must still be released with raptorq_encode_abort.
+///
+/// Arguments:
+/// * `job_id` - Job ID returned from raptorq_encode_begin
+/// * `blocks_done` - Receives the number of blocks encoded so far (can be NULL)
+/// * `bytes_processed` - Receives the number of bytes encoded so far (can be NULL)
+///
+/// Returns:
+/// *   0 on success
+/// *  -1 on generic error
+/// *  -5 on invalid job
+/// * -11 on IO error
+/// * -14 on Encoding failed
+/// * -17 on Concurrency limit reached
+/// * -19 on Cancelled
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_encode_next_block(
+    job_id: usize,
+    blocks_done: *mut usize,
+    bytes_processed: *mut u64,
+) -> i32 {
+    ffi_guard(-1, || {
+        let entry = match ENCODE_JOBS.lock().get(&job_id).cloned() {
+            Some(e) => e,
+            None => return -5,
+        };
+
+        let mut job_guard = entry.job.lock();
+        let job = match job_guard.as_mut() {
+            Some(j) => j,
+            None => return -5,
+        };
+
+        match entry.processor.encode_next_block(job) {
+            Ok(progress) => {
+                unsafe {
+                    if !blocks_done.is_null() {
+                        *blocks_done = progress.blocks_done;
+                    }
+                    if !bytes_processed.is_null() {
+                        *bytes_processed = progress.bytes_processed;
+                    }
+                }
+                0
+            },
+            Err(e) => operation_error(&entry.processor, &e),
+        }
+    })
+}
+
+/// Writes the layout of a job whose blocks were all encoded and releases the job
+///
+/// The job is released once its result is copied to the buffer. It is kept if some
+/// blocks are not encoded yet or the buffer is too small, so the call can be made
+/// again, the layout being written only once. A job failing to write its layout is
+/// released, as it can't be finished anymore.
+///
+/// Arguments:
+/// * `job_id` - Job ID returned from raptorq_encode_begin
+/// * `result_buffer` - Buffer to store the result (JSON metadata)
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -1 on generic error
+/// *  -2 on invalid parameters
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size
+/// *  -5 on invalid job
+/// * -11 on IO error
+/// * -14 on Encoding failed (including blocks not encoded yet)
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_encode_finish(
+    job_id: usize,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        if result_buffer.is_null() {
+            return -2;
+        }
+
+        let entry = match ENCODE_JOBS.lock().get(&job_id).cloned() {
+            Some(e) => e,
+            None => return -5,
+        };
+
+        // Finished by a previous call whose buffer was too small otherwise
+        let mut finished = entry.result.lock();
+        if finished.is_none() {
+            let mut job_guard = entry.job.lock();
+            let job = match job_guard.take() {
+                Some(j) => j,
+                None => return -5,
+            };
+            if let Err(e) = entry.processor.check_encode_done(&job) {
+                *job_guard = Some(job);
+                return operation_error(&entry.processor, &e);
+            }
+
+            let result = match entry.processor.finish_encode_file(job) {
+                Ok(result) => result,
+                Err(e) => {
+                    ENCODE_JOBS.lock().remove(&job_id);
+                    return operation_error(&entry.processor, &e);
+                }
+            };
+
+            // Serialize result to JSON
+            let c_result = match serde_json::to_string(&result).map(CString::new) {
+                Ok(Ok(s)) => s,
+                _ => {
+                    ENCODE_JOBS.lock().remove(&job_id);
+                    return -3;
+                }
+            };
+            *finished = Some(c_result);
+        }
+
+        // Copy result to result buffer
+        let result_bytes = match finished.as_ref() {
+            Some(c_result) => c_result.as_bytes_with_nul(),
+            None => return -3,
+        };
+        if result_bytes.len() > result_buffer_len {
+            return -4;
+        }
+
+        unsafe {
+            ptr::copy_nonoverlapping(
+                result_bytes.as_ptr() as *const c_char,
+                result_buffer,
+                result_bytes.len(),
+            );
+        }
+
+        ENCODE_JOBS.lock().remove(&job_id);
+        0
+    })
+}
+
+/// Releases a job started with raptorq_encode_begin without finishing it
+///
+/// Symbols of the blocks already encoded are left in the output directory.
+///
+/// Returns true if the job existed
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_encode_abort(job_id: usize) -> bool {
+    ffi_guard(false, || {
+        ENCODE_JOBS.lock().remove(&job_id).is_some()
+    })
+}
+
+/// Gets the last error message from the processor
+///
+/// The last error is kept per thread, as are its code, the shortfalls and the corrupt
+/// symbols: a thread gets the error of the last operation it called on the session,
+/// even while other threads run operations on the same session. They are dropped when
+/// the thread exits.
+///
+/// The thread is the OS thread, so callers whose tasks move between OS threads must
+/// keep the operation and the reads of its error on one thread. From Go, call
+/// `runtime.LockOSThread()` before the operation and `runtime.UnlockOSThread()` once
+/// its error is read, otherwise the error of another call, or none, may be returned.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `error_buffer` - Buffer to store the error message
+/// * `error_buffer_len` - Length of the error buffer
+///
+/// Returns:
+/// * 0 on success
+/// * -1 on error
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_get_last_error(
+    session_id: usize,
+    error_buffer: *mut c_char,
+    error_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        if error_buffer.is_null() {
+            return -1;
+        }
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return -1,
+        };
+
+        let error_msg = processor.get_last_error();
+        let c_error = match CString::new(error_msg) {
+            Ok(s) => s,
+            Err(_) => return -1,
+        };
+
+        let error_bytes = c_error.as_bytes_with_nul();
+        if error_bytes.len() > error_buffer_len {
+            // Error message too long, truncate
+            unsafe {
+                ptr::copy_nonoverlapping(
+                    error_bytes.as_ptr() as *const c_char,
+                    error_buffer,
+                    error_buffer_len - 1,
+                );
+                *error_buffer.add(error_buffer_len - 1) = 0;
+            }
+        } else {
+            unsafe {
+                ptr::copy_nonoverlapping(
+                    error_bytes.as_ptr() as *const c_char,
+                    error_buffer,
+                    error_bytes.len(),
+                );
+            }
+        }
+
+        0
+    })
+}
+
+/// Gets the last error of a session with its code
+///
+/// The code is the one returned by the last operation of the session that failed:
+/// * -11 to -23 when the operation itself failed (IO error, file not found, invalid
+///   path, encoding or decoding failed, memory limit, concurrency limit, missing
+///   symbols, cancelled, symbol not found, timed out, object mismatch, unsupported
+///   layout version), the message then gives the details
+/// * -2 when the operation rejected its configuration or parameters
+///
+/// Failures of the call itself (-1 to -5: NULL arguments, result buffer too small,
+/// unknown session) are returned directly and not recorded. The code is 0 if no
+/// operation failed since the session was created or reset.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `error_code` - Receives the code of the last error (can be NULL)
+/// * `error_buffer` - Buffer to store the error message
+/// * `error_buffer_len` - Length of the error buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_get_last_error_detail(
+    session_id: usize,
+    error_code: *mut i32,
+    error_buffer: *mut c_char,
+    error_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        if error_buffer.is_null() {
+            return -2;
+        }
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let result = write_c_string(&processor.get_last_error(), error_buffer, error_buffer_len);
+        if result == 0 && !error_code.is_null() {
+            unsafe { *error_code = processor.get_last_error_code(); }
+        }
+        result
+    })
+}
+
+/// Decodes RaptorQ symbols back to the original file
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `symbols_dir` - Directory containing the symbols
+/// * `output_path` - Path where the decoded file will be written
+/// * `layout_path` - Path to the layout file (containing encoder parameters and block information)
+///
+/// Returns:
+/// *   0 on success
+/// *  -1 on generic error
+/// *  -2 on invalid parameters
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size
+/// *  -4 on encoding failure
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error
+/// * -12 on File not found
+/// * -13 on Invalid Path
+/// * -15 on Decoding failed
+/// * -16 on Memory limit exceeded
+/// * -17 on Concurrency limit reached
+/// * -18 on Insufficient symbols (see raptorq_get_last_shortfalls)
+/// * -22 if the symbols directory holds the layout of another object
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_decode_symbols(
+    session_id: usize,
+    symbols_dir: *const c_char,
+    output_path: *const c_char,
+    layout_path: *const c_char,
+) -> i32 {
+    ffi_guard(-1, || {
+        // Basic null pointer checks
+        if symbols_dir.is_null() || output_path.is_null() || layout_path.is_null() {
+            return -2;
+        }
+
+        let symbols_dir_str = match c_path_arg(symbols_dir) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let output_path_str = match c_path_arg(output_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let layout_path_str = match c_path_arg(layout_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        match processor.decode_symbols(symbols_dir_str, output_path_str, layout_path_str) {
+            Ok(_) => 0,
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Decodes RaptorQ symbols to recreate the original file, then writes back the source
+/// symbols missing from the symbols directory
+///
+/// The missing source symbols are encoded again from the decoded file and written
+/// where decodes look them up, so later decodes find them. Lost repair symbols are not
+/// regenerated and the layout file is not modified.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `symbols_dir` - Path to the directory containing the symbol files, repaired in place
+/// * `output_path` - Path where the decoded file will be written
+/// * `layout_path` - Path to the layout file
+/// * `repaired` - Receives the number of source symbols written back
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error
+/// * -12 on File not found
+/// * -15 on Decoding failed
+/// * -17 on Concurrency limit reached
+/// * -18 on Insufficient symbols (see raptorq_get_last_shortfalls), the directory is left untouched
+/// * -22 if the symbols directory holds the layout of another object
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_decode_and_repair(
+    session_id: usize,
+    symbols_dir: *const c_char,
+    output_path: *const c_char,
+    layout_path: *const c_char,
+    repaired: *mut u64,
+) -> i32 {
+    ffi_guard(-1, || {
+        if symbols_dir.is_null() || output_path.is_null() || layout_path.is_null() || repaired.is_null() {
+            return -2;
+        }
+
+        let symbols_dir_str = match c_path_arg(symbols_dir) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let output_path_str = match c_path_arg(output_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let layout_path_str = match c_path_arg(layout_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        match processor.decode_and_repair(symbols_dir_str, output_path_str, layout_path_str) {
+            Ok(count) => {
+                unsafe { *repaired = count; }
+                0
+            },
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Encodes a file without a session, as raptorq_encode_file does with a session of
+/// the given configuration created and freed by the call
+///
+/// Arguments:
+/// * `symbol_size`, `redundancy_factor`, `max_memory_mb`, `concurrency_limit` -
+///   Configuration, as given to raptorq_init_session
+/// * `input_path` - Path to the input file
+/// * `output_dir` - Directory where symbols will be written
+/// * `block_size` - Size of blocks to process at once (0 = auto)
+/// * `result_buffer` - Buffer to store the result (JSON metadata)
+/// * `result_buffer_len` - Length of the result buffer
+/// * `error_buffer` - Optional buffer to store the error message, as there is no
+///   session to read it from
+/// * `error_buffer_len` - Length of the error buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters, including an invalid configuration
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size, the symbols are written nonetheless
+/// * Other codes of raptorq_encode_file on failure
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_encode_file_oneshot(
+    symbol_size: u16,
+    redundancy_factor: u8,
+    max_memory_mb: u64,
+    concurrency_limit: u64,
+    input_path: *const c_char,
+    output_dir: *const c_char,
+    block_size: usize,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+    error_buffer: *mut c_char,
+    error_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        if result_buffer.is_null() {
+            return -2;
+        }
+        let (Some(input_path_str), Some(output_dir_str)) = (c_path_arg(input_path), c_path_arg(output_dir)) else {
+            return -2;
+        };
+
+        let config = ProcessorConfig {
+            symbol_size,
+            redundancy_factor,
+            max_memory_mb,
+            concurrency_limit,
+        };
+        match encode_file(input_path_str, output_dir_str, config, block_size) {
+            Ok(result) => match serde_json::to_string(&result) {
+                Ok(result_json) => write_c_string(&result_json, result_buffer, result_buffer_len),
+                Err(_) => -3,
+            },
+            Err(e) => {
+                write_error_message(&e.to_string(), error_buffer, error_buffer_len);
+                error_code(&e)
+            }
+        }
+    })
+}
+
+/// Decodes symbols without a session, as raptorq_decode_symbols does with a session
+/// of the given configuration created and freed by the call
+///
+/// Arguments:
+/// * `symbol_size`, `redundancy_factor`, `max_memory_mb`, `concurrency_limit` -
+///   Configuration, as given to raptorq_init_session
+/// * `symbols_dir` - Directory containing the symbols
+/// * `output_path` - Path where the decoded file will be written
+/// * `layout_path` - Path to the layout file
+/// * `error_buffer` - Optional buffer to store the error message, as there is no
+///   session to read it from
+/// * `error_buffer_len` - Length of the error buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters, including an invalid configuration
+/// * Other codes of raptorq_decode_symbols on failure
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_decode_symbols_oneshot(
+    symbol_size: u16,
+    redundancy_factor: u8,
+    max_memory_mb: u64,
+    concurrency_limit: u64,
+    symbols_dir: *const c_char,
+    output_path: *const c_char,
+    layout_path: *const c_char,
+    error_buffer: *mut c_char,
+    error_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        let (Some(symbols_dir_str), Some(output_path_str), Some(layout_path_str)) =
+            (c_path_arg(symbols_dir), c_path_arg(output_path), c_path_arg(layout_path))
+        else {
+            return -2;
+        };
+
+        let config = ProcessorConfig {
+            symbol_size,
+            redundancy_factor,
+            max_memory_mb,
+            concurrency_limit,
+        };
+        match decode_symbols(symbols_dir_str, output_path_str, layout_path_str, config) {
+            Ok(()) => 0,
+            Err(e) => {
+                write_error_message(&e.to_string(), error_buffer, error_buffer_len);
+                error_code(&e)
+            }
+        }
+    })
+}
+
+/// Decodes the symbols of a directory with the layout file it holds
+///
+/// The `_raptorq_layout.json` written next to the symbols by raptorq_encode_file is
+/// used when present, so the symbols can't be decoded with the layout of another
+/// object. The given layout file is only used for directories without one.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `symbols_dir` - Directory containing the symbols
+/// * `output_path` - Path where the decoded file will be written
+/// * `layout_path` - Path to the layout file to use if the directory has none, or NULL
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error
+/// * -12 if the directory has no layout file and none is given, or on File not found
+/// * -13 on Invalid Path
+/// * -15 on Decoding failed
+/// * -16 on Memory limit exceeded
+/// * -17 on Concurrency limit reached
+/// * -18 on Insufficient symbols (see raptorq_get_last_shortfalls)
+/// * -23 if the layout is of a newer version than the library supports
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_decode_dir(
+    session_id: usize,
+    symbols_dir: *const c_char,
+    output_path: *const c_char,
+    layout_path: *const c_char,
+) -> i32 {
+    ffi_guard(-1, || {
+        let (Some(symbols_dir_str), Some(output_path_str)) = (c_path_arg(symbols_dir), c_path_arg(output_path)) else {
+            return -2;
+        };
+
+        // The layout path is optional
+        let layout_path_str = if layout_path.is_null() {
+            None
+        } else {
+            match c_path_arg(layout_path) {
+                Some(s) => Some(s),
+                None => return -2,
+            }
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        match processor.decode_dir(symbols_dir_str, output_path_str, layout_path_str) {
+            Ok(_) => 0,
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Decodes RaptorQ symbols read from a tar archive back to the original file
+///
+/// The archive holds the symbols as `block_<id>/<symbol_id>` entries, like the
+/// symbols directory, and is read without being extracted.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `tar_path` - Path to the tar archive
+/// * `output_path` - Path where the decoded file will be written
+/// * `layout_path` - Path to the layout file, or NULL to read the `_raptorq_layout.json`
+///   entry of the archive
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error, including a truncated or corrupt archive
+/// * -12 if the archive or the layout is not found
+/// * -15 on Decoding failed
+/// * -17 on Concurrency limit reached
+/// * -18 on Insufficient symbols (see raptorq_get_last_shortfalls)
+/// * -19 if the session was cancelled
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_decode_from_tar(
+    session_id: usize,
+    tar_path: *const c_char,
+    output_path: *const c_char,
+    layout_path: *const c_char,
+) -> i32 {
+    ffi_guard(-1, || {
+        // Basic null pointer checks, the layout path is optional
+        if tar_path.is_null() || output_path.is_null() {
+            return -2;
+        }
+
+        let tar_path_str = match c_path_arg(tar_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let output_path_str = match c_path_arg(output_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let layout_path_str = if layout_path.is_null() {
+            None
+        } else {
+            match c_path_arg(layout_path) {
+                Some(s) => Some(s),
+                None => return -2,
+            }
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        match processor.decode_from_tar(tar_path_str, output_path_str, layout_path_str) {
+            Ok(_) => 0,
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Decodes an archive written by raptorq_encode_file_to_archive back to the original file
+///
+/// Same as raptorq_decode_from_tar with the layout of the archive.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `archive_path` - Path to the archive
+/// * `output_path` - Path where the decoded file will be written
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error, including a truncated or corrupt archive
+/// * -12 if the archive or its layout is not found
+/// * -15 on Decoding failed
+/// * -17 on Concurrency limit reached
+/// * -18 on Insufficient symbols (see raptorq_get_last_shortfalls)
+/// * -19 if the session was cancelled
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_decode_from_archive(
+    session_id: usize,
+    archive_path: *const c_char,
+    output_path: *const c_char,
+) -> i32 {
+    ffi_guard(-1, || {
+        // Basic null pointer checks
+        if archive_path.is_null() || output_path.is_null() {
+            return -2;
+        }
+
+        let archive_path_str = match c_path_arg(archive_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let output_path_str = match c_path_arg(output_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        match processor.decode_from_archive(archive_path_str, output_path_str) {
+            Ok(_) => 0,
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Decodes RaptorQ symbols back to the original file, checking every symbol first
+///
+/// The id of a symbol is the hash of its content: symbol files whose content
+/// doesn't match their name are skipped and the other symbols of the block are
+/// used instead. The skipped symbols are listed by raptorq_get_last_corrupt_symbols.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `symbols_dir` - Directory containing the symbols
+/// * `output_path` - Path where the decoded file will be written
+/// * `layout_path` - Path to the layout file (containing encoder parameters and block information)
+///
+/// Returns:
+/// *   0 on success, even if corrupt symbols were skipped
+/// *  -2 on invalid parameters
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error
+/// * -12 on File not found
+/// * -13 on Invalid Path
+/// * -15 on Decoding failed
+/// * -16 on Memory limit exceeded
+/// * -17 on Concurrency limit reached
+/// * -18 on Insufficient symbols (see raptorq_get_last_shortfalls)
+/// * -22 if the symbols directory holds the layout of another object
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_decode_symbols_verified(
+    session_id: usize,
+    symbols_dir: *const c_char,
+    output_path: *const c_char,
+    layout_path: *const c_char,
+) -> i32 {
+    ffi_guard(-1, || {
+        // Basic null pointer checks
+        if symbols_dir.is_null() || output_path.is_null() || layout_path.is_null() {
+            return -2;
+        }
+
+        let symbols_dir_str = match c_path_arg(symbols_dir) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let output_path_str = match c_path_arg(output_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let layout_path_str = match c_path_arg(layout_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        match processor.decode_symbols_verified(symbols_dir_str, output_path_str, layout_path_str) {
+            Ok(_) => 0,
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Decodes RaptorQ symbols back to the original file, decoding its blocks in parallel
+///
+/// Blocks are decoded on threads taking the free slots of the session's concurrency
+/// limit and written at their offsets as they complete.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `symbols_dir` - Directory containing the symbols
+/// * `output_path` - Path where the decoded file will be written
+/// * `layout_path` - Path to the layout file (containing encoder parameters and block information)
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error
+/// * -12 on File not found
+/// * -13 on Invalid Path
+/// * -15 on Decoding failed
+/// * -16 on Memory limit exceeded
+/// * -17 on Concurrency limit reached
+/// * -18 on Insufficient symbols (see raptorq_get_last_shortfalls)
+/// * -19 on Cancelled
+/// * -22 if the symbols directory holds the layout of another object
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_decode_symbols_parallel(
+    session_id: usize,
+    symbols_dir: *const c_char,
+    output_path: *const c_char,
+    layout_path: *const c_char,
+) -> i32 {
+    ffi_guard(-1, || {
+        // Basic null pointer checks
+        if symbols_dir.is_null() || output_path.is_null() || layout_path.is_null() {
+            return -2;
+        }
+
+        let symbols_dir_str = match c_path_arg(symbols_dir) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let output_path_str = match c_path_arg(output_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let layout_path_str = match c_path_arg(layout_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        match processor.decode_symbols_parallel(symbols_dir_str, output_path_str, layout_path_str) {
+            Ok(_) => 0,
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Decodes RaptorQ symbols spread over several directories back to the original file
+///
+/// The directories are used as one, each symbol being read from the first directory
+/// having it, so they don't need to be copied into one directory first.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `symbols_dirs_json` - JSON array of the directories containing the symbols
+/// * `output_path` - Path where the decoded file will be written
+/// * `layout_path` - Path to the layout file (containing encoder parameters and block information)
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters, including an empty or malformed array
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error
+/// * -12 on File not found
+/// * -13 on Invalid Path, including a directory that doesn't exist
+/// * -15 on Decoding failed
+/// * -16 on Memory limit exceeded
+/// * -17 on Concurrency limit reached
+/// * -18 on Insufficient symbols (see raptorq_get_last_shortfalls)
+/// * -19 on Cancelled
+/// * -22 if the symbols directory holds the layout of another object
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_decode_from_dirs(
+    session_id: usize,
+    symbols_dirs_json: *const c_char,
+    output_path: *const c_char,
+    layout_path: *const c_char,
+) -> i32 {
+    ffi_guard(-1, || {
+        if symbols_dirs_json.is_null() {
+            return -2;
+        }
+
+        let symbols_dirs_str = match unsafe { CStr::from_ptr(symbols_dirs_json) }.to_str() {
+            Ok(s) => s,
+            Err(_) => return -2,
+        };
+
+        let symbols_dirs: Vec<String> = match serde_json::from_str(symbols_dirs_str) {
+            Ok(dirs) => dirs,
+            Err(_) => return -2,
+        };
+        if symbols_dirs.is_empty() || symbols_dirs.iter().any(|dir| dir.is_empty()) {
+            return -2;
+        }
+
+        let output_path_str = match c_path_arg(output_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let layout_path_str = match c_path_arg(layout_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let symbols_dirs: Vec<&str> = symbols_dirs.iter().map(String::as_str).collect();
+        match processor.decode_from_dirs(&symbols_dirs, output_path_str, layout_path_str) {
+            Ok(_) => 0,
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Copies the symbols of an object into several shard directories, dealing the symbols
+/// of each block in turn to the shards
+///
+/// Every shard holds about the same share of the symbols of every block, in block
+/// directories, and the map of all shards in `_raptorq_shards.json`. The shards decode
+/// together with raptorq_decode_from_dirs. The result is the map as a JSON object, for
+/// example `{"shard_dirs":["a","b"],"symbols":{"<symbol_id>":0},"decodable_shards":[]}`,
+/// with the shards holding enough symbols to decode every block on their own. Symbol
+/// files that don't hold the symbol of their id are not copied.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `symbols_dir` - Directory containing the symbols
+/// * `layout_path` - Path to the layout file
+/// * `shard_dirs_json` - JSON array of the distinct shard directories, created if needed
+/// * `result_buffer` - Buffer to store the JSON map
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters, including an empty, malformed or repeated array and
+///       symbols encrypted with another key
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size, the shards and their maps are written nonetheless
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error
+/// * -12 if the layout file is not found
+/// * -13 if the symbols directory doesn't exist
+/// * -15 if the layout can't be parsed
+/// * -17 on Concurrency limit reached
+/// * -19 on Cancelled
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_shard_symbols(
+    session_id: usize,
+    symbols_dir: *const c_char,
+    layout_path: *const c_char,
+    shard_dirs_json: *const c_char,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        if shard_dirs_json.is_null() || result_buffer.is_null() {
+            return -2;
+        }
+
+        let shard_dirs_str = match unsafe { CStr::from_ptr(shard_dirs_json) }.to_str() {
+            Ok(s) => s,
+            Err(_) => return -2,
+        };
+
+        let shard_dirs: Vec<String> = match serde_json::from_str(shard_dirs_str) {
+            Ok(dirs) => dirs,
+            Err(_) => return -2,
+        };
+        if shard_dirs.is_empty() || shard_dirs.iter().any(|dir| dir.is_empty()) {
+            return -2;
+        }
+
+        let symbols_dir_str = match c_path_arg(symbols_dir) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let layout_path_str = match c_path_arg(layout_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let shard_dirs: Vec<&str> = shard_dirs.iter().map(String::as_str).collect();
+        let map = match processor.shard_symbols(symbols_dir_str, layout_path_str, &shard_dirs) {
+            Ok(m) => m,
+            Err(e) => return operation_error(&processor, &e),
+        };
+
+        let result_json = match serde_json::to_string(&map) {
+            Ok(j) => j,
+            Err(_) => return -3,
+        };
+
+        write_c_string(&result_json, result_buffer, result_buffer_len)
+    })
+}
+
+/// Decodes the given symbol files only back to the original file
+///
+/// Symbol files are named after their symbol id, so the layout gives the block of
+/// each of them and no directory is listed. Files that are not in the layout, missing
+/// or unreadable are skipped.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `symbol_paths_json` - JSON array of the paths of the symbol files
+/// * `output_path` - Path where the decoded file will be written
+/// * `layout_path` - Path to the layout file (containing encoder parameters and block information)
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters, including a malformed array
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error
+/// * -12 on File not found
+/// * -15 on Decoding failed
+/// * -16 on Memory limit exceeded
+/// * -17 on Concurrency limit reached
+/// * -18 on Insufficient symbols (see raptorq_get_last_shortfalls)
+/// * -19 on Cancelled
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_decode_from_files(
+    session_id: usize,
+    symbol_paths_json: *const c_char,
+    output_path: *const c_char,
+    layout_path: *const c_char,
+) -> i32 {
+    ffi_guard(-1, || {
+        if symbol_paths_json.is_null() {
+            return -2;
+        }
+
+        let symbol_paths_str = match unsafe { CStr::from_ptr(symbol_paths_json) }.to_str() {
+            Ok(s) => s,
+            Err(_) => return -2,
+        };
+
+        let symbol_paths: Vec<String> = match serde_json::from_str(symbol_paths_str) {
+            Ok(paths) => paths,
+            Err(_) => return -2,
+        };
+
+        let output_path_str = match c_path_arg(output_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let layout_path_str = match c_path_arg(layout_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let symbol_paths: Vec<&str> = symbol_paths.iter().map(String::as_str).collect();
+        match processor.decode_from_files(&symbol_paths, output_path_str, layout_path_str) {
+            Ok(_) => 0,
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Decodes RaptorQ symbols back to the original file and checks the whole file
+/// against the SHA-256 recorded in the layout by raptorq_encode_file
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `symbols_dir` - Directory containing the symbols
+/// * `output_path` - Path where the decoded file will be written
+/// * `layout_path` - Path to the layout file, with an `object_sha256`
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error
+/// * -12 on File not found
+/// * -13 on Invalid Path
+/// * -15 on Decoding failed, also if the layout has no object hash or the decoded
+///       file doesn't match it (the file is kept, see raptorq_get_last_error)
+/// * -16 on Memory limit exceeded
+/// * -17 on Concurrency limit reached
+/// * -18 on Insufficient symbols (see raptorq_get_last_shortfalls)
+/// * -22 if the symbols directory holds the layout of another object
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_decode_symbols_checked(
+    session_id: usize,
+    symbols_dir: *const c_char,
+    output_path: *const c_char,
+    layout_path: *const c_char,
+) -> i32 {
+    ffi_guard(-1, || {
+        // Basic null pointer checks
+        if symbols_dir.is_null() || output_path.is_null() || layout_path.is_null() {
+            return -2;
+        }
+
+        let symbols_dir_str = match c_path_arg(symbols_dir) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let output_path_str = match c_path_arg(output_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let layout_path_str = match c_path_arg(layout_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        match processor.decode_symbols_checked(symbols_dir_str, output_path_str, layout_path_str) {
+            Ok(_) => 0,
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Checks whether the symbols of a directory are likely enough to decode a layout,
+/// without decoding it
+///
+/// Each block needs the number of symbols given by raptorq_min_symbols_for_block,
+/// or all of its symbols if the layout has fewer.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `symbols_dir` - Directory containing the symbols
+/// * `layout_path` - Path to the layout file
+///
+/// Returns:
+/// *   1 if every block has enough symbols
+/// *   0 if a block is missing symbols or the symbols directory does not exist
+/// *  -2 on invalid parameters
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error
+/// * -12 if the layout file is not found
+/// * -15 if the layout is invalid
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_can_decode(
+    session_id: usize,
+    symbols_dir: *const c_char,
+    layout_path: *const c_char,
+) -> i32 {
+    ffi_guard(-1, || {
+        if symbols_dir.is_null() || layout_path.is_null() {
+            return -2;
+        }
+
+        let symbols_dir_str = match c_path_arg(symbols_dir) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let layout_path_str = match c_path_arg(layout_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        match processor.can_decode(symbols_dir_str, layout_path_str) {
+            Ok(decodable) => decodable as i32,
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Counts, for each block of a layout, how many more symbols a directory needs
+///
+/// The result is a JSON object mapping each block id to the number of symbols missing
+/// to reach raptorq_min_symbols_for_block, zero or negative if the block already has
+/// enough, for example `{"0":-108,"1":7}`.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `symbols_dir` - Directory containing the symbols
+/// * `layout_path` - Path to the layout file
+/// * `result_buffer` - Buffer to store the JSON object
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error
+/// * -12 if the layout file is not found
+/// * -15 if the layout is invalid
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_missing_symbols(
+    session_id: usize,
+    symbols_dir: *const c_char,
+    layout_path: *const c_char,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        if symbols_dir.is_null() || layout_path.is_null() || result_buffer.is_null() {
+            return -2;
+        }
+
+        let symbols_dir_str = match c_path_arg(symbols_dir) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let layout_path_str = match c_path_arg(layout_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let missing = match processor.missing_symbols(symbols_dir_str, layout_path_str) {
+            Ok(m) => m,
+            Err(e) => return operation_error(&processor, &e),
+        };
+
+        let result_json = match serde_json::to_string(&missing) {
+            Ok(j) => j,
+            Err(_) => return -3,
+        };
+
+        let c_result = match CString::new(result_json) {
+            Ok(s) => s,
+            Err(_) => return -3,
+        };
+
+        let result_bytes = c_result.as_bytes_with_nul();
+        if result_bytes.len() > result_buffer_len {
+            return -4;
+        }
+
+        unsafe {
+            ptr::copy_nonoverlapping(
+                result_bytes.as_ptr() as *const c_char,
+                result_buffer,
+                result_bytes.len(),
+            );
+        }
+
+        0
+    })
+}
+
+/// Checks a layout and the symbols of a directory and describes every problem found
+///
+/// The result is a JSON array of issues, empty if the layout can be decoded with all its
+/// symbols. Each issue has a `kind`, a `message` and, unless it is about the whole
+/// layout, a `block_id`, for example
+/// `[{"block_id":1,"kind":"insufficient_symbols","message":"Block 1 has 5 of its 12 symbols on disk, 11 are needed to decode it"}]`.
+/// The kinds are `no_blocks`, `duplicate_block`, `missing_block`, `invalid_encoder_parameters`,
+/// `size_mismatch`, `offset_mismatch`, `too_few_symbols`, `missing_symbols_directory`,
+/// `missing_symbols` and `insufficient_symbols`; only `missing_symbols` still allows decoding.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `symbols_dir` - Directory containing the symbols
+/// * `layout_path` - Path to the layout file
+/// * `result_buffer` - Buffer to store the JSON array
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// Returns:
+/// *   0 on success, even if issues were found
+/// *  -2 on invalid parameters
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error
+/// * -12 if the layout file is not found
+/// * -15 if the layout can't be parsed
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_validate_layout(
+    session_id: usize,
+    symbols_dir: *const c_char,
+    layout_path: *const c_char,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        if symbols_dir.is_null() || layout_path.is_null() || result_buffer.is_null() {
+            return -2;
+        }
+
+        let symbols_dir_str = match c_path_arg(symbols_dir) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let layout_path_str = match c_path_arg(layout_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let issues = match processor.validate_layout(symbols_dir_str, layout_path_str) {
+            Ok(i) => i,
+            Err(e) => return operation_error(&processor, &e),
+        };
+
+        let result_json = match serde_json::to_string(&issues) {
+            Ok(j) => j,
+            Err(_) => return -3,
+        };
+
+        write_c_string(&result_json, result_buffer, result_buffer_len)
+    })
+}
+
+/// Callback receiving a problem found by raptorq_scrub_symbols as a JSON object
+///
+/// `issue_json` is a null terminated string only valid during the call.
+pub type RaptorQIssueCallback = extern "C" fn(context: *mut c_void, issue_json: *const c_char);
+
+/// Reads every symbol of a layout from its directory and passes each problem found
+/// to a callback as soon as it is found
+///
+/// Unlike raptorq_validate_layout, the symbol files are read one at a time with the
+/// symbol format of the layout and checked to hold a whole symbol of their block under
+/// the id they are named after, so a large directory is scrubbed with the memory of one
+/// symbol. Each issue has the fields of raptorq_validate_layout and a `symbol_id`, for example
+/// `{"block_id":0,"symbol_id":"4Nd...","kind":"symbol_size_mismatch","message":"..."}`,
+/// with the kinds `missing_symbol`, `unreadable_symbol`, `symbol_size_mismatch` and
+/// `symbol_id_mismatch`, and `missing_symbols_directory` or `invalid_encoder_parameters`
+/// without a `symbol_id`. The callback is called from the calling thread.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `symbols_dir` - Directory containing the symbols
+/// * `layout_path` - Path to the layout file
+/// * `issue_callback` - Callback receiving the issues
+/// * `context` - Opaque pointer passed to every call of the callback
+///
+/// Returns:
+/// *   0 on success, even if issues were found
+/// *  -2 on invalid parameters, including symbols encrypted with another key
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error
+/// * -12 if the layout file is not found
+/// * -15 if the layout can't be parsed
+/// * -17 on Concurrency limit reached
+/// * -23 if the layout is of a newer version than the library supports
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_scrub_symbols(
+    session_id: usize,
+    symbols_dir: *const c_char,
+    layout_path: *const c_char,
+    issue_callback: Option<RaptorQIssueCallback>,
+    context: *mut c_void,
+) -> i32 {
+    ffi_guard(-1, || {
+        let Some(callback) = issue_callback else {
+            return -2;
+        };
+        let (Some(symbols_dir_str), Some(layout_path_str)) = (c_path_arg(symbols_dir), c_path_arg(layout_path)) else {
+            return -2;
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let on_issue = |issue: LayoutIssue| {
+            let json = serde_json::to_string(&issue).ok().and_then(|json| CString::new(json).ok());
+            if let Some(json) = json {
+                callback(context, json.as_ptr());
+            }
+        };
+        match processor.scrub_symbols(symbols_dir_str, layout_path_str, on_issue) {
+            Ok(_) => 0,
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Reports how many symbols of each block a directory holds against the number needed
+/// to decode it, without decoding nor writing anything
+///
+/// The result is a JSON object with `recoverable`, `min_margin`, the number of symbols
+/// the weakest block can still lose, and `blocks`, for example
+/// `{"recoverable":true,"min_margin":3,"blocks":[{"block_id":0,"listed":15,"available":15,"source_symbols":10,"threshold":12,"margin":3}]}`.
+/// A missing symbols directory has no symbols.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `symbols_dir` - Directory containing the symbols
+/// * `layout_path` - Path to the layout file
+/// * `result_buffer` - Buffer to store the JSON report
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// Returns:
+/// *   0 on success, even if the object isn't recoverable
+/// *  -2 on invalid parameters
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error
+/// * -12 if the layout file is not found
+/// * -15 if the layout can't be parsed
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_audit_object(
+    session_id: usize,
+    symbols_dir: *const c_char,
+    layout_path: *const c_char,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        if symbols_dir.is_null() || layout_path.is_null() || result_buffer.is_null() {
+            return -2;
+        }
+
+        let symbols_dir_str = match c_path_arg(symbols_dir) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let layout_path_str = match c_path_arg(layout_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let report = match processor.audit_object(symbols_dir_str, layout_path_str) {
+            Ok(r) => r,
+            Err(e) => return operation_error(&processor, &e),
+        };
+
+        let result_json = match serde_json::to_string(&report) {
+            Ok(j) => j,
+            Err(_) => return -3,
+        };
+
+        write_c_string(&result_json, result_buffer, result_buffer_len)
+    })
+}
+
+/// Counts the source and repair symbols of each block a directory holds, without
+/// decoding nor writing anything
+///
+/// The result is a JSON object mapping each block id to its counts, for example
+/// `{"0":{"source_present":10,"repair_present":3,"source_expected":10,"repair_expected":5}}`.
+/// A missing symbols directory has no symbols.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `symbols_dir` - Directory containing the symbols
+/// * `layout_path` - Path to the layout file
+/// * `result_buffer` - Buffer to store the JSON inventory
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error
+/// * -12 if the layout file is not found
+/// * -15 if the layout can't be parsed
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_symbol_inventory(
+    session_id: usize,
+    symbols_dir: *const c_char,
+    layout_path: *const c_char,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        if symbols_dir.is_null() || layout_path.is_null() || result_buffer.is_null() {
+            return -2;
+        }
+
+        let symbols_dir_str = match c_path_arg(symbols_dir) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let layout_path_str = match c_path_arg(layout_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let inventory = match processor.symbol_inventory(symbols_dir_str, layout_path_str) {
+            Ok(i) => i,
+            Err(e) => return operation_error(&processor, &e),
+        };
+
+        let result_json = match serde_json::to_string(&inventory) {
+            Ok(j) => j,
+            Err(_) => return -3,
+        };
+
+        write_c_string(&result_json, result_buffer, result_buffer_len)
+    })
+}
+
+/// Rebuilds the layout of an object from its symbols directory, when the layout file was lost
+///
+/// The blocks are laid out as raptorq_encode_file splits an object of `object_size`
+/// bytes with `block_size` and the session's configuration, and their symbols are
+/// read with the session's symbol codec and key. The size of the object can't be read
+/// from the symbols, and a wrong size gives a layout decoding to wrong data. The hashes
+/// of the blocks and of the object are left empty, so decodes can't verify the data.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `symbols_dir` - Directory containing the symbols
+/// * `object_size` - Size of the original data in bytes
+/// * `block_size` - Block size of the encode (0 = auto)
+/// * `result_buffer` - Buffer to store the layout JSON, as written in layout files
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters, including an empty object or more blocks in the
+///    directory than in the object
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error
+/// * -13 if the symbols directory does not exist
+/// * -15 if a block has no usable symbol
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_reconstruct_layout(
+    session_id: usize,
+    symbols_dir: *const c_char,
+    object_size: u64,
+    block_size: usize,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        if symbols_dir.is_null() || result_buffer.is_null() {
+            return -2;
+        }
+
+        let symbols_dir_str = match c_path_arg(symbols_dir) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let layout = match processor.reconstruct_layout(symbols_dir_str, object_size, block_size) {
+            Ok(l) => l,
+            Err(e) => return operation_error(&processor, &e),
+        };
+
+        let result_json = match serde_json::to_string_pretty(&layout) {
+            Ok(j) => j,
+            Err(_) => return -3,
+        };
+
+        write_c_string(&result_json, result_buffer, result_buffer_len)
+    })
+}
+
+/// Enables or disables the metrics of a session
+///
+/// Once enabled, raptorq_encode_file and raptorq_decode_symbols count their bytes,
+/// blocks, symbols, successes and failures and measure their latency, and every
+/// block encoded or decoded by the session is timed. Read them with raptorq_get_metrics.
+/// Enabling metrics again keeps the current values, disabling them drops them.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `enabled` - Whether the session keeps metrics
+///
+/// Returns:
+/// *   0 on success
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_enable_metrics(session_id: usize, enabled: bool) -> i32 {
+    ffi_guard(-1, || {
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let mut session_metrics = SESSION_METRICS.lock();
+        if !enabled {
+            session_metrics.remove(&session_id);
+            processor.set_metrics(None);
+        } else if !session_metrics.contains_key(&session_id) {
+            let metrics = Arc::new(MetricsCollector::new());
+            session_metrics.insert(session_id, metrics.clone());
+            processor.set_metrics(Some(metrics));
+        }
+        0
+    })
+}
+
+/// Gets the metrics of a session
+///
+/// The result is a JSON object with:
+/// * `operations` - per operation name (`encode_file`, `decode_symbols`), the
+///   `succeeded` and `failed` counts, the `bytes`, `blocks` and `symbols` of the
+///   operations that succeeded, and their `latency`
+/// * `encoded_blocks`, `decoded_blocks` - the number of `blocks`, their `bytes` and
+///   `symbols`, their `latency` and the `slowest` block with its `block_id`
+///
+/// A latency is a histogram: `buckets` counts the durations up to 1, 5, 10, 25, 50,
+/// 100, 250, 500, 1000, 2500, 5000 and 10000 ms, then above; with `count` and `sum_ms`.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `result_buffer` - Buffer to store the JSON object
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -1 if metrics are not enabled on the session
+/// *  -2 on invalid parameters
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_get_metrics(
+    session_id: usize,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        if result_buffer.is_null() {
+            return -2;
+        }
+
+        if get_processor(session_id).is_none() {
+            return missing_session(session_id);
+        }
+
+        let metrics = match SESSION_METRICS.lock().get(&session_id) {
+            Some(m) => m.clone(),
+            None => return -1,
+        };
+
+        let result_json = match serde_json::to_string(&metrics.snapshot()) {
+            Ok(j) => j,
+            Err(_) => return -3,
+        };
+
+        write_c_string(&result_json, result_buffer, result_buffer_len)
+    })
+}
+
+/// Gets the per-block symbol shortfalls of the last failed decode
+///
+/// The result is a JSON array of `{"block_id", "present", "required"}` objects,
+/// one per block that could not be decoded. It is empty if the last decode
+/// did not fail with -18.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `result_buffer` - Buffer to store the JSON array
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_get_last_shortfalls(
+    session_id: usize,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        if result_buffer.is_null() {
+            return -2;
+        }
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let result_json = match serde_json::to_string(&processor.get_last_shortfalls()) {
+            Ok(j) => j,
+            Err(_) => return -3,
+        };
+
+        let c_result = match CString::new(result_json) {
+            Ok(s) => s,
+            Err(_) => return -3,
+        };
+
+        let result_bytes = c_result.as_bytes_with_nul();
+        if result_bytes.len() > result_buffer_len {
+            return -4;
+        }
+
+        unsafe {
+            ptr::copy_nonoverlapping(
+                result_bytes.as_ptr() as *const c_char,
+                result_buffer,
+                result_bytes.len(),
+            );
+        }
+
+        0
+    })
+}
+
+/// Gets the symbols found corrupt by the last verified decode
+///
+/// The result is a JSON array of `{"block_id", "symbol_id"}` objects, filled by
+/// raptorq_decode_symbols_verified whether the decode succeeded or not.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `result_buffer` - Buffer to store the JSON array
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_get_last_corrupt_symbols(
+    session_id: usize,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        if result_buffer.is_null() {
+            return -2;
+        }
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let corrupt_symbols: Vec<CorruptSymbol> = processor.get_last_corrupt_symbols();
+        let result_json = match serde_json::to_string(&corrupt_symbols) {
+            Ok(j) => j,
+            Err(_) => return -3,
+        };
+
+        let c_result = match CString::new(result_json) {
+            Ok(s) => s,
+            Err(_) => return -3,
+        };
+
+        let result_bytes = c_result.as_bytes_with_nul();
+        if result_bytes.len() > result_buffer_len {
+            return -4;
+        }
+
+        unsafe {
+            ptr::copy_nonoverlapping(
+                result_bytes.as_ptr() as *const c_char,
+                result_buffer,
+                result_bytes.len(),
+            );
+        }
+
+        0
+    })
+}
+
+/// Gets the minimum number of symbols to fetch for a block to decode
+///
+/// This is the number of source symbols of the block plus a small overhead,
+/// with which the decode succeeds with high probability.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `layout_path` - Path to the layout file
+/// * `block_id` - Identifier of the block in the layout
+/// * `min_symbols` - Receives the number of symbols
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -12 if the layout file is not found
+/// * -15 if the layout is invalid or does not contain the block
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_min_symbols_for_block(
+    session_id: usize,
+    layout_path: *const c_char,
+    block_id: usize,
+    min_symbols: *mut u64,
+) -> i32 {
+    ffi_guard(-1, || {
+        if layout_path.is_null() || min_symbols.is_null() {
+            return -2;
+        }
+
+        let layout_path_str = match c_path_arg(layout_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        match processor.min_symbols_for_block(layout_path_str, block_id) {
+            Ok(count) => {
+                unsafe { *min_symbols = count; }
+                0
+            },
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Gets the minimum number of symbols to fetch for each block of a layout to decode
+///
+/// The result is a JSON object mapping each block id to its number of symbols,
+/// computed as in raptorq_min_symbols_for_block.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `layout_path` - Path to the layout file
+/// * `result_buffer` - Buffer to store the JSON object
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -12 if the layout file is not found
+/// * -15 if the layout is invalid
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_min_symbols_per_block(
+    session_id: usize,
+    layout_path: *const c_char,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        if layout_path.is_null() || result_buffer.is_null() {
+            return -2;
+        }
+
+        let layout_path_str = match c_path_arg(layout_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let per_block = match processor.min_symbols_per_block(layout_path_str) {
+            Ok(m) => m,
+            Err(e) => return operation_error(&processor, &e),
+        };
+
+        let result_json = match serde_json::to_string(&per_block) {
+            Ok(j) => j,
+            Err(_) => return -3,
+        };
+
+        let c_result = match CString::new(result_json) {
+            Ok(s) => s,
+            Err(_) => return -3,
+        };
+
+        let result_bytes = c_result.as_bytes_with_nul();
+        if result_bytes.len() > result_buffer_len {
+            return -4;
+        }
+
+        unsafe {
+            ptr::copy_nonoverlapping(
+                result_bytes.as_ptr() as *const c_char,
+                result_buffer,
+                result_bytes.len(),
+            );
+        }
+
+        0
+    })
+}
+
+/// Gets the RFC 6330 Object Transmission Information of every block of a layout
+///
+/// Each block is a separate RaptorQ object, which a standard decoder can decode
+/// from its OTI and its symbols. The result is a JSON array with one object per
+/// block: `block_id`, `oti` (the 12 bytes Common and Scheme-Specific FEC OTI),
+/// `transfer_length` (F), `symbol_size` (T), `source_blocks` (Z),
+/// `sub_blocks` (N) and `symbol_alignment` (Al).
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `layout_path` - Path to the layout file
+/// * `result_buffer` - Buffer to store the JSON array
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -12 if the layout file is not found
+/// * -15 if the layout is invalid
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_get_oti(
+    session_id: usize,
+    layout_path: *const c_char,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        if layout_path.is_null() || result_buffer.is_null() {
+            return -2;
+        }
+
+        let layout_path_str = match c_path_arg(layout_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let otis = match processor.get_oti(layout_path_str) {
+            Ok(o) => o,
+            Err(e) => return operation_error(&processor, &e),
+        };
+
+        let result_json = match serde_json::to_string(&otis) {
+            Ok(j) => j,
+            Err(_) => return -3,
+        };
+
+        let c_result = match CString::new(result_json) {
+            Ok(s) => s,
+            Err(_) => return -3,
+        };
+
+        let result_bytes = c_result.as_bytes_with_nul();
+        if result_bytes.len() > result_buffer_len {
+            return -4;
+        }
+
+        unsafe {
+            ptr::copy_nonoverlapping(
+                result_bytes.as_ptr() as *const c_char,
+                result_buffer,
+                result_bytes.len(),
+            );
+        }
+
+        0
+    })
+}
+
+/// Decodes RaptorQ symbols held in memory back to the original data
+///
+/// The symbols are passed packed the same way raptorq_encode_bytes returns them:
+/// each symbol prefixed with its length as a 4 bytes little-endian integer.
+/// They can be in any order, symbols unknown to the layout are ignored.
+///
+/// Memory ownership:
+/// * The input buffers stay owned by the caller and are not retained after the call
+/// * On success, `*output_buffer` and `*output_buffer_len` describe a buffer owned by
+///   the caller, which must be released with raptorq_free_buffer exactly once;
+///   copy the data out of it before freeing it
+/// * On any error, `*output_buffer` is set to NULL and `*output_buffer_len` to 0,
+///   nothing is allocated and there is nothing to free
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `symbols` - Packed symbols
+/// * `symbols_len` - Length of the packed symbols
+/// * `layout` - Content of the layout JSON
+/// * `layout_len` - Length of the layout content
+/// * `output_buffer` - Receives the pointer to the decoded data
+/// * `output_buffer_len` - Receives the length of the decoded data
+///
+/// Returns:
+/// *   0 on success
+/// *  -1 on generic error
+/// *  -2 on invalid parameters
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -15 on Decoding failed
+/// * -16 on Memory limit exceeded
+/// * -17 on Concurrency limit reached
+/// * -18 on Insufficient symbols (see raptorq_get_last_shortfalls)
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_decode_bytes(
+    session_id: usize,
+    symbols: *const u8,
+    symbols_len: usize,
+    layout: *const u8,
+    layout_len: usize,
+    output_buffer: *mut *mut u8,
+    output_buffer_len: *mut usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        // Basic null pointer checks
+        if symbols.is_null() || layout.is_null() || output_buffer.is_null() || output_buffer_len.is_null() {
+            return -2;
+        }
+
+        // Nothing is handed over to the caller unless decoding fully succeeds
+        unsafe {
+            *output_buffer = ptr::null_mut();
+            *output_buffer_len = 0;
+        }
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let packed = unsafe { std::slice::from_raw_parts(symbols, symbols_len) };
+        let symbol_slices = match unpack_symbols(packed) {
+            Some(s) => s,
+            None => return truncated_records_error(&processor, "symbols"),
+        };
+        let layout_slice = unsafe { std::slice::from_raw_parts(layout, layout_len) };
+
+        match processor.decode_bytes(&symbol_slices, layout_slice) {
+            Ok(data) => {
+                let (buffer, len) = into_raw_buffer(data);
+                unsafe {
+                    *output_buffer = buffer;
+                    *output_buffer_len = len;
+                }
+                0
+            },
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Decodes a RaptorQ object from its RFC 6330 OTI and encoding packets
+///
+/// No layout is needed, so objects from other RFC 6330 encoders can be decoded,
+/// as well as single blocks of this library (see raptorq_get_oti). Each packet is
+/// a 4 bytes FEC payload ID followed by the symbol, the packets are packed as for
+/// raptorq_decode_bytes. The output buffer is handled as in raptorq_decode_bytes.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `oti` - The 12 bytes Common and Scheme-Specific FEC OTI of the object
+/// * `oti_len` - Length of the OTI
+/// * `packets` - Packed encoding packets
+/// * `packets_len` - Length of the packed packets
+/// * `output_buffer` - Receives the pointer to the decoded object
+/// * `output_buffer_len` - Receives the length of the decoded object
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters, including an invalid OTI
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -15 on Decoding failed
+/// * -16 on Memory limit exceeded
+/// * -17 on Concurrency limit reached
+/// * -18 on Insufficient symbols (see raptorq_get_last_shortfalls)
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_decode_with_oti(
+    session_id: usize,
+    oti: *const u8,
+    oti_len: usize,
+    packets: *const u8,
+    packets_len: usize,
+    output_buffer: *mut *mut u8,
+    output_buffer_len: *mut usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        // Basic null pointer checks
+        if oti.is_null() || packets.is_null() || output_buffer.is_null() || output_buffer_len.is_null() {
+            return -2;
+        }
+
+        // Nothing is handed over to the caller unless decoding fully succeeds
+        unsafe {
+            *output_buffer = ptr::null_mut();
+            *output_buffer_len = 0;
+        }
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let oti_slice = unsafe { std::slice::from_raw_parts(oti, oti_len) };
+        let packed = unsafe { std::slice::from_raw_parts(packets, packets_len) };
+        let packet_slices = match unpack_symbols(packed) {
+            Some(p) => p,
+            None => return truncated_records_error(&processor, "packets"),
+        };
+
+        match processor.decode_with_oti(oti_slice, &packet_slices) {
+            Ok(data) => {
+                let (buffer, len) = into_raw_buffer(data);
+                unsafe {
+                    *output_buffer = buffer;
+                    *output_buffer_len = len;
+                }
+                0
+            },
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Callback writing the bytes of `buffer` to a stream
+///
+/// Returns the number of bytes consumed (at most `buffer_len`),
+/// or a negative value on write error.
+pub type RaptorQWriteCallback = extern "C" fn(context: *mut c_void, buffer: *const u8, buffer_len: usize) -> isize;
+
+// Adapts a write callback to io::Write
+struct CallbackWriter {
+    callback: RaptorQWriteCallback,
+    context: *mut c_void,
+}
+
+impl io::Write for CallbackWriter {
+    fn write(&mut self, buf: &[u8]) -> io::Result<usize> {
+        let written = (self.callback)(self.context, buf.as_ptr(), buf.len());
+        if written < 0 {
+            return Err(io::Error::new(io::ErrorKind::Other, format!("write callback returned {}", written)));
+        }
+        Ok((written as usize).min(buf.len()))
+    }
+
+    fn flush(&mut self) -> io::Result<()> {
+        Ok(())
+    }
+}
+
+/// Decodes RaptorQ symbols and streams the original data to a callback
+///
+/// Blocks are decoded one by one and their data is passed to `write_callback`
+/// in order, so no temporary file is needed and memory stays bounded to a block.
+/// On error, the data of the blocks decoded before may have been written already.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `symbols_dir` - Directory containing the symbols
+/// * `layout_path` - Path to the layout file
+/// * `write_callback` - Callback receiving the decoded data
+/// * `context` - Opaque pointer passed to every call of the callback
+///
+/// Returns:
+/// *   0 on success
+/// *  -1 on generic error
+/// *  -2 on invalid parameters
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error (including a write error of the callback)
+/// * -12 on File not found
+/// * -13 on Invalid Path
+/// * -15 on Decoding failed
+/// * -17 on Concurrency limit reached
+/// * -18 on Insufficient symbols (see raptorq_get_last_shortfalls)
+/// * -22 if the symbols directory holds the layout of another object
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_decode_to_writer(
+    session_id: usize,
+    symbols_dir: *const c_char,
+    layout_path: *const c_char,
+    write_callback: Option<RaptorQWriteCallback>,
+    context: *mut c_void,
+) -> i32 {
+    ffi_guard(-1, || {
+        // Basic null pointer checks
+        let callback = match write_callback {
+            Some(c) => c,
+            None => return -2,
+        };
+        if symbols_dir.is_null() || layout_path.is_null() {
+            return -2;
+        }
+
+        let symbols_dir_str = match c_path_arg(symbols_dir) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let layout_path_str = match c_path_arg(layout_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let writer = CallbackWriter { callback, context };
+        match processor.decode_symbols_to_writer(symbols_dir_str, layout_path_str, writer) {
+            Ok(_) => 0,
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Callback receiving the progress of a decode, the bytes written so far and the
+/// size of the original data
+pub type RaptorQProgressCallback = extern "C" fn(context: *mut c_void, bytes_written: u64, bytes_total: u64);
+
+/// Decodes RaptorQ symbols and streams the original data to a callback, reporting
+/// the progress after each block
+///
+/// As raptorq_decode_to_writer, blocks are decoded one by one and their data is passed
+/// to `write_callback` in order. Once a block is written, `progress_callback` receives
+/// the number of bytes written so far and the size of the original data. On error, the
+/// data of the blocks decoded before may have been written already.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `symbols_dir` - Directory containing the symbols
+/// * `layout_path` - Path to the layout file
+/// * `write_callback` - Callback receiving the decoded data
+/// * `context` - Opaque pointer passed to every call of the write callback
+/// * `progress_callback` - Callback receiving the progress after each block
+/// * `progress_context` - Opaque pointer passed to every call of the progress callback
+///
+/// Returns:
+/// *   0 on success
+/// *  -1 on generic error
+/// *  -2 on invalid parameters
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error (including a write error of the callback)
+/// * -12 on File not found
+/// * -13 on Invalid Path
+/// * -15 on Decoding failed
+/// * -17 on Concurrency limit reached
+/// * -18 on Insufficient symbols (see raptorq_get_last_shortfalls)
+/// * -22 if the symbols directory holds the layout of another object
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_decode_to_writer_with_progress(
+    session_id: usize,
+    symbols_dir: *const c_char,
+    layout_path: *const c_char,
+    write_callback: Option<RaptorQWriteCallback>,
+    context: *mut c_void,
+    progress_callback: Option<RaptorQProgressCallback>,
+    progress_context: *mut c_void,
+) -> i32 {
+    ffi_guard(-1, || {
+        // Basic null pointer checks
+        let (callback, progress) = match (write_callback, progress_callback) {
+            (Some(c), Some(p)) => (c, p),
+            _ => return -2,
+        };
+        if symbols_dir.is_null() || layout_path.is_null() {
+            return -2;
+        }
+
+        let symbols_dir_str = match c_path_arg(symbols_dir) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let layout_path_str = match c_path_arg(layout_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let writer = CallbackWriter { callback, context };
+        let report = |bytes_written: u64, bytes_total: u64| progress(progress_context, bytes_written, bytes_total);
+        match processor.decode_symbols_to_writer_with_progress(symbols_dir_str, layout_path_str, writer, report) {
+            Ok(_) => 0,
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Callback writing the bytes of `buffer` at an offset of the destination
+///
+/// Returns the number of bytes written (at most `buffer_len`),
+/// or a negative value on write error.
+pub type RaptorQWriteAtCallback = extern "C" fn(context: *mut c_void, offset: u64, buffer: *const u8, buffer_len: usize) -> isize;
+
+// Adapts a write-at callback to WriteAt
+struct CallbackWriteAt {
+    callback: RaptorQWriteAtCallback,
+    context: *mut c_void,
+}
+
+impl WriteAt for CallbackWriteAt {
+    fn write_at(&self, offset: u64, buf: &[u8]) -> io::Result<usize> {
+        let written = (self.callback)(self.context, offset, buf.as_ptr(), buf.len());
+        if written < 0 {
+            return Err(io::Error::new(io::ErrorKind::Other, format!("write callback returned {} at offset {}", written, offset)));
+        }
+        Ok((written as usize).min(buf.len()))
+    }
+}
+
+/// Decodes RaptorQ symbols and writes each block at its offset through a callback
+///
+/// Blocks are decoded in parallel and passed to `write_callback` at their original
+/// offset as they complete, in any order, so the destination can be a preallocated
+/// file or a memory mapping. The callback is only called from the calling thread.
+/// On error, the data of the blocks decoded before may have been written already.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `symbols_dir` - Directory containing the symbols
+/// * `layout_path` - Path to the layout file
+/// * `write_callback` - Callback writing the decoded data at an offset
+/// * `context` - Opaque pointer passed to every call of the callback
+///
+/// Returns:
+/// *   0 on success
+/// *  -1 on generic error
+/// *  -2 on invalid parameters
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error (including a write error of the callback)
+/// * -12 on File not found
+/// * -13 on Invalid Path
+/// * -15 on Decoding failed (including blocks not adding up to the size of the data)
+/// * -17 on Concurrency limit reached
+/// * -18 on Insufficient symbols (see raptorq_get_last_shortfalls)
+/// * -22 if the symbols directory holds the layout of another object
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_decode_to_writer_at(
+    session_id: usize,
+    symbols_dir: *const c_char,
+    layout_path: *const c_char,
+    write_callback: Option<RaptorQWriteAtCallback>,
+    context: *mut c_void,
+) -> i32 {
+    ffi_guard(-1, || {
+        let callback = match write_callback {
+            Some(c) => c,
+            None => return -2,
+        };
+        if symbols_dir.is_null() || layout_path.is_null() {
+            return -2;
+        }
+
+        let symbols_dir_str = match c_path_arg(symbols_dir) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let layout_path_str = match c_path_arg(layout_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let writer = CallbackWriteAt { callback, context };
+        match processor.decode_symbols_to_writer_at(symbols_dir_str, layout_path_str, &writer) {
+            Ok(_) => 0,
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Decodes a byte range of the original data and streams it to a callback
+///
+/// Only the blocks overlapping `[offset, offset + length)` are decoded, and exactly
+/// the bytes of the range are passed to `write_callback`, in order. This serves
+/// range requests on large objects without decoding them entirely.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `symbols_dir` - Directory containing the symbols
+/// * `layout_path` - Path to the layout file
+/// * `offset` - Offset of the range in the original data
+/// * `length` - Length of the range, 0 writes nothing
+/// * `write_callback` - Callback receiving the decoded range
+/// * `context` - Opaque pointer passed to every call of the callback
+///
+/// Returns:
+/// *   0 on success
+/// *  -1 on generic error
+/// *  -2 on invalid parameters, including a range ending past the data
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error (including a write error of the callback)
+/// * -12 on File not found
+/// * -13 on Invalid Path
+/// * -15 on Decoding failed
+/// * -17 on Concurrency limit reached
+/// * -18 on Insufficient symbols (see raptorq_get_last_shortfalls)
+/// * -22 if the symbols directory holds the layout of another object
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_decode_range(
+    session_id: usize,
+    symbols_dir: *const c_char,
+    layout_path: *const c_char,
+    offset: u64,
+    length: u64,
+    write_callback: Option<RaptorQWriteCallback>,
+    context: *mut c_void,
+) -> i32 {
+    ffi_guard(-1, || {
+        let callback = match write_callback {
+            Some(c) => c,
+            None => return -2,
+        };
+
+        let symbols_dir_str = match c_path_arg(symbols_dir) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let layout_path_str = match c_path_arg(layout_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let writer = CallbackWriter { callback, context };
+        match processor.decode_range(symbols_dir_str, layout_path_str, offset, length, writer) {
+            Ok(_) => 0,
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Callback giving the next symbol to decode
+///
+/// Writes the symbol into `buffer` and the id of its block into `block_id`.
+/// `buffer_len` is large enough for any symbol. Returns the length of the symbol,
+/// 0 once there are no more symbols, or a negative value on error.
+pub type RaptorQSymbolCallback = extern "C" fn(context: *mut c_void, block_id: *mut usize, buffer: *mut u8, buffer_len: usize) -> isize;
+
+// Largest symbol: 4 bytes FEC payload ID followed by the largest symbol size
+const MAX_SYMBOL_LEN: usize = 4 + u16::MAX as usize;
+
+// Adapts a symbol callback to an iterator of symbols
+struct CallbackSymbols {
+    callback: RaptorQSymbolCallback,
+    context: *mut c_void,
+    buffer: Vec<u8>,
+}
+
+impl Iterator for CallbackSymbols {
+    type Item = io::Result<(usize, Vec<u8>)>;
+
+    fn next(&mut self) -> Option<Self::Item> {
+        let mut block_id = 0usize;
+        let len = (self.callback)(self.context, &mut block_id, self.buffer.as_mut_ptr(), self.buffer.len());
+        if len < 0 || len as usize > self.buffer.len() {
+            return Some(Err(io::Error::new(io::ErrorKind::Other, format!("symbol callback returned {}", len))));
+        }
+        if len == 0 {
+            return None;
+        }
+        Some(Ok((block_id, self.buffer[..len as usize].to_vec())))
+    }
+}
+
+/// Decodes RaptorQ symbols pulled one by one from a callback and streams the
+/// original data to a callback
+///
+/// Symbols can be given in any order, each with the id of its block, for example
+/// as they are fetched from a remote storage. `symbol_callback` is not called any
+/// more once every block is decoded, so the remaining symbols don't need to be
+/// fetched. Symbols not in the layout of their block are ignored. Decoded blocks
+/// are written in order; a block is held in memory until the ones before it are written.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `symbol_callback` - Callback giving the next symbol
+/// * `symbol_context` - Opaque pointer passed to every call of the symbol callback
+/// * `layout_path` - Path to the layout file
+/// * `write_callback` - Callback receiving the decoded data
+/// * `write_context` - Opaque pointer passed to every call of the write callback
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error (including an error of a callback)
+/// * -12 if the layout file is not found
+/// * -15 on Decoding failed
+/// * -17 on Concurrency limit reached
+/// * -18 on Insufficient symbols when the symbols run out or the limit set with
+///       raptorq_set_max_source_symbols is reached (see raptorq_get_last_shortfalls)
+/// * -19 if the session was cancelled
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_decode_from_source(
+    session_id: usize,
+    symbol_callback: Option<RaptorQSymbolCallback>,
+    symbol_context: *mut c_void,
+    layout_path: *const c_char,
+    write_callback: Option<RaptorQWriteCallback>,
+    write_context: *mut c_void,
+) -> i32 {
+    ffi_guard(-1, || {
+        // Basic null pointer checks
+        let (symbol_callback, write_callback) = match (symbol_callback, write_callback) {
+            (Some(s), Some(w)) => (s, w),
+            _ => return -2,
+        };
+        if layout_path.is_null() {
+            return -2;
+        }
+
+        let layout_path_str = match c_path_arg(layout_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let symbols = CallbackSymbols {
+            callback: symbol_callback,
+            context: symbol_context,
+            buffer: vec![0u8; MAX_SYMBOL_LEN],
+        };
+        let writer = CallbackWriter { callback: write_callback, context: write_context };
+        match processor.decode_from_source(symbols, layout_path_str, writer) {
+            Ok(_) => 0,
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Callback reading a whole file of a store into `buffer`
+///
+/// `path` is relative to the store, slash separated. Returns the size of the file and
+/// copies it into `buffer` if `buffer_len` is large enough; otherwise the callback is
+/// called again with a buffer of that size. Returns -1 if there is no file at `path`,
+/// or another negative value on error.
+pub type RaptorQFileCallback = extern "C" fn(context: *mut c_void, path: *const c_char, buffer: *mut u8, buffer_len: usize) -> isize;
+
+// Adapts a file callback to a symbol store
+struct CallbackStore {
+    callback: RaptorQFileCallback,
+    context: *mut c_void,
+    buffer: RefCell<Vec<u8>>,
+}
+
+impl SymbolStore for CallbackStore {
+    fn read(&self, path: &str) -> io::Result<Vec<u8>> {
+        let c_path = CString::new(path).map_err(|e| io::Error::new(io::ErrorKind::InvalidInput, e))?;
+        let mut buffer = self.buffer.borrow_mut();
+        loop {
+            let len = (self.callback)(self.context, c_path.as_ptr(), buffer.as_mut_ptr(), buffer.len());
+            if len == -1 {
+                return Err(io::Error::new(io::ErrorKind::NotFound, format!("No file {:?} in the store", path)));
+            }
+            if len < 0 {
+                return Err(io::Error::new(io::ErrorKind::Other, format!("file callback returned {} for {:?}", len, path)));
+            }
+            let len = len as usize;
+            if len <= buffer.len() {
+                return Ok(buffer[..len].to_vec());
+            }
+            buffer.resize(len, 0);
+        }
+    }
+}
+
+/// Decodes symbols and a layout read through a callback and streams the original
+/// data to a callback
+///
+/// Same as raptorq_decode_to_writer, with every file read through
+/// `file_callback` instead of the local filesystem, for example from an embedded
+/// filesystem or an object storage bucket. The symbols of a block are read from
+/// `<symbols_dir>/block_<id>/<symbol_id>`, or `<symbols_dir>/<symbol_id>` if the first
+/// one is missing.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `file_callback` - Callback reading a file of the store
+/// * `file_context` - Opaque pointer passed to every call of the file callback
+/// * `symbols_dir` - Path of the symbols directory in the store, "." for its root
+/// * `layout_path` - Path of the layout file in the store
+/// * `write_callback` - Callback receiving the decoded data
+/// * `write_context` - Opaque pointer passed to every call of the write callback
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error (including an error of a callback)
+/// * -12 if the store has no layout at `layout_path`
+/// * -15 on Decoding failed
+/// * -17 on Concurrency limit reached
+/// * -18 on Insufficient symbols (see raptorq_get_last_shortfalls)
+/// * -19 if the session was cancelled
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_decode_from_store(
+    session_id: usize,
+    file_callback: Option<RaptorQFileCallback>,
+    file_context: *mut c_void,
+    symbols_dir: *const c_char,
+    layout_path: *const c_char,
+    write_callback: Option<RaptorQWriteCallback>,
+    write_context: *mut c_void,
+) -> i32 {
+    ffi_guard(-1, || {
+        let (file_callback, write_callback) = match (file_callback, write_callback) {
+            (Some(f), Some(w)) => (f, w),
+            _ => return -2,
+        };
+
+        let symbols_dir_str = match c_path_arg(symbols_dir) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let layout_path_str = match c_path_arg(layout_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let store = CallbackStore {
+            callback: file_callback,
+            context: file_context,
+            buffer: RefCell::new(vec![0u8; MAX_SYMBOL_LEN]),
+        };
+        let writer = CallbackWriter { callback: write_callback, context: write_context };
+        match processor.decode_from_store(&store, symbols_dir_str, layout_path_str, writer) {
+            Ok(_) => 0,
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Gets the version and build of the library
+///
+/// The result is a JSON object `{"version", "build_hash", "abi_version"}`: the semantic
+/// version, the commit the library was built from and the version of the C interface.
+/// A program can compare `abi_version` to the RAPTORQ_ABI_VERSION of the header it was
+/// built with to detect a mismatched library when it starts.
+///
+/// Arguments:
+/// * `result_buffer` - Buffer to store the result (JSON)
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_version_info(result_buffer: *mut c_char, result_buffer_len: usize) -> i32 {
+    ffi_guard(-1, || {
+        if result_buffer.is_null() {
+            return -2;
+        }
+
+        let result_json = match serde_json::to_string(&version_info()) {
+            Ok(j) => j,
+            Err(_) => return -3,
+        };
+        write_c_string(&result_json, result_buffer, result_buffer_len)
+    })
+}
+
+// Copy a string with its terminating nul to a C buffer, -3 if it contains a nul, -4 if too small
+fn write_c_string(value: &str, buffer: *mut c_char, buffer_len: usize) -> i32 {
+    let c_value = match CString::new(value) {
+        Ok(s) => s,
+        Err(_) => return -3,
+    };
+
+    let bytes = c_value.as_bytes_with_nul();
+    if bytes.len() > buffer_len {
+        return -4;
+    }
+
+    unsafe {
+        ptr::copy_nonoverlapping(bytes.as_ptr() as *const c_char, buffer, bytes.len());
+    }
+
+    0
+}
+
+/// Gets the path of a symbol relative to the symbols directory
+///
+/// Symbols are stored as `block_<block_id>/<symbol_id>`, the symbol id being
+/// the Base58 encoded BLAKE3 hash of the symbol as listed in the layout. The names
+/// don't carry the ESI, see `raptorq_get_symbol_esi` to read it from the symbol.
+///
+/// Arguments:
+/// * `block_id` - Identifier of the block of the symbol
+/// * `symbol_id` - Identifier of the symbol
+/// * `path_buffer` - Buffer to store the path
+/// * `path_buffer_len` - Length of the path buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters
+/// *  -4 if the buffer is too small
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_get_symbol_path(
+    block_id: usize,
+    symbol_id: *const c_char,
+    path_buffer: *mut c_char,
+    path_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        if symbol_id.is_null() || path_buffer.is_null() {
+            return -2;
+        }
+
+        let symbol_id_str = match unsafe { CStr::from_ptr(symbol_id) }.to_str() {
+            Ok(s) => s,
+            Err(_) => return -2,
+        };
+
+        write_c_string(&symbol_path(block_id, symbol_id_str), path_buffer, path_buffer_len)
+    })
+}
+
+/// Parses the path of a symbol into its block id and symbol id
+///
+/// Only the last two components are used, so the path may include the symbols directory.
+///
+/// Arguments:
+/// * `path` - Path of the symbol, `[...]/block_<block_id>/<symbol_id>`
+/// * `block_id` - Receives the block id
+/// * `symbol_id_buffer` - Buffer to store the symbol id
+/// * `symbol_id_buffer_len` - Length of the symbol id buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters
+/// *  -4 if the buffer is too small
+/// * -13 if the path is not a symbol path
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_parse_symbol_path(
+    path: *const c_char,
+    block_id: *mut usize,
+    symbol_id_buffer: *mut c_char,
+    symbol_id_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        if path.is_null() || block_id.is_null() || symbol_id_buffer.is_null() {
+            return -2;
+        }
+
+        let path_str = match unsafe { CStr::from_ptr(path) }.to_str() {
+            Ok(s) => s,
+            Err(_) => return -2,
+        };
+
+        match parse_symbol_path(path_str) {
+            Ok((parsed_block_id, symbol_id)) => {
+                let result = write_c_string(&symbol_id, symbol_id_buffer, symbol_id_buffer_len);
+                if result == 0 {
+                    unsafe { *block_id = parsed_block_id; }
+                }
+                result
+            },
+            Err(e) => error_code(&e),
+        }
+    })
+}
+
+/// Checks a path before passing it to the other functions
+///
+/// The functions taking paths return -2 for a path that is empty or isn't valid UTF-8
+/// and -13 for one that can't be opened: longer than 4095 bytes or with a name longer
+/// than 255 bytes. This function returns the same code and tells why, so wrappers can
+/// report a clear error instead of a bare code. Spaces and any Unicode character are fine.
+///
+/// Arguments:
+/// * `path` - Path to check
+/// * `error_buffer` - Receives the reason the path is rejected, empty if it isn't (can be NULL)
+/// * `error_buffer_len` - Length of the error buffer
+///
+/// Returns:
+/// *   0 if the path can be used
+/// *  -2 if the path is NULL, empty or not valid UTF-8
+/// *  -4 if the error buffer is too small
+/// * -13 if the path is rejected
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_check_path(
+    path: *const c_char,
+    error_buffer: *mut c_char,
+    error_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        if path.is_null() {
+            return -2;
+        }
+
+        let path = unsafe { CStr::from_ptr(path) };
+        let result = match path.to_str() {
+            Ok(path_str) => check_path(path_str),
+            Err(e) => Err(ProcessError::InvalidPath(format!(
+                "Path {:?} is not valid UTF-8 from byte {}", path.to_string_lossy(), e.valid_up_to()
+            ))),
+        };
+        // As c_path_arg, which the other functions read their paths with
+        let (code, message) = match result {
+            Ok(()) => (RAPTORQ_OK, String::new()),
+            Err(e) if path.to_bytes().is_empty() || path.to_str().is_err() => (RAPTORQ_ERR_INVALID_PARAMS, e.to_string()),
+            Err(e) => (error_code(&e), e.to_string()),
+        };
+
+        if !error_buffer.is_null() {
+            let result = write_c_string(&message, error_buffer, error_buffer_len);
+            if result != 0 {
+                return result;
+            }
+        }
+        code
+    })
+}
+
+/// Gets the encoding symbol ID (ESI) of a symbol from its FEC payload ID
+///
+/// The ESIs number the symbols of each source block, the first byte of the symbol
+/// numbering its source block: the ESIs below the number of source symbols of the
+/// source block are source symbols, the others repair symbols. A block has a single
+/// source block unless it holds more source symbols than a source block can.
+///
+/// Arguments:
+/// * `symbol` - Symbol data
+/// * `symbol_len` - Length of the symbol data
+/// * `esi` - Receives the encoding symbol ID
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters, including a symbol shorter than its payload ID
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_get_symbol_esi(
+    symbol: *const u8,
+    symbol_len: usize,
+    esi: *mut u32,
+) -> i32 {
+    ffi_guard(-1, || {
+        if symbol.is_null() || esi.is_null() {
+            return -2;
+        }
+
+        let symbol_slice = unsafe { std::slice::from_raw_parts(symbol, symbol_len) };
+        match symbol_esi(symbol_slice) {
+            Some(value) => {
+                unsafe { *esi = value; }
+                0
+            },
+            None => -2,
+        }
+    })
+}
+
+/// Gets the id of a symbol, the name of its file and its entry in the layouts
+///
+/// Arguments:
+/// * `symbol` - Symbol data, with its FEC payload ID
+/// * `symbol_len` - Length of the symbol data
+/// * `result_buffer` - Buffer to store the id
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters
+/// *  -4 on bad return buffer size
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_get_symbol_id(
+    symbol: *const u8,
+    symbol_len: usize,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        if symbol.is_null() || result_buffer.is_null() {
+            return -2;
+        }
+
+        let symbol_slice = unsafe { std::slice::from_raw_parts(symbol, symbol_len) };
+        write_c_string(&symbol_id(symbol_slice), result_buffer, result_buffer_len)
+    })
+}
+
+/// Builds the layout of an object encoded by a standard RaptorQ encoder as one RaptorQ
+/// object per block, from the OTI of its blocks
+///
+/// The OTIs are a JSON array in the format of raptorq_get_oti, in the order of the
+/// data of the blocks; the 12 bytes of each `oti` are used and the other fields must
+/// match them. The result is the layout JSON, whose blocks list no symbols and have no
+/// hash: add the id of each symbol (see raptorq_get_symbol_id) to the `symbols` of its
+/// block, source symbols first, and store the symbols under these names to decode them.
+///
+/// Arguments:
+/// * `oti_json` - JSON array of the OTIs of the blocks
+/// * `object_size` - Size of the object, the sum of the transfer lengths of the blocks
+/// * `result_buffer` - Buffer to store the layout JSON
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters, including malformed OTIs or OTIs not adding up to the
+///       object size
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_oti_to_layout(
+    oti_json: *const c_char,
+    object_size: u64,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        if oti_json.is_null() || result_buffer.is_null() {
+            return -2;
+        }
+
+        let oti_str = match unsafe { CStr::from_ptr(oti_json) }.to_str() {
+            Ok(s) => s,
+            Err(_) => return -2,
+        };
+
+        let otis: Vec<BlockOti> = match serde_json::from_str(oti_str) {
+            Ok(o) => o,
+            Err(_) => return -2,
+        };
+
+        let layout = match RaptorQLayout::from_oti(&otis, object_size) {
+            Ok(l) => l,
+            Err(e) => return error_code(&e),
+        };
+
+        let result_json = match serde_json::to_string(&layout) {
+            Ok(j) => j,
+            Err(_) => return -3,
+        };
+
+        write_c_string(&result_json, result_buffer, result_buffer_len)
+    })
+}
+
+/// Parses a layout file without a session
+///
+/// The result is a JSON object with the `layout_version` of the layout file (0 for
+/// layouts written before the format was versioned), the `total_size` of the original
+/// data, the `symbols_count` of all blocks and the `blocks`, each with its `block_id`,
+/// `original_offset`, `size`, `symbol_size`, `symbols_count`, `source_symbols_count`
+/// and `repair_symbols_count`, and the `metadata` of the application if the layout
+/// has any (see raptorq_set_layout_metadata).
+///
+/// Arguments:
+/// * `layout_path` - Path to the layout file
+/// * `result_buffer` - Buffer to store the JSON object
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size
+/// * -12 on File not found
+/// * -15 if the layout can't be read or parsed
+/// * -23 if the layout is of a newer version than the library supports
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_parse_layout(
+    layout_path: *const c_char,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        if result_buffer.is_null() {
+            return -2;
+        }
+
+        let layout_path_str = match c_path_arg(layout_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let layout = match RaptorQLayout::read_file(layout_path_str) {
+            Ok(l) => l,
+            Err(e) => return error_code(&e),
+        };
+
+        let result_json = match serde_json::to_string(&layout.summary()) {
+            Ok(j) => j,
+            Err(_) => return -3,
+        };
+
+        write_c_string(&result_json, result_buffer, result_buffer_len)
+    })
+}
+
+/// Parses the content of a layout without a session
+///
+/// Same as raptorq_parse_layout for a layout held in memory, such as the
+/// `layout_content` returned by raptorq_create_metadata with an empty layout file,
+/// in the JSON or binary format.
+///
+/// Arguments:
+/// * `layout` - Content of the layout
+/// * `layout_len` - Length of the layout content
+/// * `result_buffer` - Buffer to store the JSON object
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size
+/// * -15 if the layout can't be parsed
+/// * -23 if the layout is of a newer version than the library supports
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_parse_layout_content(
+    layout: *const u8,
+    layout_len: usize,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        if layout.is_null() || result_buffer.is_null() {
+            return -2;
+        }
+
+        let layout_bytes = unsafe { std::slice::from_raw_parts(layout, layout_len) };
+        let layout = match RaptorQLayout::parse(layout_bytes) {
+            Ok(l) => l,
+            Err(e) => return error_code(&e),
+        };
+
+        let result_json = match serde_json::to_string(&layout.summary()) {
+            Ok(j) => j,
+            Err(_) => return -3,
+        };
+
+        write_c_string(&result_json, result_buffer, result_buffer_len)
+    })
+}
+
+/// Upgrades a layout file to the version of the layouts written by this library
+///
+/// The file is replaced if it is of an older version, by a file written and synced next
+/// to it, and left as it is if it is already current. Layouts of older versions still decode, this is for the
+/// tools reading layout files that expect the current version.
+///
+/// Arguments:
+/// * `layout_path` - Path to the layout file
+///
+/// Returns:
+/// *   0 on success, whether the layout was upgraded or already current
+/// *  -2 on invalid parameters
+/// * -11 on IO error
+/// * -12 on File not found
+/// * -15 if the layout can't be read or parsed
+/// * -23 if the layout is of a newer version than the library supports
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_migrate_layout(layout_path: *const c_char) -> i32 {
+    ffi_guard(-1, || {
+        let layout_path_str = match c_path_arg(layout_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        match RaptorQLayout::migrate_file(layout_path_str) {
+            Ok(_) => 0,
+            Err(e) => error_code(&e),
+        }
+    })
+}
+
+/// Gets the configuration of a session
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `symbol_size` - Receives the symbol size (can be NULL)
+/// * `redundancy_factor` - Receives the redundancy factor (can be NULL)
+/// * `max_memory_mb` - Receives the memory limit in MB (can be NULL)
+/// * `concurrency_limit` - Receives the concurrency limit (can be NULL)
+///
+/// Returns:
+/// *   0 on success
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_get_config(
+    session_id: usize,
+    symbol_size: *mut u16,
+    redundancy_factor: *mut u8,
+    max_memory_mb: *mut u64,
+    concurrency_limit: *mut u64,
+) -> i32 {
+    ffi_guard(-1, || {
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        let config = processor.get_config();
+        unsafe {
+            if !symbol_size.is_null() {
+                *symbol_size = config.symbol_size;
+            }
+            if !redundancy_factor.is_null() {
+                *redundancy_factor = config.redundancy_factor;
+            }
+            if !max_memory_mb.is_null() {
+                *max_memory_mb = config.max_memory_mb;
+            }
+            if !concurrency_limit.is_null() {
+                *concurrency_limit = config.concurrency_limit;
+            }
+        }
+
+        0
+    })
+}
+
+/// Gets the number of task slots of a session free right now, out of its concurrency limit
+///
+/// Parallel operations run their extra workers on free slots only, so the threads of a
+/// session never exceed its concurrency limit. A program running its own workers, e.g.
+/// a goroutine per file, can size them with this count. Operations of other threads may
+/// take the slots at any time, so it is a hint, not a reservation.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `available_slots` - Receives the number of free task slots
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_get_available_task_slots(session_id: usize, available_slots: *mut u64) -> i32 {
+    ffi_guard(-1, || {
+        if available_slots.is_null() {
+            return -2;
+        }
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        unsafe {
+            *available_slots = processor.available_task_slots() as u64;
+        }
+        0
+    })
+}
+
+/// Gets a recommended block size based on file size and available memory
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `file_size` - Size of the file to process
+///
+/// Returns:
+/// * Recommended block size in bytes
+/// * 0 if it should not block or on error
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_get_recommended_block_size(
+    session_id: usize,
+    file_size: u64,
+) -> usize {
+    ffi_guard(0, || {
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return 0,
+        };
+
+        processor.get_recommended_block_size(usize::try_from(file_size).unwrap_or(usize::MAX))
+    })
+}
+
+/// Gets the largest block size the session can encode
+///
+/// It depends on the symbol size, about 14 GB with 1024-byte symbols, and on the
+/// platform. Encodes with a larger block size fail with -2, while recommended block
+/// sizes never exceed it.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+///
+/// Returns:
+/// * Largest block size in bytes
+/// * 0 on error
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_get_max_block_size(session_id: usize) -> u64 {
+    ffi_guard(0, || {
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return 0,
+        };
+
+        processor.max_block_size()
+    })
+}
+
+/// Gets a recommended block size based on file size, available memory and cores
+///
+/// With several cores the file is split into a block per core, capped by the session's
+/// concurrency limit, but not smaller than 4 MiB, so the blocks can be encoded in
+/// parallel; with one core it is split only if it doesn't fit in the memory. Blocks
+/// are small enough for each core to hold one in the memory.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `file_size` - Size of the file to process
+/// * `available_memory_mb` - Memory available in MB (0 = the session's memory limit)
+/// * `cores` - Number of cores available (0 = the cores of the host)
+///
+/// Returns:
+/// * Recommended block size in bytes
+/// * 0 if it should not block or on error
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_get_recommended_block_size_for(
+    session_id: usize,
+    file_size: u64,
+    available_memory_mb: u64,
+    cores: u32,
+) -> usize {
+    ffi_guard(0, || {
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return 0,
+        };
+
+        processor.get_recommended_block_size_for(usize::try_from(file_size).unwrap_or(usize::MAX), available_memory_mb, cores as usize)
+    })
+}
+
+/// Gets a recommended redundancy factor for a file, given the fraction of its
+/// symbols expected to be lost
+///
+/// Symbols are assumed to be lost independently, the factor is the smallest one
+/// giving each block its source symbols plus a small overhead with a failure
+/// probability around one in a million. It is clamped to 255.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `file_size` - Size of the file to process
+/// * `expected_loss_fraction` - Expected fraction of lost symbols, in [0, 1)
+/// * `redundancy_factor` - Receives the recommended redundancy factor
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters, including an empty file or a fraction out of range
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_get_recommended_redundancy(
+    session_id: usize,
+    file_size: u64,
+    expected_loss_fraction: f64,
+    redundancy_factor: *mut u8,
+) -> i32 {
+    ffi_guard(-1, || {
+        if redundancy_factor.is_null() {
+            return -2;
+        }
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        match processor.get_recommended_redundancy(file_size, expected_loss_fraction) {
+            Ok(factor) => {
+                unsafe { *redundancy_factor = factor; }
+                0
+            },
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Estimates the peak memory, in bytes, used to encode a file of the given size
+///
+/// The estimate covers the largest block with the RaptorQ working memory and all of
+/// its symbols. Each operation of the session needs its own memory, so up to the
+/// concurrency limit times the estimate is used when operations run in parallel.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `file_size` - Size of the file to encode
+/// * `block_size` - Size of blocks the file will be encoded with (0 = recommended)
+/// * `peak_memory` - Receives the estimated peak memory in bytes
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters, including an empty file
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_estimate_peak_memory(
+    session_id: usize,
+    file_size: u64,
+    block_size: usize,
+    peak_memory: *mut u64,
+) -> i32 {
+    ffi_guard(-1, || {
+        if peak_memory.is_null() {
+            return -2;
+        }
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        match processor.estimate_peak_memory(file_size, block_size) {
+            Ok(bytes) => {
+                unsafe { *peak_memory = bytes; }
+                0
+            },
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Estimates the storage used by the symbol files of a file of the given size
+///
+/// Covers the source and repair symbols of every block with their payload IDs, before
+/// compression, and the nonce and tag of each file with a symbol key. The layout file
+/// is not included.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `file_size` - Size of the file to encode
+/// * `block_size` - Size of blocks the file will be encoded with (0 = recommended)
+/// * `symbol_bytes` - Receives the bytes of all symbol files
+/// * `overhead_fraction` - Receives the bytes stored in addition to the file, as a
+///   fraction of its size (can be NULL)
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters, including an empty file
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_estimate_storage(
+    session_id: usize,
+    file_size: u64,
+    block_size: usize,
+    symbol_bytes: *mut u64,
+    overhead_fraction: *mut f64,
+) -> i32 {
+    ffi_guard(-1, || {
+        if symbol_bytes.is_null() {
+            return -2;
+        }
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        match processor.estimate_storage(file_size, block_size) {
+            Ok(estimate) => {
+                unsafe {
+                    *symbol_bytes = estimate.symbol_bytes;
+                    if !overhead_fraction.is_null() {
+                        *overhead_fraction = estimate.overhead_fraction;
+                    }
+                }
+                0
+            },
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Plans how a file would be encoded, without reading its data nor writing symbols
+///
+/// The result is a JSON object in the format of raptorq_parse_layout: the `total_size`,
+/// the `symbols_count` and the `blocks` the file would be split into, with their symbol
+/// counts. Much cheaper than raptorq_create_metadata, which encodes every block.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `input_path` - Path to the input file
+/// * `block_size` - Size of blocks the file would be encoded with (0 = auto)
+/// * `result_buffer` - Buffer to store the JSON object
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters
+/// *  -3 on invalid response
+/// *  -4 on bad return buffer size
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error
+/// * -12 on File not found
+/// * -13 on Invalid path
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_plan_encode(
+    session_id: usize,
+    input_path: *const c_char,
+    block_size: usize,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        if result_buffer.is_null() {
+            return -2;
+        }
+
+        let input_path_str = match c_path_arg(input_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        match processor.plan_encode(input_path_str, block_size) {
+            Ok(plan) => {
+                let result_json = match serde_json::to_string(&plan) {
+                    Ok(j) => j,
+                    Err(_) => return -3,
+                };
+                write_c_string(&result_json, result_buffer, result_buffer_len)
+            },
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Describes how a file would be encoded, as lines of text for logs and support
+///
+/// The plan of raptorq_plan_encode with the parameters the encoder would use: the
+/// settings of the session, then the RaptorQ parameters, symbol counts and padding of
+/// each block. Meant to be read, the format may change; programs use raptorq_plan_encode.
+///
+/// Arguments:
+/// * `session_id` - Session ID returned from raptorq_init_session
+/// * `input_path` - Path to the input file
+/// * `block_size` - Size of blocks the file would be encoded with (0 = auto)
+/// * `result_buffer` - Buffer to store the description
+/// * `result_buffer_len` - Length of the result buffer
+///
+/// Returns:
+/// *   0 on success
+/// *  -2 on invalid parameters
+/// *  -4 on bad return buffer size
+/// *  -5 on invalid session
+/// *  -7 if the session was created by a previous load of the library
+/// * -11 on IO error
+/// * -12 on File not found
+/// * -13 on Invalid path
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_describe_encode(
+    session_id: usize,
+    input_path: *const c_char,
+    block_size: usize,
+    result_buffer: *mut c_char,
+    result_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        if result_buffer.is_null() {
+            return -2;
+        }
+
+        let input_path_str = match c_path_arg(input_path) {
+            Some(s) => s,
+            None => return -2,
+        };
+
+        let processor = match get_processor(session_id) {
+            Some(p) => p,
+            None => return missing_session(session_id),
+        };
+
+        match processor.describe_encode(input_path_str, block_size) {
+            Ok(description) => write_c_string(&description, result_buffer, result_buffer_len),
+            Err(e) => operation_error(&processor, &e),
+        }
+    })
+}
+
+/// Encode a file with a processor of the given configuration, created for this call
+///
+/// A shortcut for one-off encodes, see `RaptorQProcessor::encode_file`.
+///
+/// # Returns
+/// * `Err(ProcessError::InvalidConfig)` if the configuration is invalid
+/// * `Err(ProcessError)` if the encode fails
+pub fn encode_file(
+    input_path: &str,
+    output_dir: &str,
+    config: ProcessorConfig,
+    block_size: usize,
+) -> Result<ProcessResult, ProcessError> {
+    RaptorQProcessor::builder().config(config).build()?.encode_file(input_path, output_dir, block_size, false)
+}
+
+/// Decode symbols with a processor of the given configuration, created for this call
+///
+/// A shortcut for one-off decodes, see `RaptorQProcessor::decode_symbols`.
+///
+/// # Returns
+/// * `Err(ProcessError::InvalidConfig)` if the configuration is invalid
+/// * `Err(ProcessError)` if the decode fails
+pub fn decode_symbols(
+    symbols_dir: &str,
+    output_path: &str,
+    layout_path: &str,
+    config: ProcessorConfig,
+) -> Result<(), ProcessError> {
+    RaptorQProcessor::builder().config(config).build()?.decode_symbols(symbols_dir, output_path, layout_path)
+}
+
+// Data of the self-test, 16 symbols of 256 bytes
+const SELF_TEST_DATA_LEN: usize = 4096;
+const SELF_TEST_SYMBOL_SIZE: u16 = 256;
+
+/// Check that the library works by encoding a small buffer and decoding it back
+///
+/// The buffer is encoded in memory and decoded without its first source symbol, so
+/// both the encoder and the recovery from repair symbols are exercised. Nothing is
+/// written to the filesystem and it takes a few milliseconds, cheap enough for a
+/// readiness probe.
+///
+/// # Returns
+/// * `Err(ProcessError)` if the encode or the decode fails
+/// * `Err(ProcessError::DecodingFailed)` if the decoded data differs from the buffer
+pub fn self_test() -> Result<(), ProcessError> {
+    let processor = RaptorQProcessor::builder().symbol_size(SELF_TEST_SYMBOL_SIZE).build()?;
+    let data: Vec<u8> = (0..SELF_TEST_DATA_LEN).map(|i| (i * 31 % 251) as u8).collect();
+    let (result, symbols) = processor.encode_bytes(&data, 0)?;
+    let layout_content = result.layout_content.unwrap_or_default();
+    let decoded = processor.decode_bytes(&symbols[1..], layout_content.as_bytes())?;
+    if decoded != data {
+        return Err(ProcessError::DecodingFailed("self-test decoded data differs from the input".to_string()));
+    }
+    Ok(())
+}
+
+/// Checks that the library works by encoding a small buffer in memory and decoding it back
+///
+/// A cheap check for readiness probes that runs the encoder and the decoder, see
+/// `self_test`. No session is needed.
+///
+/// Arguments:
+/// * `error_buffer` - Optional buffer to store the reason of a failure
+/// * `error_buffer_len` - Length of the error buffer
+///
+/// Returns:
+/// *   0 if the library works
+/// *  -1 on generic error
+/// * -14 on Encoding failed
+/// * -15 on Decoding failed, also if the decoded data differs
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_self_test(error_buffer: *mut c_char, error_buffer_len: usize) -> i32 {
+    ffi_guard(-1, || {
+        let error = match self_test() {
+            Ok(()) => return RAPTORQ_OK,
+            Err(e) => e,
+        };
+
+        log::error!("Self-test failed: {}", error);
+        write_error_message(&error.to_string(), error_buffer, error_buffer_len);
+        error_code(&error)
+    })
+}
+
+/// Version and build of the library
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+pub struct VersionInfo {
+    /// Semantic version of the library
+    pub version: String,
+    /// Commit the library was built from, "unknown" if it was not built from a git checkout
+    pub build_hash: String,
+    /// Version of the C interface, see RAPTORQ_ABI_VERSION
+    pub abi_version: u32,
+}
+
+/// Version and build of the library
+pub fn version_info() -> VersionInfo {
+    VersionInfo {
+        version: env!("CARGO_PKG_VERSION").to_string(),
+        build_hash: option_env!("RQ_LIBRARY_BUILD_HASH").unwrap_or("unknown").to_string(),
+        abi_version: RAPTORQ_ABI_VERSION,
+    }
+}
+
+/// Version information
+#[unsafe(no_mangle)]
+pub extern "C" fn raptorq_version(
+    version_buffer: *mut c_char,
+    version_buffer_len: usize,
+) -> i32 {
+    ffi_guard(-1, || {
+        if version_buffer.is_null() {
+            return -1;
+        }
+
+        let version = "RaptorQ Library v0.1.0";
+        let c_version = match CString::new(version) {
+            Ok(s) => s,
+            Err(_) => return -1,
+        };
+
+        let version_bytes = c_version.as_bytes_with_nul();
+        if version_bytes.len() > version_buffer_len {
+            return -1;
+        }
+
+        unsafe {
+            ptr::copy_nonoverlapping(
+                version_bytes.as_ptr() as *const c_char,
+                version_buffer,
+                version_bytes.len(),
+            );
+        }
+
+        0
+    })
+}
+
+#[cfg(test)]
+mod ffi_tests {
+    use super::*;
+    use std::ffi::{CStr, CString};
+    use std::fs::{self, File};
+    use std::io::{self, Write};
+    use std::path::{Path, PathBuf};
+    use std::ptr;
+    use tempfile::tempdir;
+
+    // Helper functions for the tests
+    fn create_temp_file(dir: &Path, name: &str, content: &[u8]) -> io::Result<PathBuf> {
+        let file_path = dir.join(name);
+        let mut file = File::create(&file_path)?;
+        file.write_all(content)?;
+        Ok(file_path)
+    }
+
+    // Tests for raptorq_init_session
+    #[test]
+        fn test_ffi_init_success() {
+            let session_id = raptorq_init_session(1024, 10, 1024, 4);
+            assert!(session_id > 0, "Session ID should be non-zero");
+            
+            // Verify processor exists in PROCESSORS map with correct config
+            let processors = PROCESSORS.lock();
+            let processor = processors.get(&session_id).expect("Processor should exist in map");
+            
+            let config = &processor.get_config();
+            assert_eq!(config.symbol_size, 1024);
+            assert_eq!(config.redundancy_factor, 10);
+            assert_eq!(config.max_memory_mb, 1024);
+            assert_eq!(config.concurrency_limit, 4);
+            
+            // Clean up
+            drop(processors);
+            raptorq_free_session(session_id);
+        }
+    
+        #[test]
+        fn test_ffi_init_invalid_config() {
+            assert_eq!(raptorq_init_session(0, 10, 1024, 4), 0);
+            assert_eq!(raptorq_init_session(4, 10, 1024, 4), 0);
+            assert_eq!(raptorq_init_session(1024, 0, 1024, 4), 0);
+            assert_eq!(raptorq_init_session(1024, 10, 0, 4), 0);
+            assert_eq!(raptorq_init_session(1024, 10, 1024, 0), 0);
+        }
+
+        #[test]
+        fn test_ffi_validate_config() {
+            assert_eq!(raptorq_validate_config(1024, 10, 1024, 4, ptr::null_mut(), 0), 0);
+            assert_eq!(raptorq_validate_config(0, 10, 1024, 4, ptr::null_mut(), 0), -2);
+
+            let mut error_buffer = vec![0u8; 256];
+            let result = raptorq_validate_config(
+                1024, 0, 1024, 4,
+                error_buffer.as_mut_ptr() as *mut c_char,
+                error_buffer.len(),
+            );
+            assert_eq!(result, -2);
+            let message = unsafe { CStr::from_ptr(error_buffer.as_ptr() as *const c_char) }
+                .to_str()
+                .unwrap();
+            assert!(message.contains("redundancy factor"), "Unexpected message: {}", message);
+
+            // Message is truncated to the buffer
+            let mut small_buffer = vec![0xffu8; 8];
+            let result = raptorq_validate_config(
+                1024, 10, 0, 4,
+                small_buffer.as_mut_ptr() as *mut c_char,
+                small_buffer.len(),
+            );
+            assert_eq!(result, -2);
+            assert_eq!(small_buffer[7], 0);
+            assert_eq!(&small_buffer[..7], b"Invalid");
+        }
+
+        #[test]
+        fn test_ffi_check_abi() {
+            assert_eq!(raptorq_check_abi(RAPTORQ_ABI_VERSION, ptr::null_mut(), 0), 0);
+
+            let mut error_buffer = vec![0u8; 256];
+            let result = raptorq_check_abi(
+                RAPTORQ_ABI_VERSION + 1,
+                error_buffer.as_mut_ptr() as *mut c_char,
+                error_buffer.len(),
+            );
+            assert_eq!(result, RAPTORQ_ERR_ABI_MISMATCH);
+            let message = buffer_as_string(error_buffer.as_ptr() as *const c_char, error_buffer.len());
+            assert_eq!(
+                message,
+                format!("library ABI {} incompatible with wrapper ABI {}", RAPTORQ_ABI_VERSION, RAPTORQ_ABI_VERSION + 1)
+            );
+
+            assert_eq!(raptorq_check_abi(0, ptr::null_mut(), 0), RAPTORQ_ERR_ABI_MISMATCH);
+        }
+
+        #[test]
+        fn test_encode_and_decode_without_processor() {
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let data: Vec<u8> = (0..5000).map(|i| (i * 31 / 7 % 251) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &data)
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+            let output_path = temp_dir.path().join("decoded.bin");
+            let config = ProcessorConfig { symbol_size: 1024, ..ProcessorConfig::default() };
+
+            let result = encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), config.clone(), 0).unwrap();
+            decode_symbols(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path, config).unwrap();
+            assert_eq!(fs::read(&output_path).unwrap(), data);
+
+            let invalid = ProcessorConfig { redundancy_factor: 0, ..ProcessorConfig::default() };
+            let result = encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), invalid, 0);
+            assert!(matches!(result, Err(ProcessError::InvalidConfig(_))));
+        }
+
+        #[test]
+        fn test_ffi_oneshot() {
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let data: Vec<u8> = (0..5000).map(|i| (i * 31 / 7 % 251) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &data)
+                .expect("Failed to create test input file");
+            let input_path_c = CString::new(input_path.to_string_lossy().as_ref()).unwrap();
+            let symbols_dir_c = CString::new(temp_dir.path().join("symbols").to_string_lossy().as_ref()).unwrap();
+            let output_path = temp_dir.path().join("decoded.bin");
+            let output_path_c = CString::new(output_path.to_str().unwrap()).unwrap();
+
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let mut error_buffer = vec![0u8; 256];
+            let result = raptorq_encode_file_oneshot(
+                1024, 4, 1024, 4,
+                input_path_c.as_ptr(),
+                symbols_dir_c.as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+                error_buffer.as_mut_ptr() as *mut c_char,
+                error_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encoding should succeed");
+            let result_json = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
+            let process_result: ProcessResult = serde_json::from_str(&result_json).unwrap();
+            let layout_path_c = CString::new(process_result.layout_file_path).unwrap();
+
+            let result = raptorq_decode_symbols_oneshot(
+                1024, 4, 1024, 4,
+                symbols_dir_c.as_ptr(),
+                output_path_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                ptr::null_mut(),
+                0,
+            );
+            assert_eq!(result, 0, "Decoding should succeed");
+            assert_eq!(fs::read(&output_path).unwrap(), data);
+
+            // The error is reported in the buffer
+            let missing_c = CString::new(temp_dir.path().join("missing.bin").to_str().unwrap()).unwrap();
+            let result = raptorq_encode_file_oneshot(
+                1024, 4, 1024, 4,
+                missing_c.as_ptr(),
+                symbols_dir_c.as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+                error_buffer.as_mut_ptr() as *mut c_char,
+                error_buffer.len(),
+            );
+            assert_eq!(result, RAPTORQ_ERR_FILE_NOT_FOUND);
+            let message = buffer_as_string(error_buffer.as_ptr() as *const c_char, error_buffer.len());
+            assert!(message.contains("missing.bin"), "Unexpected error {:?}", message);
+
+            let result = raptorq_decode_symbols_oneshot(
+                1024, 0, 1024, 4,
+                symbols_dir_c.as_ptr(),
+                output_path_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                error_buffer.as_mut_ptr() as *mut c_char,
+                error_buffer.len(),
+            );
+            assert_eq!(result, -2, "An invalid configuration should return -2");
+            let message = buffer_as_string(error_buffer.as_ptr() as *const c_char, error_buffer.len());
+            assert!(message.contains("redundancy factor"), "Unexpected error {:?}", message);
+        }
+
+        #[test]
+        fn test_ffi_clone_session() {
+            let session_id = raptorq_init_session(2048, 6, 512, 3);
+            let clone_id = raptorq_clone_session(session_id);
+            assert!(clone_id > 0, "Clone session ID should be non-zero");
+            assert_ne!(clone_id, session_id, "Clone should be a new session");
+
+            let original = get_processor(session_id).unwrap();
+            let clone = get_processor(clone_id).unwrap();
+            assert!(!Arc::ptr_eq(&original, &clone), "Clone should have its own processor");
+            let config = clone.get_config();
+            assert_eq!(config.symbol_size, 2048);
+            assert_eq!(config.redundancy_factor, 6);
+            assert_eq!(config.max_memory_mb, 512);
+            assert_eq!(config.concurrency_limit, 3);
+
+            // The options of the original are copied
+            let key = [7u8; 32];
+            assert_eq!(raptorq_set_symbol_key(session_id, key.as_ptr(), key.len()), 0);
+            assert_eq!(raptorq_set_symbol_codec(session_id, RAPTORQ_CODEC_ZSTD), 0);
+            assert_eq!(raptorq_set_symbol_crc(session_id, true), 0);
+            assert_eq!(raptorq_set_layout_format(session_id, RAPTORQ_LAYOUT_BINARY), 0);
+            let metadata = CString::new(r#"{"object":"a"}"#).unwrap();
+            assert_eq!(raptorq_set_layout_metadata(session_id, metadata.as_ptr()), 0);
+            assert_eq!(raptorq_enable_metrics(session_id, true), 0);
+            let options_clone_id = raptorq_clone_session(session_id);
+
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let input_path = create_temp_file(temp_dir.path(), "input.bin", &[42u8; 10_000]).unwrap();
+            let symbols_dir = temp_dir.path().join("symbols");
+            let options_clone = get_processor(options_clone_id).unwrap();
+            let result = options_clone.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 0, false).unwrap();
+            let content = fs::read(&result.layout_file_path).unwrap();
+            assert_eq!(LayoutFormat::detect(&content), LayoutFormat::Binary);
+            let layout = RaptorQLayout::parse(&content).unwrap();
+            assert_eq!(layout.symbol_codec, SymbolCodec::Zstd);
+            assert!(!layout.symbol_key_id.is_empty());
+            assert!(layout.symbol_crc);
+            assert_eq!(layout.metadata.get("object").map(String::as_str), Some("a"));
+
+            // With metrics of its own
+            let mut metrics_buffer = [0u8; 4096];
+            assert_eq!(raptorq_get_metrics(options_clone_id, metrics_buffer.as_mut_ptr() as *mut c_char, metrics_buffer.len()), 0);
+            let session_metrics = SESSION_METRICS.lock();
+            assert!(!Arc::ptr_eq(&session_metrics[&session_id], &session_metrics[&options_clone_id]));
+            drop(session_metrics);
+            assert!(raptorq_free_session(options_clone_id));
+
+            // Freeing the original doesn't free the clone
+            assert!(raptorq_free_session(session_id));
+            assert!(get_processor(clone_id).is_some());
+            assert!(raptorq_free_session(clone_id));
+
+            assert_eq!(raptorq_clone_session(session_id), 0, "Invalid session should return 0");
+        }
+
+        #[test]
+        fn test_ffi_init_multiple() {
+            let session_id1 = raptorq_init_session(1024, 10, 1024, 4);
+            let session_id2 = raptorq_init_session(512, 5, 512, 2);
+            
+            assert!(session_id1 > 0, "First session ID should be non-zero");
+            assert!(session_id2 > 0, "Second session ID should be non-zero");
+            assert_ne!(session_id1, session_id2, "Session IDs should be unique");
+            
+            // Verify both processors exist in the map
+            let processors = PROCESSORS.lock();
+            assert!(processors.contains_key(&session_id1), "First processor should exist in map");
+            assert!(processors.contains_key(&session_id2), "Second processor should exist in map");
+            
+            // Clean up
+            drop(processors);
+            raptorq_free_session(session_id1);
+            raptorq_free_session(session_id2);
+        }
+    
+        // Tests for raptorq_free_session
+        #[test]
+        fn test_ffi_free_valid_session() {
+            let session_id = init_test_session();
+            
+            // Verify session exists before freeing
+            {
+                let processors = PROCESSORS.lock();
+                assert!(processors.contains_key(&session_id), "Session should exist before freeing");
+            }
+            
+            // Free the session
+            let result = raptorq_free_session(session_id);
+            assert!(result, "Freeing valid session should return true");
+            
+            // Verify processor was removed from map
+            let processors = PROCESSORS.lock();
+            assert!(!processors.contains_key(&session_id), "Session should not exist after freeing");
+        }
+    
+        #[test]
+        fn test_ffi_free_invalid_session() {
+            // Try to free a session that doesn't exist
+            let invalid_session_id = 99999;
+            let result = raptorq_free_session(invalid_session_id);
+            
+            assert!(!result, "Freeing invalid session should return false");
+        }
+    
+        #[test]
+        fn test_ffi_free_double_free() {
+            let session_id = init_test_session();
+            
+            // First free (should succeed)
+            let first_result = raptorq_free_session(session_id);
+            assert!(first_result, "First free should return true");
+            
+            // Second free (should fail)
+            let second_result = raptorq_free_session(session_id);
+            assert!(!second_result, "Second free of same ID should return false");
+        }
+    
+        #[test]
+        fn test_ffi_close_session() {
+            let session_id = init_test_session();
+
+            assert_eq!(raptorq_close_session(session_id), 0, "Closing a session should return 0");
+            assert!(!PROCESSORS.lock().contains_key(&session_id), "Session should not exist after closing");
+            assert_eq!(raptorq_close_session(session_id), 0, "Closing a session again should return 0");
+            assert!(!raptorq_free_session(session_id), "Freeing a closed session should return false");
+
+            assert_eq!(raptorq_close_session(0), -5, "Session 0 should return -5");
+            assert_eq!(raptorq_close_session(999999), -5, "Unknown session should return -5");
+        }
+
+        #[test]
+        fn test_ffi_pool() {
+            assert_eq!(raptorq_pool_create(1024, 10, 1024, 4, 3, 2), 0, "Size above the maximum should return 0");
+            assert_eq!(raptorq_pool_create(4, 10, 1024, 4, 1, 2), 0, "Invalid configuration should return 0");
+            let pool_id = raptorq_pool_create(1024, 10, 1024, 4, 1, 2);
+            assert_ne!(pool_id, 0);
+
+            let mut first = 0usize;
+            let mut second = 0usize;
+            let mut third = 0usize;
+            assert_eq!(raptorq_pool_acquire(pool_id, false, &mut first), 0);
+            assert_eq!(raptorq_pool_acquire(pool_id, true, &mut second), 0, "Pool can still grow");
+            assert_eq!(raptorq_pool_acquire(pool_id, false, &mut third), -17, "Pool is at its maximum size");
+            assert_eq!(raptorq_pool_acquire(pool_id, false, ptr::null_mut()), -2);
+            assert_ne!(first, second);
+
+            // Acquired sessions work with the functions taking a session
+            let mut symbol_size = 0u16;
+            assert_eq!(raptorq_get_config(first, &mut symbol_size, ptr::null_mut(), ptr::null_mut(), ptr::null_mut()), 0);
+            assert_eq!(symbol_size, 1024);
+            let metadata_c = CString::new(r#"{"object":"a"}"#).unwrap();
+            assert_eq!(raptorq_set_layout_metadata(first, metadata_c.as_ptr()), 0);
+
+            assert_eq!(raptorq_pool_release(pool_id, first), 0);
+            assert_eq!(raptorq_session_status(first), -5, "Released session should not be usable");
+            assert_eq!(raptorq_pool_release(pool_id, first), -5, "Released session should return -5");
+            assert_eq!(raptorq_pool_release(pool_id, 999999), -5, "Session of another pool should return -5");
+            assert_eq!(raptorq_pool_release(999999, second), -5, "Invalid pool should return -5");
+
+            // The next thread gets the session without the options set on it
+            assert_eq!(raptorq_pool_acquire(pool_id, false, &mut third), 0);
+            assert_ne!(third, first);
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let input_path = create_temp_file(temp_dir.path(), "input.bin", &[3u8; 3000])
+                .expect("Failed to create test input file");
+            let mut result_buffer = [0u8; 4096];
+            let result = raptorq_create_metadata(
+                third,
+                CString::new(input_path.to_str().unwrap()).unwrap().as_ptr(),
+                CString::new("").unwrap().as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0);
+            let result_str = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
+            let process_result: ProcessResult = serde_json::from_str(&result_str).unwrap();
+            assert!(!process_result.layout_content.unwrap().contains("metadata"));
+
+            // A session freed instead of released is replaced
+            assert!(raptorq_free_session(second));
+            assert_eq!(raptorq_pool_release(pool_id, second), 0);
+            assert_eq!(raptorq_pool_acquire(pool_id, false, &mut second), 0);
+
+            // Freeing the pool frees the sessions still acquired
+            assert!(raptorq_pool_free(pool_id));
+            assert_eq!(raptorq_session_status(second), -5);
+            assert_eq!(raptorq_session_status(third), -5);
+            assert_eq!(raptorq_pool_acquire(pool_id, false, &mut first), -5, "Freed pool should return -5");
+            assert!(!raptorq_pool_free(pool_id));
+        }
+
+        #[test]
+        fn test_ffi_active_session_count() {
+            // Other tests create and free sessions at the same time, but hold far fewer
+            // than the 64 sessions of this one, so its own show in the count
+            let session_ids: Vec<usize> = (0..64).map(|_| init_test_session()).collect();
+            let created = raptorq_active_session_count();
+            assert!(created >= 64);
+
+            for &session_id in &session_ids[..32] {
+                assert!(raptorq_free_session(session_id));
+            }
+            let freed = raptorq_active_session_count();
+            assert!(freed + 16 <= created, "Freeing 32 sessions should lower the count from {} but it is {}", created, freed);
+
+            for &session_id in &session_ids[32..] {
+                assert_eq!(raptorq_close_session(session_id), 0);
+            }
+            let closed = raptorq_active_session_count();
+            assert!(closed + 16 <= freed, "Closing 32 sessions should lower the count from {} but it is {}", freed, closed);
+            assert!(session_ids.iter().all(|session_id| !PROCESSORS.lock().contains_key(session_id)));
+        }
+
+        #[test]
+        fn test_ffi_session_status() {
+            let session_id = init_test_session();
+            assert_eq!(session_id >> LOAD_TAG_SHIFT, *LOAD_TAG, "Session IDs should carry the tag of the load");
+            assert_eq!(raptorq_session_status(session_id), 0, "Active session should return 0");
+
+            raptorq_free_session(session_id);
+            assert_eq!(raptorq_session_status(session_id), -5, "Freed session should return -5");
+            assert_eq!(raptorq_session_status(0), -5, "Session 0 should return -5");
+            assert_eq!(raptorq_session_status(999999), -5, "Untagged session should return -5");
+
+            // The same session as created by another load of the library
+            let other_tag = *LOAD_TAG % ((1 << LOAD_TAG_BITS) - 1) + 1;
+            let stale_id = (other_tag << LOAD_TAG_SHIFT) | (session_id & ((1 << LOAD_TAG_SHIFT) - 1));
+            assert_eq!(raptorq_session_status(stale_id), RAPTORQ_ERR_SESSION_INVALIDATED);
+            assert_eq!(raptorq_close_session(stale_id), RAPTORQ_ERR_SESSION_INVALIDATED);
+
+            // Every function taking a session reports it the same way
+            assert_eq!(raptorq_cancel(stale_id), RAPTORQ_ERR_SESSION_INVALIDATED);
+            let mut result_buffer = [0u8; 1024];
+            let result = raptorq_encode_file(
+                stale_id,
+                CString::new("input").unwrap().as_ptr(),
+                CString::new("output").unwrap().as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, RAPTORQ_ERR_SESSION_INVALIDATED);
+            assert_eq!(raptorq_get_metrics(stale_id, result_buffer.as_mut_ptr() as *mut c_char, result_buffer.len()), RAPTORQ_ERR_SESSION_INVALIDATED);
+        }
+
+        // Tests for raptorq_encode_file
+        #[test]
+        fn test_ffi_encode_null_pointers() {
+            let session_id = init_test_session();
+            let mut result_buffer = [0u8; 1024];
+            
+            // Test with null input_path
+            let result = raptorq_encode_file(
+                    session_id,
+                    ptr::null(),
+                    CString::new("output").unwrap().as_ptr(),
+                    0,
+                    result_buffer.as_mut_ptr() as *mut c_char,
+                    result_buffer.len(),
+                );
+            assert_eq!(result, -2, "Null input_path should return -2");
+            
+            // Test with null output_dir
+            let result = raptorq_encode_file(
+                    session_id,
+                    CString::new("input").unwrap().as_ptr(),
+                    ptr::null(),
+                    0,
+                    result_buffer.as_mut_ptr() as *mut c_char,
+                    result_buffer.len(),
+                );
+            assert_eq!(result, -2, "Null output_dir should return -2");
+            
+            // Test with null result_buffer
+            let result = raptorq_encode_file(
+                    session_id,
+                    CString::new("input").unwrap().as_ptr(),
+                    CString::new("output").unwrap().as_ptr(),
+                    0,
+                    ptr::null_mut(),
+                    1024,
+                );
+            assert_eq!(result, -2, "Null result_buffer should return -2");
+            
+            // Clean up
+            raptorq_free_session(session_id);
+        }
+    
+        #[test]
+        fn test_ffi_encode_invalid_session() {
+            let invalid_session_id = 99999;
+            let mut result_buffer = [0u8; 1024];
+            
+            let result = raptorq_encode_file(
+                    invalid_session_id,
+                    CString::new("input").unwrap().as_ptr(),
+                    CString::new("output").unwrap().as_ptr(),
+                    0,
+                    result_buffer.as_mut_ptr() as *mut c_char,
+                    result_buffer.len(),
+                );
+            
+            assert_eq!(result, -5, "Invalid session ID should return -5");
+        }
+    
+        #[test]
+        fn test_ffi_encode_success() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            
+            // Create test input file
+            let input_path = create_temp_file(
+                temp_dir.path(),
+                "test_input.txt",
+                b"This is test content for encoding",
+            ).expect("Failed to create test input file");
+            
+            let output_dir = temp_dir.path().join("output");
+            fs::create_dir_all(&output_dir).expect("Failed to create output directory");
+            
+            // Buffer for result
+            let mut result_buffer = [0u8; 1024];
+            
+            let result = raptorq_encode_file(
+                    session_id,
+                    CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                    CString::new(output_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                    0, // auto block size
+                    result_buffer.as_mut_ptr() as *mut c_char,
+                    result_buffer.len(),
+                );
+            
+            assert_eq!(result, 0, "Encoding should succeed with return code 0");
+            
+            // Verify result_buffer contains valid JSON
+            let result_json = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
+            let _parsed: serde_json::Value = serde_json::from_str(&result_json)
+                .expect("Result buffer should contain valid JSON");
+            
+            // Clean up
+            raptorq_free_session(session_id);
+        }
+    
+        #[test]
+        fn test_ffi_encode_file_not_found() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            
+            // Non-existent input file
+            let input_path = temp_dir.path().join("nonexistent.txt");
+            let output_dir = temp_dir.path().join("output");
+            fs::create_dir_all(&output_dir).expect("Failed to create output directory");
+            
+            // Buffer for result
+            let mut result_buffer = [0u8; 1024];
+            
+            let result = raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                CString::new(output_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            
+            assert_eq!(result, -12, "File not found should return -12");
+            
+            // Check error message
+            let mut error_buffer = [0u8; 1024];
+            raptorq_get_last_error(
+                    session_id,
+                    error_buffer.as_mut_ptr() as *mut c_char,
+                    error_buffer.len(),
+                );
+            
+            let error_msg = buffer_as_string(error_buffer.as_ptr() as *const c_char, error_buffer.len());
+            assert!(!error_msg.is_empty(), "Error message should not be empty");
+            
+            // Clean up
+            raptorq_free_session(session_id);
+        }
+    
+        #[test]
+        fn test_ffi_encode_empty_file() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            
+            let input_path = create_temp_file(
+                temp_dir.path(),
+                "empty.txt",
+                b"", // Empty content
+            ).expect("Failed to create empty test file");
+            
+            let output_dir = temp_dir.path().join("output");
+            let output_dir_c = CString::new(output_dir.to_string_lossy().as_ref()).unwrap();
+            
+            // Buffer for result
+            let mut result_buffer = [0u8; 1024];
+            
+            let result = raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                output_dir_c.as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "An empty file should be encoded");
+
+            // The layout without blocks decodes to an empty file
+            let result_json = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
+            let process_result: ProcessResult = serde_json::from_str(&result_json).unwrap();
+            assert_eq!(process_result.total_symbols_count, 0);
+            let output_path = temp_dir.path().join("decoded.txt");
+            let result = raptorq_decode_symbols(
+                session_id,
+                output_dir_c.as_ptr(),
+                CString::new(output_path.to_str().unwrap()).unwrap().as_ptr(),
+                CString::new(process_result.layout_file_path).unwrap().as_ptr(),
+            );
+            assert_eq!(result, 0, "Decoding should succeed");
+            assert_eq!(fs::read(&output_path).unwrap(), b"");
+            
+            // Clean up
+            raptorq_free_session(session_id);
+        }
+    
+        #[test]
+        fn test_ffi_encode_result_buffer_too_small() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            
+            // Create test input file
+            let input_path = create_temp_file(
+                temp_dir.path(),
+                "test_input.txt",
+                b"This is test content for encoding with a buffer that's too small",
+            ).expect("Failed to create test input file");
+            
+            let output_dir = temp_dir.path().join("output");
+            fs::create_dir_all(&output_dir).expect("Failed to create output directory");
+            
+            // Very small result buffer
+            let mut result_buffer = [0u8; 5];
+            
+            let result = raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                CString::new(output_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            
+            assert_eq!(result, -4, "Result buffer too small should return -4");
+            
+            // Clean up
+            raptorq_free_session(session_id);
+        }
+    
+        #[test]
+        fn test_ffi_encode_path_conversion_error() {
+            let session_id = init_test_session();
+            
+            // Create invalid UTF-8 C string (not easy in Rust, using a workaround)
+            // We can simulate this by creating a CString and manually manipulating it
+            let mut result_buffer = [0u8; 1024];
+            
+            // Instead of trying to create invalid UTF-8, we'll test the path conversion
+            // error branch indirectly by passing extremely long paths that would likely
+            // fail in CString::new
+            let very_long_path = "a".repeat(100000); // Extremely long path
+            
+            let result = raptorq_encode_file(
+                session_id,
+                CString::new(very_long_path.clone()).unwrap_or(CString::new("x").unwrap()).as_ptr(),
+                CString::new("output").unwrap().as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            
+            // The result may be -1 (for path conversion error) or something else if the system
+            // accepts very long paths, but the important part is to exercise that code path
+            if result == -1 {
+                // Test successful - path conversion failed as expected
+            }
+            
+            // Clean up
+            raptorq_free_session(session_id);
+        }
+    
+        // Tests for raptorq_get_last_error
+        #[test]
+        fn test_ffi_get_error_null_buffer() {
+            let session_id = init_test_session();
+            
+            let result = raptorq_get_last_error(
+                session_id,
+                ptr::null_mut(),
+                1024,
+            );
+            
+            assert_eq!(result, -1, "Null error buffer should return -1");
+            
+            // Clean up
+            raptorq_free_session(session_id);
+        }
+    
+        #[test]
+        fn test_ffi_get_error_invalid_session() {
+            let invalid_session_id = 99999;
+            let mut error_buffer = [0u8; 1024];
+            
+            let result = raptorq_get_last_error(
+                invalid_session_id,
+                error_buffer.as_mut_ptr() as *mut c_char,
+                error_buffer.len(),
+            );
+            
+            assert_eq!(result, -1, "Invalid session ID should return -1");
+        }
+    
+        #[test]
+        fn test_ffi_get_error_success_empty() {
+            let session_id = init_test_session();
+            let mut error_buffer = [0u8; 1024];
+            
+            // No prior error, should return empty string
+            let result = raptorq_get_last_error(
+                session_id,
+                error_buffer.as_mut_ptr() as *mut c_char,
+                error_buffer.len(),
+            );
+            
+            assert_eq!(result, 0, "Get error should return 0 for success");
+            
+            let error_msg = buffer_as_string(error_buffer.as_ptr() as *const c_char, error_buffer.len());
+            assert_eq!(error_msg, "", "Error buffer should contain empty string");
+            
+            // Clean up
+            raptorq_free_session(session_id);
+        }
+    
+        #[test]
+        fn test_ffi_get_error_success_message() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            
+            // Trigger an error first (file not found)
+            let input_path = temp_dir.path().join("nonexistent.txt");
+            let output_dir = temp_dir.path().join("output");
+            fs::create_dir_all(&output_dir).expect("Failed to create output directory");
+            
+            let mut result_buffer = [0u8; 1024];
+            
+            raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                CString::new(output_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            
+            // Now get the error message
+            let mut error_buffer = [0u8; 1024];
+            
+            let result = raptorq_get_last_error(
+                session_id,
+                error_buffer.as_mut_ptr() as *mut c_char,
+                error_buffer.len(),
+            );
+            
+            assert_eq!(result, 0, "Get error should return 0 for success");
+            
+            let error_msg = buffer_as_string(error_buffer.as_ptr() as *const c_char, error_buffer.len());
+            assert!(!error_msg.is_empty(), "Error buffer should contain an error message");
+            
+            // Clean up
+            raptorq_free_session(session_id);
+        }
+    
+        #[test]
+        fn test_ffi_reset_session() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+
+            let mut result_buffer = [0u8; 1024];
+            raptorq_encode_file(
+                session_id,
+                CString::new(temp_dir.path().join("nonexistent.txt").to_str().unwrap()).unwrap().as_ptr(),
+                CString::new(temp_dir.path().join("output").to_str().unwrap()).unwrap().as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+
+            let mut error_buffer = [0u8; 1024];
+            assert_eq!(raptorq_get_last_error(session_id, error_buffer.as_mut_ptr() as *mut c_char, error_buffer.len()), 0);
+            assert!(!buffer_as_string(error_buffer.as_ptr() as *const c_char, error_buffer.len()).is_empty());
+
+            assert_eq!(raptorq_reset_session(session_id), 0);
+            assert_eq!(raptorq_get_last_error(session_id, error_buffer.as_mut_ptr() as *mut c_char, error_buffer.len()), 0);
+            let error_msg = buffer_as_string(error_buffer.as_ptr() as *const c_char, error_buffer.len());
+            assert!(error_msg.is_empty(), "Last error should be empty after a reset");
+
+            raptorq_free_session(session_id);
+
+            assert_eq!(raptorq_reset_session(session_id), -5, "Invalid session should return -5");
+        }
+
+        #[test]
+        fn test_ffi_set_log_callback() {
+            extern "C" fn collect_event(context: *mut c_void, level: i32, event_json: *const c_char) {
+                let events = unsafe { &*(context as *const Mutex<Vec<(i32, serde_json::Value)>>) };
+                let json = unsafe { CStr::from_ptr(event_json) }.to_str().unwrap();
+                events.lock().push((level, serde_json::from_str(json).unwrap()));
+            }
+
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let original_content: Vec<u8> = (0..5000).map(|i| (i % 199) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &original_content)
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+            let input_path_c = CString::new(input_path.to_string_lossy().as_ref()).unwrap();
+            let symbols_dir_c = CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap();
+            let layout_path_c = CString::new(symbols_dir.join("_raptorq_layout.json").to_string_lossy().as_ref()).unwrap();
+            let output_path_c = CString::new(temp_dir.path().join("decoded.bin").to_string_lossy().as_ref()).unwrap();
+
+            let events: Mutex<Vec<(i32, serde_json::Value)>> = Mutex::new(Vec::new());
+            let context = &events as *const _ as *mut c_void;
+            assert_eq!(raptorq_set_log_callback(session_id, Some(collect_event), context), 0);
+
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let result = raptorq_encode_file(
+                session_id,
+                input_path_c.as_ptr(),
+                symbols_dir_c.as_ptr(),
+                2048,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encoding should succeed");
+            let result = raptorq_decode_symbols(session_id, symbols_dir_c.as_ptr(), output_path_c.as_ptr(), layout_path_c.as_ptr());
+            assert_eq!(result, 0, "Decoding should succeed");
+
+            {
+                let events = events.lock();
+                assert_eq!(events.len(), 4);
+                assert_eq!(events[0].0, RAPTORQ_LOG_DEBUG);
+                assert_eq!(events[0].1["operation"], "encode_file");
+                assert_eq!(events[0].1["stage"], "started");
+
+                let (level, encoded) = &events[1];
+                assert_eq!(*level, RAPTORQ_LOG_INFO);
+                assert_eq!(encoded["stage"], "completed");
+                assert_eq!(encoded["object_size"], 5000);
+                assert_eq!(encoded["blocks"], 3);
+                assert!(encoded["symbols"].as_u64().unwrap() > 0);
+                assert!(encoded["duration_ms"].is_u64());
+
+                let decoded = &events[3].1;
+                assert_eq!(decoded["operation"], "decode_symbols");
+                assert_eq!(decoded["object_size"], 5000);
+                assert_eq!(decoded["symbols"], encoded["symbols"]);
+            }
+
+            // Failures are logged with their error
+            events.lock().clear();
+            let missing_c = CString::new(temp_dir.path().join("missing.json").to_string_lossy().as_ref()).unwrap();
+            assert_ne!(raptorq_decode_symbols(session_id, symbols_dir_c.as_ptr(), output_path_c.as_ptr(), missing_c.as_ptr()), 0);
+            {
+                let events = events.lock();
+                assert_eq!(events.len(), 2);
+                assert_eq!(events[1].1["stage"], "failed");
+                assert!(events[1].1["error"].is_string());
+            }
+
+            // Without a callback nothing is logged
+            events.lock().clear();
+            assert_eq!(raptorq_set_log_callback(session_id, None, ptr::null_mut()), 0);
+            assert_eq!(raptorq_decode_symbols(session_id, symbols_dir_c.as_ptr(), output_path_c.as_ptr(), layout_path_c.as_ptr()), 0);
+            assert!(events.lock().is_empty());
+
+            raptorq_free_session(session_id);
+
+            assert_eq!(raptorq_set_log_callback(session_id, None, ptr::null_mut()), -5, "Invalid session should return -5");
+        }
+
+        #[test]
+        fn test_ffi_metrics() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let original_content: Vec<u8> = (0..5000).map(|i| (i % 199) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &original_content)
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+            let input_path_c = CString::new(input_path.to_string_lossy().as_ref()).unwrap();
+            let symbols_dir_c = CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap();
+            let layout_path_c = CString::new(symbols_dir.join("_raptorq_layout.json").to_string_lossy().as_ref()).unwrap();
+            let output_path_c = CString::new(temp_dir.path().join("decoded.bin").to_string_lossy().as_ref()).unwrap();
+            let missing_c = CString::new(temp_dir.path().join("missing.json").to_string_lossy().as_ref()).unwrap();
+
+            let mut metrics_buffer = vec![0u8; 16 * 1024];
+            let metrics_ptr = metrics_buffer.as_mut_ptr() as *mut c_char;
+            assert_eq!(raptorq_get_metrics(session_id, metrics_ptr, metrics_buffer.len()), -1, "Metrics are disabled by default");
+            assert_eq!(raptorq_enable_metrics(session_id, true), 0);
+
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let result = raptorq_encode_file(
+                session_id,
+                input_path_c.as_ptr(),
+                symbols_dir_c.as_ptr(),
+                2048,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encoding should succeed");
+            assert_eq!(raptorq_decode_symbols(session_id, symbols_dir_c.as_ptr(), output_path_c.as_ptr(), layout_path_c.as_ptr()), 0);
+            assert_ne!(raptorq_decode_symbols(session_id, symbols_dir_c.as_ptr(), output_path_c.as_ptr(), missing_c.as_ptr()), 0);
+
+            assert_eq!(raptorq_get_metrics(session_id, metrics_ptr, metrics_buffer.len()), 0);
+            let metrics: serde_json::Value = serde_json::from_str(&buffer_as_string(metrics_ptr, metrics_buffer.len())).unwrap();
+            let encode = &metrics["operations"]["encode_file"];
+            assert_eq!(encode["succeeded"], 1);
+            assert_eq!(encode["bytes"], 5000);
+            assert_eq!(encode["blocks"], 3);
+            assert_eq!(encode["latency"]["count"], 1);
+            let decode = &metrics["operations"]["decode_symbols"];
+            assert_eq!((&decode["succeeded"], &decode["failed"]), (&serde_json::json!(1), &serde_json::json!(1)));
+            assert_eq!(decode["latency"]["count"], 2);
+
+            assert_eq!(metrics["encoded_blocks"]["blocks"], 3);
+            assert_eq!(metrics["encoded_blocks"]["symbols"], encode["symbols"]);
+            assert_eq!(metrics["decoded_blocks"]["bytes"], 5000);
+            assert!(metrics["decoded_blocks"]["slowest"]["block_id"].as_u64().unwrap() < 3);
+
+            assert_eq!(raptorq_get_metrics(session_id, metrics_ptr, 8), -4, "Small buffer should return -4");
+            assert_eq!(raptorq_get_metrics(session_id, ptr::null_mut(), 0), -2);
+
+            assert_eq!(raptorq_enable_metrics(session_id, false), 0);
+            assert_eq!(raptorq_get_metrics(session_id, metrics_ptr, metrics_buffer.len()), -1);
+
+            raptorq_free_session(session_id);
+
+            assert_eq!(raptorq_enable_metrics(session_id, true), -5, "Invalid session should return -5");
+            assert_eq!(raptorq_get_metrics(session_id, metrics_ptr, metrics_buffer.len()), -5);
+        }
+
+        #[test]
+        fn test_ffi_guard() {
+            assert_eq!(ffi_guard(-1, || 7), 7);
+            assert_eq!(ffi_guard(-1, || -> i32 { panic!("boom") }), -1);
+            assert_eq!(ffi_guard(0usize, || -> usize { panic!("{}", String::from("boom")) }), 0);
+        }
+
+        #[test]
+        fn test_ffi_pathological_inputs() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let input_path = create_temp_file(temp_dir.path(), "input.bin", &[5u8; 3000])
+                .expect("Failed to create test input file");
+            let input_path_c = CString::new(input_path.to_str().unwrap()).unwrap();
+            let output_dir_c = CString::new(temp_dir.path().join("symbols").to_str().unwrap()).unwrap();
+            let empty = CString::new("").unwrap();
+            let non_utf8 = CString::new(vec![b'/', 0xff, 0xfe, b'x']).unwrap();
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let result_ptr = result_buffer.as_mut_ptr() as *mut c_char;
+            let result_len = result_buffer.len();
+
+            // Empty and non UTF-8 paths are rejected before touching the file system
+            assert_eq!(raptorq_encode_file(session_id, empty.as_ptr(), output_dir_c.as_ptr(), 0, result_ptr, result_len), -2);
+            assert_eq!(raptorq_encode_file(session_id, input_path_c.as_ptr(), empty.as_ptr(), 0, result_ptr, result_len), -2);
+            assert_eq!(raptorq_encode_file(session_id, non_utf8.as_ptr(), output_dir_c.as_ptr(), 0, result_ptr, result_len), -2);
+            assert_eq!(raptorq_decode_symbols(session_id, empty.as_ptr(), empty.as_ptr(), empty.as_ptr()), -2);
+            assert_eq!(raptorq_decode_symbols(session_id, non_utf8.as_ptr(), non_utf8.as_ptr(), non_utf8.as_ptr()), -2);
+            assert_eq!(raptorq_can_decode(session_id, empty.as_ptr(), empty.as_ptr()), -2);
+
+            // A huge block size is capped to the file
+            assert_eq!(raptorq_encode_file(session_id, input_path_c.as_ptr(), output_dir_c.as_ptr(), usize::MAX, result_ptr, result_len), 0);
+            let result: serde_json::Value = serde_json::from_str(&buffer_as_string(result_ptr, result_len)).unwrap();
+            assert_eq!(result["blocks"].as_array().unwrap().len(), 1);
+
+            // A buffer too small is reported, not written past
+            assert_eq!(raptorq_encode_file(session_id, input_path_c.as_ptr(), output_dir_c.as_ptr(), 0, result_ptr, 4), -4);
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_unicode_paths() {
+            // Paths are UTF-8 on every platform, Windows gets them as UTF-16
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let dir = temp_dir.path().join("données 日本");
+            fs::create_dir(&dir).unwrap();
+            let original_content: Vec<u8> = (0..3000).map(|i| (i % 199) as u8).collect();
+            let input_path = create_temp_file(&dir, "файл.bin", &original_content)
+                .expect("Failed to create test input file");
+            let symbols_dir = dir.join("符号");
+            let output_path = dir.join("sortie ü.bin");
+            let symbols_dir_c = CString::new(symbols_dir.to_str().unwrap()).unwrap();
+
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let result = raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_str().unwrap()).unwrap().as_ptr(),
+                symbols_dir_c.as_ptr(),
+                1024,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encoding should succeed");
+
+            let result = raptorq_decode_symbols(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                CString::new(output_path.to_str().unwrap()).unwrap().as_ptr(),
+                CString::new(symbols_dir.join("_raptorq_layout.json").to_str().unwrap()).unwrap().as_ptr(),
+            );
+            assert_eq!(result, 0, "Decoding should succeed");
+            assert_eq!(fs::read(&output_path).unwrap(), original_content);
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_check_path() {
+            let mut error_buffer = [0u8; 1024];
+            let check = |path: &[u8], error_buffer: &mut [u8]| {
+                let path = CString::new(path).unwrap();
+                raptorq_check_path(path.as_ptr(), error_buffer.as_mut_ptr() as *mut c_char, error_buffer.len())
+            };
+
+            assert_eq!(check("/data/données 日本/sortie ü.bin".as_bytes(), &mut error_buffer), 0);
+            assert_eq!(buffer_as_string(error_buffer.as_ptr() as *const c_char, error_buffer.len()), "");
+
+            assert_eq!(check(b"/data/\xff\xfe.bin", &mut error_buffer), RAPTORQ_ERR_INVALID_PARAMS);
+            let error = buffer_as_string(error_buffer.as_ptr() as *const c_char, error_buffer.len());
+            assert!(error.contains("not valid UTF-8 from byte 6"), "Unexpected error {}", error);
+
+            let long_path = "a/".repeat(2048);
+            assert_eq!(check(long_path.as_bytes(), &mut error_buffer), RAPTORQ_ERR_INVALID_PATH);
+            let error = buffer_as_string(error_buffer.as_ptr() as *const c_char, error_buffer.len());
+            assert!(error.contains("longer than 4095 bytes"), "Unexpected error {}", error);
+            assert_eq!(check(&[b'a'; 256], &mut error_buffer), RAPTORQ_ERR_INVALID_PATH);
+            assert_eq!(check(b"", &mut error_buffer), RAPTORQ_ERR_INVALID_PARAMS);
+            let error = buffer_as_string(error_buffer.as_ptr() as *const c_char, error_buffer.len());
+            assert!(error.contains("Path is empty"), "Unexpected error {}", error);
+
+            assert_eq!(check(long_path.as_bytes(), &mut error_buffer[..8]), -4, "Small buffer should return -4");
+            let long_path_c = CString::new(long_path.clone()).unwrap();
+            assert_eq!(raptorq_check_path(long_path_c.as_ptr(), ptr::null_mut(), 0), RAPTORQ_ERR_INVALID_PATH);
+            assert_eq!(raptorq_check_path(ptr::null(), error_buffer.as_mut_ptr() as *mut c_char, error_buffer.len()), -2);
+
+            // The operations reject the same paths
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let input_path = create_temp_file(temp_dir.path(), "input.bin", &vec![7u8; 3000])
+                .expect("Failed to create test input file");
+            let input_path_c = CString::new(input_path.to_str().unwrap()).unwrap();
+            let mut result_buffer = vec![0u8; 16384];
+            let result = raptorq_encode_file(
+                session_id,
+                input_path_c.as_ptr(),
+                long_path_c.as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, RAPTORQ_ERR_INVALID_PATH, "Long output path should return -13");
+
+            let invalid_utf8 = CString::new(&b"/data/\xff.bin"[..]).unwrap();
+            let result = raptorq_encode_file(
+                session_id,
+                input_path_c.as_ptr(),
+                invalid_utf8.as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, -2, "Invalid UTF-8 output path should return -2");
+
+            let empty = CString::new("").unwrap();
+            let result = raptorq_encode_file(
+                session_id,
+                input_path_c.as_ptr(),
+                empty.as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, raptorq_check_path(empty.as_ptr(), ptr::null_mut(), 0), "Empty output path should return -2");
+
+            raptorq_free_session(session_id);
+        }
+
+        extern "C" fn test_keep_source(context: *mut c_void, _block_id: usize, _esi: u32, is_repair: bool) -> bool {
+            let calls = unsafe { &mut *(context as *mut u64) };
+            *calls += 1;
+            !is_repair
+        }
+
+        #[test]
+        fn test_ffi_encode_file_with_filter() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let original_content: Vec<u8> = (0..5000).map(|i| (i % 199) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &original_content)
+                .expect("Failed to create test input file");
+            let input_path_c = CString::new(input_path.to_str().unwrap()).unwrap();
+            let symbols_dir = temp_dir.path().join("symbols");
+            let symbols_dir_c = CString::new(symbols_dir.to_str().unwrap()).unwrap();
+            let mut result_buffer = vec![0u8; 64 * 1024];
+
+            // Only the source symbols are written
+            let mut calls = 0u64;
+            let result = raptorq_encode_file_with_filter(
+                session_id,
+                input_path_c.as_ptr(),
+                symbols_dir_c.as_ptr(),
+                2048,
+                Some(test_keep_source),
+                &mut calls as *mut u64 as *mut c_void,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encoding should succeed");
+            let parsed: ProcessResult = serde_json::from_str(&buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len())).unwrap();
+            assert_eq!(parsed.total_repair_symbols, 0);
+            assert!(calls > parsed.total_symbols_count);
+
+            let decoded_path = temp_dir.path().join("decoded.bin");
+            let result = raptorq_decode_symbols(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                CString::new(decoded_path.to_str().unwrap()).unwrap().as_ptr(),
+                CString::new(symbols_dir.join("_raptorq_layout.json").to_str().unwrap()).unwrap().as_ptr(),
+            );
+            assert_eq!(result, 0, "The source symbols should decode");
+            assert_eq!(fs::read(&decoded_path).unwrap(), original_content);
+
+            let result = raptorq_encode_file_with_filter(
+                session_id,
+                input_path_c.as_ptr(),
+                symbols_dir_c.as_ptr(),
+                2048,
+                None,
+                ptr::null_mut(),
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, -2, "A NULL callback should return -2");
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_encode_to_callback() {
+            extern "C" fn collect_symbol(context: *mut c_void, block_id: usize, _esi: u32, _is_repair: bool, buffer: *const u8, buffer_len: usize) -> i32 {
+                let symbols = unsafe { &mut *(context as *mut Vec<(usize, Vec<u8>)>) };
+                symbols.push((block_id, unsafe { std::slice::from_raw_parts(buffer, buffer_len) }.to_vec()));
+                0
+            }
+
+            extern "C" fn stop_after_repair(_context: *mut c_void, _block_id: usize, _esi: u32, is_repair: bool, _buffer: *const u8, _buffer_len: usize) -> i32 {
+                is_repair as i32
+            }
+
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let original_content: Vec<u8> = (0..5000).map(|i| (i % 199) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &original_content)
+                .expect("Failed to create test input file");
+            let input_path_c = CString::new(input_path.to_str().unwrap()).unwrap();
+            let mut result_buffer = vec![0u8; 64 * 1024];
+
+            let mut symbols: Vec<(usize, Vec<u8>)> = Vec::new();
+            let result = raptorq_encode_to_callback(
+                session_id,
+                input_path_c.as_ptr(),
+                2048,
+                Some(collect_symbol),
+                &mut symbols as *mut Vec<(usize, Vec<u8>)> as *mut c_void,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encoding should succeed");
+            let parsed: ProcessResult = serde_json::from_str(&buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len())).unwrap();
+            assert_eq!(symbols.len() as u64, parsed.total_symbols_count);
+
+            // The symbols are the ones encode_file writes, in the order of the layout
+            let symbols_dir = temp_dir.path().join("symbols");
+            let encode_result = raptorq_encode_file(
+                session_id,
+                input_path_c.as_ptr(),
+                CString::new(symbols_dir.to_str().unwrap()).unwrap().as_ptr(),
+                2048,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(encode_result, 0, "Encoding should succeed");
+            let layout: serde_json::Value = serde_json::from_slice(&fs::read(symbols_dir.join("_raptorq_layout.json")).unwrap()).unwrap();
+            assert_eq!(parsed.layout_content.as_deref().map(|c| serde_json::from_str::<serde_json::Value>(c).unwrap()), Some(layout.clone()));
+            let mut expected = Vec::new();
+            for block in layout["blocks"].as_array().unwrap() {
+                let block_id = block["block_id"].as_u64().unwrap() as usize;
+                for symbol_id in block["symbols"].as_array().unwrap() {
+                    let path = symbols_dir.join(format!("block_{}", block_id)).join(symbol_id.as_str().unwrap());
+                    expected.push((block_id, fs::read(path).unwrap()));
+                }
+            }
+            assert_eq!(symbols, expected);
+
+            // A callback that stops the encode cancels it
+            let result = raptorq_encode_to_callback(
+                session_id,
+                input_path_c.as_ptr(),
+                2048,
+                Some(stop_after_repair),
+                ptr::null_mut(),
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, -19, "A stopped encode should return -19");
+
+            let result = raptorq_encode_to_callback(
+                session_id,
+                input_path_c.as_ptr(),
+                2048,
+                None,
+                ptr::null_mut(),
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, -2, "A NULL callback should return -2");
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_encode_file_alloc() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let original_content: Vec<u8> = (0..300 * 1024).map(|i| (i % 251) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &original_content)
+                .expect("Failed to create test input file");
+            let input_path_c = CString::new(input_path.to_str().unwrap()).unwrap();
+            let symbols_dir = temp_dir.path().join("symbols");
+            let symbols_dir_c = CString::new(symbols_dir.to_str().unwrap()).unwrap();
+
+            // Hundreds of blocks don't fit in a 4KB result
+            let mut small_buffer = [0u8; 4096];
+            let result = raptorq_encode_file(
+                session_id,
+                input_path_c.as_ptr(),
+                symbols_dir_c.as_ptr(),
+                1024,
+                small_buffer.as_mut_ptr() as *mut c_char,
+                small_buffer.len(),
+            );
+            assert_eq!(result, -4);
+
+            let mut result_buffer: *mut u8 = ptr::null_mut();
+            let mut result_len: usize = 0;
+            let result = raptorq_encode_file_alloc(
+                session_id,
+                input_path_c.as_ptr(),
+                symbols_dir_c.as_ptr(),
+                1024,
+                &mut result_buffer,
+                &mut result_len,
+            );
+            assert_eq!(result, 0, "Encoding should succeed");
+            assert!(result_len > small_buffer.len());
+
+            let result_json = unsafe { CStr::from_ptr(result_buffer as *const c_char) }.to_str().unwrap();
+            assert_eq!(result_json.len() + 1, result_len);
+            let parsed: ProcessResult = serde_json::from_str(result_json).expect("The full result should parse");
+            assert_eq!(parsed.blocks.unwrap().len(), 300);
+            raptorq_free_buffer(result_buffer, result_len);
+
+            let decoded_path = temp_dir.path().join("decoded.bin");
+            let result = raptorq_decode_symbols(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                CString::new(decoded_path.to_str().unwrap()).unwrap().as_ptr(),
+                CString::new(symbols_dir.join("_raptorq_layout.json").to_str().unwrap()).unwrap().as_ptr(),
+            );
+            assert_eq!(result, 0);
+            assert_eq!(fs::read(&decoded_path).unwrap(), original_content);
+
+            // Nothing is allocated on error
+            let missing_c = CString::new(temp_dir.path().join("missing.bin").to_str().unwrap()).unwrap();
+            let result = raptorq_encode_file_alloc(session_id, missing_c.as_ptr(), symbols_dir_c.as_ptr(), 0, &mut result_buffer, &mut result_len);
+            assert_eq!(result, -12);
+            assert!(result_buffer.is_null());
+            assert_eq!(result_len, 0);
+            assert_eq!(raptorq_encode_file_alloc(session_id, input_path_c.as_ptr(), symbols_dir_c.as_ptr(), 0, ptr::null_mut(), &mut result_len), -2);
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_get_last_error_detail() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let mut error_buffer = [0u8; 1024];
+            let error_ptr = error_buffer.as_mut_ptr() as *mut c_char;
+            let mut code = -100;
+
+            assert_eq!(raptorq_get_last_error_detail(session_id, &mut code, error_ptr, error_buffer.len()), 0);
+            assert_eq!(code, 0, "No operation failed yet");
+            assert!(buffer_as_string(error_ptr, error_buffer.len()).is_empty());
+
+            let mut result_buffer = [0u8; 1024];
+            let result = raptorq_encode_file(
+                session_id,
+                CString::new(temp_dir.path().join("nonexistent.txt").to_str().unwrap()).unwrap().as_ptr(),
+                CString::new(temp_dir.path().join("output").to_str().unwrap()).unwrap().as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, RAPTORQ_ERR_FILE_NOT_FOUND);
+
+            // Failures of the call itself are not recorded
+            assert_eq!(raptorq_decode_symbols(session_id, ptr::null(), ptr::null(), ptr::null()), -2);
+
+            assert_eq!(raptorq_get_last_error_detail(session_id, &mut code, error_ptr, error_buffer.len()), 0);
+            assert_eq!(code, RAPTORQ_ERR_FILE_NOT_FOUND);
+            assert!(buffer_as_string(error_ptr, error_buffer.len()).contains("nonexistent.txt"));
+
+            assert_eq!(raptorq_get_last_error_detail(session_id, ptr::null_mut(), error_ptr, error_buffer.len()), 0);
+            assert_eq!(raptorq_get_last_error_detail(session_id, &mut code, error_ptr, 4), -4, "Small buffer should return -4");
+            assert_eq!(raptorq_get_last_error_detail(session_id, &mut code, ptr::null_mut(), 0), -2);
+
+            assert_eq!(raptorq_reset_session(session_id), 0);
+            assert_eq!(raptorq_get_last_error_detail(session_id, &mut code, error_ptr, error_buffer.len()), 0);
+            assert_eq!(code, 0, "Reset clears the code");
+
+            raptorq_free_session(session_id);
+
+            assert_eq!(raptorq_get_last_error_detail(session_id, &mut code, error_ptr, error_buffer.len()), -5);
+        }
+
+        #[test]
+        fn test_ffi_get_error_buffer_too_small() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            
+            // Trigger an error first (file not found)
+            let input_path = temp_dir.path().join("nonexistent.txt");
+            let output_dir = temp_dir.path().join("output");
+            fs::create_dir_all(&output_dir).expect("Failed to create output directory");
+            
+            let mut result_buffer = [0u8; 1024];
+            
+            raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                CString::new(output_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            
+            // Now get the error message with a small buffer
+            let mut small_error_buffer = [0u8; 5]; // Very small buffer
+            
+            let result = raptorq_get_last_error(
+                session_id,
+                small_error_buffer.as_mut_ptr() as *mut c_char,
+                small_error_buffer.len(),
+            );
+            
+            assert_eq!(result, 0, "Get error should return 0 even with small buffer");
+            
+            // Verify buffer contains truncated, null-terminated message
+            let error_msg = buffer_as_string(small_error_buffer.as_ptr() as *const c_char, small_error_buffer.len());
+            assert!(error_msg.len() < 5, "Error should be truncated to buffer length");
+            
+            // Clean up
+            raptorq_free_session(session_id);
+        }
+        
+        // Tests for raptorq_encode_bytes
+        #[test]
+        fn test_ffi_encode_bytes_success() {
+            let session_id = init_test_session();
+            let data = vec![3u8; 10 * 1024];
+
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let mut symbols_ptr: *mut u8 = ptr::null_mut();
+            let mut symbols_len: usize = 0;
+            let result = raptorq_encode_bytes(
+                session_id,
+                data.as_ptr(),
+                data.len(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+                &mut symbols_ptr,
+                &mut symbols_len,
+            );
+            assert_eq!(result, 0, "Encoding should succeed");
+            assert!(!symbols_ptr.is_null());
+
+            let result_json = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
+            let process_result: serde_json::Value = serde_json::from_str(&result_json).unwrap();
+            let total_symbols = process_result["total_symbols_count"].as_u64().unwrap();
+
+            // Unpack the length-prefixed symbols
+            let packed = unsafe { std::slice::from_raw_parts(symbols_ptr, symbols_len) };
+            let mut offset = 0;
+            let mut count = 0;
+            while offset < packed.len() {
+                let len = u32::from_le_bytes(packed[offset..offset + 4].try_into().unwrap()) as usize;
+                offset += 4 + len;
+                count += 1;
+            }
+            assert_eq!(offset, packed.len());
+            assert_eq!(count, total_symbols);
+
+            raptorq_free_buffer(symbols_ptr, symbols_len);
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_encode_bytes_buffer_too_small() {
+            let session_id = init_test_session();
+            let data = vec![3u8; 10 * 1024];
+
+            let mut result_buffer = [0u8; 8];
+            let mut symbols_ptr: *mut u8 = ptr::null_mut();
+            let mut symbols_len: usize = 0;
+            let result = raptorq_encode_bytes(
+                session_id,
+                data.as_ptr(),
+                data.len(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+                &mut symbols_ptr,
+                &mut symbols_len,
+            );
+            assert_eq!(result, -4, "Small result buffer should return -4");
+
+            // Nothing to free on failure
+            assert!(symbols_ptr.is_null());
+            assert_eq!(symbols_len, 0);
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_encode_bytes_invalid_params() {
+            let session_id = init_test_session();
+            let mut result_buffer = [0u8; 1024];
+            let mut symbols_ptr: *mut u8 = ptr::null_mut();
+            let mut symbols_len: usize = 0;
+
+            let result = raptorq_encode_bytes(
+                session_id,
+                ptr::null(),
+                0,
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+                &mut symbols_ptr,
+                &mut symbols_len,
+            );
+            assert_eq!(result, -2, "Null data should return -2");
+
+            let data = [1u8; 16];
+            let result = raptorq_encode_bytes(
+                session_id,
+                data.as_ptr(),
+                data.len(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+                ptr::null_mut(),
+                &mut symbols_len,
+            );
+            assert_eq!(result, -2, "Null symbols buffer should return -2");
+
+            // Freeing NULL is a no-op
+            raptorq_free_buffer(ptr::null_mut(), 0);
+
+            raptorq_free_session(session_id);
+        }
+
+        // Tests for raptorq_decode_symbols
+        #[test]
+        fn test_ffi_decode_null_pointers() {
+            let session_id = init_test_session();
+            
+            // Test with null symbols_dir
+            let result = raptorq_decode_symbols(
+                session_id,
+                ptr::null(),
+                CString::new("output.txt").unwrap().as_ptr(),
+                CString::new("layout.json").unwrap().as_ptr(),
+            );
+            assert_eq!(result, -2, "Null symbols_dir should return -2");
+            
+            // Test with null output_path
+            let result = raptorq_decode_symbols(
+                session_id,
+                CString::new("symbols").unwrap().as_ptr(),
+                ptr::null(),
+                CString::new("layout.json").unwrap().as_ptr(),
+            );
+            assert_eq!(result, -2, "Null output_path should return -2");
+            
+            // Test with null layout_path
+            let result = raptorq_decode_symbols(
+                session_id,
+                CString::new("symbols").unwrap().as_ptr(),
+                CString::new("output.txt").unwrap().as_ptr(),
+                ptr::null(),
+            );
+            assert_eq!(result, -2, "Null layout_path should return -2");
+            
+            // Clean up
+            raptorq_free_session(session_id);
+        }
+    
+        #[test]
+        fn test_ffi_decode_invalid_session() {
+            let invalid_session_id = 99999;
+            
+            let result = raptorq_decode_symbols(
+                invalid_session_id,
+                CString::new("symbols").unwrap().as_ptr(),
+                CString::new("output.txt").unwrap().as_ptr(),
+                CString::new("layout.json").unwrap().as_ptr(),
+            );
+            
+            assert_eq!(result, -5, "Invalid session ID should return -5");
+        }
+    
+        #[test]
+        fn test_ffi_decode_layout_file_not_found() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            
+            // Valid symbols directory but non-existent layout file
+            let symbols_dir = temp_dir.path();
+            let output_path = temp_dir.path().join("output.txt");
+            let layout_path = temp_dir.path().join("nonexistent_layout.json");
+            
+            let result = raptorq_decode_symbols(
+                session_id,
+                CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                CString::new(output_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                CString::new(layout_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+            );
+            
+            // Should return -2 for file not found or -3 for decoding failed
+            assert!(result == -12, "Layout file not found should return -12");
+            
+            // Clean up
+            raptorq_free_session(session_id);
+        }
+    
+        #[test]
+        fn test_ffi_decode_success() {
+            // This test would typically require:
+            // 1. First encoding a file to generate symbols
+            // 2. Then decoding those symbols back to a file
+            // 3. Verifying the output file matches the input
+            
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            
+            // Create original content for comparison later
+            let original_content = b"This is test content for the RaptorQ encoding/decoding test";
+            let input_path = create_temp_file(
+                temp_dir.path(),
+                "original.txt",
+                original_content,
+            ).expect("Failed to create test input file");
+            
+            // Set up directories
+            let symbols_dir = temp_dir.path().join("symbols");
+            fs::create_dir_all(&symbols_dir).expect("Failed to create symbols directory");
+            let output_path = temp_dir.path().join("decoded.txt");
+            
+            // Encode the file to generate symbols
+            let mut result_buffer = [0u8; 1024];
+            
+            let encode_result = raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            
+            if encode_result == 0 {
+                // Encoding succeeded, now try decoding
+                
+                // Get the path to the layout file (should be in symbols_dir)
+                let layout_path = symbols_dir.join("_raptorq_layout.json");
+                assert!(layout_path.exists(), "Layout file should exist after encoding");
+                
+                let decode_result = raptorq_decode_symbols(
+                    session_id,
+                    CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                    CString::new(output_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                    CString::new(layout_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                );
+                
+                // Ideally, this would return 0 (success)
+                // But since we're using placeholder encoder params, it might fail
+                // The important part is exercising the code path
+                
+                if decode_result == 0 {
+                    // If it succeeded, verify the output file matches the input
+                    let decoded_content = fs::read(&output_path).expect("Failed to read decoded file");
+                    assert_eq!(decoded_content, original_content, "Decoded content should match original");
+                }
+            }
+            
+            // Clean up
+            raptorq_free_session(session_id);
+        }
+    
+        #[test]
+        fn test_ffi_decode_symbols_verified() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+
+            let original_content: Vec<u8> = (0..3000).map(|i| (i % 251) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &original_content)
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+            let output_path = temp_dir.path().join("decoded.bin");
+            let symbols_dir_c = CString::new(symbols_dir.to_str().unwrap()).unwrap();
+
+            let mut result_buffer = [0u8; 4096];
+            let result = raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_str().unwrap()).unwrap().as_ptr(),
+                symbols_dir_c.as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encode should succeed");
+
+            // Corrupt the first symbol of the layout
+            let layout_path = symbols_dir.join("_raptorq_layout.json");
+            let layout: serde_json::Value = serde_json::from_slice(&fs::read(&layout_path).unwrap()).unwrap();
+            let symbol_id = layout["blocks"][0]["symbols"][0].as_str().unwrap().to_string();
+            let symbol_path = symbols_dir.join("block_0").join(&symbol_id);
+            let mut symbol = fs::read(&symbol_path).unwrap();
+            symbol[4] ^= 0xff;
+            fs::write(&symbol_path, &symbol).unwrap();
+
+            let result = raptorq_decode_symbols_verified(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                CString::new(output_path.to_str().unwrap()).unwrap().as_ptr(),
+                CString::new(layout_path.to_str().unwrap()).unwrap().as_ptr(),
+            );
+            assert_eq!(result, 0, "Decode should succeed without the corrupt symbol");
+            assert_eq!(fs::read(&output_path).unwrap(), original_content);
+
+            let mut corrupt_buffer = [0u8; 1024];
+            let result = raptorq_get_last_corrupt_symbols(
+                session_id,
+                corrupt_buffer.as_mut_ptr() as *mut c_char,
+                corrupt_buffer.len(),
+            );
+            assert_eq!(result, 0);
+            let corrupt_json = buffer_as_string(corrupt_buffer.as_ptr() as *const c_char, corrupt_buffer.len());
+            let corrupt: Vec<CorruptSymbol> = serde_json::from_str(&corrupt_json).unwrap();
+            assert_eq!(corrupt, vec![CorruptSymbol { block_id: 0, symbol_id }]);
+
+            raptorq_free_session(session_id);
+
+            let result = raptorq_get_last_corrupt_symbols(
+                session_id,
+                corrupt_buffer.as_mut_ptr() as *mut c_char,
+                corrupt_buffer.len(),
+            );
+            assert_eq!(result, -5, "Invalid session should return -5");
+        }
+
+        #[test]
+        fn test_ffi_decode_symbols_parallel() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+
+            let original_content: Vec<u8> = (0..20000).map(|i| (i * 31 / 7 % 251) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &original_content)
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+            let output_path = temp_dir.path().join("decoded.bin");
+            let symbols_dir_c = CString::new(symbols_dir.to_str().unwrap()).unwrap();
+            let output_path_c = CString::new(output_path.to_str().unwrap()).unwrap();
+
+            let mut result_buffer = [0u8; 4096];
+            let result = raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_str().unwrap()).unwrap().as_ptr(),
+                symbols_dir_c.as_ptr(),
+                4096,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encode should succeed");
+
+            let layout_path_c = CString::new(symbols_dir.join("_raptorq_layout.json").to_str().unwrap()).unwrap();
+            let result = raptorq_decode_symbols_parallel(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                output_path_c.as_ptr(),
+                layout_path_c.as_ptr(),
+            );
+            assert_eq!(result, 0, "Parallel decode should succeed");
+            assert_eq!(fs::read(&output_path).unwrap(), original_content);
+
+            let result = raptorq_decode_symbols_parallel(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                std::ptr::null(),
+                layout_path_c.as_ptr(),
+            );
+            assert_eq!(result, -2, "Null output_path should return -2");
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_decode_symbols_of_another_object() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+
+            let original_content: Vec<u8> = (0..20000).map(|i| (i * 31 / 7 % 251) as u8).collect();
+            let other_content: Vec<u8> = original_content.iter().rev().cloned().collect();
+            let mut symbols_dirs = Vec::new();
+            for (name, content) in [("original", &original_content), ("other", &other_content)] {
+                let input_path = create_temp_file(temp_dir.path(), &format!("{}.bin", name), content)
+                    .expect("Failed to create test input file");
+                let symbols_dir = temp_dir.path().join(name);
+                let mut result_buffer = [0u8; 4096];
+                let result = raptorq_encode_file(
+                    session_id,
+                    CString::new(input_path.to_str().unwrap()).unwrap().as_ptr(),
+                    CString::new(symbols_dir.to_str().unwrap()).unwrap().as_ptr(),
+                    4096,
+                    result_buffer.as_mut_ptr() as *mut c_char,
+                    result_buffer.len(),
+                );
+                assert_eq!(result, 0, "Encode should succeed");
+                symbols_dirs.push(symbols_dir);
+            }
+
+            let output_path_c = CString::new(temp_dir.path().join("decoded.bin").to_str().unwrap()).unwrap();
+            let layout_path_c = CString::new(symbols_dirs[0].join("_raptorq_layout.json").to_str().unwrap()).unwrap();
+            let result = raptorq_decode_symbols(
+                session_id,
+                CString::new(symbols_dirs[1].to_str().unwrap()).unwrap().as_ptr(),
+                output_path_c.as_ptr(),
+                layout_path_c.as_ptr(),
+            );
+            assert_eq!(result, RAPTORQ_ERR_OBJECT_MISMATCH, "Symbols of another object should return -22");
+
+            let result = raptorq_decode_symbols(
+                session_id,
+                CString::new(symbols_dirs[0].to_str().unwrap()).unwrap().as_ptr(),
+                output_path_c.as_ptr(),
+                layout_path_c.as_ptr(),
+            );
+            assert_eq!(result, 0, "Decode should succeed");
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_decode_from_dirs() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+
+            let original_content: Vec<u8> = (0..20000).map(|i| (i * 31 / 7 % 251) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &original_content)
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+            let other_dir = temp_dir.path().join("other");
+            fs::create_dir_all(&other_dir).unwrap();
+            let output_path = temp_dir.path().join("decoded.bin");
+            let output_path_c = CString::new(output_path.to_str().unwrap()).unwrap();
+
+            let mut result_buffer = [0u8; 4096];
+            let result = raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_str().unwrap()).unwrap().as_ptr(),
+                CString::new(symbols_dir.to_str().unwrap()).unwrap().as_ptr(),
+                4096,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encode should succeed");
+
+            // The symbols of the first block are moved to the other directory
+            for entry in fs::read_dir(symbols_dir.join("block_0")).unwrap() {
+                let path = entry.unwrap().path();
+                fs::rename(&path, other_dir.join(path.file_name().unwrap())).unwrap();
+            }
+
+            let layout_path_c = CString::new(symbols_dir.join("_raptorq_layout.json").to_str().unwrap()).unwrap();
+            let symbols_dirs_json = serde_json::to_string(&[symbols_dir.to_str().unwrap(), other_dir.to_str().unwrap()]).unwrap();
+            let result = raptorq_decode_from_dirs(
+                session_id,
+                CString::new(symbols_dirs_json).unwrap().as_ptr(),
+                output_path_c.as_ptr(),
+                layout_path_c.as_ptr(),
+            );
+            assert_eq!(result, 0, "Decode should succeed");
+            assert_eq!(fs::read(&output_path).unwrap(), original_content);
+
+            let result = raptorq_decode_from_dirs(
+                session_id,
+                CString::new("[]").unwrap().as_ptr(),
+                output_path_c.as_ptr(),
+                layout_path_c.as_ptr(),
+            );
+            assert_eq!(result, -2, "No directory should return -2");
+
+            let result = raptorq_decode_from_dirs(
+                session_id,
+                CString::new("not json").unwrap().as_ptr(),
+                output_path_c.as_ptr(),
+                layout_path_c.as_ptr(),
+            );
+            assert_eq!(result, -2, "Malformed directories should return -2");
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_decode_from_files() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+
+            let original_content: Vec<u8> = (0..20000).map(|i| (i * 31 / 7 % 251) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &original_content)
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+            let output_path = temp_dir.path().join("decoded.bin");
+            let output_path_c = CString::new(output_path.to_str().unwrap()).unwrap();
+
+            let mut result_buffer = [0u8; 4096];
+            let result = raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_str().unwrap()).unwrap().as_ptr(),
+                CString::new(symbols_dir.to_str().unwrap()).unwrap().as_ptr(),
+                4096,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encode should succeed");
+
+            let layout_path = symbols_dir.join("_raptorq_layout.json");
+            let layout_path_c = CString::new(layout_path.to_str().unwrap()).unwrap();
+            let layout: serde_json::Value = serde_json::from_slice(&fs::read(&layout_path).unwrap()).unwrap();
+            let mut symbol_paths = Vec::new();
+            for block in layout["blocks"].as_array().unwrap() {
+                let block_id = block["block_id"].as_u64().unwrap();
+                for symbol_id in block["symbols"].as_array().unwrap() {
+                    symbol_paths.push(symbols_dir.join(format!("block_{}", block_id)).join(symbol_id.as_str().unwrap()));
+                }
+            }
+
+            let symbol_paths_json = serde_json::to_string(&symbol_paths).unwrap();
+            let result = raptorq_decode_from_files(
+                session_id,
+                CString::new(symbol_paths_json).unwrap().as_ptr(),
+                output_path_c.as_ptr(),
+                layout_path_c.as_ptr(),
+            );
+            assert_eq!(result, 0, "Decode should succeed");
+            assert_eq!(fs::read(&output_path).unwrap(), original_content);
+
+            let result = raptorq_decode_from_files(
+                session_id,
+                CString::new("[]").unwrap().as_ptr(),
+                output_path_c.as_ptr(),
+                layout_path_c.as_ptr(),
+            );
+            assert_eq!(result, -18, "No symbol should return -18");
+
+            let result = raptorq_decode_from_files(
+                session_id,
+                CString::new("not json").unwrap().as_ptr(),
+                output_path_c.as_ptr(),
+                layout_path_c.as_ptr(),
+            );
+            assert_eq!(result, -2, "Malformed paths should return -2");
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_decode_symbols_checked() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+
+            let original_content: Vec<u8> = (0..3000).map(|i| (i % 251) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &original_content)
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+            let output_path = temp_dir.path().join("decoded.bin");
+            let symbols_dir_c = CString::new(symbols_dir.to_str().unwrap()).unwrap();
+            let output_path_c = CString::new(output_path.to_str().unwrap()).unwrap();
+
+            let mut result_buffer = [0u8; 4096];
+            let result = raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_str().unwrap()).unwrap().as_ptr(),
+                symbols_dir_c.as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encode should succeed");
+
+            let layout_path = symbols_dir.join("_raptorq_layout.json");
+            let layout_path_c = CString::new(layout_path.to_str().unwrap()).unwrap();
+            let result = raptorq_decode_symbols_checked(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                output_path_c.as_ptr(),
+                layout_path_c.as_ptr(),
+            );
+            assert_eq!(result, 0, "Checked decode should succeed");
+            assert_eq!(fs::read(&output_path).unwrap(), original_content);
+
+            // A layout recording another object hash
+            let mut layout: serde_json::Value = serde_json::from_slice(&fs::read(&layout_path).unwrap()).unwrap();
+            layout["object_sha256"] = serde_json::Value::String("00".repeat(32));
+            fs::write(&layout_path, serde_json::to_vec(&layout).unwrap()).unwrap();
+            let result = raptorq_decode_symbols_checked(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                output_path_c.as_ptr(),
+                layout_path_c.as_ptr(),
+            );
+            assert_eq!(result, -15, "Hash mismatch should return -15");
+
+            let result = raptorq_decode_symbols_checked(
+                session_id,
+                std::ptr::null(),
+                output_path_c.as_ptr(),
+                layout_path_c.as_ptr(),
+            );
+            assert_eq!(result, -2, "Null symbols_dir should return -2");
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_decode_from_tar() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+
+            let original_content: Vec<u8> = (0..3000).map(|i| (i % 251) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &original_content)
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+            let output_path = temp_dir.path().join("decoded.bin");
+            let output_path_c = CString::new(output_path.to_str().unwrap()).unwrap();
+
+            let mut result_buffer = [0u8; 4096];
+            let result = raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_str().unwrap()).unwrap().as_ptr(),
+                CString::new(symbols_dir.to_str().unwrap()).unwrap().as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encode should succeed");
+
+            // Same as `tar -cf symbols.tar -C symbols .`
+            let tar_path = temp_dir.path().join("symbols.tar");
+            let mut builder = tar::Builder::new(fs::File::create(&tar_path).unwrap());
+            builder.append_dir_all(".", &symbols_dir).unwrap();
+            builder.finish().unwrap();
+            let tar_path_c = CString::new(tar_path.to_str().unwrap()).unwrap();
+
+            let result = raptorq_decode_from_tar(session_id, tar_path_c.as_ptr(), output_path_c.as_ptr(), ptr::null());
+            assert_eq!(result, 0, "Decode should succeed with the layout of the archive");
+            assert_eq!(fs::read(&output_path).unwrap(), original_content);
+
+            let missing_path_c = CString::new(temp_dir.path().join("missing.tar").to_str().unwrap()).unwrap();
+            let result = raptorq_decode_from_tar(session_id, missing_path_c.as_ptr(), output_path_c.as_ptr(), ptr::null());
+            assert_eq!(result, -12, "Missing archive should return -12");
+
+            let result = raptorq_decode_from_tar(session_id, ptr::null(), output_path_c.as_ptr(), ptr::null());
+            assert_eq!(result, -2, "Null archive path should return -2");
+
+            raptorq_free_session(session_id);
+
+            let result = raptorq_decode_from_tar(session_id, tar_path_c.as_ptr(), output_path_c.as_ptr(), ptr::null());
+            assert_eq!(result, -5, "Invalid session should return -5");
+        }
+
+        #[test]
+        fn test_ffi_encode_block() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+
+            let original_content: Vec<u8> = (0..5000).map(|i| (i % 199) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &original_content)
+                .expect("Failed to create test input file");
+            let input_path_c = CString::new(input_path.to_str().unwrap()).unwrap();
+            let symbols_dir = temp_dir.path().join("symbols");
+            let symbols_dir_c = CString::new(symbols_dir.to_str().unwrap()).unwrap();
+
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let result = raptorq_encode_file(
+                session_id,
+                input_path_c.as_ptr(),
+                symbols_dir_c.as_ptr(),
+                2048,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encode should succeed");
+            let layout_path_c = CString::new(symbols_dir.join("_raptorq_layout.json").to_str().unwrap()).unwrap();
+
+            fs::remove_dir_all(symbols_dir.join("block_1")).unwrap();
+            let result = raptorq_encode_block(
+                session_id,
+                input_path_c.as_ptr(),
+                symbols_dir_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                1,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encoding the block should succeed");
+            let result_json = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
+            let process_result: ProcessResult = serde_json::from_str(&result_json).unwrap();
+            let blocks = process_result.blocks.unwrap();
+            assert_eq!(blocks.len(), 1);
+            assert_eq!(blocks[0].block_id, 1);
+            assert_eq!(raptorq_can_decode(session_id, symbols_dir_c.as_ptr(), layout_path_c.as_ptr()), 1);
+
+            let result = raptorq_encode_block(
+                session_id,
+                input_path_c.as_ptr(),
+                symbols_dir_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                7,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, -14, "Unknown block should return -14");
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_decode_and_repair() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+
+            let original_content: Vec<u8> = (0..20000).map(|i| (i * 31 / 7 % 251) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &original_content)
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+            let symbols_dir_c = CString::new(symbols_dir.to_str().unwrap()).unwrap();
+            let output_path = temp_dir.path().join("decoded.bin");
+            let output_path_c = CString::new(output_path.to_str().unwrap()).unwrap();
+
+            let mut result_buffer = [0u8; 4096];
+            let result = raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_str().unwrap()).unwrap().as_ptr(),
+                symbols_dir_c.as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encode should succeed");
+
+            let layout_path = symbols_dir.join("_raptorq_layout.json");
+            let layout_path_c = CString::new(layout_path.to_str().unwrap()).unwrap();
+            let layout = RaptorQLayout::read_file(layout_path.to_str().unwrap()).unwrap();
+            let lost = symbols_dir.join("block_0").join(&layout.blocks[0].symbols[0]);
+            fs::remove_file(&lost).unwrap();
+
+            let mut repaired = 0u64;
+            let result = raptorq_decode_and_repair(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                output_path_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                &mut repaired,
+            );
+            assert_eq!(result, 0, "Decode should succeed");
+            assert_eq!(repaired, 1);
+            assert!(lost.exists());
+            assert_eq!(fs::read(&output_path).unwrap(), original_content);
+
+            let result = raptorq_decode_and_repair(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                output_path_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                ptr::null_mut(),
+            );
+            assert_eq!(result, -2, "Null count should return -2");
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_generate_repair_symbols() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+
+            let original_content: Vec<u8> = (0..5000).map(|i| (i % 199) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &original_content)
+                .expect("Failed to create test input file");
+            let input_path_c = CString::new(input_path.to_str().unwrap()).unwrap();
+            let symbols_dir = temp_dir.path().join("symbols");
+            let symbols_dir_c = CString::new(symbols_dir.to_str().unwrap()).unwrap();
+
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let result = raptorq_encode_file(
+                session_id,
+                input_path_c.as_ptr(),
+                symbols_dir_c.as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encode should succeed");
+            let layout_path = symbols_dir.join("_raptorq_layout.json");
+            let layout_path_c = CString::new(layout_path.to_str().unwrap()).unwrap();
+
+            let result = raptorq_generate_repair_symbols(
+                session_id,
+                input_path_c.as_ptr(),
+                symbols_dir_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                0,
+                4,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Generating repair symbols should succeed");
+            let ids_json = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
+            let ids: Vec<String> = serde_json::from_str(&ids_json).unwrap();
+            assert_eq!(ids.len(), 4);
+
+            let layout: serde_json::Value = serde_json::from_slice(&fs::read(&layout_path).unwrap()).unwrap();
+            let layout_ids: Vec<&str> = layout["blocks"][0]["symbols"].as_array().unwrap()
+                .iter()
+                .map(|id| id.as_str().unwrap())
+                .collect();
+            assert_eq!(&layout_ids[layout_ids.len() - 4..], ids.iter().map(String::as_str).collect::<Vec<_>>().as_slice());
+            for id in &ids {
+                assert!(symbols_dir.join("block_0").join(id).exists());
+            }
+
+            let result = raptorq_generate_repair_symbols(
+                session_id,
+                input_path_c.as_ptr(),
+                symbols_dir_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                3,
+                4,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, -14, "Unknown block should return -14");
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_get_symbol() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+
+            let original_content: Vec<u8> = (0..5000).map(|i| (i % 199) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &original_content)
+                .expect("Failed to create test input file");
+            let input_path_c = CString::new(input_path.to_str().unwrap()).unwrap();
+            let symbols_dir = temp_dir.path().join("symbols");
+            let symbols_dir_c = CString::new(symbols_dir.to_str().unwrap()).unwrap();
+
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let result = raptorq_encode_file(
+                session_id,
+                input_path_c.as_ptr(),
+                symbols_dir_c.as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encode should succeed");
+            let layout_path_c = CString::new(symbols_dir.join("_raptorq_layout.json").to_str().unwrap()).unwrap();
+
+            let mut symbol = vec![0u8; 2048];
+            let mut symbol_len = 0usize;
+            let result = raptorq_get_symbol(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                0,
+                2,
+                ptr::null(),
+                symbol.as_mut_ptr(),
+                symbol.len(),
+                &mut symbol_len,
+            );
+            assert_eq!(result, 0, "Getting a stored symbol should succeed");
+            assert_eq!(symbol_esi(&symbol[..symbol_len]), Some(2));
+
+            // A repair symbol far past the stored ones is generated from the original file
+            let result = raptorq_get_symbol(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                0,
+                1000,
+                input_path_c.as_ptr(),
+                symbol.as_mut_ptr(),
+                symbol.len(),
+                &mut symbol_len,
+            );
+            assert_eq!(result, 0, "Generating a symbol should succeed");
+            assert_eq!(symbol_esi(&symbol[..symbol_len]), Some(1000));
+
+            let result = raptorq_get_symbol(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                0,
+                1000,
+                ptr::null(),
+                symbol.as_mut_ptr(),
+                4,
+                &mut symbol_len,
+            );
+            assert_eq!(result, -4, "Small buffer should return -4");
+            assert!(symbol_len > 4, "The length of the symbol is given with -4");
+
+            let missing_dir_c = CString::new(temp_dir.path().join("missing").to_str().unwrap()).unwrap();
+            let result = raptorq_get_symbol(
+                session_id,
+                missing_dir_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                0,
+                1000,
+                ptr::null(),
+                symbol.as_mut_ptr(),
+                symbol.len(),
+                &mut symbol_len,
+            );
+            assert_eq!(result, -20, "A symbol that can't be generated should return -20");
+            let mut code = 0i32;
+            let mut message = [0u8; 1024];
+            assert_eq!(raptorq_get_last_error_detail(session_id, &mut code, message.as_mut_ptr() as *mut c_char, message.len()), 0);
+            assert_eq!(code, -20);
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_encode_file_to_archive() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+
+            let original_content: Vec<u8> = (0..5000).map(|i| (i % 199) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &original_content)
+                .expect("Failed to create test input file");
+            let archive_path = temp_dir.path().join("object.tar");
+            let output_path = temp_dir.path().join("decoded.bin");
+            let archive_path_c = CString::new(archive_path.to_str().unwrap()).unwrap();
+            let output_path_c = CString::new(output_path.to_str().unwrap()).unwrap();
+
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let result = raptorq_encode_file_to_archive(
+                session_id,
+                CString::new(input_path.to_str().unwrap()).unwrap().as_ptr(),
+                archive_path_c.as_ptr(),
+                2048,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encode should succeed");
+            let result_json = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
+            let process_result: ProcessResult = serde_json::from_str(&result_json).unwrap();
+            assert_eq!(process_result.blocks.unwrap().len(), 3);
+            assert!(archive_path.exists());
+
+            let result = raptorq_decode_from_archive(session_id, archive_path_c.as_ptr(), output_path_c.as_ptr());
+            assert_eq!(result, 0, "Decode should succeed");
+            assert_eq!(fs::read(&output_path).unwrap(), original_content);
+
+            let result = raptorq_decode_from_archive(session_id, ptr::null(), output_path_c.as_ptr());
+            assert_eq!(result, -2, "Null archive path should return -2");
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_symbol_path() {
+            let mut buffer = [0u8; 64];
+            let symbol_id = CString::new("8fXz2b").unwrap();
+            let result = raptorq_get_symbol_path(3, symbol_id.as_ptr(), buffer.as_mut_ptr() as *mut c_char, buffer.len());
+            assert_eq!(result, 0);
+            let path = buffer_as_string(buffer.as_ptr() as *const c_char, buffer.len());
+            assert_eq!(path, "block_3/8fXz2b");
+
+            let result = raptorq_get_symbol_path(3, symbol_id.as_ptr(), buffer.as_mut_ptr() as *mut c_char, 5);
+            assert_eq!(result, -4, "Small buffer should return -4");
+
+            let mut block_id = 0usize;
+            let full_path = CString::new("/data/symbols/block_12/8fXz2b").unwrap();
+            let result = raptorq_parse_symbol_path(full_path.as_ptr(), &mut block_id, buffer.as_mut_ptr() as *mut c_char, buffer.len());
+            assert_eq!(result, 0);
+            assert_eq!(block_id, 12);
+            assert_eq!(buffer_as_string(buffer.as_ptr() as *const c_char, buffer.len()), "8fXz2b");
+
+            let bad_path = CString::new("symbols/8fXz2b").unwrap();
+            let result = raptorq_parse_symbol_path(bad_path.as_ptr(), &mut block_id, buffer.as_mut_ptr() as *mut c_char, buffer.len());
+            assert_eq!(result, -13, "Path outside a block directory should return -13");
+
+            let mut esi = 0u32;
+            let symbol = [0u8, 0x01, 0x02, 0x03, 0xaa, 0xbb];
+            assert_eq!(raptorq_get_symbol_esi(symbol.as_ptr(), symbol.len(), &mut esi), 0);
+            assert_eq!(esi, 0x010203);
+            assert_eq!(raptorq_get_symbol_esi(symbol.as_ptr(), 3, &mut esi), -2, "Short symbol should return -2");
+        }
+
+        #[test]
+        fn test_ffi_set_layout_metadata() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &vec![7u8; 3000])
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+
+            let metadata_c = CString::new(r#"{"object_id":"3f2a","content_type":"text/plain"}"#).unwrap();
+            assert_eq!(raptorq_set_layout_metadata(session_id, metadata_c.as_ptr()), 0);
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let result = raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encoding should succeed");
+
+            let layout_path_c = CString::new(symbols_dir.join("_raptorq_layout.json").to_string_lossy().as_ref()).unwrap();
+            let result_ptr = result_buffer.as_mut_ptr() as *mut c_char;
+            assert_eq!(raptorq_parse_layout(layout_path_c.as_ptr(), result_ptr, result_buffer.len()), 0);
+            let summary: LayoutSummary = serde_json::from_str(&buffer_as_string(result_ptr, result_buffer.len())).unwrap();
+            assert_eq!(summary.metadata.get("object_id").map(String::as_str), Some("3f2a"));
+            assert_eq!(summary.metadata.len(), 2);
+
+            for invalid in [r#"{"size":3}"#, "[]", "not json"] {
+                let invalid_c = CString::new(invalid).unwrap();
+                assert_eq!(raptorq_set_layout_metadata(session_id, invalid_c.as_ptr()), -2, "{} should return -2", invalid);
+            }
+            assert_eq!(raptorq_set_layout_metadata(session_id, ptr::null()), -2);
+            assert_eq!(raptorq_set_layout_metadata(999999, metadata_c.as_ptr()), -5, "Invalid session should return -5");
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_parse_layout() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let original_content: Vec<u8> = (0..5000).map(|i| (i % 199) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &original_content)
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let encode_result = raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                2048,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(encode_result, 0, "Encoding should succeed");
+            // The layout is read without a session
+            raptorq_free_session(session_id);
+
+            let layout_path_c = CString::new(symbols_dir.join("_raptorq_layout.json").to_string_lossy().as_ref()).unwrap();
+            let result_ptr = result_buffer.as_mut_ptr() as *mut c_char;
+            assert_eq!(raptorq_parse_layout(layout_path_c.as_ptr(), result_ptr, result_buffer.len()), 0);
+            let summary: LayoutSummary = serde_json::from_str(&buffer_as_string(result_ptr, result_buffer.len())).unwrap();
+            assert_eq!(summary.total_size, 5000);
+            assert_eq!(summary.blocks.len(), 3);
+            assert_eq!(summary.blocks[2].original_offset, 4096);
+            assert!(summary.blocks.iter().all(|b| b.source_symbols_count + b.repair_symbols_count == b.symbols_count));
+
+            assert_eq!(raptorq_parse_layout(layout_path_c.as_ptr(), result_ptr, 8), -4, "Small buffer should return -4");
+            assert_eq!(raptorq_parse_layout(layout_path_c.as_ptr(), ptr::null_mut(), 0), -2);
+            let missing_c = CString::new(temp_dir.path().join("missing.json").to_string_lossy().as_ref()).unwrap();
+            assert_eq!(raptorq_parse_layout(missing_c.as_ptr(), result_ptr, result_buffer.len()), -12);
+            let invalid_path = create_temp_file(temp_dir.path(), "invalid.json", b"not a layout").unwrap();
+            let invalid_c = CString::new(invalid_path.to_string_lossy().as_ref()).unwrap();
+            assert_eq!(raptorq_parse_layout(invalid_c.as_ptr(), result_ptr, result_buffer.len()), -15);
+        }
+
+        #[test]
+        fn test_ffi_decode_dir() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let data: Vec<u8> = (0..5000).map(|i| (i * 31 / 7 % 251) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &data)
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+            let symbols_dir_c = CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap();
+            let output_path = temp_dir.path().join("decoded.bin");
+            let output_path_c = CString::new(output_path.to_string_lossy().as_ref()).unwrap();
+
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let result = raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                symbols_dir_c.as_ptr(),
+                2048,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encoding should succeed");
+
+            let result = raptorq_decode_dir(session_id, symbols_dir_c.as_ptr(), output_path_c.as_ptr(), ptr::null());
+            assert_eq!(result, 0, "Decoding with the layout of the directory should succeed");
+            assert_eq!(fs::read(&output_path).unwrap(), data);
+
+            let moved_layout = temp_dir.path().join("layout.json");
+            fs::rename(symbols_dir.join("_raptorq_layout.json"), &moved_layout).unwrap();
+            let result = raptorq_decode_dir(session_id, symbols_dir_c.as_ptr(), output_path_c.as_ptr(), ptr::null());
+            assert_eq!(result, -12, "Missing layout should return -12");
+
+            let moved_layout_c = CString::new(moved_layout.to_string_lossy().as_ref()).unwrap();
+            fs::remove_file(&output_path).unwrap();
+            let result = raptorq_decode_dir(session_id, symbols_dir_c.as_ptr(), output_path_c.as_ptr(), moved_layout_c.as_ptr());
+            assert_eq!(result, 0, "Decoding with the given layout should succeed");
+            assert_eq!(fs::read(&output_path).unwrap(), data);
+
+            assert_eq!(raptorq_decode_dir(session_id, ptr::null(), output_path_c.as_ptr(), ptr::null()), -2);
+            assert_eq!(raptorq_decode_dir(999999, symbols_dir_c.as_ptr(), output_path_c.as_ptr(), ptr::null()), -5);
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_migrate_layout() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &vec![3u8; 5000])
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let encode_result = raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                2048,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(encode_result, 0, "Encoding should succeed");
+            raptorq_free_session(session_id);
+
+            let layout_path = symbols_dir.join("_raptorq_layout.json");
+            let layout_path_c = CString::new(layout_path.to_string_lossy().as_ref()).unwrap();
+            let mut layout: serde_json::Value = serde_json::from_slice(&fs::read(&layout_path).unwrap()).unwrap();
+            layout.as_object_mut().unwrap().remove("layout_version");
+            fs::write(&layout_path, layout.to_string()).unwrap();
+
+            let result_ptr = result_buffer.as_mut_ptr() as *mut c_char;
+            assert_eq!(raptorq_parse_layout(layout_path_c.as_ptr(), result_ptr, result_buffer.len()), 0);
+            let summary: LayoutSummary = serde_json::from_str(&buffer_as_string(result_ptr, result_buffer.len())).unwrap();
+            assert_eq!(summary.layout_version, 0);
+
+            assert_eq!(raptorq_migrate_layout(layout_path_c.as_ptr()), 0, "Migrating should succeed");
+            assert_eq!(raptorq_migrate_layout(layout_path_c.as_ptr()), 0, "Migrating again should succeed");
+            assert_eq!(raptorq_parse_layout(layout_path_c.as_ptr(), result_ptr, result_buffer.len()), 0);
+            let summary: LayoutSummary = serde_json::from_str(&buffer_as_string(result_ptr, result_buffer.len())).unwrap();
+            assert_eq!(summary.layout_version, LAYOUT_VERSION);
+
+            layout["layout_version"] = (LAYOUT_VERSION + 1).into();
+            fs::write(&layout_path, layout.to_string()).unwrap();
+            assert_eq!(raptorq_parse_layout(layout_path_c.as_ptr(), result_ptr, result_buffer.len()), -23);
+            assert_eq!(raptorq_migrate_layout(layout_path_c.as_ptr()), -23, "Newer layout should return -23");
+
+            assert_eq!(raptorq_migrate_layout(ptr::null()), -2, "Null path should return -2");
+            let missing_c = CString::new(temp_dir.path().join("missing.json").to_string_lossy().as_ref()).unwrap();
+            assert_eq!(raptorq_migrate_layout(missing_c.as_ptr()), -12);
+        }
+
+        #[test]
+        fn test_ffi_decode_file_not_found() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            
+            // Non-existent symbols directory
+            let symbols_dir = temp_dir.path().join("nonexistent_symbols");
+            let output_path = temp_dir.path().join("decoded.txt");
+            let layout_path = temp_dir.path().join("layout.json");
+            
+            let result = raptorq_decode_symbols(
+                session_id,
+                CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                CString::new(output_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                CString::new(layout_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+            );
+            
+            assert_eq!(result, -12, "File not found should return -12");
+            
+            // Check error message
+            let mut error_buffer = [0u8; 1024];
+            raptorq_get_last_error(
+                session_id,
+                error_buffer.as_mut_ptr() as *mut c_char,
+                error_buffer.len(),
+            );
+            
+            let error_msg = buffer_as_string(error_buffer.as_ptr() as *const c_char, error_buffer.len());
+            assert!(!error_msg.is_empty(), "Error message should not be empty");
+            
+            // Clean up
+            raptorq_free_session(session_id);
+        }
+    
+        #[test]
+        fn test_ffi_decode_decoding_failed() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            
+            // Create an empty symbols directory (which should cause decoding to fail)
+            let symbols_dir = temp_dir.path().join("empty_symbols");
+            fs::create_dir_all(&symbols_dir).expect("Failed to create empty symbols directory");
+            
+            let output_path = temp_dir.path().join("decoded.txt");
+            
+            // Create an empty layout file that won't have valid content
+            let layout_path = temp_dir.path().join("empty_layout.json");
+            File::create(&layout_path).expect("Failed to create empty layout file");
+            
+            let result = raptorq_decode_symbols(
+                session_id,
+                CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                CString::new(output_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                CString::new(layout_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+            );
+            
+            // Expect -3 for decoding failure, but other values are possible depending on implementation
+            if result == -3 {
+                // Check error message
+                let mut error_buffer = [0u8; 1024];
+                raptorq_get_last_error(
+                    session_id,
+                    error_buffer.as_mut_ptr() as *mut c_char,
+                    error_buffer.len(),
+                );
+                
+                let error_msg = buffer_as_string(error_buffer.as_ptr() as *const c_char, error_buffer.len());
+                assert!(!error_msg.is_empty(), "Error message should not be empty");
+            }
+            
+            // Clean up
+            raptorq_free_session(session_id);
+        }
+    
+        #[test]
+        fn test_ffi_decode_insufficient_symbols() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+
+            // 10 source symbols of 1024 bytes in a single block
+            let input_path = create_temp_file(
+                temp_dir.path(),
+                "original.bin",
+                &vec![7u8; 10 * 1024],
+            ).expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+            let output_path = temp_dir.path().join("decoded.bin");
+
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let encode_result = raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(encode_result, 0, "Encoding should succeed");
+
+            // Keep only 3 symbols of the block
+            for path in fs::read_dir(symbols_dir.join("block_0")).unwrap().skip(3) {
+                fs::remove_file(path.unwrap().path()).expect("Failed to remove the symbol file");
+            }
+
+            let layout_path = symbols_dir.join("_raptorq_layout.json");
+            let result = raptorq_decode_symbols(
+                session_id,
+                CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                CString::new(output_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                CString::new(layout_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+            );
+            assert_eq!(result, -18, "Insufficient symbols should return -18");
+
+            let mut shortfalls_buffer = [0u8; 1024];
+            let shortfalls_result = raptorq_get_last_shortfalls(
+                session_id,
+                shortfalls_buffer.as_mut_ptr() as *mut c_char,
+                shortfalls_buffer.len(),
+            );
+            assert_eq!(shortfalls_result, 0);
+
+            let shortfalls_json = buffer_as_string(shortfalls_buffer.as_ptr() as *const c_char, shortfalls_buffer.len());
+            let shortfalls: Vec<BlockShortfall> = serde_json::from_str(&shortfalls_json)
+                .expect("Shortfalls should be a valid JSON array");
+            assert_eq!(shortfalls, vec![BlockShortfall { block_id: 0, present: 3, required: 10 }]);
+
+            // Clean up
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_get_shortfalls_invalid_session() {
+            let mut buffer = [0u8; 64];
+            let result = raptorq_get_last_shortfalls(
+                999999,
+                buffer.as_mut_ptr() as *mut c_char,
+                buffer.len(),
+            );
+            assert_eq!(result, -5, "Invalid session should return -5");
+        }
+
+        #[test]
+        fn test_ffi_decode_path_conversion_error() {
+            let session_id = init_test_session();
+            
+            // Use extremely long path that might fail in CString::new
+            let very_long_path = "a".repeat(100000);
+            
+            let result = raptorq_decode_symbols(
+                session_id,
+                CString::new(very_long_path.clone()).unwrap_or(CString::new("x").unwrap()).as_ptr(),
+                CString::new("output.txt").unwrap().as_ptr(),
+                CString::new("layout.json").unwrap().as_ptr(),
+            );
+            
+            // Expect -1 if path conversion failed, but other values are possible
+            // The important part is to exercise that code path
+            if result == -1 {
+                // Test successful - path conversion failed as expected
+            }
+            
+            // Clean up
+            raptorq_free_session(session_id);
+        }
+        
+        // Tests for raptorq_encode_stream
+        struct TestStream {
+            data: Vec<u8>,
+            position: usize,
+            fail: bool,
+        }
+
+        extern "C" fn test_stream_read(context: *mut c_void, buffer: *mut u8, buffer_len: usize) -> isize {
+            let stream = unsafe { &mut *(context as *mut TestStream) };
+            if stream.fail {
+                return -1;
+            }
+            // Return short reads on purpose
+            let len = buffer_len.min(333).min(stream.data.len() - stream.position);
+            unsafe {
+                ptr::copy_nonoverlapping(stream.data[stream.position..].as_ptr(), buffer, len);
+            }
+            stream.position += len;
+            len as isize
+        }
+
+        #[test]
+        fn test_ffi_encode_stream_success() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let symbols_dir = temp_dir.path().join("symbols");
+
+            let mut stream = TestStream { data: vec![9u8; 5000], position: 0, fail: false };
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let result = raptorq_encode_stream(
+                session_id,
+                Some(test_stream_read),
+                &mut stream as *mut TestStream as *mut c_void,
+                CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                2048,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encoding the stream should succeed");
+            assert_eq!(stream.position, stream.data.len());
+
+            let result_json = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
+            let process_result: ProcessResult = serde_json::from_str(&result_json).unwrap();
+            assert_eq!(process_result.blocks.unwrap().len(), 3);
+            assert!(symbols_dir.join("_raptorq_layout.json").exists());
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_encode_stream_read_error() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let symbols_dir = temp_dir.path().join("symbols");
+
+            let mut stream = TestStream { data: vec![9u8; 5000], position: 0, fail: true };
+            let mut result_buffer = vec![0u8; 1024];
+            let result = raptorq_encode_stream(
+                session_id,
+                Some(test_stream_read),
+                &mut stream as *mut TestStream as *mut c_void,
+                CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, -11, "Read error should return -11");
+
+            let result = raptorq_encode_stream(
+                session_id,
+                None,
+                ptr::null_mut(),
+                CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, -2, "Missing callback should return -2");
+
+            raptorq_free_session(session_id);
+        }
+
+        extern "C" fn test_read_at(context: *mut c_void, offset: u64, buffer: *mut u8, buffer_len: usize) -> isize {
+            let data = unsafe { &*(context as *const Vec<u8>) };
+            let buffer = unsafe { std::slice::from_raw_parts_mut(buffer, buffer_len) };
+            match data.read_at(offset, buffer) {
+                Ok(read) => read as isize,
+                Err(_) => -1,
+            }
+        }
+
+        #[test]
+        fn test_ffi_encode_read_at() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let symbols_dir = temp_dir.path().join("symbols");
+            let symbols_dir_c = CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap();
+
+            let data: Vec<u8> = (0..5000).map(|i| (i * 31 / 7 % 251) as u8).collect();
+            let context = &data as *const Vec<u8> as *mut c_void;
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let result = raptorq_encode_read_at(
+                session_id,
+                Some(test_read_at),
+                context,
+                data.len() as u64,
+                symbols_dir_c.as_ptr(),
+                2048,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encoding should succeed");
+
+            let result_json = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
+            let process_result: ProcessResult = serde_json::from_str(&result_json).unwrap();
+            assert_eq!(process_result.blocks.unwrap().len(), 3);
+
+            let output_path = temp_dir.path().join("decoded.bin");
+            let result = raptorq_decode_symbols(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                CString::new(output_path.to_str().unwrap()).unwrap().as_ptr(),
+                CString::new(process_result.layout_file_path).unwrap().as_ptr(),
+            );
+            assert_eq!(result, 0, "Decoding should succeed");
+            assert_eq!(fs::read(&output_path).unwrap(), data);
+
+            // More data than the callback has
+            let result = raptorq_encode_read_at(
+                session_id,
+                Some(test_read_at),
+                context,
+                data.len() as u64 + 1,
+                symbols_dir_c.as_ptr(),
+                2048,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, -11, "Short data should return -11");
+
+            let result = raptorq_encode_read_at(
+                session_id,
+                None,
+                ptr::null_mut(),
+                data.len() as u64,
+                symbols_dir_c.as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, -2, "Missing callback should return -2");
+
+            raptorq_free_session(session_id);
+        }
+
+        // Tests for raptorq_decode_to_writer
+        extern "C" fn test_collect_write(context: *mut c_void, buffer: *const u8, buffer_len: usize) -> isize {
+            let output = unsafe { &mut *(context as *mut Vec<u8>) };
+            // Consume partially on purpose
+            let len = buffer_len.min(1000);
+            output.extend_from_slice(unsafe { std::slice::from_raw_parts(buffer, len) });
+            len as isize
+        }
+
+        extern "C" fn test_failing_write(_context: *mut c_void, _buffer: *const u8, _buffer_len: usize) -> isize {
+            -1
+        }
+
+        #[test]
+        fn test_ffi_decode_to_writer() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+
+            let original_content: Vec<u8> = (0..5000).map(|i| (i % 199) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &original_content)
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let encode_result = raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                2048,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(encode_result, 0, "Encoding should succeed");
+
+            let layout_path = symbols_dir.join("_raptorq_layout.json");
+            let symbols_dir_c = CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap();
+            let layout_path_c = CString::new(layout_path.to_string_lossy().as_ref()).unwrap();
+
+            let mut output: Vec<u8> = Vec::new();
+            let result = raptorq_decode_to_writer(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                Some(test_collect_write),
+                &mut output as *mut Vec<u8> as *mut c_void,
+            );
+            assert_eq!(result, 0, "Decoding should succeed");
+            assert_eq!(output, original_content);
+
+            let result = raptorq_decode_to_writer(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                Some(test_failing_write),
+                ptr::null_mut(),
+            );
+            assert_eq!(result, -11, "Write error should return -11");
+
+            raptorq_free_session(session_id);
+        }
+
+        extern "C" fn test_collect_progress(context: *mut c_void, bytes_written: u64, bytes_total: u64) {
+            let progress = unsafe { &mut *(context as *mut Vec<(u64, u64)>) };
+            progress.push((bytes_written, bytes_total));
+        }
+
+        #[test]
+        fn test_ffi_decode_to_writer_with_progress() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+
+            let original_content: Vec<u8> = (0..5000).map(|i| (i * 31 / 7 % 251) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &original_content)
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let encode_result = raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                2048,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(encode_result, 0, "Encoding should succeed");
+
+            let layout_path = symbols_dir.join("_raptorq_layout.json");
+            let symbols_dir_c = CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap();
+            let layout_path_c = CString::new(layout_path.to_string_lossy().as_ref()).unwrap();
+
+            let mut output: Vec<u8> = Vec::new();
+            let mut progress: Vec<(u64, u64)> = Vec::new();
+            let result = raptorq_decode_to_writer_with_progress(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                Some(test_collect_write),
+                &mut output as *mut Vec<u8> as *mut c_void,
+                Some(test_collect_progress),
+                &mut progress as *mut Vec<(u64, u64)> as *mut c_void,
+            );
+            assert_eq!(result, 0, "Decoding should succeed");
+            assert_eq!(output, original_content);
+            assert_eq!(progress, vec![(2048, 5000), (4096, 5000), (5000, 5000)]);
+
+            let result = raptorq_decode_to_writer_with_progress(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                Some(test_collect_write),
+                &mut output as *mut Vec<u8> as *mut c_void,
+                None,
+                ptr::null_mut(),
+            );
+            assert_eq!(result, -2, "Missing progress callback should return -2");
+
+            raptorq_free_session(session_id);
+        }
+
+        extern "C" fn test_write_at(context: *mut c_void, offset: u64, buffer: *const u8, buffer_len: usize) -> isize {
+            let output = unsafe { &mut *(context as *mut Vec<u8>) };
+            // Write partially on purpose
+            let len = buffer_len.min(1000);
+            let start = offset as usize;
+            output[start..start + len].copy_from_slice(unsafe { std::slice::from_raw_parts(buffer, len) });
+            len as isize
+        }
+
+        extern "C" fn test_failing_write_at(_context: *mut c_void, _offset: u64, _buffer: *const u8, _buffer_len: usize) -> isize {
+            -1
+        }
+
+        #[test]
+        fn test_ffi_decode_to_writer_at() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+
+            let original_content: Vec<u8> = (0..5000).map(|i| (i % 199) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &original_content)
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let encode_result = raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                2048,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(encode_result, 0, "Encoding should succeed");
+
+            let layout_path = symbols_dir.join("_raptorq_layout.json");
+            let symbols_dir_c = CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap();
+            let layout_path_c = CString::new(layout_path.to_string_lossy().as_ref()).unwrap();
+
+            let mut output = vec![0u8; original_content.len()];
+            let result = raptorq_decode_to_writer_at(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                Some(test_write_at),
+                &mut output as *mut Vec<u8> as *mut c_void,
+            );
+            assert_eq!(result, 0, "Decoding should succeed");
+            assert_eq!(output, original_content);
+
+            let result = raptorq_decode_to_writer_at(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                Some(test_failing_write_at),
+                ptr::null_mut(),
+            );
+            assert_eq!(result, -11, "Write error should return -11");
+
+            let result = raptorq_decode_to_writer_at(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                None,
+                ptr::null_mut(),
+            );
+            assert_eq!(result, -2, "A NULL callback should return -2");
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_decode_range() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+
+            let original_content: Vec<u8> = (0..5000).map(|i| (i % 199) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &original_content)
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let encode_result = raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                2048,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(encode_result, 0, "Encoding should succeed");
+
+            let symbols_dir_c = CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap();
+            let layout_path_c = CString::new(symbols_dir.join("_raptorq_layout.json").to_string_lossy().as_ref()).unwrap();
+
+            let mut output: Vec<u8> = Vec::new();
+            let result = raptorq_decode_range(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                2000,
+                2500,
+                Some(test_collect_write),
+                &mut output as *mut Vec<u8> as *mut c_void,
+            );
+            assert_eq!(result, 0, "Decoding the range should succeed");
+            assert_eq!(output, &original_content[2000..4500]);
+
+            let result = raptorq_decode_range(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                4000,
+                1001,
+                Some(test_collect_write),
+                &mut output as *mut Vec<u8> as *mut c_void,
+            );
+            assert_eq!(result, -2, "A range past the data should return -2");
+
+            let result = raptorq_decode_range(session_id, symbols_dir_c.as_ptr(), layout_path_c.as_ptr(), 0, 10, None, ptr::null_mut());
+            assert_eq!(result, -2);
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_decode_from_source() {
+            // Symbols to give, the last one first
+            struct TestSource {
+                symbols: Vec<(usize, Vec<u8>)>,
+                pulled: usize,
+            }
+
+            extern "C" fn next_symbol(context: *mut c_void, block_id: *mut usize, buffer: *mut u8, buffer_len: usize) -> isize {
+                let source = unsafe { &mut *(context as *mut TestSource) };
+                let Some((id, symbol)) = source.symbols.pop() else { return 0 };
+                assert!(symbol.len() <= buffer_len);
+                source.pulled += 1;
+                unsafe {
+                    *block_id = id;
+                    ptr::copy_nonoverlapping(symbol.as_ptr(), buffer, symbol.len());
+                }
+                symbol.len() as isize
+            }
+
+            extern "C" fn failing_symbol(_context: *mut c_void, _block_id: *mut usize, _buffer: *mut u8, _buffer_len: usize) -> isize {
+                -1
+            }
+
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+
+            let original_content: Vec<u8> = (0..5000).map(|i| (i % 199) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &original_content)
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let encode_result = raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                2048,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(encode_result, 0, "Encoding should succeed");
+
+            let layout_path = symbols_dir.join("_raptorq_layout.json");
+            let layout_path_c = CString::new(layout_path.to_string_lossy().as_ref()).unwrap();
+            let layout: serde_json::Value = serde_json::from_slice(&fs::read(&layout_path).unwrap()).unwrap();
+
+            let mut source = TestSource { symbols: Vec::new(), pulled: 0 };
+            for block in layout["blocks"].as_array().unwrap() {
+                let block_id = block["block_id"].as_u64().unwrap() as usize;
+                for symbol_id in block["symbols"].as_array().unwrap() {
+                    let path = symbols_dir.join(format!("block_{}", block_id)).join(symbol_id.as_str().unwrap());
+                    source.symbols.push((block_id, fs::read(path).unwrap()));
+                }
+            }
+            let total = source.symbols.len();
+
+            let mut output: Vec<u8> = Vec::new();
+            let result = raptorq_decode_from_source(
+                session_id,
+                Some(next_symbol),
+                &mut source as *mut TestSource as *mut c_void,
+                layout_path_c.as_ptr(),
+                Some(test_collect_write),
+                &mut output as *mut Vec<u8> as *mut c_void,
+            );
+            assert_eq!(result, 0, "Decoding should succeed");
+            assert_eq!(output, original_content);
+            assert!(source.pulled < total, "Decoding should stop before the last symbol");
+
+            let result = raptorq_decode_from_source(
+                session_id,
+                Some(failing_symbol),
+                ptr::null_mut(),
+                layout_path_c.as_ptr(),
+                Some(test_collect_write),
+                &mut output as *mut Vec<u8> as *mut c_void,
+            );
+            assert_eq!(result, -11, "Symbol callback error should return -11");
+
+            let mut empty = TestSource { symbols: Vec::new(), pulled: 0 };
+            let result = raptorq_decode_from_source(
+                session_id,
+                Some(next_symbol),
+                &mut empty as *mut TestSource as *mut c_void,
+                layout_path_c.as_ptr(),
+                Some(test_collect_write),
+                &mut output as *mut Vec<u8> as *mut c_void,
+            );
+            assert_eq!(result, -18, "Missing symbols should return -18");
+
+            // The decode gives up once the limit of symbols is pulled
+            assert_eq!(raptorq_set_max_source_symbols(session_id, 3), 0);
+            let mut limited = TestSource { symbols: source.symbols.clone(), pulled: 0 };
+            let result = raptorq_decode_from_source(
+                session_id,
+                Some(next_symbol),
+                &mut limited as *mut TestSource as *mut c_void,
+                layout_path_c.as_ptr(),
+                Some(test_collect_write),
+                &mut output as *mut Vec<u8> as *mut c_void,
+            );
+            assert_eq!(result, -18, "Reaching the limit should return -18");
+            assert_eq!(limited.pulled, 3);
+            assert_eq!(raptorq_set_max_source_symbols(session_id, 0), 0);
+            assert_eq!(raptorq_set_max_source_symbols(999999, 3), -5, "Invalid session should return -5");
+
+            let result = raptorq_decode_from_source(
+                session_id,
+                None,
+                ptr::null_mut(),
+                layout_path_c.as_ptr(),
+                Some(test_collect_write),
+                ptr::null_mut(),
+            );
+            assert_eq!(result, -2, "Missing callback should return -2");
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_decode_from_store() {
+            extern "C" fn read_file(context: *mut c_void, path: *const c_char, buffer: *mut u8, buffer_len: usize) -> isize {
+                let files = unsafe { &*(context as *const HashMap<String, Vec<u8>>) };
+                let path = unsafe { CStr::from_ptr(path) }.to_str().unwrap();
+                let Some(data) = files.get(path) else { return -1 };
+                if data.len() <= buffer_len {
+                    unsafe { ptr::copy_nonoverlapping(data.as_ptr(), buffer, data.len()) };
+                }
+                data.len() as isize
+            }
+
+            extern "C" fn failing_file(_context: *mut c_void, _path: *const c_char, _buffer: *mut u8, _buffer_len: usize) -> isize {
+                -2
+            }
+
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+
+            let original_content: Vec<u8> = (0..5000).map(|i| (i % 199) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &original_content)
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let encode_result = raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                2048,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(encode_result, 0, "Encoding should succeed");
+
+            // The symbols directory in memory, with a layout larger than any symbol
+            let mut files: HashMap<String, Vec<u8>> = HashMap::new();
+            let mut layout = fs::read(symbols_dir.join("_raptorq_layout.json")).unwrap();
+            layout.extend(vec![b' '; 100 * 1024]);
+            files.insert("layout.json".to_string(), layout);
+            for block_dir in fs::read_dir(&symbols_dir).unwrap().map(|e| e.unwrap().path()).filter(|p| p.is_dir()) {
+                for symbol in fs::read_dir(&block_dir).unwrap().map(|e| e.unwrap().path()) {
+                    let (block_id, symbol_id) = parse_symbol_path(symbol.to_str().unwrap()).unwrap();
+                    files.insert(format!("object/{}", symbol_path(block_id, &symbol_id)), fs::read(&symbol).unwrap());
+                }
+            }
+
+            let object_c = CString::new("object").unwrap();
+            let layout_c = CString::new("layout.json").unwrap();
+            let mut output: Vec<u8> = Vec::new();
+            let result = raptorq_decode_from_store(
+                session_id,
+                Some(read_file),
+                &mut files as *mut HashMap<String, Vec<u8>> as *mut c_void,
+                object_c.as_ptr(),
+                layout_c.as_ptr(),
+                Some(test_collect_write),
+                &mut output as *mut Vec<u8> as *mut c_void,
+            );
+            assert_eq!(result, 0, "Decoding should succeed");
+            assert_eq!(output, original_content);
+
+            let missing_c = CString::new("missing").unwrap();
+            let result = raptorq_decode_from_store(
+                session_id,
+                Some(read_file),
+                &mut files as *mut HashMap<String, Vec<u8>> as *mut c_void,
+                missing_c.as_ptr(),
+                layout_c.as_ptr(),
+                Some(test_collect_write),
+                &mut output as *mut Vec<u8> as *mut c_void,
+            );
+            assert_eq!(result, -18, "Missing symbols should return -18");
+
+            let result = raptorq_decode_from_store(
+                session_id,
+                Some(read_file),
+                &mut files as *mut HashMap<String, Vec<u8>> as *mut c_void,
+                object_c.as_ptr(),
+                missing_c.as_ptr(),
+                Some(test_collect_write),
+                &mut output as *mut Vec<u8> as *mut c_void,
+            );
+            assert_eq!(result, -12, "Missing layout should return -12");
+
+            let result = raptorq_decode_from_store(
+                session_id,
+                Some(failing_file),
+                ptr::null_mut(),
+                object_c.as_ptr(),
+                layout_c.as_ptr(),
+                Some(test_collect_write),
+                &mut output as *mut Vec<u8> as *mut c_void,
+            );
+            assert_eq!(result, -11, "File callback error should return -11");
+
+            let result = raptorq_decode_from_store(
+                session_id,
+                None,
+                ptr::null_mut(),
+                object_c.as_ptr(),
+                layout_c.as_ptr(),
+                Some(test_collect_write),
+                ptr::null_mut(),
+            );
+            assert_eq!(result, -2, "Missing callback should return -2");
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_cancel_stream() {
+            extern "C" fn cancelling_read(context: *mut c_void, buffer: *mut u8, buffer_len: usize) -> isize {
+                let session_id = context as usize;
+                let len = buffer_len.min(1024);
+                unsafe { ptr::write_bytes(buffer, 1, len) };
+                // Endless stream, only stopped by the cancellation
+                raptorq_cancel(session_id);
+                len as isize
+            }
+
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let symbols_dir = temp_dir.path().join("symbols");
+
+            let mut result_buffer = vec![0u8; 1024];
+            let result = raptorq_encode_stream(
+                session_id,
+                Some(cancelling_read),
+                session_id as *mut c_void,
+                CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                4096,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, -19, "Cancelled operation should return -19");
+
+            assert_eq!(raptorq_cancel(999999), -5, "Invalid session should return -5");
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_set_timeout() {
+            extern "C" fn slow_read(_context: *mut c_void, buffer: *mut u8, buffer_len: usize) -> isize {
+                let len = buffer_len.min(1024);
+                unsafe { ptr::write_bytes(buffer, 1, len) };
+                // Endless stream, only stopped by the timeout
+                std::thread::sleep(Duration::from_millis(1));
+                len as isize
+            }
+
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let symbols_dir = temp_dir.path().join("symbols");
+
+            assert_eq!(raptorq_set_timeout(session_id, 20), 0);
+            let mut result_buffer = vec![0u8; 1024];
+            let result = raptorq_encode_stream(
+                session_id,
+                Some(slow_read),
+                ptr::null_mut(),
+                CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                4096,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, -21, "Operation over the timeout should return -21");
+
+            let mut code = 0;
+            let mut error_buffer = [0u8; 256];
+            raptorq_get_last_error_detail(session_id, &mut code, error_buffer.as_mut_ptr() as *mut c_char, error_buffer.len());
+            assert_eq!(code, -21);
+
+            assert_eq!(raptorq_set_timeout(session_id, 0), 0);
+            assert_eq!(raptorq_set_timeout(999999, 10), -5, "Invalid session should return -5");
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_set_cleanup_on_error() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &vec![5u8; 5000])
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+            // The third block can't be written
+            fs::create_dir_all(&symbols_dir).unwrap();
+            fs::write(symbols_dir.join("block_2"), b"").unwrap();
+
+            let encode = || {
+                let mut result_buffer = vec![0u8; 4096];
+                raptorq_encode_file(
+                    session_id,
+                    CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                    CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                    2048,
+                    result_buffer.as_mut_ptr() as *mut c_char,
+                    result_buffer.len(),
+                )
+            };
+
+            assert_eq!(encode(), -11, "Failed write should return -11");
+            assert!(!symbols_dir.join("block_0").exists(), "Symbols should be removed by default");
+
+            assert_eq!(raptorq_set_cleanup_on_error(session_id, false), 0);
+            assert_eq!(encode(), -11, "Failed write should return -11");
+            assert!(symbols_dir.join("block_0").exists(), "Symbols should be kept");
+
+            assert_eq!(raptorq_set_cleanup_on_error(999999, true), -5, "Invalid session should return -5");
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_set_repair_symbols_per_block() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let data: Vec<u8> = (0..5000).map(|i| (i * 31 / 7 % 251) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &data)
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+
+            assert_eq!(raptorq_set_repair_symbols_per_block(session_id, 7), 0);
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let result = raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                2048,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encoding should succeed");
+            let result_json = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
+            let process_result: ProcessResult = serde_json::from_str(&result_json).unwrap();
+            assert_eq!(process_result.total_repair_symbols, 3 * 7);
+
+            assert_eq!(raptorq_set_repair_symbols_per_block(session_id, u32::MAX), -2, "Too many symbols should return -2");
+            assert_eq!(raptorq_set_repair_symbols_per_block(session_id, 0), 0);
+            assert_eq!(raptorq_set_repair_symbols_per_block(999999, 7), -5, "Invalid session should return -5");
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_set_flat_symbol_layout() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let data: Vec<u8> = (0..5000).map(|i| (i * 31 / 7 % 251) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &data)
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+            let symbols_dir_c = CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap();
+
+            assert_eq!(raptorq_set_flat_symbol_layout(session_id, true), 0);
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let result = raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                symbols_dir_c.as_ptr(),
+                2048,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encoding should succeed");
+            assert!(!symbols_dir.join("block_0").exists(), "No block directory should be written");
+
+            let result_json = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
+            let process_result: ProcessResult = serde_json::from_str(&result_json).unwrap();
+            let output_path = temp_dir.path().join("decoded.bin");
+            let result = raptorq_decode_symbols(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                CString::new(output_path.to_str().unwrap()).unwrap().as_ptr(),
+                CString::new(process_result.layout_file_path).unwrap().as_ptr(),
+            );
+            assert_eq!(result, 0, "Decoding should succeed");
+            assert_eq!(fs::read(&output_path).unwrap(), data);
+
+            assert_eq!(raptorq_set_flat_symbol_layout(999999, true), -5, "Invalid session should return -5");
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_set_auto_symbol_size() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let data: Vec<u8> = (0..2100).map(|i| (i * 31 / 7 % 251) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &data)
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+            let symbols_dir_c = CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap();
+
+            assert_eq!(raptorq_set_auto_symbol_size(session_id, true), 0);
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let result = raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                symbols_dir_c.as_ptr(),
+                2048,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encoding should succeed");
+
+            let result_json = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
+            let process_result: ProcessResult = serde_json::from_str(&result_json).unwrap();
+            let layout = RaptorQLayout::read_file(&process_result.layout_file_path).unwrap();
+            assert_eq!(layout.blocks[1].symbol_size(), 56, "The tail of 52 bytes should get a fitted symbol");
+            let output_path = temp_dir.path().join("decoded.bin");
+            let result = raptorq_decode_symbols(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                CString::new(output_path.to_str().unwrap()).unwrap().as_ptr(),
+                CString::new(process_result.layout_file_path).unwrap().as_ptr(),
+            );
+            assert_eq!(result, 0, "Decoding should succeed");
+            assert_eq!(fs::read(&output_path).unwrap(), data);
+
+            assert_eq!(raptorq_set_auto_symbol_size(999999, true), -5, "Invalid session should return -5");
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_encode_file_with_symbol_size() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let data: Vec<u8> = (0..5000).map(|i| (i * 31 / 7 % 251) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &data)
+                .expect("Failed to create test input file");
+            let input_path_c = CString::new(input_path.to_string_lossy().as_ref()).unwrap();
+            let symbols_dir = temp_dir.path().join("symbols");
+            let symbols_dir_c = CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap();
+
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let result = raptorq_encode_file_with_symbol_size(
+                session_id,
+                input_path_c.as_ptr(),
+                symbols_dir_c.as_ptr(),
+                512,
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encoding should succeed");
+
+            let result_json = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
+            let process_result: ProcessResult = serde_json::from_str(&result_json).unwrap();
+            let layout = RaptorQLayout::read_file(&process_result.layout_file_path).unwrap();
+            assert_eq!(layout.blocks[0].symbol_size(), 512);
+            let mut session_symbol_size: u16 = 0;
+            assert_eq!(raptorq_get_config(session_id, &mut session_symbol_size, ptr::null_mut(), ptr::null_mut(), ptr::null_mut()), 0);
+            assert_ne!(session_symbol_size, 512, "The session keeps its symbol size");
+
+            let output_path = temp_dir.path().join("decoded.bin");
+            let result = raptorq_decode_symbols(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                CString::new(output_path.to_str().unwrap()).unwrap().as_ptr(),
+                CString::new(process_result.layout_file_path).unwrap().as_ptr(),
+            );
+            assert_eq!(result, 0, "Decoding should succeed");
+            assert_eq!(fs::read(&output_path).unwrap(), data);
+
+            let result = raptorq_encode_file_with_symbol_size(
+                session_id,
+                input_path_c.as_ptr(),
+                symbols_dir_c.as_ptr(),
+                4,
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, -2, "A symbol size below 8 bytes should return -2");
+
+            let result = raptorq_encode_file_with_symbol_size(
+                999999,
+                input_path_c.as_ptr(),
+                symbols_dir_c.as_ptr(),
+                512,
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, -5, "Invalid session should return -5");
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_set_buffer_pool() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let data: Vec<u8> = (0..5000).map(|i| (i * 31 / 7 % 251) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &data)
+                .expect("Failed to create test input file");
+
+            assert_eq!(raptorq_set_buffer_pool(session_id, true), 0);
+            let mut layouts = Vec::new();
+            for name in ["first", "second"] {
+                let symbols_dir = temp_dir.path().join(name);
+                let mut result_buffer = vec![0u8; 64 * 1024];
+                let result = raptorq_encode_file(
+                    session_id,
+                    CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                    CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                    2048,
+                    result_buffer.as_mut_ptr() as *mut c_char,
+                    result_buffer.len(),
+                );
+                assert_eq!(result, 0, "Encoding should succeed");
+                let result_json = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
+                let process_result: ProcessResult = serde_json::from_str(&result_json).unwrap();
+                layouts.push(fs::read_to_string(process_result.layout_file_path).unwrap());
+            }
+            assert_eq!(layouts[0], layouts[1], "Encodes reusing the buffer should write the same layout");
+            assert_eq!(raptorq_set_buffer_pool(session_id, false), 0);
+
+            assert_eq!(raptorq_set_buffer_pool(999999, true), -5, "Invalid session should return -5");
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_set_symbol_codec() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let data = b"{\"level\":\"info\",\"message\":\"request served\"}\n".repeat(200);
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &data)
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+            let symbols_dir_c = CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap();
+
+            assert_eq!(raptorq_set_symbol_codec(session_id, RAPTORQ_CODEC_ZSTD), 0);
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let result = raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                symbols_dir_c.as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encoding should succeed");
+
+            let result_json = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
+            let process_result: ProcessResult = serde_json::from_str(&result_json).unwrap();
+            let layout = RaptorQLayout::read_file(&process_result.layout_file_path).unwrap();
+            assert_eq!(layout.symbol_codec, SymbolCodec::Zstd);
+
+            // The layout gives the codec to the decode
+            assert_eq!(raptorq_set_symbol_codec(session_id, RAPTORQ_CODEC_NONE), 0);
+            let output_path = temp_dir.path().join("decoded.bin");
+            let result = raptorq_decode_symbols(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                CString::new(output_path.to_str().unwrap()).unwrap().as_ptr(),
+                CString::new(process_result.layout_file_path).unwrap().as_ptr(),
+            );
+            assert_eq!(result, 0, "Decoding should succeed");
+            assert_eq!(fs::read(&output_path).unwrap(), data);
+
+            assert_eq!(raptorq_set_symbol_codec(session_id, 3), -2, "Unknown codec should return -2");
+            assert_eq!(raptorq_set_symbol_codec(999999, RAPTORQ_CODEC_GZIP), -5, "Invalid session should return -5");
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_set_layout_format() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let data: Vec<u8> = (0..20000).map(|i| (i * 31 / 7 % 251) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &data)
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+            let symbols_dir_c = CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap();
+            let layout_path = symbols_dir.join("_raptorq_layout.json");
+            let layout_path_c = CString::new(layout_path.to_string_lossy().as_ref()).unwrap();
+            let output_path = temp_dir.path().join("decoded.bin");
+
+            assert_eq!(raptorq_set_layout_format(session_id, RAPTORQ_LAYOUT_BINARY), 0);
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let result = raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                symbols_dir_c.as_ptr(),
+                5000,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encoding should succeed");
+            assert!(fs::read(&layout_path).unwrap().starts_with(b"RQLB"));
+
+            // Sessions read the layout whatever their format
+            assert_eq!(raptorq_set_layout_format(session_id, RAPTORQ_LAYOUT_JSON), 0);
+            let result = raptorq_decode_symbols(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                CString::new(output_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                layout_path_c.as_ptr(),
+            );
+            assert_eq!(result, 0, "Decoding the binary layout should succeed");
+            assert_eq!(fs::read(&output_path).unwrap(), data);
+
+            let result = raptorq_parse_layout(
+                layout_path_c.as_ptr(),
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0);
+            let summary: LayoutSummary = serde_json::from_str(
+                &buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len())
+            ).unwrap();
+            assert_eq!(summary.total_size, data.len() as u64);
+            assert_eq!(summary.blocks.len(), 4);
+
+            assert_eq!(raptorq_set_layout_format(session_id, 2), -2, "Unknown format should return -2");
+            assert_eq!(raptorq_set_layout_format(999999, RAPTORQ_LAYOUT_JSON), -5, "Invalid session should return -5");
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_set_content_hash() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let data: Vec<u8> = (0..20000).map(|i| (i * 31 / 7 % 251) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &data)
+                .expect("Failed to create test input file");
+            let input_path_c = CString::new(input_path.to_string_lossy().as_ref()).unwrap();
+
+            let encode = |name: &str| {
+                let symbols_dir = temp_dir.path().join(name);
+                let mut result_buffer = vec![0u8; 64 * 1024];
+                let result = raptorq_encode_file(
+                    session_id,
+                    input_path_c.as_ptr(),
+                    CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                    0,
+                    result_buffer.as_mut_ptr() as *mut c_char,
+                    result_buffer.len(),
+                );
+                assert_eq!(result, 0, "Encoding should succeed");
+                let result_json = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
+                let process_result: ProcessResult = serde_json::from_str(&result_json).unwrap();
+                let layout = RaptorQLayout::read_file(&process_result.layout_file_path).unwrap();
+                (process_result.content_hash, layout.object_sha256)
+            };
+
+            // SHA-256 by default, the hash of the layout
+            let (sha256, object_sha256) = encode("sha256");
+            assert_eq!(sha256.len(), 64);
+            assert_eq!(sha256, object_sha256);
+
+            assert_eq!(raptorq_set_content_hash(session_id, RAPTORQ_HASH_BLAKE3), 0);
+            let (blake3, object_sha256) = encode("blake3");
+            assert_eq!(blake3.len(), 64);
+            assert_ne!(blake3, sha256);
+            assert!(object_sha256.is_empty());
+
+            assert_eq!(raptorq_set_content_hash(session_id, RAPTORQ_HASH_NONE), 0);
+            assert_eq!(encode("none"), (String::new(), String::new()));
+
+            assert_eq!(raptorq_set_content_hash(session_id, 3), -2, "Unknown hash should return -2");
+            assert_eq!(raptorq_set_content_hash(999999, RAPTORQ_HASH_SHA256), -5, "Invalid session should return -5");
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_set_temp_dir() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let scratch_dir = temp_dir.path().join("scratch");
+
+            assert_eq!(raptorq_set_temp_dir(session_id, CString::new(scratch_dir.to_str().unwrap()).unwrap().as_ptr()), 0);
+            assert_eq!(get_processor(session_id).unwrap().temp_dir(), Some(scratch_dir));
+            assert_eq!(raptorq_set_temp_dir(session_id, ptr::null()), 0);
+            assert_eq!(get_processor(session_id).unwrap().temp_dir(), Some(std::env::temp_dir()));
+
+            assert_eq!(raptorq_set_temp_dir(session_id, CString::new("").unwrap().as_ptr()), -2, "Empty path should return -2");
+            assert_eq!(raptorq_set_temp_dir(999999, ptr::null()), -5, "Invalid session should return -5");
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_set_symbol_key() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let data: Vec<u8> = (0..5000).map(|i| (i * 31 / 7 % 251) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &data)
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+            let symbols_dir_c = CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap();
+            let key = [5u8; 16];
+
+            assert_eq!(raptorq_set_symbol_key(session_id, key.as_ptr(), key.len()), 0);
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let result = raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                symbols_dir_c.as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encoding should succeed");
+
+            let result_json = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
+            let process_result: ProcessResult = serde_json::from_str(&result_json).unwrap();
+            let layout_path_c = CString::new(process_result.layout_file_path).unwrap();
+            let output_path_c = CString::new(temp_dir.path().join("decoded.bin").to_str().unwrap()).unwrap();
+
+            // Without the key the symbols can't be decoded
+            assert_eq!(raptorq_set_symbol_key(session_id, ptr::null(), 0), 0);
+            let result = raptorq_decode_symbols(session_id, symbols_dir_c.as_ptr(), output_path_c.as_ptr(), layout_path_c.as_ptr());
+            assert_eq!(result, -2, "Decoding without the key should fail");
+
+            assert_eq!(raptorq_set_symbol_key(session_id, key.as_ptr(), key.len()), 0);
+            let result = raptorq_decode_symbols(session_id, symbols_dir_c.as_ptr(), output_path_c.as_ptr(), layout_path_c.as_ptr());
+            assert_eq!(result, 0, "Decoding should succeed");
+            assert_eq!(fs::read(temp_dir.path().join("decoded.bin")).unwrap(), data);
+
+            assert_eq!(raptorq_set_symbol_key(session_id, key.as_ptr(), 10), -2, "A 10-byte key should return -2");
+            assert_eq!(raptorq_set_symbol_key(session_id, ptr::null(), 16), -2, "A NULL key should return -2");
+            assert_eq!(raptorq_set_symbol_key(999999, key.as_ptr(), key.len()), -5, "Invalid session should return -5");
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_set_symbol_crc() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let data: Vec<u8> = (0..5000).map(|i| (i * 31 / 7 % 251) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &data)
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+            let symbols_dir_c = CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap();
+
+            assert_eq!(raptorq_set_symbol_crc(session_id, true), 0);
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let result = raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                symbols_dir_c.as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encoding should succeed");
+
+            let result_json = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
+            let process_result: ProcessResult = serde_json::from_str(&result_json).unwrap();
+            let layout = RaptorQLayout::read_file(&process_result.layout_file_path).unwrap();
+            assert!(layout.symbol_crc);
+
+            // A bit-flipped symbol is skipped, whatever the option of the decoding session
+            let symbol_path = symbols_dir.join(block_dir_name(0)).join(&layout.blocks[0].symbols[0]);
+            let mut content = fs::read(&symbol_path).unwrap();
+            assert!(SymbolHeader::read(&content).is_ok());
+            content[SymbolHeader::LEN] ^= 1;
+            fs::write(&symbol_path, &content).unwrap();
+
+            assert_eq!(raptorq_set_symbol_crc(session_id, false), 0);
+            let output_path = temp_dir.path().join("decoded.bin");
+            let result = raptorq_decode_symbols(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                CString::new(output_path.to_str().unwrap()).unwrap().as_ptr(),
+                CString::new(process_result.layout_file_path).unwrap().as_ptr(),
+            );
+            assert_eq!(result, 0, "Decoding should succeed");
+            assert_eq!(fs::read(&output_path).unwrap(), data);
+
+            assert_eq!(raptorq_set_symbol_crc(999999, true), -5, "Invalid session should return -5");
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_resume_encode() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let data: Vec<u8> = (0..5000).map(|i| (i * 31 / 7 % 251) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &data)
+                .expect("Failed to create test input file");
+            let input_path_c = CString::new(input_path.to_string_lossy().as_ref()).unwrap();
+            let symbols_dir = temp_dir.path().join("symbols");
+            let symbols_dir_c = CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap();
+            // The third block can't be written
+            fs::create_dir_all(&symbols_dir).unwrap();
+            fs::write(symbols_dir.join("block_2"), b"").unwrap();
+
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            assert_eq!(raptorq_set_cleanup_on_error(session_id, false), 0);
+            let result = raptorq_encode_file(
+                session_id,
+                input_path_c.as_ptr(),
+                symbols_dir_c.as_ptr(),
+                2048,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, -11, "Failed write should return -11");
+
+            fs::remove_file(symbols_dir.join("block_2")).unwrap();
+            let result = raptorq_resume_encode(
+                session_id,
+                input_path_c.as_ptr(),
+                symbols_dir_c.as_ptr(),
+                2048,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Resuming should succeed");
+
+            let result_json = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
+            let process_result: ProcessResult = serde_json::from_str(&result_json).unwrap();
+            assert_eq!(process_result.blocks.unwrap().len(), 3);
+
+            let output_path = temp_dir.path().join("decoded.bin");
+            let result = raptorq_decode_symbols(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                CString::new(output_path.to_str().unwrap()).unwrap().as_ptr(),
+                CString::new(process_result.layout_file_path).unwrap().as_ptr(),
+            );
+            assert_eq!(result, 0, "Decoding should succeed");
+            assert_eq!(fs::read(&output_path).unwrap(), data);
+
+            let result = raptorq_resume_encode(
+                999999,
+                input_path_c.as_ptr(),
+                symbols_dir_c.as_ptr(),
+                2048,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, -5, "Invalid session should return -5");
+
+            raptorq_free_session(session_id);
+        }
+
+        #[test]
+        fn test_ffi_append_encode() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let data: Vec<u8> = (0..9000).map(|i| (i * 31 / 7 % 251) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &data[..5000])
+                .expect("Failed to create test input file");
+            let input_path_c = CString::new(input_path.to_string_lossy().as_ref()).unwrap();
+            let symbols_dir = temp_dir.path().join("symbols");
+            let symbols_dir_c = CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap();
+            let layout_path_c = CString::new(symbols_dir.join("_raptorq_layout.json").to_string_lossy().as_ref()).unwrap();
+
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let result = raptorq_encode_file(
+                session_id,
+                input_path_c.as_ptr(),
+                symbols_dir_c.as_ptr(),
+                2048,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encoding should succeed");
+
+            fs::write(&input_path, &data).unwrap();
+            let result = raptorq_append_encode(
+                session_id,
+                layout_path_c.as_ptr(),
+                input_path_c.as_ptr(),
+                symbols_dir_c.as_ptr(),
+                2048,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Appending should succeed");
 
-    let version_bytes = c_version.as_bytes_with_nul();
-    if version_bytes.len() > version_buffer_len {
-        return -1;
-    }
+            let result_json = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
+            let process_result: ProcessResult = serde_json::from_str(&result_json).unwrap();
+            let sizes: Vec<u64> = process_result.blocks.unwrap().iter().map(|b| b.size).collect();
+            assert_eq!(sizes, vec![2048, 2048, 904, 2048, 1952]);
 
-    unsafe {
-        ptr::copy_nonoverlapping(
-            version_bytes.as_ptr() as *const c_char,
-            version_buffer,
-            version_bytes.len(),
-        );
-    }
+            let output_path = temp_dir.path().join("decoded.bin");
+            let result = raptorq_decode_symbols(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                CString::new(output_path.to_str().unwrap()).unwrap().as_ptr(),
+                layout_path_c.as_ptr(),
+            );
+            assert_eq!(result, 0, "Decoding should succeed");
+            assert_eq!(fs::read(&output_path).unwrap(), data);
 
-    0
-}
+            // A shorter input is not an append
+            fs::write(&input_path, &data[..4000]).unwrap();
+            let result = raptorq_append_encode(
+                session_id,
+                layout_path_c.as_ptr(),
+                input_path_c.as_ptr(),
+                symbols_dir_c.as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, -2, "Shorter input should return -2");
 
-#[cfg(test)]
-mod ffi_tests {
-    use super::*;
-    use std::ffi::{CStr, CString};
-    use std::fs::{self, File};
-    use std::io::{self, Write};
-    use std::path::{Path, PathBuf};
-    use std::ptr;
-    use tempfile::tempdir;
+            let result = raptorq_append_encode(
+                session_id,
+                ptr::null(),
+                input_path_c.as_ptr(),
+                symbols_dir_c.as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, -2, "Null layout path should return -2");
 
-    // Helper functions for the tests
-    fn create_temp_file(dir: &Path, name: &str, content: &[u8]) -> io::Result<PathBuf> {
-        let file_path = dir.join(name);
-        let mut file = File::create(&file_path)?;
-        file.write_all(content)?;
-        Ok(file_path)
-    }
+            let result = raptorq_append_encode(
+                999999,
+                layout_path_c.as_ptr(),
+                input_path_c.as_ptr(),
+                symbols_dir_c.as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, -5, "Invalid session should return -5");
 
-    // Tests for raptorq_init_session
-    #[test]
-        fn test_ffi_init_success() {
-            let session_id = raptorq_init_session(1024, 10, 1024, 4);
-            assert!(session_id > 0, "Session ID should be non-zero");
-            
-            // Verify processor exists in PROCESSORS map with correct config
-            let processors = PROCESSORS.lock();
-            let processor = processors.get(&session_id).expect("Processor should exist in map");
-            
-            let config = &processor.get_config();
-            assert_eq!(config.symbol_size, 1024);
-            assert_eq!(config.redundancy_factor, 10);
-            assert_eq!(config.max_memory_mb, 1024);
-            assert_eq!(config.concurrency_limit, 4);
-            
-            // Clean up
-            drop(processors);
             raptorq_free_session(session_id);
         }
-    
+
         #[test]
-        fn test_ffi_init_multiple() {
-            let session_id1 = raptorq_init_session(1024, 10, 1024, 4);
-            let session_id2 = raptorq_init_session(512, 5, 512, 2);
-            
-            assert!(session_id1 > 0, "First session ID should be non-zero");
-            assert!(session_id2 > 0, "Second session ID should be non-zero");
-            assert_ne!(session_id1, session_id2, "Session IDs should be unique");
-            
-            // Verify both processors exist in the map
-            let processors = PROCESSORS.lock();
-            assert!(processors.contains_key(&session_id1), "First processor should exist in map");
-            assert!(processors.contains_key(&session_id2), "Second processor should exist in map");
-            
-            // Clean up
-            drop(processors);
-            raptorq_free_session(session_id1);
-            raptorq_free_session(session_id2);
+        fn test_ffi_encode_block_by_block() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &vec![5u8; 5000])
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+
+            let mut job_id: usize = 0;
+            let mut blocks_total: usize = 0;
+            let result = raptorq_encode_begin(
+                session_id,
+                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                2048,
+                &mut job_id,
+                &mut blocks_total,
+            );
+            assert_eq!(result, 0, "Starting the encoding should succeed");
+            assert_eq!(blocks_total, 3);
+
+            // Finishing too early keeps the job
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let result = raptorq_encode_finish(job_id, result_buffer.as_mut_ptr() as *mut c_char, result_buffer.len());
+            assert_eq!(result, -14);
+
+            let mut blocks_done: usize = 0;
+            let mut bytes_processed: u64 = 0;
+            for expected in 1..=3 {
+                assert_eq!(raptorq_encode_next_block(job_id, &mut blocks_done, &mut bytes_processed), 0);
+                assert_eq!(blocks_done, expected);
+            }
+            assert_eq!(bytes_processed, 5000);
+
+            // Without a result buffer or with one too small, the job is kept
+            assert_eq!(raptorq_encode_finish(job_id, ptr::null_mut(), 0), -2);
+            let mut small_buffer = vec![0u8; 8];
+            let result = raptorq_encode_finish(job_id, small_buffer.as_mut_ptr() as *mut c_char, small_buffer.len());
+            assert_eq!(result, -4);
+            assert!(symbols_dir.join("_raptorq_layout.json").exists());
+
+            let result = raptorq_encode_finish(
+                job_id,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Finishing the encoding should succeed");
+            assert!(symbols_dir.join("_raptorq_layout.json").exists());
+
+            // The job is released
+            assert_eq!(raptorq_encode_next_block(job_id, ptr::null_mut(), ptr::null_mut()), -5);
+            assert!(!raptorq_encode_abort(job_id));
+
+            raptorq_free_session(session_id);
         }
-    
-        // Tests for raptorq_free_session
+
         #[test]
-        fn test_ffi_free_valid_session() {
+        fn test_ffi_encode_abort() {
             let session_id = init_test_session();
-            
-            // Verify session exists before freeing
-            {
-                let processors = PROCESSORS.lock();
-                assert!(processors.contains_key(&session_id), "Session should exist before freeing");
-            }
-            
-            // Free the session
-            let result = raptorq_free_session(session_id);
-            assert!(result, "Freeing valid session should return true");
-            
-            // Verify processor was removed from map
-            let processors = PROCESSORS.lock();
-            assert!(!processors.contains_key(&session_id), "Session should not exist after freeing");
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &vec![5u8; 5000])
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+
+            let mut job_id: usize = 0;
+            let result = raptorq_encode_begin(
+                session_id,
+                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                2048,
+                &mut job_id,
+                ptr::null_mut(),
+            );
+            assert_eq!(result, 0, "Starting the encoding should succeed");
+            assert_eq!(raptorq_encode_next_block(job_id, ptr::null_mut(), ptr::null_mut()), 0);
+
+            assert!(raptorq_encode_abort(job_id));
+            assert!(!symbols_dir.join("_raptorq_layout.json").exists());
+
+            raptorq_free_session(session_id);
         }
-    
+
         #[test]
-        fn test_ffi_free_invalid_session() {
-            // Try to free a session that doesn't exist
-            let invalid_session_id = 99999;
-            let result = raptorq_free_session(invalid_session_id);
-            
-            assert!(!result, "Freeing invalid session should return false");
+        fn test_ffi_get_config() {
+            let session_id = raptorq_init_session(2048, 6, 512, 3);
+
+            let mut symbol_size: u16 = 0;
+            let mut redundancy_factor: u8 = 0;
+            let mut max_memory_mb: u64 = 0;
+            let mut concurrency_limit: u64 = 0;
+            let result = raptorq_get_config(
+                session_id,
+                &mut symbol_size,
+                &mut redundancy_factor,
+                &mut max_memory_mb,
+                &mut concurrency_limit,
+            );
+            assert_eq!(result, 0);
+            assert_eq!(symbol_size, 2048);
+            assert_eq!(redundancy_factor, 6);
+            assert_eq!(max_memory_mb, 512);
+            assert_eq!(concurrency_limit, 3);
+
+            // Only some of the values
+            let mut symbol_size: u16 = 0;
+            let result = raptorq_get_config(session_id, &mut symbol_size, ptr::null_mut(), ptr::null_mut(), ptr::null_mut());
+            assert_eq!(result, 0);
+            assert_eq!(symbol_size, 2048);
+
+            raptorq_free_session(session_id);
+
+            let result = raptorq_get_config(session_id, ptr::null_mut(), ptr::null_mut(), ptr::null_mut(), ptr::null_mut());
+            assert_eq!(result, -5, "Invalid session should return -5");
         }
-    
+
         #[test]
-        fn test_ffi_free_double_free() {
+        fn test_ffi_get_available_task_slots() {
+            let session_id = raptorq_init_session(1024, 10, 1024, 3);
+
+            let mut available_slots: u64 = 0;
+            assert_eq!(raptorq_get_available_task_slots(session_id, &mut available_slots), 0);
+            assert_eq!(available_slots, 3);
+            assert_eq!(raptorq_get_available_task_slots(session_id, ptr::null_mut()), -2);
+
+            raptorq_free_session(session_id);
+            assert_eq!(raptorq_get_available_task_slots(session_id, &mut available_slots), -5);
+        }
+
+        #[test]
+        fn test_ffi_min_symbols_for_block() {
             let session_id = init_test_session();
-            
-            // First free (should succeed)
-            let first_result = raptorq_free_session(session_id);
-            assert!(first_result, "First free should return true");
-            
-            // Second free (should fail)
-            let second_result = raptorq_free_session(session_id);
-            assert!(!second_result, "Second free of same ID should return false");
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+
+            let input_path = create_temp_file(temp_dir.path(), "input.bin", &vec![7u8; 3000])
+                .expect("Failed to create test input file");
+            let layout_path = temp_dir.path().join("layout.json");
+            let layout_path_c = CString::new(layout_path.to_str().unwrap()).unwrap();
+
+            let mut result_buffer = [0u8; 4096];
+            let result = raptorq_create_metadata(
+                session_id,
+                CString::new(input_path.to_str().unwrap()).unwrap().as_ptr(),
+                layout_path_c.as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Create metadata should succeed");
+
+            // 3000 bytes with 1024 bytes symbols: 3 source symbols plus the overhead
+            let mut min_symbols: u64 = 0;
+            let result = raptorq_min_symbols_for_block(session_id, layout_path_c.as_ptr(), 0, &mut min_symbols);
+            assert_eq!(result, 0);
+            assert_eq!(min_symbols, 3 + DECODE_SYMBOL_OVERHEAD);
+
+            let result = raptorq_min_symbols_for_block(session_id, layout_path_c.as_ptr(), 1, &mut min_symbols);
+            assert_eq!(result, -15, "Unknown block should return -15");
+
+            let result = raptorq_min_symbols_for_block(session_id, layout_path_c.as_ptr(), 0, ptr::null_mut());
+            assert_eq!(result, -2, "Null output should return -2");
+
+            let mut json_buffer = [0u8; 256];
+            let result = raptorq_min_symbols_per_block(
+                session_id,
+                layout_path_c.as_ptr(),
+                json_buffer.as_mut_ptr() as *mut c_char,
+                json_buffer.len(),
+            );
+            assert_eq!(result, 0);
+            let json = buffer_as_string(json_buffer.as_ptr() as *const c_char, json_buffer.len());
+            assert_eq!(json, r#"{"0":5}"#);
+
+            let result = raptorq_min_symbols_per_block(
+                session_id,
+                layout_path_c.as_ptr(),
+                json_buffer.as_mut_ptr() as *mut c_char,
+                4,
+            );
+            assert_eq!(result, -4, "Small buffer should return -4");
+
+            raptorq_free_session(session_id);
+
+            let result = raptorq_min_symbols_for_block(session_id, layout_path_c.as_ptr(), 0, &mut min_symbols);
+            assert_eq!(result, -5, "Invalid session should return -5");
         }
-    
-        // Tests for raptorq_encode_file
+
         #[test]
-        fn test_ffi_encode_null_pointers() {
+        fn test_ffi_can_decode() {
             let session_id = init_test_session();
-            let mut result_buffer = [0u8; 1024];
-            
-            // Test with null input_path
-            let result = raptorq_encode_file(
-                    session_id,
-                    ptr::null(),
-                    CString::new("output").unwrap().as_ptr(),
-                    0,
-                    result_buffer.as_mut_ptr() as *mut c_char,
-                    result_buffer.len(),
-                );
-            assert_eq!(result, -2, "Null input_path should return -2");
-            
-            // Test with null output_dir
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+
+            let input_path = create_temp_file(temp_dir.path(), "input.bin", &vec![7u8; 3000])
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+            let symbols_dir_c = CString::new(symbols_dir.to_str().unwrap()).unwrap();
+
+            let mut result_buffer = [0u8; 4096];
             let result = raptorq_encode_file(
-                    session_id,
-                    CString::new("input").unwrap().as_ptr(),
-                    ptr::null(),
-                    0,
-                    result_buffer.as_mut_ptr() as *mut c_char,
-                    result_buffer.len(),
-                );
-            assert_eq!(result, -2, "Null output_dir should return -2");
-            
-            // Test with null result_buffer
+                session_id,
+                CString::new(input_path.to_str().unwrap()).unwrap().as_ptr(),
+                symbols_dir_c.as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encode should succeed");
+
+            let layout_path_c = CString::new(symbols_dir.join("_raptorq_layout.json").to_str().unwrap()).unwrap();
+            assert_eq!(raptorq_can_decode(session_id, symbols_dir_c.as_ptr(), layout_path_c.as_ptr()), 1);
+
+            let missing_dir_c = CString::new(temp_dir.path().join("missing").to_str().unwrap()).unwrap();
+            assert_eq!(raptorq_can_decode(session_id, missing_dir_c.as_ptr(), layout_path_c.as_ptr()), 0);
+
+            let missing_layout_c = CString::new(temp_dir.path().join("missing.json").to_str().unwrap()).unwrap();
+            assert_eq!(raptorq_can_decode(session_id, symbols_dir_c.as_ptr(), missing_layout_c.as_ptr()), -12);
+
+            assert_eq!(raptorq_can_decode(session_id, ptr::null(), layout_path_c.as_ptr()), -2);
+
+            raptorq_free_session(session_id);
+
+            assert_eq!(raptorq_can_decode(session_id, symbols_dir_c.as_ptr(), layout_path_c.as_ptr()), -5);
+        }
+
+        #[test]
+        fn test_ffi_missing_symbols() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+
+            let input_path = create_temp_file(temp_dir.path(), "input.bin", &vec![7u8; 3000])
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+            let symbols_dir_c = CString::new(symbols_dir.to_str().unwrap()).unwrap();
+
+            let mut result_buffer = [0u8; 4096];
             let result = raptorq_encode_file(
-                    session_id,
-                    CString::new("input").unwrap().as_ptr(),
-                    CString::new("output").unwrap().as_ptr(),
-                    0,
-                    ptr::null_mut(),
-                    1024,
-                );
-            assert_eq!(result, -2, "Null result_buffer should return -2");
-            
-            // Clean up
+                session_id,
+                CString::new(input_path.to_str().unwrap()).unwrap().as_ptr(),
+                symbols_dir_c.as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encode should succeed");
+
+            let layout_path_c = CString::new(symbols_dir.join("_raptorq_layout.json").to_str().unwrap()).unwrap();
+            let missing_dir_c = CString::new(temp_dir.path().join("missing").to_str().unwrap()).unwrap();
+            let result = raptorq_missing_symbols(
+                session_id,
+                missing_dir_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0);
+            let missing_json = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
+            let missing: HashMap<String, i64> = serde_json::from_str(&missing_json).unwrap();
+            let mut min_symbols = 0u64;
+            assert_eq!(raptorq_min_symbols_for_block(session_id, layout_path_c.as_ptr(), 0, &mut min_symbols), 0);
+            assert_eq!(missing["0"], min_symbols as i64, "A missing directory has no symbols");
+
+            let result = raptorq_missing_symbols(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0);
+            let missing_json = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
+            let missing: HashMap<String, i64> = serde_json::from_str(&missing_json).unwrap();
+            assert!(missing["0"] <= 0, "All symbols are present");
+
+            let result = raptorq_missing_symbols(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                result_buffer.as_mut_ptr() as *mut c_char,
+                2,
+            );
+            assert_eq!(result, -4, "Small buffer should return -4");
+
             raptorq_free_session(session_id);
+
+            let result = raptorq_missing_symbols(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, -5, "Invalid session should return -5");
         }
-    
+
         #[test]
-        fn test_ffi_encode_invalid_session() {
-            let invalid_session_id = 99999;
-            let mut result_buffer = [0u8; 1024];
-            
+        fn test_ffi_shard_symbols() {
+            let session_id = init_test_session();
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let data: Vec<u8> = (0..5000).map(|i| (i * 31 / 7 % 251) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "original.bin", &data)
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+            let symbols_dir_c = CString::new(symbols_dir.to_str().unwrap()).unwrap();
+
+            let mut result_buffer = vec![0u8; 64 * 1024];
             let result = raptorq_encode_file(
-                    invalid_session_id,
-                    CString::new("input").unwrap().as_ptr(),
-                    CString::new("output").unwrap().as_ptr(),
-                    0,
-                    result_buffer.as_mut_ptr() as *mut c_char,
-                    result_buffer.len(),
-                );
-            
-            assert_eq!(result, -5, "Invalid session ID should return -5");
+                session_id,
+                CString::new(input_path.to_str().unwrap()).unwrap().as_ptr(),
+                symbols_dir_c.as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encode should succeed");
+            let layout_path = symbols_dir.join("_raptorq_layout.json");
+            let layout_path_c = CString::new(layout_path.to_str().unwrap()).unwrap();
+
+            let shard_dirs: Vec<String> = (0..2).map(|i| temp_dir.path().join(format!("shard_{}", i)).to_string_lossy().to_string()).collect();
+            let shard_dirs_c = CString::new(serde_json::to_string(&shard_dirs).unwrap()).unwrap();
+            let result = raptorq_shard_symbols(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                shard_dirs_c.as_ptr(),
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Sharding should succeed");
+            let map: ShardMap = serde_json::from_str(
+                &buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len())
+            ).unwrap();
+            assert_eq!(map.shard_dirs, shard_dirs);
+            assert_eq!(ShardMap::read_dir(&shard_dirs[1]).unwrap(), map);
+
+            // The shards decode together
+            let output_path = temp_dir.path().join("decoded.bin");
+            let result = raptorq_decode_from_dirs(
+                session_id,
+                shard_dirs_c.as_ptr(),
+                CString::new(output_path.to_str().unwrap()).unwrap().as_ptr(),
+                layout_path_c.as_ptr(),
+            );
+            assert_eq!(result, 0, "Decoding the shards should succeed");
+            assert_eq!(fs::read(&output_path).unwrap(), data);
+
+            for bad_json in ["[]", "not json", "[\"\"]"] {
+                let bad_json_c = CString::new(bad_json).unwrap();
+                let result = raptorq_shard_symbols(session_id, symbols_dir_c.as_ptr(), layout_path_c.as_ptr(), bad_json_c.as_ptr(), result_buffer.as_mut_ptr() as *mut c_char, result_buffer.len());
+                assert_eq!(result, -2, "{} should return -2", bad_json);
+            }
+            let result = raptorq_shard_symbols(999999, symbols_dir_c.as_ptr(), layout_path_c.as_ptr(), shard_dirs_c.as_ptr(), result_buffer.as_mut_ptr() as *mut c_char, result_buffer.len());
+            assert_eq!(result, -5, "Invalid session should return -5");
+
+            raptorq_free_session(session_id);
         }
-    
+
         #[test]
-        fn test_ffi_encode_success() {
+        fn test_ffi_audit_object() {
             let session_id = init_test_session();
             let temp_dir = tempdir().expect("Failed to create temp directory");
-            
-            // Create test input file
-            let input_path = create_temp_file(
-                temp_dir.path(),
-                "test_input.txt",
-                b"This is test content for encoding",
-            ).expect("Failed to create test input file");
-            
-            let output_dir = temp_dir.path().join("output");
-            fs::create_dir_all(&output_dir).expect("Failed to create output directory");
-            
-            // Buffer for result
-            let mut result_buffer = [0u8; 1024];
-            
+
+            let input_path = create_temp_file(temp_dir.path(), "input.bin", &vec![7u8; 3000])
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+            let symbols_dir_c = CString::new(symbols_dir.to_str().unwrap()).unwrap();
+
+            let mut result_buffer = [0u8; 4096];
             let result = raptorq_encode_file(
-                    session_id,
-                    CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
-                    CString::new(output_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
-                    0, // auto block size
-                    result_buffer.as_mut_ptr() as *mut c_char,
-                    result_buffer.len(),
-                );
-            
-            assert_eq!(result, 0, "Encoding should succeed with return code 0");
-            
-            // Verify result_buffer contains valid JSON
-            let result_json = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
-            let _parsed: serde_json::Value = serde_json::from_str(&result_json)
-                .expect("Result buffer should contain valid JSON");
-            
-            // Clean up
+                session_id,
+                CString::new(input_path.to_str().unwrap()).unwrap().as_ptr(),
+                symbols_dir_c.as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Encode should succeed");
+
+            let layout_path_c = CString::new(symbols_dir.join("_raptorq_layout.json").to_str().unwrap()).unwrap();
+            let result = raptorq_audit_object(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0);
+            let report: AuditReport = serde_json::from_str(
+                &buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len())
+            ).unwrap();
+            assert!(report.recoverable);
+            assert_eq!(report.blocks.len(), 1);
+            assert_eq!(report.blocks[0].available, report.blocks[0].listed);
+            assert_eq!(report.min_margin, report.blocks[0].margin);
+
+            let missing_dir_c = CString::new(temp_dir.path().join("missing").to_str().unwrap()).unwrap();
+            let result = raptorq_audit_object(
+                session_id,
+                missing_dir_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0);
+            let report: AuditReport = serde_json::from_str(
+                &buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len())
+            ).unwrap();
+            assert!(!report.recoverable);
+
+            let result = raptorq_audit_object(session_id, symbols_dir_c.as_ptr(), missing_dir_c.as_ptr(), result_buffer.as_mut_ptr() as *mut c_char, result_buffer.len());
+            assert_eq!(result, -12, "A missing layout should return -12");
+            let result = raptorq_audit_object(session_id, symbols_dir_c.as_ptr(), layout_path_c.as_ptr(), result_buffer.as_mut_ptr() as *mut c_char, 8);
+            assert_eq!(result, -4, "A small buffer should return -4");
+            let result = raptorq_audit_object(999999, symbols_dir_c.as_ptr(), layout_path_c.as_ptr(), result_buffer.as_mut_ptr() as *mut c_char, result_buffer.len());
+            assert_eq!(result, -5, "Invalid session should return -5");
+
             raptorq_free_session(session_id);
         }
-    
+
         #[test]
-        fn test_ffi_encode_file_not_found() {
+        fn test_ffi_symbol_inventory() {
             let session_id = init_test_session();
             let temp_dir = tempdir().expect("Failed to create temp directory");
-            
-            // Non-existent input file
-            let input_path = temp_dir.path().join("nonexistent.txt");
-            let output_dir = temp_dir.path().join("output");
-            fs::create_dir_all(&output_dir).expect("Failed to create output directory");
-            
-            // Buffer for result
-            let mut result_buffer = [0u8; 1024];
-            
+
+            let content: Vec<u8> = (0..3000).map(|i| (i * 31 / 7 % 251) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "input.bin", &content)
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+            let symbols_dir_c = CString::new(symbols_dir.to_str().unwrap()).unwrap();
+
+            let mut result_buffer = [0u8; 4096];
             let result = raptorq_encode_file(
                 session_id,
-                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
-                CString::new(output_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                CString::new(input_path.to_str().unwrap()).unwrap().as_ptr(),
+                symbols_dir_c.as_ptr(),
                 0,
                 result_buffer.as_mut_ptr() as *mut c_char,
                 result_buffer.len(),
             );
-            
-            assert_eq!(result, -12, "File not found should return -12");
-            
-            // Check error message
-            let mut error_buffer = [0u8; 1024];
-            raptorq_get_last_error(
-                    session_id,
-                    error_buffer.as_mut_ptr() as *mut c_char,
-                    error_buffer.len(),
-                );
-            
-            let error_msg = buffer_as_string(error_buffer.as_ptr() as *const c_char, error_buffer.len());
-            assert!(!error_msg.is_empty(), "Error message should not be empty");
-            
-            // Clean up
+            assert_eq!(result, 0, "Encode should succeed");
+
+            let layout_path = symbols_dir.join("_raptorq_layout.json");
+            let layout = RaptorQLayout::read_file(layout_path.to_str().unwrap()).unwrap();
+            fs::remove_file(symbols_dir.join("block_0").join(&layout.blocks[0].symbols[0])).unwrap();
+
+            let layout_path_c = CString::new(layout_path.to_str().unwrap()).unwrap();
+            let result = raptorq_symbol_inventory(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0);
+            let inventory: BTreeMap<usize, BlockInventory> = serde_json::from_str(
+                &buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len())
+            ).unwrap();
+            assert_eq!(inventory.len(), 1);
+            let block = inventory[&0];
+            assert_eq!(block.source_present, block.source_expected - 1);
+            assert_eq!(block.repair_present, block.repair_expected);
+            assert_eq!(block.source_expected, layout.blocks[0].source_symbols_count());
+
+            let result = raptorq_symbol_inventory(session_id, symbols_dir_c.as_ptr(), layout_path_c.as_ptr(), result_buffer.as_mut_ptr() as *mut c_char, 8);
+            assert_eq!(result, -4, "A small buffer should return -4");
+            let result = raptorq_symbol_inventory(999999, symbols_dir_c.as_ptr(), layout_path_c.as_ptr(), result_buffer.as_mut_ptr() as *mut c_char, result_buffer.len());
+            assert_eq!(result, -5, "Invalid session should return -5");
+
             raptorq_free_session(session_id);
         }
-    
+
         #[test]
-        fn test_ffi_encode_encoding_failed() {
+        fn test_ffi_scrub_symbols() {
+            extern "C" fn collect_issue(context: *mut c_void, issue_json: *const c_char) {
+                let issues = unsafe { &*(context as *const Mutex<Vec<LayoutIssue>>) };
+                let json = unsafe { CStr::from_ptr(issue_json) }.to_str().unwrap();
+                issues.lock().push(serde_json::from_str(json).unwrap());
+            }
+
             let session_id = init_test_session();
             let temp_dir = tempdir().expect("Failed to create temp directory");
-            
-            // Create an empty file (which might cause encoding to fail)
-            let input_path = create_temp_file(
-                temp_dir.path(),
-                "empty.txt",
-                b"", // Empty content
-            ).expect("Failed to create empty test file");
-            
-            let output_dir = temp_dir.path().join("output");
-            fs::create_dir_all(&output_dir).expect("Failed to create output directory");
-            
-            // Buffer for result
-            let mut result_buffer = [0u8; 1024];
-            
+            let input_path = create_temp_file(temp_dir.path(), "input.bin", &vec![7u8; 3000])
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+            let symbols_dir_c = CString::new(symbols_dir.to_str().unwrap()).unwrap();
+
+            let mut result_buffer = [0u8; 4096];
             let result = raptorq_encode_file(
                 session_id,
-                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
-                CString::new(output_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                CString::new(input_path.to_str().unwrap()).unwrap().as_ptr(),
+                symbols_dir_c.as_ptr(),
                 0,
                 result_buffer.as_mut_ptr() as *mut c_char,
                 result_buffer.len(),
             );
-            
-            // Note: This test may pass or fail depending on whether the RaptorQ
-            // implementation actually rejects empty files. If it handles them
-            // properly, we'll need to find another way to force an encoding error.
-            if result == -3 {
-                // Check error message if it failed
-                let mut error_buffer = [0u8; 1024];
-                raptorq_get_last_error(
-                    session_id,
-                    error_buffer.as_mut_ptr() as *mut c_char,
-                    error_buffer.len(),
-                );
-                
-                let error_msg = buffer_as_string(error_buffer.as_ptr() as *const c_char, error_buffer.len());
-                assert!(!error_msg.is_empty(), "Error message should not be empty");
-            }
-            
-            // Clean up
+            assert_eq!(result, 0, "Encode should succeed");
+
+            let layout_path = symbols_dir.join("_raptorq_layout.json");
+            let layout_path_c = CString::new(layout_path.to_str().unwrap()).unwrap();
+            let issues: Mutex<Vec<LayoutIssue>> = Mutex::new(Vec::new());
+            let context = &issues as *const _ as *mut c_void;
+            let result = raptorq_scrub_symbols(session_id, symbols_dir_c.as_ptr(), layout_path_c.as_ptr(), Some(collect_issue), context);
+            assert_eq!(result, 0);
+            assert!(issues.lock().is_empty());
+
+            let layout = RaptorQLayout::read_file(layout_path.to_str().unwrap()).unwrap();
+            let symbol_id = &layout.blocks[0].symbols[0];
+            let symbol_path = fs::read_dir(&symbols_dir).unwrap()
+                .map(|e| e.unwrap().path().join(symbol_id))
+                .find(|p| p.exists())
+                .unwrap();
+            fs::remove_file(symbol_path).unwrap();
+            let result = raptorq_scrub_symbols(session_id, symbols_dir_c.as_ptr(), layout_path_c.as_ptr(), Some(collect_issue), context);
+            assert_eq!(result, 0);
+            let found = issues.lock().clone();
+            assert_eq!(found.len(), 1);
+            assert_eq!(found[0].kind, LayoutIssueKind::MissingSymbol);
+            assert_eq!(found[0].symbol_id.as_ref(), Some(symbol_id));
+
+            let missing_layout_c = CString::new(temp_dir.path().join("missing.json").to_str().unwrap()).unwrap();
+            let result = raptorq_scrub_symbols(session_id, symbols_dir_c.as_ptr(), missing_layout_c.as_ptr(), Some(collect_issue), context);
+            assert_eq!(result, -12, "Missing layout should return -12");
+            assert_eq!(raptorq_scrub_symbols(session_id, symbols_dir_c.as_ptr(), layout_path_c.as_ptr(), None, context), -2);
+            assert_eq!(raptorq_scrub_symbols(999999, symbols_dir_c.as_ptr(), layout_path_c.as_ptr(), Some(collect_issue), context), -5);
+
             raptorq_free_session(session_id);
         }
-    
+
         #[test]
-        fn test_ffi_encode_result_buffer_too_small() {
+        fn test_ffi_validate_layout() {
             let session_id = init_test_session();
             let temp_dir = tempdir().expect("Failed to create temp directory");
-            
-            // Create test input file
-            let input_path = create_temp_file(
-                temp_dir.path(),
-                "test_input.txt",
-                b"This is test content for encoding with a buffer that's too small",
-            ).expect("Failed to create test input file");
-            
-            let output_dir = temp_dir.path().join("output");
-            fs::create_dir_all(&output_dir).expect("Failed to create output directory");
-            
-            // Very small result buffer
-            let mut result_buffer = [0u8; 5];
-            
+
+            let input_path = create_temp_file(temp_dir.path(), "input.bin", &vec![7u8; 3000])
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+            let symbols_dir_c = CString::new(symbols_dir.to_str().unwrap()).unwrap();
+
+            let mut result_buffer = [0u8; 4096];
             let result = raptorq_encode_file(
                 session_id,
-                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
-                CString::new(output_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                CString::new(input_path.to_str().unwrap()).unwrap().as_ptr(),
+                symbols_dir_c.as_ptr(),
                 0,
                 result_buffer.as_mut_ptr() as *mut c_char,
                 result_buffer.len(),
             );
-            
-            assert_eq!(result, -4, "Result buffer too small should return -4");
-            
-            // Clean up
+            assert_eq!(result, 0, "Encode should succeed");
+
+            let layout_path_c = CString::new(symbols_dir.join("_raptorq_layout.json").to_str().unwrap()).unwrap();
+            let result = raptorq_validate_layout(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0);
+            assert_eq!(buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len()), "[]");
+
+            let missing_dir_c = CString::new(temp_dir.path().join("missing").to_str().unwrap()).unwrap();
+            let result = raptorq_validate_layout(
+                session_id,
+                missing_dir_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0);
+            let issues: Vec<LayoutIssue> = serde_json::from_str(
+                &buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len())
+            ).unwrap();
+            assert_eq!(issues.len(), 1);
+            assert_eq!(issues[0].kind, LayoutIssueKind::MissingSymbolsDirectory);
+
+            let result = raptorq_validate_layout(
+                session_id,
+                missing_dir_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                result_buffer.as_mut_ptr() as *mut c_char,
+                2,
+            );
+            assert_eq!(result, -4, "Small buffer should return -4");
+
+            let missing_layout_c = CString::new(temp_dir.path().join("missing.json").to_str().unwrap()).unwrap();
+            let result = raptorq_validate_layout(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                missing_layout_c.as_ptr(),
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, -12, "Missing layout should return -12");
+
             raptorq_free_session(session_id);
+
+            let result = raptorq_validate_layout(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                layout_path_c.as_ptr(),
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, -5, "Invalid session should return -5");
         }
-    
+
         #[test]
-        fn test_ffi_encode_path_conversion_error() {
+        fn test_ffi_reconstruct_layout() {
             let session_id = init_test_session();
-            
-            // Create invalid UTF-8 C string (not easy in Rust, using a workaround)
-            // We can simulate this by creating a CString and manually manipulating it
-            let mut result_buffer = [0u8; 1024];
-            
-            // Instead of trying to create invalid UTF-8, we'll test the path conversion
-            // error branch indirectly by passing extremely long paths that would likely
-            // fail in CString::new
-            let very_long_path = "a".repeat(100000); // Extremely long path
-            
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+
+            let original_content: Vec<u8> = (0..5000).map(|i| (i % 199) as u8).collect();
+            let input_path = create_temp_file(temp_dir.path(), "input.bin", &original_content)
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+            let symbols_dir_c = CString::new(symbols_dir.to_str().unwrap()).unwrap();
+
+            let mut result_buffer = vec![0u8; 64 * 1024];
             let result = raptorq_encode_file(
                 session_id,
-                CString::new(very_long_path.clone()).unwrap_or(CString::new("x").unwrap()).as_ptr(),
-                CString::new("output").unwrap().as_ptr(),
-                0,
+                CString::new(input_path.to_str().unwrap()).unwrap().as_ptr(),
+                symbols_dir_c.as_ptr(),
+                2048,
                 result_buffer.as_mut_ptr() as *mut c_char,
                 result_buffer.len(),
             );
-            
-            // The result may be -1 (for path conversion error) or something else if the system
-            // accepts very long paths, but the important part is to exercise that code path
-            if result == -1 {
-                // Test successful - path conversion failed as expected
-            }
-            
-            // Clean up
-            raptorq_free_session(session_id);
-        }
-    
-        // Tests for raptorq_get_last_error
-        #[test]
-        fn test_ffi_get_error_null_buffer() {
-            let session_id = init_test_session();
-            
-            let result = raptorq_get_last_error(
+            assert_eq!(result, 0, "Encode should succeed");
+
+            // The layout is lost
+            let layout_path = symbols_dir.join("_raptorq_layout.json");
+            fs::remove_file(&layout_path).unwrap();
+
+            let result = raptorq_reconstruct_layout(
                 session_id,
-                ptr::null_mut(),
-                1024,
+                symbols_dir_c.as_ptr(),
+                original_content.len() as u64,
+                2048,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
             );
-            
-            assert_eq!(result, -1, "Null error buffer should return -1");
-            
-            // Clean up
-            raptorq_free_session(session_id);
-        }
-    
-        #[test]
-        fn test_ffi_get_error_invalid_session() {
-            let invalid_session_id = 99999;
-            let mut error_buffer = [0u8; 1024];
-            
-            let result = raptorq_get_last_error(
-                invalid_session_id,
-                error_buffer.as_mut_ptr() as *mut c_char,
-                error_buffer.len(),
+            assert_eq!(result, 0);
+            let layout_json = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
+            fs::write(&layout_path, layout_json).unwrap();
+
+            let decoded_path = temp_dir.path().join("decoded.bin");
+            let result = raptorq_decode_symbols(
+                session_id,
+                symbols_dir_c.as_ptr(),
+                CString::new(decoded_path.to_str().unwrap()).unwrap().as_ptr(),
+                CString::new(layout_path.to_str().unwrap()).unwrap().as_ptr(),
             );
-            
-            assert_eq!(result, -1, "Invalid session ID should return -1");
-        }
-    
-        #[test]
-        fn test_ffi_get_error_success_empty() {
-            let session_id = init_test_session();
-            let mut error_buffer = [0u8; 1024];
-            
-            // No prior error, should return empty string
-            let result = raptorq_get_last_error(
+            assert_eq!(result, 0, "The rebuilt layout should decode");
+            assert_eq!(fs::read(&decoded_path).unwrap(), original_content);
+
+            let result = raptorq_reconstruct_layout(
                 session_id,
-                error_buffer.as_mut_ptr() as *mut c_char,
-                error_buffer.len(),
+                symbols_dir_c.as_ptr(),
+                0,
+                2048,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
             );
-            
-            assert_eq!(result, 0, "Get error should return 0 for success");
-            
-            let error_msg = buffer_as_string(error_buffer.as_ptr() as *const c_char, error_buffer.len());
-            assert_eq!(error_msg, "", "Error buffer should contain empty string");
-            
-            // Clean up
+            assert_eq!(result, -2, "An empty object should return -2");
+
+            let missing_dir_c = CString::new(temp_dir.path().join("missing").to_str().unwrap()).unwrap();
+            let result = raptorq_reconstruct_layout(
+                session_id,
+                missing_dir_c.as_ptr(),
+                original_content.len() as u64,
+                2048,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, -13, "A missing directory should return -13");
+
             raptorq_free_session(session_id);
         }
-    
+
         #[test]
-        fn test_ffi_get_error_success_message() {
+        fn test_ffi_encode_files() {
             let session_id = init_test_session();
             let temp_dir = tempdir().expect("Failed to create temp directory");
-            
-            // Trigger an error first (file not found)
-            let input_path = temp_dir.path().join("nonexistent.txt");
-            let output_dir = temp_dir.path().join("output");
-            fs::create_dir_all(&output_dir).expect("Failed to create output directory");
-            
-            let mut result_buffer = [0u8; 1024];
-            
-            raptorq_encode_file(
+
+            let input_path = create_temp_file(temp_dir.path(), "input.bin", &vec![7u8; 3000])
+                .expect("Failed to create test input file");
+            let jobs = serde_json::json!([
+                {
+                    "input_path": input_path.to_str().unwrap(),
+                    "output_dir": temp_dir.path().join("symbols_0").to_str().unwrap(),
+                    "block_size": 0,
+                },
+                {
+                    "input_path": temp_dir.path().join("missing.bin").to_str().unwrap(),
+                    "output_dir": temp_dir.path().join("symbols_1").to_str().unwrap(),
+                },
+            ]);
+            let jobs_c = CString::new(jobs.to_string()).unwrap();
+
+            let mut result_buffer = vec![0u8; 16384];
+            let result = raptorq_encode_files(
                 session_id,
-                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
-                CString::new(output_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
-                0,
+                jobs_c.as_ptr(),
                 result_buffer.as_mut_ptr() as *mut c_char,
                 result_buffer.len(),
             );
-            
-            // Now get the error message
-            let mut error_buffer = [0u8; 1024];
-            
-            let result = raptorq_get_last_error(
+            assert_eq!(result, 0, "Encode files should succeed");
+
+            let result_str = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
+            let outcomes: serde_json::Value = serde_json::from_str(&result_str).unwrap();
+            let outcomes = outcomes.as_array().unwrap();
+            assert_eq!(outcomes.len(), 2);
+            assert_eq!(outcomes[0]["error_code"], 0);
+            assert!(outcomes[0]["result"]["total_symbols_count"].as_u64().unwrap() > 0);
+            assert_eq!(outcomes[1]["error_code"], -12);
+            assert!(outcomes[1]["error"].as_str().unwrap().contains("missing.bin"));
+            assert!(outcomes[1].get("result").is_none());
+
+            // Malformed jobs
+            let bad_jobs = CString::new(r#"[{"input_path": 1}]"#).unwrap();
+            let result = raptorq_encode_files(
                 session_id,
-                error_buffer.as_mut_ptr() as *mut c_char,
-                error_buffer.len(),
+                bad_jobs.as_ptr(),
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
             );
-            
-            assert_eq!(result, 0, "Get error should return 0 for success");
-            
-            let error_msg = buffer_as_string(error_buffer.as_ptr() as *const c_char, error_buffer.len());
-            assert!(!error_msg.is_empty(), "Error buffer should contain an error message");
-            
-            // Clean up
+            assert_eq!(result, -2, "Malformed jobs should return -2");
+
+            let result = raptorq_encode_files(
+                session_id,
+                jobs_c.as_ptr(),
+                result_buffer.as_mut_ptr() as *mut c_char,
+                16,
+            );
+            assert_eq!(result, -4, "Small buffer should return -4");
+
             raptorq_free_session(session_id);
+
+            let result = raptorq_encode_files(
+                session_id,
+                jobs_c.as_ptr(),
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, -5, "Invalid session should return -5");
         }
-    
+
         #[test]
-        fn test_ffi_get_error_buffer_too_small() {
+        fn test_ffi_decode_files() {
             let session_id = init_test_session();
             let temp_dir = tempdir().expect("Failed to create temp directory");
-            
-            // Trigger an error first (file not found)
-            let input_path = temp_dir.path().join("nonexistent.txt");
-            let output_dir = temp_dir.path().join("output");
-            fs::create_dir_all(&output_dir).expect("Failed to create output directory");
-            
-            let mut result_buffer = [0u8; 1024];
-            
-            raptorq_encode_file(
+
+            let input_path = create_temp_file(temp_dir.path(), "input.bin", &vec![7u8; 3000])
+                .expect("Failed to create test input file");
+            let symbols_dir = temp_dir.path().join("symbols");
+            let input_c = CString::new(input_path.to_str().unwrap()).unwrap();
+            let symbols_c = CString::new(symbols_dir.to_str().unwrap()).unwrap();
+            let mut result_buffer = vec![0u8; 16384];
+            let result = raptorq_encode_file(
                 session_id,
-                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
-                CString::new(output_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                input_c.as_ptr(),
+                symbols_c.as_ptr(),
                 0,
                 result_buffer.as_mut_ptr() as *mut c_char,
                 result_buffer.len(),
             );
-            
-            // Now get the error message with a small buffer
-            let mut small_error_buffer = [0u8; 5]; // Very small buffer
-            
-            let result = raptorq_get_last_error(
+            assert_eq!(result, 0, "Encode should succeed");
+
+            let output_path = temp_dir.path().join("output.bin");
+            let jobs = serde_json::json!([
+                {
+                    "symbols_dir": symbols_dir.to_str().unwrap(),
+                    "output_path": output_path.to_str().unwrap(),
+                    "layout_path": symbols_dir.join("_raptorq_layout.json").to_str().unwrap(),
+                },
+                {
+                    "symbols_dir": symbols_dir.to_str().unwrap(),
+                    "output_path": temp_dir.path().join("other.bin").to_str().unwrap(),
+                    "layout_path": temp_dir.path().join("missing.json").to_str().unwrap(),
+                },
+            ]);
+            let jobs_c = CString::new(jobs.to_string()).unwrap();
+
+            let result = raptorq_decode_files(
                 session_id,
-                small_error_buffer.as_mut_ptr() as *mut c_char,
-                small_error_buffer.len(),
+                jobs_c.as_ptr(),
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
             );
-            
-            assert_eq!(result, 0, "Get error should return 0 even with small buffer");
-            
-            // Verify buffer contains truncated, null-terminated message
-            let error_msg = buffer_as_string(small_error_buffer.as_ptr() as *const c_char, small_error_buffer.len());
-            assert!(error_msg.len() < 5, "Error should be truncated to buffer length");
-            
-            // Clean up
-            raptorq_free_session(session_id);
-        }
-        
-        // Tests for raptorq_decode_symbols
-        #[test]
-        fn test_ffi_decode_null_pointers() {
-            let session_id = init_test_session();
-            
-            // Test with null symbols_dir
-            let result = raptorq_decode_symbols(
+            assert_eq!(result, 0, "Decode files should succeed");
+
+            let result_str = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
+            let outcomes: serde_json::Value = serde_json::from_str(&result_str).unwrap();
+            let outcomes = outcomes.as_array().unwrap();
+            assert_eq!(outcomes.len(), 2);
+            assert_eq!(outcomes[0]["error_code"], 0);
+            assert!(outcomes[0].get("error").is_none());
+            assert_eq!(fs::read(&output_path).unwrap(), vec![7u8; 3000]);
+            assert_eq!(outcomes[1]["error_code"], -12);
+            assert!(outcomes[1]["error"].as_str().unwrap().contains("missing.json"));
+
+            let bad_jobs = CString::new(r#"[{"symbols_dir": "symbols"}]"#).unwrap();
+            let result = raptorq_decode_files(
                 session_id,
-                ptr::null(),
-                CString::new("output.txt").unwrap().as_ptr(),
-                CString::new("layout.json").unwrap().as_ptr(),
+                bad_jobs.as_ptr(),
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
             );
-            assert_eq!(result, -2, "Null symbols_dir should return -2");
-            
-            // Test with null output_path
-            let result = raptorq_decode_symbols(
+            assert_eq!(result, -2, "Malformed jobs should return -2");
+
+            let result = raptorq_decode_files(
                 session_id,
-                CString::new("symbols").unwrap().as_ptr(),
-                ptr::null(),
-                CString::new("layout.json").unwrap().as_ptr(),
+                jobs_c.as_ptr(),
+                result_buffer.as_mut_ptr() as *mut c_char,
+                16,
             );
-            assert_eq!(result, -2, "Null output_path should return -2");
-            
-            // Test with null layout_path
-            let result = raptorq_decode_symbols(
+            assert_eq!(result, -4, "Small buffer should return -4");
+
+            raptorq_free_session(session_id);
+
+            let result = raptorq_decode_files(
                 session_id,
-                CString::new("symbols").unwrap().as_ptr(),
-                CString::new("output.txt").unwrap().as_ptr(),
-                ptr::null(),
+                jobs_c.as_ptr(),
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
             );
-            assert_eq!(result, -2, "Null layout_path should return -2");
-            
-            // Clean up
+            assert_eq!(result, -5, "Invalid session should return -5");
+        }
+
+        #[test]
+        fn test_ffi_get_recommended_block_size_for() {
+            let session_id = raptorq_init_session(1024, 4, 16 * 1024, 8);
+            let mb = 1024 * 1024;
+
+            assert_eq!(raptorq_get_recommended_block_size_for(session_id, 400 * mb, 16 * 1024, 4), 100 * mb as usize);
+            assert_eq!(
+                raptorq_get_recommended_block_size_for(session_id, 12000 * mb, 0, 1),
+                raptorq_get_recommended_block_size(session_id, 12000 * mb)
+            );
+
             raptorq_free_session(session_id);
+            assert_eq!(raptorq_get_recommended_block_size_for(session_id, 400 * mb, 16 * 1024, 4), 0, "Invalid session should return 0");
         }
-    
+
         #[test]
-        fn test_ffi_decode_invalid_session() {
-            let invalid_session_id = 99999;
-            
-            let result = raptorq_decode_symbols(
-                invalid_session_id,
-                CString::new("symbols").unwrap().as_ptr(),
-                CString::new("output.txt").unwrap().as_ptr(),
-                CString::new("layout.json").unwrap().as_ptr(),
+        fn test_ffi_get_max_block_size() {
+            let session_id = raptorq_init_session(8, 4, 16 * 1024, 8);
+            let max_block_size = raptorq_get_max_block_size(session_id);
+            assert_eq!(max_block_size, 255 * 56403 * 8);
+            assert!(raptorq_get_recommended_block_size(session_id, 4 << 30) as u64 <= max_block_size);
+            assert!(raptorq_get_recommended_block_size_for(session_id, u64::MAX, 0, 1) as u64 <= max_block_size);
+
+            let temp_dir = tempdir().expect("Failed to create temp directory");
+            let input_path = temp_dir.path().join("original.bin");
+            // Sparse, the encode is rejected before reading it
+            let file = std::fs::File::create(&input_path).expect("Failed to create test input file");
+            file.set_len(max_block_size + 16).expect("Failed to size test input file");
+            drop(file);
+            let symbols_dir = temp_dir.path().join("symbols");
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let result = raptorq_encode_file(
+                session_id,
+                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                max_block_size as usize + 8,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
             );
-            
-            assert_eq!(result, -5, "Invalid session ID should return -5");
+            assert_eq!(result, -2, "A block size over the limit should return -2");
+
+            raptorq_free_session(session_id);
+            assert_eq!(raptorq_get_max_block_size(session_id), 0, "Invalid session should return 0");
+        }
+
+        #[test]
+        fn test_ffi_get_recommended_redundancy() {
+            let session_id = init_test_session();
+
+            let mut redundancy_factor: u8 = 0;
+            let result = raptorq_get_recommended_redundancy(session_id, 1024 * 1024, 0.5, &mut redundancy_factor);
+            assert_eq!(result, 0);
+            assert_eq!(redundancy_factor, 3);
+
+            let result = raptorq_get_recommended_redundancy(session_id, 1024 * 1024, 1.5, &mut redundancy_factor);
+            assert_eq!(result, -2, "Fraction out of range should return -2");
+
+            let result = raptorq_get_recommended_redundancy(session_id, 1024 * 1024, 0.5, ptr::null_mut());
+            assert_eq!(result, -2, "Null output should return -2");
+
+            raptorq_free_session(session_id);
+
+            let result = raptorq_get_recommended_redundancy(session_id, 1024 * 1024, 0.5, &mut redundancy_factor);
+            assert_eq!(result, -5, "Invalid session should return -5");
+        }
+
+        #[test]
+        fn test_ffi_estimate_peak_memory() {
+            let session_id = init_test_session();
+
+            let mut small: u64 = 0;
+            let mut large: u64 = 0;
+            assert_eq!(raptorq_estimate_peak_memory(session_id, 1024 * 1024, 0, &mut small), 0);
+            assert_eq!(raptorq_estimate_peak_memory(session_id, 4 * 1024 * 1024, 0, &mut large), 0);
+            assert!(small > 1024 * 1024);
+            assert!(large > small);
+
+            let result = raptorq_estimate_peak_memory(session_id, 0, 0, &mut small);
+            assert_eq!(result, -2, "Empty file should return -2");
+
+            let result = raptorq_estimate_peak_memory(session_id, 1024, 0, ptr::null_mut());
+            assert_eq!(result, -2, "Null output should return -2");
+
+            raptorq_free_session(session_id);
+
+            let result = raptorq_estimate_peak_memory(session_id, 1024, 0, &mut small);
+            assert_eq!(result, -5, "Invalid session should return -5");
+        }
+
+        #[test]
+        fn test_ffi_estimate_storage() {
+            let session_id = init_test_session();
+
+            let mut symbol_bytes: u64 = 0;
+            let mut overhead_fraction: f64 = 0.0;
+            assert_eq!(raptorq_estimate_storage(session_id, 1024 * 1024, 0, &mut symbol_bytes, &mut overhead_fraction), 0);
+            let estimate = get_processor(session_id).unwrap().estimate_storage(1024 * 1024, 0).unwrap();
+            assert_eq!(symbol_bytes, estimate.symbol_bytes);
+            assert_eq!(overhead_fraction, estimate.overhead_fraction);
+            assert!(symbol_bytes > 1024 * 1024);
+
+            assert_eq!(raptorq_estimate_storage(session_id, 1024, 0, &mut symbol_bytes, ptr::null_mut()), 0);
+            let result = raptorq_estimate_storage(session_id, 0, 0, &mut symbol_bytes, ptr::null_mut());
+            assert_eq!(result, -2, "Empty file should return -2");
+            let result = raptorq_estimate_storage(session_id, 1024, 0, ptr::null_mut(), ptr::null_mut());
+            assert_eq!(result, -2, "Null output should return -2");
+
+            raptorq_free_session(session_id);
+
+            let result = raptorq_estimate_storage(session_id, 1024, 0, &mut symbol_bytes, ptr::null_mut());
+            assert_eq!(result, -5, "Invalid session should return -5");
         }
-    
+
         #[test]
-        fn test_ffi_decode_layout_file_not_found() {
+        fn test_ffi_plan_encode() {
             let session_id = init_test_session();
             let temp_dir = tempdir().expect("Failed to create temp directory");
-            
-            // Valid symbols directory but non-existent layout file
-            let symbols_dir = temp_dir.path();
-            let output_path = temp_dir.path().join("output.txt");
-            let layout_path = temp_dir.path().join("nonexistent_layout.json");
-            
-            let result = raptorq_decode_symbols(
+            let input_path = create_temp_file(temp_dir.path(), "input.bin", &vec![7u8; 5000])
+                .expect("Failed to create test input file");
+            let input_path_c = CString::new(input_path.to_str().unwrap()).unwrap();
+
+            let mut result_buffer = [0u8; 4096];
+            let result = raptorq_plan_encode(
                 session_id,
-                CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
-                CString::new(output_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
-                CString::new(layout_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                input_path_c.as_ptr(),
+                2048,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
             );
-            
-            // Should return -2 for file not found or -3 for decoding failed
-            assert!(result == -12, "Layout file not found should return -12");
-            
-            // Clean up
+            assert_eq!(result, 0, "Plan should succeed");
+            let plan_json = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
+            let plan: LayoutSummary = serde_json::from_str(&plan_json).unwrap();
+            assert_eq!(plan.total_size, 5000);
+            let sizes: Vec<_> = plan.blocks.iter().map(|b| b.size).collect();
+            assert_eq!(sizes, vec![2048, 2048, 904]);
+            assert_eq!(fs::read_dir(temp_dir.path()).unwrap().count(), 1, "Nothing should be written");
+
+            let mut small_buffer = [0u8; 8];
+            let result = raptorq_plan_encode(
+                session_id,
+                input_path_c.as_ptr(),
+                2048,
+                small_buffer.as_mut_ptr() as *mut c_char,
+                small_buffer.len(),
+            );
+            assert_eq!(result, -4, "Small buffer should return -4");
+
+            let missing_c = CString::new(temp_dir.path().join("missing.bin").to_str().unwrap()).unwrap();
+            let result = raptorq_plan_encode(
+                session_id,
+                missing_c.as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, -12, "Missing file should return -12");
+
             raptorq_free_session(session_id);
         }
-    
+
         #[test]
-        fn test_ffi_decode_success() {
-            // This test would typically require:
-            // 1. First encoding a file to generate symbols
-            // 2. Then decoding those symbols back to a file
-            // 3. Verifying the output file matches the input
-            
+        fn test_ffi_describe_encode() {
             let session_id = init_test_session();
             let temp_dir = tempdir().expect("Failed to create temp directory");
-            
-            // Create original content for comparison later
-            let original_content = b"This is test content for the RaptorQ encoding/decoding test";
-            let input_path = create_temp_file(
-                temp_dir.path(),
-                "original.txt",
-                original_content,
-            ).expect("Failed to create test input file");
-            
-            // Set up directories
-            let symbols_dir = temp_dir.path().join("symbols");
-            fs::create_dir_all(&symbols_dir).expect("Failed to create symbols directory");
-            let output_path = temp_dir.path().join("decoded.txt");
-            
-            // Encode the file to generate symbols
-            let mut result_buffer = [0u8; 1024];
-            
-            let encode_result = raptorq_encode_file(
+            let input_path = create_temp_file(temp_dir.path(), "input.bin", &vec![7u8; 5000])
+                .expect("Failed to create test input file");
+            let input_path_c = CString::new(input_path.to_str().unwrap()).unwrap();
+
+            let mut result_buffer = [0u8; 4096];
+            let result = raptorq_describe_encode(
                 session_id,
-                CString::new(input_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
-                CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                input_path_c.as_ptr(),
+                2048,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0, "Describe should succeed");
+            let description = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
+            assert!(description.contains("object size: 5000 bytes"), "{}", description);
+            assert!(description.contains("blocks: 3 of up to 2048 bytes"), "{}", description);
+            assert!(description.contains("  block 2: offset 4096, 904 bytes"), "{}", description);
+
+            let mut small_buffer = [0u8; 8];
+            let result = raptorq_describe_encode(
+                session_id,
+                input_path_c.as_ptr(),
+                2048,
+                small_buffer.as_mut_ptr() as *mut c_char,
+                small_buffer.len(),
+            );
+            assert_eq!(result, -4, "Small buffer should return -4");
+
+            let missing_c = CString::new(temp_dir.path().join("missing.bin").to_str().unwrap()).unwrap();
+            let result = raptorq_describe_encode(
+                session_id,
+                missing_c.as_ptr(),
                 0,
                 result_buffer.as_mut_ptr() as *mut c_char,
                 result_buffer.len(),
             );
-            
-            if encode_result == 0 {
-                // Encoding succeeded, now try decoding
-                
-                // Get the path to the layout file (should be in symbols_dir)
-                let layout_path = symbols_dir.join("_raptorq_layout.json");
-                assert!(layout_path.exists(), "Layout file should exist after encoding");
-                
-                let decode_result = raptorq_decode_symbols(
-                    session_id,
-                    CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
-                    CString::new(output_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
-                    CString::new(layout_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
-                );
-                
-                // Ideally, this would return 0 (success)
-                // But since we're using placeholder encoder params, it might fail
-                // The important part is exercising the code path
-                
-                if decode_result == 0 {
-                    // If it succeeded, verify the output file matches the input
-                    let decoded_content = fs::read(&output_path).expect("Failed to read decoded file");
-                    assert_eq!(decoded_content, original_content, "Decoded content should match original");
-                }
-            }
-            
-            // Clean up
+            assert_eq!(result, -12, "Missing file should return -12");
+
             raptorq_free_session(session_id);
+
+            let result = raptorq_describe_encode(
+                session_id,
+                input_path_c.as_ptr(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, -5, "Invalid session should return -5");
         }
-    
+
         #[test]
-        fn test_ffi_decode_file_not_found() {
+        fn test_error_codes() {
+            let cases = [
+                (ProcessError::IOError(io::Error::new(io::ErrorKind::Other, "io")), -11),
+                (ProcessError::FileNotFound("file".to_string()), -12),
+                (ProcessError::InvalidPath("path".to_string()), -13),
+                (ProcessError::EncodingFailed("encoding".to_string()), -14),
+                (ProcessError::DecodingFailed("decoding".to_string()), -15),
+                (ProcessError::MemoryLimitExceeded { required: 2, available: 1 }, -16),
+                (ProcessError::ConcurrencyLimitReached, -17),
+                (ProcessError::InsufficientSymbols(Vec::new()), -18),
+                (ProcessError::Cancelled, -19),
+                (ProcessError::SymbolNotFound { block_id: 0, esi: 1 }, -20),
+                (ProcessError::TimedOut(Duration::from_secs(1)), -21),
+                (ProcessError::ObjectMismatch { expected: "a".to_string(), found: "b".to_string() }, -22),
+                (ProcessError::UnsupportedLayoutVersion { version: 2, supported: 1 }, -23),
+                (ProcessError::InvalidConfig("config".to_string()), -2),
+                (ProcessError::InvalidParameter("parameter".to_string()), -2),
+            ];
+            for (error, code) in cases {
+                assert_eq!(error_code(&error), code, "Unexpected code for {:?}", error);
+            }
+        }
+
+        // Tests for raptorq_decode_bytes
+        #[test]
+        fn test_ffi_decode_bytes_roundtrip() {
             let session_id = init_test_session();
-            let temp_dir = tempdir().expect("Failed to create temp directory");
-            
-            // Non-existent symbols directory
-            let symbols_dir = temp_dir.path().join("nonexistent_symbols");
-            let output_path = temp_dir.path().join("decoded.txt");
-            let layout_path = temp_dir.path().join("layout.json");
-            
-            let result = raptorq_decode_symbols(
+            let data: Vec<u8> = (0..10 * 1024).map(|i| (i % 251) as u8).collect();
+
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let mut symbols_ptr: *mut u8 = ptr::null_mut();
+            let mut symbols_len: usize = 0;
+            let result = raptorq_encode_bytes(
                 session_id,
-                CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
-                CString::new(output_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
-                CString::new(layout_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                data.as_ptr(),
+                data.len(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+                &mut symbols_ptr,
+                &mut symbols_len,
             );
-            
-            assert_eq!(result, -12, "File not found should return -12");
-            
-            // Check error message
-            let mut error_buffer = [0u8; 1024];
-            raptorq_get_last_error(
+            assert_eq!(result, 0, "Encoding should succeed");
+
+            let result_json = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
+            let process_result: ProcessResult = serde_json::from_str(&result_json).unwrap();
+            let layout = process_result.layout_content.unwrap();
+
+            let mut output_ptr: *mut u8 = ptr::null_mut();
+            let mut output_len: usize = 0;
+            let result = raptorq_decode_bytes(
                 session_id,
-                error_buffer.as_mut_ptr() as *mut c_char,
-                error_buffer.len(),
+                symbols_ptr,
+                symbols_len,
+                layout.as_ptr(),
+                layout.len(),
+                &mut output_ptr,
+                &mut output_len,
             );
-            
-            let error_msg = buffer_as_string(error_buffer.as_ptr() as *const c_char, error_buffer.len());
-            assert!(!error_msg.is_empty(), "Error message should not be empty");
-            
-            // Clean up
+            assert_eq!(result, 0, "Decoding should succeed");
+
+            let decoded = unsafe { std::slice::from_raw_parts(output_ptr, output_len) }.to_vec();
+            assert_eq!(decoded, data);
+
+            raptorq_free_buffer(output_ptr, output_len);
+            raptorq_free_buffer(symbols_ptr, symbols_len);
             raptorq_free_session(session_id);
         }
-    
+
         #[test]
-        fn test_ffi_decode_decoding_failed() {
+        fn test_ffi_oti_roundtrip() {
             let session_id = init_test_session();
+            let data: Vec<u8> = (0..10 * 1024).map(|i| (i % 251) as u8).collect();
+
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let mut symbols_ptr: *mut u8 = ptr::null_mut();
+            let mut symbols_len: usize = 0;
+            let result = raptorq_encode_bytes(
+                session_id,
+                data.as_ptr(),
+                data.len(),
+                0,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+                &mut symbols_ptr,
+                &mut symbols_len,
+            );
+            assert_eq!(result, 0, "Encoding should succeed");
+
+            let result_json = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
+            let process_result: ProcessResult = serde_json::from_str(&result_json).unwrap();
+
             let temp_dir = tempdir().expect("Failed to create temp directory");
-            
-            // Create an empty symbols directory (which should cause decoding to fail)
-            let symbols_dir = temp_dir.path().join("empty_symbols");
-            fs::create_dir_all(&symbols_dir).expect("Failed to create empty symbols directory");
-            
-            let output_path = temp_dir.path().join("decoded.txt");
-            
-            // Create an empty layout file that won't have valid content
-            let layout_path = temp_dir.path().join("empty_layout.json");
-            File::create(&layout_path).expect("Failed to create empty layout file");
-            
-            let result = raptorq_decode_symbols(
+            let layout_path = create_temp_file(
+                temp_dir.path(),
+                "layout.json",
+                process_result.layout_content.unwrap().as_bytes(),
+            ).expect("Failed to write the layout");
+            let layout_path_c = CString::new(layout_path.to_str().unwrap()).unwrap();
+
+            let mut oti_buffer = [0u8; 1024];
+            let result = raptorq_get_oti(
                 session_id,
-                CString::new(symbols_dir.to_string_lossy().as_ref()).unwrap().as_ptr(),
-                CString::new(output_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
-                CString::new(layout_path.to_string_lossy().as_ref()).unwrap().as_ptr(),
+                layout_path_c.as_ptr(),
+                oti_buffer.as_mut_ptr() as *mut c_char,
+                oti_buffer.len(),
             );
-            
-            // Expect -3 for decoding failure, but other values are possible depending on implementation
-            if result == -3 {
-                // Check error message
-                let mut error_buffer = [0u8; 1024];
-                raptorq_get_last_error(
-                    session_id,
-                    error_buffer.as_mut_ptr() as *mut c_char,
-                    error_buffer.len(),
-                );
-                
-                let error_msg = buffer_as_string(error_buffer.as_ptr() as *const c_char, error_buffer.len());
-                assert!(!error_msg.is_empty(), "Error message should not be empty");
-            }
-            
-            // Clean up
+            assert_eq!(result, 0);
+            let oti_json = buffer_as_string(oti_buffer.as_ptr() as *const c_char, oti_buffer.len());
+            let otis: Vec<BlockOti> = serde_json::from_str(&oti_json).unwrap();
+            assert_eq!(otis.len(), 1);
+            assert_eq!(otis[0].transfer_length, data.len() as u64);
+            assert_eq!(otis[0].symbol_size, 1024);
+
+            // The symbols decode with the OTI alone
+            let mut output_ptr: *mut u8 = ptr::null_mut();
+            let mut output_len: usize = 0;
+            let result = raptorq_decode_with_oti(
+                session_id,
+                otis[0].oti.as_ptr(),
+                otis[0].oti.len(),
+                symbols_ptr,
+                symbols_len,
+                &mut output_ptr,
+                &mut output_len,
+            );
+            assert_eq!(result, 0, "Decoding should succeed");
+            let decoded = unsafe { std::slice::from_raw_parts(output_ptr, output_len) }.to_vec();
+            assert_eq!(decoded, data);
+            raptorq_free_buffer(output_ptr, output_len);
+
+            let result = raptorq_decode_with_oti(
+                session_id,
+                otis[0].oti.as_ptr(),
+                4,
+                symbols_ptr,
+                symbols_len,
+                &mut output_ptr,
+                &mut output_len,
+            );
+            assert_eq!(result, -2, "Short OTI should return -2");
+            assert!(output_ptr.is_null());
+
+            let mut no_source_blocks = otis[0].oti.clone();
+            no_source_blocks[8] = 0;
+            let result = raptorq_decode_with_oti(
+                session_id,
+                no_source_blocks.as_ptr(),
+                no_source_blocks.len(),
+                symbols_ptr,
+                symbols_len,
+                &mut output_ptr,
+                &mut output_len,
+            );
+            assert_eq!(result, -2, "An OTI with no source blocks should return -2");
+            assert!(output_ptr.is_null());
+
+            let result = raptorq_get_oti(
+                session_id,
+                layout_path_c.as_ptr(),
+                oti_buffer.as_mut_ptr() as *mut c_char,
+                8,
+            );
+            assert_eq!(result, -4, "Small buffer should return -4");
+
+            raptorq_free_buffer(symbols_ptr, symbols_len);
             raptorq_free_session(session_id);
         }
-    
+
         #[test]
-        fn test_ffi_decode_path_conversion_error() {
+        fn test_ffi_oti_to_layout() {
+            let data: Vec<u8> = (0..25_000).map(|i| (i * 31 / 7 % 251) as u8).collect();
+            let processor = RaptorQProcessor::builder().symbol_size(1024).build().unwrap();
+            let (result, _) = processor.encode_bytes(&data, 10_000).unwrap();
+            let layout = result.layout.unwrap();
+            let oti_json = CString::new(serde_json::to_string(&layout.to_oti().unwrap()).unwrap()).unwrap();
+
+            let mut result_buffer = vec![0u8; 64 * 1024];
+            let result = raptorq_oti_to_layout(
+                oti_json.as_ptr(),
+                data.len() as u64,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, 0);
+            let layout_json = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
+            let converted: RaptorQLayout = serde_json::from_str(&layout_json).unwrap();
+            assert_eq!(converted.blocks.len(), 3);
+            assert_eq!(converted.blocks[2].encoder_parameters, layout.blocks[2].encoder_parameters);
+            assert_eq!(converted.blocks[2].original_offset, 20_000);
+
+            let result = raptorq_oti_to_layout(
+                oti_json.as_ptr(),
+                data.len() as u64 - 1,
+                result_buffer.as_mut_ptr() as *mut c_char,
+                result_buffer.len(),
+            );
+            assert_eq!(result, -2, "OTIs not adding up to the object size should return -2");
+            let invalid_json = CString::new("[{\"block_id\":0}]").unwrap();
+            let result = raptorq_oti_to_layout(invalid_json.as_ptr(), 0, result_buffer.as_mut_ptr() as *mut c_char, result_buffer.len());
+            assert_eq!(result, -2, "Malformed OTIs should return -2");
+            let result = raptorq_oti_to_layout(oti_json.as_ptr(), data.len() as u64, result_buffer.as_mut_ptr() as *mut c_char, 16);
+            assert_eq!(result, -4, "Small buffer should return -4");
+
+            // Symbols are named by their ids
+            let symbol = [0u8, 0, 0, 3, 42, 43];
+            let mut id_buffer = [0u8; 128];
+            assert_eq!(raptorq_get_symbol_id(symbol.as_ptr(), symbol.len(), id_buffer.as_mut_ptr() as *mut c_char, id_buffer.len()), 0);
+            assert_eq!(buffer_as_string(id_buffer.as_ptr() as *const c_char, id_buffer.len()), symbol_id(&symbol));
+            assert_eq!(raptorq_get_symbol_id(symbol.as_ptr(), symbol.len(), id_buffer.as_mut_ptr() as *mut c_char, 4), -4);
+            assert_eq!(raptorq_get_symbol_id(ptr::null(), 0, id_buffer.as_mut_ptr() as *mut c_char, id_buffer.len()), -2);
+        }
+
+        #[test]
+        fn test_ffi_decode_bytes_truncated_symbols() {
             let session_id = init_test_session();
-            
-            // Use extremely long path that might fail in CString::new
-            let very_long_path = "a".repeat(100000);
-            
-            let result = raptorq_decode_symbols(
+            let layout = b"{}";
+
+            // Record announces more bytes than available
+            let packed = [10u8, 0, 0, 0, 1, 2, 3];
+            let mut output_ptr: *mut u8 = ptr::null_mut();
+            let mut output_len: usize = 0;
+            let result = raptorq_decode_bytes(
                 session_id,
-                CString::new(very_long_path.clone()).unwrap_or(CString::new("x").unwrap()).as_ptr(),
-                CString::new("output.txt").unwrap().as_ptr(),
-                CString::new("layout.json").unwrap().as_ptr(),
+                packed.as_ptr(),
+                packed.len(),
+                layout.as_ptr(),
+                layout.len(),
+                &mut output_ptr,
+                &mut output_len,
             );
-            
-            // Expect -1 if path conversion failed, but other values are possible
-            // The important part is to exercise that code path
-            if result == -1 {
-                // Test successful - path conversion failed as expected
-            }
-            
-            // Clean up
+            assert_eq!(result, -2, "Truncated symbols should return -2");
+            assert!(output_ptr.is_null());
+            assert_eq!(output_len, 0);
+
+            let mut error_buffer = [0u8; 256];
+            raptorq_get_last_error(session_id, error_buffer.as_mut_ptr() as *mut c_char, error_buffer.len());
+            let error_msg = buffer_as_string(error_buffer.as_ptr() as *const c_char, error_buffer.len());
+            assert!(error_msg.contains("truncated"), "{}", error_msg);
+
             raptorq_free_session(session_id);
         }
-        
+
         // Tests for raptorq_create_metadata
         #[test]
         fn test_ffi_create_metadata_success() {
@@ -1220,6 +11978,24 @@ mod ffi_tests {
             // Verify the result buffer contains JSON with layout_content
             let result_str = buffer_as_string(result_buffer.as_ptr() as *const c_char, result_buffer.len());
             assert!(result_str.contains("layout_content"), "Result should contain layout_content");
+
+            // The parsed layout is left out of the JSON, which holds it once as layout_content
+            let process_result: ProcessResult = serde_json::from_str(&result_str).unwrap();
+            assert!(process_result.layout.is_none());
+
+            // and is parsed from it with raptorq_parse_layout_content
+            let layout_content = process_result.layout_content.unwrap();
+            let mut summary_buffer = [0u8; 2048];
+            let summary_ptr = summary_buffer.as_mut_ptr() as *mut c_char;
+            assert_eq!(raptorq_parse_layout_content(layout_content.as_ptr(), layout_content.len(), summary_ptr, summary_buffer.len()), 0);
+            let summary: LayoutSummary = serde_json::from_str(&buffer_as_string(summary_ptr, summary_buffer.len())).unwrap();
+            assert_eq!(summary.total_size, 61);
+            assert_eq!(summary.blocks.len(), 1);
+            assert_eq!(summary.blocks[0].size, 61);
+
+            assert_eq!(raptorq_parse_layout_content(layout_content.as_ptr(), layout_content.len(), summary_ptr, 8), -4);
+            assert_eq!(raptorq_parse_layout_content(ptr::null(), 0, summary_ptr, summary_buffer.len()), -2);
+            assert_eq!(raptorq_parse_layout_content(b"not a layout".as_ptr(), 12, summary_ptr, summary_buffer.len()), -15);
             
             // Verify layout file was NOT written
             let layout_path = output_dir.join("_raptorq_layout.json");
@@ -1359,6 +12135,35 @@ mod ffi_tests {
             assert!(version_str.contains("RaptorQ Library"), "Version string should contain library name");
         }
     
+        #[test]
+        fn test_ffi_version_info() {
+            let mut buffer = [0u8; 1024];
+            let result = raptorq_version_info(buffer.as_mut_ptr() as *mut c_char, buffer.len());
+            assert_eq!(result, 0, "Valid buffer should return 0");
+
+            let info: serde_json::Value =
+                serde_json::from_str(&buffer_as_string(buffer.as_ptr() as *const c_char, buffer.len())).unwrap();
+            assert_eq!(info["version"], env!("CARGO_PKG_VERSION"));
+            assert_eq!(info["abi_version"], RAPTORQ_ABI_VERSION);
+            assert!(!info["build_hash"].as_str().unwrap().is_empty());
+
+            let result = raptorq_version_info(buffer.as_mut_ptr() as *mut c_char, 10);
+            assert_eq!(result, -4, "Buffer too small should return -4");
+            let result = raptorq_version_info(ptr::null_mut(), 1024);
+            assert_eq!(result, -2, "Null buffer should return -2");
+        }
+
+        #[test]
+        fn test_ffi_self_test() {
+            assert!(self_test().is_ok());
+
+            let mut error_buffer = [0u8; 256];
+            let result = raptorq_self_test(error_buffer.as_mut_ptr() as *mut c_char, error_buffer.len());
+            assert_eq!(result, 0, "The self-test should pass");
+            assert_eq!(error_buffer[0], 0, "No error should be written");
+            assert_eq!(raptorq_self_test(ptr::null_mut(), 0), 0, "The error buffer is optional");
+        }
+    
     fn init_test_session() -> usize {
         // Using reasonable default values for testing
         raptorq_init_session(1024, 10, 1024, 4)
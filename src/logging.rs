@@ -0,0 +1,168 @@
+//! Structured logging of encode and decode operations
+//!
+//! A processor reports the lifecycle of its operations to a `ProcessorLogger`:
+//! a `started` event at debug level, then a `completed` or `failed` event at info
+//! level with the object size, block and symbol counts and the duration.
+//! Events serialize to JSON so they can be forwarded to any log pipeline.
+//! Processors have no logger by default, so nothing is measured or emitted.
+
+use serde::Serialize;
+use std::sync::Arc;
+use std::time::Instant;
+
+/// Receives the events of the operations of a processor
+///
+/// Both methods do nothing by default. They are called from the thread running
+/// the operation, so they should return quickly.
+pub trait ProcessorLogger: Send + Sync {
+    fn debug(&self, _event: &OperationEvent) {}
+
+    fn info(&self, _event: &OperationEvent) {}
+}
+
+/// Logger ignoring every event
+pub struct NoopLogger;
+
+impl ProcessorLogger for NoopLogger {}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "lowercase")]
+pub enum OperationStage {
+    Started,
+    Completed,
+    Failed,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct OperationEvent {
+    /// Name of the processor method, e.g. `encode_file`
+    pub operation: &'static str,
+    pub stage: OperationStage,
+    /// Input file of an encode, layout file of a decode
+    pub path: String,
+    /// Size of the original data in bytes
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub object_size: Option<u64>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub blocks: Option<usize>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub symbols: Option<u64>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub duration_ms: Option<u64>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub error: Option<String>,
+}
+
+impl OperationEvent {
+    pub fn to_json(&self) -> String {
+        serde_json::to_string(self).unwrap_or_default()
+    }
+}
+
+/// Counts of a finished operation
+#[derive(Debug, Default, Clone, Copy)]
+pub(crate) struct OperationStats {
+    pub object_size: u64,
+    pub blocks: usize,
+    pub symbols: u64,
+}
+
+/// Operation being logged, started by `OperationLog::start`
+pub(crate) struct OperationLog {
+    logger: Arc<dyn ProcessorLogger>,
+    operation: &'static str,
+    path: String,
+    started: Instant,
+}
+
+impl OperationLog {
+    pub fn start(logger: Arc<dyn ProcessorLogger>, operation: &'static str, path: &str) -> Self {
+        let log = Self { logger, operation, path: path.to_string(), started: Instant::now() };
+        log.logger.debug(&log.event(OperationStage::Started));
+        log
+    }
+
+    /// Log the end of the operation, with the counts of `stats` if it succeeded
+    pub fn finish<T, E: ToString>(self, result: &Result<T, E>, stats: impl FnOnce(&T) -> OperationStats) {
+        let mut event = self.event(OperationStage::Completed);
+        event.duration_ms = Some(self.started.elapsed().as_millis() as u64);
+        match result {
+            Ok(value) => {
+                let stats = stats(value);
+                event.object_size = Some(stats.object_size);
+                event.blocks = Some(stats.blocks);
+                event.symbols = Some(stats.symbols);
+            }
+            Err(e) => {
+                event.stage = OperationStage::Failed;
+                event.error = Some(e.to_string());
+            }
+        }
+        self.logger.info(&event);
+    }
+
+    fn event(&self, stage: OperationStage) -> OperationEvent {
+        OperationEvent {
+            operation: self.operation,
+            stage,
+            path: self.path.clone(),
+            object_size: None,
+            blocks: None,
+            symbols: None,
+            duration_ms: None,
+            error: None,
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use parking_lot::Mutex;
+
+    #[derive(Default)]
+    struct RecordingLogger {
+        events: Mutex<Vec<(&'static str, OperationEvent)>>,
+    }
+
+    impl ProcessorLogger for RecordingLogger {
+        fn debug(&self, event: &OperationEvent) {
+            self.events.lock().push(("debug", event.clone()));
+        }
+
+        fn info(&self, event: &OperationEvent) {
+            self.events.lock().push(("info", event.clone()));
+        }
+    }
+
+    #[test]
+    fn test_operation_log() {
+        let logger = Arc::new(RecordingLogger::default());
+
+        let log = OperationLog::start(logger.clone(), "encode_file", "input.bin");
+        let result: Result<u32, String> = Ok(7);
+        log.finish(&result, |_| OperationStats { object_size: 100, blocks: 2, symbols: 7 });
+
+        let log = OperationLog::start(logger.clone(), "decode_symbols", "layout.json");
+        let result: Result<u32, String> = Err("not enough symbols".to_string());
+        log.finish(&result, |_| unreachable!("stats are only read on success"));
+
+        let events = logger.events.lock();
+        assert_eq!(events.len(), 4);
+        assert_eq!(events[0].0, "debug");
+        assert_eq!(events[0].1.stage, OperationStage::Started);
+        assert_eq!(events[0].1.to_json(), r#"{"operation":"encode_file","stage":"started","path":"input.bin"}"#);
+
+        let (level, completed) = &events[1];
+        assert_eq!(*level, "info");
+        assert_eq!(completed.stage, OperationStage::Completed);
+        assert_eq!((completed.object_size, completed.blocks, completed.symbols), (Some(100), Some(2), Some(7)));
+        assert!(completed.duration_ms.is_some());
+
+        let failed: serde_json::Value = serde_json::from_str(&events[3].1.to_json()).unwrap();
+        assert_eq!(failed["operation"], "decode_symbols");
+        assert_eq!(failed["stage"], "failed");
+        assert_eq!(failed["error"], "not enough symbols");
+        assert!(failed.get("blocks").is_none());
+    }
+}
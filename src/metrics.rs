@@ -0,0 +1,213 @@
+//! Metrics of encode and decode operations
+//!
+//! A processor reports every `encode_file` and `decode_symbols` call, and every
+//! block it encodes or decodes, to a `ProcessorMetrics`. `MetricsCollector` keeps
+//! OpenTelemetry-style counters and latency histograms of them; other exporters
+//! implement the trait themselves. Processors have no metrics by default, so
+//! nothing is measured.
+
+use parking_lot::Mutex;
+use serde::Serialize;
+use std::collections::BTreeMap;
+use std::time::Duration;
+
+/// Receives the measures of the operations of a processor
+///
+/// All methods do nothing by default. They are called from the thread running
+/// the operation, so they should return quickly.
+pub trait ProcessorMetrics: Send + Sync {
+    fn record_operation(&self, _record: &OperationRecord) {}
+
+    fn record_block(&self, _record: &BlockRecord) {}
+}
+
+/// An `encode_file` or `decode_symbols` call that finished
+#[derive(Debug, Clone)]
+pub struct OperationRecord {
+    pub operation: &'static str,
+    pub succeeded: bool,
+    /// Size of the original data in bytes, 0 if the operation failed
+    pub object_size: u64,
+    pub blocks: usize,
+    pub symbols: u64,
+    pub duration: Duration,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum BlockOperation {
+    Encode,
+    Decode,
+}
+
+/// A block that was encoded or decoded, by any operation of the processor
+#[derive(Debug, Clone)]
+pub struct BlockRecord {
+    pub operation: BlockOperation,
+    pub block_id: usize,
+    pub size: u64,
+    /// Symbols written for an encoded block, 0 for a decoded block
+    pub symbols: u64,
+    pub duration: Duration,
+}
+
+/// Upper bounds in milliseconds of the histogram buckets, the last bucket has no bound
+pub const LATENCY_BUCKETS_MS: [u64; 12] = [1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000];
+
+#[derive(Debug, Clone, Default, PartialEq, Serialize)]
+pub struct LatencyHistogram {
+    /// Number of measures per bucket of LATENCY_BUCKETS_MS, then above the last bound
+    pub buckets: Vec<u64>,
+    pub count: u64,
+    pub sum_ms: f64,
+}
+
+impl LatencyHistogram {
+    fn record(&mut self, duration: Duration) {
+        if self.buckets.is_empty() {
+            self.buckets = vec![0; LATENCY_BUCKETS_MS.len() + 1];
+        }
+        let ms = duration.as_secs_f64() * 1000.0;
+        let bucket = LATENCY_BUCKETS_MS.iter().position(|&bound| ms <= bound as f64).unwrap_or(LATENCY_BUCKETS_MS.len());
+        self.buckets[bucket] += 1;
+        self.count += 1;
+        self.sum_ms += ms;
+    }
+}
+
+#[derive(Debug, Clone, Default, PartialEq, Serialize)]
+pub struct OperationCounters {
+    pub succeeded: u64,
+    pub failed: u64,
+    pub bytes: u64,
+    pub blocks: u64,
+    pub symbols: u64,
+    pub latency: LatencyHistogram,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Serialize)]
+pub struct SlowBlock {
+    pub block_id: usize,
+    pub duration_ms: f64,
+}
+
+#[derive(Debug, Clone, Default, PartialEq, Serialize)]
+pub struct BlockCounters {
+    pub blocks: u64,
+    pub bytes: u64,
+    pub symbols: u64,
+    pub latency: LatencyHistogram,
+    /// Block that took the longest so far
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub slowest: Option<SlowBlock>,
+}
+
+/// Values of the metrics of a `MetricsCollector`
+#[derive(Debug, Clone, Default, PartialEq, Serialize)]
+pub struct MetricsSnapshot {
+    /// Counters per operation name, e.g. `encode_file`
+    pub operations: BTreeMap<String, OperationCounters>,
+    pub encoded_blocks: BlockCounters,
+    pub decoded_blocks: BlockCounters,
+}
+
+/// Metrics kept in memory, read with `snapshot`
+#[derive(Default)]
+pub struct MetricsCollector {
+    metrics: Mutex<MetricsSnapshot>,
+}
+
+impl MetricsCollector {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    pub fn snapshot(&self) -> MetricsSnapshot {
+        self.metrics.lock().clone()
+    }
+}
+
+impl ProcessorMetrics for MetricsCollector {
+    fn record_operation(&self, record: &OperationRecord) {
+        let mut metrics = self.metrics.lock();
+        let counters = metrics.operations.entry(record.operation.to_string()).or_default();
+        if record.succeeded {
+            counters.succeeded += 1;
+            counters.bytes += record.object_size;
+            counters.blocks += record.blocks as u64;
+            counters.symbols += record.symbols;
+        } else {
+            counters.failed += 1;
+        }
+        counters.latency.record(record.duration);
+    }
+
+    fn record_block(&self, record: &BlockRecord) {
+        let mut metrics = self.metrics.lock();
+        let counters = match record.operation {
+            BlockOperation::Encode => &mut metrics.encoded_blocks,
+            BlockOperation::Decode => &mut metrics.decoded_blocks,
+        };
+        counters.blocks += 1;
+        counters.bytes += record.size;
+        counters.symbols += record.symbols;
+        counters.latency.record(record.duration);
+
+        let duration_ms = record.duration.as_secs_f64() * 1000.0;
+        if counters.slowest.map_or(true, |slowest| duration_ms > slowest.duration_ms) {
+            counters.slowest = Some(SlowBlock { block_id: record.block_id, duration_ms });
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn block(operation: BlockOperation, block_id: usize, millis: u64) -> BlockRecord {
+        BlockRecord { operation, block_id, size: 1000, symbols: 12, duration: Duration::from_millis(millis) }
+    }
+
+    #[test]
+    fn test_metrics_collector() {
+        let collector = MetricsCollector::new();
+        assert_eq!(collector.snapshot(), MetricsSnapshot::default());
+
+        collector.record_operation(&OperationRecord {
+            operation: "encode_file",
+            succeeded: true,
+            object_size: 3000,
+            blocks: 3,
+            symbols: 36,
+            duration: Duration::from_millis(30),
+        });
+        collector.record_operation(&OperationRecord {
+            operation: "encode_file",
+            succeeded: false,
+            object_size: 0,
+            blocks: 0,
+            symbols: 0,
+            duration: Duration::from_micros(200),
+        });
+        collector.record_block(&block(BlockOperation::Encode, 0, 3));
+        collector.record_block(&block(BlockOperation::Encode, 1, 20));
+        collector.record_block(&block(BlockOperation::Encode, 2, 7));
+        collector.record_block(&block(BlockOperation::Decode, 0, 20_000));
+
+        let snapshot = collector.snapshot();
+        let encode = &snapshot.operations["encode_file"];
+        assert_eq!((encode.succeeded, encode.failed), (1, 1));
+        assert_eq!((encode.bytes, encode.blocks, encode.symbols), (3000, 3, 36));
+        assert_eq!(encode.latency.count, 2);
+        assert_eq!(encode.latency.buckets[0], 1, "200us is in the first bucket");
+        assert_eq!(encode.latency.buckets[4], 1, "30ms is in the 50ms bucket");
+
+        assert_eq!(snapshot.encoded_blocks.blocks, 3);
+        assert_eq!(snapshot.encoded_blocks.bytes, 3000);
+        assert_eq!(snapshot.encoded_blocks.slowest.unwrap().block_id, 1);
+        assert!((snapshot.encoded_blocks.latency.sum_ms - 30.0).abs() < 1e-6);
+
+        let decoded = &snapshot.decoded_blocks;
+        assert_eq!(decoded.latency.buckets[LATENCY_BUCKETS_MS.len()], 1, "20s is above the last bound");
+        assert!(!snapshot.operations.contains_key("decode_symbols"));
+    }
+}
@@ -0,0 +1,282 @@
+//! Pool of RaptorQ processors for services encoding and decoding in parallel
+//!
+//! Each processor of the pool is used by one thread at a time, so its last error
+//! and shortfalls always come from the operation of that thread. A processor is reset
+//! when it is returned, and the options set on it are dropped, so the next thread gets
+//! it as the pool created it.
+//! The pool creates its processors up front and grows on demand up to a maximum,
+//! then `get` blocks until a processor is returned. Dropping the pool frees them.
+//! `take` and `put` do the same for callers holding processors across calls, as the
+//! pools of the C API.
+
+use crate::processor::{ProcessError, ProcessorConfig, RaptorQProcessor};
+use parking_lot::{Condvar, Mutex};
+use std::ops::Deref;
+
+pub struct ProcessorPool {
+    config: ProcessorConfig,
+    // Never used, holds the options restored on the returned processors
+    defaults: RaptorQProcessor,
+    max_size: usize,
+    state: Mutex<PoolState>,
+    returned: Condvar,
+}
+
+struct PoolState {
+    idle: Vec<RaptorQProcessor>,
+    created: usize,
+}
+
+/// Processor taken from a pool, returned to it when dropped
+pub struct PooledProcessor<'a> {
+    pool: &'a ProcessorPool,
+    processor: Option<RaptorQProcessor>,
+}
+
+impl ProcessorPool {
+    /// Create a pool of `size` processors that can grow up to `max_size`
+    ///
+    /// # Returns
+    /// * `Err(ProcessError::InvalidConfig)` if the configuration is invalid,
+    ///   `max_size` is 0 or `size` is larger than `max_size`
+    pub fn new(size: usize, max_size: usize, config: ProcessorConfig) -> Result<Self, ProcessError> {
+        config.validate()?;
+        if max_size == 0 {
+            return Err(ProcessError::InvalidConfig("pool maximum size must be at least 1".to_string()));
+        }
+        if size > max_size {
+            return Err(ProcessError::InvalidConfig(format!(
+                "pool size {} is larger than its maximum size {}", size, max_size
+            )));
+        }
+
+        let idle = (0..size).map(|_| RaptorQProcessor::new(config.clone())).collect();
+        Ok(Self {
+            defaults: RaptorQProcessor::new(config.clone()),
+            config,
+            max_size,
+            state: Mutex::new(PoolState { idle, created: size }),
+            returned: Condvar::new(),
+        })
+    }
+
+    /// Take an idle processor, creating one if the pool can still grow,
+    /// otherwise wait until one is returned
+    pub fn get(&self) -> PooledProcessor<'_> {
+        self.pooled(self.take(true).expect("Waiting for a processor always gets one"))
+    }
+
+    /// Take a processor if one is idle or can be created, without waiting
+    pub fn try_get(&self) -> Option<PooledProcessor<'_>> {
+        self.take(false).map(|processor| self.pooled(processor))
+    }
+
+    /// Take a processor out of the pool as `get`, or as `try_get` if `wait` is false,
+    /// for callers that can't hold a `PooledProcessor`; return it with `put`
+    pub fn take(&self, wait: bool) -> Option<RaptorQProcessor> {
+        let mut state = self.state.lock();
+        loop {
+            if let Some(processor) = state.idle.pop() {
+                return Some(processor);
+            }
+            if state.created < self.max_size {
+                state.created += 1;
+                drop(state);
+                return Some(RaptorQProcessor::new(self.config.clone()));
+            }
+            if !wait {
+                return None;
+            }
+            self.returned.wait(&mut state);
+        }
+    }
+
+    /// Return a processor taken with `take`, reset with the options of the pool
+    pub fn put(&self, processor: RaptorQProcessor) {
+        processor.reset();
+        processor.copy_options_from(&self.defaults);
+        self.state.lock().idle.push(processor);
+        self.returned.notify_one();
+    }
+
+    /// Run `f` with a processor of the pool, see `get`
+    pub fn with_processor<T, F>(&self, f: F) -> Result<T, ProcessError>
+    where
+        F: FnOnce(&RaptorQProcessor) -> Result<T, ProcessError>,
+    {
+        let processor = self.get();
+        f(&processor)
+    }
+
+    /// Number of processors created by the pool, idle or in use
+    pub fn size(&self) -> usize {
+        self.state.lock().created
+    }
+
+    /// Number of processors waiting to be used
+    pub fn idle_count(&self) -> usize {
+        self.state.lock().idle.len()
+    }
+
+    pub fn max_size(&self) -> usize {
+        self.max_size
+    }
+
+    pub fn get_config(&self) -> &ProcessorConfig {
+        &self.config
+    }
+
+    fn pooled(&self, processor: RaptorQProcessor) -> PooledProcessor<'_> {
+        PooledProcessor { pool: self, processor: Some(processor) }
+    }
+}
+
+impl Deref for PooledProcessor<'_> {
+    type Target = RaptorQProcessor;
+
+    fn deref(&self) -> &RaptorQProcessor {
+        self.processor.as_ref().expect("Processor is only taken when dropped")
+    }
+}
+
+impl Drop for PooledProcessor<'_> {
+    fn drop(&mut self) {
+        if let Some(processor) = self.processor.take() {
+            self.pool.put(processor);
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::collections::BTreeMap;
+    use std::sync::atomic::{AtomicUsize, Ordering};
+    use std::time::Duration;
+
+    fn test_config() -> ProcessorConfig {
+        ProcessorConfig { symbol_size: 1024, ..ProcessorConfig::default() }
+    }
+
+    #[test]
+    fn test_pool_new() {
+        let pool = ProcessorPool::new(2, 4, test_config()).unwrap();
+        assert_eq!(pool.size(), 2);
+        assert_eq!(pool.idle_count(), 2);
+        assert_eq!(pool.max_size(), 4);
+        assert_eq!(pool.get_config().symbol_size, 1024);
+
+        assert!(matches!(ProcessorPool::new(0, 0, test_config()), Err(ProcessError::InvalidConfig(_))));
+        assert!(matches!(ProcessorPool::new(3, 2, test_config()), Err(ProcessError::InvalidConfig(_))));
+        let invalid = ProcessorConfig { redundancy_factor: 0, ..test_config() };
+        assert!(matches!(ProcessorPool::new(1, 1, invalid), Err(ProcessError::InvalidConfig(_))));
+    }
+
+    #[test]
+    fn test_pool_grows_up_to_max_size() {
+        let pool = ProcessorPool::new(0, 2, test_config()).unwrap();
+        assert_eq!(pool.size(), 0);
+
+        let first = pool.get();
+        let second = pool.try_get().expect("Pool can still grow");
+        assert_eq!(pool.size(), 2);
+        assert!(pool.try_get().is_none(), "Pool is at its maximum size");
+
+        drop(first);
+        assert_eq!(pool.idle_count(), 1);
+        let third = pool.try_get().expect("A processor was returned");
+        assert_eq!(pool.size(), 2);
+
+        drop(second);
+        drop(third);
+        assert_eq!(pool.idle_count(), 2);
+    }
+
+    #[test]
+    fn test_pool_get_waits_for_a_processor() {
+        let pool = ProcessorPool::new(1, 1, test_config()).unwrap();
+        let held = pool.get();
+        let waited = AtomicUsize::new(0);
+
+        std::thread::scope(|scope| {
+            scope.spawn(|| {
+                let _processor = pool.get();
+                waited.store(1, Ordering::SeqCst);
+            });
+            std::thread::sleep(Duration::from_millis(50));
+            assert_eq!(waited.load(Ordering::SeqCst), 0, "Pool is empty, get should wait");
+            drop(held);
+        });
+
+        assert_eq!(waited.load(Ordering::SeqCst), 1);
+        assert_eq!(pool.idle_count(), 1);
+    }
+
+    #[test]
+    fn test_pool_take_and_put() {
+        let pool = ProcessorPool::new(1, 2, test_config()).unwrap();
+        let first = pool.take(false).expect("Processor is idle");
+        let second = pool.take(true).expect("Pool can still grow");
+        assert!(pool.take(false).is_none(), "Pool is at its maximum size");
+        assert_eq!(pool.idle_count(), 0);
+
+        second.set_layout_metadata(BTreeMap::from([("object".to_string(), "a".to_string())]));
+        pool.put(second);
+        let processor = pool.get();
+        let layout = processor.encode_bytes(&[7u8; 3000], 0).unwrap().0.layout.unwrap();
+        assert!(layout.metadata.is_empty(), "Returned processor is restored");
+        drop(processor);
+
+        pool.put(first);
+        assert_eq!(pool.size(), 2);
+        assert_eq!(pool.idle_count(), 2);
+    }
+
+    #[test]
+    fn test_pool_with_processor() {
+        let pool = ProcessorPool::new(2, 2, test_config()).unwrap();
+        let data: Vec<u8> = (0..20_000).map(|i| (i % 251) as u8).collect();
+
+        std::thread::scope(|scope| {
+            for _ in 0..8 {
+                scope.spawn(|| {
+                    let decoded = pool.with_processor(|processor| {
+                        let (result, symbols) = processor.encode_bytes(&data, 0)?;
+                        let layout = serde_json::to_vec(result.layout.as_ref().unwrap()).unwrap();
+                        processor.decode_bytes(&symbols, &layout)
+                    }).unwrap();
+                    assert_eq!(decoded, data);
+                });
+            }
+        });
+
+        assert_eq!(pool.size(), 2);
+        assert_eq!(pool.idle_count(), 2);
+
+        let result: Result<(), _> = pool.with_processor(|_| Err(ProcessError::Cancelled));
+        assert!(matches!(result, Err(ProcessError::Cancelled)));
+        assert_eq!(pool.idle_count(), 2, "Processor is returned on error");
+    }
+
+    #[test]
+    fn test_pool_restores_returned_processors() {
+        let pool = ProcessorPool::new(1, 1, test_config()).unwrap();
+        let data: Vec<u8> = (0..20_000).map(|i| (i % 251) as u8).collect();
+
+        let processor = pool.get();
+        processor.set_layout_metadata(BTreeMap::from([("object".to_string(), "a".to_string())]));
+        processor.set_temp_dir(Some("scratch".into()));
+        let (result, _) = processor.encode_bytes(&data, 0).unwrap();
+        assert_eq!(result.layout.unwrap().metadata.len(), 1);
+        assert!(processor.encode_bytes(&[], 0).is_err());
+        assert!(!processor.get_last_error().is_empty());
+        drop(processor);
+
+        // The next user gets the processor as the pool created it
+        let processor = pool.get();
+        assert!(processor.get_last_error().is_empty());
+        assert_eq!(processor.temp_dir(), Some(std::env::temp_dir()));
+        let layout = processor.encode_bytes(&data, 0).unwrap().0.layout.unwrap();
+        assert!(layout.metadata.is_empty());
+    }
+}
@@ -33,29 +33,384 @@
 //! - For more architectural details, see ARCHITECTURE_REVIEW.md.
 
 use raptorq::{Decoder, Encoder, EncodingPacket, ObjectTransmissionInformation};
-use std::io::{self};
-use std::path::Path;
-use crate::file_io::{self, FileReader/*, FileWriter, DirManager*/};
-use std::sync::atomic::{AtomicUsize, Ordering};
-use parking_lot::Mutex;
+use std::collections::{BTreeMap, HashMap, HashSet};
+use std::hash::{BuildHasher, Hasher};
+use std::io::{self, Read};
+use std::ops::Range;
+use std::path::{Path, PathBuf};
+use crate::codec::SymbolCodec;
+use crate::layout_format::{self, LayoutFormat};
+use crate::encryption::SymbolCipher;
+use crate::checksum::SymbolHeader;
+use crate::hash::{ContentHash, ContentHasher};
+use crate::file_io::{self, FileReader, ReadAt, WriteAt/*, FileWriter, DirManager*/};
+use crate::logging::{OperationLog, OperationStats, ProcessorLogger};
+use crate::metrics::{BlockOperation, BlockRecord, OperationRecord, ProcessorMetrics};
+use crate::store::{store_path, SymbolStore};
+use std::sync::{mpsc, Arc, Weak};
+use std::sync::atomic::{AtomicBool, AtomicUsize, Ordering};
+use std::cell::RefCell;
+use std::thread::ThreadId;
+use std::time::{Duration, Instant};
+use parking_lot::{MappedMutexGuard, Mutex, MutexGuard};
 use thiserror::Error;
 use serde::{Serialize, Deserialize};
+use sha2::{Digest, Sha256};
 use log::{error, debug};
 
 const LAYOUT_FILENAME: &str = "_raptorq_layout.json";
 const BLOCK_DIR_PREFIX: &str = "block_";
+// Marker of a block whose symbols are all written, `_raptorq_block_<id>.json` in the
+// output directory holding its BlockLayout, removed once the layout file is written
+const BLOCK_MARKER_PREFIX: &str = "_raptorq_block_";
+// Map of the symbols of a sharded object, written in every shard by `shard_symbols`
+const SHARD_MAP_FILENAME: &str = "_raptorq_shards.json";
+// Encoding symbol IDs are 24-bit in the FEC payload ID
+const MAX_ENCODING_SYMBOL_ID: u32 = (1 << 24) - 1;
+// Largest number of source symbols of a block (K'max of RFC 6330)
+const MAX_SOURCE_SYMBOLS: u32 = 56403;
+/// Largest number of repair symbols per block, so the ESIs of the symbols of any
+/// block fit the 24 bits of the payload ID
+pub const MAX_REPAIR_SYMBOLS_PER_BLOCK: u32 = MAX_ENCODING_SYMBOL_ID + 1 - MAX_SOURCE_SYMBOLS;
+// Largest number of source blocks RaptorQ splits a block into, numbered on 8 bits
+const MAX_SOURCE_BLOCKS: u64 = 255;
+// Largest transfer length of RFC 6330, the size of a block
+const MAX_TRANSFER_LENGTH: u64 = 946_270_874_880;
+// Largest symbol with its 4 bytes FEC payload ID, the symbol size being 16-bit
+const MAX_SYMBOL_LEN: usize = u16::MAX as usize + 4;
+// Largest symbol file: the largest symbol, which compression may grow a little, with
+// its checksum header and encryption overhead
+const MAX_SYMBOL_FILE_LEN: usize = MAX_SYMBOL_LEN + MAX_SYMBOL_LEN / 128 + 64 + SymbolHeader::LEN + SymbolCipher::OVERHEAD;
+// Largest layout read from a tar archive, far more than the layout of the largest
+// object a layout can describe needs
+const MAX_LAYOUT_LEN: u64 = 1 << 30;
+// Alignment of the symbols, RaptorQ rounds the symbol size down to a multiple of it
+const SYMBOL_ALIGNMENT: u16 = 8;
+// Size of the reads hashing the whole object apart from the blocks
+const OBJECT_HASH_CHUNK_SIZE: usize = 1024 * 1024;
 
 /// Layout information structure saved to disk during encoding
 /// and read during decoding to facilitate proper file reassembly.
-#[derive(Debug, Serialize, Deserialize)]
+#[derive(Debug, Serialize, Deserialize, Clone, PartialEq)]
 pub struct RaptorQLayout {
+    /// Version of the format of the layout, `LAYOUT_VERSION` for the layouts written by
+    /// this library. 0 for layouts written before the format was versioned, which
+    /// `migrate_file` upgrades. Layouts of a newer version are rejected when parsed.
+    #[serde(default)]
+    pub layout_version: u32,
+
     /// Detailed layout for each block. Will always contain at least one block,
-    /// even if the file was processed as a single block.
+    /// even if the file was processed as a single block, unless the object is empty
+    /// (see `is_empty_object`).
     pub blocks: Vec<BlockLayout>,
+
+    /// SHA-256 of the whole original data in lowercase hex, checked by
+    /// `decode_symbols_checked`. Empty in layouts written without it.
+    ///
+    /// It identifies the object: decodes from a symbols directory holding the layout
+    /// written by `encode_file` check that both layouts have the same hash.
+    #[serde(default, skip_serializing_if = "String::is_empty")]
+    pub object_sha256: String,
+
+    /// Compression of the symbol files of the object, which decodes undo. Omitted
+    /// when they are not compressed, so layouts without it are read as uncompressed.
+    #[serde(default, skip_serializing_if = "SymbolCodec::is_none")]
+    pub symbol_codec: SymbolCodec,
+
+    /// Id of the key the symbol files are encrypted with, see the `encryption` module.
+    /// Empty when they are not encrypted.
+    #[serde(default, skip_serializing_if = "String::is_empty")]
+    pub symbol_key_id: String,
+
+    /// Whether the symbol files start with a header holding their length and CRC32, see
+    /// the `checksum` module. Omitted when they don't, so older layouts are read without.
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub symbol_crc: bool,
+
+    /// Metadata of the application, e.g. an object id or a content type, recorded as
+    /// given by `RaptorQProcessor::set_layout_metadata` and not interpreted
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub metadata: BTreeMap<String, String>,
+}
+
+impl RaptorQLayout {
+    /// Parse a layout from the content of a layout file, JSON or binary
+    ///
+    /// The format is told by the first bytes of the content, see `LayoutFormat::detect`.
+    /// Layouts of the versions up to `LAYOUT_VERSION` are parsed as they are, with the
+    /// version they were written with.
+    ///
+    /// # Returns
+    /// * `Err(ProcessError::UnsupportedLayoutVersion)` if the layout is of a newer version
+    /// * `Err(ProcessError::DecodingFailed)` if the content is not a valid layout
+    pub fn parse(content: &[u8]) -> Result<Self, ProcessError> {
+        if LayoutFormat::detect(content) == LayoutFormat::Binary {
+            if let Some(layout_version) = layout_format::binary_version(content) {
+                if layout_version > LAYOUT_VERSION {
+                    return Err(ProcessError::UnsupportedLayoutVersion { version: layout_version, supported: LAYOUT_VERSION });
+                }
+            }
+            return layout_format::decode_binary(content)
+                .map_err(|e| ProcessError::DecodingFailed(format!("Failed to parse the binary layout file: {}", e)));
+        }
+
+        let content = std::str::from_utf8(content)
+            .map_err(|e| ProcessError::DecodingFailed(format!("Layout file contains invalid UTF-8: {}", e)))?;
+
+        // The version is checked first, a newer layout may not parse as this one
+        #[derive(Deserialize)]
+        struct Version {
+            #[serde(default)]
+            layout_version: u32,
+        }
+        if let Ok(Version { layout_version }) = serde_json::from_str(content) {
+            if layout_version > LAYOUT_VERSION {
+                return Err(ProcessError::UnsupportedLayoutVersion { version: layout_version, supported: LAYOUT_VERSION });
+            }
+        }
+
+        serde_json::from_str(content)
+            .map_err(|e| ProcessError::DecodingFailed(format!("Failed to parse the layout file: {}", e)))
+    }
+
+    /// Upgrade a layout file to `LAYOUT_VERSION`
+    ///
+    /// Layouts of older versions still decode, so migrating is only needed for the
+    /// tools reading layout files that expect the current version. The upgraded layout
+    /// is written and synced to a file next to it, which then replaces it, so the layout
+    /// is never left partly written.
+    ///
+    /// # Returns
+    /// * `Ok(true)` if the layout was upgraded, `Ok(false)` if it was already current
+    /// * `Err(ProcessError)` if the file can't be read, parsed or written, including
+    ///   `UnsupportedLayoutVersion` for a layout of a newer version
+    pub fn migrate_file(layout_path: &str) -> Result<bool, ProcessError> {
+        let content = Self::read_file_content(layout_path)?;
+        let mut layout = Self::parse(&content)?;
+        if layout.layout_version == LAYOUT_VERSION {
+            return Ok(false);
+        }
+
+        // Version 0 only lacks the version, the fields added since then have defaults
+        layout.layout_version = LAYOUT_VERSION;
+
+        // The file keeps its format
+        let content = layout.to_bytes(LayoutFormat::detect(&content))?;
+        let temp_path = format!("{}.{}.tmp", layout_path, std::process::id());
+        let dir_manager = file_io::get_dir_manager();
+        let written = file_io::open_file_writer(&temp_path).and_then(|mut writer| {
+            writer.write_chunk(0, &content)?;
+            writer.sync()
+        });
+        if let Err(e) = written.and_then(|()| dir_manager.rename(&temp_path, layout_path)) {
+            let _ = dir_manager.remove_file(&temp_path);
+            return Err(io::Error::new(io::ErrorKind::Other, e).into());
+        }
+        debug!("Migrated the layout file {:?} to version {}", layout_path, LAYOUT_VERSION);
+        Ok(true)
+    }
+
+    /// Read and parse a layout file, no processor is needed
+    ///
+    /// # Returns
+    /// * `Err(ProcessError::FileNotFound)` if the file can't be opened
+    /// * `Err(ProcessError::DecodingFailed)` if it can't be read or is not a valid layout
+    pub fn read_file(layout_path: &str) -> Result<Self, ProcessError> {
+        Self::parse(&Self::read_file_content(layout_path)?)
+    }
+
+    /// Content of the layout in the given format, as written to the layout files
+    ///
+    /// # Returns
+    /// * `Err(ProcessError::EncodingFailed)` if the layout can't be serialized
+    pub fn to_bytes(&self, format: LayoutFormat) -> Result<Vec<u8>, ProcessError> {
+        match format {
+            LayoutFormat::Json => serde_json::to_vec_pretty(self)
+                .map_err(|e| ProcessError::EncodingFailed(format!("Failed to serialize the layout: {}", e))),
+            LayoutFormat::Binary => Ok(layout_format::encode_binary(self)),
+        }
+    }
+
+    fn read_file_content(layout_path: &str) -> Result<Vec<u8>, ProcessError> {
+        let mut reader = file_io::open_file_reader(layout_path)
+            .map_err(|e| ProcessError::FileNotFound(format!("Failed to open file {:?}: {}", layout_path, e)))?;
+        let read_error = |e: String| ProcessError::DecodingFailed(format!("Failed to read the layout file: {}", e));
+        let mut content = vec![0; reader.file_size().map_err(read_error)? as usize];
+        reader.read_chunk(0, &mut content).map_err(read_error)?;
+        Ok(content)
+    }
+
+    /// Whether the layout is the one of an empty file, which has no blocks and the
+    /// hash of no data, and decodes to an empty file
+    pub fn is_empty_object(&self) -> bool {
+        self.blocks.is_empty() && self.object_sha256 == sha256_hex(Sha256::new())
+    }
+
+    /// Size of the original data, the sum of the sizes of the blocks
+    pub fn total_size(&self) -> u64 {
+        self.blocks.iter().map(|b| b.size).sum()
+    }
+
+    /// Number of symbols listed for all blocks
+    pub fn symbols_count(&self) -> u64 {
+        self.blocks.iter().map(|b| b.symbols.len() as u64).sum()
+    }
+
+    /// Byte range of a block in the original data, as `(offset, length)`,
+    /// None if the layout has no such block
+    pub fn block_range(&self, block_id: usize) -> Option<(u64, u64)> {
+        self.blocks.iter()
+            .find(|b| b.block_id == block_id)
+            .map(|b| (b.original_offset, b.size))
+    }
+
+    /// Id of the block holding the byte at `offset` of the original data,
+    /// None if the offset is past the data
+    pub fn block_for_offset(&self, offset: u64) -> Option<usize> {
+        self.blocks.iter()
+            .find(|b| offset >= b.original_offset && offset - b.original_offset < b.size)
+            .map(|b| b.block_id)
+    }
+
+    /// Summary of the layout with the counts derived from the encoder parameters
+    pub fn summary(&self) -> LayoutSummary {
+        LayoutSummary {
+            layout_version: self.layout_version,
+            total_size: self.total_size(),
+            symbols_count: self.symbols_count(),
+            metadata: self.metadata.clone(),
+            blocks: self.blocks.iter().map(|b| BlockSummary {
+                block_id: b.block_id,
+                original_offset: b.original_offset,
+                size: b.size,
+                symbol_size: b.symbol_size(),
+                symbols_count: b.symbols.len() as u64,
+                source_symbols_count: b.source_symbols_count(),
+                repair_symbols_count: b.repair_symbols_count(),
+            }).collect(),
+        }
+    }
+
+    /// RFC 6330 Object Transmission Information of every block, in the order of the layout
+    ///
+    /// Each block is a separate RaptorQ object, which a standard decoder decodes from its
+    /// OTI and its symbols, see `BlockOti`.
+    ///
+    /// # Returns
+    /// * `Err(ProcessError::DecodingFailed)` if a block has malformed encoder parameters
+    pub fn to_oti(&self) -> Result<Vec<BlockOti>, ProcessError> {
+        self.blocks
+            .iter()
+            .map(|block_layout| match block_layout.oti() {
+                Some(oti) if oti.symbol_size > 0 => Ok(oti),
+                _ => Err(ProcessError::DecodingFailed(format!(
+                    "Invalid encoder parameters in block {}", block_layout.block_id
+                ))),
+            })
+            .collect()
+    }
+
+    /// Layout of an object encoded by a standard RaptorQ encoder as one RaptorQ object
+    /// per block, from the OTI of its blocks in the order of their data
+    ///
+    /// The OTI doesn't name the symbols, so the blocks list no symbols and have no hash:
+    /// add the `symbol_id` of each symbol of a block to its `symbols`, source symbols
+    /// first, and store them under these names for the layout to decode like the ones of
+    /// this library. The 12 bytes of each OTI are used, the other fields must match them.
+    ///
+    /// # Arguments
+    /// * `otis` - OTI of each block, as returned by `to_oti`
+    /// * `object_size` - Size of the object, the sum of the transfer lengths of the blocks
+    ///
+    /// # Returns
+    /// * `Err(ProcessError::InvalidParameter)` if an OTI is malformed or doesn't match its
+    ///   fields, two blocks have the same id, or the blocks don't add up to the object size
+    pub fn from_oti(otis: &[BlockOti], object_size: u64) -> Result<Self, ProcessError> {
+        let mut blocks: Vec<BlockLayout> = Vec::with_capacity(otis.len());
+        let mut offset = 0u64;
+        for block_oti in otis {
+            let Ok(params) = <[u8; 12]>::try_from(block_oti.oti.as_slice()) else {
+                return Err(ProcessError::InvalidParameter(format!(
+                    "OTI of block {} is {} bytes, expected 12", block_oti.block_id, block_oti.oti.len()
+                )));
+            };
+            let block_layout = BlockLayout {
+                block_id: block_oti.block_id,
+                encoder_parameters: params.to_vec(),
+                original_offset: offset,
+                size: ObjectTransmissionInformation::deserialize(&params).transfer_length(),
+                symbols: Vec::new(),
+                hash: String::new(),
+            };
+            if block_layout.oti().as_ref() != Some(block_oti) || block_oti.symbol_size == 0 {
+                return Err(ProcessError::InvalidParameter(format!(
+                    "OTI of block {} is malformed or doesn't match its fields", block_oti.block_id
+                )));
+            }
+            if blocks.iter().any(|b| b.block_id == block_oti.block_id) {
+                return Err(ProcessError::InvalidParameter(format!("Block {} has several OTIs", block_oti.block_id)));
+            }
+            offset += block_layout.size;
+            blocks.push(block_layout);
+        }
+        if offset != object_size {
+            return Err(ProcessError::InvalidParameter(format!(
+                "Blocks of the OTIs hold {} bytes, the object has {}", offset, object_size
+            )));
+        }
+
+        Ok(RaptorQLayout {
+            layout_version: LAYOUT_VERSION,
+            blocks,
+            // The hash of no data identifies the layout of an empty object
+            object_sha256: if object_size == 0 { sha256_hex(Sha256::new()) } else { String::new() },
+            symbol_codec: SymbolCodec::None,
+            symbol_key_id: String::new(),
+            symbol_crc: false,
+            metadata: BTreeMap::new(),
+        })
+    }
+}
+
+/// Summary of a layout, see `RaptorQLayout::summary`
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct LayoutSummary {
+    /// Version of the format of the layout, see `RaptorQLayout::layout_version`
+    #[serde(default)]
+    pub layout_version: u32,
+    pub total_size: u64,
+    pub symbols_count: u64,
+    /// Metadata of the application recorded in the layout
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub metadata: BTreeMap<String, String>,
+    pub blocks: Vec<BlockSummary>,
+}
+
+/// Storage used by the symbol files of an object, see `RaptorQProcessor::estimate_storage`
+#[derive(Debug, Clone, Copy, PartialEq, Serialize, Deserialize)]
+pub struct StorageEstimate {
+    pub object_size: u64,
+    pub symbols_count: u64,
+    /// Bytes of all symbol files, source and repair symbols with their payload IDs and
+    /// the padding of the last symbol of each source block
+    pub symbol_bytes: u64,
+    /// Bytes stored in addition to the object, as a fraction of its size
+    pub overhead_fraction: f64,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct BlockSummary {
+    pub block_id: usize,
+    pub original_offset: u64,
+    pub size: u64,
+    pub symbol_size: u16,
+    pub symbols_count: u64,
+    pub source_symbols_count: u64,
+    pub repair_symbols_count: u64,
 }
 
 /// Information about a single block
-#[derive(Debug, Serialize, Deserialize, Clone)]
+#[derive(Debug, Serialize, Deserialize, Clone, PartialEq)]
 pub struct BlockLayout {
     /// Identifier for the block (0, 1, 2, etc.)
     pub block_id: usize,
@@ -76,6 +431,92 @@ pub struct BlockLayout {
     pub hash: String,
 }
 
+impl BlockLayout {
+    /// Encoder parameters (OTI) of the block, None if they are malformed
+    pub fn encoder_config(&self) -> Option<ObjectTransmissionInformation> {
+        let params: [u8; 12] = self.encoder_parameters.get(0..12)?.try_into().ok()?;
+        Some(ObjectTransmissionInformation::deserialize(&params))
+    }
+
+    /// Size of the symbols of the block, 0 if the encoder parameters are malformed
+    pub fn symbol_size(&self) -> u16 {
+        self.encoder_config().map_or(0, |config| config.symbol_size())
+    }
+
+    /// Number of source symbols of the block, 0 if the encoder parameters are malformed
+    pub fn source_symbols_count(&self) -> u64 {
+        self.encoder_config()
+            .filter(|config| config.symbol_size() > 0)
+            .map_or(0, |config| source_symbols_count(&config))
+    }
+
+    /// Number of repair symbols listed for the block, the symbols beyond its source symbols
+    pub fn repair_symbols_count(&self) -> u64 {
+        (self.symbols.len() as u64).saturating_sub(self.source_symbols_count())
+    }
+
+    /// RFC 6330 Object Transmission Information of the block, None if the encoder
+    /// parameters are malformed
+    pub fn oti(&self) -> Option<BlockOti> {
+        let config = self.encoder_config()?;
+        Some(BlockOti {
+            block_id: self.block_id,
+            oti: config.serialize().to_vec(),
+            transfer_length: config.transfer_length(),
+            symbol_size: config.symbol_size(),
+            source_blocks: config.source_blocks(),
+            sub_blocks: config.sub_blocks(),
+            symbol_alignment: config.symbol_alignment(),
+        })
+    }
+
+    /// Whether a symbol of the block is a repair symbol, None if the symbol is too short
+    /// or the encoder parameters are malformed
+    ///
+    /// A block of more source symbols than a source block holds is split into source
+    /// blocks, each numbering its own symbols, so the ESI of the symbol is compared to
+    /// the source symbols of the source block of its payload ID.
+    pub fn is_repair_symbol(&self, symbol: &[u8]) -> Option<bool> {
+        let config = self.encoder_config().filter(|config| oti_error(config).is_none())?;
+        let source_block = *symbol.first()?;
+        if source_block >= config.source_blocks() {
+            return None;
+        }
+        symbol_esi(symbol).map(|esi| is_repair_esi(&config, source_block, esi))
+    }
+
+    /// Number of symbols to collect for the block to decode with high probability,
+    /// 0 if the encoder parameters are malformed
+    pub fn min_symbols_required(&self) -> u64 {
+        match self.source_symbols_count() {
+            0 => 0,
+            count => count + DECODE_SYMBOL_OVERHEAD,
+        }
+    }
+}
+
+/// RFC 6330 Object Transmission Information of a block
+///
+/// Each block is encoded as a separate RaptorQ object, so a standard decoder
+/// can decode a block from its OTI and its symbols, which are FEC payload IDs
+/// followed by the symbol data.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct BlockOti {
+    pub block_id: usize,
+    /// The 12 bytes of the Common and Scheme-Specific FEC OTI
+    pub oti: Vec<u8>,
+    /// F, the size of the block in bytes
+    pub transfer_length: u64,
+    /// T, the symbol size in bytes
+    pub symbol_size: u16,
+    /// Z, the number of source blocks
+    pub source_blocks: u8,
+    /// N, the number of sub-blocks
+    pub sub_blocks: u16,
+    /// Al, the symbol alignment in bytes
+    pub symbol_alignment: u8,
+}
+
 #[derive(Debug, Serialize, Deserialize)]
 pub struct ProcessResult {
     pub total_symbols_count: u64,
@@ -86,6 +527,14 @@ pub struct ProcessResult {
     /// The layout file content, only populated when return_layout is true
     #[serde(skip_serializing_if = "Option::is_none")]
     pub layout_content: Option<String>,
+    /// The parsed layout, only populated when return_layout is true; not serialized,
+    /// `layout_content` already holds it (see raptorq_parse_layout_content)
+    #[serde(skip)]
+    pub layout: Option<RaptorQLayout>,
+    /// Hash of the source in lowercase hex, of the algorithm set with
+    /// `RaptorQProcessor::set_content_hash`; empty if disabled or for a single block
+    #[serde(default, skip_serializing_if = "String::is_empty")]
+    pub content_hash: String,
 }
 
 #[derive(Debug, Serialize, Deserialize)]
@@ -98,11 +547,127 @@ pub struct BlockInfo {
     pub source_symbols_count: u64,
     pub hash: String,
 }
-const DEFAULT_SYMBOL_SIZE_B: u16 = 65535;  // 64 KiB, this is MAX possible value for now - symbol size is uint16 in RaptorQ
-const DEFAULT_REDUNDANCY_FACTOR: u8 = 4;
+/// Selects the symbols an encode writes, from their block id, encoding symbol ID and
+/// whether they are repair symbols, see `RaptorQProcessor::encode_file_with_filter`
+pub type SymbolFilter<'a> = dyn Fn(usize, u32, bool) -> bool + 'a;
+
+/// Symbol sent by `RaptorQProcessor::encode_to_channel`
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct EncodedSymbol {
+    pub block_id: usize,
+    /// Encoding symbol ID of the symbol in its source block, the first byte of the
+    /// payload ID of `data` numbering the source block
+    pub esi: u32,
+    /// Whether the ESI is past the source symbols of its source block
+    pub is_repair: bool,
+    /// Serialized packet, the content of the symbol file without compression or encryption
+    pub data: Vec<u8>,
+}
+
+// Receives the symbols of an encode that keeps them in memory instead of writing them
+type SymbolSink<'a> = dyn FnMut(EncodedSymbol) -> Result<(), ProcessError> + 'a;
+
+/// Progress of a file encoded block by block
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+pub struct EncodeProgress {
+    pub blocks_done: usize,
+    pub blocks_total: usize,
+    pub bytes_processed: u64,
+}
+
+/// File being encoded block by block, see `RaptorQProcessor::begin_encode_file`
+pub struct FileEncodeJob {
+    reader: Box<dyn FileReader>,
+    output_dir: String,
+    layout_file: String,
+    block_size: usize,
+    total_size: usize,
+    blocks_total: usize,
+    bytes_processed: u64,
+    encoded: EncodedBlocks,
+    cancellation: Cancellation,
+}
+
+impl FileEncodeJob {
+    /// Progress of the encoding so far
+    pub fn progress(&self) -> EncodeProgress {
+        EncodeProgress {
+            blocks_done: self.encoded.blocks.len(),
+            blocks_total: self.blocks_total,
+            bytes_processed: self.bytes_processed,
+        }
+    }
+
+    /// Whether all blocks were encoded
+    pub fn is_done(&self) -> bool {
+        self.encoded.blocks.len() >= self.blocks_total
+    }
+}
+
+/// File to encode with `RaptorQProcessor::encode_files`
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct BatchEncodeJob {
+    pub input_path: String,
+    pub output_dir: String,
+    /// Size of blocks to process at once (0 = recommended block size)
+    #[serde(default)]
+    pub block_size: usize,
+}
+
+/// Object to decode with `RaptorQProcessor::decode_files`
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct BatchDecodeJob {
+    pub symbols_dir: String,
+    pub output_path: String,
+    pub layout_path: String,
+}
+
+/// Version of the layout format written by this library, see `RaptorQLayout::layout_version`
+pub const LAYOUT_VERSION: u32 = 1;
+
+/// Default symbol size in bytes.
+/// Largest value allowed by RFC 6330, where the symbol size is a 16-bit field:
+/// large symbols keep the number of symbols and the per-symbol overhead low.
+/// The encoder aligns it down to a multiple of 8, so symbols are 65528 bytes.
+pub const DEFAULT_SYMBOL_SIZE_B: u16 = 65535;
+/// Symbols needed on top of the source symbols for a decode to succeed with high
+/// probability, with K + 2 symbols RaptorQ fails less than once in a million.
+pub const DECODE_SYMBOL_OVERHEAD: u64 = 2;
+/// Number of standard deviations of margin used by `get_recommended_redundancy`,
+/// the probability of falling below the mean by more is about one in a million.
+pub const REDUNDANCY_Z_SCORE: f64 = 4.75;
+/// Smallest symbol size accepted, the encoder aligns symbols to 8 bytes.
+pub const MIN_SYMBOL_SIZE_B: u16 = 8;
+/// Default redundancy factor: about 4 times the source data is generated,
+/// so the data can be decoded with up to 3/4 of the symbols lost.
+pub const DEFAULT_REDUNDANCY_FACTOR: u8 = 4;
 // const DEFAULT_STREAM_BUFFER_SIZE_B: usize = 1 * 1024 * 1024; // 1 MiB
-const DEFAULT_MAX_MEMORY_MB: u64 = 16 * 1024; // 16 GB
-const DEFAULT_CONCURRENCY_LIMIT: u64 = 4;
+/// Default memory limit in MB (16 GB), files needing more are split into blocks.
+pub const DEFAULT_MAX_MEMORY_MB: u64 = 16 * 1024;
+/// Default number of operations that can run at the same time on a processor.
+pub const DEFAULT_CONCURRENCY_LIMIT: u64 = 4;
+
+/// Memory limit presets in MB, for the `max_memory_mb` configuration.
+/// Files smaller than limit / 1.5 are encoded as a single block.
+pub const MAX_MEMORY_MB_1GB: u64 = 1024;
+pub const MAX_MEMORY_MB_2GB: u64 = 2 * 1024;
+/// Enough to encode files up to about 2.7 GB as a single block,
+/// a good fit for most hosts.
+pub const MAX_MEMORY_MB_4GB: u64 = 4 * 1024;
+pub const MAX_MEMORY_MB_8GB: u64 = 8 * 1024;
+pub const MAX_MEMORY_MB_16GB: u64 = 16 * 1024;
+
+/// Longest path accepted in bytes, PATH_MAX of Linux without the terminating NUL.
+/// The symbols of an encode are written in a block directory and a symbol id below the
+/// output directory, which should leave about 64 bytes for them.
+pub const MAX_PATH_LEN_B: usize = 4095;
+/// Longest file or directory name accepted in bytes, NAME_MAX of most file systems.
+pub const MAX_PATH_COMPONENT_LEN_B: usize = 255;
+
+/// Smallest block size `get_recommended_block_size_for` splits a file into to encode
+/// its blocks in parallel, below it the cost per block outweighs the parallelism.
+pub const MIN_PARALLEL_BLOCK_SIZE_B: usize = 4 * 1024 * 1024;
+
 const MEMORY_SAFETY_MARGIN: f64 = 1.5; // 50% safety margin
 
 /// Estimate the peak memory required to encode or decode a block of the given size (in bytes).
@@ -119,9 +684,38 @@ pub struct ProcessorConfig {
     pub symbol_size: u16,
     pub redundancy_factor: u8,
     pub max_memory_mb: u64,
+    /// Largest number of tasks running at once on the processor
+    ///
+    /// Each operation takes a task slot while it runs and fails with
+    /// `ConcurrencyLimitReached` when none is free. Parallel operations
+    /// (`encode_reader_at`, `decode_symbols_parallel`, `encode_files`, `decode_files`...)
+    /// run their extra workers on free slots only, so the threads of a processor never
+    /// exceed the limit. Applications running their own workers can size them with
+    /// `available_task_slots`.
     pub concurrency_limit: u64,
 }
 
+impl ProcessorConfig {
+    /// Check that the configuration can be used by a processor
+    pub fn validate(&self) -> Result<(), ProcessError> {
+        if self.symbol_size < MIN_SYMBOL_SIZE_B {
+            return Err(ProcessError::InvalidConfig(format!(
+                "symbol size must be at least {} bytes, got {}", MIN_SYMBOL_SIZE_B, self.symbol_size
+            )));
+        }
+        if self.redundancy_factor == 0 {
+            return Err(ProcessError::InvalidConfig("redundancy factor must be at least 1".to_string()));
+        }
+        if self.max_memory_mb == 0 {
+            return Err(ProcessError::InvalidConfig("memory limit must be at least 1 MB".to_string()));
+        }
+        if self.concurrency_limit == 0 {
+            return Err(ProcessError::InvalidConfig("concurrency limit must be at least 1".to_string()));
+        }
+        Ok(())
+    }
+}
+
 impl Default for ProcessorConfig {
     fn default() -> Self {
         Self {
@@ -133,6 +727,203 @@ impl Default for ProcessorConfig {
     }
 }
 
+/// Builds a processor from the options that differ from the defaults,
+/// e.g. `RaptorQProcessor::builder().symbol_size(1024).concurrency_limit(2).build()`
+#[derive(Clone, Default)]
+pub struct ProcessorBuilder {
+    config: ProcessorConfig,
+    logger: Option<Arc<dyn ProcessorLogger>>,
+    metrics: Option<Arc<dyn ProcessorMetrics>>,
+    timeout: Option<Duration>,
+    cleanup_on_error: Option<bool>,
+    flat_symbol_layout: bool,
+    auto_symbol_size: bool,
+    buffer_pool: bool,
+    repair_symbols_per_block: Option<u32>,
+    symbol_codec: SymbolCodec,
+    symbol_key: Option<Vec<u8>>,
+    symbol_crc: bool,
+    layout_metadata: BTreeMap<String, String>,
+    layout_format: LayoutFormat,
+    content_hash: ContentHash,
+    temp_dir: Option<PathBuf>,
+    max_source_symbols: Option<u64>,
+}
+
+impl ProcessorBuilder {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Replace the whole configuration, options set before are overwritten
+    pub fn config(mut self, config: ProcessorConfig) -> Self {
+        self.config = config;
+        self
+    }
+
+    pub fn symbol_size(mut self, symbol_size: u16) -> Self {
+        self.config.symbol_size = symbol_size;
+        self
+    }
+
+    pub fn redundancy_factor(mut self, redundancy_factor: u8) -> Self {
+        self.config.redundancy_factor = redundancy_factor;
+        self
+    }
+
+    pub fn max_memory_mb(mut self, max_memory_mb: u64) -> Self {
+        self.config.max_memory_mb = max_memory_mb;
+        self
+    }
+
+    pub fn concurrency_limit(mut self, concurrency_limit: u64) -> Self {
+        self.config.concurrency_limit = concurrency_limit;
+        self
+    }
+
+    /// See `RaptorQProcessor::set_logger`
+    pub fn logger(mut self, logger: Arc<dyn ProcessorLogger>) -> Self {
+        self.logger = Some(logger);
+        self
+    }
+
+    /// See `RaptorQProcessor::set_metrics`
+    pub fn metrics(mut self, metrics: Arc<dyn ProcessorMetrics>) -> Self {
+        self.metrics = Some(metrics);
+        self
+    }
+
+    /// See `RaptorQProcessor::set_timeout`
+    pub fn timeout(mut self, timeout: Duration) -> Self {
+        self.timeout = Some(timeout);
+        self
+    }
+
+    /// See `RaptorQProcessor::set_cleanup_on_error`
+    pub fn cleanup_on_error(mut self, cleanup_on_error: bool) -> Self {
+        self.cleanup_on_error = Some(cleanup_on_error);
+        self
+    }
+
+    /// See `RaptorQProcessor::set_flat_symbol_layout`
+    pub fn flat_symbol_layout(mut self, flat_symbol_layout: bool) -> Self {
+        self.flat_symbol_layout = flat_symbol_layout;
+        self
+    }
+
+    /// See `RaptorQProcessor::set_auto_symbol_size`
+    pub fn auto_symbol_size(mut self, auto_symbol_size: bool) -> Self {
+        self.auto_symbol_size = auto_symbol_size;
+        self
+    }
+
+    /// See `RaptorQProcessor::set_buffer_pool`
+    pub fn buffer_pool(mut self, buffer_pool: bool) -> Self {
+        self.buffer_pool = buffer_pool;
+        self
+    }
+
+    /// See `RaptorQProcessor::set_repair_symbols_per_block`
+    pub fn repair_symbols_per_block(mut self, repair_symbols: u32) -> Self {
+        self.repair_symbols_per_block = Some(repair_symbols);
+        self
+    }
+
+    /// See `RaptorQProcessor::set_symbol_codec`
+    pub fn symbol_codec(mut self, symbol_codec: SymbolCodec) -> Self {
+        self.symbol_codec = symbol_codec;
+        self
+    }
+
+    /// See `RaptorQProcessor::set_symbol_key`
+    pub fn symbol_key(mut self, key: &[u8]) -> Self {
+        self.symbol_key = Some(key.to_vec());
+        self
+    }
+
+    /// See `RaptorQProcessor::set_symbol_crc`
+    pub fn symbol_crc(mut self, symbol_crc: bool) -> Self {
+        self.symbol_crc = symbol_crc;
+        self
+    }
+
+    /// See `RaptorQProcessor::set_layout_metadata`
+    pub fn layout_metadata(mut self, metadata: BTreeMap<String, String>) -> Self {
+        self.layout_metadata = metadata;
+        self
+    }
+
+    /// See `RaptorQProcessor::set_layout_format`
+    pub fn layout_format(mut self, layout_format: LayoutFormat) -> Self {
+        self.layout_format = layout_format;
+        self
+    }
+
+    /// See `RaptorQProcessor::set_content_hash`
+    pub fn content_hash(mut self, content_hash: ContentHash) -> Self {
+        self.content_hash = content_hash;
+        self
+    }
+
+    /// See `RaptorQProcessor::set_temp_dir`
+    pub fn temp_dir(mut self, temp_dir: impl Into<PathBuf>) -> Self {
+        self.temp_dir = Some(temp_dir.into());
+        self
+    }
+
+    /// See `RaptorQProcessor::set_max_source_symbols`
+    pub fn max_source_symbols(mut self, max_symbols: u64) -> Self {
+        self.max_source_symbols = Some(max_symbols);
+        self
+    }
+
+    /// Create the processor
+    ///
+    /// # Returns
+    /// * `Err(ProcessError::InvalidConfig)` if the configuration, the number of
+    ///   repair symbols per block or the length of the symbol key is invalid
+    pub fn build(self) -> Result<RaptorQProcessor, ProcessError> {
+        self.config.validate()?;
+        if let Some(repair_symbols) = self.repair_symbols_per_block {
+            check_repair_symbols_per_block(repair_symbols).map_err(ProcessError::InvalidConfig)?;
+        }
+        if let Some(key) = &self.symbol_key {
+            SymbolCipher::new(key).map_err(ProcessError::InvalidConfig)?;
+        }
+        Ok(self.create())
+    }
+
+    // Create the processor without validating its configuration
+    fn create(self) -> RaptorQProcessor {
+        RaptorQProcessor {
+            config: self.config,
+            active_tasks: AtomicUsize::new(0),
+            last_error: PerThread::default(),
+            last_error_code: PerThread::default(),
+            last_shortfalls: PerThread::default(),
+            last_corrupt_symbols: PerThread::default(),
+            cancel_epoch: AtomicUsize::new(0),
+            logger: Mutex::new(self.logger),
+            metrics: Mutex::new(self.metrics),
+            timeout: Mutex::new(self.timeout),
+            cleanup_on_error: AtomicBool::new(self.cleanup_on_error.unwrap_or(true)),
+            flat_symbol_layout: AtomicBool::new(self.flat_symbol_layout),
+            auto_symbol_size: AtomicBool::new(self.auto_symbol_size),
+            block_buffers: Mutex::new(self.buffer_pool.then(Vec::new)),
+            repair_symbols_per_block: Mutex::new(self.repair_symbols_per_block),
+            symbol_codec: Mutex::new(self.symbol_codec),
+            // An invalid key is rejected by build()
+            symbol_cipher: Mutex::new(self.symbol_key.and_then(|key| SymbolCipher::new(&key).ok()).map(Arc::new)),
+            symbol_crc: AtomicBool::new(self.symbol_crc),
+            layout_metadata: Mutex::new(self.layout_metadata),
+            layout_format: Mutex::new(self.layout_format),
+            content_hash: Mutex::new(self.content_hash),
+            temp_dir: Mutex::new(self.temp_dir),
+            max_source_symbols: Mutex::new(self.max_source_symbols),
+        }
+    }
+}
+
 #[derive(Error, Debug)]
 pub enum ProcessError {
     #[error("IO error: {0}")]
@@ -150,6 +941,15 @@ pub enum ProcessError {
     #[error("Decoding failed: {0}")]
     DecodingFailed(String),
 
+    #[error("Insufficient symbols to decode: {}", format_shortfalls(.0))]
+    InsufficientSymbols(Vec<BlockShortfall>),
+
+    #[error("Symbol {esi} of block {block_id} is not stored and can't be generated")]
+    SymbolNotFound {
+        block_id: usize,
+        esi: u32,
+    },
+
     #[error("Memory limit exceeded. Required: {required}MB, Available: {available}MB")]
     MemoryLimitExceeded {
         required: usize,
@@ -158,1731 +958,11788 @@ pub enum ProcessError {
 
     #[error("Concurrency limit reached")]
     ConcurrencyLimitReached,
+
+    #[error("Operation cancelled")]
+    Cancelled,
+
+    #[error("Operation timed out after {0:?}")]
+    TimedOut(Duration),
+
+    #[error("The symbols directory holds object {found}, the layout describes object {expected}")]
+    ObjectMismatch {
+        /// Object hash of the layout being decoded
+        expected: String,
+        /// Object hash of the layout found in the symbols directory
+        found: String,
+    },
+
+    #[error("Layout version {version} is newer than version {supported} supported by this library")]
+    UnsupportedLayoutVersion {
+        version: u32,
+        supported: u32,
+    },
+
+    #[error("Invalid configuration: {0}")]
+    InvalidConfig(String),
+
+    #[error("Invalid parameter: {0}")]
+    InvalidParameter(String),
 }
 
-fn get_hash_as_b58(data: &[u8]) -> String {
-    let hash = blake3::hash(data);
-    bs58::encode(hash.as_bytes()).into_string()
+/// Symbol availability of a block that could not be decoded
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct BlockShortfall {
+    pub block_id: usize,
+    /// Number of symbols for the block that were found and accepted by the decoder
+    pub present: u64,
+    /// Number of symbols needed to attempt the decoding of the block
+    pub required: u64,
 }
 
-pub struct RaptorQProcessor {
-    config: ProcessorConfig,
-    active_tasks: AtomicUsize,
-    last_error: Mutex<String>,
+/// Shards holding the symbols of an object, see `RaptorQProcessor::shard_symbols`
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct ShardMap {
+    /// Directories of the shards, in the order the symbols were dealt to them
+    pub shard_dirs: Vec<String>,
+    /// Index in `shard_dirs` of the shard holding each symbol, by symbol id
+    pub symbols: BTreeMap<String, usize>,
+    /// Indexes of the shards holding enough symbols to decode every block on their own,
+    /// empty unless the blocks have more symbols than the shards need together
+    pub decodable_shards: Vec<usize>,
 }
 
-impl RaptorQProcessor {
-    pub fn new(config: ProcessorConfig) -> Self {
-        Self {
-            config,
-            active_tasks: AtomicUsize::new(0),
-            last_error: Mutex::new(String::new()),
-        }
+impl ShardMap {
+    /// Read the map written in a shard directory by `shard_symbols`
+    ///
+    /// # Returns
+    /// * `Err(ProcessError::FileNotFound)` if the directory has no map
+    /// * `Err(ProcessError::DecodingFailed)` if it can't be read or is not a valid map
+    pub fn read_dir(shard_dir: &str) -> Result<Self, ProcessError> {
+        let map_path = Path::new(shard_dir).join(SHARD_MAP_FILENAME).to_string_lossy().to_string();
+        let mut reader = file_io::open_file_reader(&map_path)
+            .map_err(|e| ProcessError::FileNotFound(format!("Failed to open file {:?}: {}", map_path, e)))?;
+        let read_error = |e: String| ProcessError::DecodingFailed(format!("Failed to read the shard map: {}", e));
+        let mut content = vec![0; reader.file_size().map_err(read_error)? as usize];
+        reader.read_chunk(0, &mut content).map_err(read_error)?;
+        serde_json::from_slice(&content)
+            .map_err(|e| ProcessError::DecodingFailed(format!("Failed to parse the shard map: {}", e)))
     }
 
-    pub fn get_last_error(&self) -> String {
-        self.last_error.lock().clone()
+    /// Directory of the shard holding a symbol, None if the symbol wasn't sharded
+    pub fn shard_dir(&self, symbol_id: &str) -> Option<&str> {
+        self.symbols.get(symbol_id).and_then(|&shard| self.shard_dirs.get(shard)).map(String::as_str)
     }
+}
 
-    fn set_last_error(&self, error: String) {
-        *self.last_error.lock() = error;
-    }
+/// Symbol availability of an object, see `RaptorQProcessor::audit_object`
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct AuditReport {
+    /// Whether every block has at least its decode threshold of symbols
+    pub recoverable: bool,
+    /// Smallest margin of the blocks, how many more symbols the object can lose in its
+    /// weakest block while staying recoverable; 0 for an empty object
+    pub min_margin: i64,
+    pub blocks: Vec<BlockAudit>,
+}
 
-    #[allow(dead_code)] // Only used in tests
-    pub fn get_config(&self) -> &ProcessorConfig {
-        &self.config
-    }
+/// Symbol availability of a block, see `RaptorQProcessor::audit_object`
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct BlockAudit {
+    pub block_id: usize,
+    /// Number of symbols listed for the block in the layout
+    pub listed: u64,
+    /// Number of listed symbols found in the symbols directory
+    pub available: u64,
+    /// Number of source symbols of the block, the least a decode could succeed with
+    pub source_symbols: u64,
+    /// Number of symbols decoding the block with high probability, see
+    /// `min_symbols_for_block`, capped at the symbols listed
+    pub threshold: u64,
+    /// Available symbols beyond the threshold, negative when the block has fewer
+    pub margin: i64,
+}
 
-    pub fn get_recommended_block_size(&self, file_size: usize) -> usize {
-        let max_memory_bytes = self.config.max_memory_mb * 1024 * 1024;
+/// Source and repair symbols of a block found on disk, see `RaptorQProcessor::symbol_inventory`
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+pub struct BlockInventory {
+    /// Number of source symbols of the block found in the symbols directory
+    pub source_present: u64,
+    /// Number of repair symbols of the block found in the symbols directory
+    pub repair_present: u64,
+    /// Number of source symbols listed for the block in the layout
+    pub source_expected: u64,
+    /// Number of repair symbols listed for the block in the layout
+    pub repair_expected: u64,
+}
 
-        // If the file is smaller than max memory divided by MEMORY_SAFETY_MARGIN, don't split it
-        let safe_memory = (max_memory_bytes as f64 / MEMORY_SAFETY_MARGIN) as usize;
-        if file_size < safe_memory {
-            return 0;
-        }
+/// Symbol whose content doesn't match its id, found by a verified decode
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct CorruptSymbol {
+    pub block_id: usize,
+    pub symbol_id: String,
+}
 
-        // Otherwise, aim for blocks that would use about 1/4 of available memory
-        let target_block_size = safe_memory / 4;
+/// Kind of problem found by `validate_layout`
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum LayoutIssueKind {
+    /// The layout has no blocks, while it isn't the layout of an empty object
+    NoBlocks,
+    /// Several blocks have the same id
+    DuplicateBlock,
+    /// A block id between 0 and the largest id is not in the layout
+    MissingBlock,
+    /// The encoder parameters of the block can't be read
+    InvalidEncoderParameters,
+    /// The size of the block differs from the size in its encoder parameters
+    SizeMismatch,
+    /// The block doesn't start where the previous one ends, so the blocks don't
+    /// add up to the original data
+    OffsetMismatch,
+    /// The block lists fewer symbols than it has source symbols
+    TooFewSymbols,
+    /// The symbols directory doesn't exist
+    MissingSymbolsDirectory,
+    /// Some symbols of the block are not on disk, it can still be decoded
+    MissingSymbols,
+    /// Too few symbols of the block are on disk to decode it
+    InsufficientSymbols,
+    /// A symbol listed by the layout has no file, found by `scrub_symbols`
+    MissingSymbol,
+    /// The file of a symbol can't be read, or fails its CRC, to decrypt or to
+    /// decompress, found by `scrub_symbols`
+    UnreadableSymbol,
+    /// The file of a symbol doesn't hold a whole symbol of its block, found by
+    /// `scrub_symbols`
+    SymbolSizeMismatch,
+    /// The file of a symbol isn't named after the id of its content, or the id
+    /// isn't a file name, found by `scrub_symbols`
+    SymbolIdMismatch,
+}
 
-        // Ensure block size is a multiple of symbol size for efficient processing
-        let symbol_size = self.config.symbol_size as usize;
-        let blocks = (target_block_size / symbol_size).max(1);
-        blocks * symbol_size
+/// Problem found in a layout or its symbols by `validate_layout` or `scrub_symbols`
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct LayoutIssue {
+    /// Block with the problem, None for the layout as a whole
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub block_id: Option<usize>,
+    /// Symbol with the problem, None unless it is about a single symbol
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub symbol_id: Option<String>,
+    pub kind: LayoutIssueKind,
+    pub message: String,
+}
+
+impl LayoutIssue {
+    fn new(block_id: Option<usize>, kind: LayoutIssueKind, message: String) -> Self {
+        Self { block_id, symbol_id: None, kind, message }
     }
 
-    /// Create metadata for a file without generating symbols
-    ///
-    /// This method calculates symbol IDs and creates a layout file without
-    /// actually writing the symbol files to disk.
+    fn for_symbol(block_id: usize, symbol_id: &str, kind: LayoutIssueKind, message: String) -> Self {
+        Self { block_id: Some(block_id), symbol_id: Some(symbol_id.to_string()), kind, message }
+    }
+}
+
+fn format_corrupt_symbols(corrupt: &[CorruptSymbol]) -> String {
+    corrupt
+        .iter()
+        .map(|c| format!("symbol {} in block {} failed checksum", c.symbol_id, c.block_id))
+        .collect::<Vec<_>>()
+        .join("; ")
+}
+
+fn format_shortfalls(shortfalls: &[BlockShortfall]) -> String {
+    shortfalls
+        .iter()
+        .map(|s| format!("block {} has {} of {} required symbols", s.block_id, s.present, s.required))
+        .collect::<Vec<_>>()
+        .join("; ")
+}
+
+/// Number of source symbols (K) described by the encoder parameters of a block.
+/// RaptorQ needs at least this many symbols to reconstruct the block.
+fn source_symbols_count(config: &ObjectTransmissionInformation) -> u64 {
+    let symbol_size = config.symbol_size() as u64;
+    (config.transfer_length() + symbol_size - 1) / symbol_size
+}
+
+// Number of source symbols (K) of a source block of a block, RaptorQ partitioning the
+// Kt source symbols of the block between its Z source blocks as RFC 6330 does: the
+// first ZL source blocks get KL symbols, the others KS
+fn source_block_symbols_count(config: &ObjectTransmissionInformation, source_block: u8) -> u64 {
+    let total = source_symbols_count(config);
+    let blocks = (config.source_blocks() as u64).max(1);
+    let (long, short) = (total.div_ceil(blocks), total / blocks);
+    let long_blocks = total - short * blocks;
+    if (source_block as u64) < long_blocks { long } else { short }
+}
+
+// Whether the symbol of a source block (SBN) and ESI is a repair symbol: the ESIs of
+// each source block number its own source symbols first, then its repair symbols
+fn is_repair_esi(config: &ObjectTransmissionInformation, source_block: u8, esi: u32) -> bool {
+    esi as u64 >= source_block_symbols_count(config, source_block)
+}
+
+// Why encoder parameters (OTI) can't decode a block, None if they can; raptorq
+// panics on a zero or inconsistent field instead of rejecting it
+fn oti_error(config: &ObjectTransmissionInformation) -> Option<String> {
+    let symbol_size = config.symbol_size() as u64;
+    let alignment = config.symbol_alignment() as u64;
+    let sub_blocks = config.sub_blocks() as u64;
+    let source_blocks = config.source_blocks() as u64;
+    let reason = if config.transfer_length() == 0 || config.transfer_length() > MAX_TRANSFER_LENGTH {
+        format!("transfer length {} is not within 1 to {} bytes", config.transfer_length(), MAX_TRANSFER_LENGTH)
+    } else if alignment == 0 || symbol_size == 0 || symbol_size % alignment != 0 {
+        format!("symbol size {} is not a non-zero multiple of the alignment {}", symbol_size, alignment)
+    } else if sub_blocks == 0 || sub_blocks > symbol_size / alignment {
+        format!("{} sub-blocks don't fit symbols of {} bytes aligned on {}", sub_blocks, symbol_size, alignment)
+    } else if source_blocks == 0 || source_blocks > source_symbols_count(config) {
+        format!("{} source blocks for {} source symbols", source_blocks, source_symbols_count(config))
+    } else if source_symbols_count(config).div_ceil(source_blocks) > MAX_SOURCE_SYMBOLS as u64 {
+        format!("source blocks have more than {} source symbols", MAX_SOURCE_SYMBOLS)
+    } else {
+        return None;
+    };
+    Some(format!("Invalid OTI: {}", reason))
+}
+
+/// Number of repair symbols generated for a block of `data_len` bytes
+fn repair_symbols_count(data_len: u64, symbol_size: u16, redundancy_factor: u8) -> u64 {
+    if data_len <= symbol_size as u64 {
+        redundancy_factor as u64
+    } else {
+        (data_len as f64 * (redundancy_factor as f64 - 1.0) / symbol_size as f64).ceil() as u64
+    }
+}
+
+// Check a number of repair symbols per block against the limits of RaptorQ
+fn check_repair_symbols_per_block(repair_symbols: u32) -> Result<(), String> {
+    if repair_symbols > MAX_REPAIR_SYMBOLS_PER_BLOCK {
+        return Err(format!(
+            "Invalid repair symbols per block {}: at most {} fit the encoding symbol IDs",
+            repair_symbols, MAX_REPAIR_SYMBOLS_PER_BLOCK
+        ));
+    }
+    Ok(())
+}
+
+/// Name of the directory holding the symbols of a block, `block_<block_id>`
+pub fn block_dir_name(block_id: usize) -> String {
+    format!("{}{}", BLOCK_DIR_PREFIX, block_id)
+}
+
+/// Path of a symbol relative to the symbols directory, `block_<block_id>/<symbol_id>`
+///
+/// The symbol id is the Base58 encoded BLAKE3 hash of the symbol, as listed in the
+/// layout, so symbols can be stored and fetched by their content. The same paths
+/// are used for the entries of symbol archives.
+///
+/// The names don't carry the ESI of the symbols nor whether they are repair symbols,
+/// which would break the content addressing the layouts and the stores rely on. Both
+/// are read from the FEC payload ID the symbol starts with instead, see `symbol_esi`
+/// and `BlockLayout::is_repair_symbol`.
+pub fn symbol_path(block_id: usize, symbol_id: &str) -> String {
+    format!("{}/{}", block_dir_name(block_id), symbol_id)
+}
+
+/// Parse the path of a symbol into its block id and symbol id
+///
+/// Only the last two components are used, so the path may be relative to the
+/// symbols directory or include it. Both `/` and `\` separate components on every
+/// platform, so paths written on Windows can be parsed elsewhere and the other way round.
+///
+/// # Returns
+/// * `Err(ProcessError::InvalidPath)` if the path is not `[...]/block_<block_id>/<symbol_id>`
+pub fn parse_symbol_path(path: &str) -> Result<(usize, String), ProcessError> {
+    let mut components = path.rsplit(['/', '\\']);
+    let symbol_id = components.next();
+    let block_id = components.next()
+        .and_then(|name| name.strip_prefix(BLOCK_DIR_PREFIX))
+        .and_then(|id| id.parse::<usize>().ok());
+    match (block_id, symbol_id) {
+        (Some(block_id), Some(symbol_id)) if !matches!(symbol_id, "" | "." | "..") => Ok((block_id, symbol_id.to_string())),
+        _ => Err(ProcessError::InvalidPath(format!(
+            "{:?} is not a symbol path {}<block_id>/<symbol_id>", path, BLOCK_DIR_PREFIX
+        ))),
+    }
+}
+
+/// Check that a path can be opened before an operation runs on it
+///
+/// Spaces and any Unicode character are fine. Paths that can't be opened on any
+/// platform are rejected up front with a clear error, instead of failing with an IO
+/// error once the operation started writing its output.
+///
+/// # Returns
+/// * `Err(ProcessError::InvalidPath)` if the path is empty, holds a NUL character, is
+///   longer than `MAX_PATH_LEN_B` bytes or has a component longer than `MAX_PATH_COMPONENT_LEN_B`
+pub fn check_path(path: &str) -> Result<(), ProcessError> {
+    let err = if path.is_empty() {
+        "Path is empty".to_string()
+    } else if let Some(index) = path.find('\0') {
+        format!("Path {:?} holds a NUL character at byte {}", path, index)
+    } else if path.len() > MAX_PATH_LEN_B {
+        format!("Path of {} bytes is longer than {} bytes: {:?}...", path.len(), MAX_PATH_LEN_B, path.chars().take(64).collect::<String>())
+    } else if let Some(name) = path.split(['/', '\\']).find(|name| name.len() > MAX_PATH_COMPONENT_LEN_B) {
+        format!("Name of {} bytes is longer than {} bytes in path {:?}", name.len(), MAX_PATH_COMPONENT_LEN_B, path)
+    } else {
+        return Ok(());
+    };
+    Err(ProcessError::InvalidPath(err))
+}
+
+/// Encoding symbol ID (ESI) of a symbol, read from its FEC payload ID,
+/// None if the symbol is too short
+///
+/// The ESIs number the symbols of each source block of a block, its source symbols
+/// first, then its repair symbols, see `BlockLayout::is_repair_symbol`.
+pub fn symbol_esi(symbol: &[u8]) -> Option<u32> {
+    let payload_id = symbol.get(0..4)?;
+    Some(u32::from_be_bytes([0, payload_id[1], payload_id[2], payload_id[3]]))
+}
+
+/// Id of a symbol, the Base58 encoded BLAKE3 hash of the symbol with its FEC payload
+/// ID, under which the layouts list it and its file is named
+pub fn symbol_id(symbol: &[u8]) -> String {
+    get_hash_as_b58(symbol)
+}
+
+// Whether a symbol id of a layout can be used as the name of its file, so a
+// malicious layout can't make a decode read files outside the symbols directory
+fn is_symbol_file_name(symbol_id: &str) -> bool {
+    !symbol_id.is_empty() && symbol_id != "." && symbol_id != ".."
+        && !symbol_id.contains(['/', '\\', '\0'])
+}
+
+// Whether a symbol has a payload ID of a source block of the block followed by a whole symbol
+fn symbol_fits_block(config: &ObjectTransmissionInformation, symbol: &[u8]) -> bool {
+    symbol.len() == config.symbol_size() as usize + 4 && symbol[0] < config.source_blocks()
+}
+
+// Fill `buf` with the data of the file at `offset`, as readers may return less than
+// asked, so a block never holds bytes left in a reused buffer by a previous one
+fn read_block_data(reader: &mut dyn FileReader, offset: u64, buf: &mut [u8]) -> Result<(), ProcessError> {
+    let mut read = 0;
+    while read < buf.len() {
+        match reader.read_chunk(offset + read as u64, &mut buf[read..]) {
+            Ok(0) => return Err(ProcessError::IOError(io::Error::new(io::ErrorKind::UnexpectedEof, format!(
+                "File ends at offset {}, {} bytes before the end of the block", offset + read as u64, buf.len() - read
+            )))),
+            Ok(n) => read += n,
+            Err(e) => return Err(ProcessError::IOError(io::Error::new(io::ErrorKind::Other, e))),
+        }
+    }
+    Ok(())
+}
+
+fn get_hash_as_b58(data: &[u8]) -> String {
+    let hash = blake3::hash(data);
+    bs58::encode(hash.as_bytes()).into_string()
+}
+
+fn sha256_hex(hasher: Sha256) -> String {
+    hasher.finalize().iter().map(|b| format!("{:02x}", b)).collect()
+}
+
+/// RaptorQ encoder and decoder
+///
+/// A processor can be shared between threads. Up to `concurrency_limit` operations
+/// run at the same time, the ones started beyond fail with
+/// `ProcessError::ConcurrencyLimitReached`. The last error, its code, shortfalls and
+/// corrupt symbols are kept per thread: each thread reads those of the operations it
+/// called, whatever the other threads run on the processor at the same time. They are
+/// dropped when their thread exits.
+pub struct RaptorQProcessor {
+    config: ProcessorConfig,
+    active_tasks: AtomicUsize,
+    last_error: PerThread<String>,
+    last_error_code: PerThread<i32>,
+    last_shortfalls: PerThread<Vec<BlockShortfall>>,
+    last_corrupt_symbols: PerThread<Vec<CorruptSymbol>>,
+    cancel_epoch: AtomicUsize,
+    logger: Mutex<Option<Arc<dyn ProcessorLogger>>>,
+    metrics: Mutex<Option<Arc<dyn ProcessorMetrics>>>,
+    timeout: Mutex<Option<Duration>>,
+    cleanup_on_error: AtomicBool,
+    flat_symbol_layout: AtomicBool,
+    auto_symbol_size: AtomicBool,
+    // Block buffers kept between encodes, `None` when the pool is disabled
+    block_buffers: Mutex<Option<Vec<Vec<u8>>>>,
+    repair_symbols_per_block: Mutex<Option<u32>>,
+    symbol_codec: Mutex<SymbolCodec>,
+    symbol_cipher: Mutex<Option<Arc<SymbolCipher>>>,
+    symbol_crc: AtomicBool,
+    layout_metadata: Mutex<BTreeMap<String, String>>,
+    layout_format: Mutex<LayoutFormat>,
+    content_hash: Mutex<ContentHash>,
+    temp_dir: Mutex<Option<PathBuf>>,
+    max_source_symbols: Mutex<Option<u64>>,
+}
+
+impl RaptorQProcessor {
+    /// Create a processor with the given configuration, which is not validated
+    pub fn new(config: ProcessorConfig) -> Self {
+        ProcessorBuilder::new().config(config).create()
+    }
+
+    /// Start building a processor with the default configuration, see `ProcessorBuilder`
+    pub fn builder() -> ProcessorBuilder {
+        ProcessorBuilder::new()
+    }
+
+    /// Returns the error of the last operation of the calling thread that failed
+    pub fn get_last_error(&self) -> String {
+        self.last_error.lock().clone()
+    }
+
+    /// Returns the C API code of the last operation of the calling thread that failed
+    /// through the C API, 0 if none did since the processor was created or reset
+    pub fn get_last_error_code(&self) -> i32 {
+        *self.last_error_code.lock()
+    }
+
+    pub(crate) fn set_last_error_code(&self, code: i32) {
+        *self.last_error_code.lock() = code;
+    }
+
+    /// Returns the per-block shortfalls of the last decode of the calling thread that
+    /// failed with `ProcessError::InsufficientSymbols`, empty otherwise
+    pub fn get_last_shortfalls(&self) -> Vec<BlockShortfall> {
+        self.last_shortfalls.lock().clone()
+    }
+
+    /// Returns the symbols found corrupt by the last verified decode of the calling
+    /// thread, whether it succeeded or not
+    pub fn get_last_corrupt_symbols(&self) -> Vec<CorruptSymbol> {
+        self.last_corrupt_symbols.lock().clone()
+    }
+
+    /// Cancels the operations in progress on this processor
     ///
-    /// # Arguments
+    /// They stop before processing their next block and fail with
+    /// `ProcessError::Cancelled`. Operations started afterwards are not affected.
+    pub fn cancel(&self) {
+        self.cancel_epoch.fetch_add(1, Ordering::SeqCst);
+    }
+
+    /// Clears the state left by previous operations, so the processor can be reused
+    /// for a new operation as if it was just created
     ///
-    /// * `input_path` - Path to the input file
-    /// * `output_path` - Path of the output layout file will be written
-    /// * `block_size` - Size of blocks to process at once (0 = auto)
-    /// * `return_layout` - Whether to return the layout as an object instead of writing to file
+    /// The last error and its code, shortfalls and corrupt symbols of every thread are
+    /// emptied, and the buffers kept by the block buffer pool are released while the
+    /// pool stays enabled (see `set_buffer_pool`). Operations in progress are not
+    /// affected and may set them again, call `cancel` first to stop them. The logger,
+    /// metrics, timeout and encoding options are kept.
+    pub fn reset(&self) {
+        self.last_error.clear();
+        self.last_error_code.clear();
+        self.last_shortfalls.clear();
+        self.last_corrupt_symbols.clear();
+        if let Some(buffers) = self.block_buffers.lock().as_mut() {
+            *buffers = Vec::new();
+        }
+    }
+
+    /// Set every option of the processor to the one of `source`: the logger, metrics,
+    /// timeout and encoding options set with the builder or the setters
     ///
-    /// # Returns
+    /// The configuration, which can't change, and the operation state are not copied.
+    pub fn copy_options_from(&self, source: &RaptorQProcessor) {
+        if std::ptr::eq(self, source) {
+            return;
+        }
+        self.set_logger(source.logger.lock().clone());
+        self.set_metrics(source.metrics.lock().clone());
+        self.set_timeout(*source.timeout.lock());
+        self.set_cleanup_on_error(source.cleanup_on_error.load(Ordering::SeqCst));
+        self.set_flat_symbol_layout(source.flat_symbol_layout.load(Ordering::SeqCst));
+        self.set_auto_symbol_size(source.auto_symbol_size.load(Ordering::SeqCst));
+        self.set_buffer_pool(source.block_buffers.lock().is_some());
+        *self.repair_symbols_per_block.lock() = *source.repair_symbols_per_block.lock();
+        self.set_symbol_codec(*source.symbol_codec.lock());
+        *self.symbol_cipher.lock() = source.symbol_cipher.lock().clone();
+        self.set_symbol_crc(source.symbol_crc.load(Ordering::SeqCst));
+        *self.layout_metadata.lock() = source.layout_metadata.lock().clone();
+        self.set_layout_format(*source.layout_format.lock());
+        self.set_content_hash(*source.content_hash.lock());
+        *self.temp_dir.lock() = source.temp_dir.lock().clone();
+        self.set_max_source_symbols(*source.max_source_symbols.lock());
+    }
+
+    /// Set the logger receiving the events of `encode_file` and `decode_symbols`,
+    /// see the `logging` module; `None` stops logging, which is the default
+    pub fn set_logger(&self, logger: Option<Arc<dyn ProcessorLogger>>) {
+        *self.logger.lock() = logger;
+    }
+
+    /// Set the metrics updated by `encode_file` and `decode_symbols` and by every
+    /// block encoded or decoded, see the `metrics` module; `None` stops measuring,
+    /// which is the default
+    pub fn set_metrics(&self, metrics: Option<Arc<dyn ProcessorMetrics>>) {
+        *self.metrics.lock() = metrics;
+    }
+
+    /// Set the wall-clock limit of each operation started afterwards; `None`, the
+    /// default, lets operations run until they are done or cancelled
     ///
-    /// * `Ok(ProcessResult)` with the layout information
-    /// * `Err(ProcessError)` on failure
-    /// Create metadata for a file without generating symbols
+    /// An operation over its limit stops like a cancelled one, before processing its
+    /// next block, and fails with `ProcessError::TimedOut`, so it can run over by the
+    /// time of one block. The output written before is kept: an encode leaves the
+    /// symbols of the blocks done but no layout file, and a decode a partial file,
+    /// unless `encode_file` or the decode removes them (see `set_cleanup_on_error`).
+    /// For `begin_encode_file`, the limit covers the whole job.
+    pub fn set_timeout(&self, timeout: Option<Duration>) {
+        *self.timeout.lock() = timeout;
+    }
+
+    /// Set whether `encode_file` removes the symbols it wrote when it fails, and the
+    /// decodes to an output file remove it, on by default
     ///
-    /// This method calculates symbol IDs and creates a layout file if `layout_file` is non-empty,
-    /// or returns the layout as an object if `layout_file` is an empty string.
+    /// The block directories of the blocks it started are removed, whatever the failure:
+    /// a write error, a cancellation or a timeout, so no partial set of symbols is
+    /// left for a decode or a validation to trip over. The output directory itself is kept.
+    /// Off, the symbols written are kept but there is no layout file, as it is written last.
     ///
-    /// # Arguments
-    /// * `input_path` - Path to the input file
-    /// * `layout_file` - Path to the output layout file (if empty, returns layout as object)
-    /// * `block_size` - Size of blocks to process at once (0 = auto)
+    /// A decode stops at the first block it can't decode. The output file of
+    /// `decode_symbols` and the other decodes of a symbols directory is then removed,
+    /// as it would only hold the blocks before; off, it is kept with them.
+    pub fn set_cleanup_on_error(&self, cleanup_on_error: bool) {
+        self.cleanup_on_error.store(cleanup_on_error, Ordering::SeqCst);
+    }
+
+    /// Set whether encodes write all symbols directly in the output directory instead
+    /// of a `block_<block_id>` directory per block, off by default
+    ///
+    /// Symbol ids are hashes of the symbols, so the symbols of all blocks can share one
+    /// directory, which suits stores that handle deep trees poorly. Decodes find the
+    /// symbols of a block in the symbols directory when it has no block directory, so
+    /// they read both layouts whatever this option. It applies to `encode_block` and
+    /// `generate_repair_symbols` as well, which write to the output directory they are given.
+    pub fn set_flat_symbol_layout(&self, flat_symbol_layout: bool) {
+        self.flat_symbol_layout.store(flat_symbol_layout, Ordering::SeqCst);
+    }
+
+    /// Set whether encodes fit the symbol size to each block, off by default
+    ///
+    /// A block is split into as many source symbols as with the configured symbol size,
+    /// the smallest aligned size holding the block, so a block much smaller than the
+    /// others, like the tail of an object, isn't padded to a whole symbol. Full blocks of
+    /// a multiple of the symbol size keep it. The encoder parameters of each block in the
+    /// layout record its symbol size, which decodes read whatever this option.
+    pub fn set_auto_symbol_size(&self, auto_symbol_size: bool) {
+        self.auto_symbol_size.store(auto_symbol_size, Ordering::SeqCst);
+    }
+
+    /// Set whether encodes keep their block buffer for the next encode, off by default
+    ///
+    /// An encode reads each block of its input into a buffer of the block size. With the
+    /// pool, encodes reuse the buffers of the previous ones instead of allocating them,
+    /// which suits services encoding many objects of similar sizes. The pool keeps one
+    /// buffer per task the processor can run, of the largest block they read; disabling
+    /// it or `reset` releases them.
+    pub fn set_buffer_pool(&self, buffer_pool: bool) {
+        let mut block_buffers = self.block_buffers.lock();
+        match (buffer_pool, block_buffers.is_some()) {
+            (true, false) => *block_buffers = Some(Vec::new()),
+            (false, true) => *block_buffers = None,
+            _ => {},
+        }
+    }
+
+    // Encoder parameters of a block of the given size and symbol size, see `set_auto_symbol_size`
+    fn block_encoder_config(&self, block_size: u64, symbol_size: u16) -> ObjectTransmissionInformation {
+        let aligned = (symbol_size - symbol_size % SYMBOL_ALIGNMENT) as u64;
+        if !self.auto_symbol_size.load(Ordering::SeqCst) || block_size == 0 || aligned == 0 {
+            return ObjectTransmissionInformation::with_defaults(block_size, symbol_size);
+        }
+        // The same number of symbols, each at most the configured size
+        let symbols = block_size.div_ceil(aligned);
+        let fitted = block_size.div_ceil(symbols).next_multiple_of(SYMBOL_ALIGNMENT as u64);
+        ObjectTransmissionInformation::with_defaults(block_size, fitted as u16)
+    }
+
+    // Take a buffer for the blocks of an encode, from the pool when it is enabled
+    fn take_block_buffer(&self) -> Vec<u8> {
+        self.block_buffers.lock().as_mut().and_then(|buffers| buffers.pop()).unwrap_or_default()
+    }
+
+    // Keep the block buffer of a finished encode for the next one when the pool is enabled
+    fn return_block_buffer(&self, buffer: Vec<u8>) {
+        if let Some(buffers) = self.block_buffers.lock().as_mut() {
+            if buffers.len() < self.config.concurrency_limit as usize {
+                buffers.push(buffer);
+            }
+        }
+    }
+
+    /// Set the number of repair symbols generated for every block by the encodes started
+    /// afterwards; `None`, the default, derives it from the redundancy factor
+    ///
+    /// The redundancy factor gives a number of repair symbols proportional to the size
+    /// of each block, a fixed number suits durability targets set per block. The
+    /// number applies to the last, smaller block of a file as well, and is reflected
+    /// in the symbols of the layout and the counts of the result.
     ///
     /// # Returns
-    /// * `Ok(ProcessResult)` with the layout information
-    /// * `Err(ProcessError)` on failure
-    pub fn create_metadata(
-        &self,
-        input_path: &str,
-        layout_file: &str,
-        block_size: usize,
-    ) -> Result<ProcessResult, ProcessError> {
-        // Prepare for processing
-        let (file_reader, file_size, actual_block_size) = self.prepare_processing(
-            input_path,
-            block_size,
-            false, // We don't force single file for metadata creation
-        )?;
+    /// * `Err(ProcessError::InvalidParameter)` if it exceeds `MAX_REPAIR_SYMBOLS_PER_BLOCK`
+    pub fn set_repair_symbols_per_block(&self, repair_symbols: Option<u32>) -> Result<(), ProcessError> {
+        if let Some(repair_symbols) = repair_symbols {
+            check_repair_symbols_per_block(repair_symbols).map_err(|err| {
+                self.set_last_error(err.clone());
+                ProcessError::InvalidParameter(err)
+            })?;
+        }
+        *self.repair_symbols_per_block.lock() = repair_symbols;
+        Ok(())
+    }
 
-        debug!(
-            "Creating metadata for file: {:?} ({}B) with block size {}B",
-            input_path, file_size, actual_block_size
-        );
+    /// Set the compression of the symbol files written by the encodes started
+    /// afterwards, `SymbolCodec::None` by default
+    ///
+    /// Each symbol is compressed on its own and the codec is recorded in the layout of
+    /// the object, so decodes decompress its symbols whatever the codec of the processor,
+    /// and a directory holding the symbols of objects encoded with different codecs
+    /// still decodes. Symbol ids are the hashes of the uncompressed symbols. It doesn't
+    /// apply to the symbols returned in memory or written to an archive. `resume_encode`
+    /// expects the codec of the encode it resumes.
+    pub fn set_symbol_codec(&self, symbol_codec: SymbolCodec) {
+        *self.symbol_codec.lock() = symbol_codec;
+    }
 
-        // If layout_file is empty, return layout as object; else, write to file
-        let return_layout = layout_file.is_empty();
-        self.process_file_blocks(
-            file_reader,
-            "", // output_dir is not used for metadata-only
-            actual_block_size,
-            file_size,
-            true, // metadata_only = true
-            return_layout,
-            layout_file,
-        )
+    /// Set the metadata of the application recorded in the layouts written afterwards,
+    /// none by default
+    ///
+    /// The key-values, e.g. an object id, a content type or a creation time, travel with
+    /// the layout and are returned as they are by `RaptorQLayout::read_file` and the
+    /// layout summaries; the library doesn't interpret them. An empty map records none.
+    pub fn set_layout_metadata(&self, metadata: BTreeMap<String, String>) {
+        *self.layout_metadata.lock() = metadata;
     }
 
-    /// Encode a file using RaptorQ
-    pub fn encode_file(
-        &self,
-        input_path: &str,
-        output_dir: &str,
-        block_size: usize,
-        force_single_file: bool,
-    ) -> Result<ProcessResult, ProcessError> {
-        // Prepare for processing
-        let (file_reader, file_size, actual_block_size) = self.prepare_processing(
-            input_path,
-            block_size,
-            force_single_file,
-        )?;
+    /// Set the format of the layout files written afterwards, `LayoutFormat::Json` by default
+    ///
+    /// It applies to the layout files of the encodes and to the ones rewritten by the
+    /// operations adding symbols to a layout, e.g. `generate_repair_symbols`. The binary
+    /// format is smaller and faster to parse for objects with many blocks, but can't be
+    /// read by JSON tools. Decodes detect the format of a layout file, so layouts of both
+    /// formats decode whatever the format of the processor. The layout returned in memory
+    /// in `ProcessResult::layout_content` and written to archives stays JSON.
+    pub fn set_layout_format(&self, layout_format: LayoutFormat) {
+        *self.layout_format.lock() = layout_format;
+    }
 
-        debug!(
-            "Processing file: {:?} ({}B) with block size {}B",
-            input_path, file_size, actual_block_size
-        );
+    /// Set the hash of the source computed by the encodes started afterwards,
+    /// `ContentHash::Sha256` by default
+    ///
+    /// The source is hashed as it is read for encoding and the hash is returned in
+    /// `ProcessResult::content_hash`, so the object doesn't need to be read again to
+    /// hash it. Only SHA-256 is recorded in the layout, as `object_sha256`, which
+    /// `decode_symbols_checked` and the detection of the symbols of another object rely
+    /// on. `ContentHash::None` saves the cost of hashing on large objects.
+    pub fn set_content_hash(&self, content_hash: ContentHash) {
+        *self.content_hash.lock() = content_hash;
+    }
 
-        // Generate default layout file path
-        let layout_file = std::path::Path::new(output_dir).join(LAYOUT_FILENAME).to_string_lossy().to_string();
+    /// Set the directory of the scratch files of the operations started afterwards;
+    /// `None`, the default, uses the temp directory of the system
+    ///
+    /// Decodes from a source of symbols in any order (`decode_from_source`,
+    /// `decode_from_tar`, `decode_from_files`...) hold the blocks decoded before the
+    /// blocks preceding them in memory, and write them to a scratch file once they take
+    /// more than `max_memory_mb`. In containers with a small /tmp, point it to a data
+    /// volume. Scratch files are removed when the operation ends. Encodes, including
+    /// `encode_stream`, and the decodes of symbols directories need no scratch files.
+    pub fn set_temp_dir(&self, temp_dir: Option<PathBuf>) {
+        *self.temp_dir.lock() = temp_dir;
+    }
 
-        // Process file blocks - create actual symbols
-        self.process_file_blocks(
-            file_reader,
-            output_dir,
-            actual_block_size,
-            file_size,
-            false, // metadata_only = false
-            false, // return_layout = false
-            &layout_file,
-        )
+    /// Directory of the scratch files, the one set with `set_temp_dir` or the temp
+    /// directory of the system; `None` in the browser when unset, where blocks are
+    /// then kept in memory
+    pub fn temp_dir(&self) -> Option<PathBuf> {
+        let temp_dir = self.temp_dir.lock().clone();
+        #[cfg(not(target_arch = "wasm32"))]
+        let temp_dir = temp_dir.or_else(|| Some(std::env::temp_dir()));
+        temp_dir
     }
 
-    /// Prepare the file for processing
+    /// Set the most symbols `decode_from_source` and `decode_from_channel` pull from
+    /// their source; `None`, the default, pulls until the source ends
     ///
-    /// This helper method handles common setup for encode_file and create_metadata
-    fn prepare_processing(
-        &self,
-        input_path: &str,
-        block_size: usize,
-        force_single_file: bool,
-    ) -> Result<(Box<dyn FileReader>, usize, usize), ProcessError> {
-        // Check if we can take another task
-        if !self.can_start_task() {
-            return Err(ProcessError::ConcurrencyLimitReached);
+    /// A block that doesn't decode gets more symbols until it does, so a source that
+    /// keeps giving undecodable symbols, e.g. corrupt ones or ones of another object,
+    /// would be read forever. Once the limit is reached before every block is decoded,
+    /// the decode stops reading and fails with `ProcessError::InsufficientSymbols`, as
+    /// if the source had ended. Every symbol pulled counts, including the ones ignored.
+    pub fn set_max_source_symbols(&self, max_symbols: Option<u64>) {
+        *self.max_source_symbols.lock() = max_symbols;
+    }
+
+    /// Set the key encrypting the symbol files written by the encodes started afterwards,
+    /// and decrypting the ones of the layouts encrypted with it; `None`, the default,
+    /// writes them in the clear
+    ///
+    /// Symbols are encrypted with AES-GCM, AES-128 for a key of 16 bytes and AES-256
+    /// for 32 bytes, after their compression; see the `encryption` module for the nonces.
+    /// Tampered symbols fail authentication and are skipped like missing ones. The layout
+    /// records the id of the key, not the key, and is itself written in the clear.
+    /// Decoding the layout of encrypted symbols without their key fails with
+    /// `ProcessError::InvalidConfig`. It doesn't apply to the symbols returned in memory
+    /// or written to an archive.
+    ///
+    /// The symbol ids naming the files stay the unkeyed hashes of the plain symbols, so
+    /// they let anyone confirm a guess of the content, see the `encryption` module.
+    ///
+    /// # Returns
+    /// * `Err(ProcessError::InvalidParameter)` if the key is neither 16 nor 32 bytes long
+    pub fn set_symbol_key(&self, key: Option<&[u8]>) -> Result<(), ProcessError> {
+        let cipher = match key {
+            Some(key) => Some(Arc::new(SymbolCipher::new(key).map_err(|err| {
+                self.set_last_error(err.clone());
+                ProcessError::InvalidParameter(err)
+            })?)),
+            None => None,
+        };
+        *self.symbol_cipher.lock() = cipher;
+        Ok(())
+    }
+
+    /// Set whether the symbol files written by the encodes started afterwards start with
+    /// a header holding their length and CRC32, off by default
+    ///
+    /// Decodes check the header of each file before decrypting and decoding it, and skip
+    /// the truncated or bit-flipped ones like missing symbols, which the repair symbols
+    /// cover, at the cost of a CRC32 instead of a hash. The layout records whether the
+    /// files have headers, so decodes read them whatever the option, and the symbols of
+    /// older layouts still decode. It doesn't apply to the symbols returned in memory or
+    /// written to an archive.
+    pub fn set_symbol_crc(&self, symbol_crc: bool) {
+        self.symbol_crc.store(symbol_crc, Ordering::SeqCst);
+    }
+
+    // How the encodes started now write the symbol files
+    fn symbol_format(&self) -> SymbolFormat {
+        SymbolFormat {
+            codec: *self.symbol_codec.lock(),
+            cipher: self.symbol_cipher.lock().clone(),
+            crc: self.symbol_crc.load(Ordering::SeqCst),
+            max_symbol_len: MAX_SYMBOL_LEN,
         }
-        let _guard = TaskGuard::new(&self.active_tasks);
+    }
 
-        let (file_reader, file_size) = match self.open_and_validate_file(input_path) {
-            Ok(result) => result,
-            Err(e) => {
-                self.set_last_error(e.to_string());
-                return Err(e);
+    // How the symbol files of a layout are written, with the key of the processor
+    // if they are encrypted
+    fn layout_symbol_format(&self, layout: &RaptorQLayout) -> Result<SymbolFormat, ProcessError> {
+        let cipher = if layout.symbol_key_id.is_empty() {
+            None
+        } else {
+            let cipher = self.symbol_cipher.lock().clone();
+            match cipher {
+                Some(cipher) if cipher.key_id() == layout.symbol_key_id => Some(cipher),
+                cipher => {
+                    let err = match cipher {
+                        Some(cipher) => format!(
+                            "Symbols are encrypted with the key {}, the processor has the key {}",
+                            layout.symbol_key_id, cipher.key_id()
+                        ),
+                        None => format!("Symbols are encrypted with the key {}, the processor has no key", layout.symbol_key_id),
+                    };
+                    self.set_last_error(err.clone());
+                    return Err(ProcessError::InvalidConfig(err));
+                }
             }
         };
+        // The symbols of the blocks and their payload ID, a symbol file can't hold more
+        let max_symbol_len = layout.blocks.iter().map(|b| b.symbol_size() as usize + 4).max().unwrap_or(MAX_SYMBOL_LEN);
+        Ok(SymbolFormat { codec: layout.symbol_codec, cipher, crc: layout.symbol_crc, max_symbol_len })
+    }
 
-        // Determine the block size
-        let actual_block_size = if force_single_file {
-            let memory_required = self.estimate_memory_requirements(file_size);
-            if !self.is_memory_available(memory_required) {
-                let err = ProcessError::MemoryLimitExceeded {
-                    required: memory_required,
-                    available: self.config.max_memory_mb as usize,
-                };
-                self.set_last_error(err.to_string());
-                return Err(err);
-            }
-            debug!("Processing the file forced to skip splitting: {:?} ({}B)", input_path, file_size);
-            file_size
-        } else if block_size == 0 && self.get_recommended_block_size(file_size) == 0 {
-            // Use file size as block size for single file mode
-            debug!("Processing the file without splitting: {:?} ({}B)", input_path, file_size);
-            file_size
-        } else if block_size == 0 {
-            // Auto determine block size
-            let recommended = self.get_recommended_block_size(file_size);
-            debug!("Using the recommended block size: {}B", recommended);
-            recommended
+    // Directory the symbols of a block are written to
+    fn block_output_dir(&self, output_dir: &str, block_id: usize) -> PathBuf {
+        if self.flat_symbol_layout.load(Ordering::SeqCst) {
+            PathBuf::from(output_dir)
         } else {
-            // Use provided block size
-            debug!("Using the provided block size: {}B", block_size);
-            block_size
+            Path::new(output_dir).join(block_dir_name(block_id))
+        }
+    }
+
+    // Run an operation, reporting it to the logger and metrics if they are set
+    fn observe_operation<T>(
+        &self,
+        operation: &'static str,
+        path: &str,
+        run: impl FnOnce() -> Result<T, ProcessError>,
+        stats: impl Fn(&T) -> OperationStats,
+    ) -> Result<T, ProcessError> {
+        let log = self.logger.lock().clone().map(|logger| OperationLog::start(logger, operation, path));
+        let timer = self.start_timer();
+
+        let result = run();
+
+        if let Some((metrics, started)) = timer {
+            let counts = result.as_ref().map(&stats).unwrap_or_default();
+            metrics.record_operation(&OperationRecord {
+                operation,
+                succeeded: result.is_ok(),
+                object_size: counts.object_size,
+                blocks: counts.blocks,
+                symbols: counts.symbols,
+                duration: started.elapsed(),
+            });
+        }
+        if let Some(log) = log {
+            log.finish(&result, &stats);
+        }
+        result
+    }
+
+    // Start measuring a duration if metrics are set
+    fn start_timer(&self) -> Option<(Arc<dyn ProcessorMetrics>, Instant)> {
+        let metrics = self.metrics.lock().clone()?;
+        Some((metrics, Instant::now()))
+    }
+
+    pub(crate) fn set_last_error(&self, error: String) {
+        *self.last_error.lock() = error;
+    }
+
+    #[allow(dead_code)] // Only used in tests
+    pub fn get_config(&self) -> &ProcessorConfig {
+        &self.config
+    }
+
+    /// Symbol size of the configuration, used by the encodes unless
+    /// `encode_file_with_symbol_size` overrides it
+    pub fn symbol_size(&self) -> u16 {
+        self.config.symbol_size
+    }
+
+    /// Number of task slots free right now, out of the concurrency limit
+    ///
+    /// An operation started now can run, and a parallel operation gets up to this many
+    /// workers. Tasks of other threads may take the slots at any time, so this is a hint
+    /// for sizing the workers of a pipeline, not a reservation.
+    pub fn available_task_slots(&self) -> usize {
+        (self.config.concurrency_limit as usize).saturating_sub(self.active_tasks.load(Ordering::SeqCst))
+    }
+
+    pub fn get_recommended_block_size(&self, file_size: usize) -> usize {
+        self.recommended_block_size(file_size, self.config.symbol_size)
+    }
+
+    // Recommended block size of `get_recommended_block_size` with symbols of the given size
+    fn recommended_block_size(&self, file_size: usize, symbol_size: u16) -> usize {
+        // If the file is smaller than max memory divided by MEMORY_SAFETY_MARGIN, don't split it
+        if file_size < self.safe_memory() {
+            return Self::cap_block_size(file_size, 0, symbol_size);
+        }
+
+        self.memory_block_size(symbol_size)
+    }
+
+    // Memory the processor plans to use, max memory divided by MEMORY_SAFETY_MARGIN
+    fn safe_memory(&self) -> usize {
+        let max_memory_bytes = self.config.max_memory_mb.saturating_mul(1024 * 1024);
+        (max_memory_bytes as f64 / MEMORY_SAFETY_MARGIN) as usize
+    }
+
+    // Block size using about 1/4 of the safe memory, whatever the size of the data,
+    // a multiple of the symbol size and at most `max_block_size`
+    fn memory_block_size(&self, symbol_size: u16) -> usize {
+        let target_block_size = self.safe_memory() / 4;
+
+        // Ensure block size is a multiple of symbol size for efficient processing
+        let blocks = (target_block_size / symbol_size as usize).max(1);
+        Self::cap_block_size(usize::MAX, blocks * symbol_size as usize, symbol_size)
+    }
+
+    /// Largest block size the processor can encode, in bytes
+    ///
+    /// RaptorQ splits a block into at most 255 source blocks of at most 56403 symbols,
+    /// so the limit grows with the symbol size: about 110 MB with 8-byte symbols and
+    /// 14 GB with 1024-byte ones. It is also capped by the transfer length of RFC 6330
+    /// and by what the platform can address. It is a multiple of the symbol size.
+    pub fn max_block_size(&self) -> u64 {
+        Self::block_size_limit(self.config.symbol_size)
+    }
+
+    // Largest block size of `max_block_size` with symbols of the given size
+    fn block_size_limit(symbol_size: u16) -> u64 {
+        let symbol_size = (symbol_size - symbol_size % SYMBOL_ALIGNMENT) as u64;
+        let max = (MAX_SOURCE_BLOCKS * MAX_SOURCE_SYMBOLS as u64 * symbol_size)
+            .min(MAX_TRANSFER_LENGTH)
+            .min(usize::MAX as u64);
+        max / symbol_size * symbol_size
+    }
+
+    /// Check that blocks of the given size can be encoded with the symbol size of the processor
+    ///
+    /// `encode_file` and the other encodes run this check on their block size, before
+    /// reading any data. A block needs one source symbol per symbol size bytes, and
+    /// RaptorQ encodes at most 255 source blocks of 56403 symbols, see `max_block_size`.
+    ///
+    /// # Returns
+    /// * `Err(ProcessError::InvalidParameter)` if the block is too large, with the number
+    ///   of source symbols it needs and the smallest symbol size or the largest block
+    ///   size that would do
+    pub fn validate_block_size(&self, block_size: u64) -> Result<(), ProcessError> {
+        Self::check_block_size(block_size, self.config.symbol_size)
+    }
+
+    // Check of `validate_block_size` with symbols of the given size
+    fn check_block_size(block_size: u64, configured_symbol_size: u16) -> Result<(), ProcessError> {
+        let max_block_size = Self::block_size_limit(configured_symbol_size);
+        if block_size <= max_block_size {
+            return Ok(());
+        }
+        let symbol_size = (configured_symbol_size - configured_symbol_size % SYMBOL_ALIGNMENT) as u64;
+        let mut message = format!(
+            "block size {} exceeds the largest block size {} with symbols of {} bytes",
+            block_size, max_block_size, configured_symbol_size
+        );
+        if symbol_size != configured_symbol_size as u64 {
+            message.push_str(&format!(" (rounded down to {})", symbol_size));
+        }
+        let max_symbols = MAX_SOURCE_BLOCKS * MAX_SOURCE_SYMBOLS as u64;
+        let symbols = block_size.div_ceil(symbol_size);
+        // A block with few enough symbols is over the transfer length or what the
+        // platform can address, whatever the symbol size
+        if symbols > max_symbols {
+            message.push_str(&format!(
+                ": it needs {} source symbols, more than the {} of {} source blocks of {} symbols",
+                symbols, max_symbols, MAX_SOURCE_BLOCKS, MAX_SOURCE_SYMBOLS
+            ));
+        }
+        let min_symbol_size = block_size.div_ceil(max_symbols).next_multiple_of(SYMBOL_ALIGNMENT as u64);
+        if block_size <= MAX_TRANSFER_LENGTH.min(usize::MAX as u64) && min_symbol_size <= (u16::MAX - u16::MAX % SYMBOL_ALIGNMENT) as u64 {
+            message.push_str(&format!("; use symbols of at least {} bytes or blocks of at most {} bytes", min_symbol_size, max_block_size));
+        } else {
+            message.push_str(&format!("; use blocks of at most {} bytes", max_block_size));
+        }
+        Err(ProcessError::InvalidParameter(message))
+    }
+
+    // Caps a recommended block size to the largest block size with symbols of the given
+    // size, a file too large for a single block being split even when the recommendation is not to split it (0)
+    fn cap_block_size(file_size: usize, block_size: usize, symbol_size: u16) -> usize {
+        let max = Self::block_size_limit(symbol_size) as usize;
+        if block_size == 0 && file_size <= max {
+            0
+        } else if block_size == 0 {
+            max
+        } else {
+            block_size.min(max)
+        }
+    }
+
+    /// Get a recommended block size for a file, balancing the number of blocks against
+    /// the memory and the cores of the host
+    ///
+    /// The blocks are meant to be encoded by up to `workers` at once, the number of cores
+    /// capped by the concurrency limit, as `encode_reader_at` does:
+    /// * With several workers, the file is split into one block per worker, so they all
+    ///   have one, but blocks are not smaller than `MIN_PARALLEL_BLOCK_SIZE_B`.
+    /// * With one worker, the file is split only if it doesn't fit in the memory, as
+    ///   with `get_recommended_block_size`.
+    /// * Each worker holding a block, blocks are at most a quarter of the memory divided
+    ///   by 1.5 for safety, divided by the number of workers.
+    ///
+    /// So a host with many cores and memory gets more, smaller blocks, and a constrained
+    /// host fewer, larger ones. Block sizes are multiples of the symbol size, at most
+    /// `max_block_size`.
+    ///
+    /// # Arguments
+    /// * `file_size` - Size of the file in bytes
+    /// * `available_memory_mb` - Memory available for the encode (0 = the memory limit
+    ///   of the configuration)
+    /// * `cores` - Number of cores available for the encode (0 = those of the host)
+    ///
+    /// # Returns
+    /// * The recommended block size in bytes, 0 if the file should not be split
+    pub fn get_recommended_block_size_for(&self, file_size: usize, available_memory_mb: u64, cores: usize) -> usize {
+        let memory_mb = if available_memory_mb == 0 { self.config.max_memory_mb } else { available_memory_mb };
+        let cores = if cores == 0 {
+            std::thread::available_parallelism().map_or(1, |n| n.get())
+        } else {
+            cores
+        };
+        let workers = cores.min(self.config.concurrency_limit as usize).max(1);
+
+        let safe_memory = (memory_mb.saturating_mul(1024 * 1024) as f64 / MEMORY_SAFETY_MARGIN) as usize;
+        let memory_block_size = safe_memory / 4 / workers;
+        let block_size = if workers > 1 {
+            file_size.div_ceil(workers).max(MIN_PARALLEL_BLOCK_SIZE_B).min(memory_block_size)
+        } else if file_size < safe_memory {
+            return 0;
+        } else {
+            memory_block_size
+        };
+        if block_size >= file_size {
+            return Self::cap_block_size(file_size, 0, self.config.symbol_size);
+        }
+
+        let symbol_size = self.config.symbol_size as usize;
+        Self::cap_block_size(file_size, (block_size / symbol_size).max(1) * symbol_size, self.config.symbol_size)
+    }
+
+    /// Estimate the peak memory, in bytes, used to encode a file of the given size
+    /// with the configuration of the processor
+    ///
+    /// Blocks are encoded one after the other, so the peak is reached with the largest
+    /// block: the block itself with the RaptorQ working memory, plus all of its source
+    /// and repair symbols, which are generated before being written. Each operation of
+    /// the processor needs its own memory, so up to `concurrency_limit` times the
+    /// estimate is used when operations run in parallel.
+    ///
+    /// # Arguments
+    /// * `file_size` - Size of the file in bytes
+    /// * `block_size` - Size of blocks the file will be encoded with (0 = recommended)
+    ///
+    /// # Returns
+    /// * `Ok(u64)` with the estimated peak memory in bytes
+    /// * `Err(ProcessError::InvalidParameter)` if the file is empty
+    pub fn estimate_peak_memory(&self, file_size: u64, block_size: usize) -> Result<u64, ProcessError> {
+        if file_size == 0 {
+            let err = ProcessError::InvalidParameter("file size must be greater than 0".to_string());
+            self.set_last_error(err.to_string());
+            return Err(err);
+        }
+
+        let file_size = usize::try_from(file_size).unwrap_or(usize::MAX);
+        let block_size = self.resolve_block_size("<estimate>", file_size, block_size, false, self.config.symbol_size)?;
+        let largest_block = block_size.min(file_size) as u64;
+
+        let symbol_size = self.config.symbol_size as u64;
+        let source_symbols = (largest_block + symbol_size - 1) / symbol_size;
+        let repair_symbols = self.calculate_repair_symbols(largest_block, self.config.symbol_size);
+        // Each symbol is serialized with its 4-byte FEC payload ID
+        let symbols_bytes = (source_symbols + repair_symbols) * (symbol_size + 4);
+
+        let block_bytes = (largest_block as f64 * RAPTORQ_MEMORY_OVERHEAD_FACTOR).ceil() as u64;
+        Ok(block_bytes + symbols_bytes)
+    }
+
+    /// Estimate the storage used by the symbol files of a file of the given size
+    ///
+    /// The file is split into blocks as `encode_file` would, and every block stores its
+    /// source and repair symbols: the data padded to whole symbols, the repair symbols
+    /// of the redundancy factor or of `set_repair_symbols_per_block`, and the 4-byte
+    /// payload ID of each symbol, plus the nonce and tag of each file with a symbol key
+    /// and its header with symbol CRCs.
+    /// Compressed symbol files are usually smaller, the estimate is their size before
+    /// compression. The layout file is not included.
+    ///
+    /// # Arguments
+    /// * `file_size` - Size of the file in bytes
+    /// * `block_size` - Size of blocks the file will be encoded with (0 = recommended)
+    ///
+    /// # Returns
+    /// * `Ok(StorageEstimate)` with the bytes of the symbol files and the overhead over the file
+    /// * `Err(ProcessError::InvalidParameter)` if the file is empty or the block too large
+    pub fn estimate_storage(&self, file_size: u64, block_size: usize) -> Result<StorageEstimate, ProcessError> {
+        if file_size == 0 {
+            let err = ProcessError::InvalidParameter("file size must be greater than 0".to_string());
+            self.set_last_error(err.to_string());
+            return Err(err);
+        }
+
+        let block_size = self.resolve_block_size("<estimate>", usize::try_from(file_size).unwrap_or(usize::MAX), block_size, false, self.config.symbol_size)?;
+        let block_size = (block_size as u64).min(file_size);
+        let mut file_overhead = if self.symbol_cipher.lock().is_some() { SymbolCipher::OVERHEAD as u64 } else { 0 };
+        if self.symbol_crc.load(Ordering::SeqCst) {
+            file_overhead += SymbolHeader::LEN as u64;
+        }
+
+        // All blocks but the last one have the same size
+        let block_storage = |size: u64| {
+            let config = self.block_encoder_config(size, self.config.symbol_size);
+            let symbols = source_symbols_count(&config) + self.calculate_repair_symbols(size, self.config.symbol_size);
+            (symbols, symbols * (config.symbol_size() as u64 + 4 + file_overhead))
+        };
+        let (full_symbols, full_bytes) = block_storage(block_size);
+        let full_blocks = file_size / block_size;
+        let (last_symbols, last_bytes) = match file_size % block_size {
+            0 => (0, 0),
+            last_size => block_storage(last_size),
+        };
+
+        let symbol_bytes = full_bytes * full_blocks + last_bytes;
+        Ok(StorageEstimate {
+            object_size: file_size,
+            symbols_count: full_symbols * full_blocks + last_symbols,
+            symbol_bytes,
+            overhead_fraction: symbol_bytes as f64 / file_size as f64 - 1.0,
+        })
+    }
+
+    /// Get a recommended redundancy factor for a file, given the fraction of its
+    /// symbols expected to be lost in the network or the storage
+    ///
+    /// Symbols are assumed to be lost independently with probability
+    /// `expected_loss_fraction`, so the symbols received for a block of n symbols
+    /// follow a binomial distribution. The recommendation is the smallest factor for
+    /// which every block, split as with the recommended block size, receives its
+    /// source symbols plus DECODE_SYMBOL_OVERHEAD with a margin of
+    /// REDUNDANCY_Z_SCORE standard deviations in the normal approximation, that is
+    /// a failure probability around one in a million per block.
+    /// The factor is clamped to 255, which may not be enough for loss fractions close to 1.
+    ///
+    /// # Returns
+    /// * `Ok(u8)` with the redundancy factor, at least 1
+    /// * `Err(ProcessError::InvalidParameter)` if the file is empty or the fraction
+    ///   is not in [0, 1)
+    pub fn get_recommended_redundancy(&self, file_size: u64, expected_loss_fraction: f64) -> Result<u8, ProcessError> {
+        if file_size == 0 {
+            return Err(ProcessError::InvalidParameter("file size must be greater than 0".to_string()));
+        }
+        if !(0.0..1.0).contains(&expected_loss_fraction) {
+            return Err(ProcessError::InvalidParameter(format!(
+                "expected loss fraction must be in [0, 1), got {}", expected_loss_fraction
+            )));
+        }
+
+        // Sizes of the full blocks and of the last one
+        let block_size = match self.get_recommended_block_size(file_size as usize) as u64 {
+            0 => file_size,
+            size => size.min(file_size),
+        };
+        let mut block_sizes = vec![block_size];
+        if file_size % block_size != 0 && file_size > block_size {
+            block_sizes.push(file_size % block_size);
+        }
+
+        let symbol_size = self.config.symbol_size;
+        let p = expected_loss_fraction;
+        let recovers = |block_size: u64, redundancy_factor: u8| {
+            let config = ObjectTransmissionInformation::with_defaults(block_size, symbol_size);
+            let source_symbols = source_symbols_count(&config);
+            let n = (source_symbols + repair_symbols_count(block_size, symbol_size, redundancy_factor)) as f64;
+            let required = ((source_symbols + DECODE_SYMBOL_OVERHEAD) as f64).min(n);
+            n * (1.0 - p) - REDUNDANCY_Z_SCORE * (n * p * (1.0 - p)).sqrt() >= required
+        };
+
+        let redundancy_factor = (1..=u8::MAX)
+            .find(|&factor| block_sizes.iter().all(|&size| recovers(size, factor)))
+            .unwrap_or(u8::MAX);
+        Ok(redundancy_factor)
+    }
+
+    /// Create metadata for a file without generating symbols
+    ///
+    /// This method calculates symbol IDs and creates a layout file without
+    /// actually writing the symbol files to disk.
+    ///
+    /// # Arguments
+    ///
+    /// * `input_path` - Path to the input file
+    /// * `output_path` - Path of the output layout file will be written
+    /// * `block_size` - Size of blocks to process at once (0 = auto)
+    /// * `return_layout` - Whether to return the layout as an object instead of writing to file
+    ///
+    /// # Returns
+    ///
+    /// * `Ok(ProcessResult)` with the layout information
+    /// * `Err(ProcessError)` on failure
+    /// Create metadata for a file without generating symbols
+    ///
+    /// This method calculates symbol IDs and creates a layout file if `layout_file` is non-empty,
+    /// or returns the layout as an object if `layout_file` is an empty string.
+    ///
+    /// # Arguments
+    /// * `input_path` - Path to the input file
+    /// * `layout_file` - Path to the output layout file (if empty, returns layout as object)
+    /// * `block_size` - Size of blocks to process at once (0 = auto)
+    ///
+    /// # Returns
+    /// * `Ok(ProcessResult)` with the layout information
+    /// * `Err(ProcessError)` on failure
+    pub fn create_metadata(
+        &self,
+        input_path: &str,
+        layout_file: &str,
+        block_size: usize,
+    ) -> Result<ProcessResult, ProcessError> {
+        let cancellation = self.start_cancellation();
+
+        // Check if we can take another task
+        let _guard = self.start_task()?;
+
+        // Prepare for processing
+        let (file_reader, file_size, actual_block_size) = self.prepare_processing(
+            input_path,
+            block_size,
+            false, // We don't force single file for metadata creation
+            self.config.symbol_size,
+        )?;
+
+        debug!(
+            "Creating metadata for file: {:?} ({}B) with block size {}B",
+            input_path, file_size, actual_block_size
+        );
+
+        // If layout_file is empty, return layout as object; else, write to file
+        let return_layout = layout_file.is_empty();
+        self.process_file_blocks(
+            file_reader,
+            "", // output_dir is not used for metadata-only
+            actual_block_size,
+            self.config.symbol_size,
+            file_size,
+            true, // metadata_only = true
+            return_layout,
+            layout_file,
+            None,
+            false,
+            None,
+            cancellation,
+        )
+    }
+
+    /// Plan how a file would be encoded, without reading its data nor generating symbols
+    ///
+    /// The file is split into blocks as `encode_file` would with the same block size,
+    /// and each block gets the source and repair symbol counts of the configuration.
+    /// Only the size of the file is needed, so this is much cheaper than
+    /// `create_metadata`, which encodes every block to compute the symbol ids.
+    ///
+    /// # Arguments
+    /// * `input_path` - Path to the input file
+    /// * `block_size` - Size of blocks the file would be encoded with (0 = auto)
+    ///
+    /// # Returns
+    /// * `Ok(LayoutSummary)` with the blocks and symbol counts, as `RaptorQLayout::summary`
+    ///   would give for the layout written by `encode_file`
+    /// * `Err(ProcessError)` on failure (e.g., file not found, empty file)
+    pub fn plan_encode(&self, input_path: &str, block_size: usize) -> Result<LayoutSummary, ProcessError> {
+        let (_, file_size, block_size) = self.prepare_processing(input_path, block_size, false, self.config.symbol_size)?;
+
+        let mut blocks = Vec::new();
+        let mut offset = 0;
+        while offset < file_size {
+            let size = block_size.min(file_size - offset) as u64;
+            let config = self.block_encoder_config(size, self.config.symbol_size);
+            let source_symbols_count = source_symbols_count(&config);
+            let repair_symbols_count = self.calculate_repair_symbols(size, self.config.symbol_size);
+            blocks.push(BlockSummary {
+                block_id: blocks.len(),
+                original_offset: offset as u64,
+                size,
+                symbol_size: config.symbol_size(),
+                symbols_count: source_symbols_count + repair_symbols_count,
+                source_symbols_count,
+                repair_symbols_count,
+            });
+            offset += block_size;
+        }
+
+        debug!("Planned {} blocks for file: {:?} ({}B)", blocks.len(), input_path, file_size);
+
+        Ok(LayoutSummary {
+            layout_version: LAYOUT_VERSION,
+            total_size: file_size as u64,
+            symbols_count: blocks.iter().map(|b| b.symbols_count).sum(),
+            metadata: self.layout_metadata.lock().clone(),
+            blocks,
+        })
+    }
+
+    /// Describe how a file would be encoded, as lines of text for logs and support
+    ///
+    /// The plan of `plan_encode` with the parameters the encoder would use: the settings
+    /// of the processor, then the RaptorQ parameters of each block, its source and
+    /// repair symbols and the padding of its last source symbol. Meant to be read, the
+    /// format may change; applications use `plan_encode` instead.
+    ///
+    /// # Arguments
+    /// * `input_path` - Path to the input file
+    /// * `block_size` - Size of blocks the file would be encoded with (0 = auto)
+    ///
+    /// # Returns
+    /// * `Ok(String)` with the description, one line per setting and per block
+    /// * `Err(ProcessError)` on failure (e.g., file not found, empty file)
+    pub fn describe_encode(&self, input_path: &str, block_size: usize) -> Result<String, ProcessError> {
+        let plan = self.plan_encode(input_path, block_size)?;
+        let format = self.symbol_format();
+        let symbol_size = self.config.symbol_size;
+
+        let repair_symbols = match *self.repair_symbols_per_block.lock() {
+            Some(repair_symbols) => format!("{} per block", repair_symbols),
+            None => format!("redundancy factor {}", self.config.redundancy_factor),
+        };
+        let encryption = match &format.cipher {
+            Some(cipher) => format!("encrypted with the key {}", cipher.key_id()),
+            None => "not encrypted".to_string(),
+        };
+        let source_symbols: u64 = plan.blocks.iter().map(|b| b.source_symbols_count).sum();
+        let mut lines = vec![
+            format!("Encode of {:?}", input_path),
+            format!("  object size: {} bytes", plan.total_size),
+            format!(
+                "  blocks: {} of up to {} bytes",
+                plan.blocks.len(),
+                plan.blocks.iter().map(|b| b.size).max().unwrap_or(0)
+            ),
+            format!(
+                "  symbol size: {} bytes{}",
+                symbol_size - symbol_size % SYMBOL_ALIGNMENT,
+                if self.auto_symbol_size.load(Ordering::SeqCst) { " at most, fitted to each block" } else { "" }
+            ),
+            format!("  repair symbols: {}", repair_symbols),
+            format!(
+                "  symbols: {} ({} source, {} repair)",
+                plan.symbols_count,
+                source_symbols,
+                plan.symbols_count - source_symbols
+            ),
+            format!(
+                "  symbol files: codec {:?}, {}, {}, {} layout",
+                format.codec,
+                encryption,
+                if format.crc { "CRC32 headers" } else { "no CRC32 headers" },
+                if self.flat_symbol_layout.load(Ordering::SeqCst) { "flat" } else { "block directory" }
+            ),
+            format!("  content hash: {:?}", *self.content_hash.lock()),
+        ];
+        for block in &plan.blocks {
+            let config = self.block_encoder_config(block.size, symbol_size);
+            lines.push(format!(
+                "  block {}: offset {}, {} bytes, K={} source + {} repair symbols of {} bytes, \
+                 padding {} bytes, {} source block(s), {} sub-block(s), alignment {}",
+                block.block_id,
+                block.original_offset,
+                block.size,
+                block.source_symbols_count,
+                block.repair_symbols_count,
+                block.symbol_size,
+                block.source_symbols_count * block.symbol_size as u64 - block.size,
+                config.source_blocks(),
+                config.sub_blocks(),
+                config.symbol_alignment(),
+            ));
+        }
+        Ok(lines.join("\n"))
+    }
+
+    /// Encode a file using RaptorQ
+    ///
+    /// The output only depends on the data, the block size and the configuration of
+    /// the processor, including its symbol codec and key: encoding the same file again
+    /// writes the same symbol files with the same names and the same layout, whatever
+    /// the concurrency limit, so symbols can be stored by content address.
+    ///
+    /// An empty file gives a layout without blocks nor symbols, which decodes to an
+    /// empty file. A file smaller than a symbol gives a block of one source symbol,
+    /// padded with zeros the decode drops.
+    pub fn encode_file(
+        &self,
+        input_path: &str,
+        output_dir: &str,
+        block_size: usize,
+        force_single_file: bool,
+    ) -> Result<ProcessResult, ProcessError> {
+        self.observe_operation(
+            "encode_file",
+            input_path,
+            || self.encode_file_to_dir(input_path, output_dir, block_size, self.config.symbol_size, force_single_file, false, None),
+            |r| {
+                let blocks = r.blocks.as_deref().unwrap_or_default();
+                OperationStats {
+                    object_size: blocks.iter().map(|b| b.size).sum(),
+                    blocks: blocks.len(),
+                    symbols: r.total_symbols_count,
+                }
+            },
+        )
+    }
+
+    /// Encode a file using RaptorQ with symbols of the given size instead of the
+    /// configured one
+    ///
+    /// Objects are best encoded with different symbol sizes, small symbols for small
+    /// objects and large ones for large objects, which one processor does with this
+    /// override. The symbol size of each block is recorded in its encoder parameters,
+    /// so the layout is decoded by any processor whatever its own symbol size. The
+    /// other settings of the processor (redundancy, codec, key...) apply as usual.
+    ///
+    /// # Arguments
+    /// * `input_path` - Path to the input file
+    /// * `output_dir` - Directory where symbols will be written
+    /// * `symbol_size` - Size of the symbols in bytes, at least `MIN_SYMBOL_SIZE_B`
+    /// * `block_size` - Size of blocks to process at once (0 = auto)
+    ///
+    /// # Returns
+    /// * `Ok(ProcessResult)` on success
+    /// * `Err(ProcessError::InvalidParameter)` if the symbol size is too small, or the
+    ///   block too large for it, see `validate_block_size`
+    pub fn encode_file_with_symbol_size(
+        &self,
+        input_path: &str,
+        output_dir: &str,
+        symbol_size: u16,
+        block_size: usize,
+    ) -> Result<ProcessResult, ProcessError> {
+        if symbol_size < MIN_SYMBOL_SIZE_B {
+            let err = ProcessError::InvalidParameter(format!(
+                "symbol size must be at least {} bytes, got {}", MIN_SYMBOL_SIZE_B, symbol_size
+            ));
+            self.set_last_error(err.to_string());
+            return Err(err);
+        }
+        self.observe_operation(
+            "encode_file_with_symbol_size",
+            input_path,
+            || self.encode_file_to_dir(input_path, output_dir, block_size, symbol_size, false, false, None),
+            |r| {
+                let blocks = r.blocks.as_deref().unwrap_or_default();
+                OperationStats {
+                    object_size: blocks.iter().map(|b| b.size).sum(),
+                    blocks: blocks.len(),
+                    symbols: r.total_symbols_count,
+                }
+            },
+        )
+    }
+
+    /// Encode a file using RaptorQ, writing only the symbols selected by `keep`
+    ///
+    /// `keep` is called for every symbol with its block id, its encoding symbol ID (ESI)
+    /// and whether it is a repair symbol, and the symbols it rejects are not written,
+    /// so a node can store its share of the symbols of an object without writing the
+    /// others first. The layout still lists all symbols of each block, so the layout of
+    /// any node decodes the symbols gathered from several of them.
+    ///
+    /// # Arguments
+    /// * `input_path` - Path to the input file
+    /// * `output_dir` - Directory where the kept symbols will be written
+    /// * `block_size` - Size of blocks to process at once (0 = auto)
+    /// * `keep` - Whether to write a symbol, from its block id, ESI and whether it is a
+    ///   repair symbol
+    ///
+    /// # Returns
+    /// * `Ok(ProcessResult)` with the symbol counts of the kept symbols only
+    /// * `Err(ProcessError)` on failure
+    pub fn encode_file_with_filter<F>(
+        &self,
+        input_path: &str,
+        output_dir: &str,
+        block_size: usize,
+        keep: F,
+    ) -> Result<ProcessResult, ProcessError>
+    where
+        F: Fn(usize, u32, bool) -> bool,
+    {
+        self.observe_operation(
+            "encode_file_with_filter",
+            input_path,
+            || self.encode_file_to_dir(input_path, output_dir, block_size, self.config.symbol_size, false, false, Some(&keep)),
+            |r| {
+                let blocks = r.blocks.as_deref().unwrap_or_default();
+                OperationStats {
+                    object_size: blocks.iter().map(|b| b.size).sum(),
+                    blocks: blocks.len(),
+                    symbols: r.total_symbols_count,
+                }
+            },
+        )
+    }
+
+    /// Resume an `encode_file` of the same file that was interrupted, encoding only
+    /// the blocks whose symbols are not all written yet
+    ///
+    /// `encode_file` marks each block once all of its symbols are written, until it
+    /// writes the layout file. A marked block is kept if its symbol files are all
+    /// present and the data of the file at its range still has its hash, so the input
+    /// is read again but only the other blocks are encoded. With the same block size,
+    /// the result and the layout are the ones `encode_file` would give.
+    ///
+    /// An encode that fails removes its symbols and markers unless
+    /// `set_cleanup_on_error(false)` was called, so there is usually something to
+    /// resume only after the process itself was stopped.
+    ///
+    /// # Arguments
+    /// * `input_path` - Path to the input file
+    /// * `output_dir` - Directory of the interrupted encode
+    /// * `block_size` - Block size of the interrupted encode (0 = auto)
+    ///
+    /// # Returns
+    /// * `Ok(ProcessResult)` with the layout information
+    /// * `Err(ProcessError)` on failure
+    pub fn resume_encode(
+        &self,
+        input_path: &str,
+        output_dir: &str,
+        block_size: usize,
+    ) -> Result<ProcessResult, ProcessError> {
+        self.observe_operation(
+            "resume_encode",
+            input_path,
+            || self.encode_file_to_dir(input_path, output_dir, block_size, self.config.symbol_size, false, true, None),
+            |r| {
+                let blocks = r.blocks.as_deref().unwrap_or_default();
+                OperationStats {
+                    object_size: blocks.iter().map(|b| b.size).sum(),
+                    blocks: blocks.len(),
+                    symbols: r.total_symbols_count,
+                }
+            },
+        )
+    }
+
+    /// Encode the data appended to an encoded object as new blocks, keeping its blocks
+    ///
+    /// For objects that only grow, such as logs: the input is the object as it is now,
+    /// its first bytes are the object of the layout and the bytes after them are split
+    /// into new blocks, numbered after the existing ones. The existing blocks are read
+    /// to check that they still hold the same data and to hash the object, but are not
+    /// encoded again and their symbols are left where they are.
+    ///
+    /// The new blocks are encoded with the symbol size and symbol format (codec, key,
+    /// CRC headers) of the layout, and written to `output_dir` with the updated layout,
+    /// which lists all blocks and keeps the metadata of the layout. `output_dir` is usually the symbols directory of the
+    /// layout, which then holds the whole object: decodes check that the layout of a
+    /// symbols directory is the one they decode, so a directory keeping the previous
+    /// layout no longer decodes the object. Appending nothing rewrites the layout unchanged.
+    ///
+    /// # Arguments
+    /// * `layout_path` - Path to the layout file of the object encoded so far
+    /// * `input_path` - Path to the object with the appended data
+    /// * `output_dir` - Directory where the new symbols and the layout will be written
+    /// * `block_size` - Size of the new blocks (0 = recommended block size for the appended data)
+    ///
+    /// # Returns
+    /// * `Ok(ProcessResult)` with the layout information of the whole object
+    /// * `Err(ProcessError::InvalidParameter)` if the input is shorter than the object
+    ///   of the layout or its data differs from the blocks of the layout
+    /// * `Err(ProcessError)` on other failures
+    pub fn append_encode(
+        &self,
+        layout_path: &str,
+        input_path: &str,
+        output_dir: &str,
+        block_size: usize,
+    ) -> Result<ProcessResult, ProcessError> {
+        self.observe_operation(
+            "append_encode",
+            input_path,
+            || self.append_file_to_dir(layout_path, input_path, output_dir, block_size),
+            |r| {
+                let blocks = r.blocks.as_deref().unwrap_or_default();
+                OperationStats {
+                    object_size: blocks.iter().map(|b| b.size).sum(),
+                    blocks: blocks.len(),
+                    symbols: r.total_symbols_count,
+                }
+            },
+        )
+    }
+
+    fn append_file_to_dir(
+        &self,
+        layout_path: &str,
+        input_path: &str,
+        output_dir: &str,
+        block_size: usize,
+    ) -> Result<ProcessResult, ProcessError> {
+        let cancellation = self.start_cancellation();
+
+        // Check if we can take another task
+        let _guard = self.start_task()?;
+
+        if let Err(e) = check_path(input_path).and_then(|_| check_path(output_dir)) {
+            self.set_last_error(e.to_string());
+            return Err(e);
+        }
+
+        let layout = self.read_layout_file(layout_path)?;
+        let symbol_format = self.layout_symbol_format(&layout)?;
+        let symbol_size = layout.blocks.first().map_or(self.config.symbol_size, |b| b.symbol_size());
+        let object_size = layout.total_size();
+
+        let (mut source_reader, file_size) = match self.open_and_validate_file(input_path) {
+            Ok(result) => result,
+            Err(e) => {
+                self.set_last_error(e.to_string());
+                return Err(e);
+            }
+        };
+        if (file_size as u64) < object_size {
+            let err = format!(
+                "File {:?} of {}B is shorter than the object of {}B of the layout, data can only be appended",
+                input_path, file_size, object_size
+            );
+            self.set_last_error(err.clone());
+            return Err(ProcessError::InvalidParameter(err));
+        }
+        let appended_size = file_size - object_size as usize;
+        let block_size = if appended_size == 0 {
+            0
+        } else {
+            self.resolve_block_size(input_path, appended_size, block_size, false, symbol_size)?
+        };
+
+        debug!(
+            "Appending {}B of file {:?} to an object of {} blocks with block size {}B",
+            appended_size, input_path, layout.blocks.len(), block_size
+        );
+
+        let layout_file = Path::new(output_dir).join(LAYOUT_FILENAME).to_string_lossy().to_string();
+        let first_new_block = layout.blocks.len();
+        let mut blocks_started = first_new_block;
+        let mut block_data = self.take_block_buffer();
+        let result = (|| {
+            let new_blocks = if block_size == 0 { 0 } else { appended_size.div_ceil(block_size) };
+            let mut encoded = EncodedBlocks::with_capacity(
+                first_new_block + new_blocks,
+                symbol_format,
+                *self.content_hash.lock(),
+                symbol_size,
+            );
+            // The metadata of the object is kept, whatever the one of the processor
+            encoded.metadata = Some(layout.metadata);
+
+            // The existing blocks are only read, for the hash of the object
+            for block_layout in layout.blocks {
+                self.check_cancelled(cancellation)?;
+                block_data.resize(block_layout.size as usize, 0);
+                read_block_data(&mut *source_reader, block_layout.original_offset, &mut block_data)?;
+                if !block_layout.hash.is_empty() && block_layout.hash != get_hash_as_b58(&block_data) {
+                    let err = format!(
+                        "File {:?} differs from block {} of the layout, data can only be appended",
+                        input_path, block_layout.block_id
+                    );
+                    self.set_last_error(err.clone());
+                    return Err(ProcessError::InvalidParameter(err));
+                }
+                encoded.object_hasher.update(&block_data);
+
+                let symbols_count = block_layout.symbols.len() as u64;
+                let block_info = BlockInfo {
+                    block_id: block_layout.block_id,
+                    encoder_parameters: block_layout.encoder_parameters.clone(),
+                    original_offset: block_layout.original_offset,
+                    size: block_layout.size,
+                    symbols_count,
+                    source_symbols_count: block_layout.source_symbols_count().min(symbols_count),
+                    hash: block_layout.hash.clone(),
+                };
+                encoded.push(block_info, block_layout);
+            }
+
+            let mut offset = object_size as usize;
+            while offset < file_size {
+                self.check_cancelled(cancellation)?;
+                blocks_started += 1;
+
+                block_data.resize(block_size.min(file_size - offset), 0);
+                read_block_data(&mut *source_reader, offset as u64, &mut block_data)?;
+
+                self.process_block(&mut encoded, &block_data, offset as u64, output_dir, false, None, None)?;
+                let block_layout = encoded.block_layouts.last().expect("The block was just encoded");
+                self.write_block_marker(output_dir, block_layout)?;
+                offset += block_size;
+            }
+
+            self.finish_layout(encoded, output_dir, false, &layout_file)
+        })();
+        self.return_block_buffer(block_data);
+
+        // Only the new blocks are removed, the existing ones are untouched
+        if result.is_err() {
+            self.cleanup_failed_encode(output_dir, first_new_block..blocks_started);
+        }
+        if result.is_ok() || self.cleanup_on_error.load(Ordering::SeqCst) {
+            self.remove_block_markers(output_dir, first_new_block..blocks_started);
+        }
+        result
+    }
+
+    fn encode_file_to_dir(
+        &self,
+        input_path: &str,
+        output_dir: &str,
+        block_size: usize,
+        symbol_size: u16,
+        force_single_file: bool,
+        resume: bool,
+        keep: Option<&SymbolFilter>,
+    ) -> Result<ProcessResult, ProcessError> {
+        let cancellation = self.start_cancellation();
+
+        // Check if we can take another task
+        let _guard = self.start_task()?;
+
+        if let Err(e) = check_path(input_path).and_then(|_| check_path(output_dir)) {
+            self.set_last_error(e.to_string());
+            return Err(e);
+        }
+
+        // Generate default layout file path
+        let layout_file = std::path::Path::new(output_dir).join(LAYOUT_FILENAME).to_string_lossy().to_string();
+
+        // An empty file has no blocks to encode, the other errors are reported below
+        if file_io::open_file_reader(input_path).and_then(|reader| reader.file_size()) == Ok(0) {
+            debug!("Encoding the empty file {:?} without blocks", input_path);
+            return self.encode_empty_file(output_dir, &layout_file);
+        }
+
+        // Prepare for processing
+        let (file_reader, file_size, actual_block_size) = self.prepare_processing(
+            input_path,
+            block_size,
+            force_single_file,
+            symbol_size,
+        )?;
+
+        debug!(
+            "Processing file: {:?} ({}B) with block size {}B",
+            input_path, file_size, actual_block_size
+        );
+
+        // Process file blocks - create actual symbols
+        self.process_file_blocks(
+            file_reader,
+            output_dir,
+            actual_block_size,
+            symbol_size,
+            file_size,
+            false, // metadata_only = false
+            false, // return_layout = false
+            &layout_file,
+            None,
+            resume,
+            keep,
+            cancellation,
+        )
+    }
+
+    // Write the layout of an empty file, which has no blocks and the hash of no data, see
+    // `RaptorQLayout::is_empty_object`
+    fn encode_empty_file(&self, output_dir: &str, layout_file: &str) -> Result<ProcessResult, ProcessError> {
+        file_io::get_dir_manager().create_dir_all(output_dir).map_err(|e| {
+            ProcessError::IOError(io::Error::new(io::ErrorKind::Other, e))
+        })?;
+        self.finish_layout(EncodedBlocks::with_capacity(0, self.symbol_format(), *self.content_hash.lock(), self.config.symbol_size), output_dir, false, layout_file)
+    }
+
+    /// Encode a file using RaptorQ into a single archive holding all symbols and the layout
+    ///
+    /// The archive is a plain tar archive, see docs/SYMBOL_ARCHIVE_FORMAT.md: the symbols
+    /// of each block as `block_<id>/<symbol_id>` entries, block after block, then the
+    /// layout as a `_raptorq_layout.json` entry. Only one block is held in memory at a time.
+    ///
+    /// # Arguments
+    /// * `input_path` - Path to the input file
+    /// * `archive_path` - Path of the archive to write
+    /// * `block_size` - Size of blocks to process at once (0 = auto)
+    ///
+    /// # Returns
+    /// * `Ok(ProcessResult)` with the layout in `layout_content` and the archive
+    ///   path in `symbols_directory`
+    /// * `Err(ProcessError)` on failure, the archive may be incomplete
+    pub fn encode_file_to_archive(
+        &self,
+        input_path: &str,
+        archive_path: &str,
+        block_size: usize,
+    ) -> Result<ProcessResult, ProcessError> {
+        let cancellation = self.start_cancellation();
+
+        // Check if we can take another task
+        let _guard = self.start_task()?;
+
+        let (mut file_reader, file_size, actual_block_size) = self.prepare_processing(
+            input_path,
+            block_size,
+            false,
+            self.config.symbol_size,
+        )?;
+
+        debug!(
+            "Encoding file {:?} ({}B) to the archive {:?} with block size {}B",
+            input_path, file_size, archive_path, actual_block_size
+        );
+
+        let archive_writer = file_io::open_file_writer(archive_path)
+            .map_err(|e| ProcessError::IOError(io::Error::new(io::ErrorKind::Other, e)))?;
+        let mut archive = tar::Builder::new(io::BufWriter::new(file_io::SequentialWriter::new(archive_writer)));
+
+        let block_count = file_size.div_ceil(actual_block_size);
+        let mut encoded = EncodedBlocks::with_capacity(block_count, SymbolFormat::default(), *self.content_hash.lock(), self.config.symbol_size);
+        let mut offset = 0usize;
+        while offset < file_size {
+            self.check_cancelled(cancellation)?;
+
+            let mut block_data = vec![0u8; std::cmp::min(actual_block_size, file_size - offset)];
+            read_block_data(&mut *file_reader, offset as u64, &mut block_data)?;
+
+            let mut symbols = Vec::new();
+            let mut collect = |symbol: EncodedSymbol| {
+                symbols.push(symbol.data);
+                Ok(())
+            };
+            self.process_block(&mut encoded, &block_data, offset as u64, "", false, Some(&mut collect), None)?;
+
+            let block_layout = encoded.block_layouts.last().expect("Block was just encoded");
+            for (symbol_id, symbol) in block_layout.symbols.iter().zip(&symbols) {
+                self.append_archive_entry(&mut archive, &symbol_path(block_layout.block_id, symbol_id), symbol)?;
+            }
+            offset += block_data.len();
+        }
+
+        let mut result = self.finish_layout(encoded, "", true, "")?;
+        let layout_json = result.layout_content.as_deref().unwrap_or_default();
+        self.append_archive_entry(&mut archive, LAYOUT_FILENAME, layout_json.as_bytes())?;
+        archive.into_inner()
+            .and_then(|mut writer| io::Write::flush(&mut writer))
+            .map_err(|e| {
+                let err = format!("Failed to write the archive {:?}: {}", archive_path, e);
+                self.set_last_error(err.clone());
+                ProcessError::IOError(io::Error::new(e.kind(), err))
+            })?;
+
+        result.symbols_directory = archive_path.to_string();
+        Ok(result)
+    }
+
+    fn append_archive_entry<W: io::Write>(&self, archive: &mut tar::Builder<W>, name: &str, data: &[u8]) -> Result<(), ProcessError> {
+        let mut header = tar::Header::new_ustar();
+        header.set_size(data.len() as u64);
+        header.set_mode(0o644);
+        header.set_entry_type(tar::EntryType::Regular);
+        archive.append_data(&mut header, name, data).map_err(|e| {
+            let err = format!("Failed to write the archive entry {}: {}", name, e);
+            self.set_last_error(err.clone());
+            ProcessError::IOError(io::Error::new(e.kind(), err))
+        })
+    }
+
+    /// Encode again a single block of a file already encoded, writing only its symbols
+    ///
+    /// The block is read from the byte range given by the layout and encoded with
+    /// the encoder parameters and the number of symbols of the layout, so the symbols
+    /// are the same as the ones listed there. This regenerates the symbols of a damaged
+    /// block without encoding the whole file. The layout file is not modified.
+    ///
+    /// # Arguments
+    /// * `input_path` - Path to the original file
+    /// * `output_dir` - Directory where the `block_<block_id>` directory will be written
+    /// * `layout_path` - Path to the layout JSON file of the file
+    /// * `block_id` - Identifier of the block in the layout
+    ///
+    /// # Returns
+    /// * `Ok(ProcessResult)` with the block
+    /// * `Err(ProcessError::EncodingFailed)` if the block is not in the layout or the
+    ///   data of the file doesn't match it
+    /// * `Err(ProcessError)` on other failures
+    pub fn encode_block(
+        &self,
+        input_path: &str,
+        output_dir: &str,
+        layout_path: &str,
+        block_id: usize,
+    ) -> Result<ProcessResult, ProcessError> {
+        // Check if we can take another task
+        let _guard = self.start_task()?;
+
+        let mut layout = self.read_layout_file(layout_path)?;
+        let symbol_format = self.layout_symbol_format(&layout)?;
+        let (block_layout, config, repair_symbols) = self.find_encoded_block(&mut layout, block_id)?;
+        let block_layout = &*block_layout;
+
+        debug!(
+            "Encoding block {} of {} bytes at offset {} of the file {:?}",
+            block_id, block_layout.size, block_layout.original_offset, input_path
+        );
+
+        let block_data = self.read_encoded_block(input_path, block_layout)?;
+
+        let block_dir = self.block_output_dir(output_dir, block_id);
+        file_io::get_dir_manager().create_dir_all(&block_dir.to_string_lossy()).map_err(|e| {
+            ProcessError::IOError(io::Error::new(io::ErrorKind::Other, e))
+        })?;
+        let (params, symbol_ids, hash) =
+            self.encode_block_data(&block_data, config, repair_symbols, &block_dir, false, None, false, &symbol_format, &mut |_, _| true)?;
+        if symbol_ids != block_layout.symbols {
+            let err = format!("Symbols of block {} do not match the layout", block_id);
+            self.set_last_error(err.clone());
+            return Err(ProcessError::EncodingFailed(err));
+        }
+
+        Ok(ProcessResult {
+            total_symbols_count: symbol_ids.len() as u64,
+            total_repair_symbols: repair_symbols,
+            symbols_directory: output_dir.to_string(),
+            blocks: Some(vec![BlockInfo {
+                block_id,
+                encoder_parameters: params,
+                original_offset: block_layout.original_offset,
+                size: block_layout.size,
+                symbols_count: symbol_ids.len() as u64,
+                source_symbols_count: symbol_ids.len() as u64 - repair_symbols,
+                hash,
+            }]),
+            layout_file_path: layout_path.to_string(),
+            layout_content: None,
+            layout: None,
+            content_hash: String::new(),
+        })
+    }
+
+    /// Generate new repair symbols for a block of a file already encoded and add them to the layout
+    ///
+    /// RaptorQ can generate practically unlimited repair symbols. The new symbols continue
+    /// the encoding symbol IDs (ESI) after the symbols of the layout, so they never collide
+    /// with existing ones, and calling it again keeps minting fresh symbols. Their ids are
+    /// appended to the block in the layout file.
+    ///
+    /// # Arguments
+    /// * `input_path` - Path to the original file
+    /// * `output_dir` - Directory where the `block_<block_id>` directory will be written
+    /// * `layout_path` - Path to the layout JSON file of the file, updated with the new symbols
+    /// * `block_id` - Identifier of the block in the layout
+    /// * `count` - Number of repair symbols to generate
+    ///
+    /// # Returns
+    /// * `Ok(Vec<String>)` with the ids of the new symbols
+    /// * `Err(ProcessError::InvalidParameter)` if the ESIs would exceed the 24 bits of the payload ID
+    ///   or the block is split into several source blocks
+    /// * `Err(ProcessError::EncodingFailed)` if the block is not in the layout or the
+    ///   data of the file doesn't match it
+    /// * `Err(ProcessError)` on other failures
+    pub fn generate_repair_symbols(
+        &self,
+        input_path: &str,
+        output_dir: &str,
+        layout_path: &str,
+        block_id: usize,
+        count: u32,
+    ) -> Result<Vec<String>, ProcessError> {
+        // Check if we can take another task
+        let _guard = self.start_task()?;
+
+        let mut layout = self.read_layout_file(layout_path)?;
+        let symbol_format = self.layout_symbol_format(&layout)?;
+        let (block_layout, config, repair_symbols) = self.find_encoded_block(&mut layout, block_id)?;
+
+        // The ESIs of the symbols of the layout are only known for a single source block
+        if config.source_blocks() > 1 {
+            let err = format!(
+                "Block {} is split into {} source blocks, repair symbols can only be generated for blocks of a single source block",
+                block_id, config.source_blocks()
+            );
+            self.set_last_error(err.clone());
+            return Err(ProcessError::InvalidParameter(err));
+        }
+
+        let first_esi = block_layout.symbols.len() as u64;
+        if first_esi + count as u64 > MAX_ENCODING_SYMBOL_ID as u64 + 1 {
+            let err = format!(
+                "Block {} has {} symbols, {} more would exceed the largest encoding symbol ID {}",
+                block_id, first_esi, count, MAX_ENCODING_SYMBOL_ID
+            );
+            self.set_last_error(err.clone());
+            return Err(ProcessError::InvalidParameter(err));
+        }
+
+        debug!("Generating {} repair symbols for block {} from ESI {}", count, block_id, first_esi);
+
+        let block_data = self.read_encoded_block(input_path, block_layout)?;
+        let block_dir = self.block_output_dir(output_dir, block_id);
+        file_io::get_dir_manager().create_dir_all(&block_dir.to_string_lossy()).map_err(|e| {
+            ProcessError::IOError(io::Error::new(io::ErrorKind::Other, e))
+        })?;
+
+        let encoder = Encoder::new(&block_data, config);
+        let mut symbol_ids = Vec::with_capacity(count as usize);
+        for packet in encoder.get_block_encoders()[0].repair_packets(repair_symbols as u32, count) {
+            let symbol = packet.serialize();
+            let symbol_id = self.calculate_symbol_id(&symbol);
+            self.write_symbol_file(&symbol_format, &block_dir, &symbol_id, &symbol)?;
+            symbol_ids.push(symbol_id);
+        }
+
+        block_layout.symbols.extend(symbol_ids.iter().cloned());
+        self.write_layout_file(layout_path, &layout)?;
+
+        Ok(symbol_ids)
+    }
+
+    // Write the file of a symbol encoded again in the format of its layout
+    fn write_symbol_file(
+        &self,
+        symbol_format: &SymbolFormat,
+        block_dir: &Path,
+        symbol_id: &str,
+        symbol: &[u8],
+    ) -> Result<(), ProcessError> {
+        let content = symbol_format.encode(symbol).map_err(|e| {
+            let err = format!("Failed to write the symbol {}: {}", symbol_id, e);
+            self.set_last_error(err.clone());
+            ProcessError::EncodingFailed(err)
+        })?;
+        let path_str = block_dir.join(symbol_id).to_string_lossy().to_string();
+        let mut writer = file_io::open_file_writer(&path_str)
+            .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
+        writer.write_chunk(0, &content)
+            .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
+        writer.flush()
+            .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
+        Ok(())
+    }
+
+    // Find a block in a layout to encode it again, with its encoder parameters
+    // and its number of repair symbols
+    fn find_encoded_block<'a>(
+        &self,
+        layout: &'a mut RaptorQLayout,
+        block_id: usize,
+    ) -> Result<(&'a mut BlockLayout, ObjectTransmissionInformation, u64), ProcessError> {
+        let Some(block_layout) = layout.blocks.iter_mut().find(|b| b.block_id == block_id) else {
+            let err = format!("Block {} not found in the layout", block_id);
+            self.set_last_error(err.clone());
+            return Err(ProcessError::EncodingFailed(err));
+        };
+        let config = self.block_decoder_config(block_layout)?;
+        match (block_layout.symbols.len() as u64).checked_sub(source_symbols_count(&config)) {
+            Some(repair_symbols) => Ok((block_layout, config, repair_symbols)),
+            None => {
+                let err = format!("Block {} lists fewer symbols than its source symbols", block_id);
+                self.set_last_error(err.clone());
+                Err(ProcessError::EncodingFailed(err))
+            }
+        }
+    }
+
+    // Read the data of a block from the original file, checked against the block hash
+    fn read_encoded_block(&self, input_path: &str, block_layout: &BlockLayout) -> Result<Vec<u8>, ProcessError> {
+        let (mut file_reader, file_size) = match self.open_and_validate_file(input_path) {
+            Ok(result) => result,
+            Err(e) => {
+                self.set_last_error(e.to_string());
+                return Err(e);
+            }
+        };
+        let block_end = block_layout.original_offset + block_layout.size;
+        if block_end > file_size as u64 {
+            let err = format!(
+                "Block {} ends at {} beyond the end of the file {:?} ({}B)",
+                block_layout.block_id, block_end, input_path, file_size
+            );
+            self.set_last_error(err.clone());
+            return Err(ProcessError::EncodingFailed(err));
+        }
+
+        let mut block_data = vec![0u8; block_layout.size as usize];
+        read_block_data(&mut *file_reader, block_layout.original_offset, &mut block_data)?;
+        if !block_layout.hash.is_empty() && get_hash_as_b58(&block_data) != block_layout.hash {
+            let err = format!(
+                "Data of block {} in the file {:?} does not match the layout hash",
+                block_layout.block_id, input_path
+            );
+            self.set_last_error(err.clone());
+            return Err(ProcessError::EncodingFailed(err));
+        }
+        Ok(block_data)
+    }
+
+    /// Get a symbol of a block by its encoding symbol ID (ESI)
+    ///
+    /// The symbol is read from the symbols directory if it is stored there and matches
+    /// its id. Otherwise it is generated from the data of the block, read from the
+    /// original file if `input_path` is given, or else decoded from the stored symbols
+    /// of the block. Generated symbols are not written to the symbols directory.
+    ///
+    /// # Arguments
+    /// * `symbols_dir` - Path to the directory containing the symbol files
+    /// * `layout_path` - Path to the layout JSON file
+    /// * `block_id` - Identifier of the block in the layout
+    /// * `esi` - Encoding symbol ID of the symbol, source symbols come first
+    /// * `input_path` - Path to the original file, if it is available
+    ///
+    /// # Returns
+    /// * `Ok(Vec<u8>)` with the symbol, FEC payload ID included, as stored in symbol files
+    /// * `Err(ProcessError::SymbolNotFound)` if the symbol is not stored and the block
+    ///   has too few stored symbols to generate it
+    /// * `Err(ProcessError::InvalidParameter)` if the block is not in the layout, is split
+    ///   into several source blocks or the ESI exceeds the 24 bits of the payload ID
+    /// * `Err(ProcessError)` on other failures, e.g. an original file not matching the layout
+    pub fn get_symbol(
+        &self,
+        symbols_dir: &str,
+        layout_path: &str,
+        block_id: usize,
+        esi: u32,
+        input_path: Option<&str>,
+    ) -> Result<Vec<u8>, ProcessError> {
+        // Check if we can take another task
+        let _guard = self.start_task()?;
+
+        let layout = self.read_layout_file(layout_path)?;
+        let Some(block_layout) = layout.blocks.iter().find(|b| b.block_id == block_id) else {
+            let err = format!("Block {} not found in the layout", block_id);
+            self.set_last_error(err.clone());
+            return Err(ProcessError::InvalidParameter(err));
+        };
+        let symbol_format = self.layout_symbol_format(&layout)?;
+        if esi > MAX_ENCODING_SYMBOL_ID {
+            let err = format!("ESI {} exceeds the largest encoding symbol ID {}", esi, MAX_ENCODING_SYMBOL_ID);
+            self.set_last_error(err.clone());
+            return Err(ProcessError::InvalidParameter(err));
+        }
+        let config = self.block_decoder_config(block_layout)?;
+        // An ESI alone doesn't tell a symbol of a block split into several source blocks
+        if config.source_blocks() > 1 {
+            let err = format!(
+                "Block {} is split into {} source blocks, symbols can only be got by ESI for blocks of a single source block",
+                block_id, config.source_blocks()
+            );
+            self.set_last_error(err.clone());
+            return Err(ProcessError::InvalidParameter(err));
+        }
+
+        let dir_manager = file_io::get_dir_manager();
+        let block_path = self.block_symbols_path(dir_manager.as_ref(), Path::new(symbols_dir), block_id)?;
+
+        // Symbols are listed in the order of their ESIs when the layout was written by
+        // this library, so the symbol at the position of the ESI is tried first
+        let position = esi as usize;
+        let candidates = block_layout.symbols.get(position).into_iter()
+            .chain(block_layout.symbols.iter().enumerate().filter(|(i, _)| *i != position).map(|(_, id)| id));
+        for symbol_id in candidates {
+            let Some(symbol) = self.read_symbol_file(&block_path, symbol_id, &symbol_format) else { continue };
+            if symbol_esi(&symbol) == Some(esi) && self.calculate_symbol_id(&symbol) == *symbol_id {
+                return Ok(symbol);
+            }
+        }
+
+        let block_data = match input_path {
+            Some(input_path) => self.read_encoded_block(input_path, block_layout)?,
+            None => match self.decode_block(block_layout, |symbol_id| self.read_symbol_file(&block_path, symbol_id, &symbol_format))? {
+                BlockDecodeOutcome::Decoded(data) => data,
+                BlockDecodeOutcome::Skipped | BlockDecodeOutcome::Shortfall(_) => {
+                    let err = ProcessError::SymbolNotFound { block_id, esi };
+                    self.set_last_error(err.to_string());
+                    return Err(err);
+                }
+            },
+        };
+
+        debug!("Generating symbol {} of block {}", esi, block_id);
+        let encoder = Encoder::new(&block_data, config);
+        let block_encoder = &encoder.get_block_encoders()[0];
+        let source_symbols = source_symbols_count(&config) as u32;
+        let packet = if esi < source_symbols {
+            block_encoder.source_packets().into_iter().nth(esi as usize)
+        } else {
+            block_encoder.repair_packets(esi - source_symbols, 1).into_iter().next()
+        };
+        match packet.map(|p| p.serialize()) {
+            Some(symbol) if symbol_esi(&symbol) == Some(esi) => Ok(symbol),
+            _ => {
+                let err = format!("Failed to generate symbol {} of block {}", esi, block_id);
+                self.set_last_error(err.clone());
+                Err(ProcessError::EncodingFailed(err))
+            }
+        }
+    }
+
+    /// Encode several files, each one as `encode_file` does
+    ///
+    /// Files are encoded in parallel on the task slots of the concurrency limit that
+    /// are not taken by other operations. A file that fails doesn't stop the others,
+    /// but once the processor is cancelled the files not started yet fail as well.
+    ///
+    /// # Arguments
+    /// * `jobs` - Files to encode with their output directory and block size
+    ///
+    /// # Returns
+    /// * The result of each job, in the order of the jobs
+    pub fn encode_files(&self, jobs: &[BatchEncodeJob]) -> Vec<Result<ProcessResult, ProcessError>> {
+        self.run_batch("Encoding", jobs, |job| {
+            self.encode_file(&job.input_path, &job.output_dir, job.block_size, false)
+        })
+    }
+
+    /// Decode several objects, each one as `decode_symbols` does
+    ///
+    /// Objects are decoded in parallel on the task slots of the concurrency limit that
+    /// are not taken by other operations, so a single processor restores many objects
+    /// at once. An object that fails doesn't stop the others, but once the processor is
+    /// cancelled the objects not started yet fail as well.
+    ///
+    /// # Arguments
+    /// * `jobs` - Objects to decode with their symbols directory, output path and layout file
+    ///
+    /// # Returns
+    /// * The result of each job, in the order of the jobs
+    pub fn decode_files(&self, jobs: &[BatchDecodeJob]) -> Vec<Result<(), ProcessError>> {
+        self.run_batch("Decoding", jobs, |job| {
+            self.decode_symbols(&job.symbols_dir, &job.output_path, &job.layout_path)
+        })
+    }
+
+    // Run the jobs of a batch on the free task slots, returning their results in order
+    fn run_batch<J: Sync, T: Send>(
+        &self,
+        action: &str,
+        jobs: &[J],
+        run: impl Fn(&J) -> Result<T, ProcessError> + Sync,
+    ) -> Vec<Result<T, ProcessError>> {
+        let cancellation = self.start_cancellation();
+
+        let run_job = |job: &J| {
+            self.check_cancelled(cancellation)?;
+            run(job)
+        };
+
+        // Threads are not available in the browser
+        let workers = self.available_task_slots().min(jobs.len());
+        if workers <= 1 || cfg!(target_arch = "wasm32") {
+            return jobs.iter().map(run_job).collect();
+        }
+
+        debug!("{} {} files with {} workers", action, jobs.len(), workers);
+
+        let next_job = AtomicUsize::new(0);
+        let results = Mutex::new((0..jobs.len()).map(|_| None).collect::<Vec<_>>());
+        let owner = operation_thread();
+        std::thread::scope(|scope| {
+            for _ in 0..workers {
+                scope.spawn(|| work_for(&owner, || loop {
+                    let index = next_job.fetch_add(1, Ordering::SeqCst);
+                    let Some(job) = jobs.get(index) else { break };
+                    let result = run_job(job);
+                    results.lock()[index] = Some(result);
+                }));
+            }
+        });
+
+        results
+            .into_inner()
+            .into_iter()
+            .map(|result| result.expect("Every job is processed by a worker"))
+            .collect()
+    }
+
+    /// Encode data read from a stream using RaptorQ, block by block
+    ///
+    /// The total size doesn't need to be known up front, only one block
+    /// is held in memory at a time.
+    ///
+    /// # Arguments
+    /// * `reader` - Stream to read the data from, until its end
+    /// * `output_dir` - Directory where symbols will be written
+    /// * `block_size` - Size of blocks to process at once (0 = about a quarter of
+    ///   `max_memory_mb` divided by 1.5 for safety, as for a file too large for the memory)
+    ///
+    /// # Returns
+    /// * `Ok(ProcessResult)` with the layout information
+    /// * `Err(ProcessError)` on failure, including read errors of the stream
+    pub fn encode_stream<R: io::Read>(
+        &self,
+        mut reader: R,
+        output_dir: &str,
+        block_size: usize,
+    ) -> Result<ProcessResult, ProcessError> {
+        let cancellation = self.start_cancellation();
+
+        // Check if we can take another task
+        let _guard = self.start_task()?;
+
+        // The size is unknown, split the stream into blocks bounded by the memory limit
+        let actual_block_size = if block_size == 0 {
+            self.memory_block_size(self.config.symbol_size)
+        } else {
+            block_size
+        };
+
+        debug!("Processing a stream with block size {}B", actual_block_size);
+
+        let layout_file = Path::new(output_dir).join(LAYOUT_FILENAME).to_string_lossy().to_string();
+
+        let mut encoded = EncodedBlocks::with_capacity(1, self.symbol_format(), *self.content_hash.lock(), self.config.symbol_size);
+        let mut offset = 0u64;
+        let mut block_data = Vec::new();
+        loop {
+            self.check_cancelled(cancellation)?;
+
+            // Fill the block, the reader may return fewer bytes than requested
+            block_data.clear();
+            let read = (&mut reader)
+                .take(actual_block_size as u64)
+                .read_to_end(&mut block_data)
+                .map_err(|e| {
+                    let err = format!("Failed to read the input stream at offset {}: {}", offset, e);
+                    self.set_last_error(err.clone());
+                    ProcessError::IOError(io::Error::new(e.kind(), err))
+                })?;
+            if read == 0 {
+                break;
+            }
+
+            debug!("Processing block {} of {} bytes at offset {}", encoded.blocks.len(), read, offset);
+
+            self.process_block(&mut encoded, &block_data, offset, output_dir, false, None, None)?;
+            offset += read as u64;
+
+            // Short block, the stream is over
+            if read < actual_block_size {
+                break;
+            }
+        }
+
+        if encoded.blocks.is_empty() {
+            let err = ProcessError::EncodingFailed("Input stream is empty".to_string());
+            self.set_last_error(err.to_string());
+            return Err(err);
+        }
+
+        self.finish_layout(encoded, output_dir, false, &layout_file)
+    }
+
+    /// Encode data read at random offsets, encoding its blocks in parallel
+    ///
+    /// Each block is read from its own range of `reader`, so blocks are encoded on
+    /// extra threads taking the task slots of the concurrency limit that are free,
+    /// with one block in memory per thread, while the calling thread reads the data
+    /// once more to hash the whole object for the layout. Without a free slot, the
+    /// blocks are encoded one by one as `encode_file` does. Either way the symbol files
+    /// and the layout are the ones `encode_file` writes with the same block size.
+    ///
+    /// # Arguments
+    /// * `reader` - Source of the data, read from several threads at once
+    /// * `size` - Size of the data, the source must hold at least that many bytes
+    /// * `output_dir` - Directory where symbols will be written
+    /// * `block_size` - Size of blocks to process at once (0 = auto)
+    ///
+    /// # Returns
+    /// * `Ok(ProcessResult)` with the layout information
+    /// * `Err(ProcessError)` on failure, including read errors of the source;
+    ///   the symbols of the blocks encoded before are kept
+    pub fn encode_reader_at<R: ReadAt + Sync + ?Sized>(
+        &self,
+        reader: &R,
+        size: u64,
+        output_dir: &str,
+        block_size: usize,
+    ) -> Result<ProcessResult, ProcessError> {
+        let cancellation = self.start_cancellation();
+
+        // Check if we can take another task
+        let _guard = self.start_task()?;
+
+        if size == 0 {
+            let err = ProcessError::EncodingFailed("Input is empty".to_string());
+            self.set_last_error(err.to_string());
+            return Err(err);
+        }
+        let total_size = usize::try_from(size).map_err(|_| {
+            let err = ProcessError::InvalidParameter(format!("size {} is too large for this platform", size));
+            self.set_last_error(err.to_string());
+            err
+        })?;
+        let block_size = self.resolve_block_size("<reader>", total_size, block_size, false, self.config.symbol_size)?;
+        let block_count = total_size.div_ceil(block_size);
+
+        let layout_file = Path::new(output_dir).join(LAYOUT_FILENAME).to_string_lossy().to_string();
+
+        let read_range = |offset: u64, data: &mut [u8]| {
+            reader.read_exact_at(offset, data).map_err(|e| {
+                let err = format!("Failed to read the input at offset {}: {}", offset, e);
+                self.set_last_error(err.clone());
+                ProcessError::IOError(io::Error::new(e.kind(), err))
+            })
+        };
+        let read_block = |block_id: usize| {
+            let offset = block_id * block_size;
+            let mut block_data = vec![0u8; block_size.min(total_size - offset)];
+            read_range(offset as u64, &mut block_data)?;
+            Ok::<_, ProcessError>((offset as u64, block_data))
+        };
+
+        let worker_guards = self.start_workers(block_count);
+
+        let symbol_format = self.symbol_format();
+        let content_hash = *self.content_hash.lock();
+        if worker_guards.is_empty() {
+            let mut encoded = EncodedBlocks::with_capacity(block_count, symbol_format, content_hash, self.config.symbol_size);
+            for block_id in 0..block_count {
+                self.check_cancelled(cancellation)?;
+                let (offset, block_data) = read_block(block_id)?;
+                self.process_block(&mut encoded, &block_data, offset, output_dir, false, None, None)?;
+            }
+            return self.finish_layout(encoded, output_dir, false, &layout_file);
+        }
+
+        debug!("Encoding {} blocks with {} workers", block_count, worker_guards.len());
+
+        let next_block = AtomicUsize::new(0);
+        let failed = AtomicBool::new(false);
+        let results = Mutex::new((0..block_count).map(|_| None).collect::<Vec<_>>());
+        let owner = operation_thread();
+        let object_hasher = std::thread::scope(|scope| {
+            for _ in 0..worker_guards.len() {
+                scope.spawn(|| work_for(&owner, || loop {
+                    let block_id = next_block.fetch_add(1, Ordering::SeqCst);
+                    if block_id >= block_count || failed.load(Ordering::SeqCst) {
+                        break;
+                    }
+                    let result = self.check_cancelled(cancellation)
+                        .and_then(|_| read_block(block_id))
+                        .and_then(|(offset, block_data)| {
+                            self.encode_layout_block(block_id, &block_data, offset, output_dir, false, None, &symbol_format, self.config.symbol_size, None)
+                        });
+                    if result.is_err() {
+                        failed.store(true, Ordering::SeqCst);
+                    }
+                    results.lock()[block_id] = Some(result);
+                }));
+            }
+
+            // Hash the whole object while the blocks are encoded
+            let mut object_hasher = content_hash.hasher();
+            if content_hash.is_none() {
+                return Ok(object_hasher);
+            }
+            let mut chunk = vec![0u8; block_size.min(OBJECT_HASH_CHUNK_SIZE)];
+            let mut offset = 0;
+            while offset < total_size && !failed.load(Ordering::SeqCst) {
+                let len = chunk.len().min(total_size - offset);
+                if let Err(e) = read_range(offset as u64, &mut chunk[..len]) {
+                    failed.store(true, Ordering::SeqCst);
+                    return Err(e);
+                }
+                object_hasher.update(&chunk[..len]);
+                offset += len;
+            }
+            Ok(object_hasher)
+        });
+        drop(worker_guards);
+
+        // Blocks not started after a failure have no result
+        let mut encoded = EncodedBlocks::with_capacity(block_count, symbol_format, content_hash, self.config.symbol_size);
+        for result in results.into_inner().into_iter().flatten() {
+            let (block_info, block_layout) = result?;
+            encoded.push(block_info, block_layout);
+        }
+        encoded.object_hasher = object_hasher?;
+
+        self.finish_layout(encoded, output_dir, false, &layout_file)
+    }
+
+    /// Encode data held in memory using RaptorQ, without touching the filesystem
+    ///
+    /// # Arguments
+    /// * `data` - Data to encode
+    /// * `block_size` - Size of blocks to process at once (0 = auto)
+    ///
+    /// # Returns
+    /// * `Ok((ProcessResult, symbols))` - the result carries the layout in `layout_content`,
+    ///   symbols are the serialized packets in the order of the layout blocks and their symbol ids
+    /// * `Err(ProcessError)` on failure
+    pub fn encode_bytes(
+        &self,
+        data: &[u8],
+        block_size: usize,
+    ) -> Result<(ProcessResult, Vec<Vec<u8>>), ProcessError> {
+        let cancellation = self.start_cancellation();
+
+        // Check if we can take another task
+        let _guard = self.start_task()?;
+
+        if data.is_empty() {
+            let err = ProcessError::EncodingFailed("Data is empty".to_string());
+            self.set_last_error(err.to_string());
+            return Err(err);
+        }
+
+        let actual_block_size = self.resolve_block_size("<memory>", data.len(), block_size, false, self.config.symbol_size)?;
+
+        debug!(
+            "Processing {}B of data in memory with block size {}B",
+            data.len(), actual_block_size
+        );
+
+        let mut symbols = Vec::new();
+        let mut collect = |symbol: EncodedSymbol| {
+            symbols.push(symbol.data);
+            Ok(())
+        };
+        let result = self.process_file_blocks(
+            Box::new(file_io::MemoryFileReader::new(data)),
+            "", // nothing is written to disk
+            actual_block_size,
+            self.config.symbol_size,
+            data.len(),
+            false, // metadata_only = false
+            true, // return_layout = true
+            "",
+            Some(&mut collect),
+            false,
+            None,
+            cancellation,
+        )?;
+
+        Ok((result, symbols))
+    }
+
+    /// Encode a file using RaptorQ, sending the symbols through a channel instead of
+    /// writing them
+    ///
+    /// Blocks are read and encoded one after the other on the calling thread, which
+    /// takes a task slot like `encode_file` until the encode is done, so the receiver
+    /// runs on another thread. The symbols of a source block are sent as soon as they
+    /// are produced, its source symbols before its repair symbols are generated, in the
+    /// order of the symbol ids of the layout. A bounded channel applies backpressure:
+    /// the encode waits while the channel is full. The sender is dropped when the
+    /// encode returns, which closes the channel.
+    ///
+    /// Symbols are sent without compression or encryption, and can be decoded with
+    /// `decode_from_source` from their block id and data.
+    ///
+    /// # Arguments
+    /// * `input_path` - Path to the input file
+    /// * `block_size` - Size of blocks to process at once (0 = auto)
+    /// * `sender` - Channel the symbols are sent through
+    ///
+    /// # Returns
+    /// * `Ok(ProcessResult)` with the layout in `layout_content`, once every symbol was sent
+    /// * `Err(ProcessError::Cancelled)` if the receiver was dropped before the end
+    /// * `Err(ProcessError)` on other failures, the symbols sent so far are incomplete
+    pub fn encode_to_channel(
+        &self,
+        input_path: &str,
+        block_size: usize,
+        sender: mpsc::SyncSender<EncodedSymbol>,
+    ) -> Result<ProcessResult, ProcessError> {
+        let cancellation = self.start_cancellation();
+
+        // Check if we can take another task
+        let _guard = self.start_task()?;
+
+        let (file_reader, file_size, actual_block_size) = self.prepare_processing(
+            input_path,
+            block_size,
+            false,
+            self.config.symbol_size,
+        )?;
+
+        debug!(
+            "Encoding file {:?} ({}B) to a channel with block size {}B",
+            input_path, file_size, actual_block_size
+        );
+
+        let mut send = |symbol: EncodedSymbol| {
+            sender.send(symbol).map_err(|_| {
+                self.set_last_error("The symbol receiver was dropped".to_string());
+                ProcessError::Cancelled
+            })
+        };
+        self.process_file_blocks(
+            file_reader,
+            "", // nothing is written to disk
+            actual_block_size,
+            self.config.symbol_size,
+            file_size,
+            false, // metadata_only = false
+            true, // return_layout = true
+            "",
+            Some(&mut send),
+            false,
+            None,
+            cancellation,
+        )
+    }
+
+    /// Start encoding a file block by block
+    ///
+    /// Each block is then encoded by a call to `encode_next_block`, so the caller can
+    /// report progress or stop between blocks, and `finish_encode_file` writes the layout.
+    /// The result is the same as with `encode_file`.
+    ///
+    /// # Arguments
+    /// * `input_path` - Path to the input file
+    /// * `output_dir` - Directory where symbols will be written
+    /// * `block_size` - Size of blocks to process at once (0 = auto)
+    pub fn begin_encode_file(
+        &self,
+        input_path: &str,
+        output_dir: &str,
+        block_size: usize,
+    ) -> Result<FileEncodeJob, ProcessError> {
+        let cancellation = self.start_cancellation();
+
+        // The job only takes a task slot while it is prepared
+        let _guard = self.start_task()?;
+        let (file_reader, file_size, actual_block_size) = self.prepare_processing(
+            input_path,
+            block_size,
+            false,
+            self.config.symbol_size,
+        )?;
+
+        let blocks_total = if actual_block_size >= file_size {
+            1
+        } else {
+            file_size.div_ceil(actual_block_size)
+        };
+
+        debug!(
+            "Encoding file {:?} ({}B) in {} blocks of {}B",
+            input_path, file_size, blocks_total, actual_block_size
+        );
+
+        Ok(FileEncodeJob {
+            reader: file_reader,
+            output_dir: output_dir.to_string(),
+            layout_file: Path::new(output_dir).join(LAYOUT_FILENAME).to_string_lossy().to_string(),
+            block_size: actual_block_size,
+            total_size: file_size,
+            blocks_total,
+            bytes_processed: 0,
+            encoded: EncodedBlocks::with_capacity(blocks_total, self.symbol_format(), *self.content_hash.lock(), self.config.symbol_size),
+            cancellation,
+        })
+    }
+
+    /// Encode the next block of a file started with `begin_encode_file`
+    ///
+    /// Does nothing once all blocks are encoded.
+    ///
+    /// # Returns
+    /// * `Ok(EncodeProgress)` after the block was encoded
+    /// * `Err(ProcessError)` on failure
+    pub fn encode_next_block(&self, job: &mut FileEncodeJob) -> Result<EncodeProgress, ProcessError> {
+        if job.is_done() {
+            return Ok(job.progress());
+        }
+
+        self.check_cancelled(job.cancellation)?;
+
+        // Check if we can take another task
+        let _guard = self.start_task()?;
+
+        let offset = job.bytes_processed;
+        let block_size = std::cmp::min(job.block_size, job.total_size - offset as usize);
+
+        debug!(
+            "Processing block {} of {} bytes at offset {}",
+            job.encoded.blocks.len(), block_size, offset
+        );
+
+        let mut block_data = vec![0u8; block_size];
+        read_block_data(&mut *job.reader, offset, &mut block_data)?;
+
+        self.process_block(&mut job.encoded, &block_data, offset, &job.output_dir, false, None, None)?;
+        job.bytes_processed += block_size as u64;
+
+        Ok(job.progress())
+    }
+
+    /// Write the layout of a file encoded with `encode_next_block` and return the result
+    ///
+    /// Fails if some blocks were not encoded yet.
+    pub fn finish_encode_file(&self, job: FileEncodeJob) -> Result<ProcessResult, ProcessError> {
+        self.check_encode_done(&job)?;
+        self.finish_layout(job.encoded, &job.output_dir, false, &job.layout_file)
+    }
+
+    // Fail if some blocks of a job were not encoded yet, before it is given to finish_encode_file
+    pub(crate) fn check_encode_done(&self, job: &FileEncodeJob) -> Result<(), ProcessError> {
+        if !job.is_done() {
+            let err = format!(
+                "Encoding is not complete: {} of {} blocks encoded",
+                job.encoded.blocks.len(), job.blocks_total
+            );
+            self.set_last_error(err.clone());
+            return Err(ProcessError::EncodingFailed(err));
+        }
+        Ok(())
+    }
+
+    /// Prepare the file for processing
+    ///
+    /// This helper method handles common setup for encode_file and create_metadata
+    fn prepare_processing(
+        &self,
+        input_path: &str,
+        block_size: usize,
+        force_single_file: bool,
+        symbol_size: u16,
+    ) -> Result<(Box<dyn FileReader>, usize, usize), ProcessError> {
+
+        let (file_reader, file_size) = match self.open_and_validate_file(input_path) {
+            Ok(result) => result,
+            Err(e) => {
+                self.set_last_error(e.to_string());
+                return Err(e);
+            }
+        };
+
+        let actual_block_size = self.resolve_block_size(input_path, file_size, block_size, force_single_file, symbol_size)?;
+
+        Ok((file_reader, file_size, actual_block_size))
+    }
+
+    /// Determine the block size to split the data of the given size into, for symbols of the given size
+    fn resolve_block_size(
+        &self,
+        source: &str,
+        file_size: usize,
+        block_size: usize,
+        force_single_file: bool,
+        symbol_size: u16,
+    ) -> Result<usize, ProcessError> {
+        // A block size larger than the data gives a single block of the data, and
+        // recommended block sizes (0) are capped
+        let requested = if force_single_file { file_size } else { block_size.min(file_size) };
+        if let Err(err) = Self::check_block_size(requested as u64, symbol_size) {
+            self.set_last_error(err.to_string());
+            return Err(err);
+        }
+
+        if force_single_file {
+            let memory_required = self.estimate_memory_requirements(file_size);
+            if !self.is_memory_available(memory_required) {
+                let err = ProcessError::MemoryLimitExceeded {
+                    required: memory_required,
+                    available: self.config.max_memory_mb as usize,
+                };
+                self.set_last_error(err.to_string());
+                return Err(err);
+            }
+            debug!("Processing the file forced to skip splitting: {:?} ({}B)", source, file_size);
+            Ok(file_size)
+        } else if block_size == 0 && self.recommended_block_size(file_size, symbol_size) == 0 {
+            // Use file size as block size for single file mode
+            debug!("Processing the file without splitting: {:?} ({}B)", source, file_size);
+            Ok(file_size)
+        } else if block_size == 0 {
+            // Auto determine block size
+            let recommended = self.recommended_block_size(file_size, symbol_size);
+            debug!("Using the recommended block size: {}B", recommended);
+            Ok(recommended)
+        } else {
+            // Use provided block size
+            debug!("Using the provided block size: {}B", block_size);
+            Ok(block_size)
+        }
+    }
+
+    /// Process file blocks for encoding or metadata creation
+    ///
+    /// This method handles both creating actual symbols or just generating metadata
+    /// Process file blocks for encoding or metadata creation.
+    /// If `metadata_only` is true, only layout is created (no symbols written).
+    /// If `return_layout` is true, returns layout as object; else, writes to the specified file.
+    /// If `symbols_out` is set, symbols are collected there instead of being written to disk.
+    fn process_file_blocks(
+        &self,
+        mut source_reader: Box<dyn FileReader + '_>,
+        output_dir: &str,
+        block_size: usize,
+        symbol_size: u16,
+        total_size: usize,
+        metadata_only: bool,
+        return_layout: bool,
+        layout_file: &str,
+        mut symbols_out: Option<&mut SymbolSink>,
+        resume: bool,
+        keep: Option<&SymbolFilter>,
+        cancellation: Cancellation,
+    ) -> Result<ProcessResult, ProcessError> {
+        // Calculate the number of blocks
+        let block_count = if block_size >= total_size {
+            1
+        } else {
+            (total_size as f64 / block_size as f64).ceil() as usize
+        };
+
+        debug!("File will be split into {} blocks", block_count);
+
+        let writes_symbols = !metadata_only && symbols_out.is_none() && !output_dir.is_empty();
+        let mut blocks_started = 0;
+        // First block encoded by this call, the ones before were resumed and are kept on failure
+        let mut first_encoded = None;
+        // One buffer holds each block in turn
+        let mut block_data = self.take_block_buffer();
+        let result = (|| {
+            // Process each block, the symbols returned in memory are not compressed
+            let symbol_format = if symbols_out.is_none() { self.symbol_format() } else { SymbolFormat::default() };
+            let mut encoded = EncodedBlocks::with_capacity(block_count, symbol_format, *self.content_hash.lock(), symbol_size);
+
+            for block_index in 0..block_count {
+                self.check_cancelled(cancellation)?;
+                blocks_started = block_index + 1;
+
+                let actual_offset = (block_index * block_size) as u64;
+                let remaining = total_size - actual_offset as usize;
+                if remaining <= 0 {
+                    break;
+                }
+                let actual_block_size = std::cmp::min(block_size, remaining);
+
+                debug!(
+                    "Processing block {} of {} bytes at offset {}",
+                    block_index, actual_block_size, actual_offset
+                );
+
+                // Read this block into memory directly
+                block_data.resize(actual_block_size, 0);
+                read_block_data(&mut *source_reader, actual_offset, &mut block_data)?;
+
+                if resume {
+                    if let Some((block_info, block_layout)) =
+                        self.completed_block(output_dir, block_index, actual_offset, &block_data, symbol_size)
+                    {
+                        debug!("Block {} is already encoded", block_index);
+                        encoded.object_hasher.update(&block_data);
+                        encoded.push(block_info, block_layout);
+                        continue;
+                    }
+                }
+
+                first_encoded.get_or_insert(block_index);
+                self.process_block(
+                    &mut encoded,
+                    &block_data,
+                    actual_offset,
+                    output_dir,
+                    metadata_only,
+                    symbols_out.as_deref_mut(),
+                    keep,
+                )?;
+                if writes_symbols {
+                    let block_layout = encoded.block_layouts.last().expect("The block was just encoded");
+                    self.write_block_marker(output_dir, block_layout)?;
+                }
+                // No need to seek - we'll just read the next block at its offset
+            }
+
+            self.finish_layout(encoded, output_dir, return_layout, layout_file)
+        })();
+        self.return_block_buffer(block_data);
+
+        // The blocks completed by an earlier encode are kept, so it can be resumed again
+        let encoded_blocks = first_encoded.unwrap_or(blocks_started)..blocks_started;
+        if result.is_err() && writes_symbols {
+            self.cleanup_failed_encode(output_dir, encoded_blocks.clone());
+        }
+
+        // The markers are only needed until the layout is written
+        if writes_symbols {
+            if result.is_ok() {
+                self.remove_block_markers(output_dir, 0..blocks_started);
+            } else if self.cleanup_on_error.load(Ordering::SeqCst) {
+                self.remove_block_markers(output_dir, encoded_blocks);
+            }
+        }
+        result
+    }
+
+    // Path of the marker of a block whose symbols are all written
+    fn block_marker_path(output_dir: &str, block_id: usize) -> String {
+        Path::new(output_dir).join(format!("{}{}.json", BLOCK_MARKER_PREFIX, block_id)).to_string_lossy().to_string()
+    }
+
+    // Mark a block whose symbols are all written, so an interrupted encode can be resumed
+    fn write_block_marker(&self, output_dir: &str, block_layout: &BlockLayout) -> Result<(), ProcessError> {
+        let marker_json = serde_json::to_string(block_layout).map_err(|e| {
+            let err = format!("Failed to serialize the marker of block {}: {}", block_layout.block_id, e);
+            self.set_last_error(err.clone());
+            ProcessError::EncodingFailed(err)
+        })?;
+        let marker_path = Self::block_marker_path(output_dir, block_layout.block_id);
+        let mut writer = file_io::open_file_writer(&marker_path)
+            .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
+        writer
+            .write_chunk(0, marker_json.as_bytes())
+            .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
+        writer
+            .flush()
+            .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
+        Ok(())
+    }
+
+    // Layout of a block marked as complete, None if it has no readable marker
+    fn read_block_marker(&self, output_dir: &str, block_id: usize) -> Option<BlockLayout> {
+        let marker_path = Self::block_marker_path(output_dir, block_id);
+        let mut reader = file_io::open_file_reader(&marker_path).ok()?;
+        let mut content = vec![0; reader.file_size().ok()? as usize];
+        reader.read_chunk(0, &mut content).ok()?;
+        serde_json::from_slice(&content).ok()
+    }
+
+    fn remove_block_markers(&self, output_dir: &str, block_ids: Range<usize>) {
+        let dir_manager = file_io::get_dir_manager();
+        for block_id in block_ids {
+            let marker_path = Self::block_marker_path(output_dir, block_id);
+            if let Err(e) = dir_manager.remove_file(&marker_path) {
+                debug!("Failed to remove the block marker {}: {}", marker_path, e);
+            }
+        }
+    }
+
+    // The layout of a block written by an earlier encode, if it is marked as complete,
+    // all of its symbol files are present and it was encoded from the same data
+    fn completed_block(
+        &self,
+        output_dir: &str,
+        block_id: usize,
+        offset: u64,
+        block_data: &[u8],
+        symbol_size: u16,
+    ) -> Option<(BlockInfo, BlockLayout)> {
+        let block_layout = self.read_block_marker(output_dir, block_id)?;
+
+        let block_size = block_data.len() as u64;
+        let repair_symbols = self.calculate_repair_symbols(block_size, symbol_size);
+        let config = self.block_encoder_config(block_size, symbol_size);
+        let block_path = self.block_output_dir(output_dir, block_id);
+        let matches = block_layout.block_id == block_id
+            && block_layout.original_offset == offset
+            && block_layout.size == block_size
+            && block_layout.encoder_parameters == config.serialize()
+            && block_layout.symbols.len() as u64 > repair_symbols
+            && self.count_present_symbols(&block_path, &block_layout, u64::MAX) == block_layout.symbols.len() as u64
+            && block_layout.hash == get_hash_as_b58(block_data);
+        if !matches {
+            debug!("Block {} has to be encoded again", block_id);
+            return None;
+        }
+
+        let block_info = BlockInfo {
+            block_id,
+            encoder_parameters: block_layout.encoder_parameters.clone(),
+            original_offset: offset,
+            size: block_size,
+            symbols_count: block_layout.symbols.len() as u64,
+            source_symbols_count: block_layout.symbols.len() as u64 - repair_symbols,
+            hash: block_layout.hash.clone(),
+        };
+        Some((block_info, block_layout))
+    }
+
+    // Remove the symbols of the given blocks of an encode that failed, when cleanup on
+    // error is on; failures to remove them are only logged
+    fn cleanup_failed_encode(&self, output_dir: &str, block_ids: Range<usize>) {
+        if !self.cleanup_on_error.load(Ordering::SeqCst) {
+            return;
+        }
+        let dir_manager = file_io::get_dir_manager();
+        if self.flat_symbol_layout.load(Ordering::SeqCst) {
+            // The symbols of the completed blocks are listed by their markers, the block
+            // that failed removed the ones it wrote itself
+            for block_id in block_ids {
+                let Some(block_layout) = self.read_block_marker(output_dir, block_id) else {
+                    continue;
+                };
+                for symbol_id in &block_layout.symbols {
+                    let symbol_path = Path::new(output_dir).join(symbol_id).to_string_lossy().to_string();
+                    if let Err(e) = dir_manager.remove_file(&symbol_path) {
+                        debug!("Failed to remove the symbol {} of the failed encode: {}", symbol_path, e);
+                    }
+                }
+            }
+            return;
+        }
+        for block_id in block_ids {
+            let block_dir = Path::new(output_dir).join(block_dir_name(block_id)).to_string_lossy().to_string();
+            if let Err(e) = dir_manager.remove_dir_all(&block_dir) {
+                debug!("Failed to remove the symbols of the failed encode in {}: {}", block_dir, e);
+            }
+        }
+    }
+
+    /// Encode one block and record it in the blocks encoded so far
+    fn process_block(
+        &self,
+        encoded: &mut EncodedBlocks,
+        block_data: &[u8],
+        offset: u64,
+        output_dir: &str,
+        metadata_only: bool,
+        symbols_out: Option<&mut SymbolSink>,
+        keep: Option<&SymbolFilter>,
+    ) -> Result<(), ProcessError> {
+        let block_id = encoded.blocks.len();
+        encoded.object_hasher.update(block_data);
+        let (block_info, block_layout) = self.encode_layout_block(
+            block_id,
+            block_data,
+            offset,
+            output_dir,
+            metadata_only,
+            symbols_out,
+            &encoded.symbol_format,
+            encoded.symbol_size,
+            keep,
+        )?;
+        encoded.push(block_info, block_layout);
+        Ok(())
+    }
+
+    /// Encode one block into the symbols directory and describe it for the result and the layout
+    fn encode_layout_block(
+        &self,
+        block_id: usize,
+        block_data: &[u8],
+        offset: u64,
+        output_dir: &str,
+        metadata_only: bool,
+        symbols_out: Option<&mut SymbolSink>,
+        symbol_format: &SymbolFormat,
+        symbol_size: u16,
+        keep: Option<&SymbolFilter>,
+    ) -> Result<(BlockInfo, BlockLayout), ProcessError> {
+        let block_dir = self.block_output_dir(output_dir, block_id);
+        if !metadata_only && !output_dir.is_empty() {
+            let block_dir_path = block_dir.to_string_lossy().to_string();
+            file_io::get_dir_manager().create_dir_all(&block_dir_path).map_err(|e| {
+                ProcessError::IOError(io::Error::new(io::ErrorKind::Other, e))
+            })?;
+        }
+
+        let block_size = block_data.len() as u64;
+        let repair_symbols = self.calculate_repair_symbols(block_size, symbol_size);
+
+        // Create object transmission information
+        let config = self.block_encoder_config(block_size, symbol_size);
+
+        // Process this block
+        let timer = self.start_timer();
+        // Without block directories, the symbols of a block that fails can only be told
+        // apart from the others while it is written
+        let remove_on_error = self.flat_symbol_layout.load(Ordering::SeqCst)
+            && self.cleanup_on_error.load(Ordering::SeqCst);
+        // Symbols rejected by the filter are listed in the layout but not counted in the result
+        let source_symbols = source_symbols_count(&config);
+        let (mut dropped_source, mut dropped_repair) = (0u64, 0u64);
+        let mut keep_symbol = |esi: u32, is_repair: bool| {
+            let kept = keep.is_none_or(|keep| keep(block_id, esi, is_repair));
+            if !kept && is_repair {
+                dropped_repair += 1;
+            } else if !kept {
+                dropped_source += 1;
+            }
+            kept
+        };
+        let mut send_symbol = symbols_out.map(|out| {
+            move |esi: u32, is_repair: bool, data: Vec<u8>| out(EncodedSymbol { block_id, esi, is_repair, data })
+        });
+        let (params, symbol_ids, hash) = self.encode_block_data(
+            block_data,
+            config,
+            repair_symbols,
+            &block_dir,
+            metadata_only,
+            send_symbol.as_mut().map(|send| send as &mut dyn FnMut(u32, bool, Vec<u8>) -> Result<(), ProcessError>),
+            remove_on_error,
+            symbol_format,
+            &mut keep_symbol,
+        )?;
+        if let Some((metrics, started)) = timer {
+            metrics.record_block(&BlockRecord {
+                operation: BlockOperation::Encode,
+                block_id,
+                size: block_size,
+                symbols: symbol_ids.len() as u64,
+                duration: started.elapsed(),
+            });
+        }
+
+        // BlockInfo for ProcessResult
+        let block_info = BlockInfo {
+            block_id,
+            encoder_parameters: params.clone(),
+            original_offset: offset,
+            size: block_size,
+            symbols_count: symbol_ids.len() as u64 - dropped_source - dropped_repair,
+            source_symbols_count: source_symbols - dropped_source,
+            hash: hash.clone(),
+        };
+
+        // BlockLayout for the metadata file
+        let block_layout = BlockLayout {
+            block_id,
+            encoder_parameters: params,
+            original_offset: offset,
+            size: block_size,
+            symbols: symbol_ids,
+            hash,
+        };
+
+        Ok((block_info, block_layout))
+    }
+
+    /// Save or return the layout of the encoded blocks and build the final result
+    fn finish_layout(
+        &self,
+        encoded: EncodedBlocks,
+        output_dir: &str,
+        return_layout: bool,
+        layout_file: &str,
+    ) -> Result<ProcessResult, ProcessError> {
+        let hashed_with = encoded.object_hasher.content_hash();
+        let content_hash = encoded.object_hasher.finalize_hex();
+        // An empty object is told apart by its hash whatever the hash of the encode
+        let object_sha256 = if encoded.block_layouts.is_empty() {
+            sha256_hex(Sha256::new())
+        } else if hashed_with == ContentHash::Sha256 {
+            content_hash.clone()
+        } else {
+            String::new()
+        };
+
+        // Create layout information to save
+        let layout = RaptorQLayout {
+            layout_version: LAYOUT_VERSION,
+            blocks: encoded.block_layouts,
+            object_sha256,
+            symbol_codec: encoded.symbol_format.codec,
+            symbol_key_id: encoded.symbol_format.cipher.as_ref().map(|c| c.key_id().to_string()).unwrap_or_default(),
+            symbol_crc: encoded.symbol_format.crc,
+            metadata: encoded.metadata.unwrap_or_else(|| self.layout_metadata.lock().clone()),
+        };
+
+        let layout_path_str;
+        let symbols_directory = output_dir.to_string();
+        if !return_layout && !layout_file.is_empty() {
+            // Save layout information to the specified file
+            layout_path_str = Path::new(layout_file).to_string_lossy().to_string();
+            self.write_layout_file(&layout_path_str, &layout)?;
+        } else {
+            // Return layout as object, no file written
+            layout_path_str = String::new();
+        }
+
+        let mut result = ProcessResult {
+            total_symbols_count: encoded.total_symbols_count,
+            total_repair_symbols: encoded.total_repair_symbols,
+            symbols_directory,
+            blocks: Some(encoded.blocks),
+            layout_file_path: layout_path_str,
+            layout_content: None,
+            layout: None,
+            content_hash,
+        };
+
+        // If we're returning the layout directly, include it in the result
+        if return_layout {
+            let layout_json = match serde_json::to_string_pretty(&layout) {
+                Ok(json) => json,
+                Err(e) => {
+                    let err = format!("Failed to serialize layout information: {}", e);
+                    self.set_last_error(err.clone());
+                    return Err(ProcessError::EncodingFailed(err));
+                }
+            };
+            result.layout_content = Some(layout_json);
+            result.layout = Some(layout);
+        }
+
+        Ok(result)
+    }
+
+    // Write a layout file in the format of the processor
+    fn write_layout_file(&self, layout_file: &str, layout: &RaptorQLayout) -> Result<(), ProcessError> {
+        let content = layout.to_bytes(*self.layout_format.lock()).map_err(|e| {
+            self.set_last_error(e.to_string());
+            e
+        })?;
+        let mut writer = file_io::open_file_writer(layout_file)
+            .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
+        writer
+            .write_chunk(0, &content)
+            .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
+        writer
+            .flush()
+            .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
+        debug!("Saved the layout file at {:?}", layout_file);
+        Ok(())
+    }
+
+    fn encode_block_data(
+        &self,
+        data: &[u8],
+        config: ObjectTransmissionInformation,
+        repair_symbols: u64,
+        output_path: &Path,
+        metadata_only: bool,
+        mut symbols_out: Option<&mut dyn FnMut(u32, bool, Vec<u8>) -> Result<(), ProcessError>>,
+        remove_on_error: bool,
+        symbol_format: &SymbolFormat,
+        keep: &mut dyn FnMut(u32, bool) -> bool,
+    ) -> Result<(Vec<u8>, Vec<String>, String), ProcessError> {
+        //get hash of the data
+        let hash_hex = get_hash_as_b58(data);
+
+        // Encode the data
+        debug!("Encoding {} bytes of data with {} repair symbols",
+               data.len(), repair_symbols);
+
+        let encoder = Encoder::new(data, config);
+
+        // Generate symbol ids (and write symbols to disk if not metadata_only)
+        let mut symbol_ids = Vec::new();
+
+        // In the order of get_encoded_packets, the repair symbols of a source block are
+        // only generated once its source symbols are handed over
+        let block_symbols = encoder.get_block_encoders().iter().flat_map(|block_encoder| {
+            block_encoder.source_packets().into_iter().chain(
+                std::iter::once(()).flat_map(move |_| block_encoder.repair_packets(0, repair_symbols as u32)),
+            )
+        });
+        for symbol in block_symbols {
+            let packet = symbol.serialize();
+            let symbol_id = self.calculate_symbol_id(&packet);
+
+            // Only write the symbols to disk if we're not in metadata_only mode
+            let esi = symbol.payload_id().encoding_symbol_id();
+            let is_repair = is_repair_esi(&config, symbol.payload_id().source_block_number(), esi);
+            if !keep(esi, is_repair) {
+                // Not written, still listed in the layout
+            } else if let Some(out) = symbols_out.as_deref_mut() {
+                out(esi, is_repair, packet)?;
+            } else if !metadata_only {
+                let output_file_path = output_path.join(&symbol_id);
+                let path_str = output_file_path.to_string_lossy().to_string();
+                let written = symbol_format.encode(&packet)
+                    .map_err(|e| format!("Failed to encode the symbol file {}: {}", symbol_id, e))
+                    .and_then(|content| {
+                        let mut writer = file_io::open_file_writer(&path_str)?;
+                        writer.write_chunk(0, &content)?;
+                        writer.flush()
+                    });
+                if let Err(e) = written {
+                    if remove_on_error {
+                        let dir_manager = file_io::get_dir_manager();
+                        for written_id in &symbol_ids {
+                            let _ = dir_manager.remove_file(&output_path.join(written_id).to_string_lossy());
+                        }
+                    }
+                    return Err(ProcessError::IOError(io::Error::new(io::ErrorKind::Other, e)));
+                }
+            }
+
+            symbol_ids.push(symbol_id);
+        }
+
+        Ok((encoder.get_config().serialize().to_vec(), symbol_ids, hash_hex))
+    }
+
+    /// Decode RaptorQ symbols to recreate the original file, using a layout file path
+    ///
+    /// This function reads the RaptorQ layout information from the specified file path,
+    /// which contains encoding parameters and blocks metadata.
+    ///
+    /// # Arguments
+    ///
+    /// * `symbols_dir` - Path to the directory containing the symbol files
+    /// * `output_path` - Path where the decoded file will be written
+    /// * `layout_path` - Path to the layout JSON file that contains encoding parameters and blocks information
+    ///
+    /// # Returns
+    ///
+    /// * `Ok(())` on successful decoding
+    /// * `Err(ProcessError::ObjectMismatch)` if the symbols directory holds the layout of another object
+    /// * `Err(ProcessError)` on error (e.g., file not found, decoding failed)
+    pub fn decode_symbols(
+        &self,
+        symbols_dir: &str,
+        output_path: &str,
+        layout_path: &str,
+    ) -> Result<(), ProcessError> {
+        let decode = || {
+            // Check if we can take another task and guard is done in the decode_symbols_with_layout
+            let layout = self.read_layout_file(layout_path)?;
+
+            // Now that we have the layout, delegate to decode_symbols_with_layout
+            self.decode_symbols_with_layout(symbols_dir, output_path, &layout)?;
+            Ok(layout)
+        };
+
+        self.observe_operation("decode_symbols", layout_path, decode, |layout| OperationStats {
+            object_size: layout.blocks.iter().map(|b| b.size).sum(),
+            blocks: layout.blocks.len(),
+            symbols: layout.blocks.iter().map(|b| b.symbols.len() as u64).sum(),
+        })
+        .map(|_| ())
+    }
+
+    /// Decode the symbols of a directory with the layout file it holds
+    ///
+    /// `encode_file` writes the layout next to the symbols as `_raptorq_layout.json`,
+    /// which is used when present so the symbols can't be decoded with the layout of
+    /// another object. The given layout file is only used for directories without one,
+    /// e.g. when the layouts are stored apart from the symbols.
+    ///
+    /// # Arguments
+    ///
+    /// * `symbols_dir` - Path to the directory containing the symbol files
+    /// * `output_path` - Path where the decoded file will be written
+    /// * `layout_path` - Path to the layout file to use if the directory has none, or `None`
+    ///
+    /// # Returns
+    ///
+    /// * `Ok(())` on successful decoding
+    /// * `Err(ProcessError::FileNotFound)` if the directory has no layout file and none is given
+    /// * `Err(ProcessError)` on other errors, as `decode_symbols`
+    pub fn decode_dir(
+        &self,
+        symbols_dir: &str,
+        output_path: &str,
+        layout_path: Option<&str>,
+    ) -> Result<(), ProcessError> {
+        let dir_layout_path = Path::new(symbols_dir).join(LAYOUT_FILENAME).to_string_lossy().to_string();
+        let layout_path = if file_io::open_file_reader(&dir_layout_path).is_ok() {
+            dir_layout_path.as_str()
+        } else if let Some(layout_path) = layout_path {
+            debug!("Symbols directory {:?} has no layout file, using {:?}", symbols_dir, layout_path);
+            layout_path
+        } else {
+            let err = format!("Symbols directory {:?} has no {} file and no layout file is given", symbols_dir, LAYOUT_FILENAME);
+            self.set_last_error(err.clone());
+            return Err(ProcessError::FileNotFound(err));
+        };
+
+        self.decode_symbols(symbols_dir, output_path, layout_path)
+    }
+
+    /// Decode RaptorQ symbols to recreate the original file, using a RaptorQLayout object
+    ///
+    /// This function uses the provided RaptorQLayout structure which contains
+    /// encoding parameters and block metadata
+    ///
+    /// # Arguments
+    ///
+    /// * `symbols_dir` - Path to the directory containing the symbol files
+    /// * `output_path` - Path where the decoded file will be written
+    /// * `layout` - The RaptorQLayout object containing encoding parameters and block information
+    ///
+    /// # Returns
+    ///
+    /// * `Ok(())` on successful decoding
+    /// * `Err(ProcessError)` on error (e.g., file not found, decoding failed)
+    pub fn decode_symbols_with_layout(
+        &self,
+        symbols_dir: &str,
+        output_path: &str,
+        layout: &RaptorQLayout,
+    ) -> Result<(), ProcessError> {
+        self.decode_to_file(&[symbols_dir], output_path, layout, false, false)
+    }
+
+    /// Decode RaptorQ symbols to recreate the original file, checking every symbol first
+    ///
+    /// The id of a symbol is the hash of its content, so a symbol file whose content
+    /// doesn't hash to its name is corrupt. Corrupt symbols are skipped, the decoder
+    /// uses the other symbols of the block instead, and are reported by
+    /// `get_last_corrupt_symbols` and in the last error if the decoding fails.
+    ///
+    /// # Arguments
+    ///
+    /// * `symbols_dir` - Path to the directory containing the symbol files
+    /// * `output_path` - Path where the decoded file will be written
+    /// * `layout_path` - Path to the layout JSON file
+    ///
+    /// # Returns
+    ///
+    /// * `Ok(())` on successful decoding, even if corrupt symbols were skipped
+    /// * `Err(ProcessError)` on error (e.g., not enough valid symbols)
+    pub fn decode_symbols_verified(
+        &self,
+        symbols_dir: &str,
+        output_path: &str,
+        layout_path: &str,
+    ) -> Result<(), ProcessError> {
+        self.last_corrupt_symbols.lock().clear();
+        let layout = self.read_layout_file(layout_path)?;
+        self.decode_to_file(&[symbols_dir], output_path, &layout, true, false)
+    }
+
+    /// Decode RaptorQ symbols to recreate the original file, then check the whole file
+    /// against the SHA-256 recorded in the layout
+    ///
+    /// The block hashes only cover the data of each block, the object hash also catches
+    /// blocks that decode and match their hash but are assembled wrongly, and corruption
+    /// of the output file while it is written. The output file is read back once decoded.
+    ///
+    /// # Arguments
+    ///
+    /// * `symbols_dir` - Path to the directory containing the symbol files
+    /// * `output_path` - Path where the decoded file will be written
+    /// * `layout_path` - Path to the layout JSON file, with an `object_sha256`
+    ///
+    /// # Returns
+    ///
+    /// * `Ok(())` if the decoded file matches the hash of the layout
+    /// * `Err(ProcessError::DecodingFailed)` if the layout has no object hash, nothing
+    ///   is decoded then, or if the decoded file doesn't match it, the file is kept
+    /// * `Err(ProcessError)` on other errors (e.g., insufficient symbols)
+    pub fn decode_symbols_checked(
+        &self,
+        symbols_dir: &str,
+        output_path: &str,
+        layout_path: &str,
+    ) -> Result<(), ProcessError> {
+        let layout = self.read_layout_file(layout_path)?;
+        if layout.object_sha256.is_empty() {
+            let err = format!("Layout {:?} has no object hash to check the decoded file against", layout_path);
+            self.set_last_error(err.clone());
+            return Err(ProcessError::DecodingFailed(err));
+        }
+
+        self.decode_to_file(&[symbols_dir], output_path, &layout, false, false)?;
+
+        let reader = file_io::open_file_reader(output_path)
+            .map_err(|e| ProcessError::IOError(io::Error::new(io::ErrorKind::Other, e)))?;
+        let mut hasher = Sha256::new();
+        io::copy(&mut file_io::SequentialReader::new(reader), &mut hasher)?;
+        let computed = sha256_hex(hasher);
+        if computed != layout.object_sha256 {
+            let err = format!("Hash mismatch for the decoded file {:?}: expected {}, got {}",
+                              output_path, layout.object_sha256, computed);
+            self.set_last_error(err.clone());
+            return Err(ProcessError::DecodingFailed(err));
+        }
+        Ok(())
+    }
+
+    /// Decode RaptorQ symbols to recreate the original file, then write back the source
+    /// symbols missing from the symbols directory
+    ///
+    /// A directory that lost some source symbols still decodes from its repair symbols,
+    /// at the cost of solving the blocks. Once the file is decoded, the missing source
+    /// symbols are encoded again from it with the encoder parameters of the layout and
+    /// written where `decode_symbols` looks them up, so later decodes find them. Symbols
+    /// of the layout are the same as before, lost repair symbols are not regenerated
+    /// (see `generate_repair_symbols`) and the layout file is not modified.
+    ///
+    /// # Arguments
+    ///
+    /// * `symbols_dir` - Path to the directory containing the symbol files, repaired in place
+    /// * `output_path` - Path where the decoded file will be written
+    /// * `layout_path` - Path to the layout JSON file
+    ///
+    /// # Returns
+    ///
+    /// * `Ok(u64)` with the number of source symbols written back, 0 if none was missing
+    /// * `Err(ProcessError)` on error; a decode error leaves the directory untouched
+    pub fn decode_and_repair(
+        &self,
+        symbols_dir: &str,
+        output_path: &str,
+        layout_path: &str,
+    ) -> Result<u64, ProcessError> {
+        let layout = self.read_layout_file(layout_path)?;
+        self.decode_to_file(&[symbols_dir], output_path, &layout, false, false)?;
+
+        // Check if we can take another task
+        let _guard = self.start_task()?;
+
+        let symbol_format = self.layout_symbol_format(&layout)?;
+        let dir_manager = file_io::get_dir_manager();
+        let symbols_dir_path = Path::new(symbols_dir);
+        let mut repaired = 0;
+        for block_layout in &layout.blocks {
+            let config = self.block_decoder_config(block_layout)?;
+            let block_path = self.block_symbols_path(dir_manager.as_ref(), symbols_dir_path, block_layout.block_id)?;
+            // A missing file can't tell whether it held a source symbol, so the source
+            // symbols of every source block are encoded again, each with the SBN and ESI
+            // of its payload ID in its id, and the missing ones are written back
+            let missing: HashSet<&String> = block_layout.symbols.iter()
+                .filter(|symbol_id| {
+                    let symbol_path = block_path.join(symbol_id).to_string_lossy().to_string();
+                    is_symbol_file_name(symbol_id) && self.open_and_validate_file(&symbol_path).is_err()
+                })
+                .collect();
+            if missing.is_empty() {
+                continue;
+            }
+
+            // The decoded data is checked against the block hash
+            let block_data = self.read_encoded_block(output_path, block_layout)?;
+            let encoder = Encoder::new(&block_data, config);
+            let mut block_repaired = 0;
+            for block_encoder in encoder.get_block_encoders() {
+                for packet in block_encoder.source_packets() {
+                    let symbol = packet.serialize();
+                    let symbol_id = self.calculate_symbol_id(&symbol);
+                    if missing.contains(&symbol_id) {
+                        self.write_symbol_file(&symbol_format, &block_path, &symbol_id, &symbol)?;
+                        block_repaired += 1;
+                    }
+                }
+            }
+            debug!("Wrote back {} of the {} missing symbols of block {}",
+                   block_repaired, missing.len(), block_layout.block_id);
+            repaired += block_repaired;
+        }
+
+        Ok(repaired)
+    }
+
+    /// Decode RaptorQ symbols to recreate the original file, decoding blocks in parallel
+    ///
+    /// Blocks are independent, so they are decoded on extra threads taking the task
+    /// slots of the concurrency limit that are free, and written at their offsets
+    /// in the output file as they complete. Each worker holds one block in memory,
+    /// with a single free slot it decodes like `decode_symbols`.
+    ///
+    /// # Arguments
+    ///
+    /// * `symbols_dir` - Path to the directory containing the symbol files
+    /// * `output_path` - Path where the decoded file will be written
+    /// * `layout_path` - Path to the layout JSON file
+    ///
+    /// # Returns
+    ///
+    /// * `Ok(())` on successful decoding
+    /// * `Err(ProcessError)` on error (e.g., file not found, decoding failed)
+    pub fn decode_symbols_parallel(
+        &self,
+        symbols_dir: &str,
+        output_path: &str,
+        layout_path: &str,
+    ) -> Result<(), ProcessError> {
+        let layout = self.read_layout_file(layout_path)?;
+        self.decode_to_file(&[symbols_dir], output_path, &layout, false, true)
+    }
+
+    /// Decode RaptorQ symbols spread over several directories to recreate the original file
+    ///
+    /// The directories are used as one: each symbol of the layout is read from the first
+    /// directory having it, in the order of `symbols_dirs`, so a symbol found in several
+    /// of them is used once and nothing has to be copied into a single directory first.
+    /// Each directory may hold block directories or the symbols directly, like for
+    /// `decode_symbols`.
+    ///
+    /// # Arguments
+    ///
+    /// * `symbols_dirs` - Paths to the directories containing the symbol files
+    /// * `output_path` - Path where the decoded file will be written
+    /// * `layout_path` - Path to the layout JSON file
+    ///
+    /// # Returns
+    ///
+    /// * `Ok(())` on successful decoding
+    /// * `Err(ProcessError::InvalidParameter)` if no directory is given
+    /// * `Err(ProcessError::InvalidPath)` if a directory does not exist
+    /// * `Err(ProcessError)` on other errors (e.g., not enough symbols in all directories)
+    pub fn decode_from_dirs(
+        &self,
+        symbols_dirs: &[&str],
+        output_path: &str,
+        layout_path: &str,
+    ) -> Result<(), ProcessError> {
+        if symbols_dirs.is_empty() {
+            let err = "No symbols directory to decode from".to_string();
+            self.set_last_error(err.clone());
+            return Err(ProcessError::InvalidParameter(err));
+        }
+        let layout = self.read_layout_file(layout_path)?;
+        self.decode_to_file(symbols_dirs, output_path, &layout, false, false)
+    }
+
+    /// Copy the symbols of an object into several shard directories, to store them on
+    /// as many nodes
+    ///
+    /// The symbols of each block are dealt in turn to the shards, starting with shard
+    /// `block_id % n`, so every shard holds about `1/n` of the symbols of every block and
+    /// losing a shard costs each block the same share of its symbols. The sharding only
+    /// depends on the layout, sharding the same object again gives the same shards. A
+    /// shard holds enough symbols to decode a block on its own only if the block has at
+    /// least `n` times the symbols it needs, the map lists such shards.
+    ///
+    /// Every shard directory holds its symbols in block directories like an encode
+    /// writes them, and the map of all shards in `_raptorq_shards.json`, see
+    /// `ShardMap::read_dir`. The shards can be decoded together with `decode_from_dirs`.
+    /// Symbols missing from `symbols_dir`, listed under an id that is not a file name or
+    /// whose file doesn't hold the symbol of their id are skipped and left out of the map,
+    /// so the files are read with the symbol codec and key of the processor.
+    ///
+    /// # Arguments
+    ///
+    /// * `symbols_dir` - Path to the directory containing the symbol files
+    /// * `layout_path` - Path to the layout JSON file
+    /// * `shard_dirs` - Paths of the shard directories, created if needed
+    ///
+    /// # Returns
+    ///
+    /// * `Ok(ShardMap)` with the shard of each symbol copied
+    /// * `Err(ProcessError::InvalidParameter)` if no shard is given or a shard is given twice
+    /// * `Err(ProcessError::InvalidPath)` if the symbols directory does not exist
+    /// * `Err(ProcessError)` on other errors (e.g., invalid layout, symbols encrypted
+    ///   with another key, IO error); the shards may be incomplete
+    pub fn shard_symbols(&self, symbols_dir: &str, layout_path: &str, shard_dirs: &[&str]) -> Result<ShardMap, ProcessError> {
+        let cancellation = self.start_cancellation();
+
+        // Check if we can take another task
+        let _guard = self.start_task()?;
+
+        let distinct: HashSet<&str> = shard_dirs.iter().copied().collect();
+        if shard_dirs.is_empty() || distinct.len() != shard_dirs.len() {
+            let err = format!("Shard directories must be distinct and at least one, got {:?}", shard_dirs);
+            self.set_last_error(err.clone());
+            return Err(ProcessError::InvalidParameter(err));
+        }
+        let layout = self.read_layout_file(layout_path)?;
+        let symbol_format = self.layout_symbol_format(&layout)?;
+
+        let dir_manager = file_io::get_dir_manager();
+        let exists = dir_manager.dir_exists(symbols_dir)
+            .map_err(|e| ProcessError::IOError(io::Error::new(io::ErrorKind::Other, e)))?;
+        if !exists {
+            let err = format!("Symbols directory does not exist: {}", symbols_dir);
+            self.set_last_error(err.clone());
+            return Err(ProcessError::InvalidPath(err));
+        }
+        let symbols_dir_path = Path::new(symbols_dir);
+
+        let shard_count = shard_dirs.len();
+        let mut map = ShardMap {
+            shard_dirs: shard_dirs.iter().map(|dir| dir.to_string()).collect(),
+            symbols: BTreeMap::new(),
+            decodable_shards: Vec::new(),
+        };
+        let mut decodable = vec![true; shard_count];
+        // The symbol files are copied as they are, compressed or encrypted
+        let raw_format = SymbolFormat::default();
+        for block_layout in &layout.blocks {
+            self.check_cancelled(cancellation)?;
+
+            let block_id = block_layout.block_id;
+            let block_path = self.block_symbols_path(dir_manager.as_ref(), symbols_dir_path, block_id)?;
+            let shard_block_dirs: Vec<PathBuf> = shard_dirs.iter().map(|dir| self.block_output_dir(dir, block_id)).collect();
+            for shard_block_dir in &shard_block_dirs {
+                dir_manager.create_dir_all(&shard_block_dir.to_string_lossy())
+                    .map_err(|e| ProcessError::IOError(io::Error::new(io::ErrorKind::Other, e)))?;
+            }
+
+            let mut shard_symbols = vec![0u64; shard_count];
+            for (index, listed_id) in block_layout.symbols.iter().enumerate() {
+                if !is_symbol_file_name(listed_id) {
+                    debug!("Skipping the symbol {:?} of block {}, its id is not a file name", listed_id, block_id);
+                    continue;
+                }
+                let Some(content) = self.read_symbol_file(&block_path, listed_id, &raw_format) else {
+                    debug!("Symbol {} of block {} is missing, it is not sharded", listed_id, block_id);
+                    continue;
+                };
+                if symbol_format.decode(&content).map_or(true, |symbol| symbol_id(&symbol) != *listed_id) {
+                    debug!("Symbol file {} of block {} doesn't hold the symbol, it is not sharded", listed_id, block_id);
+                    continue;
+                }
+                let shard = (block_id + index) % shard_count;
+                let path_str = shard_block_dirs[shard].join(listed_id).to_string_lossy().to_string();
+                let mut writer = file_io::open_file_writer(&path_str)
+                    .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
+                writer.write_chunk(0, &content)
+                    .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
+                writer.flush()
+                    .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
+                map.symbols.insert(listed_id.clone(), shard);
+                shard_symbols[shard] += 1;
+            }
+
+            let required = self.block_min_symbols(block_layout)?.min(block_layout.symbols.len() as u64);
+            for (shard, &count) in shard_symbols.iter().enumerate() {
+                decodable[shard] &= count >= required;
+            }
+        }
+        map.decodable_shards = (0..shard_count).filter(|&shard| decodable[shard]).collect();
+
+        let map_json = serde_json::to_string_pretty(&map).map_err(|e| {
+            let err = format!("Failed to serialize the shard map: {}", e);
+            self.set_last_error(err.clone());
+            ProcessError::EncodingFailed(err)
+        })?;
+        for shard_dir in shard_dirs {
+            dir_manager.create_dir_all(shard_dir)
+                .map_err(|e| ProcessError::IOError(io::Error::new(io::ErrorKind::Other, e)))?;
+            let map_path = Path::new(shard_dir).join(SHARD_MAP_FILENAME).to_string_lossy().to_string();
+            let mut writer = file_io::open_file_writer(&map_path)
+                .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
+            writer.write_chunk(0, map_json.as_bytes())
+                .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
+            writer.flush()
+                .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
+        }
+
+        Ok(map)
+    }
+
+    // Check that a symbols directory holding a layout file, as `encode_file` writes it,
+    // has the symbols of the object of the layout being decoded
+    //
+    // Symbols are plain RFC 6330 packets named after their hash, so they can't carry the
+    // object they belong to, a directory mixed up with another one would only fail with
+    // missing symbols. The layout file of the directory tells its object apart.
+    fn check_symbols_object(&self, symbols_dir: &str, layout: &RaptorQLayout) -> Result<(), ProcessError> {
+        if layout.object_sha256.is_empty() {
+            return Ok(());
+        }
+        let layout_path = Path::new(symbols_dir).join(LAYOUT_FILENAME).to_string_lossy().to_string();
+        let Ok(mut reader) = file_io::open_file_reader(&layout_path) else {
+            return Ok(());
+        };
+        let mut content = vec![0; reader.file_size().unwrap_or(0) as usize];
+        let found = match reader.read_chunk(0, &mut content) {
+            // In either format, a layout that doesn't parse identifies no object
+            Ok(_) => RaptorQLayout::parse(&content).map(|l| l.object_sha256).unwrap_or_default(),
+            Err(e) => {
+                debug!("Failed to read the layout file of the symbols directory {}: {}", symbols_dir, e);
+                String::new()
+            },
+        };
+        if found.is_empty() || found == layout.object_sha256 {
+            return Ok(());
+        }
+
+        let err = ProcessError::ObjectMismatch { expected: layout.object_sha256.clone(), found };
+        self.set_last_error(err.to_string());
+        Err(err)
+    }
+
+    fn decode_to_file(
+        &self,
+        symbols_dirs: &[&str],
+        output_path: &str,
+        layout: &RaptorQLayout,
+        verify_symbols: bool,
+        parallel: bool,
+    ) -> Result<(), ProcessError> {
+        if let Err(e) = symbols_dirs.iter().chain([&output_path]).try_for_each(|path| check_path(path)) {
+            self.set_last_error(e.to_string());
+            return Err(e);
+        }
+
+        let opened = AtomicBool::new(false);
+        let result = self.decode_layout_blocks(symbols_dirs, layout, verify_symbols, parallel, || {
+            let mut output_writer = file_io::open_file_writer(output_path)
+                .map_err(|e| ProcessError::IOError(io::Error::new(io::ErrorKind::Other, e)))?;
+            opened.store(true, Ordering::SeqCst);
+
+            Ok(move |block_layout: &BlockLayout, block_data: &[u8]| {
+                // Write to the correct position in the output file based on the block's original offset
+                output_writer.write_chunk(block_layout.original_offset as usize, block_data)
+                    .map_err(|e| ProcessError::IOError(io::Error::new(io::ErrorKind::Other, e)))
+            })
+        });
+
+        // The output holds only the blocks decoded before the failure
+        if result.is_err() && opened.load(Ordering::SeqCst) && self.cleanup_on_error.load(Ordering::SeqCst) {
+            if let Err(e) = file_io::get_dir_manager().remove_file(output_path) {
+                debug!("Failed to remove the output file {}: {}", output_path, e);
+            }
+        }
+        result
+    }
+
+    /// Decode RaptorQ symbols and write the original data sequentially to a writer
+    ///
+    /// Blocks are decoded and written one by one in the order of their offsets,
+    /// so no temporary file is needed and only one block is held in memory.
+    ///
+    /// # Arguments
+    ///
+    /// * `symbols_dir` - Path to the directory containing the symbol files
+    /// * `layout_path` - Path to the layout JSON file
+    /// * `writer` - Destination of the decoded data
+    ///
+    /// # Returns
+    ///
+    /// * `Ok(u64)` with the number of bytes written
+    /// * `Err(ProcessError)` on error; data of the blocks decoded before may have been written
+    pub fn decode_symbols_to_writer<W: io::Write>(
+        &self,
+        symbols_dir: &str,
+        layout_path: &str,
+        writer: W,
+    ) -> Result<u64, ProcessError> {
+        self.decode_symbols_to_writer_with_progress(symbols_dir, layout_path, writer, |_, _| {})
+    }
+
+    /// Decode RaptorQ symbols and write the original data sequentially to a writer,
+    /// reporting the progress after each block
+    ///
+    /// Like `decode_symbols_to_writer`, blocks are decoded and written one by one in the
+    /// order of their offsets with only one block held in memory. The writer is flushed
+    /// after each block, then `progress` is called with the number of bytes written so
+    /// far and the size of the original data, so a restore of a large object reaches
+    /// its storage as it goes and can drive a progress bar.
+    ///
+    /// # Arguments
+    ///
+    /// * `symbols_dir` - Path to the directory containing the symbol files
+    /// * `layout_path` - Path to the layout JSON file
+    /// * `writer` - Destination of the decoded data
+    /// * `progress` - Called with the bytes written and the total bytes after each block
+    ///
+    /// # Returns
+    ///
+    /// * `Ok(u64)` with the number of bytes written
+    /// * `Err(ProcessError)` on error; data of the blocks decoded before may have been written
+    pub fn decode_symbols_to_writer_with_progress<W: io::Write, P: FnMut(u64, u64)>(
+        &self,
+        symbols_dir: &str,
+        layout_path: &str,
+        mut writer: W,
+        mut progress: P,
+    ) -> Result<u64, ProcessError> {
+        let layout = self.read_layout_file(layout_path)?;
+        let expected: u64 = layout.blocks.iter().map(|b| b.size).sum();
+
+        let mut written = 0u64;
+        self.decode_layout_blocks(&[symbols_dir], &layout, false, false, || {
+            Ok(|block_layout: &BlockLayout, block_data: &[u8]| {
+                if block_layout.original_offset != written {
+                    let err = format!(
+                        "Layout blocks do not cover the data contiguously: block {} at offset {} after {} bytes written",
+                        block_layout.block_id, block_layout.original_offset, written
+                    );
+                    self.set_last_error(err.clone());
+                    return Err(ProcessError::DecodingFailed(err));
+                }
+                writer.write_all(block_data)?;
+                writer.flush()?;
+                written += block_data.len() as u64;
+                progress(written, expected);
+                Ok(())
+            })
+        })?;
+
+        writer.flush()?;
+
+        if written != expected {
+            let err = format!("Layout blocks do not cover the data contiguously: {} of {} bytes written", written, expected);
+            self.set_last_error(err.clone());
+            return Err(ProcessError::DecodingFailed(err));
+        }
+
+        Ok(written)
+    }
+
+    /// Decode RaptorQ symbols and write each block at its offset in a destination
+    ///
+    /// Blocks are decoded in parallel like `decode_symbols_parallel`, and each is written
+    /// at its original offset as it completes, so the destination can be a preallocated
+    /// file or a memory mapping owned by the caller. The destination isn't truncated: only
+    /// the bytes covered by the blocks are written.
+    ///
+    /// # Arguments
+    ///
+    /// * `symbols_dir` - Path to the directory containing the symbol files
+    /// * `layout_path` - Path to the layout JSON file
+    /// * `writer` - Destination of the decoded data
+    ///
+    /// # Returns
+    ///
+    /// * `Ok(u64)` with the number of bytes written, the size of the original data
+    /// * `Err(ProcessError::DecodingFailed)` if the blocks written don't add up to the
+    ///   size of the original data
+    /// * `Err(ProcessError)` on other errors; blocks decoded before may have been written
+    pub fn decode_symbols_to_writer_at<W: WriteAt + ?Sized>(
+        &self,
+        symbols_dir: &str,
+        layout_path: &str,
+        writer: &W,
+    ) -> Result<u64, ProcessError> {
+        let layout = self.read_layout_file(layout_path)?;
+
+        let mut written = 0u64;
+        self.decode_layout_blocks(&[symbols_dir], &layout, false, true, || {
+            Ok(|block_layout: &BlockLayout, block_data: &[u8]| {
+                writer.write_all_at(block_layout.original_offset, block_data)?;
+                written += block_data.len() as u64;
+                Ok(())
+            })
+        })?;
+
+        let expected = layout.total_size();
+        if written != expected {
+            let err = format!("Decoded blocks do not add up to the data: {} of {} bytes written", written, expected);
+            self.set_last_error(err.clone());
+            return Err(ProcessError::DecodingFailed(err));
+        }
+
+        Ok(written)
+    }
+
+    /// Decode only a byte range of the original data and write it to a writer
+    ///
+    /// Only the blocks overlapping `[offset, offset + length)` are decoded, the others
+    /// are skipped entirely, so serving a small slice of a large object costs one or
+    /// two blocks. Exactly the bytes of the range are written, in order.
+    ///
+    /// # Arguments
+    ///
+    /// * `symbols_dir` - Path to the directory containing the symbol files
+    /// * `layout_path` - Path to the layout JSON file
+    /// * `offset` - Offset of the range in the original data
+    /// * `length` - Length of the range, 0 writes nothing
+    /// * `writer` - Destination of the range
+    ///
+    /// # Returns
+    ///
+    /// * `Ok(u64)` with the number of bytes written, always `length`
+    /// * `Err(ProcessError::InvalidParameter)` if the range ends past the data
+    /// * `Err(ProcessError)` on other errors; part of the range may have been written
+    pub fn decode_range<W: io::Write>(
+        &self,
+        symbols_dir: &str,
+        layout_path: &str,
+        offset: u64,
+        length: u64,
+        mut writer: W,
+    ) -> Result<u64, ProcessError> {
+        let layout = self.read_layout_file(layout_path)?;
+
+        let total: u64 = layout.blocks.iter().map(|b| b.size).sum();
+        let end = match offset.checked_add(length) {
+            Some(end) if end <= total => end,
+            _ => {
+                let err = format!("Range of {} bytes at offset {} ends past the {} bytes of data", length, offset, total);
+                self.set_last_error(err.clone());
+                return Err(ProcessError::InvalidParameter(err));
+            }
+        };
+        if length == 0 {
+            return Ok(0);
+        }
+
+        // Blocks overlapping the range
+        let range_layout = RaptorQLayout {
+            layout_version: layout.layout_version,
+            blocks: layout.blocks.iter()
+                .filter(|b| b.original_offset < end && b.original_offset + b.size > offset)
+                .cloned()
+                .collect(),
+            object_sha256: String::new(),
+            symbol_codec: layout.symbol_codec,
+            symbol_key_id: layout.symbol_key_id.clone(),
+            symbol_crc: layout.symbol_crc,
+            metadata: layout.metadata.clone(),
+        };
+        debug!("Decoding {} of {} blocks for the range [{}, {})", range_layout.blocks.len(), layout.blocks.len(), offset, end);
+
+        let mut position = offset;
+        self.decode_layout_blocks(&[symbols_dir], &range_layout, false, false, || {
+            Ok(|block_layout: &BlockLayout, block_data: &[u8]| {
+                let block_start = block_layout.original_offset;
+                if block_start.max(offset) != position {
+                    let err = format!(
+                        "Layout blocks do not cover the range contiguously: block {} at offset {} after {} bytes written",
+                        block_layout.block_id, block_start, position - offset
+                    );
+                    self.set_last_error(err.clone());
+                    return Err(ProcessError::DecodingFailed(err));
+                }
+                let from = (position - block_start) as usize;
+                let to = ((end.min(block_start + block_layout.size) - block_start) as usize).min(block_data.len());
+                writer.write_all(&block_data[from..to])?;
+                position += (to - from) as u64;
+                Ok(())
+            })
+        })?;
+
+        writer.flush()?;
+
+        if position != end {
+            let err = format!("Layout blocks do not cover the range contiguously: {} of {} bytes written", position - offset, length);
+            self.set_last_error(err.clone());
+            return Err(ProcessError::DecodingFailed(err));
+        }
+
+        Ok(length)
+    }
+
+    /// Decode RaptorQ symbols pulled one by one from a source and write the original
+    /// data sequentially to a writer
+    ///
+    /// Each symbol comes with the id of its block, in any order. The source is not read
+    /// any further once every block is decoded, so the remaining symbols never need to be
+    /// fetched. Symbols that are not in the layout of their block, or were already given,
+    /// are ignored. A decoded block is held in memory until the blocks before it are written,
+    /// or in a scratch file of `temp_dir` once the blocks waiting exceed `max_memory_mb`.
+    ///
+    /// No retry is needed when too few symbols were pulled: each symbol is added to the
+    /// decoder of its block, which tries again with every new symbol once it has as many
+    /// as the block has source symbols, so a block that doesn't decode with exactly that
+    /// many gets more until it does. `InsufficientSymbols` is only returned once the
+    /// source is exhausted, or once `set_max_source_symbols` symbols were pulled.
+    ///
+    /// # Arguments
+    ///
+    /// * `symbols` - Source of `(block_id, symbol)` pairs, a read error stops the decoding
+    /// * `layout_path` - Path to the layout JSON file
+    /// * `writer` - Destination of the decoded data
+    ///
+    /// # Returns
+    ///
+    /// * `Ok(u64)` with the number of bytes written
+    /// * `Err(ProcessError)` on error, `InsufficientSymbols` if the source ends or the
+    ///   limit of symbols is reached before every block is decoded; data of the blocks
+    ///   decoded before may have been written
+    pub fn decode_from_source<I, W>(&self, symbols: I, layout_path: &str, writer: W) -> Result<u64, ProcessError>
+    where
+        I: IntoIterator<Item = io::Result<(usize, Vec<u8>)>>,
+        W: io::Write,
+    {
+        let cancellation = self.start_cancellation();
+
+        // Check if we can take another task
+        let _guard = self.start_task()?;
+
+        let layout = self.read_layout_file(layout_path)?;
+        self.decode_source_blocks(symbols, &layout, writer, cancellation)
+    }
+
+    /// Decode symbols received from a channel as they arrive and write the original
+    /// data sequentially to a writer
+    ///
+    /// Same as `decode_from_source` with a layout already parsed, for symbols sent by
+    /// `encode_to_channel` or received from the network. Each block is decoded as soon
+    /// as enough of its symbols arrived, and the decode returns once every block is
+    /// written without waiting for the other symbols. The receiver is dropped then, so
+    /// sending more symbols fails.
+    ///
+    /// # Arguments
+    ///
+    /// * `symbols` - Channel the symbols are received from
+    /// * `layout` - Layout of the encoded object
+    /// * `writer` - Destination of the decoded data
+    ///
+    /// # Returns
+    ///
+    /// * `Ok(u64)` with the number of bytes written
+    /// * `Err(ProcessError)` on error, `InsufficientSymbols` if every sender is dropped or
+    ///   the limit of symbols is reached before every block is decoded (see
+    ///   `set_max_source_symbols`); data of the blocks decoded before may have been written
+    pub fn decode_from_channel<W: io::Write>(
+        &self,
+        symbols: mpsc::Receiver<EncodedSymbol>,
+        layout: &RaptorQLayout,
+        writer: W,
+    ) -> Result<u64, ProcessError> {
+        let cancellation = self.start_cancellation();
+
+        // Check if we can take another task
+        let _guard = self.start_task()?;
+
+        let symbols = symbols.into_iter().map(|symbol| Ok((symbol.block_id, symbol.data)));
+        self.decode_source_blocks(symbols, layout, writer, cancellation)
+    }
+
+    /// Decode symbols and a layout read from a store and write the original data
+    /// sequentially to a writer
+    ///
+    /// Same as `decode_symbols_to_writer`, with every file read through `store` instead
+    /// of the local filesystem. The symbols of a block are read from its block directory,
+    /// or from the symbols directory itself if they are not there.
+    ///
+    /// # Arguments
+    ///
+    /// * `store` - Store holding the symbols and the layout
+    /// * `symbols_dir` - Path of the symbols directory in the store, `.` for its root
+    /// * `layout_path` - Path of the layout JSON file in the store
+    /// * `writer` - Destination of the decoded data
+    ///
+    /// # Returns
+    ///
+    /// * `Ok(u64)` with the number of bytes written
+    /// * `Err(ProcessError::FileNotFound)` if the store has no layout at `layout_path`
+    /// * `Err(ProcessError)` on other errors; data of the blocks decoded before may have been written
+    pub fn decode_from_store<S, W>(&self, store: &S, symbols_dir: &str, layout_path: &str, mut writer: W) -> Result<u64, ProcessError>
+    where
+        S: SymbolStore + ?Sized,
+        W: io::Write,
+    {
+        let cancellation = self.start_cancellation();
+
+        // Check if we can take another task
+        let _guard = self.start_task()?;
+
+        self.last_shortfalls.lock().clear();
+
+        let layout_content = store.read(layout_path).map_err(|e| {
+            let err = format!("Failed to read the layout {:?} from the store: {}", layout_path, e);
+            self.set_last_error(err.clone());
+            match e.kind() {
+                io::ErrorKind::NotFound => ProcessError::FileNotFound(err),
+                kind => ProcessError::IOError(io::Error::new(kind, err)),
+            }
+        })?;
+        let layout = self.parse_layout(layout_content)?;
+
+        if layout.is_empty_object() {
+            writer.flush()?;
+            return Ok(0);
+        }
+        if layout.blocks.is_empty() {
+            let err = "Layout file has the empty blocks array".to_string();
+            self.set_last_error(err.clone());
+            return Err(ProcessError::DecodingFailed(err));
+        }
+        let symbol_format = self.layout_symbol_format(&layout)?;
+
+        let mut sorted_blocks = layout.blocks.clone();
+        sorted_blocks.sort_by(|a, b| a.block_id.cmp(&b.block_id));
+
+        let mut written = 0u64;
+        for block_layout in &sorted_blocks {
+            self.check_cancelled(cancellation)?;
+
+            let block_dir = store_path(symbols_dir, &block_dir_name(block_layout.block_id));
+            let timer = self.start_timer();
+            let block_data = match self.decode_block(block_layout, |symbol_id| {
+                let result = match store.read(&store_path(&block_dir, symbol_id)) {
+                    Err(e) if e.kind() == io::ErrorKind::NotFound => store.read(&store_path(symbols_dir, symbol_id)),
+                    result => result,
+                };
+                result.and_then(|content| symbol_format.decode(&content))
+                    .map_err(|e| debug!("Failed to read the symbol {} from the store: {}", symbol_id, e))
+                    .ok()
+            })? {
+                BlockDecodeOutcome::Decoded(data) => data,
+                BlockDecodeOutcome::Skipped => continue,
+                // The object can't be decoded without the block, the next ones are not tried
+                BlockDecodeOutcome::Shortfall(shortfall) => {
+                    let err = ProcessError::InsufficientSymbols(vec![shortfall.clone()]);
+                    self.set_last_error(err.to_string());
+                    *self.last_shortfalls.lock() = vec![shortfall];
+                    return Err(err);
+                },
+            };
+            if let Some((metrics, started)) = timer {
+                metrics.record_block(&BlockRecord {
+                    operation: BlockOperation::Decode,
+                    block_id: block_layout.block_id,
+                    size: block_data.len() as u64,
+                    symbols: 0,
+                    duration: started.elapsed(),
+                });
+            }
+
+            if block_layout.original_offset != written {
+                let err = format!(
+                    "Layout blocks do not cover the data contiguously: block {} at offset {} after {} bytes written",
+                    block_layout.block_id, block_layout.original_offset, written
+                );
+                self.set_last_error(err.clone());
+                return Err(ProcessError::DecodingFailed(err));
+            }
+            writer.write_all(&block_data)?;
+            written += block_data.len() as u64;
+        }
+
+        writer.flush()?;
+
+        let expected: u64 = layout.blocks.iter().map(|b| b.size).sum();
+        if written != expected {
+            let err = format!("Layout blocks do not cover the data contiguously: {} of {} bytes written", written, expected);
+            self.set_last_error(err.clone());
+            return Err(ProcessError::DecodingFailed(err));
+        }
+
+        Ok(written)
+    }
+
+    /// Decode the symbols of a tar archive to recreate the original file
+    ///
+    /// The archive holds the symbols as `block_<id>/<symbol_id>` entries, like the
+    /// symbols directory written by `encode_file`, possibly under a top directory.
+    /// Symbols are read straight from the archive, which is not extracted, and the
+    /// rest of the archive is not read once every block is decoded.
+    ///
+    /// # Arguments
+    ///
+    /// * `tar_path` - Path to the tar archive
+    /// * `output_path` - Path where the decoded file will be written
+    /// * `layout_path` - Path to the layout JSON file, or `None` to read the
+    ///   `_raptorq_layout.json` entry of the archive
+    ///
+    /// # Returns
+    ///
+    /// * `Ok(())` on successful decoding
+    /// * `Err(ProcessError::IOError)` if the archive is truncated or corrupt
+    ///   before enough symbols were read
+    /// * `Err(ProcessError::FileNotFound)` if the archive or its layout is missing
+    /// * `Err(ProcessError)` on other errors (e.g. insufficient symbols)
+    pub fn decode_from_tar(
+        &self,
+        tar_path: &str,
+        output_path: &str,
+        layout_path: Option<&str>,
+    ) -> Result<(), ProcessError> {
+        let cancellation = self.start_cancellation();
+
+        // Check if we can take another task
+        let _guard = self.start_task()?;
+
+        let layout = match layout_path {
+            Some(layout_path) => self.read_layout_file(layout_path)?,
+            None => self.read_tar_layout(tar_path)?,
+        };
+
+        let mut archive = tar::Archive::new(self.open_tar(tar_path)?);
+        let entries = archive.entries().map_err(|e| self.tar_error(tar_path, e))?;
+        let symbols = entries.filter_map(|entry| {
+            read_tar_symbol(entry).map_err(|e| self.tar_error(tar_path, e)).transpose()
+        });
+
+        let output_writer = file_io::open_file_writer(output_path)
+            .map_err(|e| ProcessError::IOError(io::Error::new(io::ErrorKind::Other, e)))?;
+        let writer = io::BufWriter::new(file_io::SequentialWriter::new(output_writer));
+        self.decode_source_blocks(symbols, &layout, writer, cancellation)?;
+        Ok(())
+    }
+
+    /// Decode an archive written by `encode_file_to_archive` to recreate the original file
+    ///
+    /// Same as `decode_from_tar` with the layout of the archive.
+    pub fn decode_from_archive(&self, archive_path: &str, output_path: &str) -> Result<(), ProcessError> {
+        self.decode_from_tar(archive_path, output_path, None)
+    }
+
+    /// Decode the given symbol files only to recreate the original file
+    ///
+    /// Symbol files are named after their symbol id, which gives the block of each file
+    /// from the layout wherever it is stored, so no directory is listed or searched.
+    /// Files are read in the order of `symbol_paths` until every block is decoded; files
+    /// that are not in the layout, missing or unreadable are skipped, and a symbol listed
+    /// twice is used once.
+    ///
+    /// # Arguments
+    ///
+    /// * `symbol_paths` - Paths of the symbol files to decode from
+    /// * `output_path` - Path where the decoded file will be written
+    /// * `layout_path` - Path to the layout JSON file
+    ///
+    /// # Returns
+    ///
+    /// * `Ok(())` on successful decoding
+    /// * `Err(ProcessError::InsufficientSymbols)` if the files are not enough to decode every block
+    /// * `Err(ProcessError)` on other errors (e.g., invalid layout)
+    pub fn decode_from_files(
+        &self,
+        symbol_paths: &[&str],
+        output_path: &str,
+        layout_path: &str,
+    ) -> Result<(), ProcessError> {
+        let cancellation = self.start_cancellation();
+
+        // Check if we can take another task
+        let _guard = self.start_task()?;
+
+        let layout = self.read_layout_file(layout_path)?;
+        let symbol_blocks: HashMap<&str, usize> = layout.blocks.iter()
+            .flat_map(|b| b.symbols.iter().map(move |symbol_id| (symbol_id.as_str(), b.block_id)))
+            .collect();
+
+        let symbols = symbol_paths.iter().filter_map(|symbol_path| {
+            let symbol_id = Path::new(symbol_path).file_name().and_then(|name| name.to_str()).unwrap_or_default();
+            let Some(&block_id) = symbol_blocks.get(symbol_id) else {
+                debug!("Ignoring the symbol file {:?} which is not in the layout", symbol_path);
+                return None;
+            };
+            let (mut symbol_reader, symbol_size) = self.open_and_validate_file(symbol_path)
+                .map_err(|e| debug!("Skipping the symbol file {:?}: {}", symbol_path, e))
+                .ok()?;
+            let mut content = vec![0u8; symbol_size];
+            match symbol_reader.read_chunk(0, &mut content) {
+                Ok(bytes_read) if bytes_read == symbol_size => Some(Ok((block_id, content))),
+                Ok(bytes_read) => {
+                    debug!("Partial read of the symbol file {:?}: {} of {} bytes", symbol_path, bytes_read, symbol_size);
+                    None
+                },
+                Err(e) => {
+                    debug!("Failed to read the symbol file {:?}: {}", symbol_path, e);
+                    None
+                }
+            }
+        });
+
+        let output_writer = file_io::open_file_writer(output_path)
+            .map_err(|e| ProcessError::IOError(io::Error::new(io::ErrorKind::Other, e)))?;
+        let writer = io::BufWriter::new(file_io::SequentialWriter::new(output_writer));
+        self.decode_source_blocks(symbols, &layout, writer, cancellation)?;
+        Ok(())
+    }
+
+    // Read the layout entry of a tar archive
+    fn read_tar_layout(&self, tar_path: &str) -> Result<RaptorQLayout, ProcessError> {
+        let mut archive = tar::Archive::new(self.open_tar(tar_path)?);
+        let entries = archive.entries().map_err(|e| self.tar_error(tar_path, e))?;
+        for entry in entries {
+            let mut entry = entry.map_err(|e| self.tar_error(tar_path, e))?;
+            let is_layout = entry.path()
+                .map(|path| path.file_name().is_some_and(|name| name == LAYOUT_FILENAME))
+                .unwrap_or(false);
+            if is_layout {
+                let content = read_tar_entry(&mut entry, MAX_LAYOUT_LEN).map_err(|e| self.tar_error(tar_path, e))?;
+                return self.parse_layout(content);
+            }
+        }
+
+        let err = format!("Tar archive {:?} has no {} entry", tar_path, LAYOUT_FILENAME);
+        self.set_last_error(err.clone());
+        Err(ProcessError::FileNotFound(err))
+    }
+
+    fn open_tar(&self, tar_path: &str) -> Result<io::BufReader<file_io::SequentialReader>, ProcessError> {
+        match file_io::open_file_reader(tar_path) {
+            Ok(reader) => Ok(io::BufReader::new(file_io::SequentialReader::new(reader))),
+            Err(e) => {
+                let err = format!("Failed to open file {:?}: {}", tar_path, e);
+                self.set_last_error(err.clone());
+                Err(ProcessError::FileNotFound(err))
+            }
+        }
+    }
+
+    fn tar_error(&self, tar_path: &str, e: io::Error) -> io::Error {
+        let err = format!("Failed to read the tar archive {:?}: {}", tar_path, e);
+        self.set_last_error(err.clone());
+        io::Error::new(e.kind(), err)
+    }
+
+    // Decode the blocks of the layout from symbols in any order, see decode_from_source
+    fn decode_source_blocks<I, W>(
+        &self,
+        symbols: I,
+        layout: &RaptorQLayout,
+        mut writer: W,
+        cancellation: Cancellation,
+    ) -> Result<u64, ProcessError>
+    where
+        I: IntoIterator<Item = io::Result<(usize, Vec<u8>)>>,
+        W: io::Write,
+    {
+        self.last_shortfalls.lock().clear();
+
+        if layout.is_empty_object() {
+            writer.flush()?;
+            return Ok(0);
+        }
+        if layout.blocks.is_empty() {
+            let err = "Layout file has the empty blocks array".to_string();
+            self.set_last_error(err.clone());
+            return Err(ProcessError::DecodingFailed(err));
+        }
+        let symbol_format = self.layout_symbol_format(layout)?;
+
+        // Blocks in the order of their data
+        let mut blocks: Vec<&BlockLayout> = layout.blocks.iter().collect();
+        blocks.sort_by_key(|b| b.original_offset);
+        let block_index: HashMap<usize, usize> = blocks.iter()
+            .enumerate()
+            .map(|(index, b)| (b.block_id, index))
+            .collect();
+        let mut states = blocks.iter()
+            .map(|b| {
+                let config = self.block_decoder_config(b)?;
+                Ok(SourceBlockState {
+                    decoder: Decoder::new(config),
+                    pending_ids: b.symbols.iter().map(String::as_str).collect(),
+                    present: 0,
+                    required: source_symbols_count(&config),
+                    data: None,
+                    decoded: false,
+                })
+            })
+            .collect::<Result<Vec<_>, ProcessError>>()?;
+
+        // Blocks waiting for the blocks before them stay in memory up to the memory limit
+        let memory_limit = self.config.max_memory_mb * 1024 * 1024;
+        let mut waiting_in_memory = 0u64;
+        let mut scratch: Option<ScratchFile> = None;
+
+        // The symbols past the limit are never pulled
+        let max_symbols = self.max_source_symbols.lock().unwrap_or(u64::MAX);
+        let mut symbols = symbols.into_iter().take(usize::try_from(max_symbols).unwrap_or(usize::MAX));
+        let mut next_to_write = 0;
+        let mut written = 0u64;
+        while next_to_write < states.len() {
+            let Some(item) = symbols.next() else { break };
+            self.check_cancelled(cancellation)?;
+
+            let (block_id, content) = match item {
+                Ok(symbol) => symbol,
+                Err(e) => {
+                    self.set_last_error(e.to_string());
+                    return Err(ProcessError::IOError(e));
+                }
+            };
+            let Some(&index) = block_index.get(&block_id) else {
+                debug!("Ignoring a symbol of block {} which is not in the layout", block_id);
+                continue;
+            };
+            let symbol = match symbol_format.decode(&content) {
+                Ok(symbol) => symbol,
+                Err(e) => {
+                    debug!("Ignoring a symbol of block {} which can't be read: {}", block_id, e);
+                    continue;
+                }
+            };
+            let state = &mut states[index];
+            if state.decoded || symbol.len() <= 4 || !state.pending_ids.remove(self.calculate_symbol_id(&symbol).as_str()) {
+                continue;
+            }
+
+            match self.safe_decode(&mut state.decoder, EncodingPacket::deserialize(&symbol)) {
+                Ok(Some(data)) => {
+                    self.verify_block_hash(blocks[index], &data)?;
+                    state.decoded = true;
+                    let in_memory = index == next_to_write || waiting_in_memory + data.len() as u64 <= memory_limit;
+                    if let Some(temp_dir) = self.temp_dir().filter(|_| !in_memory) {
+                        let scratch = match &mut scratch {
+                            Some(scratch) => scratch,
+                            None => scratch.insert(ScratchFile::create(&temp_dir).map_err(|e| {
+                                let err = format!("Failed to create a scratch file in {:?}: {}", temp_dir, e);
+                                self.set_last_error(err.clone());
+                                ProcessError::IOError(io::Error::new(e.kind(), err))
+                            })?),
+                        };
+                        debug!("Writing block {} to the scratch file {}", blocks[index].block_id, scratch.path);
+                        let offset = scratch.append(&data)?;
+                        state.data = Some(WaitingBlock::Scratch { offset, len: data.len() });
+                    } else {
+                        // Without a temp directory, blocks stay in memory over the limit
+                        waiting_in_memory += data.len() as u64;
+                        state.data = Some(WaitingBlock::Memory(data));
+                    }
+                },
+                Ok(None) => {
+                    state.present += 1;
+                    continue;
+                },
+                Err(_) => continue,
+            }
+
+            // Write the blocks that are now next to the data written
+            while let Some(waiting) = states.get_mut(next_to_write).and_then(|s| s.data.take()) {
+                let data = match waiting {
+                    WaitingBlock::Memory(data) => {
+                        waiting_in_memory -= data.len() as u64;
+                        data
+                    },
+                    WaitingBlock::Scratch { offset, len } => scratch.as_ref().unwrap().read(offset, len)?,
+                };
+                writer.write_all(&data)?;
+                written += data.len() as u64;
+                next_to_write += 1;
+            }
+        }
+
+        let shortfalls: Vec<BlockShortfall> = blocks.iter()
+            .zip(&states)
+            .filter(|(_, state)| !state.decoded)
+            .map(|(b, state)| BlockShortfall {
+                block_id: b.block_id,
+                present: state.present,
+                required: state.required.max(state.present + 1),
+            })
+            .collect();
+        if !shortfalls.is_empty() {
+            let err = ProcessError::InsufficientSymbols(shortfalls.clone());
+            self.set_last_error(err.to_string());
+            *self.last_shortfalls.lock() = shortfalls;
+            return Err(err);
+        }
+
+        writer.flush()?;
+
+        let expected: u64 = layout.blocks.iter().map(|b| b.size).sum();
+        if written != expected {
+            let err = format!("Layout blocks do not cover the data contiguously: {} of {} bytes written", written, expected);
+            self.set_last_error(err.clone());
+            return Err(ProcessError::DecodingFailed(err));
+        }
+
+        Ok(written)
+    }
+
+    /// Decode the blocks of the layout from the symbols directories, in the order of their ids
+    ///
+    /// Each symbol is read from the first of `symbols_dirs` having it. `open_output` is called once the inputs are validated and returns the sink
+    /// receiving the data of every decoded block. With `verify_symbols`, symbols
+    /// not matching their id are skipped and recorded as corrupt.
+    ///
+    /// With `parallel`, blocks are decoded on extra threads, each taking a free task
+    /// slot of the concurrency limit, and the sink receives them in the order they
+    /// complete, so it must place each block at its offset.
+    fn decode_layout_blocks<O, E>(
+        &self,
+        symbols_dirs: &[&str],
+        layout: &RaptorQLayout,
+        verify_symbols: bool,
+        parallel: bool,
+        open_output: O,
+    ) -> Result<(), ProcessError>
+    where
+        O: FnOnce() -> Result<E, ProcessError>,
+        E: FnMut(&BlockLayout, &[u8]) -> Result<(), ProcessError>,
+    {
+        let cancellation = self.start_cancellation();
+
+        // Check if we can take another task
+        let _guard = self.start_task()?;
+
+        self.last_shortfalls.lock().clear();
+
+        if layout.is_empty_object() {
+            open_output()?;
+            return Ok(());
+        }
+        if layout.blocks.is_empty() {
+            let err = "Layout file has the empty blocks array".to_string();
+            self.set_last_error(err.clone());
+            return Err(ProcessError::DecodingFailed(err));
+        }
+        let symbol_format = self.layout_symbol_format(layout)?;
+
+        let dir_manager = file_io::get_dir_manager();
+
+        // check if the symbols dirs exist
+        for symbols_dir in symbols_dirs {
+            let exists = dir_manager.dir_exists(symbols_dir)
+                .map_err(|e| ProcessError::IOError(io::Error::new(io::ErrorKind::Other, e)))?;
+            if !exists {
+                return Err(ProcessError::InvalidPath(format!("Symbols directory does not exist: {}",symbols_dir)));
+            }
+            self.check_symbols_object(symbols_dir, layout)?;
+        }
+
+        let mut write_block = open_output()?;
+
+        // Process multiple blocks
+        debug!("Decoding the file with {} blocks", layout.blocks.len());
+        
+        // Sort blocks by their block_id to ensure deterministic processing order
+        let mut sorted_blocks = layout.blocks.clone();
+        sorted_blocks.sort_by(|a, b| a.block_id.cmp(&b.block_id));
+
+        let block_paths = sorted_blocks.iter()
+            .map(|b| {
+                symbols_dirs.iter()
+                    .map(|dir| self.block_symbols_path(dir_manager.as_ref(), Path::new(dir), b.block_id))
+                    .collect::<Result<Vec<_>, ProcessError>>()
+            })
+            .collect::<Result<Vec<_>, ProcessError>>()?;
+
+        let mut handle_outcome = |block_layout: &BlockLayout, outcome: BlockDecodeOutcome| match outcome {
+            BlockDecodeOutcome::Decoded(data) => write_block(block_layout, &data),
+            BlockDecodeOutcome::Skipped => Ok(()),
+            // The object can't be decoded without the block, the next ones are not tried
+            BlockDecodeOutcome::Shortfall(shortfall) => {
+                let err = ProcessError::InsufficientSymbols(vec![shortfall.clone()]);
+                let corrupt_symbols = self.last_corrupt_symbols.lock();
+                if verify_symbols && !corrupt_symbols.is_empty() {
+                    self.set_last_error(format!("{}; {}", err, format_corrupt_symbols(&corrupt_symbols)));
+                } else {
+                    self.set_last_error(err.to_string());
+                }
+                *self.last_shortfalls.lock() = vec![shortfall];
+                Err(err)
+            },
+        };
+
+        // Threads are not available in the browser
+        // The calling thread is a worker as well
+        let worker_guards = if parallel { self.start_workers(sorted_blocks.len().saturating_sub(1)) } else { Vec::new() };
+
+        if worker_guards.is_empty() {
+            // Iterate over blocks from the layout file (source of truth)
+            for (block_layout, block_path) in sorted_blocks.iter().zip(&block_paths) {
+                self.check_cancelled(cancellation)?;
+                let outcome = self.decode_layout_block(block_path, block_layout, &symbol_format, verify_symbols)?;
+                handle_outcome(block_layout, outcome)?;
+            }
+        } else {
+            let workers = worker_guards.len() + 1;
+            debug!("Decoding {} blocks with {} workers", sorted_blocks.len(), workers);
+
+            let next_block = AtomicUsize::new(0);
+            let owner = operation_thread();
+            std::thread::scope(|scope| {
+                // No buffering, at most one decoded block per worker is held in memory
+                let (sender, receiver) = mpsc::sync_channel(0);
+                for _ in 0..workers {
+                    let sender = sender.clone();
+                    let (sorted_blocks, block_paths, next_block, symbol_format, owner) = (&sorted_blocks, &block_paths, &next_block, &symbol_format, &owner);
+                    scope.spawn(move || work_for(owner, || loop {
+                        let index = next_block.fetch_add(1, Ordering::SeqCst);
+                        let (Some(block_layout), Some(block_path)) = (sorted_blocks.get(index), block_paths.get(index)) else { break };
+                        let outcome = self.check_cancelled(cancellation)
+                            .and_then(|_| self.decode_layout_block(block_path, block_layout, symbol_format, verify_symbols));
+                        // The receiver is gone once a block failed
+                        if sender.send((block_layout, outcome)).is_err() {
+                            break;
+                        }
+                    }));
+                }
+                drop(sender);
+
+                for (block_layout, outcome) in receiver {
+                    handle_outcome(block_layout, outcome?)?;
+                }
+                Ok::<_, ProcessError>(())
+            })?;
+        }
+
+        Ok(())
+    }
+
+    // Decode a block of the layout from the symbols of `block_paths`, the first one having
+    // a symbol giving it, and record its metrics.
+    // With `verify_symbols`, symbols not matching their id are skipped and recorded as corrupt.
+    fn decode_layout_block(
+        &self,
+        block_paths: &[PathBuf],
+        block_layout: &BlockLayout,
+        symbol_format: &SymbolFormat,
+        verify_symbols: bool,
+    ) -> Result<BlockDecodeOutcome, ProcessError> {
+        let timer = self.start_timer();
+        let outcome = self.decode_block(block_layout, |symbol_id| {
+            let symbol = block_paths.iter()
+                .find_map(|block_path| self.read_symbol_file(block_path, symbol_id, symbol_format))?;
+            if verify_symbols && self.calculate_symbol_id(&symbol) != symbol_id {
+                debug!("Symbol {} in block {} failed checksum", symbol_id, block_layout.block_id);
+                self.last_corrupt_symbols.lock().push(CorruptSymbol {
+                    block_id: block_layout.block_id,
+                    symbol_id: symbol_id.to_string(),
+                });
+                return None;
+            }
+            Some(symbol)
+        })?;
+        if let (Some((metrics, started)), BlockDecodeOutcome::Decoded(data)) = (timer, &outcome) {
+            metrics.record_block(&BlockRecord {
+                operation: BlockOperation::Decode,
+                block_id: block_layout.block_id,
+                size: data.len() as u64,
+                symbols: 0,
+                duration: started.elapsed(),
+            });
+        }
+        Ok(outcome)
+    }
+
+    // Directory holding the symbols of a block: its block directory if it exists,
+    // otherwise the symbols directory itself
+    fn block_symbols_path(
+        &self,
+        dir_manager: &dyn file_io::DirManager,
+        symbols_dir_path: &Path,
+        block_id: usize,
+    ) -> Result<PathBuf, ProcessError> {
+        let block_dir_path = symbols_dir_path.join(block_dir_name(block_id));
+
+        let block_dir_path_str = block_dir_path.to_string_lossy().to_string();
+        let exists = dir_manager.dir_exists(&block_dir_path_str)
+            .map_err(|e| ProcessError::IOError(io::Error::new(io::ErrorKind::Other, e)))?;
+        if exists {
+            debug!("Using block directory: {}", block_dir_path_str);
+            Ok(block_dir_path)
+        } else {
+            debug!("Block directory does not exist, falling back to the symbols directory: {:?}", symbols_dir_path);
+            Ok(symbols_dir_path.to_path_buf())
+        }
+    }
+
+    /// Check whether the symbols of a directory are likely enough to decode a layout,
+    /// without decoding it
+    ///
+    /// Each block needs the number of symbols given by min_symbols_for_block, or all
+    /// of its symbols if the layout has fewer. Symbols are looked up the same way
+    /// decode_symbols does, they are not read nor verified.
+    ///
+    /// # Arguments
+    ///
+    /// * `symbols_dir` - Path to the directory containing the symbol files
+    /// * `layout_path` - Path to the layout JSON file
+    ///
+    /// # Returns
+    ///
+    /// * `Ok(true)` if every block has enough symbols
+    /// * `Ok(false)` if a block is missing symbols or the symbols directory does not exist
+    /// * `Err(ProcessError)` on error (e.g., invalid layout, IO error)
+    pub fn can_decode(&self, symbols_dir: &str, layout_path: &str) -> Result<bool, ProcessError> {
+        let layout = self.read_layout_file(layout_path)?;
+        if layout.blocks.is_empty() && !layout.is_empty_object() {
+            let err = "Layout file has the empty blocks array".to_string();
+            self.set_last_error(err.clone());
+            return Err(ProcessError::DecodingFailed(err));
+        }
+
+        let dir_manager = file_io::get_dir_manager();
+        let exists = dir_manager.dir_exists(symbols_dir)
+            .map_err(|e| ProcessError::IOError(io::Error::new(io::ErrorKind::Other, e)))?;
+        if !exists {
+            return Ok(false);
+        }
+
+        let symbols_dir_path = Path::new(symbols_dir);
+        let mut decodable = true;
+
+        for block_layout in &layout.blocks {
+            // Validate the encoder parameters of every block, even after a shortfall
+            let required = self.block_min_symbols(block_layout)?.min(block_layout.symbols.len() as u64);
+            if !decodable {
+                continue;
+            }
+
+            let block_path = self.block_symbols_path(dir_manager.as_ref(), symbols_dir_path, block_layout.block_id)?;
+            let present = self.count_present_symbols(&block_path, block_layout, required);
+            if present < required {
+                debug!("Block {} has {} of {} required symbols", block_layout.block_id, present, required);
+                decodable = false;
+            }
+        }
+
+        Ok(decodable)
+    }
+
+    /// Count, for each block of a layout, how many more symbols the directory needs
+    /// to reach the number given by min_symbols_for_block
+    ///
+    /// Symbols are looked up the same way decode_symbols does, they are not read nor
+    /// verified. A repair can use the counts to regenerate symbols for the blocks
+    /// that are at risk before they become unrecoverable.
+    ///
+    /// # Arguments
+    ///
+    /// * `symbols_dir` - Path to the directory containing the symbol files
+    /// * `layout_path` - Path to the layout JSON file
+    ///
+    /// # Returns
+    ///
+    /// * `Ok(BTreeMap)` mapping each block id to the number of missing symbols,
+    ///   zero or negative if the block already has enough (all of the required
+    ///   symbols are missing if the symbols directory does not exist)
+    /// * `Err(ProcessError)` on error (e.g., invalid layout, IO error)
+    pub fn missing_symbols(&self, symbols_dir: &str, layout_path: &str) -> Result<BTreeMap<usize, i64>, ProcessError> {
+        let layout = self.read_layout_file(layout_path)?;
+        if layout.blocks.is_empty() && !layout.is_empty_object() {
+            let err = "Layout file has the empty blocks array".to_string();
+            self.set_last_error(err.clone());
+            return Err(ProcessError::DecodingFailed(err));
+        }
+
+        let dir_manager = file_io::get_dir_manager();
+        let exists = dir_manager.dir_exists(symbols_dir)
+            .map_err(|e| ProcessError::IOError(io::Error::new(io::ErrorKind::Other, e)))?;
+        let symbols_dir_path = Path::new(symbols_dir);
+
+        let mut missing = BTreeMap::new();
+        for block_layout in &layout.blocks {
+            let required = self.block_min_symbols(block_layout)?;
+            let present = if exists {
+                let block_path = self.block_symbols_path(dir_manager.as_ref(), symbols_dir_path, block_layout.block_id)?;
+                self.count_present_symbols(&block_path, block_layout, u64::MAX)
+            } else {
+                0
+            };
+            debug!("Block {} has {} of {} required symbols", block_layout.block_id, present, required);
+            missing.insert(block_layout.block_id, required as i64 - present as i64);
+        }
+
+        Ok(missing)
+    }
+
+    /// Report how many symbols of each block of a layout a directory holds against the
+    /// number needed to decode it, without decoding
+    ///
+    /// Meant for periodic audits of stored objects: the margin of a block is the number
+    /// of symbols it can still lose before a decode is likely to fail, so a shrinking
+    /// margin tells when to regenerate symbols (see `generate_repair_symbols`). Symbols
+    /// are looked up the same way decode_symbols does, they are not read nor verified,
+    /// and a missing symbols directory has no symbols.
+    ///
+    /// # Arguments
+    ///
+    /// * `symbols_dir` - Path to the directory containing the symbol files
+    /// * `layout_path` - Path to the layout JSON file
+    ///
+    /// # Returns
+    ///
+    /// * `Ok(AuditReport)` with the availability of every block
+    /// * `Err(ProcessError)` on error (e.g., invalid layout, IO error)
+    pub fn audit_object(&self, symbols_dir: &str, layout_path: &str) -> Result<AuditReport, ProcessError> {
+        let layout = self.read_layout_file(layout_path)?;
+        if layout.blocks.is_empty() && !layout.is_empty_object() {
+            let err = "Layout file has the empty blocks array".to_string();
+            self.set_last_error(err.clone());
+            return Err(ProcessError::DecodingFailed(err));
+        }
+
+        let dir_manager = file_io::get_dir_manager();
+        let exists = dir_manager.dir_exists(symbols_dir)
+            .map_err(|e| ProcessError::IOError(io::Error::new(io::ErrorKind::Other, e)))?;
+        let symbols_dir_path = Path::new(symbols_dir);
+
+        let mut blocks = Vec::with_capacity(layout.blocks.len());
+        for block_layout in &layout.blocks {
+            let listed = block_layout.symbols.len() as u64;
+            let threshold = self.block_min_symbols(block_layout)?.min(listed);
+            let available = if exists {
+                let block_path = self.block_symbols_path(dir_manager.as_ref(), symbols_dir_path, block_layout.block_id)?;
+                self.count_present_symbols(&block_path, block_layout, u64::MAX)
+            } else {
+                0
+            };
+            debug!("Block {} has {} of {} required symbols", block_layout.block_id, available, threshold);
+            blocks.push(BlockAudit {
+                block_id: block_layout.block_id,
+                listed,
+                available,
+                source_symbols: block_layout.source_symbols_count(),
+                threshold,
+                margin: available as i64 - threshold as i64,
+            });
+        }
+
+        let min_margin = blocks.iter().map(|b| b.margin).min().unwrap_or(0);
+        Ok(AuditReport { recoverable: min_margin >= 0, min_margin, blocks })
+    }
+
+    /// Count the source and repair symbols of each block of a layout a directory holds,
+    /// without decoding
+    ///
+    /// Meant for monitoring the replication of stored objects: a block missing repair
+    /// symbols still decodes but has less to lose, one missing source symbols needs a
+    /// decode that solves it. The first symbols listed for a block are its source
+    /// symbols, the following ones its repair symbols. Symbols are looked up the same
+    /// way decode_symbols does, they are not read nor verified, and a missing symbols
+    /// directory has no symbols.
+    ///
+    /// # Arguments
+    ///
+    /// * `symbols_dir` - Path to the directory containing the symbol files
+    /// * `layout_path` - Path to the layout JSON file
+    ///
+    /// # Returns
+    ///
+    /// * `Ok(BTreeMap<usize, BlockInventory>)` mapping each block id to its symbols
+    /// * `Err(ProcessError)` on error (e.g., invalid layout, IO error)
+    pub fn symbol_inventory(&self, symbols_dir: &str, layout_path: &str) -> Result<BTreeMap<usize, BlockInventory>, ProcessError> {
+        let layout = self.read_layout_file(layout_path)?;
+        if layout.blocks.is_empty() && !layout.is_empty_object() {
+            let err = "Layout file has the empty blocks array".to_string();
+            self.set_last_error(err.clone());
+            return Err(ProcessError::DecodingFailed(err));
+        }
+
+        let dir_manager = file_io::get_dir_manager();
+        let exists = dir_manager.dir_exists(symbols_dir)
+            .map_err(|e| ProcessError::IOError(io::Error::new(io::ErrorKind::Other, e)))?;
+        let symbols_dir_path = Path::new(symbols_dir);
+
+        let mut inventory = BTreeMap::new();
+        for block_layout in &layout.blocks {
+            let config = self.block_decoder_config(block_layout)?;
+            let source_expected = source_symbols_count(&config).min(block_layout.symbols.len() as u64);
+            let mut block_inventory = BlockInventory {
+                source_present: 0,
+                repair_present: 0,
+                source_expected,
+                repair_expected: block_layout.symbols.len() as u64 - source_expected,
+            };
+            if exists {
+                // The layout lists the symbols of each source block in turn, its source
+                // symbols then its share of the repair symbols
+                let source_blocks = config.source_blocks() as u64;
+                let repair_per_source_block = block_inventory.repair_expected / source_blocks;
+                let repair_flags = (0..source_blocks).flat_map(|source_block| {
+                    let source = source_block_symbols_count(&config, source_block as u8);
+                    (0..source + repair_per_source_block).map(move |index| index >= source)
+                }).chain(std::iter::repeat(true));
+                let block_path = self.block_symbols_path(dir_manager.as_ref(), symbols_dir_path, block_layout.block_id)?;
+                for (symbol_id, is_repair) in block_layout.symbols.iter().zip(repair_flags) {
+                    let symbol_path = block_path.join(symbol_id).to_string_lossy().to_string();
+                    if self.open_and_validate_file(&symbol_path).is_err() {
+                        continue;
+                    }
+                    if !is_repair {
+                        block_inventory.source_present += 1;
+                    } else {
+                        block_inventory.repair_present += 1;
+                    }
+                }
+            }
+            debug!("Block {} has {:?}", block_layout.block_id, block_inventory);
+            inventory.insert(block_layout.block_id, block_inventory);
+        }
+
+        Ok(inventory)
+    }
+
+    /// Cross-check a layout with the symbols on disk and report every problem found
+    ///
+    /// The layout itself is checked for missing or duplicate blocks, unreadable encoder
+    /// parameters, block sizes not matching them, blocks that don't follow each other
+    /// from offset 0, and blocks listing fewer symbols than their source symbols. Then
+    /// the symbols of each block are looked up the same way decode_symbols does, they
+    /// are not read nor verified.
+    ///
+    /// # Arguments
+    ///
+    /// * `symbols_dir` - Path to the directory containing the symbol files
+    /// * `layout_path` - Path to the layout JSON file
+    ///
+    /// # Returns
+    ///
+    /// * `Ok(Vec<LayoutIssue>)` with the problems found, empty if there are none;
+    ///   a layout with an `InsufficientSymbols` issue or any issue about the layout
+    ///   itself can't be decoded
+    /// * `Err(ProcessError)` if the layout can't be read or parsed
+    pub fn validate_layout(&self, symbols_dir: &str, layout_path: &str) -> Result<Vec<LayoutIssue>, ProcessError> {
+        use LayoutIssueKind::*;
+
+        let layout = self.read_layout_file(layout_path)?;
+        let mut issues = Vec::new();
+        if layout.is_empty_object() {
+            return Ok(issues);
+        }
+        if layout.blocks.is_empty() {
+            issues.push(LayoutIssue::new(None, NoBlocks, "The layout has no blocks".to_string()));
+            return Ok(issues);
+        }
+
+        let mut ids = BTreeMap::new();
+        for block in &layout.blocks {
+            *ids.entry(block.block_id).or_insert(0) += 1;
+        }
+        for (&block_id, &count) in &ids {
+            if count > 1 {
+                issues.push(LayoutIssue::new(Some(block_id), DuplicateBlock, format!("Block {} appears {} times in the layout", block_id, count)));
+            }
+        }
+        let last_id = *ids.keys().next_back().unwrap_or(&0);
+        for block_id in (0..last_id).filter(|id| !ids.contains_key(id)) {
+            issues.push(LayoutIssue::new(Some(block_id), MissingBlock, format!("Block {} is not in the layout", block_id)));
+        }
+
+        let mut blocks: Vec<&BlockLayout> = layout.blocks.iter().collect();
+        blocks.sort_by_key(|b| (b.original_offset, b.block_id));
+        let mut expected_offset = 0u64;
+        for block in &blocks {
+            let block_id = Some(block.block_id);
+            if block.original_offset != expected_offset {
+                let message = if block.original_offset > expected_offset {
+                    format!("Block {} starts at offset {}, leaving {} bytes uncovered after offset {}",
+                        block.block_id, block.original_offset, block.original_offset - expected_offset, expected_offset)
+                } else {
+                    format!("Block {} starts at offset {}, overlapping the previous block which ends at offset {}",
+                        block.block_id, block.original_offset, expected_offset)
+                };
+                issues.push(LayoutIssue::new(block_id, OffsetMismatch, message));
+            }
+            expected_offset = block.original_offset.saturating_add(block.size);
+
+            match block.encoder_config().filter(|config| oti_error(config).is_none()) {
+                None => issues.push(LayoutIssue::new(block_id, InvalidEncoderParameters,
+                    format!("Block {} has invalid encoder parameters", block.block_id))),
+                Some(config) => {
+                    if config.transfer_length() != block.size {
+                        issues.push(LayoutIssue::new(block_id, SizeMismatch, format!(
+                            "Block {} has a size of {} bytes but its encoder parameters give {} bytes",
+                            block.block_id, block.size, config.transfer_length()
+                        )));
+                    }
+                    let source_symbols = block.source_symbols_count();
+                    if (block.symbols.len() as u64) < source_symbols {
+                        issues.push(LayoutIssue::new(block_id, TooFewSymbols, format!(
+                            "Block {} lists {} symbols but has {} source symbols",
+                            block.block_id, block.symbols.len(), source_symbols
+                        )));
+                    }
+                }
+            }
+        }
+
+        let dir_manager = file_io::get_dir_manager();
+        let exists = dir_manager.dir_exists(symbols_dir)
+            .map_err(|e| ProcessError::IOError(io::Error::new(io::ErrorKind::Other, e)))?;
+        if !exists {
+            issues.push(LayoutIssue::new(None, MissingSymbolsDirectory, format!("Symbols directory does not exist: {}", symbols_dir)));
+            return Ok(issues);
+        }
+
+        let symbols_dir_path = Path::new(symbols_dir);
+        for block in &layout.blocks {
+            let block_path = self.block_symbols_path(dir_manager.as_ref(), symbols_dir_path, block.block_id)?;
+            let listed = block.symbols.len() as u64;
+            let present = self.count_present_symbols(&block_path, block, u64::MAX);
+            let required = block.min_symbols_required().min(listed);
+            if present < required {
+                issues.push(LayoutIssue::new(Some(block.block_id), InsufficientSymbols, format!(
+                    "Block {} has {} of its {} symbols on disk, {} are needed to decode it",
+                    block.block_id, present, listed, required
+                )));
+            } else if present < listed {
+                issues.push(LayoutIssue::new(Some(block.block_id), MissingSymbols, format!(
+                    "Block {} is missing {} of its {} symbols",
+                    block.block_id, listed - present, listed
+                )));
+            }
+        }
+
+        Ok(issues)
+    }
+
+    /// Read every symbol of a layout from its directory and report each one that
+    /// doesn't match the layout as soon as it is found
+    ///
+    /// Meant for background scrubbers of large symbols directories: the symbol files are
+    /// read one at a time, with the symbol format of the layout, and checked to hold a
+    /// whole symbol of their block under the id they are named after, so only one symbol
+    /// is held in memory besides the layout. Unlike `validate_layout`, which only looks
+    /// the files up, nothing is collected: `on_issue` receives the issues in the order
+    /// of the layout. A block with invalid encoder parameters is reported and skipped,
+    /// and files the layout doesn't list are not looked at.
+    ///
+    /// # Arguments
+    ///
+    /// * `symbols_dir` - Path to the directory containing the symbol files
+    /// * `layout_path` - Path to the layout JSON file
+    /// * `on_issue` - Called with each problem found
+    ///
+    /// # Returns
+    ///
+    /// * `Ok(())` once every symbol is checked, whether issues were found or not
+    /// * `Err(ProcessError)` if the layout can't be read or parsed, its symbols are
+    ///   encrypted with another key, or the scrub is cancelled
+    pub fn scrub_symbols(
+        &self,
+        symbols_dir: &str,
+        layout_path: &str,
+        mut on_issue: impl FnMut(LayoutIssue),
+    ) -> Result<(), ProcessError> {
+        use LayoutIssueKind::*;
+
+        let cancellation = self.start_cancellation();
+        let _guard = self.start_task()?;
+
+        let layout = self.read_layout_file(layout_path)?;
+        let symbol_format = self.layout_symbol_format(&layout)?;
+
+        let dir_manager = file_io::get_dir_manager();
+        let exists = dir_manager.dir_exists(symbols_dir)
+            .map_err(|e| ProcessError::IOError(io::Error::new(io::ErrorKind::Other, e)))?;
+        if !exists {
+            on_issue(LayoutIssue::new(None, MissingSymbolsDirectory, format!("Symbols directory does not exist: {}", symbols_dir)));
+            return Ok(());
+        }
+
+        let symbols_dir_path = Path::new(symbols_dir);
+        for block in &layout.blocks {
+            let Some(config) = block.encoder_config().filter(|config| oti_error(config).is_none()) else {
+                on_issue(LayoutIssue::new(Some(block.block_id), InvalidEncoderParameters,
+                    format!("Block {} has invalid encoder parameters", block.block_id)));
+                continue;
+            };
+
+            let block_path = self.block_symbols_path(dir_manager.as_ref(), symbols_dir_path, block.block_id)?;
+            for listed_id in &block.symbols {
+                self.check_cancelled(cancellation)?;
+
+                if !is_symbol_file_name(listed_id) {
+                    on_issue(LayoutIssue::for_symbol(block.block_id, listed_id, SymbolIdMismatch, format!(
+                        "Symbol {:?} of block {} is not a file name", listed_id, block.block_id
+                    )));
+                    continue;
+                }
+
+                let symbol_path = block_path.join(listed_id).to_string_lossy().to_string();
+                if self.open_and_validate_file(&symbol_path).is_err() {
+                    on_issue(LayoutIssue::for_symbol(block.block_id, listed_id, MissingSymbol, format!(
+                        "Symbol {} of block {} is missing", listed_id, block.block_id
+                    )));
+                    continue;
+                }
+
+                let Some(symbol) = self.read_symbol_file(&block_path, listed_id, &symbol_format) else {
+                    on_issue(LayoutIssue::for_symbol(block.block_id, listed_id, UnreadableSymbol, format!(
+                        "Symbol {} of block {} can't be read", listed_id, block.block_id
+                    )));
+                    continue;
+                };
+
+                if !symbol_fits_block(&config, &symbol) {
+                    on_issue(LayoutIssue::for_symbol(block.block_id, listed_id, SymbolSizeMismatch, format!(
+                        "Symbol {} of block {} has {} bytes, a symbol of the block has {}",
+                        listed_id, block.block_id, symbol.len(), config.symbol_size() as usize + 4
+                    )));
+                } else {
+                    let content_id = symbol_id(&symbol);
+                    if content_id != *listed_id {
+                        on_issue(LayoutIssue::for_symbol(block.block_id, listed_id, SymbolIdMismatch, format!(
+                            "Symbol {} of block {} holds the symbol {}", listed_id, block.block_id, content_id
+                        )));
+                    }
+                }
+            }
+        }
+
+        Ok(())
+    }
+
+    /// Rebuild the layout of an object from its symbols directory, when the layout file
+    /// was lost
+    ///
+    /// The blocks are laid out as `encode_file` splits an object of `object_size` bytes
+    /// with `block_size` and the configuration of the processor, its automatic symbol size
+    /// included, so both have to be the ones of the encode. Every file of the block
+    /// directories (or of `symbols_dir` for a single block written without them) is read
+    /// with the symbol codec and key of the processor, and kept if its name is the id of
+    /// its content and it fits the block; the symbols are listed by source block and ESI,
+    /// as the encode lists them.
+    ///
+    /// The symbol files don't hold the size of the object, the padding of the last
+    /// symbol of a block being indistinguishable from data, hence `object_size`. A wrong
+    /// size or block size gives a layout that decodes to wrong data. The hashes of the
+    /// blocks and of the object are left empty, so decodes can't verify the data.
+    ///
+    /// # Arguments
+    ///
+    /// * `symbols_dir` - Path to the directory containing the symbol files
+    /// * `object_size` - Size of the original data in bytes
+    /// * `block_size` - Block size of the encode (0 = auto)
+    ///
+    /// # Returns
+    ///
+    /// * `Ok(RaptorQLayout)` with the blocks and their symbols
+    /// * `Err(ProcessError::InvalidParameter)` if the object is empty, or the directory
+    ///   has more blocks than the object or blocks without a directory
+    /// * `Err(ProcessError::InvalidPath)` if the directory does not exist
+    /// * `Err(ProcessError::DecodingFailed)` if a block has no usable symbol
+    pub fn reconstruct_layout(&self, symbols_dir: &str, object_size: u64, block_size: usize) -> Result<RaptorQLayout, ProcessError> {
+        let result = (|| {
+            if object_size == 0 {
+                return Err(ProcessError::InvalidParameter("object size must be greater than 0".to_string()));
+            }
+            let Ok(object_size) = usize::try_from(object_size) else {
+                return Err(ProcessError::InvalidParameter(format!("object of {}B is too large for this platform", object_size)));
+            };
+            let block_size = self.resolve_block_size(symbols_dir, object_size, block_size, false, self.config.symbol_size)?;
+            let block_count = object_size.div_ceil(block_size);
+
+            let dir_manager = file_io::get_dir_manager();
+            let dir_exists = |path: &Path| dir_manager.dir_exists(&path.to_string_lossy())
+                .map_err(|e| ProcessError::IOError(io::Error::new(io::ErrorKind::Other, e)));
+            let symbols_dir_path = Path::new(symbols_dir);
+            if !dir_exists(symbols_dir_path)? {
+                return Err(ProcessError::InvalidPath(format!("Symbols directory does not exist: {}", symbols_dir)));
+            }
+            if dir_exists(&symbols_dir_path.join(block_dir_name(block_count)))? {
+                return Err(ProcessError::InvalidParameter(format!(
+                    "Symbols directory has a block {} but an object of {}B has {} blocks of {}B",
+                    block_count, object_size, block_count, block_size
+                )));
+            }
+
+            let symbol_format = self.symbol_format();
+            let mut blocks = Vec::with_capacity(block_count);
+            for block_id in 0..block_count {
+                let offset = block_id * block_size;
+                let size = block_size.min(object_size - offset) as u64;
+                let config = self.block_encoder_config(size, self.config.symbol_size);
+
+                let mut block_path = symbols_dir_path.join(block_dir_name(block_id));
+                if !dir_exists(&block_path)? {
+                    if block_count > 1 {
+                        return Err(ProcessError::InvalidParameter(format!(
+                            "Block {} has no directory, the symbols of several blocks can't be told apart without them",
+                            block_id
+                        )));
+                    }
+                    block_path = symbols_dir_path.to_path_buf();
+                }
+                let file_names = dir_manager.list_files(&block_path.to_string_lossy())
+                    .map_err(|e| ProcessError::IOError(io::Error::new(io::ErrorKind::Other, e)))?;
+
+                // Symbols by source block and ESI, other files are skipped
+                let mut symbols = BTreeMap::new();
+                for file_name in &file_names {
+                    if !is_symbol_file_name(file_name) {
+                        continue;
+                    }
+                    let Some(symbol) = self.read_symbol_file(&block_path, file_name, &symbol_format) else {
+                        continue;
+                    };
+                    if !symbol_fits_block(&config, &symbol) || self.calculate_symbol_id(&symbol) != *file_name {
+                        debug!("Skipping the file {} of block {}, not a symbol of the block", file_name, block_id);
+                        continue;
+                    }
+                    let esi = symbol_esi(&symbol).expect("The symbol fits the block");
+                    symbols.insert((symbol[0], esi), file_name.clone());
+                }
+                if symbols.is_empty() {
+                    return Err(ProcessError::DecodingFailed(format!(
+                        "None of the {} files of block {} is a symbol of a block of {}B with symbols of {}B",
+                        file_names.len(), block_id, size, config.symbol_size()
+                    )));
+                }
+
+                blocks.push(BlockLayout {
+                    block_id,
+                    encoder_parameters: config.serialize().to_vec(),
+                    original_offset: offset as u64,
+                    size,
+                    symbols: symbols.into_values().collect(),
+                    hash: String::new(),
+                });
+            }
+
+            debug!("Reconstructed the layout of {} blocks from {:?}", blocks.len(), symbols_dir);
+            Ok(RaptorQLayout {
+                layout_version: LAYOUT_VERSION,
+                blocks,
+                object_sha256: String::new(),
+                symbol_codec: symbol_format.codec,
+                symbol_key_id: symbol_format.cipher.as_ref().map(|c| c.key_id().to_string()).unwrap_or_default(),
+                symbol_crc: symbol_format.crc,
+                metadata: BTreeMap::new(),
+            })
+        })();
+
+        if let Err(e) = &result {
+            self.set_last_error(e.to_string());
+        }
+        result
+    }
+
+    // Count the symbols of the block layout found in its directory, stopping at `limit`
+    fn count_present_symbols(&self, block_path: &Path, block_layout: &BlockLayout, limit: u64) -> u64 {
+        let mut present = 0;
+        for symbol_id in &block_layout.symbols {
+            if present >= limit {
+                break;
+            }
+            let symbol_path = block_path.join(symbol_id).to_string_lossy().to_string();
+            if self.open_and_validate_file(&symbol_path).is_ok() {
+                present += 1;
+            }
+        }
+        present
+    }
+
+    /// Decode RaptorQ symbols held in memory to recreate the original data
+    ///
+    /// Symbols are matched to the blocks of the layout by their id (hash of the symbol),
+    /// so they can be given in any order and symbols unknown to the layout are ignored.
+    ///
+    /// # Arguments
+    ///
+    /// * `symbols` - Serialized symbols, as produced by the encoder
+    /// * `layout_content` - Content of the layout JSON
+    ///
+    /// # Returns
+    ///
+    /// * `Ok(Vec<u8>)` with the decoded data
+    /// * `Err(ProcessError)` on error (e.g., invalid layout, not enough symbols)
+    pub fn decode_bytes<S: AsRef<[u8]>>(
+        &self,
+        symbols: &[S],
+        layout_content: &[u8],
+    ) -> Result<Vec<u8>, ProcessError> {
+        let cancellation = self.start_cancellation();
+
+        // Check if we can take another task
+        let _guard = self.start_task()?;
+
+        self.last_shortfalls.lock().clear();
+
+        let layout = self.parse_layout(layout_content.to_vec())?;
+        if layout.is_empty_object() {
+            return Ok(Vec::new());
+        }
+        if layout.blocks.is_empty() {
+            let err = "Layout has the empty blocks array".to_string();
+            self.set_last_error(err.clone());
+            return Err(ProcessError::DecodingFailed(err));
+        }
+
+        let symbols_by_id: HashMap<String, &[u8]> = symbols
+            .iter()
+            .map(|symbol| (self.calculate_symbol_id(symbol.as_ref()), symbol.as_ref()))
+            .collect();
+
+        // The layout comes from the caller, so the output it declares is bounded before
+        // it is allocated
+        let max_memory = self.config.max_memory_mb as u64 * 1024 * 1024;
+        let mut total_size = 0u64;
+        for block in &layout.blocks {
+            match block.original_offset.checked_add(block.size) {
+                Some(end) if end <= max_memory => total_size = total_size.max(end),
+                _ => {
+                    let err = format!(
+                        "Block {} at offset {} of {} bytes exceeds the memory limit of {} MB",
+                        block.block_id, block.original_offset, block.size, self.config.max_memory_mb
+                    );
+                    self.set_last_error(err.clone());
+                    return Err(ProcessError::InvalidParameter(err));
+                },
+            }
+        }
+        let memory_required = self.estimate_memory_requirements(total_size as usize);
+        if !self.is_memory_available(memory_required) {
+            let err = ProcessError::MemoryLimitExceeded {
+                required: memory_required,
+                available: self.config.max_memory_mb as usize,
+            };
+            self.set_last_error(err.to_string());
+            return Err(err);
+        }
+        let mut output = vec![0u8; total_size as usize];
+
+        debug!("Decoding {} symbols from memory with {} blocks", symbols.len(), layout.blocks.len());
+
+        let mut sorted_blocks = layout.blocks.clone();
+        sorted_blocks.sort_by(|a, b| a.block_id.cmp(&b.block_id));
+
+        for block_layout in &sorted_blocks {
+            self.check_cancelled(cancellation)?;
+
+            let block_data = match self.decode_block(block_layout, |symbol_id| {
+                symbols_by_id.get(symbol_id).map(|symbol| symbol.to_vec())
+            })? {
+                BlockDecodeOutcome::Decoded(data) => data,
+                BlockDecodeOutcome::Skipped => continue,
+                // The object can't be decoded without the block, the next ones are not tried
+                BlockDecodeOutcome::Shortfall(shortfall) => {
+                    let err = ProcessError::InsufficientSymbols(vec![shortfall.clone()]);
+                    self.set_last_error(err.to_string());
+                    *self.last_shortfalls.lock() = vec![shortfall];
+                    return Err(err);
+                },
+            };
+
+            let offset = block_layout.original_offset as usize;
+            match output.get_mut(offset..offset + block_data.len()) {
+                Some(range) => range.copy_from_slice(&block_data),
+                None => {
+                    let err = format!(
+                        "Block {} decoded to {} bytes past the end of the object",
+                        block_layout.block_id, block_data.len()
+                    );
+                    self.set_last_error(err.clone());
+                    return Err(ProcessError::DecodingFailed(err));
+                },
+            }
+        }
+
+        Ok(output)
+    }
+
+    /// RFC 6330 Object Transmission Information of every block of a layout
+    ///
+    /// # Arguments
+    ///
+    /// * `layout_path` - Path to the layout JSON file
+    ///
+    /// # Returns
+    ///
+    /// * `Ok(Vec<BlockOti>)` with the OTI of each block, in the order of the layout
+    /// * `Err(ProcessError)` on error (e.g., invalid layout)
+    pub fn get_oti(&self, layout_path: &str) -> Result<Vec<BlockOti>, ProcessError> {
+        let layout = self.read_layout_file(layout_path)?;
+        let result = layout.to_oti();
+        if let Err(e) = &result {
+            self.set_last_error(e.to_string());
+        }
+        result
+    }
+
+    /// Decode a RaptorQ object from its RFC 6330 OTI and encoding packets
+    ///
+    /// This doesn't need a layout, so it decodes objects from other RFC 6330
+    /// encoders as well as single blocks of this library (see `get_oti`).
+    /// There are no symbol ids nor block hash to verify the data against.
+    ///
+    /// # Arguments
+    ///
+    /// * `oti` - The 12 bytes Common and Scheme-Specific FEC OTI of the object
+    /// * `packets` - Encoding packets, each a 4 bytes FEC payload ID followed by the symbol
+    ///
+    /// # Returns
+    ///
+    /// * `Ok(Vec<u8>)` with the decoded object
+    /// * `Err(ProcessError::InvalidParameter)` if the OTI is malformed or has a field
+    ///   raptorq can't decode with, e.g. zero source blocks
+    /// * `Err(ProcessError)` on other errors (e.g., not enough packets)
+    pub fn decode_with_oti<S: AsRef<[u8]>>(&self, oti: &[u8], packets: &[S]) -> Result<Vec<u8>, ProcessError> {
+        // Check if we can take another task
+        let _guard = self.start_task()?;
+
+        self.last_shortfalls.lock().clear();
+
+        let config = match <[u8; 12]>::try_from(oti) {
+            Ok(params) => ObjectTransmissionInformation::deserialize(&params),
+            Err(_) => {
+                let err = format!("OTI must be 12 bytes, got {}", oti.len());
+                self.set_last_error(err.clone());
+                return Err(ProcessError::InvalidParameter(err));
+            }
+        };
+        if let Some(err) = oti_error(&config) {
+            self.set_last_error(err.clone());
+            return Err(ProcessError::InvalidParameter(err));
+        }
+
+        let memory_required = self.estimate_memory_requirements(config.transfer_length() as usize);
+        if !self.is_memory_available(memory_required) {
+            let err = ProcessError::MemoryLimitExceeded {
+                required: memory_required,
+                available: self.config.max_memory_mb as usize,
+            };
+            self.set_last_error(err.to_string());
+            return Err(err);
+        }
+
+        let mut decoder = Decoder::new(config);
+        let mut present = 0u64;
+        for packet in packets {
+            let packet = packet.as_ref();
+            if packet.len() <= 4 {
+                continue;
+            }
+            match self.safe_decode(&mut decoder, EncodingPacket::deserialize(packet)) {
+                Ok(Some(data)) => return Ok(data),
+                Ok(None) => present += 1,
+                Err(_) => {},
+            }
+        }
+
+        let shortfalls = vec![BlockShortfall {
+            block_id: 0,
+            present,
+            required: source_symbols_count(&config).max(present + 1),
+        }];
+        let err = ProcessError::InsufficientSymbols(shortfalls.clone());
+        self.set_last_error(err.to_string());
+        *self.last_shortfalls.lock() = shortfalls;
+        Err(err)
+    }
+
+    /// Minimum number of symbols to fetch for a block of a layout to decode
+    ///
+    /// This is the number of source symbols of the block plus a small overhead
+    /// (DECODE_SYMBOL_OVERHEAD), with which the decode succeeds with high probability.
+    ///
+    /// # Arguments
+    ///
+    /// * `layout_path` - Path to the layout JSON file
+    /// * `block_id` - Identifier of the block in the layout
+    ///
+    /// # Returns
+    ///
+    /// * `Ok(u64)` with the number of symbols
+    /// * `Err(ProcessError)` on error (e.g., invalid layout, unknown block)
+    pub fn min_symbols_for_block(&self, layout_path: &str, block_id: usize) -> Result<u64, ProcessError> {
+        let layout = self.read_layout_file(layout_path)?;
+        match layout.blocks.iter().find(|b| b.block_id == block_id) {
+            Some(block_layout) => self.block_min_symbols(block_layout),
+            None => {
+                let err = format!("Block {} not found in the layout", block_id);
+                self.set_last_error(err.clone());
+                Err(ProcessError::DecodingFailed(err))
+            }
+        }
+    }
+
+    /// Minimum number of symbols to fetch for each block of a layout to decode,
+    /// as computed by min_symbols_for_block, keyed by block id
+    pub fn min_symbols_per_block(&self, layout_path: &str) -> Result<BTreeMap<usize, u64>, ProcessError> {
+        let layout = self.read_layout_file(layout_path)?;
+        layout.blocks
+            .iter()
+            .map(|block_layout| Ok((block_layout.block_id, self.block_min_symbols(block_layout)?)))
+            .collect()
+    }
+
+    fn block_min_symbols(&self, block_layout: &BlockLayout) -> Result<u64, ProcessError> {
+        match block_layout.min_symbols_required() {
+            0 => {
+                let err = format!("Invalid encoder parameters in block {}", block_layout.block_id);
+                self.set_last_error(err.clone());
+                Err(ProcessError::DecodingFailed(err))
+            }
+            count => Ok(count),
+        }
+    }
+
+    /// Decode a single block, pulling its symbols by id from `read_symbol`
+    /// until the decoder succeeds, and validate the result against the block hash
+    fn decode_block<F>(&self, block_layout: &BlockLayout, mut read_symbol: F) -> Result<BlockDecodeOutcome, ProcessError>
+    where
+        F: FnMut(&str) -> Option<Vec<u8>>,
+    {
+        // Create the decoder with the parameters specific to this block
+        let config = self.block_decoder_config(block_layout)?;
+        let mut decoder = Decoder::new(config);
+
+        // Skip blocks that have no symbols in the layout
+        if block_layout.symbols.is_empty() {
+            debug!("No symbols in the layout for block {}, skipping", block_layout.block_id);
+            return Ok(BlockDecodeOutcome::Skipped);
+        }
+
+        // Process symbols from the layout file
+        let mut present = 0u64;
+        let mut block_data = None;
+        for symbol_id in &block_layout.symbols {
+            if !is_symbol_file_name(symbol_id) {
+                debug!("Skipping the symbol {:?} of block {}, its id is not a file name", symbol_id, block_layout.block_id);
+                continue;
+            }
+            let symbol_data = match read_symbol(symbol_id) {
+                Some(data) => data,
+                None => continue,
+            };
+
+            // Malformed symbols, e.g. truncated files, are skipped like missing ones
+            if !symbol_fits_block(&config, &symbol_data) {
+                debug!("Skipping the symbol {} of block {}, its {} bytes are not a symbol of the block",
+                       symbol_id, block_layout.block_id, symbol_data.len());
+                continue;
+            }
+            let packet = EncodingPacket::deserialize(&symbol_data);
+            match self.safe_decode(&mut decoder, packet) {
+                Ok(Some(result)) => {
+                    block_data = Some(result);
+                    break; // Successfully decoded
+                },
+                Ok(None) => present += 1,
+                Err(_) => {},
+            }
+        }
+
+        // Not enough symbols for this block
+        let block_data = match block_data {
+            Some(data) => data,
+            None => {
+                debug!("Block {} could not be decoded with {} symbols", block_layout.block_id, present);
+                return Ok(BlockDecodeOutcome::Shortfall(BlockShortfall {
+                    block_id: block_layout.block_id,
+                    present,
+                    // More symbols than source ones are needed if decoding failed with K or more
+                    required: source_symbols_count(&config).max(present + 1),
+                }));
+            }
+        };
+
+        self.verify_block_hash(block_layout, &block_data)?;
+
+        Ok(BlockDecodeOutcome::Decoded(block_data))
+    }
+
+    // Encoder parameters of a block, checked against the size of the block
+    fn block_decoder_config(&self, block_layout: &BlockLayout) -> Result<ObjectTransmissionInformation, ProcessError> {
+        let config = match block_layout.encoder_config() {
+            Some(config) => config,
+            None => {
+                let err = format!("Invalid encoder parameters in block {}", block_layout.block_id);
+                self.set_last_error(err.clone());
+                return Err(ProcessError::DecodingFailed(err));
+            }
+        };
+
+        if let Some(reason) = oti_error(&config) {
+            let err = format!("Invalid encoder parameters in block {}: {}", block_layout.block_id, reason);
+            self.set_last_error(err.clone());
+            return Err(ProcessError::DecodingFailed(err));
+        }
+        if config.transfer_length() != block_layout.size {
+            let err = format!("Encoder parameters do not match the size of block {}", block_layout.block_id);
+            self.set_last_error(err.clone());
+            return Err(ProcessError::DecodingFailed(err));
+        }
+        Ok(config)
+    }
+
+    // Validate the decoded data against the block hash, if the layout has one
+    fn verify_block_hash(&self, block_layout: &BlockLayout, block_data: &[u8]) -> Result<(), ProcessError> {
+        if !block_layout.hash.is_empty() {
+            let computed_hash = get_hash_as_b58(block_data);
+            if computed_hash != block_layout.hash {
+                let err = format!("Hash mismatch for block {}: expected {}, got {}",
+                                 block_layout.block_id, block_layout.hash, computed_hash);
+                self.set_last_error(err.clone());
+                return Err(ProcessError::DecodingFailed(err));
+            }
+        }
+        Ok(())
+    }
+
+    // Read a whole symbol file and decrypt and decompress it, None if it is missing,
+    // unreadable or fails to decrypt or decompress
+    fn read_symbol_file(&self, block_path: &Path, symbol_id: &str, symbol_format: &SymbolFormat) -> Option<Vec<u8>> {
+        let symbol_path = block_path.join(symbol_id);
+        let symbol_path_str = symbol_path.to_string_lossy().to_string();
+
+        let (mut symbol_reader, symbol_size) = self.open_and_validate_file(&symbol_path_str).ok()?;
+
+        // Read symbol data
+        let mut symbol_data = vec![0u8; symbol_size];
+        match symbol_reader.read_chunk(0, &mut symbol_data) {
+            Ok(bytes_read) if bytes_read == symbol_size => symbol_format.decode(&symbol_data)
+                .map_err(|e| debug!("Failed to decode the symbol file {}: {}", symbol_id, e))
+                .ok(),
+            Ok(bytes_read) => {
+                debug!("Partial read of the symbol file {}: {} of {} bytes",
+                       symbol_id, bytes_read, symbol_size);
+                None
+            },
+            Err(e) => {
+                debug!("Failed to read the symbol file {}: {}", symbol_id, e);
+                None
+            }
+        }
+    }
+
+    // Read and parse the layout file
+    fn read_layout_file(&self, layout_path: &str) -> Result<RaptorQLayout, ProcessError> {
+        let (mut file_reader, file_size) = match self.open_and_validate_file(layout_path) {
+            Ok(result) => result,
+            Err(e) => {
+                self.set_last_error(e.to_string());
+                return Err(e);
+            }
+        };
+
+        let mut layout_content_bytes = vec![0; file_size];
+        match file_reader.read_chunk(0, &mut layout_content_bytes) {
+            Ok(_) => {},
+            Err(e) => {
+                let err = format!("Failed to read the layout file: {}", e);
+                self.set_last_error(err.clone());
+                return Err(ProcessError::DecodingFailed(err));
+            }
+        }
+        
+        self.parse_layout(layout_content_bytes)
+    }
+
+    // Parse the JSON layout content
+    fn parse_layout(&self, layout_content_bytes: Vec<u8>) -> Result<RaptorQLayout, ProcessError> {
+        RaptorQLayout::parse(&layout_content_bytes).map_err(|e| {
+            self.set_last_error(e.to_string());
+            e
+        })
+    }
+
+    // Helper function to safely attempt the decoding a packet without panicking.
+    // Returns Err if the decoder rejected the packet (e.g. corrupted symbol).
+    fn safe_decode(&self, decoder: &mut Decoder, packet: EncodingPacket) -> Result<Option<Vec<u8>>, ()> {
+        // Use catch_unwind to prevent panics from propagating
+        std::panic::catch_unwind(std::panic::AssertUnwindSafe(|| {
+            decoder.decode(packet)
+        })).map_err(|_| {
+            // Log corrupted symbol
+            debug!("Skipping corrupted symbol: panic during decoding");
+        })
+    }
+
+    /// Read all symbol files from a directory
+    #[allow(dead_code)]
+    fn read_symbol_files(&self, dir: &Path) -> Result<Vec<Vec<u8>>, ProcessError> {
+        let mut symbol_files = Vec::new();
+        let _dir_str = dir.to_string_lossy().to_string();
+
+        // Attempt to read symbol files with pattern "symbol_N.bin" which is used in tests
+        for i in 0..100 { // Arbitrary limit to avoid infinite loop
+            let file_name = format!("symbol_{}.bin", i);
+            let path = dir.join(&file_name);
+            let path_str = path.to_string_lossy().to_string();
+
+            let (mut symbol_reader, symbol_size) = match self.open_and_validate_file(&path_str) {
+                Ok(result) => result,
+                Err(_) => {
+                    continue;
+                }
+            };
+
+            let mut symbol_data = vec![0u8; symbol_size];
+            match symbol_reader.read_chunk(0, &mut symbol_data) {
+                Ok(bytes_read) if bytes_read == symbol_size => {
+                    // Successfully read the entire symbol
+                    symbol_files.push(symbol_data);
+                },
+                Ok(_) => {
+                    continue;
+                },
+                Err(_) => {
+                    continue;
+                }
+            };
+        }
+
+        if symbol_files.is_empty() {
+            let err = format!("No symbol files found in {:?}", dir);
+            self.set_last_error(err.clone());
+            return Err(ProcessError::DecodingFailed(err));
+        }
+
+        Ok(symbol_files)
+    }
+
+    /// Read specific symbol files by ID from a directory
+    #[allow(dead_code)]
+    fn read_specific_symbol_files(&self, dir: &Path, symbol_ids: &[String]) -> Result<Vec<Vec<u8>>, ProcessError> {
+        let mut symbol_files = Vec::new();
+        let _dir_str = dir.to_string_lossy().to_string(); // Keep but prefix with underscore to avoid warning
+
+        for symbol_id in symbol_ids {
+            let symbol_path = dir.join(symbol_id);
+            let symbol_path_str = symbol_path.to_string_lossy().to_string();
+
+            // Try to open the file using FileReader
+            match file_io::open_file_reader(&symbol_path_str) {
+                Ok(mut reader) => {
+                    // Get file size
+                    let size = match reader.file_size() {
+                        Ok(size) => size as usize,
+                        Err(e) => {
+                            debug!("Failed to get file size for symbol {:?}: {}", symbol_path, e);
+                            continue;
+                        }
+                    };
+
+                    // Read the file content
+                    let mut symbol_data = vec![0u8; size];
+                    match reader.read_chunk(0, &mut symbol_data) {
+                        Ok(bytes_read) if bytes_read == size => {
+                            // Successfully read the file
+                            symbol_files.push(symbol_data);
+                        },
+                        Ok(bytes_read) => {
+                            debug!("Partial read of symbol file {:?}: {} of {} bytes",
+                                  symbol_path, bytes_read, size);
+                            continue;
+                        },
+                        Err(e) => {
+                            debug!("Failed to read symbol file {:?}: {}", symbol_path, e);
+                            continue;
+                        }
+                    }
+                },
+                Err(e) => {
+                    debug!("Failed to open symbol file {:?}: {}", symbol_path, e);
+                    continue;
+                }
+            }
+        }
+
+        if symbol_files.is_empty() {
+            let err = format!("No valid symbol files found from the provided IDs in {:?}", dir);
+            self.set_last_error(err.clone());
+            return Err(ProcessError::DecodingFailed(err));
+        }
+
+        Ok(symbol_files)
+    }
+
+    // Helper methods
+
+    fn check_cancelled(&self, cancellation: Cancellation) -> Result<(), ProcessError> {
+        if self.cancel_epoch.load(Ordering::SeqCst) != cancellation.epoch {
+            let err = ProcessError::Cancelled;
+            self.set_last_error(err.to_string());
+            return Err(err);
+        }
+        if let Some((started, timeout)) = cancellation.deadline {
+            if started.elapsed() > timeout {
+                let err = ProcessError::TimedOut(timeout);
+                self.set_last_error(err.to_string());
+                return Err(err);
+            }
+        }
+        Ok(())
+    }
+
+    // Capture what stops the operation starting now: a later call to cancel, or its timeout
+    fn start_cancellation(&self) -> Cancellation {
+        Cancellation {
+            epoch: self.cancel_epoch.load(Ordering::SeqCst),
+            deadline: self.timeout.lock().map(|timeout| (Instant::now(), timeout)),
+        }
+    }
+
+    // Take a task slot, held until the guard is dropped
+    fn start_task(&self) -> Result<TaskGuard<'_>, ProcessError> {
+        TaskGuard::try_new(&self.active_tasks, self.config.concurrency_limit as usize)
+            .ok_or(ProcessError::ConcurrencyLimitReached)
+    }
+
+    // Take up to `count` free task slots for the workers of a parallel operation, none
+    // in the browser where threads are not available
+    fn start_workers(&self, count: usize) -> Vec<TaskGuard<'_>> {
+        let mut worker_guards = Vec::new();
+        if !cfg!(target_arch = "wasm32") {
+            while worker_guards.len() < count {
+                let Ok(guard) = self.start_task() else { break };
+                worker_guards.push(guard);
+            }
+        }
+        worker_guards
+    }
+
+    fn open_and_validate_file(&self, path: &str) -> Result<(Box<dyn FileReader>, usize), ProcessError> {
+        check_path(path)?;
+        let file_reader = match file_io::open_file_reader(path) {
+            Ok(reader) => reader,
+            Err(e) => {
+                let err = format!("Failed to open file {:?}: {}", path, e);
+                return Err(ProcessError::FileNotFound(err));
+            }
+        };
+
+        let file_size = match file_reader.file_size() {
+            Ok(size) => size,
+            Err(e) => {
+                let err = format!("Failed to get file size for {:?}: {}", path, e);
+                return Err(ProcessError::IOError(io::Error::new(io::ErrorKind::Other, err)));
+            }
+        };
+
+        if file_size == 0 {
+            let err = format!("File is empty: {:?}", path);
+            return Err(ProcessError::EncodingFailed(err));
+        }
+
+        // Files are addressed in memory, which is 4 GB on 32-bit platforms
+        let Ok(file_size) = usize::try_from(file_size) else {
+            let err = format!("File {:?} of {}B is too large for this platform", path, file_size);
+            return Err(ProcessError::InvalidParameter(err));
+        };
+
+        Ok((file_reader, file_size))
+    }
+
+    fn calculate_repair_symbols(&self, data_len: u64, symbol_size: u16) -> u64 {
+        if let Some(repair_symbols) = *self.repair_symbols_per_block.lock() {
+            return repair_symbols as u64;
+        }
+        repair_symbols_count(data_len, symbol_size, self.config.redundancy_factor)
+    }
+
+    fn calculate_symbol_id(&self, symbol: &[u8]) -> String {
+        get_hash_as_b58(symbol)
+    }
+
+    fn estimate_memory_requirements(&self, data_size: usize) -> usize {
+        let mb = 1024 * 1024;
+        let data_mb = (data_size as f64 / mb as f64).ceil() as usize;
+        (data_mb as f64 * RAPTORQ_MEMORY_OVERHEAD_FACTOR).ceil() as usize
+    }
+
+    fn is_memory_available(&self, required_mb: usize) -> bool {
+        required_mb <= self.config.max_memory_mb as usize
+    }
+}
+
+// Read a symbol entry of a tar archive as `(block_id, symbol)`,
+// None for the entries that are not symbols
+fn read_tar_symbol<R: io::Read>(entry: io::Result<tar::Entry<'_, R>>) -> io::Result<Option<(usize, Vec<u8>)>> {
+    let mut entry = entry?;
+    if !entry.header().entry_type().is_file() {
+        return Ok(None);
+    }
+    let block_id = match parse_symbol_path(&entry.path()?.to_string_lossy()) {
+        Ok((block_id, _)) => block_id,
+        Err(_) => return Ok(None),
+    };
+    Ok(Some((block_id, read_tar_entry(&mut entry, MAX_SYMBOL_FILE_LEN as u64)?)))
+}
+
+// Read the whole content of a tar entry, failing if the archive ends before it or the
+// size of its header is more than `max_len`
+fn read_tar_entry<R: io::Read>(entry: &mut tar::Entry<'_, R>, max_len: u64) -> io::Result<Vec<u8>> {
+    let size = entry.size();
+    if size > max_len {
+        return Err(io::Error::new(io::ErrorKind::InvalidData, format!(
+            "entry {} of {} bytes is larger than the largest valid one of {} bytes",
+            entry.path().map(|p| p.display().to_string()).unwrap_or_default(), size, max_len
+        )));
+    }
+    // The size is untrusted until the content is read, so it doesn't size the buffer
+    let mut content = Vec::with_capacity(size.min(MAX_SYMBOL_FILE_LEN as u64) as usize);
+    entry.take(size).read_to_end(&mut content)?;
+    if content.len() as u64 != size {
+        return Err(io::Error::new(io::ErrorKind::UnexpectedEof, format!(
+            "archive is truncated, entry {} has {} of {} bytes",
+            entry.path().map(|p| p.display().to_string()).unwrap_or_default(), content.len(), size
+        )));
+    }
+    Ok(content)
+}
+
+// Blocks encoded so far, with the running totals of the result
+struct EncodedBlocks {
+    blocks: Vec<BlockInfo>,
+    block_layouts: Vec<BlockLayout>,
+    total_symbols_count: u64,
+    total_repair_symbols: u64,
+    // Hash of the data of the blocks so far, which are encoded in the order of their offsets
+    object_hasher: ContentHasher,
+    // How the symbol files are written, the same for all blocks of the object
+    symbol_format: SymbolFormat,
+    // Symbol size the blocks are encoded with, the configured one unless overridden
+    symbol_size: u16,
+    // Metadata of the layout, the one of the processor unless set, e.g. by an append
+    metadata: Option<BTreeMap<String, String>>,
+}
+
+impl EncodedBlocks {
+    fn with_capacity(block_count: usize, symbol_format: SymbolFormat, content_hash: ContentHash, symbol_size: u16) -> Self {
+        Self {
+            blocks: Vec::with_capacity(block_count),
+            block_layouts: Vec::with_capacity(block_count),
+            total_symbols_count: 0,
+            total_repair_symbols: 0,
+            object_hasher: content_hash.hasher(),
+            symbol_format,
+            symbol_size,
+            metadata: None,
+        }
+    }
+
+    // Record the next block, the object hash is updated by the caller
+    fn push(&mut self, block_info: BlockInfo, block_layout: BlockLayout) {
+        self.total_symbols_count += block_info.symbols_count;
+        self.total_repair_symbols += block_info.symbols_count - block_info.source_symbols_count;
+        self.blocks.push(block_info);
+        self.block_layouts.push(block_layout);
+    }
+}
+
+// How symbol files are written: compressed, then encrypted, then behind a CRC header
+#[derive(Clone)]
+struct SymbolFormat {
+    codec: SymbolCodec,
+    cipher: Option<Arc<SymbolCipher>>,
+    crc: bool,
+    // Largest symbol with its payload ID the files may hold, which bounds decompression
+    max_symbol_len: usize,
+}
+
+impl Default for SymbolFormat {
+    fn default() -> Self {
+        Self { codec: SymbolCodec::None, cipher: None, crc: false, max_symbol_len: MAX_SYMBOL_LEN }
+    }
+}
+
+impl SymbolFormat {
+    // Content of the file of a symbol
+    fn encode(&self, symbol: &[u8]) -> io::Result<Vec<u8>> {
+        let content = self.codec.compress(symbol)?;
+        let content = match &self.cipher {
+            Some(cipher) => cipher.encrypt(&content)?,
+            None => content,
+        };
+        Ok(if self.crc { SymbolHeader::write(&content) } else { content })
+    }
+
+    // Symbol held by the content of a file
+    fn decode(&self, content: &[u8]) -> io::Result<Vec<u8>> {
+        let content = if self.crc { SymbolHeader::read(content)? } else { content };
+        match &self.cipher {
+            Some(cipher) => self.codec.decompress(&cipher.decrypt(content)?, self.max_symbol_len),
+            None => self.codec.decompress(content, self.max_symbol_len),
+        }
+    }
+}
+
+// What stops an operation: a call to cancel after it started, or its timeout
+#[derive(Clone, Copy)]
+struct Cancellation {
+    epoch: usize,
+    // When the operation started, with its timeout
+    deadline: Option<(Instant, Duration)>,
+}
+
+// Result of decoding a single block
+enum BlockDecodeOutcome {
+    Decoded(Vec<u8>),
+    Skipped,
+    Shortfall(BlockShortfall),
+}
+
+// Decoding state of a block fed by decode_from_source
+struct SourceBlockState<'a> {
+    decoder: Decoder,
+    // Symbols of the block not given yet
+    pending_ids: HashSet<&'a str>,
+    present: u64,
+    required: u64,
+    // Decoded data waiting for the blocks before it to be written
+    data: Option<WaitingBlock>,
+    decoded: bool,
+}
+
+// Decoded block waiting to be written, in memory or in the scratch file of the decode
+enum WaitingBlock {
+    Memory(Vec<u8>),
+    Scratch { offset: u64, len: usize },
+}
+
+// Scratch file of an operation in the temp directory, removed when dropped
+struct ScratchFile {
+    path: String,
+    writer: Box<dyn file_io::FileWriter>,
+    len: u64,
+}
+
+impl ScratchFile {
+    fn create(dir: &Path) -> io::Result<Self> {
+        static NEXT_ID: AtomicUsize = AtomicUsize::new(0);
+        #[cfg(not(target_arch = "wasm32"))]
+        let process_id = std::process::id();
+        #[cfg(target_arch = "wasm32")]
+        let process_id = 0;
+
+        file_io::get_dir_manager().create_dir_all(&dir.to_string_lossy())
+            .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
+        // The temp directory may be shared with other users: the file is created new
+        // and private under a name that can't be guessed, a file or symlink planted
+        // at the name makes it try another one
+        let mut attempts = 0;
+        loop {
+            let random = std::collections::hash_map::RandomState::new().build_hasher().finish();
+            let name = format!("rq-scratch-{}-{}-{:016x}.tmp", process_id, NEXT_ID.fetch_add(1, Ordering::SeqCst), random);
+            let path = dir.join(name).to_string_lossy().to_string();
+            match file_io::create_new_file_writer(&path) {
+                Ok(writer) => return Ok(Self { path, writer, len: 0 }),
+                Err(e) if e.kind() == io::ErrorKind::AlreadyExists && attempts < 16 => attempts += 1,
+                Err(e) => return Err(e),
+            }
+        }
+    }
+
+    // Append data, returning its offset in the file
+    fn append(&mut self, data: &[u8]) -> io::Result<u64> {
+        let offset = self.len;
+        self.writer.write_chunk(offset as usize, data)
+            .and_then(|_| self.writer.flush())
+            .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
+        self.len += data.len() as u64;
+        Ok(offset)
+    }
+
+    fn read(&self, offset: u64, len: usize) -> io::Result<Vec<u8>> {
+        let mut data = vec![0u8; len];
+        let mut reader = file_io::open_file_reader(&self.path).map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
+        let mut read = 0;
+        while read < len {
+            match reader.read_chunk(offset + read as u64, &mut data[read..]) {
+                Ok(0) => return Err(io::Error::new(io::ErrorKind::UnexpectedEof, format!("Scratch file {} is truncated", self.path))),
+                Ok(n) => read += n,
+                Err(e) => return Err(io::Error::new(io::ErrorKind::Other, e)),
+            }
+        }
+        Ok(data)
+    }
+}
+
+impl Drop for ScratchFile {
+    fn drop(&mut self) {
+        if let Err(e) = file_io::get_dir_manager().remove_file(&self.path) {
+            debug!("Failed to remove the scratch file {}: {}", self.path, e);
+        }
+    }
+}
+
+thread_local! {
+    // Thread whose operation the current thread works for, when it is a worker of it
+    static OPERATION_THREAD: RefCell<Option<OperationThread>> = const { RefCell::new(None) };
+    // Dropped when the thread exits, which tells the values kept for it can be dropped
+    static THREAD_ALIVE: Arc<()> = Arc::new(());
+}
+
+// Thread that called an operation, which owns its last error
+#[derive(Clone)]
+pub(crate) struct OperationThread {
+    id: ThreadId,
+    alive: Weak<()>,
+}
+
+// Thread of the operation running on the current thread
+pub(crate) fn operation_thread() -> OperationThread {
+    OPERATION_THREAD.with(|owner| owner.borrow().clone()).unwrap_or_else(|| OperationThread {
+        id: std::thread::current().id(),
+        // A thread being torn down gets values dropped with the next ones
+        alive: THREAD_ALIVE.try_with(Arc::downgrade).unwrap_or_default(),
+    })
+}
+
+// Run `work` on a worker thread of the operation of `owner`, so the errors it records
+// are read by the thread that called the operation
+pub(crate) fn work_for<R>(owner: &OperationThread, work: impl FnOnce() -> R) -> R {
+    let previous = OPERATION_THREAD.with(|current| current.replace(Some(owner.clone())));
+    let result = work();
+    OPERATION_THREAD.with(|current| current.replace(previous));
+    result
+}
+
+// A value for each thread calling the operations of a processor, so concurrent
+// operations don't overwrite each other's. Values are kept until cleared or until
+// their thread exits.
+#[derive(Default)]
+struct PerThread<T> {
+    values: Mutex<HashMap<ThreadId, (Weak<()>, T)>>,
+}
+
+impl<T: Default> PerThread<T> {
+    // Value of the operation thread of the current thread
+    fn lock(&self) -> MappedMutexGuard<'_, T> {
+        let owner = operation_thread();
+        let mut values = self.values.lock();
+        values.retain(|_, (alive, _)| alive.strong_count() > 0);
+        MutexGuard::map(values, |values| &mut values.entry(owner.id).or_insert_with(|| (owner.alive, T::default())).1)
+    }
+
+    // Drop the values of all threads
+    fn clear(&self) {
+        self.values.lock().clear();
+    }
+}
+
+// RAII guard for task counting
+struct TaskGuard<'a> {
+    counter: &'a AtomicUsize,
+}
+
+impl<'a> TaskGuard<'a> {
+    // Increments the counter unless it already reached the limit, in a single
+    // atomic step so concurrent callers can't exceed the limit
+    fn try_new(counter: &'a AtomicUsize, limit: usize) -> Option<Self> {
+        counter
+            .fetch_update(Ordering::SeqCst, Ordering::SeqCst, |current| (current < limit).then_some(current + 1))
+            .ok()?;
+        Some(Self { counter })
+    }
+}
+
+impl<'a> Drop for TaskGuard<'a> {
+    fn drop(&mut self) {
+        self.counter.fetch_sub(1, Ordering::SeqCst);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::collections::HashMap;
+    use super::*;
+    use std::path::PathBuf;
+    use std::sync::Arc;
+    use tempfile::{tempdir, TempDir};
+    use rand::{seq::SliceRandom, thread_rng};
+
+    // Helper functions to replace fs:: calls in tests with file_io abstractions
+    fn create_dir(path: &Path) -> io::Result<()> {
+        let path_str = path.to_string_lossy().to_string();
+        file_io::get_dir_manager().create_dir_all(&path_str)
+            .map_err(|e| io::Error::new(io::ErrorKind::Other, e))
+    }
+
+    fn write_file(path: &Path, contents: &[u8]) -> io::Result<()> {
+        let path_str = path.to_string_lossy().to_string();
+        let mut writer = file_io::open_file_writer(&path_str)
+            .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
+        writer.write_chunk(0, contents)
+            .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
+        writer.flush()
+            .map_err(|e| io::Error::new(io::ErrorKind::Other, e))
+    }
+
+    fn read_file(path: &Path) -> io::Result<Vec<u8>> {
+        let path_str = path.to_string_lossy().to_string();
+        let mut reader = file_io::open_file_reader(&path_str)
+            .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
+        let size = reader.file_size()
+            .map_err(|e| io::Error::new(io::ErrorKind::Other, e))? as usize;
+        let mut data = vec![0u8; size];
+        reader.read_chunk(0, &mut data)
+            .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
+        Ok(data)
+    }
+
+    fn read_file_to_string(path: &Path) -> io::Result<String> {
+        let data = read_file(path)?;
+        String::from_utf8(data)
+            .map_err(|e| io::Error::new(io::ErrorKind::InvalidData, e.to_string()))
+    }
+
+    // Limited check if a path exists - used for tests only
+    fn path_exists(path: &Path) -> bool {
+        let path_str = path.to_string_lossy().to_string();
+        file_io::open_file_reader(&path_str).is_ok()
+    }
+
+    // Helper function to create an empty file
+    fn create_empty_file(path: &Path) -> io::Result<()> {
+        write_file(path, &[]).map_err(|e| io::Error::new(io::ErrorKind::Other, e))
+    }
+
+    // Creates a temporary directory and returns its path
+    fn create_temp_dir() -> (TempDir, PathBuf) {
+        let dir = tempdir().expect("Failed to create temp directory");
+        let path = dir.path().to_path_buf();
+        (dir, path)
+    }
+
+    // Creates a test file with the specified size at a given path
+    fn create_test_file(path: &Path, size: usize) -> io::Result<()> {
+        let data = generate_test_data(size);
+        write_file(path, &data).map_err(|e| io::Error::new(io::ErrorKind::Other, e))
+    }
+
+    // Generates test data of specified size
+    fn generate_test_data(size: usize) -> Vec<u8> {
+        let mut data = Vec::with_capacity(size);
+        for i in 0..size {
+            data.push((i % 256) as u8);
+        }
+        data
+    }
+
+    // Creates encoded symbols for a given data vector
+    fn encode_test_data(data: &[u8], symbol_size: u16, repair_symbols: u64) -> (Vec<u8>, Vec<Vec<u8>>) {
+        let config = ObjectTransmissionInformation::with_defaults(
+            data.len() as u64,
+            symbol_size,
+        );
+        
+        let encoder = Encoder::new(data, config);
+        let packets = encoder.get_encoded_packets(repair_symbols as u32);
+        
+        let mut serialized_packets = Vec::new();
+        for packet in &packets {
+            serialized_packets.push(packet.serialize());
+        }
+        
+        (encoder.get_config().serialize().to_vec(), serialized_packets)
+    }
+
+    // Creates symbol files in a directory from serialized packets
+    fn create_symbol_files(dir: &Path, packets: &[Vec<u8>]) -> io::Result<Vec<String>> {
+        let mut file_paths = Vec::new();
+        
+        for (i, packet) in packets.iter().enumerate() {
+            let file_path = dir.join(format!("symbol_{}.bin", i));
+            write_file(&file_path, packet).map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
+            file_paths.push(file_path.to_string_lossy().into_owned());
+        }
+        
+        Ok(file_paths)
+    }
+
+    fn create_block_layout(original_data: &Vec<u8>, encoder_params: Vec<u8>, packets: Vec<Vec<u8>>) -> BlockLayout {
+        let block_layout = BlockLayout {
+            block_id: 0,
+            encoder_parameters: encoder_params.to_vec(),
+            original_offset: 0,
+            size: original_data.len() as u64,
+            symbols: packets.iter().enumerate().map(|(i, _)| format!("symbol_{}.bin", i)).collect(),
+            hash: get_hash_as_b58(&original_data),
+        };
+        block_layout
+    }
+
+    fn count_files_in_dir(dir: &Path) -> usize {
+        let dir_manager = file_io::get_dir_manager();
+        let dir = dir.to_string_lossy().to_string();
+        dir_manager.count_files(&dir).unwrap()
+    }
+
+    // Tests for ProcessorConfig
+
+    #[test]
+    fn test_config_default() {
+        let config = ProcessorConfig::default();
+        
+        assert_eq!(config.symbol_size, DEFAULT_SYMBOL_SIZE_B);
+        assert_eq!(config.redundancy_factor, DEFAULT_REDUNDANCY_FACTOR);
+        assert_eq!(config.max_memory_mb, DEFAULT_MAX_MEMORY_MB);
+        assert_eq!(config.concurrency_limit, DEFAULT_CONCURRENCY_LIMIT);
+    }
+
+    #[test]
+    fn test_repair_symbols_per_block() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let original_data: Vec<u8> = (0..25 * 1024).map(|i| (i * 31 / 7 % 251) as u8).collect();
+        write_file(&input_path, &original_data).unwrap();
+
+        let processor = RaptorQProcessor::builder().symbol_size(1024).repair_symbols_per_block(3).build().unwrap();
+        let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 10 * 1024, false).unwrap();
+        let blocks = result.blocks.as_ref().unwrap();
+        assert_eq!(blocks.len(), 3);
+        for block in blocks {
+            assert_eq!(block.symbols_count - block.source_symbols_count, 3);
+        }
+        assert_eq!(result.total_repair_symbols, 9);
+        let summary = RaptorQLayout::read_file(&result.layout_file_path).unwrap().summary();
+        assert!(summary.blocks.iter().all(|b| b.repair_symbols_count == 3));
+
+        let output_path = dir_path.join("output.bin");
+        processor.decode_symbols(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path).unwrap();
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
+
+        // Back to the redundancy factor
+        processor.set_repair_symbols_per_block(None).unwrap();
+        let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 10 * 1024, false).unwrap();
+        assert_eq!(result.total_repair_symbols, 2 * 30 + 15);
+
+        assert!(matches!(
+            processor.set_repair_symbols_per_block(Some(MAX_REPAIR_SYMBOLS_PER_BLOCK + 1)),
+            Err(ProcessError::InvalidParameter(_))
+        ));
+        assert!(matches!(
+            RaptorQProcessor::builder().repair_symbols_per_block(u32::MAX).build(),
+            Err(ProcessError::InvalidConfig(_))
+        ));
+    }
+
+    #[test]
+    fn test_encode_file_with_filter() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let original_data: Vec<u8> = (0..25 * 1024).map(|i| (i * 31 / 7 % 251) as u8).collect();
+        write_file(&input_path, &original_data).unwrap();
+        let input = input_path.to_str().unwrap();
+
+        let processor = RaptorQProcessor::builder().symbol_size(1024).redundancy_factor(3).build().unwrap();
+        let full_dir = dir_path.join("full");
+        let full = processor.encode_file(input, full_dir.to_str().unwrap(), 10 * 1024, false).unwrap();
+
+        // Two nodes each keep half of the symbols
+        let mut layouts = Vec::new();
+        for node in 0..2u32 {
+            let node_dir = dir_path.join(format!("node_{}", node));
+            let calls = std::cell::Cell::new(0u64);
+            let result = processor.encode_file_with_filter(input, node_dir.to_str().unwrap(), 10 * 1024, |block_id, esi, is_repair| {
+                calls.set(calls.get() + 1);
+                let layout_block = &full.blocks.as_ref().unwrap()[block_id];
+                assert_eq!(is_repair, esi as u64 >= layout_block.source_symbols_count);
+                esi % 2 == node
+            }).unwrap();
+            assert_eq!(calls.get(), full.total_symbols_count);
+
+            // The result counts the kept symbols, the layout lists them all
+            let files = std::fs::read_dir(&node_dir).unwrap()
+                .filter_map(|e| e.ok())
+                .filter(|e| e.path().is_dir())
+                .map(|e| std::fs::read_dir(e.path()).unwrap().count() as u64)
+                .sum::<u64>();
+            assert_eq!(result.total_symbols_count, files);
+            let blocks = result.blocks.as_ref().unwrap();
+            for (block, full_block) in blocks.iter().zip(full.blocks.as_ref().unwrap()) {
+                let kept_source = (full_block.source_symbols_count + 1 - node as u64) / 2;
+                assert_eq!(block.source_symbols_count, kept_source, "block {}", block.block_id);
+            }
+            assert_eq!(result.total_repair_symbols, result.total_symbols_count - blocks.iter().map(|b| b.source_symbols_count).sum::<u64>());
+            let layout = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+            assert_eq!(layout.symbols_count(), full.total_symbols_count);
+            layouts.push((node_dir, result.layout_file_path));
+        }
+        let layout_content = |path: &str| std::fs::read_to_string(path).unwrap();
+        assert_eq!(layout_content(&layouts[0].1), layout_content(&full.layout_file_path));
+
+        // The symbols of both nodes decode with the layout of either
+        let output_path = dir_path.join("output.bin");
+        let dirs = [layouts[0].0.to_str().unwrap(), layouts[1].0.to_str().unwrap()];
+        processor.decode_from_dirs(&dirs, output_path.to_str().unwrap(), &layouts[1].1).unwrap();
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
+
+        // Keeping nothing writes no symbol
+        let none_dir = dir_path.join("none");
+        let result = processor.encode_file_with_filter(input, none_dir.to_str().unwrap(), 10 * 1024, |_, _, _| false).unwrap();
+        assert_eq!(result.total_symbols_count, 0);
+        assert_eq!(result.total_repair_symbols, 0);
+        assert!(std::fs::read_dir(none_dir.join("block_0")).unwrap().next().is_none());
+    }
+
+    #[test]
+    fn test_encode_file_with_filter_source_blocks() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        // More symbols than a source block holds, split into source blocks of 29185 and 29184
+        write_file(&input_path, &generate_test_data(58369 * 8 - 3)).unwrap();
+
+        let processor = RaptorQProcessor::builder().symbol_size(8).repair_symbols_per_block(2).build().unwrap();
+        let seen = std::cell::RefCell::new(Vec::new());
+        let result = processor.encode_file_with_filter(input_path.to_str().unwrap(), dir_path.join("none").to_str().unwrap(), 0, |_, esi, is_repair| {
+            seen.borrow_mut().push((esi, is_repair));
+            false
+        }).unwrap();
+        let layout = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+        assert_eq!(layout.blocks[0].encoder_config().unwrap().source_blocks(), 2);
+
+        // Each source block lists its source symbols then its 2 repair symbols
+        let expected: Vec<(u32, bool)> = [29185u32, 29184].iter()
+            .flat_map(|&k| (0..k + 2).map(move |esi| (esi, esi >= k)))
+            .collect();
+        assert_eq!(*seen.borrow(), expected);
+    }
+
+    #[test]
+    fn test_encode_to_channel() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let original_data = generate_test_data(25 * 1024);
+        write_file(&input_path, &original_data).unwrap();
+        let input = input_path.to_str().unwrap();
+
+        let processor = RaptorQProcessor::builder().symbol_size(1024).concurrency_limit(1).build().unwrap();
+        let (sender, receiver) = mpsc::sync_channel(2);
+        let (result, symbols) = std::thread::scope(|s| {
+            let encode = s.spawn(|| processor.encode_to_channel(input, 10 * 1024, sender));
+            let symbols: Vec<EncodedSymbol> = receiver.iter().collect();
+            (encode.join().unwrap().unwrap(), symbols)
+        });
+
+        // The layout is the one of encode_file, the symbols come in its order
+        let symbols_dir = dir_path.join("symbols");
+        let encoded = processor.encode_file(input, symbols_dir.to_str().unwrap(), 10 * 1024, false).unwrap();
+        let layout_json = std::fs::read_to_string(&encoded.layout_file_path).unwrap();
+        assert_eq!(result.layout_content.as_deref(), Some(layout_json.as_str()));
+        assert_eq!(symbols.len() as u64, result.total_symbols_count);
+        let layout = result.layout.as_ref().unwrap();
+        let expected: Vec<(usize, &String)> = layout.blocks.iter()
+            .flat_map(|b| b.symbols.iter().map(move |id| (b.block_id, id)))
+            .collect();
+        for (symbol, (block_id, symbol_id)) in symbols.iter().zip(&expected) {
+            assert_eq!(symbol.block_id, *block_id);
+            assert_eq!(&processor.calculate_symbol_id(&symbol.data), *symbol_id);
+            assert_eq!(symbol.esi, symbol_esi(&symbol.data).unwrap());
+        }
+        // Source symbols of a block come before its repair symbols
+        for block in result.blocks.as_ref().unwrap() {
+            let flags: Vec<bool> = symbols.iter().filter(|s| s.block_id == block.block_id).map(|s| s.is_repair).collect();
+            assert_eq!(flags.iter().filter(|r| !**r).count() as u64, block.source_symbols_count);
+            assert!(flags.windows(2).all(|w| w[0] <= w[1]));
+        }
+
+        // The symbols decode from their block ids and data
+        let layout_path = dir_path.join("layout.json");
+        write_file(&layout_path, layout_json.as_bytes()).unwrap();
+        let mut decoded = Vec::new();
+        let source = symbols.into_iter().map(|s| Ok((s.block_id, s.data)));
+        processor.decode_from_source(source, layout_path.to_str().unwrap(), &mut decoded).unwrap();
+        assert_eq!(decoded, original_data);
+
+        // A dropped receiver stops the encode and frees its task slot
+        let (sender, receiver) = mpsc::sync_channel(0);
+        let (result, last_error) = std::thread::scope(|s| {
+            let encode = s.spawn(|| (processor.encode_to_channel(input, 10 * 1024, sender), processor.get_last_error()));
+            receiver.recv().unwrap();
+            drop(receiver);
+            encode.join().unwrap()
+        });
+        assert!(matches!(result, Err(ProcessError::Cancelled)));
+        assert_eq!(last_error, "The symbol receiver was dropped");
+        let (sender, receiver) = mpsc::sync_channel(1024);
+        let result = processor.encode_to_channel(input, 0, sender).unwrap();
+        assert_eq!(receiver.iter().count() as u64, result.total_symbols_count);
+    }
+
+    #[test]
+    fn test_encode_to_channel_source_blocks() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        // More symbols than a source block holds, split into source blocks of 29185 and 29184
+        write_file(&input_path, &generate_test_data(58369 * 8 - 3)).unwrap();
+
+        let processor = RaptorQProcessor::builder().symbol_size(8).repair_symbols_per_block(2).build().unwrap();
+        let (sender, receiver) = mpsc::sync_channel(58369 + 2 * 2);
+        processor.encode_to_channel(input_path.to_str().unwrap(), 0, sender).unwrap();
+        let symbols: Vec<EncodedSymbol> = receiver.iter().collect();
+        assert_eq!(symbols.len(), 58369 + 2 * 2);
+        for symbol in &symbols {
+            let k = if symbol.data[0] == 0 { 29185 } else { 29184 };
+            assert_eq!(symbol.is_repair, symbol.esi >= k, "Source block {}, ESI {}", symbol.data[0], symbol.esi);
+        }
+        assert_eq!(symbols.iter().filter(|s| s.is_repair).count(), 4);
+    }
+
+    #[test]
+    fn test_get_recommended_block_size_for() {
+        let processor = RaptorQProcessor::builder()
+            .symbol_size(1024)
+            .max_memory_mb(16 * 1024)
+            .concurrency_limit(8)
+            .build()
+            .unwrap();
+        let mb = 1024 * 1024;
+
+        // One core behaves like get_recommended_block_size
+        for file_size in [10 * mb, 1000 * mb, 12000 * mb] {
+            assert_eq!(
+                processor.get_recommended_block_size_for(file_size, 16 * 1024, 1),
+                processor.get_recommended_block_size(file_size)
+            );
+        }
+
+        // One block per worker, the workers being capped by the concurrency limit
+        assert_eq!(processor.get_recommended_block_size_for(400 * mb, 16 * 1024, 4), 100 * mb);
+        assert_eq!(processor.get_recommended_block_size_for(400 * mb, 16 * 1024, 32), 50 * mb);
+
+        // Not below the minimum parallel block size, small files are not split
+        assert_eq!(processor.get_recommended_block_size_for(16 * mb, 16 * 1024, 8), MIN_PARALLEL_BLOCK_SIZE_B);
+        assert_eq!(processor.get_recommended_block_size_for(3 * mb, 16 * 1024, 8), 0);
+
+        // Less memory gives blocks fitting in it, more memory doesn't change the split
+        let constrained = processor.get_recommended_block_size_for(400 * mb, 1024, 4);
+        assert!(constrained < 100 * mb && constrained > 0);
+        assert_eq!(constrained % 1024, 0);
+        assert_eq!(processor.get_recommended_block_size_for(400 * mb, 64 * 1024, 4), 100 * mb);
+
+        // Defaults to the memory limit and the cores of the host
+        assert_eq!(
+            processor.get_recommended_block_size_for(10 * mb, 0, 1),
+            processor.get_recommended_block_size_for(10 * mb, 16 * 1024, 1)
+        );
+    }
+
+    #[test]
+    fn test_get_recommended_redundancy() {
+        let config = ProcessorConfig { symbol_size: 1024, ..ProcessorConfig::default() };
+        let processor = RaptorQProcessor::new(config);
+        let file_size = 1024 * 1024;
+
+        // No loss, the source symbols are enough
+        assert_eq!(processor.get_recommended_redundancy(file_size, 0.0).unwrap(), 1);
+
+        // Higher loss needs more redundancy
+        let low = processor.get_recommended_redundancy(file_size, 0.1).unwrap();
+        let high = processor.get_recommended_redundancy(file_size, 0.5).unwrap();
+        assert!(low >= 2);
+        assert!(high > low);
+
+        // 1024 source symbols with 50% loss: 3 times as many symbols give 1536 on average
+        // with a standard deviation of about 28, which is enough
+        assert_eq!(high, 3);
+
+        // Clamped to the maximum factor
+        assert_eq!(processor.get_recommended_redundancy(100, 0.999).unwrap(), u8::MAX);
+
+        for fraction in [-0.1, 1.0, f64::NAN] {
+            assert!(matches!(
+                processor.get_recommended_redundancy(file_size, fraction),
+                Err(ProcessError::InvalidParameter(_))
+            ));
+        }
+        assert!(matches!(
+            processor.get_recommended_redundancy(0, 0.1),
+            Err(ProcessError::InvalidParameter(_))
+        ));
+    }
+
+    #[test]
+    fn test_estimate_peak_memory() {
+        let config = ProcessorConfig {
+            symbol_size: 1000,
+            redundancy_factor: 3,
+            max_memory_mb: 1024,
+            ..ProcessorConfig::default()
+        };
+        let processor = RaptorQProcessor::new(config);
+
+        // 100 source and 200 repair symbols of 1004 bytes
+        let estimate = processor.estimate_peak_memory(100_000, 0).unwrap();
+        assert_eq!(estimate, 250_000 + 300 * 1004);
+
+        // Only the largest block counts
+        let estimate = processor.estimate_peak_memory(1_000_000, 100_000).unwrap();
+        assert_eq!(estimate, 250_000 + 300 * 1004);
+
+        // Large files are split with the recommended block size
+        let large_file = 10 * 1024 * 1024 * 1024u64;
+        let recommended = processor.get_recommended_block_size(large_file as usize) as u64;
+        let estimate = processor.estimate_peak_memory(large_file, 0).unwrap();
+        assert_eq!(estimate, processor.estimate_peak_memory(recommended, 0).unwrap());
+        assert!(estimate < 1024 * 1024 * 1024);
+
+        assert!(matches!(processor.estimate_peak_memory(0, 0), Err(ProcessError::InvalidParameter(_))));
+    }
+
+    #[test]
+    fn test_estimate_storage() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let file_size = 50 * 1024 + 100;
+        write_file(&input_path, &generate_test_data(file_size)).unwrap();
+
+        // Bytes of the symbol files of an encode, without the layout
+        let stored_bytes = |symbols_dir: &Path| -> u64 {
+            std::fs::read_dir(symbols_dir).unwrap()
+                .filter_map(|e| e.ok())
+                .filter(|e| e.path().is_dir())
+                .flat_map(|e| std::fs::read_dir(e.path()).unwrap().filter_map(|e| e.ok()))
+                .map(|e| e.metadata().unwrap().len())
+                .sum()
+        };
+
+        let processor = RaptorQProcessor::builder().symbol_size(1024).redundancy_factor(4).build().unwrap();
+        for (name, key) in [("plain", None), ("encrypted", Some([5u8; 32]))] {
+            processor.set_symbol_key(key.as_ref().map(|k| &k[..])).unwrap();
+            for block_size in [0, 20 * 1024] {
+                let symbols_dir = dir_path.join(format!("{}_{}", name, block_size));
+                let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), block_size, false).unwrap();
+
+                let estimate = processor.estimate_storage(file_size as u64, block_size).unwrap();
+                assert_eq!(estimate.object_size, file_size as u64);
+                assert_eq!(estimate.symbols_count, result.total_symbols_count, "{} {}", name, block_size);
+                assert_eq!(estimate.symbol_bytes, stored_bytes(&symbols_dir), "{} {}", name, block_size);
+                let overhead = estimate.symbol_bytes as f64 / file_size as f64 - 1.0;
+                assert!((estimate.overhead_fraction - overhead).abs() < 1e-9);
+                assert!(estimate.overhead_fraction > 0.0);
+            }
+        }
+
+        // With a single repair symbol, the overhead is the padding and the payload IDs
+        processor.set_symbol_key(None).unwrap();
+        processor.set_repair_symbols_per_block(Some(1)).unwrap();
+        let estimate = processor.estimate_storage(file_size as u64, 0).unwrap();
+        assert_eq!(estimate.symbols_count, 52);
+        assert_eq!(estimate.symbol_bytes, 52 * 1028);
+
+        assert!(matches!(processor.estimate_storage(0, 0), Err(ProcessError::InvalidParameter(_))));
+    }
+
+    #[test]
+    fn test_plan_encode() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        write_file(&input_path, &generate_test_data(50 * 1024 + 100)).unwrap();
+
+        let config = ProcessorConfig { symbol_size: 1024, redundancy_factor: 4, ..ProcessorConfig::default() };
+        let processor = RaptorQProcessor::new(config);
+
+        // The plan matches the layout the encoding writes, the last block is shorter
+        for block_size in [0, 20 * 1024] {
+            let plan = processor.plan_encode(input_path.to_str().unwrap(), block_size).unwrap();
+            assert!(!symbols_dir.exists(), "Planning should not write anything");
+
+            let result = processor.encode_file(
+                input_path.to_str().unwrap(),
+                symbols_dir.to_str().unwrap(),
+                block_size,
+                false,
+            ).unwrap();
+            let layout = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+            assert_eq!(plan, layout.summary(), "block size {}", block_size);
+            std::fs::remove_dir_all(&symbols_dir).unwrap();
+        }
+
+        let plan = processor.plan_encode(input_path.to_str().unwrap(), 20 * 1024).unwrap();
+        assert_eq!(plan.blocks.len(), 3);
+        assert_eq!(plan.blocks[2].size, 10 * 1024 + 100);
+
+        let missing = dir_path.join("missing.bin");
+        assert!(matches!(processor.plan_encode(missing.to_str().unwrap(), 0), Err(ProcessError::FileNotFound(_))));
+    }
+
+    #[test]
+    fn test_describe_encode() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        write_file(&input_path, &generate_test_data(50 * 1024 + 100)).unwrap();
+
+        let processor = RaptorQProcessor::builder()
+            .symbol_size(1024)
+            .redundancy_factor(4)
+            .symbol_codec(SymbolCodec::Gzip)
+            .symbol_crc(true)
+            .build()
+            .unwrap();
+        let description = processor.describe_encode(input_path.to_str().unwrap(), 20 * 1024).unwrap();
+        let plan = processor.plan_encode(input_path.to_str().unwrap(), 20 * 1024).unwrap();
+
+        let lines: Vec<&str> = description.lines().collect();
+        assert_eq!(lines.len(), 8 + plan.blocks.len(), "{}", description);
+        assert!(lines[0].contains("input.bin"));
+        assert!(description.contains("object size: 51300 bytes"), "{}", description);
+        assert!(description.contains("blocks: 3 of up to 20480 bytes"), "{}", description);
+        assert!(description.contains("symbol size: 1024 bytes\n"), "{}", description);
+        assert!(description.contains("redundancy factor 4"), "{}", description);
+        assert!(description.contains("codec Gzip, not encrypted, CRC32 headers"), "{}", description);
+        assert!(description.contains(&format!("symbols: {} (", plan.symbols_count)), "{}", description);
+
+        // The last block of 10340 bytes takes 11 source symbols, the last one padded
+        let last = lines.last().unwrap();
+        assert!(last.starts_with("  block 2: offset 40960, 10340 bytes, K=11 source"), "{}", last);
+        assert!(last.contains("padding 924 bytes"), "{}", last);
+
+        processor.set_repair_symbols_per_block(Some(3)).unwrap();
+        let description = processor.describe_encode(input_path.to_str().unwrap(), 0).unwrap();
+        assert!(description.contains("repair symbols: 3 per block"), "{}", description);
+        assert!(description.contains("+ 3 repair symbols"), "{}", description);
+
+        let missing = dir_path.join("missing.bin");
+        assert!(matches!(processor.describe_encode(missing.to_str().unwrap(), 0), Err(ProcessError::FileNotFound(_))));
+    }
+
+    #[test]
+    fn test_max_block_size() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+
+        // A sparse file just past 4 GB, only its size is used
+        let file_size = (4u64 << 30) + 1;
+        let file = std::fs::File::create(&input_path).unwrap();
+        file.set_len(file_size).unwrap();
+        drop(file);
+        let input = input_path.to_str().unwrap();
+
+        // With small symbols, blocks are limited to about 110 MB
+        let config = ProcessorConfig { symbol_size: 8, ..ProcessorConfig::default() };
+        let processor = RaptorQProcessor::new(config);
+        let max_block_size = processor.max_block_size();
+        assert_eq!(max_block_size, 255 * 56403 * 8);
+
+        for block_size in [2usize << 30, max_block_size as usize + 8] {
+            let result = processor.plan_encode(input, block_size);
+            assert!(matches!(result, Err(ProcessError::InvalidParameter(_))), "block size {}: {:?}", block_size, result);
+            assert!(processor.get_last_error().contains("exceeds the largest block size"));
+        }
+        assert!(matches!(
+            processor.encode_file(input, symbols_dir.to_str().unwrap(), 2usize << 30, false),
+            Err(ProcessError::InvalidParameter(_))
+        ));
+        assert!(!symbols_dir.exists(), "A rejected encode should not write anything");
+        assert!(matches!(processor.estimate_peak_memory(file_size, 2usize << 30), Err(ProcessError::InvalidParameter(_))));
+
+        // Recommended block sizes are capped, even for a file that would not be split
+        let plan = processor.plan_encode(input, 0).unwrap();
+        assert!(plan.blocks.len() > 1);
+        assert!(plan.blocks.iter().all(|b| b.size <= max_block_size));
+        assert_eq!(plan.total_size, file_size);
+        assert_eq!(plan.blocks.iter().map(|b| b.size).sum::<u64>(), file_size);
+        assert!(processor.get_recommended_block_size(usize::MAX) as u64 <= max_block_size);
+        assert!(processor.get_recommended_block_size_for(usize::MAX, 0, 1) as u64 <= max_block_size);
+        assert_eq!(processor.plan_encode(input, max_block_size as usize).unwrap().blocks[0].size, max_block_size);
+
+        // With larger symbols, blocks can exceed 4 GB on 64-bit platforms
+        let config = ProcessorConfig { symbol_size: 1024, ..ProcessorConfig::default() };
+        let processor = RaptorQProcessor::new(config);
+        #[cfg(target_pointer_width = "64")]
+        {
+            assert!(processor.max_block_size() > u32::MAX as u64);
+            let plan = processor.plan_encode(input, (4usize << 30) - 1024).unwrap();
+            assert_eq!(plan.blocks.len(), 2);
+            assert_eq!(plan.blocks[1].original_offset, (4u64 << 30) - 1024);
+            assert_eq!(plan.blocks[1].size, 1025);
+            assert_eq!(processor.plan_encode(input, 4usize << 30).unwrap().blocks[1].original_offset, 4u64 << 30);
+        }
+        #[cfg(target_pointer_width = "32")]
+        assert!(matches!(processor.plan_encode(input, 0), Err(ProcessError::InvalidParameter(_))));
+    }
+
+    #[test]
+    fn test_validate_block_size() {
+        let error_message = |processor: &RaptorQProcessor, block_size: u64| match processor.validate_block_size(block_size) {
+            Err(ProcessError::InvalidParameter(message)) => message,
+            other => panic!("Expected InvalidParameter for {}, got {:?}", block_size, other),
+        };
+
+        // At most 255 source blocks of K'max = 56403 symbols
+        let k_max_symbols = 255 * 56403u64;
+        let processor = RaptorQProcessor::builder().symbol_size(8).build().unwrap();
+        assert!(processor.validate_block_size(k_max_symbols * 8).is_ok());
+        let message = error_message(&processor, k_max_symbols * 8 + 1);
+        assert!(message.contains(&format!("needs {} source symbols, more than the {}", k_max_symbols + 1, k_max_symbols)), "{}", message);
+        assert!(message.ends_with(&format!("use symbols of at least 16 bytes or blocks of at most {} bytes", k_max_symbols * 8)), "{}", message);
+
+        // Symbol sizes are rounded down to a multiple of 8 bytes
+        let processor = RaptorQProcessor::builder().symbol_size(1001).build().unwrap();
+        assert_eq!(processor.max_block_size(), k_max_symbols * 1000);
+        assert!(processor.validate_block_size(k_max_symbols * 1000).is_ok());
+        let message = error_message(&processor, k_max_symbols * 1000 + 1);
+        assert!(message.contains("symbols of 1001 bytes (rounded down to 1000)"), "{}", message);
+        assert!(message.contains("use symbols of at least 1008 bytes"), "{}", message);
+
+        // Past the largest symbol size, only smaller blocks do
+        let processor = RaptorQProcessor::builder().symbol_size(65528).build().unwrap();
+        let max_block_size = processor.max_block_size();
+        assert!(processor.validate_block_size(max_block_size).is_ok());
+        let message = error_message(&processor, 1 << 40);
+        assert!(message.ends_with(&format!("; use blocks of at most {} bytes", max_block_size)), "{}", message);
+    }
+
+    #[test]
+    fn test_config_validate() {
+        assert!(ProcessorConfig::default().validate().is_ok());
+
+        let invalid = [
+            ProcessorConfig { symbol_size: 0, ..ProcessorConfig::default() },
+            ProcessorConfig { symbol_size: MIN_SYMBOL_SIZE_B - 1, ..ProcessorConfig::default() },
+            ProcessorConfig { redundancy_factor: 0, ..ProcessorConfig::default() },
+            ProcessorConfig { max_memory_mb: 0, ..ProcessorConfig::default() },
+            ProcessorConfig { concurrency_limit: 0, ..ProcessorConfig::default() },
+        ];
+        for config in invalid {
+            assert!(
+                matches!(config.validate(), Err(ProcessError::InvalidConfig(_))),
+                "Config should be invalid: {:?}", config
+            );
+        }
+
+        let minimal = ProcessorConfig {
+            symbol_size: MIN_SYMBOL_SIZE_B,
+            redundancy_factor: 1,
+            max_memory_mb: 1,
+            concurrency_limit: 1,
+        };
+        assert!(minimal.validate().is_ok());
+    }
+
+    #[test]
+    fn test_config_custom() {
+        let config = ProcessorConfig {
+            symbol_size: 1000,
+            redundancy_factor: 20,
+            max_memory_mb: 512,
+            concurrency_limit: 8,
+        };
+        
+        assert_eq!(config.symbol_size, 1000);
+        assert_eq!(config.redundancy_factor, 20);
+        assert_eq!(config.max_memory_mb, 512);
+        assert_eq!(config.concurrency_limit, 8);
+    }
+
+    // Tests for RaptorQProcessor::new
+
+    #[test]
+    fn test_new_processor() {
+        let config = ProcessorConfig::default();
+        let processor = RaptorQProcessor::new(config.clone());
+        
+        // Verify the config is stored correctly
+        assert_eq!(processor.config.symbol_size, config.symbol_size);
+        assert_eq!(processor.config.redundancy_factor, config.redundancy_factor);
+        assert_eq!(processor.config.max_memory_mb, config.max_memory_mb);
+        assert_eq!(processor.config.concurrency_limit, config.concurrency_limit);
+    }
+
+    #[test]
+    fn test_new_processor_initial_state() {
+        let processor = RaptorQProcessor::new(ProcessorConfig::default());
+        
+        // Verify initial state
+        assert_eq!(processor.active_tasks.load(Ordering::SeqCst), 0);
+        assert_eq!(processor.get_last_error(), "");
+    }
+
+    #[test]
+    fn test_processor_builder() {
+        let metrics = Arc::new(crate::metrics::MetricsCollector::new());
+        let processor = RaptorQProcessor::builder()
+            .symbol_size(1024)
+            .redundancy_factor(5)
+            .max_memory_mb(256)
+            .concurrency_limit(2)
+            .metrics(metrics.clone())
+            .logger(Arc::new(crate::logging::NoopLogger))
+            .build()
+            .unwrap();
+        assert_eq!(processor.config.symbol_size, 1024);
+        assert_eq!(processor.config.redundancy_factor, 5);
+        assert_eq!(processor.config.max_memory_mb, 256);
+        assert_eq!(processor.config.concurrency_limit, 2);
+        assert!(processor.logger.lock().is_some());
+
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        std::fs::write(&input_path, vec![7u8; 4000]).unwrap();
+        processor.encode_file(input_path.to_str().unwrap(), dir_path.join("symbols").to_str().unwrap(), 0, false).unwrap();
+        assert_eq!(metrics.snapshot().operations["encode_file"].succeeded, 1);
+
+        // Options not set keep their defaults, the configuration is validated
+        let processor = RaptorQProcessor::builder().symbol_size(1024).build().unwrap();
+        assert_eq!(processor.config.redundancy_factor, DEFAULT_REDUNDANCY_FACTOR);
+        assert!(processor.logger.lock().is_none());
+        assert!(matches!(
+            RaptorQProcessor::builder().concurrency_limit(0).build(),
+            Err(ProcessError::InvalidConfig(_))
+        ));
+
+        let config = ProcessorConfig { symbol_size: 2048, ..ProcessorConfig::default() };
+        let processor = ProcessorBuilder::new().concurrency_limit(3).config(config).build().unwrap();
+        assert_eq!(processor.config.symbol_size, 2048);
+        assert_eq!(processor.config.concurrency_limit, DEFAULT_CONCURRENCY_LIMIT, "config replaces earlier options");
+    }
+
+    // Tests for RaptorQProcessor::get_last_error
+
+    #[test]
+    fn test_get_last_error_empty() {
+        let processor = RaptorQProcessor::new(ProcessorConfig::default());
+        
+        assert_eq!(processor.get_last_error(), "");
+    }
+
+    #[test]
+    fn test_get_last_error_after_error() {
+        let processor = RaptorQProcessor::new(ProcessorConfig::default());
+        let error_message = "Test error message";
+        
+        // Set the error using the internal method
+        processor.set_last_error(error_message.to_string());
+        
+        // Verify the error message is returned correctly
+        assert_eq!(processor.get_last_error(), error_message);
+    }
+
+    // Tests for RaptorQProcessor::get_recommended_block_size
+
+    #[test]
+    fn test_reset() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        write_file(&input_path, &generate_test_data(20 * 1024)).unwrap();
+
+        let processor = RaptorQProcessor::new(ProcessorConfig { symbol_size: 1024, ..ProcessorConfig::default() });
+        let result = processor.encode_file(
+            input_path.to_str().unwrap(),
+            symbols_dir.to_str().unwrap(),
+            0,
+            false
+        ).expect("Failed to encode the file");
+
+        // Leave a decode failure with shortfalls behind
+        std::fs::remove_dir_all(symbols_dir.join("block_0")).unwrap();
+        create_dir(&symbols_dir.join("block_0")).unwrap();
+        let output_path = dir_path.join("output.bin");
+        assert!(processor.decode_symbols_verified(
+            symbols_dir.to_str().unwrap(),
+            output_path.to_str().unwrap(),
+            &result.layout_file_path
+        ).is_err());
+        assert!(!processor.get_last_error().is_empty());
+        assert!(!processor.get_last_shortfalls().is_empty());
+
+        processor.reset();
+        assert_eq!(processor.get_last_error(), "");
+        assert!(processor.get_last_shortfalls().is_empty());
+        assert!(processor.get_last_corrupt_symbols().is_empty());
+
+        // Still usable
+        processor.encode_file(
+            input_path.to_str().unwrap(),
+            symbols_dir.to_str().unwrap(),
+            0,
+            false
+        ).expect("Failed to encode the file after the reset");
+    }
+
+    #[test]
+    fn test_block_size_small_file() {
+        let processor = RaptorQProcessor::new(ProcessorConfig::default());
+        let file_size = 10 * 1024 * 1024; // 10 MB file
+        
+        // With default config (max_memory_mb = 1024), a 10 MB file should not need splitting
+        let block_size = processor.get_recommended_block_size(file_size);
+        
+        assert_eq!(block_size, 0); // 0 means no splitting needed
+    }
+
+    #[test]
+    fn test_block_size_large_file() {
+        let config = ProcessorConfig {
+            symbol_size: DEFAULT_SYMBOL_SIZE_B,
+            redundancy_factor: DEFAULT_REDUNDANCY_FACTOR,
+            max_memory_mb: 100, // Deliberately small to force splitting
+            concurrency_limit: 4,
+        };
+        let processor = RaptorQProcessor::new(config);
+        let file_size = 1024 * 1024 * 1024; // 1 GB file
+        
+        let block_size = processor.get_recommended_block_size(file_size);
+        
+        // Block size should be non-zero
+        assert!(block_size > 0);
+        // Block size should be a multiple of symbol size
+        assert_eq!(block_size % processor.config.symbol_size as usize, 0);
+    }
+
+    #[test]
+    fn test_block_size_edge_memory() {
+        // Create an array of processor configs with different memory limits
+        let configs = [
+            ProcessorConfig {
+                symbol_size: DEFAULT_SYMBOL_SIZE_B,
+                redundancy_factor: DEFAULT_REDUNDANCY_FACTOR,
+                max_memory_mb: 8_000, // 8GB
+                concurrency_limit: 4,
+            },
+            ProcessorConfig {
+                symbol_size: DEFAULT_SYMBOL_SIZE_B,
+                redundancy_factor: DEFAULT_REDUNDANCY_FACTOR,
+                max_memory_mb: 16_000, // 16GB
+                concurrency_limit: 4,
+            },
+            ProcessorConfig {
+                symbol_size: DEFAULT_SYMBOL_SIZE_B,
+                redundancy_factor: DEFAULT_REDUNDANCY_FACTOR,
+                max_memory_mb: 32_000, // 32GB
+                concurrency_limit: 4,
+            },
+            ProcessorConfig {
+                symbol_size: DEFAULT_SYMBOL_SIZE_B,
+                redundancy_factor: DEFAULT_REDUNDANCY_FACTOR,
+                max_memory_mb: 64_000, // 64GB
+                concurrency_limit: 4,
+            },
+        ];
+
+        // Create processors from configs
+        let processors: Vec<RaptorQProcessor> = configs.iter()
+            .map(|config| RaptorQProcessor::new(config.clone()))
+            .collect();
+
+        // Define file sizes to test
+        let file_sizes = [
+            10 * 1024 * 1024,          // 10 MB
+            100 * 1024 * 1024,         // 100 MB
+            1024 * 1024 * 1024,        // 1 GB
+            10 * 1024 * 1024 * 1024,   // 10 GB
+            100 * 1024 * 1024 * 1024,  // 100 GB
+        ];
+
+        // Test each file size with each processor
+        for &file_size in &file_sizes {
+            println!("Testing file size: {} bytes", file_size);
+
+            // Get block sizes for all processors
+            // Changed type from Vec<u64> to Vec<usize> to match the return type
+            let block_sizes: Vec<usize> = processors.iter()
+                .map(|processor| processor.get_recommended_block_size(file_size))
+                .collect();
+
+            for (i, &block_size) in block_sizes.iter().enumerate() {
+                println!("  Processor with {} MB memory: block size = {} bytes",
+                         configs[i].max_memory_mb, block_size);
+
+                // For small files compared to memory, splitting might not be needed.
+                // Convert max_memory_mb to the same type as file_size for comparison
+                if file_size < (configs[i].max_memory_mb * 1024 * 1024 / 4) as usize {
+                    assert_eq!(block_size, 0,
+                               "File size of {} bytes should not need splitting with {} MB memory",
+                               file_size, configs[i].max_memory_mb);
+                }
+
+                // For large files compared to memory, splitting is required
+                if file_size > (configs[i].max_memory_mb * 1024 * 1024) as usize {
+                    assert!(block_size > 0,
+                            "File size of {} bytes should need splitting with {} MB memory",
+                            file_size, configs[i].max_memory_mb);
+                }
+
+                // Compare with next processor (with more memory)
+                if i < block_sizes.len() - 1 {
+                    let next_block_size = block_sizes[i + 1];
+
+                    // If both need splitting, more memory should allow larger blocks
+                    if block_size > 0 && next_block_size > 0 {
+                        assert!(next_block_size >= block_size,
+                                "Processor with more memory should allow larger blocks");
+                    }
+
+                    // If current doesn't need splitting, next shouldn't either
+                    if block_size == 0 {
+                        assert_eq!(next_block_size, 0,
+                                   "If a processor with less memory doesn't need splitting, a processor with more memory shouldn't either");
+                    }
+                }
+            }
+        }
+    }
+
+    #[test]
+    fn test_blcok_size_zero_file() {
+        let processor = RaptorQProcessor::new(ProcessorConfig::default());
+        let file_size = 0;
+        
+        let block_size = processor.get_recommended_block_size(file_size);
+        
+        assert_eq!(block_size, 0); // 0 means no splitting needed
+    }
+
+    // Tests for RaptorQProcessor::encode_file
+
+    #[test]
+    fn test_encode_file_not_found() {
+        let processor = RaptorQProcessor::new(ProcessorConfig::default());
+        let result = processor.encode_file("non_existent_file.txt", "output_dir", 0, false);
+        
+        assert!(matches!(result, Err(ProcessError::FileNotFound(_))));
+    }
+
+    #[test]
+    fn test_encode_empty_file() {
+        let (temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("empty.txt");
+        let output_dir = dir_path.join("output");
+        
+        // Create an empty file
+        create_empty_file(&input_path).expect("Failed to create empty file");
+        
+        let processor = RaptorQProcessor::new(ProcessorConfig::default());
+        let result = processor.encode_file(
+            input_path.to_str().unwrap(),
+            output_dir.to_str().unwrap(),
+            0,
+            false
+        ).expect("An empty file should be encoded");
+        assert_eq!(result.total_symbols_count, 0);
+        assert!(result.blocks.unwrap().is_empty());
+
+        let layout = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+        assert!(layout.blocks.is_empty());
+        assert!(layout.is_empty_object());
+        assert!(processor.can_decode(output_dir.to_str().unwrap(), &result.layout_file_path).unwrap());
+        assert!(processor.missing_symbols(output_dir.to_str().unwrap(), &result.layout_file_path).unwrap().is_empty());
+
+        // It decodes to an empty file, replacing the content of the output
+        let output_path = dir_path.join("decoded.txt");
+        write_file(&output_path, b"previous content").unwrap();
+        processor.decode_symbols_checked(output_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path)
+            .expect("An empty layout should decode");
+        assert_eq!(read_file(&output_path).unwrap(), b"");
+
+        // The other decodes and the validation accept it as well
+        let layout_content = read_file(Path::new(&result.layout_file_path)).unwrap();
+        assert_eq!(processor.decode_bytes::<Vec<u8>>(&[], &layout_content).unwrap(), b"");
+        let mut output = Vec::new();
+        let source = std::iter::empty::<io::Result<(usize, Vec<u8>)>>();
+        assert_eq!(processor.decode_from_source(source, &result.layout_file_path, &mut output).unwrap(), 0);
+        assert!(output.is_empty());
+        let mut store = crate::store::MemoryStore::new();
+        store.insert("object/layout.json", layout_content.clone());
+        assert_eq!(processor.decode_from_store(&store, "object", "object/layout.json", &mut output).unwrap(), 0);
+        assert!(output.is_empty());
+        assert!(processor.validate_layout(output_dir.to_str().unwrap(), &result.layout_file_path).unwrap().is_empty());
+
+        // A layout without blocks of a non-empty object is still rejected
+        let mut truncated = layout.clone();
+        truncated.object_sha256 = sha256_hex(Sha256::new_with_prefix(b"data"));
+        let result = processor.decode_symbols_with_layout(output_dir.to_str().unwrap(), output_path.to_str().unwrap(), &truncated);
+        assert!(matches!(result, Err(ProcessError::DecodingFailed(_))), "{:?}", result);
+        
+        // Ensure temp_dir isn't dropped early
+        drop(temp_dir);
+    }
+
+    #[test]
+    fn test_encode_tiny_files() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let processor = RaptorQProcessor::builder().symbol_size(1024).build().unwrap();
+
+        // Files smaller than a symbol have one padded source symbol
+        for size in [1, 1023] {
+            let original_data = generate_test_data(size);
+            let input_path = dir_path.join(format!("input_{}.bin", size));
+            let symbols_dir = dir_path.join(format!("symbols_{}", size));
+            write_file(&input_path, &original_data).unwrap();
+
+            let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 0, false).unwrap();
+            let layout = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+            assert_eq!(layout.blocks.len(), 1);
+            assert_eq!(layout.blocks[0].size, size as u64);
+            assert_eq!(layout.blocks[0].source_symbols_count(), 1);
+
+            let output_path = dir_path.join(format!("output_{}.bin", size));
+            processor.decode_symbols_checked(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path).unwrap();
+            assert_eq!(read_file(&output_path).unwrap(), original_data, "{} bytes", size);
+        }
+    }
+
+    #[test]
+    fn test_encode_success_no_splitting() {
+        let (temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("small_file.bin");
+        let output_dir = dir_path.join("output");
+        
+        // Create a small test file (100 KB)
+        create_test_file(&input_path, 100 * 1024).expect("Failed to create test file");
+        
+        let processor = RaptorQProcessor::new(ProcessorConfig::default());
+        let result = processor.encode_file(
+            input_path.to_str().unwrap(),
+            output_dir.to_str().unwrap(),
+            0, // 0 means auto-determine if splitting is needed
+            false
+        );
+        
+        assert!(result.is_ok());
+        let result = result.unwrap();
+        let blocks = result.blocks.unwrap();
+        assert_eq!(blocks.len(), 1);
+        let block = &blocks[0];
+        assert_eq!(result.total_symbols_count, block.symbols_count);
+        assert_eq!(result.total_repair_symbols, block.symbols_count - block.source_symbols_count);
+
+        // Verify output directory exists and contains symbol files
+        assert!(path_exists(&output_dir));
+
+        let block_dir = output_dir.join(format!("{}0", BLOCK_DIR_PREFIX));
+        let file_count = count_files_in_dir(&block_dir);
+        assert!(file_count > 0);
+        
+        // Ensure temp_dir isn't dropped early
+        drop(temp_dir);
+    }
+
+    #[test]
+    fn test_encode_success_manual_no_splitting() {
+        let (temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("small_file.bin");
+        let output_dir = dir_path.join("output");
+        
+        // Create a small test file (100 KB)
+        create_test_file(&input_path, 100 * 1024).expect("Failed to create test file");
+        
+        let processor = RaptorQProcessor::new(ProcessorConfig::default());
+        let result = processor.encode_file(
+            input_path.to_str().unwrap(),
+            output_dir.to_str().unwrap(),
+            500 * 1024, // Larger than file size
+            false
+        );
+
+        assert!(result.is_ok());
+        let result = result.unwrap();
+        let blocks = result.blocks.unwrap();
+        assert_eq!(blocks.len(), 1);
+        let block = &blocks[0];
+        assert_eq!(result.total_symbols_count, block.symbols_count);
+        assert_eq!(result.total_repair_symbols, block.symbols_count - block.source_symbols_count);
+
+        // Verify output directory exists and contains symbol files
+        assert!(path_exists(&output_dir));
+
+        let block_dir = output_dir.join(format!("{}0", BLOCK_DIR_PREFIX));
+        let file_count = count_files_in_dir(&block_dir);
+        assert!(file_count > 0);
+        
+        // Ensure temp_dir isn't dropped early
+        drop(temp_dir);
+    }
+
+    #[test]
+    fn test_encode_success_auto_splitting() {
+        let (temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("large_file.bin");
+        let output_dir = dir_path.join("output");
+        
+        // Create a "large" test file (3 MB for test purposes)
+        create_test_file(&input_path, 3 * 1024 * 1024).expect("Failed to create test file");
+        
+        let config = ProcessorConfig {
+            symbol_size: DEFAULT_SYMBOL_SIZE_B,
+            redundancy_factor: DEFAULT_REDUNDANCY_FACTOR,
+            max_memory_mb: 1, // Very small memory limit to force splitting
+            concurrency_limit: 4,
+        };
+        
+        let processor = RaptorQProcessor::new(config);
+        let result = processor.encode_file(
+            input_path.to_str().unwrap(),
+            output_dir.to_str().unwrap(),
+            0, // Auto splitting
+            false
+        );
+        
+        assert!(result.is_ok());
+        let result = result.unwrap();
+        assert!(result.blocks.is_some());
+        assert!(!result.blocks.unwrap().is_empty());
+        
+        // Verify block directories exist
+        assert!(path_exists(&output_dir));
+
+        // Ensure temp_dir isn't dropped early
+        drop(temp_dir);
+    }
+
+    #[test]
+    fn test_encode_success_manual_splitting() {
+        let (temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("large_file.bin");
+        let output_dir = dir_path.join("output");
+        
+        // Create a "large" test file (3 MB for test purposes)
+        create_test_file(&input_path, 3 * 1024 * 1024).expect("Failed to create test file");
+        
+        let processor = RaptorQProcessor::new(ProcessorConfig::default());
+        let block_size = 1 * 1024 * 1024; // 1 MB blocks
+        
+        let result = processor.encode_file(
+            input_path.to_str().unwrap(),
+            output_dir.to_str().unwrap(),
+            block_size,
+            false
+        );
+        
+        assert!(result.is_ok());
+        let result = result.unwrap();
+        assert!(result.blocks.is_some());
+        let blocks = result.blocks.unwrap();
+        assert!(!blocks.is_empty());
+        assert_eq!(blocks.len(), 3); // 3 MB should create 3 blocks of 1 MB each
+        
+        // Ensure temp_dir isn't dropped early
+        drop(temp_dir);
+    }
+
+    #[test]
+    fn test_encode_output_dir_creation() {
+        let (temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("file.bin");
+        let output_dir = dir_path.join("nested/output/dir");
+        
+        // Create a test file
+        create_test_file(&input_path, 100 * 1024).expect("Failed to create test file");
+        
+        // Verify directory doesn't exist yet
+        assert!(!path_exists(&output_dir));
+        
+        let processor = RaptorQProcessor::new(ProcessorConfig::default());
+        let result = processor.encode_file(
+            input_path.to_str().unwrap(),
+            output_dir.to_str().unwrap(),
+            0,
+            false
+        );
+        
+        assert!(result.is_ok());
+        // Verify directory was created
+        assert!(path_exists(&output_dir));
+        
+        // Ensure temp_dir isn't dropped early
+        drop(temp_dir);
+    }
+
+    #[test]
+    fn test_encode_memory_limit_exceeded() {
+        let (temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("file.bin");
+        let output_dir = dir_path.join("output");
+        
+        // Create a test file (5 MB)
+        create_test_file(&input_path, 5 * 1024 * 1024).expect("Failed to create test file");
+        
+        // Create processor with tiny memory limit
+        let config = ProcessorConfig {
+            symbol_size: DEFAULT_SYMBOL_SIZE_B,
+            redundancy_factor: DEFAULT_REDUNDANCY_FACTOR,
+            max_memory_mb: 1, // 1 MB max memory
+            concurrency_limit: 4,
+        };
+        
+        let processor = RaptorQProcessor::new(config);
+        let result = processor.encode_file(
+            input_path.to_str().unwrap(),
+            output_dir.to_str().unwrap(),
+            0, // block_size = 0
+            true // force_single_file = true, bypassing block size determination
+        );
+        
+        assert!(matches!(result, Err(ProcessError::MemoryLimitExceeded { .. })));
+        
+        // Ensure temp_dir isn't dropped early
+        drop(temp_dir);
+    }
+
+    #[test]
+    fn test_encode_concurrency_limit() {
+        let (temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("file.bin");
+        let output_dir = dir_path.join("output");
+        
+        // Create a test file
+        create_test_file(&input_path, 100 * 1024).expect("Failed to create test file");
+        
+        // Create processor with concurrency limit of 1
+        let config = ProcessorConfig {
+            symbol_size: DEFAULT_SYMBOL_SIZE_B,
+            redundancy_factor: DEFAULT_REDUNDANCY_FACTOR,
+            max_memory_mb: DEFAULT_MAX_MEMORY_MB,
+            concurrency_limit: 1,
+        };
+        
+        let processor = Arc::new(RaptorQProcessor::new(config));
+        
+        // Start a task that will keep the processor busy
+        // let processor_clone = Arc::clone(&processor);
+        let input_path_str = input_path.to_str().unwrap().to_string();
+        let output_dir_str = output_dir.to_str().unwrap().to_string();
+        
+        // Manually increment active_tasks to simulate a running task
+        processor.active_tasks.fetch_add(1, Ordering::SeqCst);
+        
+        // Attempt to start another task
+        let result = processor.encode_file(
+            &input_path_str,
+            &output_dir_str,
+            0,
+            false
+        );
+        
+        // This should fail with ConcurrencyLimitReached
+        assert!(matches!(result, Err(ProcessError::ConcurrencyLimitReached)));
+        
+        // Clean up
+        processor.active_tasks.fetch_sub(1, Ordering::SeqCst);
+        
+        // Ensure temp_dir isn't dropped early
+        drop(temp_dir);
+    }
+
+    #[test]
+    fn test_encode_verify_result() {
+        let (temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("file.bin");
+        let output_dir = dir_path.join("output");
+        
+        // Create a test file (200 KB)
+        let file_size = 200 * 1024;
+        create_test_file(&input_path, file_size).expect("Failed to create test file");
+        
+        let processor = RaptorQProcessor::new(ProcessorConfig::default());
+        let result = processor.encode_file(
+            input_path.to_str().unwrap(),
+            output_dir.to_str().unwrap(),
+            0,
+            false
+        );
+        
+        assert!(result.is_ok());
+        let result = result.unwrap();
+        
+        // Verify result fields
+        assert!(result.total_symbols_count > 0);
+        assert!(result.total_repair_symbols > 0);
+        assert_eq!(result.symbols_directory, output_dir.to_str().unwrap());
+        assert!(result.total_symbols_count > 0);
+
+        // Ensure temp_dir isn't dropped early
+        drop(temp_dir);
+    }
+
+    #[test]
+    fn test_encode_verify_symbols() {
+        let (temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("file.bin");
+        let output_dir = dir_path.join("output");
+        let block_dir = output_dir.join("block_0");
+
+        // Create a test file (200 KB)
+        create_test_file(&input_path, 200 * 1024).expect("Failed to create test file");
+        
+        let processor = RaptorQProcessor::new(ProcessorConfig::default());
+        let result = processor.encode_file(
+            input_path.to_str().unwrap(),
+            output_dir.to_str().unwrap(),
+            0,
+            false
+        );
+        
+        assert!(result.is_ok());
+        let result = result.unwrap();
+        
+        // Check that symbol files are created in the output directory
+        // Use count_files_in_dir instead of fs::read_dir
+        let symbol_count = count_files_in_dir(&block_dir);
+        
+        assert!(symbol_count > 0);
+        assert_eq!(symbol_count, result.total_symbols_count as usize);
+        
+        // Ensure temp_dir isn't dropped early
+        drop(temp_dir);
+    }
+
+    // Tests for RaptorQProcessor::decode_symbols
+
+    #[test]
+    fn test_decode_symbols_dir_not_found() {
+        let processor = RaptorQProcessor::new(ProcessorConfig::default());
+        let result = processor.decode_symbols(
+            "non_existent_dir",
+            "output.bin",
+            "layout.json"
+        );
+        
+        assert!(matches!(result, Err(ProcessError::FileNotFound(_))));
+    }
+
+    #[test]
+    fn test_decode_no_symbols_in_dir() {
+        let (temp_dir, dir_path) = create_temp_dir();
+        let symbols_dir = dir_path.join("symbols");
+        let output_path = dir_path.join("output.bin");
+        let layout_path = dir_path.join("layout.json");
+        
+        // Create an empty directory
+        create_dir(&symbols_dir).expect("Failed to create the symbols directory");
+
+        // create fake layout file
+        let config = ObjectTransmissionInformation::with_defaults(1024, 128);
+        let encoder_params = config.serialize().to_vec();
+        let packets = vec![vec![0; 128]; 10];
+        
+        // Create a single BlockLayout for the entire file
+        let block_layout = BlockLayout {
+            block_id: 0,
+            encoder_parameters: encoder_params,
+            original_offset: 0,
+            size: 1024,
+            symbols: packets.iter().enumerate().map(|(i, _)| format!("symbol_{}.bin", i)).collect(),
+            hash: "dummy_hash".to_string(),
+        };
+        
+        let layout = RaptorQLayout {
+            layout_version: LAYOUT_VERSION,
+            blocks: vec![block_layout],
+            object_sha256: String::new(),
+            symbol_codec: SymbolCodec::None,
+            symbol_key_id: String::new(),
+            symbol_crc: false,
+            metadata: BTreeMap::new(),
+        };
+        //write the layout file
+        let layout_json = serde_json::to_string_pretty(&layout).expect("Failed to serialize layout");
+        write_file(&layout_path, layout_json.as_bytes()).expect("Failed to write the layout file");
+
+        let processor = RaptorQProcessor::new(ProcessorConfig::default());
+        let result = processor.decode_symbols(
+            symbols_dir.to_str().unwrap(),
+            output_path.to_str().unwrap(),
+            layout_path.to_str().unwrap()
+        );
+        
+        assert!(matches!(result, Err(ProcessError::InsufficientSymbols(_))));
+        
+        // Ensure temp_dir isn't dropped early
+        drop(temp_dir);
+    }
+
+    #[test]
+    fn test_decode_success_single_file() {
+        let (temp_dir, dir_path) = create_temp_dir();
+        let symbols_dir = dir_path.join("symbols");
+        let output_path = dir_path.join("output.bin");
+        
+        // Create original data
+        let original_data = generate_test_data(100 * 1024);
+        
+        // Create symbols directory
+        create_dir(&symbols_dir).expect("Failed to create symbols directory");
+        
+        // Create encoded symbols
+        let (encoder_params, packets) = encode_test_data(
+            &original_data,
+            DEFAULT_SYMBOL_SIZE_B,
+            10
+        );
+        
+        // Create symbol files
+        create_symbol_files(&symbols_dir, &packets).expect("Failed to create symbol files");
+        
+        // Create layout file
+        let layout_path = symbols_dir.join(LAYOUT_FILENAME);
+        
+        // Create a single BlockLayout for the entire file
+        let block_layout = create_block_layout(&original_data, encoder_params, packets);
+        
+        let layout = RaptorQLayout {
+            layout_version: LAYOUT_VERSION,
+            blocks: vec![block_layout],
+            object_sha256: String::new(),
+            symbol_codec: SymbolCodec::None,
+            symbol_key_id: String::new(),
+            symbol_crc: false,
+            metadata: BTreeMap::new(),
+        };
+        
+        let layout_json = serde_json::to_string_pretty(&layout).expect("Failed to serialize layout");
+        write_file(&layout_path, layout_json.as_bytes()).expect("Failed to write layout file");
+        
+        let processor = RaptorQProcessor::new(ProcessorConfig::default());
+        
+        let result = processor.decode_symbols(
+            symbols_dir.to_str().unwrap(),
+            output_path.to_str().unwrap(),
+            layout_path.to_str().unwrap()
+        );
+        
+        assert!(result.is_ok());
+        
+        // Verify the decoded file matches the original
+        let decoded_data = read_file(&output_path).expect("Failed to read decoded file");
+        assert_eq!(decoded_data, original_data);
+        
+        // Ensure temp_dir isn't dropped early
+        drop(temp_dir);
+    }
+
+    #[test]
+    fn test_decode_success_multi_block_file() {
+        let (temp_dir, dir_path) = create_temp_dir();
+        let symbols_dir = dir_path.join("symbols");
+        let output_path = dir_path.join("output.bin");
+        
+        // Create original data
+        let original_data = generate_test_data(300 * 1024);
+        
+        // Create main symbols directory
+        create_dir(&symbols_dir).expect("Failed to create symbols directory");
+        
+        // Split into blocks (3 blocks of 100KB each)
+        let block_size = 100 * 1024;
+        let mut block_layouts = Vec::with_capacity(3);
+        
+        for i in 0..3 {
+            let block_dir = symbols_dir.join(format!("block_{}", i));
+            create_dir(&block_dir).expect("Failed to create block directory");
+            
+            let start = i * block_size;
+            let end = start + block_size;
+            let block_data = original_data[start..end].to_vec();
+
+            //Get hash of block
+            let block_hash = get_hash_as_b58(&block_data);
+
+            // Encode block
+            let (params, packets) = encode_test_data(
+                &block_data,
+                DEFAULT_SYMBOL_SIZE_B,
+                5
+            );
+
+            // Create symbol files for this block
+            create_symbol_files(&block_dir, &packets).expect("Failed to create symbol files");
+
+            // Create layout file with block information
+            let block_layout = BlockLayout {
+                block_id: i,
+                encoder_parameters: params,
+                original_offset: (i * block_size) as u64,
+                size: block_size as u64,
+                symbols: (0..packets.len()).map(|j| format!("symbol_{}.bin", j)).collect(),
+                hash: block_hash,
+            };
+            block_layouts.push(block_layout);
+        }
+        let layout = RaptorQLayout {
+            layout_version: LAYOUT_VERSION,
+            blocks: block_layouts,
+            object_sha256: String::new(),
+            symbol_codec: SymbolCodec::None,
+            symbol_key_id: String::new(),
+            symbol_crc: false,
+            metadata: BTreeMap::new(),
+        };
+        
+        // Save layout file
+        let layout_path = symbols_dir.join(LAYOUT_FILENAME);
+        let layout_json = serde_json::to_string_pretty(&layout).expect("Failed to serialize layout");
+        write_file(&layout_path, layout_json.as_bytes()).expect("Failed to write layout file");
+        
+        let processor = RaptorQProcessor::new(ProcessorConfig::default());
+        
+        let result = processor.decode_symbols(
+            symbols_dir.to_str().unwrap(),
+            output_path.to_str().unwrap(),
+            layout_path.to_str().unwrap()
+        );
+        
+        assert!(result.is_ok());
+        
+        // Verify the decoded file matches the original
+        let decoded_data = read_file(&output_path).expect("Failed to read decoded file");
+        assert_eq!(decoded_data, original_data);
+        
+        // Ensure temp_dir isn't dropped early
+        drop(temp_dir);
+    }
+
+    #[test]
+    fn test_decode_insufficient_symbols() {
+        let (temp_dir, dir_path) = create_temp_dir();
+        let symbols_dir = dir_path.join("symbols");
+        let output_path = dir_path.join("output.bin");
+        
+        // Create symbols directory
+        create_dir(&symbols_dir).expect("Failed to create symbols directory");
+        
+        // Create original data
+        let original_data = generate_test_data(100 * 1024);
+        
+        // Create encoded symbols (only source symbols, no repair symbols)
+        // This should not be enough for decoding
+        let (encoder_params, mut packets) = encode_test_data(
+            &original_data,
+            DEFAULT_SYMBOL_SIZE_B,
+            10
+        );
+        
+        // Remove all but one symbol to ensure decoding fails
+        if packets.len() > 1 {
+            packets = vec![packets[0].clone()];
+        }
+        
+        // Create symbol files
+        create_symbol_files(&symbols_dir, &packets).expect("Failed to create symbol files");
+        
+        // Create and save layout file
+        let layout_path = symbols_dir.join(LAYOUT_FILENAME);
+        
+        // Create a single BlockLayout for the entire file
+        let block_layout = create_block_layout(&original_data, encoder_params, packets);
+
+        let layout = RaptorQLayout {
+            layout_version: LAYOUT_VERSION,
+            blocks: vec![block_layout],
+            object_sha256: String::new(),
+            symbol_codec: SymbolCodec::None,
+            symbol_key_id: String::new(),
+            symbol_crc: false,
+            metadata: BTreeMap::new(),
+        };
+        
+        let layout_json = serde_json::to_string_pretty(&layout).expect("Failed to serialize layout");
+        write_file(&layout_path, layout_json.as_bytes()).expect("Failed to write layout file");
+        
+        let processor = RaptorQProcessor::new(ProcessorConfig::default());
+        
+        let result = processor.decode_symbols(
+            symbols_dir.to_str().unwrap(),
+            output_path.to_str().unwrap(),
+            layout_path.to_str().unwrap()
+        );
+        
+        match result {
+            Err(ProcessError::InsufficientSymbols(shortfalls)) => {
+                assert_eq!(shortfalls.len(), 1);
+                assert_eq!(shortfalls[0].block_id, 0);
+                assert_eq!(shortfalls[0].present, 1);
+                assert!(shortfalls[0].required > shortfalls[0].present);
+            },
+            other => panic!("Expected InsufficientSymbols, got {:?}", other),
+        }
+        
+        // Ensure temp_dir isn't dropped early
+        drop(temp_dir);
+    }
+
+    #[test]
+    fn test_encode_bytes_matches_encode_file() {
+        let (temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+
+        let original_data = generate_test_data(300 * 1024);
+        write_file(&input_path, &original_data).expect("Failed to write the input file");
+
+        let config = ProcessorConfig {
+            symbol_size: 1024,
+            ..ProcessorConfig::default()
+        };
+        let processor = RaptorQProcessor::new(config);
+
+        let (result, symbols) = processor.encode_bytes(&original_data, 100 * 1024)
+            .expect("Failed to encode the data");
+        assert_eq!(symbols.len() as u64, result.total_symbols_count);
+        assert!(result.symbols_directory.is_empty());
+        assert!(result.layout_file_path.is_empty());
+
+        // Symbols are returned in the layout order, identified by their hash
+        let layout: RaptorQLayout = serde_json::from_str(result.layout_content.as_ref().unwrap())
+            .expect("Failed to parse the layout");
+        let symbol_ids: Vec<&String> = layout.blocks.iter().flat_map(|b| b.symbols.iter()).collect();
+        assert_eq!(symbol_ids.len(), symbols.len());
+        for (id, symbol) in symbol_ids.iter().zip(&symbols) {
+            assert_eq!(**id, get_hash_as_b58(symbol));
+        }
+
+        // Same symbols as when encoding through the filesystem
+        processor.encode_file(
+            input_path.to_str().unwrap(),
+            symbols_dir.to_str().unwrap(),
+            100 * 1024,
+            false
+        ).expect("Failed to encode the file");
+        for block in &layout.blocks {
+            let block_dir = symbols_dir.join(format!("block_{}", block.block_id));
+            for id in &block.symbols {
+                assert!(path_exists(&block_dir.join(id)), "Missing symbol {} on disk", id);
+            }
+        }
+
+        // Ensure temp_dir isn't dropped early
+        drop(temp_dir);
+    }
+
+    // File reader returning at most 1000 bytes per read, of data that may be shorter
+    // than the size of the file
+    struct ShortFileReader {
+        data: Vec<u8>,
+    }
+
+    impl FileReader for ShortFileReader {
+        fn file_size(&self) -> Result<u64, String> {
+            Ok(self.data.len() as u64)
+        }
+
+        fn read_chunk(&mut self, offset: u64, buf: &mut [u8]) -> Result<usize, String> {
+            let start = (offset as usize).min(self.data.len());
+            let len = buf.len().min(1000).min(self.data.len() - start);
+            buf[..len].copy_from_slice(&self.data[start..start + len]);
+            Ok(len)
+        }
+    }
+
+    #[test]
+    fn test_encode_blocks_with_short_reads() {
+        let data = generate_test_data(300 * 1024);
+        let processor = RaptorQProcessor::new(ProcessorConfig {
+            symbol_size: 1024,
+            ..ProcessorConfig::default()
+        });
+        let (expected, expected_symbols) = processor.encode_bytes(&data, 100 * 1024)
+            .expect("Failed to encode the data");
+
+        // The blocks are filled across the short reads, giving the same symbols
+        let encode = |reader: ShortFileReader, total_size: usize| {
+            let mut symbols = Vec::new();
+            let mut collect = |symbol: EncodedSymbol| {
+                symbols.push(symbol.data);
+                Ok(())
+            };
+            processor.process_file_blocks(
+                Box::new(reader), "", 100 * 1024, 1024, total_size,
+                false, true, "", Some(&mut collect), false, None, processor.start_cancellation(),
+            ).map(|result| (result, symbols))
+        };
+        let (result, symbols) = encode(ShortFileReader { data: data.clone() }, data.len())
+            .expect("Failed to encode with short reads");
+        assert_eq!(result.layout_content, expected.layout_content);
+        assert_eq!(symbols, expected_symbols);
+
+        // A file ending before its last block fails instead of encoding stale bytes
+        let truncated = ShortFileReader { data: data[..250 * 1024].to_vec() };
+        match encode(truncated, data.len()) {
+            Err(ProcessError::IOError(e)) => {
+                assert_eq!(e.kind(), io::ErrorKind::UnexpectedEof);
+                assert!(e.to_string().contains("before the end of the block"), "{}", e);
+            }
+            other => panic!("Expected an end of file error, got {:?}", other.map(|(r, _)| r)),
+        }
+    }
+
+    // Reader returning at most `max_read` bytes per call, failing after `fail_after` bytes if set
+    struct ChoppyReader {
+        data: Vec<u8>,
+        position: usize,
+        max_read: usize,
+        fail_after: Option<usize>,
+    }
+
+    impl io::Read for ChoppyReader {
+        fn read(&mut self, buf: &mut [u8]) -> io::Result<usize> {
+            if let Some(limit) = self.fail_after {
+                if self.position >= limit {
+                    return Err(io::Error::new(io::ErrorKind::ConnectionReset, "connection reset"));
+                }
+            }
+            let len = buf.len().min(self.max_read).min(self.data.len() - self.position);
+            buf[..len].copy_from_slice(&self.data[self.position..self.position + len]);
+            self.position += len;
+            Ok(len)
+        }
+    }
+
+    #[test]
+    fn test_encode_stream_matches_encode_bytes() {
+        let (temp_dir, dir_path) = create_temp_dir();
+        let symbols_dir = dir_path.join("symbols");
+        let output_path = dir_path.join("output.bin");
+
+        let config = ProcessorConfig {
+            symbol_size: 1024,
+            ..ProcessorConfig::default()
+        };
+        let processor = RaptorQProcessor::new(config);
+
+        // Last block is shorter than the others
+        let original_data = generate_test_data(250 * 1024 + 17);
+        let reader = ChoppyReader { data: original_data.clone(), position: 0, max_read: 1000, fail_after: None };
+        let result = processor.encode_stream(reader, symbols_dir.to_str().unwrap(), 100 * 1024)
+            .expect("Failed to encode the stream");
+
+        let blocks = result.blocks.as_ref().unwrap();
+        assert_eq!(blocks.len(), 3);
+        assert_eq!(blocks[2].original_offset, 200 * 1024);
+        assert_eq!(blocks[2].size, 50 * 1024 + 17);
+
+        let (bytes_result, _) = processor.encode_bytes(&original_data, 100 * 1024)
+            .expect("Failed to encode the data");
+        assert_eq!(result.total_symbols_count, bytes_result.total_symbols_count);
+        assert_eq!(result.total_repair_symbols, bytes_result.total_repair_symbols);
+
+        // Symbols and layout on disk decode back to the original data
+        processor.decode_symbols(
+            symbols_dir.to_str().unwrap(),
+            output_path.to_str().unwrap(),
+            &result.layout_file_path
+        ).expect("Failed to decode the symbols");
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
+
+        // Ensure temp_dir isn't dropped early
+        drop(temp_dir);
+    }
+
+    #[test]
+    fn test_encode_output_is_deterministic() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let original_data = generate_test_data(200 * 1024 + 17);
+        write_file(&input_path, &original_data).unwrap();
+
+        // SHA-256 of every file of an output directory, by path relative to it
+        fn output_hashes(dir: &Path) -> BTreeMap<PathBuf, String> {
+            let mut hashes = BTreeMap::new();
+            let mut dirs = vec![dir.to_path_buf()];
+            while let Some(current) = dirs.pop() {
+                for entry in std::fs::read_dir(&current).unwrap() {
+                    let path = entry.unwrap().path();
+                    if path.is_dir() {
+                        dirs.push(path);
+                    } else {
+                        let digest = Sha256::digest(std::fs::read(&path).unwrap());
+                        hashes.insert(path.strip_prefix(dir).unwrap().to_path_buf(), format!("{:x}", digest));
+                    }
+                }
+            }
+            hashes
+        }
+
+        for (codec, key) in [(SymbolCodec::None, None), (SymbolCodec::Gzip, Some([3u8; 32]))] {
+            let mut outputs = Vec::new();
+            for concurrency_limit in [1, 8] {
+                let mut builder = RaptorQProcessor::builder()
+                    .symbol_size(1024)
+                    .concurrency_limit(concurrency_limit)
+                    .symbol_codec(codec);
+                if let Some(key) = &key {
+                    builder = builder.symbol_key(key);
+                }
+                let processor = builder.build().unwrap();
+
+                for run in 0..2 {
+                    let file_dir = dir_path.join(format!("file_{:?}_{}_{}", codec, concurrency_limit, run));
+                    processor.encode_file(input_path.to_str().unwrap(), file_dir.to_str().unwrap(), 20 * 1024, false).unwrap();
+                    outputs.push(output_hashes(&file_dir));
+
+                    // Blocks encoded by up to 8 workers
+                    let reader_dir = dir_path.join(format!("reader_{:?}_{}_{}", codec, concurrency_limit, run));
+                    processor.encode_reader_at(&original_data, original_data.len() as u64, reader_dir.to_str().unwrap(), 20 * 1024).unwrap();
+                    outputs.push(output_hashes(&reader_dir));
+                }
+            }
+
+            assert_eq!(outputs[0].len(), RaptorQLayout::read_file(
+                dir_path.join(format!("file_{:?}_1_0", codec)).join(LAYOUT_FILENAME).to_str().unwrap()
+            ).unwrap().symbols_count() as usize + 1);
+            for (index, output) in outputs.iter().enumerate() {
+                assert_eq!(output, &outputs[0], "{:?}, output {}", codec, index);
+            }
+        }
+    }
+
+    #[test]
+    fn test_encode_reader_at() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let output_path = dir_path.join("output.bin");
+        // Blocks with different data, to catch a block encoded from the wrong range
+        let original_data: Vec<u8> = (0..100 * 1024 + 17).map(|i| (i * 31 / 7 % 251) as u8).collect();
+        write_file(&input_path, &original_data).unwrap();
+
+        let config = ProcessorConfig { symbol_size: 1024, concurrency_limit: 4, ..ProcessorConfig::default() };
+        let processor = RaptorQProcessor::new(config);
+
+        // Same layout as encoding the file, from memory and from the file itself
+        let file_dir = dir_path.join("file");
+        let file_result = processor.encode_file(input_path.to_str().unwrap(), file_dir.to_str().unwrap(), 10 * 1024, false).unwrap();
+        let expected = RaptorQLayout::read_file(&file_result.layout_file_path).unwrap();
+        assert_eq!(expected.blocks.len(), 11);
+
+        let file = std::fs::File::open(&input_path).unwrap();
+        let sources: [(&str, &(dyn ReadAt + Sync)); 2] = [("memory", &original_data), ("file", &file)];
+        for (name, source) in sources {
+            let symbols_dir = dir_path.join(name);
+            let result = processor.encode_reader_at(source, original_data.len() as u64, symbols_dir.to_str().unwrap(), 10 * 1024).unwrap();
+            assert_eq!(processor.active_tasks.load(Ordering::SeqCst), 0, "Worker slots should be released");
+            assert_eq!(result.total_symbols_count, file_result.total_symbols_count);
+            assert_eq!(result.total_repair_symbols, file_result.total_repair_symbols);
+            assert_eq!(RaptorQLayout::read_file(&result.layout_file_path).unwrap(), expected, "{}", name);
+
+            processor.decode_symbols(
+                symbols_dir.to_str().unwrap(),
+                output_path.to_str().unwrap(),
+                &result.layout_file_path,
+            ).unwrap();
+            assert_eq!(read_file(&output_path).unwrap(), original_data);
+        }
+
+        // Without a free slot for workers the blocks are encoded in turn
+        processor.active_tasks.fetch_add(3, Ordering::SeqCst);
+        let symbols_dir = dir_path.join("sequential");
+        let result = processor.encode_reader_at(&original_data, original_data.len() as u64, symbols_dir.to_str().unwrap(), 10 * 1024).unwrap();
+        assert_eq!(RaptorQLayout::read_file(&result.layout_file_path).unwrap(), expected);
+        processor.active_tasks.fetch_sub(3, Ordering::SeqCst);
+
+        // The source is shorter than the given size
+        let symbols_dir = dir_path.join("short");
+        let result = processor.encode_reader_at(&original_data, original_data.len() as u64 + 1, symbols_dir.to_str().unwrap(), 10 * 1024);
+        match result {
+            Err(ProcessError::IOError(e)) => assert_eq!(e.kind(), io::ErrorKind::UnexpectedEof),
+            other => panic!("Unexpected result {:?}", other),
+        }
+        assert!(!symbols_dir.join(LAYOUT_FILENAME).exists());
+        assert_eq!(processor.active_tasks.load(Ordering::SeqCst), 0);
+
+        let result = processor.encode_reader_at(&original_data, 0, symbols_dir.to_str().unwrap(), 0);
+        assert!(matches!(result, Err(ProcessError::EncodingFailed(_))), "Unexpected result {:?}", result);
+    }
+
+    #[test]
+    fn test_encode_stream_default_block_size() {
+        let (temp_dir, dir_path) = create_temp_dir();
+        let symbols_dir = dir_path.join("symbols");
+        let output_path = dir_path.join("output.bin");
+
+        // 1 MB of memory bounds the blocks to 174080 bytes, a multiple of the symbol size
+        let config = ProcessorConfig {
+            symbol_size: 1024,
+            max_memory_mb: 1,
+            ..ProcessorConfig::default()
+        };
+        let processor = RaptorQProcessor::new(config);
+
+        let original_data = generate_test_data(400 * 1024);
+        let reader = ChoppyReader { data: original_data.clone(), position: 0, max_read: 4096, fail_after: None };
+        let result = processor.encode_stream(reader, symbols_dir.to_str().unwrap(), 0)
+            .expect("Failed to encode the stream");
+
+        let blocks = result.blocks.as_ref().unwrap();
+        assert_eq!(blocks.len(), 3);
+        assert!(blocks.iter().all(|b| b.size <= 174080));
+        assert_eq!(blocks[1].original_offset, 174080);
+
+        processor.decode_symbols(
+            symbols_dir.to_str().unwrap(),
+            output_path.to_str().unwrap(),
+            &result.layout_file_path
+        ).expect("Failed to decode the symbols");
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
+
+        // Ensure temp_dir isn't dropped early
+        drop(temp_dir);
+    }
+
+    #[test]
+    fn test_encode_stream_reader_error() {
+        let (temp_dir, dir_path) = create_temp_dir();
+        let symbols_dir = dir_path.join("symbols");
+
+        let processor = RaptorQProcessor::new(ProcessorConfig::default());
+        let reader = ChoppyReader {
+            data: generate_test_data(10 * 1024),
+            position: 0,
+            max_read: 1024,
+            fail_after: Some(4096),
         };
+        let result = processor.encode_stream(reader, symbols_dir.to_str().unwrap(), 0);
+        assert!(matches!(result, Err(ProcessError::IOError(_))));
+        assert!(processor.get_last_error().contains("connection reset"));
 
-        Ok((file_reader, file_size, actual_block_size))
+        // Ensure temp_dir isn't dropped early
+        drop(temp_dir);
     }
 
-    /// Process file blocks for encoding or metadata creation
-    ///
-    /// This method handles both creating actual symbols or just generating metadata
-    /// Process file blocks for encoding or metadata creation.
-    /// If `metadata_only` is true, only layout is created (no symbols written).
-    /// If `return_layout` is true, returns layout as object; else, writes to the specified file.
-    fn process_file_blocks(
-        &self,
-        mut source_reader: Box<dyn FileReader>,
-        output_dir: &str,
-        block_size: usize,
-        total_size: usize,
-        metadata_only: bool,
-        return_layout: bool,
-        layout_file: &str,
-    ) -> Result<ProcessResult, ProcessError> {
-        let dir_manager = file_io::get_dir_manager();
+    // Reader cancelling the processor once `cancel_after` bytes were read
+    struct CancellingReader<'a> {
+        processor: &'a RaptorQProcessor,
+        data: Vec<u8>,
+        position: usize,
+        cancel_after: usize,
+    }
 
-        let base_output_path = Path::new(output_dir);
+    impl<'a> io::Read for CancellingReader<'a> {
+        fn read(&mut self, buf: &mut [u8]) -> io::Result<usize> {
+            let len = buf.len().min(self.data.len() - self.position);
+            buf[..len].copy_from_slice(&self.data[self.position..self.position + len]);
+            self.position += len;
+            if self.position >= self.cancel_after {
+                self.processor.cancel();
+            }
+            Ok(len)
+        }
+    }
 
-        // Calculate the number of blocks
-        let block_count = if block_size >= total_size {
-            1
-        } else {
-            (total_size as f64 / block_size as f64).ceil() as usize
+    #[test]
+    fn test_encode_stream_cancelled() {
+        let (temp_dir, dir_path) = create_temp_dir();
+        let symbols_dir = dir_path.join("symbols");
+
+        let config = ProcessorConfig {
+            symbol_size: 1024,
+            ..ProcessorConfig::default()
+        };
+        let processor = RaptorQProcessor::new(config);
+        let reader = CancellingReader {
+            processor: &processor,
+            data: generate_test_data(300 * 1024),
+            position: 0,
+            cancel_after: 100 * 1024,
         };
+        let result = processor.encode_stream(reader, symbols_dir.to_str().unwrap(), 100 * 1024);
+        assert!(matches!(result, Err(ProcessError::Cancelled)));
 
-        debug!("File will be split into {} blocks", block_count);
+        // Stopped before the second block
+        assert!(path_exists(&symbols_dir.join("block_0")));
+        assert!(!path_exists(&symbols_dir.join("block_1")));
+        assert!(!path_exists(&symbols_dir.join(LAYOUT_FILENAME)));
 
-        // Process each block
-        let mut blocks = Vec::with_capacity(block_count);
-        let mut block_layouts = Vec::with_capacity(block_count);
-        let mut total_symbols_count = 0;
-        let mut total_repair_symbols = 0;
-
-        for block_index in 0..block_count {
-            let block_id = block_index;
-            let block_dir = base_output_path.join(format!("block_{}", block_index));
-            if !metadata_only && !output_dir.is_empty() {
-                let block_dir_path = block_dir.to_string_lossy().to_string();
-                dir_manager.create_dir_all(&block_dir_path).map_err(|e| {
-                    ProcessError::IOError(io::Error::new(io::ErrorKind::Other, e))
-                })?;
-            }
+        // Ensure temp_dir isn't dropped early
+        drop(temp_dir);
+    }
 
-            let actual_offset = (block_index * block_size) as u64;
-            let remaining = total_size - actual_offset as usize;
-            if remaining <= 0 {
-                break;
-            }
-            let actual_block_size = std::cmp::min(block_size, remaining);
-            let repair_symbols = self.calculate_repair_symbols(actual_block_size as u64);
-            total_repair_symbols += repair_symbols;
+    #[test]
+    fn test_cancel_does_not_affect_later_operations() {
+        let processor = RaptorQProcessor::new(ProcessorConfig::default());
+        processor.cancel();
 
-            debug!(
-                "Processing block {} of {} bytes at offset {}",
-                block_index, actual_block_size, actual_offset
-            );
+        let data = generate_test_data(10 * 1024);
+        let (result, symbols) = processor.encode_bytes(&data, 0).expect("Failed to encode the data");
+        let decoded = processor.decode_bytes(&symbols, result.layout_content.unwrap().as_bytes())
+            .expect("Failed to decode the data");
+        assert_eq!(decoded, data);
+    }
 
-            // Read this block into memory directly
-            let mut block_data = vec![0u8; actual_block_size];
-            source_reader
-                .read_chunk(actual_offset, &mut block_data)
-                .map_err(|e| ProcessError::IOError(io::Error::new(io::ErrorKind::Other, e)))?;
+    #[test]
+    fn test_timeout() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let output_path = dir_path.join("output.bin");
+        let original_data = generate_test_data(50 * 1024);
+        write_file(&input_path, &original_data).unwrap();
+
+        let processor = RaptorQProcessor::builder()
+            .symbol_size(1024)
+            .timeout(Duration::from_nanos(1))
+            .build()
+            .unwrap();
+
+        // Over the limit before the first block
+        let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 10 * 1024, false);
+        assert!(matches!(result, Err(ProcessError::TimedOut(_))), "Unexpected result {:?}", result);
+        assert!(!symbols_dir.join(LAYOUT_FILENAME).exists(), "No layout should be written");
+        assert!(processor.get_last_error().contains("timed out"));
+
+        processor.set_timeout(None);
+        let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 10 * 1024, false).unwrap();
+
+        processor.set_timeout(Some(Duration::from_nanos(1)));
+        let decode = processor.decode_symbols(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path);
+        assert!(matches!(decode, Err(ProcessError::TimedOut(_))), "Unexpected result {:?}", decode);
+
+        // A generous limit doesn't get in the way
+        processor.set_timeout(Some(Duration::from_secs(600)));
+        processor.decode_symbols(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path).unwrap();
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
+    }
 
-            // Process this block
-            let (params, symbol_ids, hash) = self.encode_block(
-                &mut block_data,
-                actual_block_size as u64,
-                repair_symbols,
-                &block_dir,
-                metadata_only,
-            )?;
-
-            // Add to BlockInfo for ProcessResult
-            blocks.push(BlockInfo {
-                block_id,
-                encoder_parameters: params.clone(),
-                original_offset: actual_offset,
-                size: actual_block_size as u64,
-                symbols_count: symbol_ids.len() as u64,
-                source_symbols_count: symbol_ids.len() as u64 - repair_symbols,
-                hash: hash.clone(),
-            });
+    #[test]
+    fn test_cleanup_on_error() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        write_file(&input_path, &generate_test_data(50 * 1024)).unwrap();
 
-            // Add to BlockLayout for the metadata file
-            block_layouts.push(BlockLayout {
-                block_id,
-                encoder_parameters: params.clone(),
-                original_offset: actual_offset,
-                size: actual_block_size as u64,
-                symbols: symbol_ids.clone(), // Clone to avoid ownership issues
-                hash,
-            });
+        // Writing the symbols of the third block fails
+        std::fs::create_dir_all(&symbols_dir).unwrap();
+        write_file(&symbols_dir.join(block_dir_name(2)), b"").unwrap();
 
-            total_symbols_count += symbol_ids.len() as u64;
-            // No need to seek - we'll just read the next block at its offset
+        let processor = RaptorQProcessor::builder().symbol_size(1024).build().unwrap();
+        let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 10 * 1024, false);
+        assert!(matches!(result, Err(ProcessError::IOError(_))), "Unexpected result {:?}", result);
+        for block_id in 0..2 {
+            assert!(!symbols_dir.join(block_dir_name(block_id)).exists(), "Block {} should be removed", block_id);
         }
+        assert!(symbols_dir.join(block_dir_name(2)).is_file(), "Only block directories should be removed");
+        assert!(!symbols_dir.join(LAYOUT_FILENAME).exists());
+
+        let processor = RaptorQProcessor::builder().symbol_size(1024).cleanup_on_error(false).build().unwrap();
+        let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 10 * 1024, false);
+        assert!(matches!(result, Err(ProcessError::IOError(_))), "Unexpected result {:?}", result);
+        for block_id in 0..2 {
+            assert!(symbols_dir.join(block_dir_name(block_id)).is_dir(), "Block {} should be kept", block_id);
+        }
+        assert!(!symbols_dir.join(LAYOUT_FILENAME).exists());
+
+        // Cancelled encodes are cleaned up as well
+        std::fs::remove_dir_all(&symbols_dir).unwrap();
+        processor.set_cleanup_on_error(true);
+        processor.set_timeout(Some(Duration::from_nanos(1)));
+        let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 10 * 1024, false);
+        assert!(matches!(result, Err(ProcessError::TimedOut(_))), "Unexpected result {:?}", result);
+        assert!(!symbols_dir.join(block_dir_name(0)).exists());
+    }
 
-        // Create layout information to save
-        let layout = RaptorQLayout {
-            blocks: block_layouts,
-        };
-
-        // Generate the layout JSON
-        let layout_json = match serde_json::to_string_pretty(&layout) {
-            Ok(json) => json,
-            Err(e) => {
-                let err = format!("Failed to serialize layout information: {}", e);
-                self.set_last_error(err.clone());
-                return Err(ProcessError::EncodingFailed(err));
+    #[test]
+    fn test_decode_cleanup_on_error() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let output_path = dir_path.join("output.bin");
+        write_file(&input_path, &generate_test_data(30 * 1024)).unwrap();
+
+        // 3 blocks of 10 source symbols, the second and the third ones short of symbols
+        let processor = RaptorQProcessor::builder().symbol_size(1024).build().unwrap();
+        let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 10 * 1024, false).unwrap();
+        let layout = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+        for block in &layout.blocks[1..] {
+            for symbol_id in &block.symbols[5..] {
+                std::fs::remove_file(symbols_dir.join(block_dir_name(block.block_id)).join(symbol_id)).unwrap();
             }
-        };
+        }
 
-        let layout_path_str;
-        let symbols_directory = output_dir.to_string();
-        if !return_layout && !layout_file.is_empty() {
-            // Save layout information to the specified file
-            let layout_path = Path::new(layout_file);
-            layout_path_str = layout_path.to_string_lossy().to_string();
-            let mut writer = file_io::open_file_writer(&layout_path_str)
-                .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
-            writer
-                .write_chunk(0, layout_json.as_bytes())
-                .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
-            writer
-                .flush()
-                .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
-            debug!("Saved the layout file at {:?}", layout_path);
-        } else {
-            // Return layout as object, no file written
-            layout_path_str = String::new();
+        // The decode stops at the second block and removes the output
+        let decode = || processor.decode_symbols(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path);
+        match decode() {
+            Err(ProcessError::InsufficientSymbols(shortfalls)) => {
+                assert_eq!(shortfalls, vec![BlockShortfall { block_id: 1, present: 5, required: 10 }]);
+            },
+            other => panic!("Expected InsufficientSymbols, got {:?}", other),
         }
+        assert_eq!(processor.get_last_shortfalls().len(), 1);
+        assert!(!output_path.exists(), "The partial output should be removed");
 
-        let mut result = ProcessResult {
-            total_symbols_count,
-            total_repair_symbols,
-            symbols_directory,
-            blocks: Some(blocks),
-            layout_file_path: layout_path_str,
-            layout_content: None,
-        };
+        // Off, the blocks decoded before are kept
+        processor.set_cleanup_on_error(false);
+        assert!(matches!(decode(), Err(ProcessError::InsufficientSymbols(_))));
+        assert_eq!(read_file(&output_path).unwrap(), read_file(&input_path).unwrap()[..10 * 1024]);
+    }
 
-        // If we're returning the layout directly, include it in the result
-        if return_layout {
-            result.layout_content = Some(layout_json);
+    #[test]
+    fn test_decode_dir() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let other_dir = dir_path.join("other");
+        let output_path = dir_path.join("output.bin");
+        let data = generate_test_data(25_000);
+        let other_data = generate_test_data(12_000);
+
+        let processor = RaptorQProcessor::builder().symbol_size(1024).build().unwrap();
+        write_file(&input_path, &data).unwrap();
+        processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 10_000, false).unwrap();
+        write_file(&input_path, &other_data).unwrap();
+        processor.encode_file(input_path.to_str().unwrap(), other_dir.to_str().unwrap(), 0, false).unwrap();
+
+        // The layout of the directory wins over the one given
+        let other_layout = other_dir.join(LAYOUT_FILENAME);
+        processor
+            .decode_dir(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), Some(other_layout.to_str().unwrap()))
+            .expect("Decoding should succeed");
+        assert_eq!(read_file(&output_path).unwrap(), data);
+
+        // The given layout is used for a directory without one
+        let moved_layout = dir_path.join("layout.json");
+        std::fs::rename(symbols_dir.join(LAYOUT_FILENAME), &moved_layout).unwrap();
+        processor
+            .decode_dir(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), Some(moved_layout.to_str().unwrap()))
+            .expect("Decoding with the given layout should succeed");
+        assert_eq!(read_file(&output_path).unwrap(), data);
+
+        let result = processor.decode_dir(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), None);
+        assert!(matches!(result, Err(ProcessError::FileNotFound(_))), "Unexpected result {:?}", result);
+        assert!(processor.get_last_error().contains("has no _raptorq_layout.json file"));
+    }
+
+    #[test]
+    fn test_decode_from_dirs() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let first_dir = dir_path.join("first");
+        let second_dir = dir_path.join("second");
+        let original_data: Vec<u8> = (0..35 * 1024).map(|i| (i * 31 / 7 % 251) as u8).collect();
+        write_file(&input_path, &original_data).unwrap();
+
+        let processor = RaptorQProcessor::builder().symbol_size(1024).build().unwrap();
+        let result = processor.encode_file(input_path.to_str().unwrap(), first_dir.to_str().unwrap(), 10 * 1024, false).unwrap();
+        let layout = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+
+        // Each directory has about half of the source symbols of every block, a few in both,
+        // the second one without block directories
+        std::fs::create_dir_all(&second_dir).unwrap();
+        for block in &layout.blocks {
+            let source_symbols = block.source_symbols_count() as usize;
+            let block_dir = first_dir.join(block_dir_name(block.block_id));
+            for (index, symbol_id) in block.symbols.iter().enumerate() {
+                if index >= source_symbols / 2 - 1 && index < source_symbols {
+                    std::fs::copy(block_dir.join(symbol_id), second_dir.join(symbol_id)).unwrap();
+                }
+                if index > source_symbols / 2 {
+                    std::fs::remove_file(block_dir.join(symbol_id)).unwrap();
+                }
+            }
         }
 
-        Ok(result)
-    }
+        let output_path = dir_path.join("output.bin");
+        let dirs = [first_dir.to_str().unwrap(), second_dir.to_str().unwrap()];
+        processor.decode_from_dirs(&dirs, output_path.to_str().unwrap(), &result.layout_file_path).unwrap();
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
 
-    fn encode_block(
-        &self,
-        data: &[u8],
-        data_size: u64,
-        repair_symbols: u64,
-        output_path: &Path,
-        metadata_only: bool,
-    ) -> Result<(Vec<u8>, Vec<String>, String), ProcessError> {
-        //get hash of the data
-        let hash_hex = get_hash_as_b58(data);
+        for dir in dirs {
+            let result = processor.decode_from_dirs(&[dir], output_path.to_str().unwrap(), &result.layout_file_path);
+            assert!(matches!(result, Err(ProcessError::InsufficientSymbols(_))), "Unexpected result {:?}", result);
+        }
 
-        // Create object transmission information
-        let config = ObjectTransmissionInformation::with_defaults(
-            data_size,
-            self.config.symbol_size,
+        let missing_dir = dir_path.join("missing");
+        let result = processor.decode_from_dirs(
+            &[first_dir.to_str().unwrap(), missing_dir.to_str().unwrap()],
+            output_path.to_str().unwrap(),
+            &result.layout_file_path,
         );
+        assert!(matches!(result, Err(ProcessError::InvalidPath(_))), "Unexpected result {:?}", result);
 
-        // Encode the data
-        debug!("Encoding {} bytes of data with {} repair symbols",
-               data.len(), repair_symbols);
+        let result = processor.decode_from_dirs(&[], output_path.to_str().unwrap(), first_dir.join(LAYOUT_FILENAME).to_str().unwrap());
+        assert!(matches!(result, Err(ProcessError::InvalidParameter(_))), "Unexpected result {:?}", result);
+    }
 
-        let encoder = Encoder::new(data, config);
-        let symbols = encoder.get_encoded_packets(repair_symbols as u32);
+    #[test]
+    fn test_decode_from_files() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let original_data: Vec<u8> = (0..35 * 1024).map(|i| (i * 31 / 7 % 251) as u8).collect();
+        write_file(&input_path, &original_data).unwrap();
+
+        let processor = RaptorQProcessor::builder().symbol_size(1024).build().unwrap();
+        let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 10 * 1024, false).unwrap();
+        let layout = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+
+        // The last source symbol of each block is replaced by its first repair symbol
+        let mut symbol_paths = Vec::new();
+        for block in &layout.blocks {
+            let source_symbols = block.source_symbols_count() as usize;
+            let block_dir = symbols_dir.join(block_dir_name(block.block_id));
+            for (index, symbol_id) in block.symbols.iter().enumerate() {
+                if index + 1 < source_symbols || index == source_symbols {
+                    symbol_paths.push(block_dir.join(symbol_id).to_string_lossy().to_string());
+                }
+            }
+        }
+        // Files unknown to the layout, missing or listed twice are skipped
+        let stale_path = dir_path.join("stale");
+        write_file(&stale_path, b"not a symbol").unwrap();
+        let mut paths: Vec<&str> = vec![stale_path.to_str().unwrap(), symbol_paths[0].as_str()];
+        paths.extend(symbol_paths.iter().map(String::as_str));
+        let missing_path = dir_path.join("missing").join(&layout.blocks[0].symbols[0]);
+        paths.push(missing_path.to_str().unwrap());
 
-        // Generate symbol ids (and write symbols to disk if not metadata_only)
-        let mut symbol_ids = Vec::with_capacity(symbols.len());
+        let output_path = dir_path.join("output.bin");
+        processor.decode_from_files(&paths, output_path.to_str().unwrap(), &result.layout_file_path).unwrap();
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
+
+        // Without the repair symbol of the second block
+        let repair_id = &layout.blocks[1].symbols[layout.blocks[1].source_symbols_count() as usize];
+        let paths: Vec<&str> = symbol_paths.iter().map(String::as_str).filter(|p| !p.ends_with(repair_id.as_str())).collect();
+        let result = processor.decode_from_files(&paths, output_path.to_str().unwrap(), &result.layout_file_path);
+        match result {
+            Err(ProcessError::InsufficientSymbols(shortfalls)) => {
+                assert_eq!(shortfalls.iter().map(|s| s.block_id).collect::<Vec<_>>(), vec![1]);
+            },
+            other => panic!("Expected InsufficientSymbols, got {:?}", other),
+        }
+    }
 
-        for symbol in &symbols {
-            let packet = symbol.serialize();
-            let symbol_id = self.calculate_symbol_id(&packet);
-            
-            // Only write the symbols to disk if we're not in metadata_only mode
-            if !metadata_only {
-                let output_file_path = output_path.join(&symbol_id);
-                let path_str = output_file_path.to_string_lossy().to_string();
-                let mut writer = file_io::open_file_writer(&path_str)
-                    .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
-                writer.write_chunk(0, &packet)
-                    .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
-                writer.flush()
-                    .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
+    #[test]
+    fn test_flat_symbol_layout() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let original_data: Vec<u8> = (0..50 * 1024).map(|i| (i * 31 / 7 % 251) as u8).collect();
+        write_file(&input_path, &original_data).unwrap();
+
+        let processor = RaptorQProcessor::builder().symbol_size(1024).flat_symbol_layout(true).build().unwrap();
+        let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 10 * 1024, false).unwrap();
+        assert!(!symbols_dir.join(block_dir_name(0)).exists(), "No block directory should be written");
+        let layout = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+        assert_eq!(layout.blocks.len(), 5);
+        for block in &layout.blocks {
+            for symbol_id in &block.symbols {
+                assert!(symbols_dir.join(symbol_id).is_file(), "Symbol {} should be in the symbols directory", symbol_id);
             }
-
-            symbol_ids.push(symbol_id);
         }
 
-        Ok((encoder.get_config().serialize().to_vec(), symbol_ids, hash_hex))
+        // Decoding finds the symbols whatever the layout of the processor
+        let output_path = dir_path.join("output.bin");
+        let nested = RaptorQProcessor::builder().symbol_size(1024).build().unwrap();
+        nested.decode_symbols(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path).unwrap();
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
+
+        // A failed encode removes the symbols of its completed and failed blocks
+        let failed_dir = dir_path.join("failed");
+        let obstacle = failed_dir.join(&layout.blocks[1].symbols[2]);
+        std::fs::create_dir_all(&obstacle).unwrap();
+        let result = processor.encode_file(input_path.to_str().unwrap(), failed_dir.to_str().unwrap(), 10 * 1024, false);
+        assert!(matches!(result, Err(ProcessError::IOError(_))), "Unexpected result {:?}", result);
+        let left: Vec<_> = std::fs::read_dir(&failed_dir).unwrap().map(|e| e.unwrap().path()).collect();
+        assert_eq!(left, vec![obstacle]);
     }
 
-    /// Decode RaptorQ symbols to recreate the original file, using a layout file path
-    ///
-    /// This function reads the RaptorQ layout information from the specified file path,
-    /// which contains encoding parameters and blocks metadata.
-    ///
-    /// # Arguments
-    ///
-    /// * `symbols_dir` - Path to the directory containing the symbol files
-    /// * `output_path` - Path where the decoded file will be written
-    /// * `layout_path` - Path to the layout JSON file that contains encoding parameters and blocks information
-    ///
-    /// # Returns
-    ///
-    /// * `Ok(())` on successful decoding
-    /// * `Err(ProcessError)` on error (e.g., file not found, decoding failed)
-    pub fn decode_symbols(
-        &self,
-        symbols_dir: &str,
-        output_path: &str,
-        layout_path: &str,
-    ) -> Result<(), ProcessError> {
-        // Check if we can take another task and guard is done in the decode_symbols_with_layout
+    #[test]
+    fn test_auto_symbol_size() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        // Two full blocks and a tail of 100 bytes
+        let original_data: Vec<u8> = (0..40 * 1024 + 100).map(|i| (i * 31 / 7 % 251) as u8).collect();
+        write_file(&input_path, &original_data).unwrap();
+
+        let processor = RaptorQProcessor::builder().symbol_size(1024).auto_symbol_size(true).build().unwrap();
+        let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 20 * 1024, false).unwrap();
+        let layout = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+        let symbol_sizes: Vec<u16> = layout.blocks.iter().map(|b| b.symbol_size()).collect();
+        assert_eq!(symbol_sizes, vec![1024, 1024, 104]);
+        assert_eq!(layout.blocks[2].source_symbols_count(), 1);
+        for symbol_id in &layout.blocks[2].symbols {
+            let path = symbols_dir.join(block_dir_name(2)).join(symbol_id);
+            assert_eq!(std::fs::metadata(path).unwrap().len(), 104 + 4);
+        }
 
-        let (mut file_reader, file_size) = match self.open_and_validate_file(layout_path) {
-            Ok(result) => result,
-            Err(e) => {
-                self.set_last_error(e.to_string());
-                return Err(e);
-            }
-        };
+        // A processor without the option decodes with the symbol sizes of the layout
+        let output_path = dir_path.join("output.bin");
+        let plain = RaptorQProcessor::builder().symbol_size(1024).build().unwrap();
+        plain.decode_symbols(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path).unwrap();
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
+
+        // The tail keeps its number of source symbols with a smaller size
+        let (result, _) = processor.encode_bytes(&original_data[..2500], 0).unwrap();
+        let block = &result.layout.unwrap().blocks[0];
+        assert_eq!((block.symbol_size(), block.source_symbols_count()), (840, 3));
+
+        let estimate = processor.estimate_storage(original_data.len() as u64, 20 * 1024).unwrap();
+        let stored: u64 = layout.blocks.iter()
+            .flat_map(|b| b.symbols.iter().map(|id| symbols_dir.join(block_dir_name(b.block_id)).join(id)))
+            .map(|path| std::fs::metadata(path).unwrap().len())
+            .sum();
+        assert_eq!(estimate.symbol_bytes, stored);
+
+        let plan = processor.plan_encode(input_path.to_str().unwrap(), 20 * 1024).unwrap();
+        assert_eq!(plan, layout.summary());
+
+        processor.set_auto_symbol_size(false);
+        let (result, _) = processor.encode_bytes(&original_data[..2500], 0).unwrap();
+        assert_eq!(result.layout.unwrap().blocks[0].symbol_size(), 1024);
+    }
 
-        let mut layout_content_bytes = vec![0; file_size];
-        match file_reader.read_chunk(0, &mut layout_content_bytes) {
-            Ok(_) => {},
-            Err(e) => {
-                let err = format!("Failed to read the layout file: {}", e);
-                self.set_last_error(err.clone());
-                return Err(ProcessError::DecodingFailed(err));
-            }
+    #[test]
+    fn test_encode_file_with_symbol_size() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let original_data: Vec<u8> = (0..40 * 1024 + 100).map(|i| (i * 31 / 7 % 251) as u8).collect();
+        write_file(&input_path, &original_data).unwrap();
+
+        let processor = RaptorQProcessor::builder().symbol_size(1024).build().unwrap();
+        assert_eq!(processor.symbol_size(), 1024);
+        for symbol_size in [256u16, 2048] {
+            let symbols_dir = dir_path.join(format!("symbols_{}", symbol_size));
+            let result = processor.encode_file_with_symbol_size(
+                input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), symbol_size, 20 * 1024,
+            ).unwrap();
+            let layout = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+            assert_eq!(layout.blocks.len(), 3);
+            assert!(layout.blocks.iter().all(|b| b.symbol_size() == symbol_size), "{}", symbol_size);
+            assert_eq!(layout.blocks[0].source_symbols_count(), (20 * 1024 / symbol_size) as u64);
+
+            // The processor keeps its own symbol size and decodes with those of the layout
+            let output_path = dir_path.join(format!("output_{}.bin", symbol_size));
+            processor.decode_symbols(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path).unwrap();
+            assert_eq!(read_file(&output_path).unwrap(), original_data);
         }
-        
-        let layout_content = match String::from_utf8(layout_content_bytes) {
-            Ok(content) => content,
-            Err(e) => {
-                let err = format!("Layout file contains invalid UTF-8: {}", e);
-                self.set_last_error(err.clone());
-                return Err(ProcessError::DecodingFailed(err.to_string()));
-            }
-        };
+        let (result, _) = processor.encode_bytes(&original_data, 20 * 1024).unwrap();
+        assert_eq!(result.layout.unwrap().blocks[0].symbol_size(), 1024);
 
-        let layout = match serde_json::from_str::<RaptorQLayout>(&layout_content) {
-            Ok(layout) => layout,
-            Err(e) => {
-                let err = format!("Failed to parse the layout file: {}", e);
-                self.set_last_error(err.clone());
-                return Err(ProcessError::DecodingFailed(err));
-            }
-        };
+        let symbols_dir = dir_path.join("rejected");
+        match processor.encode_file_with_symbol_size(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), MIN_SYMBOL_SIZE_B - 1, 0) {
+            Err(ProcessError::InvalidParameter(message)) => assert!(message.contains("at least 8 bytes"), "{}", message),
+            other => panic!("Expected InvalidParameter, got {:?}", other.map(|r| r.total_symbols_count)),
+        }
+        assert!(processor.get_last_error().contains("symbol size"));
 
-        // Now that we have the layout, delegate to decode_symbols_with_layout
-        self.decode_symbols_with_layout(symbols_dir, output_path, &layout)
+        // Blocks are checked against the overridden symbol size
+        let too_large = MAX_SOURCE_BLOCKS * MAX_SOURCE_SYMBOLS as u64 * 8 + 8;
+        assert!(processor.validate_block_size(too_large).is_ok());
+        assert!(RaptorQProcessor::check_block_size(too_large, 8).is_err());
     }
 
-    /// Decode RaptorQ symbols to recreate the original file, using a RaptorQLayout object
-    ///
-    /// This function uses the provided RaptorQLayout structure which contains
-    /// encoding parameters and block metadata
-    ///
-    /// # Arguments
-    ///
-    /// * `symbols_dir` - Path to the directory containing the symbol files
-    /// * `output_path` - Path where the decoded file will be written
-    /// * `layout` - The RaptorQLayout object containing encoding parameters and block information
-    ///
-    /// # Returns
-    ///
-    /// * `Ok(())` on successful decoding
-    /// * `Err(ProcessError)` on error (e.g., file not found, decoding failed)
-    pub fn decode_symbols_with_layout(
-        &self,
-        symbols_dir: &str,
-        output_path: &str,
-        layout: &RaptorQLayout,
-    ) -> Result<(), ProcessError> {
-        // Check if we can take another task
-        if !self.can_start_task() {
-            return Err(ProcessError::ConcurrencyLimitReached);
+    #[test]
+    fn test_buffer_pool() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let original_data: Vec<u8> = (0..50 * 1024).map(|i| (i * 31 / 7 % 251) as u8).collect();
+
+        let plain = RaptorQProcessor::builder().symbol_size(1024).build().unwrap();
+        let (expected, expected_symbols) = plain.encode_bytes(&original_data, 20 * 1024).unwrap();
+        assert!(plain.block_buffers.lock().is_none());
+
+        // The buffer of an encode is kept for the next ones, which encode the same symbols
+        let processor = RaptorQProcessor::builder().symbol_size(1024).buffer_pool(true).build().unwrap();
+        for _ in 0..2 {
+            let (result, symbols) = processor.encode_bytes(&original_data, 20 * 1024).unwrap();
+            assert_eq!(result.layout, expected.layout);
+            assert_eq!(symbols, expected_symbols);
+            let buffers = processor.block_buffers.lock();
+            assert_eq!(buffers.as_ref().unwrap().len(), 1);
+            assert!(buffers.as_ref().unwrap()[0].capacity() >= 20 * 1024);
         }
-        let _guard = TaskGuard::new(&self.active_tasks);
+        let (result, _) = processor.encode_bytes(&original_data[..3000], 0).unwrap();
+        assert_eq!(result.layout.unwrap().blocks[0].size, 3000);
+
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        write_file(&input_path, &original_data).unwrap();
+        let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 20 * 1024, false).unwrap();
+        let output_path = dir_path.join("output.bin");
+        processor.decode_symbols(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path).unwrap();
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
+
+        // A reset releases the buffers, the pool stays enabled
+        processor.reset();
+        assert_eq!(processor.block_buffers.lock().as_ref().unwrap().len(), 0);
+        processor.encode_bytes(&original_data, 20 * 1024).unwrap();
+        assert_eq!(processor.block_buffers.lock().as_ref().unwrap().len(), 1);
+
+        processor.set_buffer_pool(false);
+        assert!(processor.block_buffers.lock().is_none());
+        processor.set_buffer_pool(true);
+        assert_eq!(processor.block_buffers.lock().as_ref().unwrap().len(), 0);
+    }
+
+    #[test]
+    fn test_layout_metadata() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let original_data: Vec<u8> = (0..20 * 1024).map(|i| (i * 31 / 7 % 251) as u8).collect();
+        write_file(&input_path, &original_data).unwrap();
+
+        let metadata: BTreeMap<String, String> = [
+            ("object_id", "3f2a"),
+            ("content_type", "application/json"),
+            ("created_at", "2024-05-01T12:00:00Z"),
+        ].into_iter().map(|(k, v)| (k.to_string(), v.to_string())).collect();
+        let processor = RaptorQProcessor::builder().symbol_size(1024).layout_metadata(metadata.clone()).build().unwrap();
+        let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 0, false).unwrap();
+        let layout = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+        assert_eq!(layout.metadata, metadata);
+        assert_eq!(layout.summary().metadata, metadata);
+        assert_eq!(processor.plan_encode(input_path.to_str().unwrap(), 0).unwrap().metadata, metadata);
+
+        // The metadata doesn't change the symbols nor the decoding
+        let output_path = dir_path.join("output.bin");
+        let plain = RaptorQProcessor::builder().symbol_size(1024).build().unwrap();
+        plain.decode_symbols(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path).unwrap();
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
+        let (plain_result, _) = plain.encode_bytes(&original_data, 0).unwrap();
+        let plain_layout = plain_result.layout.unwrap();
+        assert_eq!(plain_layout.blocks, layout.blocks);
+        assert!(!plain_result.layout_content.unwrap().contains("metadata"));
+
+        processor.set_layout_metadata(BTreeMap::new());
+        let (result, _) = processor.encode_bytes(&original_data, 0).unwrap();
+        assert_eq!(result.layout.unwrap(), plain_layout);
+    }
+
+    #[test]
+    fn test_content_hash() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let output_path = dir_path.join("output.bin");
+        let original_data: Vec<u8> = (0..50 * 1024 + 17).map(|i| (i * 31 / 7 % 251) as u8).collect();
+        write_file(&input_path, &original_data).unwrap();
+        let sha256 = sha256_hex(Sha256::new_with_prefix(&original_data));
+        let blake3 = blake3::hash(&original_data).to_hex().to_string();
+
+        let processor = RaptorQProcessor::builder().symbol_size(1024).concurrency_limit(4).build().unwrap();
+        for (content_hash, expected) in [
+            (ContentHash::Sha256, sha256.clone()),
+            (ContentHash::Blake3, blake3),
+            (ContentHash::None, String::new()),
+        ] {
+            processor.set_content_hash(content_hash);
+            let dir = |name: &str| dir_path.join(format!("{:?}_{}", content_hash, name)).to_str().unwrap().to_string();
+
+            // Every encode hashes the source as it reads it, in one or several blocks
+            let results = [
+                processor.encode_file(input_path.to_str().unwrap(), &dir("file"), 20 * 1024, false).unwrap(),
+                processor.encode_stream(&original_data[..], &dir("stream"), 20 * 1024).unwrap(),
+                processor.encode_reader_at(&original_data, original_data.len() as u64, &dir("reader_at"), 20 * 1024).unwrap(),
+                processor.encode_bytes(&original_data, 0).unwrap().0,
+            ];
+            for result in &results {
+                assert_eq!(result.content_hash, expected, "{:?}", content_hash);
+            }
 
-        if layout.blocks.is_empty() {
-            let err = "Layout file has the empty blocks array".to_string();
-            self.set_last_error(err.clone());
-            return Err(ProcessError::DecodingFailed(err));
+            // Only SHA-256 is recorded in the layout, the symbols don't change
+            let layout = RaptorQLayout::read_file(&results[0].layout_file_path).unwrap();
+            let expected_sha256 = if content_hash == ContentHash::Sha256 { sha256.clone() } else { String::new() };
+            assert_eq!(layout.object_sha256, expected_sha256);
+            processor.decode_symbols(&dir("file"), output_path.to_str().unwrap(), &results[0].layout_file_path).unwrap();
+            assert_eq!(read_file(&output_path).unwrap(), original_data);
         }
 
-        let dir_manager = file_io::get_dir_manager();
+        // An empty file is still recorded as an empty object
+        let empty_path = dir_path.join("empty.bin");
+        write_file(&empty_path, &[]).unwrap();
+        let empty_dir = dir_path.join("empty");
+        let result = processor.encode_file(empty_path.to_str().unwrap(), empty_dir.to_str().unwrap(), 0, false).unwrap();
+        assert!(result.content_hash.is_empty());
+        assert!(RaptorQLayout::read_file(&result.layout_file_path).unwrap().is_empty_object());
+        processor.decode_symbols(empty_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path).unwrap();
+        assert!(read_file(&output_path).unwrap().is_empty());
+    }
 
-        // check if the symbols dir exists
-        let exists = dir_manager.dir_exists(symbols_dir)
-            .map_err(|e| ProcessError::IOError(io::Error::new(io::ErrorKind::Other, e)))?;
-        if !exists {
-            return Err(ProcessError::InvalidPath(format!("Symbols directory does not exist: {}",symbols_dir)));
+    #[test]
+    fn test_decode_symbols_of_another_object() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let other_input_path = dir_path.join("other.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let other_symbols_dir = dir_path.join("other_symbols");
+        let output_path = dir_path.join("output.bin");
+        let original_data: Vec<u8> = (0..20 * 1024).map(|i| (i * 31 / 7 % 251) as u8).collect();
+        let other_data: Vec<u8> = original_data.iter().map(|b| b ^ 0x5a).collect();
+        write_file(&input_path, &original_data).unwrap();
+        write_file(&other_input_path, &other_data).unwrap();
+
+        let processor = RaptorQProcessor::builder().symbol_size(1024).build().unwrap();
+        let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 0, false).unwrap();
+        let other_result = processor.encode_file(other_input_path.to_str().unwrap(), other_symbols_dir.to_str().unwrap(), 0, false).unwrap();
+        let layout = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+        let other_layout = RaptorQLayout::read_file(&other_result.layout_file_path).unwrap();
+
+        let err = processor.decode_symbols(other_symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path).unwrap_err();
+        match err {
+            ProcessError::ObjectMismatch { expected, found } => {
+                assert_eq!(expected, layout.object_sha256);
+                assert_eq!(found, other_layout.object_sha256);
+            },
+            e => panic!("Expected ObjectMismatch, got {:?}", e),
         }
+        assert!(processor.get_last_error().contains(&layout.object_sha256));
+        let dirs = [symbols_dir.to_str().unwrap(), other_symbols_dir.to_str().unwrap()];
+        assert!(matches!(
+            processor.decode_from_dirs(&dirs, output_path.to_str().unwrap(), &result.layout_file_path),
+            Err(ProcessError::ObjectMismatch { .. })
+        ));
+
+        // A binary layout in the directory tells the object as well
+        write_file(&other_symbols_dir.join(LAYOUT_FILENAME), &other_layout.to_bytes(LayoutFormat::Binary).unwrap()).unwrap();
+        assert!(matches!(
+            processor.decode_symbols(other_symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path),
+            Err(ProcessError::ObjectMismatch { .. })
+        ));
+
+        // Without a layout file in the directory the symbols are just missing
+        std::fs::remove_file(other_symbols_dir.join(LAYOUT_FILENAME)).unwrap();
+        assert!(matches!(
+            processor.decode_symbols(other_symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path),
+            Err(ProcessError::InsufficientSymbols(_))
+        ));
+        processor.decode_symbols(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path).unwrap();
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
+    }
 
-        let mut output_writer = file_io::open_file_writer(output_path)
-            .map_err(|e| ProcessError::IOError(io::Error::new(io::ErrorKind::Other, e)))?;
+    #[test]
+    fn test_symbol_codec_bounds_symbol_files() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let output_path = dir_path.join("output.bin");
+        let original_data = generate_test_data(10 * 1024);
+        write_file(&input_path, &original_data).unwrap();
+
+        let processor = RaptorQProcessor::builder()
+            .symbol_size(1024)
+            .flat_symbol_layout(true)
+            .symbol_codec(SymbolCodec::Gzip)
+            .build()
+            .unwrap();
+        let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 0, false).unwrap();
+        let layout = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+        assert_eq!(processor.layout_symbol_format(&layout).unwrap().max_symbol_len, 1024 + 4);
+
+        // A symbol file decompressing far past a symbol is skipped like a corrupt one
+        let bomb = SymbolCodec::Gzip.compress(&vec![0u8; 1 << 20]).unwrap();
+        write_file(&symbols_dir.join(&layout.blocks[0].symbols[0]), &bomb).unwrap();
+        processor.decode_symbols(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path).unwrap();
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
+    }
 
-        // Process multiple blocks
-        debug!("Decoding the file with {} blocks", layout.blocks.len());
-        
-        // Sort blocks by their block_id to ensure deterministic processing order
-        let mut sorted_blocks = layout.blocks.clone();
-        sorted_blocks.sort_by(|a, b| a.block_id.cmp(&b.block_id));
+    #[test]
+    fn test_symbol_codec() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let other_input_path = dir_path.join("other.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let original_data = b"{\"level\":\"info\",\"message\":\"request served\"}\n".repeat(1000);
+        let other_data: Vec<u8> = (0..30 * 1024).map(|i| (i * 31 / 7 % 251) as u8).collect();
+        write_file(&input_path, &original_data).unwrap();
+        write_file(&other_input_path, &other_data).unwrap();
+
+        let processor = RaptorQProcessor::builder()
+            .symbol_size(1024)
+            .flat_symbol_layout(true)
+            .symbol_codec(SymbolCodec::Gzip)
+            .build()
+            .unwrap();
+        let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 10 * 1024, false).unwrap();
+        let gzip_layout_path = dir_path.join("gzip_layout.json");
+        std::fs::rename(&result.layout_file_path, &gzip_layout_path).unwrap();
+        let layout = RaptorQLayout::read_file(gzip_layout_path.to_str().unwrap()).unwrap();
+        assert_eq!(layout.symbol_codec, SymbolCodec::Gzip);
+        assert!(read_file_to_string(&gzip_layout_path).unwrap().contains("\"symbol_codec\": \"gzip\""));
+
+        // Symbol ids are the hashes of the uncompressed symbols
+        let symbol_id = &layout.blocks[0].symbols[0];
+        let content = read_file(&symbols_dir.join(symbol_id)).unwrap();
+        assert!(content.starts_with(&[0x1f, 0x8b]));
+        let symbol = SymbolCodec::Gzip.decompress(&content, MAX_SYMBOL_LEN).unwrap();
+        assert_eq!(&get_hash_as_b58(&symbol), symbol_id);
+
+        // The symbols of another object with another codec share the directory
+        processor.set_symbol_codec(SymbolCodec::Zstd);
+        let result = processor.encode_file(other_input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 10 * 1024, false).unwrap();
+        let zstd_layout_path = dir_path.join("zstd_layout.json");
+        std::fs::rename(&result.layout_file_path, &zstd_layout_path).unwrap();
+        assert_eq!(RaptorQLayout::read_file(zstd_layout_path.to_str().unwrap()).unwrap().symbol_codec, SymbolCodec::Zstd);
+
+        // Decoding follows the codec of each layout, whatever the codec of the processor
+        let decoder = RaptorQProcessor::builder().symbol_size(1024).build().unwrap();
+        for (layout_path, data) in [(&gzip_layout_path, &original_data), (&zstd_layout_path, &other_data)] {
+            let output_path = dir_path.join("output.bin");
+            decoder.decode_symbols(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), layout_path.to_str().unwrap()).unwrap();
+            assert_eq!(&read_file(&output_path).unwrap(), data);
+        }
 
-        let symbols_dir_path = Path::new(symbols_dir);
+        // Repair symbols and symbols read back are compressed and decompressed as well
+        let repair_ids = processor.generate_repair_symbols(
+            input_path.to_str().unwrap(),
+            symbols_dir.to_str().unwrap(),
+            gzip_layout_path.to_str().unwrap(),
+            0,
+            2,
+        ).unwrap();
+        assert!(read_file(&symbols_dir.join(&repair_ids[0])).unwrap().starts_with(&[0x1f, 0x8b]));
+        let symbol = decoder.get_symbol(symbols_dir.to_str().unwrap(), gzip_layout_path.to_str().unwrap(), 0, 0, None).unwrap();
+        assert_eq!(&get_hash_as_b58(&symbol), symbol_id);
+
+        // Layouts without a codec omit it
+        processor.set_symbol_codec(SymbolCodec::None);
+        let plain_dir = dir_path.join("plain");
+        let result = processor.encode_file(input_path.to_str().unwrap(), plain_dir.to_str().unwrap(), 10 * 1024, false).unwrap();
+        assert!(!read_file_to_string(Path::new(&result.layout_file_path)).unwrap().contains("symbol_codec"));
+    }
 
-        // Iterate over blocks from the layout file (source of truth)
-        for block_layout in &sorted_blocks {
-            // Determine the block directory path
-            let block_dir_name = format!("{}{}", BLOCK_DIR_PREFIX, block_layout.block_id);
-            let block_dir_path = symbols_dir_path.join(block_dir_name);
+    #[test]
+    fn test_symbol_key() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let original_data: Vec<u8> = (0..30 * 1024).map(|i| (i * 31 / 7 % 251) as u8).collect();
+        write_file(&input_path, &original_data).unwrap();
+        let key = [42u8; 32];
+
+        let processor = RaptorQProcessor::builder()
+            .symbol_size(1024)
+            .symbol_codec(SymbolCodec::Gzip)
+            .symbol_key(&key)
+            .build()
+            .unwrap();
+        let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 10 * 1024, false).unwrap();
+        let layout = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+        assert_eq!(layout.symbol_key_id, SymbolCipher::new(&key).unwrap().key_id());
+        assert_eq!(layout.symbol_codec, SymbolCodec::Gzip);
+        let symbol_path = symbols_dir.join(block_dir_name(0)).join(&layout.blocks[0].symbols[0]);
+        let content = read_file(&symbol_path).unwrap();
+        assert!(!content.starts_with(&[0x1f, 0x8b]), "Symbols are encrypted after their compression");
+
+        // Decoding needs the key
+        let output_path = dir_path.join("output.bin");
+        let decode = |processor: &RaptorQProcessor| {
+            processor.decode_symbols(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path)
+        };
+        let decoder = RaptorQProcessor::builder().symbol_size(1024).build().unwrap();
+        assert!(matches!(decode(&decoder), Err(ProcessError::InvalidConfig(_))));
+        decoder.set_symbol_key(Some(&[7u8; 32])).unwrap();
+        assert!(matches!(decode(&decoder), Err(ProcessError::InvalidConfig(_))));
+        assert!(decoder.get_last_error().contains(&layout.symbol_key_id));
+        decoder.set_symbol_key(Some(&key)).unwrap();
+        decode(&decoder).unwrap();
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
+
+        // A tampered symbol fails authentication and is skipped
+        let mut tampered = content.clone();
+        tampered[20] ^= 1;
+        write_file(&symbol_path, &tampered).unwrap();
+        decoder.decode_symbols_verified(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path).unwrap();
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
+
+        // Encoding the block again writes the same files
+        std::fs::remove_file(&symbol_path).unwrap();
+        decoder.encode_block(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), &result.layout_file_path, 0).unwrap();
+        assert_eq!(read_file(&symbol_path).unwrap(), content);
+
+        assert!(matches!(decoder.set_symbol_key(Some(&[0u8; 20])), Err(ProcessError::InvalidParameter(_))));
+        assert!(matches!(
+            RaptorQProcessor::builder().symbol_key(&[0u8; 20]).build(),
+            Err(ProcessError::InvalidConfig(_))
+        ));
+    }
+
+    #[test]
+    fn test_symbol_crc() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let output_path = dir_path.join("output.bin");
+        let original_data: Vec<u8> = (0..30 * 1024).map(|i| (i * 31 / 7 % 251) as u8).collect();
+        write_file(&input_path, &original_data).unwrap();
 
-            // Use the block directory if it exists, otherwise use symbols_dir
-            let block_path: std::path::PathBuf;
+        let processor = RaptorQProcessor::builder().symbol_size(1024).symbol_crc(true).build().unwrap();
+        let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 20 * 1024, false).unwrap();
+        let layout = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+        assert!(layout.symbol_crc);
+        assert!(read_file_to_string(Path::new(&result.layout_file_path)).unwrap().contains("\"symbol_crc\": true"));
 
-            // check if the block dir exists
-            let block_dir_path_str = block_dir_path.to_string_lossy().to_string();
-            let exists = dir_manager.dir_exists(&block_dir_path_str)
-                .map_err(|e| ProcessError::IOError(io::Error::new(io::ErrorKind::Other, e)))?;
-            if exists {
-                debug!("Using block directory: {}", block_dir_path_str);
-                block_path = block_dir_path.clone();
+        // Symbol ids are the hashes of the symbols after the header
+        let block_dir = symbols_dir.join(block_dir_name(0));
+        let symbol_id = &layout.blocks[0].symbols[0];
+        let content = read_file(&block_dir.join(symbol_id)).unwrap();
+        assert_eq!(content.len(), 1024 + 4 + SymbolHeader::LEN);
+        assert!(content.starts_with(b"RQS1"));
+        assert_eq!(&get_hash_as_b58(SymbolHeader::read(&content).unwrap()), symbol_id);
+
+        let estimate = processor.estimate_storage(original_data.len() as u64, 20 * 1024).unwrap();
+        let stored: u64 = layout.blocks.iter()
+            .flat_map(|b| b.symbols.iter().map(|id| symbols_dir.join(block_dir_name(b.block_id)).join(id)))
+            .map(|path| std::fs::metadata(path).unwrap().len())
+            .sum();
+        assert_eq!(estimate.symbol_bytes, stored);
+
+        // Bit-flipped and truncated files are skipped, the repair symbols cover them
+        for (index, symbol_id) in layout.blocks[0].symbols.iter().take(4).enumerate() {
+            let symbol_path = block_dir.join(symbol_id);
+            let mut content = read_file(&symbol_path).unwrap();
+            if index == 0 {
+                content.truncate(content.len() - 1);
             } else {
-                debug!("Block directory does not exist, falling back to the symbols directory: {:?}", symbols_dir_path);
-                block_path = symbols_dir_path.to_path_buf();
+                content[SymbolHeader::LEN + index * 100] ^= 0x10;
             }
+            write_file(&symbol_path, &content).unwrap();
+        }
+        for symbol_id in &layout.blocks[0].symbols[..4] {
+            let content = read_file(&block_dir.join(symbol_id)).unwrap();
+            assert!(SymbolHeader::read(&content).is_err());
+        }
+        let decoder = RaptorQProcessor::builder().symbol_size(1024).build().unwrap();
+        decoder.decode_symbols(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path).unwrap();
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
+
+        // Layouts without headers omit the option and still decode
+        processor.set_symbol_crc(false);
+        let plain_dir = dir_path.join("plain");
+        let result = processor.encode_file(input_path.to_str().unwrap(), plain_dir.to_str().unwrap(), 20 * 1024, false).unwrap();
+        assert!(!read_file_to_string(Path::new(&result.layout_file_path)).unwrap().contains("symbol_crc"));
+        let layout = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+        let content = read_file(&plain_dir.join(block_dir_name(0)).join(&layout.blocks[0].symbols[0])).unwrap();
+        assert_eq!(content.len(), 1024 + 4);
+        decoder.set_symbol_crc(true);
+        decoder.decode_symbols(plain_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path).unwrap();
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
+    }
 
-            // Extract encoder parameters for this specific block
-            if block_layout.encoder_parameters.len() < 12 {
-                let err = format!("Invalid encoder parameters in block {}", block_layout.block_id);
-                self.set_last_error(err.clone());
-                return Err(ProcessError::DecodingFailed(err));
+    #[test]
+    fn test_decode_symbols_malformed_symbol_files() {
+        use rand::{Rng, SeedableRng};
+
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let output_path = dir_path.join("output.bin");
+        let original_data: Vec<u8> = (0..12 * 1024).map(|i| (i * 31 / 7 % 251) as u8).collect();
+        write_file(&input_path, &original_data).unwrap();
+
+        let processor = RaptorQProcessor::builder().symbol_size(512).build().unwrap();
+        let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 4 * 1024, false).unwrap();
+        let layout = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+        let symbol_paths: Vec<PathBuf> = layout.blocks.iter()
+            .flat_map(|b| {
+                let block_dir = symbols_dir.join(block_dir_name(b.block_id));
+                b.symbols.iter().map(move |id| block_dir.join(id))
+            })
+            .collect();
+        let symbols: Vec<Vec<u8>> = symbol_paths.iter().map(|path| read_file(path).unwrap()).collect();
+
+        // Random bytes of any size in place of random symbols: the decode may fail, but
+        // with an error, and succeeds while enough symbols are intact
+        let mut rng = rand::rngs::StdRng::seed_from_u64(1312);
+        for round in 0..40 {
+            for (path, symbol) in symbol_paths.iter().zip(&symbols) {
+                write_file(path, symbol).unwrap();
             }
-            
-            let mut block_encoder_params = [0u8; 12];
-            block_encoder_params.copy_from_slice(&block_layout.encoder_parameters[0..12]);
-            
-            // Decode block data
-            let mut block_data = Vec::with_capacity(block_layout.size as usize);
-            
-            // Create the decoder with the parameters specific to this block
-            let config = ObjectTransmissionInformation::deserialize(&block_encoder_params);
-            let mut decoder = Decoder::new(config);
-            
-            // Skip blocks that have no symbols in the layout
-            if block_layout.symbols.is_empty() {
-                debug!("No symbols in the layout for block {}, skipping", block_layout.block_id);
-                continue;
+            let corrupted = rng.gen_range(1..symbol_paths.len());
+            for _ in 0..corrupted {
+                let path = &symbol_paths[rng.gen_range(0..symbol_paths.len())];
+                let mut garbage = vec![0u8; rng.gen_range(0..1100)];
+                rng.fill(&mut garbage[..]);
+                write_file(path, &garbage).unwrap();
             }
-            
-            // Process symbols from the layout file
-            let mut found_any = false;
-            for symbol_id in &block_layout.symbols {
-                let symbol_path = block_path.join(symbol_id);
-                let symbol_path_str = symbol_path.to_string_lossy().to_string();
-
-                let (mut symbol_reader, symbol_size) = match self.open_and_validate_file(&symbol_path_str) {
-                    Ok(result) => result,
-                    Err(_) => {
-                        continue;
-                    }
-                };
 
-                found_any = true;
+            match processor.decode_symbols_verified(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path) {
+                Ok(()) => assert_eq!(read_file(&output_path).unwrap(), original_data, "round {}", round),
+                Err(ProcessError::InsufficientSymbols(_)) => {},
+                Err(e) => panic!("Unexpected error in round {}: {:?}", round, e),
+            }
+            let _ = processor.decode_symbols(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path);
+        }
 
-                // Read symbol data
-                let mut symbol_data = vec![0u8; symbol_size];
-                match symbol_reader.read_chunk(0, &mut symbol_data) {
-                    Ok(bytes_read) if bytes_read == symbol_size => {
-                        // Successfully read the entire symbol
-                    },
-                    Ok(bytes_read) => {
-                        debug!("Partial read of the symbol file {}: {} of {} bytes",
-                               symbol_id, bytes_read, symbol_size);
-                        continue;
-                    },
-                    Err(e) => {
-                        debug!("Failed to read the symbol file {}: {}", symbol_id, e);
-                        continue;
-                    }
-                };
+        // Truncated symbols and symbol ids that aren't file names are skipped
+        for (path, symbol) in symbol_paths.iter().zip(&symbols) {
+            write_file(path, &symbol[..symbol.len() / 2]).unwrap();
+        }
+        let result = processor.decode_symbols(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path);
+        assert!(matches!(result, Err(ProcessError::InsufficientSymbols(_))), "Unexpected result {:?}", result);
+
+        let mut bad_layout = layout.clone();
+        bad_layout.blocks[0].symbols = vec!["".to_string(), "..".to_string(), "../input.bin".to_string()];
+        let layout_path = dir_path.join("bad_layout.json");
+        write_file(&layout_path, serde_json::to_string(&bad_layout).unwrap().as_bytes()).unwrap();
+        let result = processor.decode_symbols(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), layout_path.to_str().unwrap());
+        assert!(matches!(result, Err(ProcessError::InsufficientSymbols(_))), "Unexpected result {:?}", result);
+    }
 
-                let packet = EncodingPacket::deserialize(&symbol_data);
-                if let Some(result) = self.safe_decode(&mut decoder, packet) {
-                    block_data.extend_from_slice(&result);
-                    break; // Successfully decoded
-                }
-            }
-            
-            // If we couldn't find any of the specified symbols
-            if !found_any {
-                let err = format!("None of the symbols for block {} could be found", block_layout.block_id);
-                self.set_last_error(err.clone());
-                return Err(ProcessError::DecodingFailed(err));
-            }
+    #[test]
+    fn test_append_encode() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let layout_path = symbols_dir.join(LAYOUT_FILENAME);
+        let output_path = dir_path.join("output.bin");
+        let data: Vec<u8> = (0..55_000).map(|i| (i * 31 / 7 % 251) as u8).collect();
+        write_file(&input_path, &data[..25_000]).unwrap();
+
+        // The appended blocks take the codec and metadata of the layout, not the ones of the processor
+        let metadata = BTreeMap::from([("object".to_string(), "log".to_string())]);
+        let gzip_processor = RaptorQProcessor::builder()
+            .symbol_size(1024)
+            .symbol_codec(SymbolCodec::Gzip)
+            .layout_metadata(metadata.clone())
+            .build()
+            .unwrap();
+        gzip_processor
+            .encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 10_000, false)
+            .unwrap();
+        let original_layout = RaptorQLayout::read_file(layout_path.to_str().unwrap()).unwrap();
+        assert_eq!(original_layout.blocks.len(), 3);
+
+        let processor = RaptorQProcessor::new(ProcessorConfig { symbol_size: 1024, ..ProcessorConfig::default() });
+        write_file(&input_path, &data).unwrap();
+        let result = processor
+            .append_encode(layout_path.to_str().unwrap(), input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 10_000)
+            .expect("Append should succeed");
+
+        let layout = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+        let sizes: Vec<u64> = layout.blocks.iter().map(|b| b.size).collect();
+        assert_eq!(sizes, vec![10_000, 10_000, 5_000, 10_000, 10_000, 10_000]);
+        assert_eq!(layout.blocks[3].original_offset, 25_000);
+        assert_eq!(&layout.blocks[..3], &original_layout.blocks[..], "Existing blocks should be kept");
+        assert_eq!(layout.symbol_codec, SymbolCodec::Gzip);
+        assert_eq!(layout.metadata, metadata);
+        assert_eq!(layout.object_sha256, sha256_hex(Sha256::new_with_prefix(&data)));
+        assert_eq!(result.blocks.as_ref().unwrap().len(), 6);
+        assert_eq!(result.total_symbols_count, layout.symbols_count());
+        assert!(!path_exists(&symbols_dir.join("_raptorq_block_3.json")), "Markers should be removed");
+
+        processor
+            .decode_symbols(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), layout_path.to_str().unwrap())
+            .expect("Decoding should succeed");
+        assert_eq!(read_file(&output_path).unwrap(), data);
+
+        // Appending nothing keeps the layout
+        let result = processor
+            .append_encode(layout_path.to_str().unwrap(), input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 0)
+            .unwrap();
+        assert_eq!(RaptorQLayout::read_file(&result.layout_file_path).unwrap(), layout);
+
+        // Appended data smaller than a block size gives a single block
+        let more_data: Vec<u8> = [&data[..], &data[..3_000]].concat();
+        write_file(&input_path, &more_data).unwrap();
+        let result = processor
+            .append_encode(layout_path.to_str().unwrap(), input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 0)
+            .unwrap();
+        assert_eq!(result.blocks.as_ref().unwrap().len(), 7);
+        assert_eq!(result.blocks.as_ref().unwrap()[6].size, 3_000);
+        let layout = RaptorQLayout::read_file(layout_path.to_str().unwrap()).unwrap();
+        processor
+            .decode_symbols(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), layout_path.to_str().unwrap())
+            .expect("Decoding should succeed");
+        assert_eq!(read_file(&output_path).unwrap(), more_data);
+
+        // Data that changed or shrank isn't an append, nothing is written
+        let mut changed = more_data.clone();
+        changed[100] ^= 1;
+        write_file(&input_path, &changed).unwrap();
+        let result = processor.append_encode(layout_path.to_str().unwrap(), input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 0);
+        assert!(matches!(result, Err(ProcessError::InvalidParameter(_))), "Unexpected result {:?}", result);
+        assert!(processor.get_last_error().contains("differs from block 0"));
+        assert!(!symbols_dir.join("block_7").exists());
+
+        write_file(&input_path, &data[..20_000]).unwrap();
+        let result = processor.append_encode(layout_path.to_str().unwrap(), input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 0);
+        assert!(matches!(result, Err(ProcessError::InvalidParameter(_))), "Unexpected result {:?}", result);
+        assert_eq!(RaptorQLayout::read_file(layout_path.to_str().unwrap()).unwrap(), layout);
+    }
 
-            // Validate hash if available
-            if !block_layout.hash.is_empty() {
-                let computed_hash = get_hash_as_b58(&block_data);
-                if computed_hash != block_layout.hash {
-                    let err = format!("Hash mismatch for block {}: expected {}, got {}",
-                                     block_layout.block_id, block_layout.hash, computed_hash);
-                    self.set_last_error(err.clone());
-                    return Err(ProcessError::DecodingFailed(err));
+    #[test]
+    fn test_resume_encode() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let reference_dir = dir_path.join("reference");
+        let original_data: Vec<u8> = (0..50 * 1024).map(|i| (i * 31 / 7 % 251) as u8).collect();
+        write_file(&input_path, &original_data).unwrap();
+
+        // Writing the symbols of the third block fails
+        std::fs::create_dir_all(&symbols_dir).unwrap();
+        write_file(&symbols_dir.join(block_dir_name(2)), b"").unwrap();
+
+        let processor = RaptorQProcessor::builder().symbol_size(1024).cleanup_on_error(false).build().unwrap();
+        let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 10 * 1024, false);
+        assert!(matches!(result, Err(ProcessError::IOError(_))), "Unexpected result {:?}", result);
+        for block_id in 0..2 {
+            assert!(Path::new(&RaptorQProcessor::block_marker_path(symbols_dir.to_str().unwrap(), block_id)).is_file());
+        }
+
+        // The first block is kept, the second one lost a symbol and is encoded again
+        let first_symbol = std::fs::read_dir(symbols_dir.join(block_dir_name(0))).unwrap().next().unwrap().unwrap().path();
+        let first_modified = std::fs::metadata(&first_symbol).unwrap().modified().unwrap();
+        let second_symbol = std::fs::read_dir(symbols_dir.join(block_dir_name(1))).unwrap().next().unwrap().unwrap().path();
+        std::fs::remove_file(&second_symbol).unwrap();
+        std::fs::remove_file(symbols_dir.join(block_dir_name(2))).unwrap();
+        std::thread::sleep(Duration::from_millis(10));
+
+        let result = processor.resume_encode(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 10 * 1024).unwrap();
+        assert_eq!(std::fs::metadata(&first_symbol).unwrap().modified().unwrap(), first_modified);
+        assert!(second_symbol.is_file(), "The missing symbol should be written again");
+        for block_id in 0..result.blocks.as_ref().unwrap().len() {
+            assert!(!Path::new(&RaptorQProcessor::block_marker_path(symbols_dir.to_str().unwrap(), block_id)).exists());
+        }
+
+        let reference = processor.encode_file(input_path.to_str().unwrap(), reference_dir.to_str().unwrap(), 10 * 1024, false).unwrap();
+        assert_eq!(serde_json::to_value(&result.blocks).unwrap(), serde_json::to_value(&reference.blocks).unwrap());
+        assert_eq!(result.total_symbols_count, reference.total_symbols_count);
+        assert_eq!(
+            read_file(Path::new(&result.layout_file_path)).unwrap(),
+            read_file(Path::new(&reference.layout_file_path)).unwrap()
+        );
+
+        let output_path = dir_path.join("output.bin");
+        processor.decode_symbols(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path).unwrap();
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
+
+        // Nothing left to resume: every block is encoded again
+        let result = processor.resume_encode(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 10 * 1024).unwrap();
+        assert_eq!(serde_json::to_value(&result.blocks).unwrap(), serde_json::to_value(&reference.blocks).unwrap());
+        assert!(std::fs::metadata(&first_symbol).unwrap().modified().unwrap() > first_modified);
+    }
+
+    #[test]
+    fn test_resume_encode_cancelled() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let symbols_dir_str = symbols_dir.to_str().unwrap();
+        let original_data: Vec<u8> = (0..50 * 1024).map(|i| (i * 31 / 7 % 251) as u8).collect();
+        write_file(&input_path, &original_data).unwrap();
+
+        // The first two blocks are encoded, writing the third one fails
+        std::fs::create_dir_all(&symbols_dir).unwrap();
+        write_file(&symbols_dir.join(block_dir_name(2)), b"").unwrap();
+        let interrupted = RaptorQProcessor::builder().symbol_size(1024).cleanup_on_error(false).build().unwrap();
+        assert!(interrupted.encode_file(input_path.to_str().unwrap(), symbols_dir_str, 10 * 1024, false).is_err());
+        std::fs::remove_file(symbols_dir.join(block_dir_name(2))).unwrap();
+
+        // Cancels the resumed encode once it encoded a block
+        struct CancelOnFirstBlock(Mutex<Option<Arc<RaptorQProcessor>>>);
+        impl ProcessorMetrics for CancelOnFirstBlock {
+            fn record_block(&self, _record: &BlockRecord) {
+                if let Some(processor) = self.0.lock().take() {
+                    processor.cancel();
                 }
             }
+        }
 
-            // Write to the correct position in the output file based on the block's original offset
-            output_writer.write_chunk(block_layout.original_offset as usize, &block_data)
-                .map_err(|e| ProcessError::IOError(io::Error::new(io::ErrorKind::Other, e)))?;
+        // The blocks of the encode it resumes are kept, only the block it encoded is removed
+        let processor = Arc::new(RaptorQProcessor::builder().symbol_size(1024).build().unwrap());
+        processor.set_metrics(Some(Arc::new(CancelOnFirstBlock(Mutex::new(Some(processor.clone()))))));
+        let result = processor.resume_encode(input_path.to_str().unwrap(), symbols_dir_str, 10 * 1024);
+        assert!(matches!(result, Err(ProcessError::Cancelled)), "Unexpected result {:?}", result);
+        for block_id in 0..2 {
+            assert!(symbols_dir.join(block_dir_name(block_id)).is_dir(), "Block {} should be kept", block_id);
+            assert!(Path::new(&RaptorQProcessor::block_marker_path(symbols_dir_str, block_id)).is_file());
         }
+        assert!(!symbols_dir.join(block_dir_name(2)).exists());
+        assert!(!Path::new(&RaptorQProcessor::block_marker_path(symbols_dir_str, 2)).exists());
 
-        Ok(())
+        // Resuming again completes the object
+        let result = processor.resume_encode(input_path.to_str().unwrap(), symbols_dir_str, 10 * 1024).unwrap();
+        let output_path = dir_path.join("output.bin");
+        processor.decode_symbols(symbols_dir_str, output_path.to_str().unwrap(), &result.layout_file_path).unwrap();
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
     }
 
-    // Helper function to safely attempt the decoding a packet without panicking
-    fn safe_decode(&self, decoder: &mut Decoder, packet: EncodingPacket) -> Option<Vec<u8>> {
-        // Use catch_unwind to prevent panics from propagating
-        std::panic::catch_unwind(std::panic::AssertUnwindSafe(|| {
-            decoder.decode(packet)
-        })).unwrap_or_else(|_| {
-            // Log corrupted symbol and return None
-            debug!("Skipping corrupted symbol: panic during decoding");
-            None
-        })
+    #[test]
+    fn test_encode_stream_empty() {
+        let (temp_dir, dir_path) = create_temp_dir();
+        let symbols_dir = dir_path.join("symbols");
+
+        let processor = RaptorQProcessor::new(ProcessorConfig::default());
+        let result = processor.encode_stream(io::empty(), symbols_dir.to_str().unwrap(), 0);
+        assert!(matches!(result, Err(ProcessError::EncodingFailed(_))));
+
+        // Ensure temp_dir isn't dropped early
+        drop(temp_dir);
     }
 
-    /// Read all symbol files from a directory
-    #[allow(dead_code)]
-    fn read_symbol_files(&self, dir: &Path) -> Result<Vec<Vec<u8>>, ProcessError> {
-        let mut symbol_files = Vec::new();
-        let _dir_str = dir.to_string_lossy().to_string();
+    #[test]
+    fn test_encode_file_block_by_block() {
+        let (temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let reference_dir = dir_path.join("reference");
 
-        // Attempt to read symbol files with pattern "symbol_N.bin" which is used in tests
-        for i in 0..100 { // Arbitrary limit to avoid infinite loop
-            let file_name = format!("symbol_{}.bin", i);
-            let path = dir.join(&file_name);
-            let path_str = path.to_string_lossy().to_string();
+        let original_data = generate_test_data(250 * 1024);
+        write_file(&input_path, &original_data).expect("Failed to write the input file");
 
-            let (mut symbol_reader, symbol_size) = match self.open_and_validate_file(&path_str) {
-                Ok(result) => result,
-                Err(_) => {
-                    continue;
-                }
-            };
+        let config = ProcessorConfig {
+            symbol_size: 1024,
+            ..ProcessorConfig::default()
+        };
+        let processor = RaptorQProcessor::new(config);
 
-            let mut symbol_data = vec![0u8; symbol_size];
-            match symbol_reader.read_chunk(0, &mut symbol_data) {
-                Ok(bytes_read) if bytes_read == symbol_size => {
-                    // Successfully read the entire symbol
-                    symbol_files.push(symbol_data);
-                },
-                Ok(_) => {
-                    continue;
-                },
-                Err(_) => {
-                    continue;
-                }
-            };
-        }
+        let mut job = processor.begin_encode_file(
+            input_path.to_str().unwrap(),
+            symbols_dir.to_str().unwrap(),
+            100 * 1024
+        ).expect("Failed to start the encoding");
+        assert_eq!(job.progress(), EncodeProgress { blocks_done: 0, blocks_total: 3, bytes_processed: 0 });
 
-        if symbol_files.is_empty() {
-            let err = format!("No symbol files found in {:?}", dir);
-            self.set_last_error(err.clone());
-            return Err(ProcessError::DecodingFailed(err));
+        let mut progress = Vec::new();
+        while !job.is_done() {
+            progress.push(processor.encode_next_block(&mut job).expect("Failed to encode the block"));
         }
+        assert_eq!(progress, vec![
+            EncodeProgress { blocks_done: 1, blocks_total: 3, bytes_processed: 100 * 1024 },
+            EncodeProgress { blocks_done: 2, blocks_total: 3, bytes_processed: 200 * 1024 },
+            EncodeProgress { blocks_done: 3, blocks_total: 3, bytes_processed: 250 * 1024 },
+        ]);
 
-        Ok(symbol_files)
+        let result = processor.finish_encode_file(job).expect("Failed to finish the encoding");
+
+        // Same layout as encoding the file in one call
+        let reference = processor.encode_file(
+            input_path.to_str().unwrap(),
+            reference_dir.to_str().unwrap(),
+            100 * 1024,
+            false
+        ).expect("Failed to encode the file");
+        assert_eq!(result.total_symbols_count, reference.total_symbols_count);
+        assert_eq!(
+            read_file(Path::new(&result.layout_file_path)).unwrap(),
+            read_file(Path::new(&reference.layout_file_path)).unwrap()
+        );
+
+        // Ensure temp_dir isn't dropped early
+        drop(temp_dir);
     }
 
-    /// Read specific symbol files by ID from a directory
-    #[allow(dead_code)]
-    fn read_specific_symbol_files(&self, dir: &Path, symbol_ids: &[String]) -> Result<Vec<Vec<u8>>, ProcessError> {
-        let mut symbol_files = Vec::new();
-        let _dir_str = dir.to_string_lossy().to_string(); // Keep but prefix with underscore to avoid warning
+    #[test]
+    fn test_finish_encode_file_incomplete() {
+        let (temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        write_file(&input_path, &generate_test_data(10 * 1024)).expect("Failed to write the input file");
 
-        for symbol_id in symbol_ids {
-            let symbol_path = dir.join(symbol_id);
-            let symbol_path_str = symbol_path.to_string_lossy().to_string();
+        let processor = RaptorQProcessor::new(ProcessorConfig::default());
+        let job = processor.begin_encode_file(
+            input_path.to_str().unwrap(),
+            symbols_dir.to_str().unwrap(),
+            0
+        ).expect("Failed to start the encoding");
 
-            // Try to open the file using FileReader
-            match file_io::open_file_reader(&symbol_path_str) {
-                Ok(mut reader) => {
-                    // Get file size
-                    let size = match reader.file_size() {
-                        Ok(size) => size as usize,
-                        Err(e) => {
-                            debug!("Failed to get file size for symbol {:?}: {}", symbol_path, e);
-                            continue;
-                        }
-                    };
+        let result = processor.finish_encode_file(job);
+        assert!(matches!(result, Err(ProcessError::EncodingFailed(_))));
 
-                    // Read the file content
-                    let mut symbol_data = vec![0u8; size];
-                    match reader.read_chunk(0, &mut symbol_data) {
-                        Ok(bytes_read) if bytes_read == size => {
-                            // Successfully read the file
-                            symbol_files.push(symbol_data);
-                        },
-                        Ok(bytes_read) => {
-                            debug!("Partial read of symbol file {:?}: {} of {} bytes",
-                                  symbol_path, bytes_read, size);
-                            continue;
-                        },
-                        Err(e) => {
-                            debug!("Failed to read symbol file {:?}: {}", symbol_path, e);
-                            continue;
-                        }
-                    }
-                },
-                Err(e) => {
-                    debug!("Failed to open symbol file {:?}: {}", symbol_path, e);
-                    continue;
-                }
-            }
-        }
+        // Ensure temp_dir isn't dropped early
+        drop(temp_dir);
+    }
 
-        if symbol_files.is_empty() {
-            let err = format!("No valid symbol files found from the provided IDs in {:?}", dir);
-            self.set_last_error(err.clone());
-            return Err(ProcessError::DecodingFailed(err));
+    #[test]
+    fn test_encode_bytes_empty() {
+        let processor = RaptorQProcessor::new(ProcessorConfig::default());
+        let result = processor.encode_bytes(&[], 0);
+        assert!(matches!(result, Err(ProcessError::EncodingFailed(_))));
+        assert!(!processor.get_last_error().is_empty());
+    }
+
+    #[test]
+    fn test_decode_bytes_roundtrip() {
+        let config = ProcessorConfig {
+            symbol_size: 1024,
+            ..ProcessorConfig::default()
+        };
+        let processor = RaptorQProcessor::new(config);
+
+        let original_data = generate_test_data(250 * 1024);
+        let (result, mut symbols) = processor.encode_bytes(&original_data, 100 * 1024)
+            .expect("Failed to encode the data");
+        let layout_content = result.layout_content.unwrap();
+
+        // Order of the symbols does not matter
+        symbols.reverse();
+        let decoded = processor.decode_bytes(&symbols, layout_content.as_bytes())
+            .expect("Failed to decode the data");
+        assert_eq!(decoded, original_data);
+    }
+
+    #[test]
+    fn test_decode_bytes_insufficient_symbols() {
+        let config = ProcessorConfig {
+            symbol_size: 1024,
+            ..ProcessorConfig::default()
+        };
+        let processor = RaptorQProcessor::new(config);
+
+        // Make both blocks different, so they don't share symbols
+        let mut original_data = generate_test_data(200 * 1024);
+        original_data[100 * 1024..].iter_mut().for_each(|b| *b ^= 0x55);
+        let (result, symbols) = processor.encode_bytes(&original_data, 100 * 1024)
+            .expect("Failed to encode the data");
+        let layout_content = result.layout_content.unwrap();
+        let layout: RaptorQLayout = serde_json::from_str(&layout_content).unwrap();
+
+        // Drop all but 5 symbols of the first block
+        let first_block: Vec<&String> = layout.blocks[0].symbols.iter().skip(5).collect();
+        let kept: Vec<&Vec<u8>> = symbols.iter()
+            .filter(|s| !first_block.contains(&&get_hash_as_b58(s)))
+            .collect();
+        let kept: Vec<&[u8]> = kept.iter().map(|s| s.as_slice()).collect();
+
+        let result = processor.decode_bytes(&kept, layout_content.as_bytes());
+        match result {
+            Err(ProcessError::InsufficientSymbols(shortfalls)) => {
+                assert_eq!(shortfalls, vec![BlockShortfall { block_id: 0, present: 5, required: 100 }]);
+            },
+            other => panic!("Expected InsufficientSymbols, got {:?}", other.map(|d| d.len())),
         }
+    }
 
-        Ok(symbol_files)
+    #[test]
+    fn test_decode_bytes_invalid_layout() {
+        let processor = RaptorQProcessor::new(ProcessorConfig::default());
+        let symbols: Vec<Vec<u8>> = Vec::new();
+        let result = processor.decode_bytes(&symbols, b"not a layout");
+        assert!(matches!(result, Err(ProcessError::DecodingFailed(_))));
     }
 
-    // Helper methods
+    #[test]
+    fn test_decode_bytes_oversized_layout() {
+        let processor = RaptorQProcessor::new(ProcessorConfig::default());
+        let original_data = generate_test_data(10 * 1024);
+        let (result, symbols) = processor.encode_bytes(&original_data, 0)
+            .expect("Failed to encode the data");
+        let layout: RaptorQLayout = serde_json::from_str(&result.layout_content.unwrap()).unwrap();
 
-    fn can_start_task(&self) -> bool {
-        let current = self.active_tasks.load(Ordering::SeqCst);
-        current < self.config.concurrency_limit as usize
+        // Ends overflowing u64 or past the memory limit are rejected before allocating
+        for (offset, size) in [(u64::MAX, 1), (0, u64::MAX), (1 << 40, 10 * 1024)] {
+            let mut oversized = layout.clone();
+            oversized.blocks[0].original_offset = offset;
+            oversized.blocks[0].size = size;
+            let content = serde_json::to_vec(&oversized).unwrap();
+            let result = processor.decode_bytes(&symbols, &content);
+            assert!(matches!(result, Err(ProcessError::InvalidParameter(_))), "{} {}", offset, size);
+            assert!(processor.get_last_error().contains("memory limit"));
+        }
     }
 
-    fn open_and_validate_file(&self, path: &str) -> Result<(Box<dyn FileReader>, usize), ProcessError> {
-        let file_reader = match file_io::open_file_reader(path) {
-            Ok(reader) => reader,
-            Err(e) => {
-                let err = format!("Failed to open file {:?}: {}", path, e);
-                return Err(ProcessError::FileNotFound(err));
-            }
-        };
+    #[test]
+    fn test_decode_symbols_to_writer() {
+        let (temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
 
-        let file_size = match file_reader.file_size() {
-            Ok(size) => size,
-            Err(e) => {
-                let err = format!("Failed to get file size for {:?}: {}", path, e);
-                return Err(ProcessError::IOError(io::Error::new(io::ErrorKind::Other, err)));
-            }
+        let original_data = generate_test_data(250 * 1024);
+        write_file(&input_path, &original_data).expect("Failed to write the input file");
+
+        let config = ProcessorConfig {
+            symbol_size: 1024,
+            ..ProcessorConfig::default()
         };
+        let processor = RaptorQProcessor::new(config);
+        let result = processor.encode_file(
+            input_path.to_str().unwrap(),
+            symbols_dir.to_str().unwrap(),
+            100 * 1024,
+            false
+        ).expect("Failed to encode the file");
 
-        if file_size == 0 {
-            let err = format!("File is empty: {:?}", path);
-            return Err(ProcessError::EncodingFailed(err));
-        }
+        let mut output = Vec::new();
+        let written = processor.decode_symbols_to_writer(
+            symbols_dir.to_str().unwrap(),
+            &result.layout_file_path,
+            &mut output
+        ).expect("Failed to decode the symbols");
+        assert_eq!(written, original_data.len() as u64);
+        assert_eq!(output, original_data);
 
-        Ok((file_reader, file_size as usize))
+        // Ensure temp_dir isn't dropped early
+        drop(temp_dir);
     }
 
-    fn calculate_repair_symbols(&self, data_len: u64) -> u64 {
-        let redundancy_factor = self.config.redundancy_factor as f64;
-        let symbol_size = self.config.symbol_size as f64;
+    #[test]
+    fn test_decode_symbols_to_writer_with_progress() {
+        // Records how much of the data was flushed
+        struct FlushRecorder<'a> {
+            data: Vec<u8>,
+            flushed: &'a std::cell::Cell<u64>,
+        }
+        impl io::Write for FlushRecorder<'_> {
+            fn write(&mut self, buf: &[u8]) -> io::Result<usize> {
+                self.data.extend_from_slice(buf);
+                Ok(buf.len())
+            }
+            fn flush(&mut self) -> io::Result<()> {
+                self.flushed.set(self.data.len() as u64);
+                Ok(())
+            }
+        }
+
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let original_data: Vec<u8> = (0..250 * 1024).map(|i| (i * 31 / 7 % 251) as u8).collect();
+        write_file(&input_path, &original_data).unwrap();
 
-        if data_len <= self.config.symbol_size as u64 {
-            self.config.redundancy_factor as u64
-        } else {
-            (data_len as f64 * (redundancy_factor - 1.0) / symbol_size).ceil() as u64
+        let processor = RaptorQProcessor::builder().symbol_size(1024).build().unwrap();
+        let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 100 * 1024, false).unwrap();
+
+        // Each block is flushed before its progress is reported
+        let flushed = std::cell::Cell::new(0);
+        let mut output = FlushRecorder { data: Vec::new(), flushed: &flushed };
+        let mut progress = Vec::new();
+        let written = processor.decode_symbols_to_writer_with_progress(
+            symbols_dir.to_str().unwrap(),
+            &result.layout_file_path,
+            &mut output,
+            |bytes_written, bytes_total| {
+                assert_eq!(flushed.get(), bytes_written);
+                progress.push((bytes_written, bytes_total));
+            },
+        ).unwrap();
+        assert_eq!(written, original_data.len() as u64);
+        assert_eq!(output.data, original_data);
+        let total = original_data.len() as u64;
+        assert_eq!(progress, vec![(100 * 1024, total), (200 * 1024, total), (total, total)]);
+
+        // No progress past a block that fails
+        let layout = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+        let block_dir = symbols_dir.join("block_1");
+        for symbol_id in &layout.blocks[1].symbols[10..] {
+            std::fs::remove_file(block_dir.join(symbol_id)).unwrap();
         }
+        let mut progress = Vec::new();
+        let result = processor.decode_symbols_to_writer_with_progress(
+            symbols_dir.to_str().unwrap(),
+            &result.layout_file_path,
+            io::sink(),
+            |bytes_written, _| progress.push(bytes_written),
+        );
+        assert!(matches!(result, Err(ProcessError::InsufficientSymbols(_))), "Unexpected result {:?}", result);
+        assert_eq!(progress, vec![100 * 1024]);
     }
 
-    fn calculate_symbol_id(&self, symbol: &[u8]) -> String {
-        get_hash_as_b58(symbol)
-    }
+    #[test]
+    fn test_decode_symbols_to_writer_at() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let output_path = dir_path.join("output.bin");
 
-    fn estimate_memory_requirements(&self, data_size: usize) -> usize {
-        let mb = 1024 * 1024;
-        let data_mb = (data_size as f64 / mb as f64).ceil() as usize;
-        (data_mb as f64 * RAPTORQ_MEMORY_OVERHEAD_FACTOR).ceil() as usize
-    }
+        let original_data = generate_test_data(250 * 1024);
+        write_file(&input_path, &original_data).expect("Failed to write the input file");
 
-    fn is_memory_available(&self, required_mb: usize) -> bool {
-        required_mb <= self.config.max_memory_mb as usize
+        let config = ProcessorConfig {
+            symbol_size: 1024,
+            ..ProcessorConfig::default()
+        };
+        let processor = RaptorQProcessor::new(config);
+        let result = processor.encode_file(
+            input_path.to_str().unwrap(),
+            symbols_dir.to_str().unwrap(),
+            100 * 1024,
+            false
+        ).expect("Failed to encode the file");
+
+        // Into a preallocated file, which isn't truncated
+        let output = std::fs::OpenOptions::new()
+            .read(true)
+            .write(true)
+            .create(true)
+            .truncate(true)
+            .open(&output_path)
+            .expect("Failed to create the output file");
+        output.set_len(original_data.len() as u64 + 10).expect("Failed to preallocate the output file");
+        let written = processor.decode_symbols_to_writer_at(
+            symbols_dir.to_str().unwrap(),
+            &result.layout_file_path,
+            &output
+        ).expect("Failed to decode the symbols");
+        assert_eq!(written, original_data.len() as u64);
+        drop(output);
+
+        let decoded = read_file(&output_path).expect("Failed to read the output file");
+        assert_eq!(decoded.len(), original_data.len() + 10);
+        assert_eq!(&decoded[..original_data.len()], &original_data[..]);
+
+        // Missing symbols of a block fail the decode
+        std::fs::remove_dir_all(symbols_dir.join("block_1")).expect("Failed to remove the block directory");
+        create_dir(&symbols_dir.join("block_1")).expect("Failed to create the block directory");
+        let output = std::fs::File::create(&output_path).expect("Failed to create the output file");
+        let result = processor.decode_symbols_to_writer_at(
+            symbols_dir.to_str().unwrap(),
+            &result.layout_file_path,
+            &output
+        );
+        assert!(matches!(result, Err(ProcessError::InsufficientSymbols(_))), "{:?}", result);
     }
-}
 
-// RAII guard for task counting
-struct TaskGuard<'a> {
-    counter: &'a AtomicUsize,
-}
+    #[test]
+    fn test_decode_range() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
 
-impl<'a> TaskGuard<'a> {
-    fn new(counter: &'a AtomicUsize) -> Self {
-        counter.fetch_add(1, Ordering::SeqCst);
-        Self { counter }
-    }
-}
+        let original_data = generate_test_data(250 * 1024);
+        write_file(&input_path, &original_data).expect("Failed to write the input file");
 
-impl<'a> Drop for TaskGuard<'a> {
-    fn drop(&mut self) {
-        self.counter.fetch_sub(1, Ordering::SeqCst);
-    }
-}
+        let config = ProcessorConfig { symbol_size: 1024, ..ProcessorConfig::default() };
+        let processor = RaptorQProcessor::new(config);
+        let result = processor.encode_file(
+            input_path.to_str().unwrap(),
+            symbols_dir.to_str().unwrap(),
+            100 * 1024,
+            false
+        ).expect("Failed to encode the file");
+        let symbols_dir = symbols_dir.to_str().unwrap();
+        let layout_path = &result.layout_file_path;
+
+        let decode = |offset: u64, length: u64| {
+            let mut output = Vec::new();
+            processor.decode_range(symbols_dir, layout_path, offset, length, &mut output).map(|written| {
+                assert_eq!(written, output.len() as u64);
+                output
+            })
+        };
 
-#[cfg(test)]
-mod tests {
-    use std::collections::HashMap;
-    use super::*;
-    use std::path::PathBuf;
-    use std::sync::Arc;
-    use tempfile::{tempdir, TempDir};
-    use rand::{seq::SliceRandom, thread_rng};
+        // Within a block, across blocks, the whole data and the end of the data
+        for (offset, length) in [(1000, 500), (90 * 1024, 20 * 1024), (0, 250 * 1024), (250 * 1024 - 10, 10)] {
+            let range = offset as usize..(offset + length) as usize;
+            assert_eq!(decode(offset, length).unwrap(), &original_data[range]);
+        }
+        assert!(decode(250 * 1024, 0).unwrap().is_empty());
 
-    // Helper functions to replace fs:: calls in tests with file_io abstractions
-    fn create_dir(path: &Path) -> io::Result<()> {
-        let path_str = path.to_string_lossy().to_string();
-        file_io::get_dir_manager().create_dir_all(&path_str)
-            .map_err(|e| io::Error::new(io::ErrorKind::Other, e))
-    }
+        assert!(matches!(decode(250 * 1024 - 10, 11), Err(ProcessError::InvalidParameter(_))));
+        assert!(matches!(decode(u64::MAX, 2), Err(ProcessError::InvalidParameter(_))));
 
-    fn write_file(path: &Path, contents: &[u8]) -> io::Result<()> {
-        let path_str = path.to_string_lossy().to_string();
-        let mut writer = file_io::open_file_writer(&path_str)
-            .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
-        writer.write_chunk(0, contents)
-            .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
-        writer.flush()
-            .map_err(|e| io::Error::new(io::ErrorKind::Other, e))
+        // Blocks outside the range are not decoded
+        std::fs::remove_dir_all(Path::new(symbols_dir).join(block_dir_name(0))).unwrap();
+        assert_eq!(decode(210 * 1024, 1024).unwrap(), &original_data[210 * 1024..211 * 1024]);
+        assert!(matches!(decode(0, 10), Err(ProcessError::InsufficientSymbols(_))));
     }
 
-    fn read_file(path: &Path) -> io::Result<Vec<u8>> {
-        let path_str = path.to_string_lossy().to_string();
-        let mut reader = file_io::open_file_reader(&path_str)
-            .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
-        let size = reader.file_size()
-            .map_err(|e| io::Error::new(io::ErrorKind::Other, e))? as usize;
-        let mut data = vec![0u8; size];
-        reader.read_chunk(0, &mut data)
-            .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
-        Ok(data)
-    }
+    #[test]
+    fn test_decode_from_channel() {
+        let original_data = generate_test_data(30 * 1024);
+        let processor = RaptorQProcessor::builder().symbol_size(1024).build().unwrap();
+        let (result, symbols) = processor.encode_bytes(&original_data, 10 * 1024).unwrap();
+        let layout = result.layout.unwrap();
+        let blocks = result.blocks.unwrap();
+        let symbols: Vec<EncodedSymbol> = layout.blocks.iter()
+            .flat_map(|b| b.symbols.iter().map(move |_| b.block_id))
+            .zip(symbols)
+            .map(|(block_id, data)| {
+                let esi = symbol_esi(&data).unwrap();
+                EncodedSymbol { block_id, esi, is_repair: esi as u64 >= blocks[block_id].source_symbols_count, data }
+            })
+            .collect();
 
-    fn read_file_to_string(path: &Path) -> io::Result<String> {
-        let data = read_file(path)?;
-        String::from_utf8(data)
-            .map_err(|e| io::Error::new(io::ErrorKind::InvalidData, e.to_string()))
+        // The decode returns once every block is decoded, the remaining symbols can't be sent
+        let (sender, receiver) = mpsc::sync_channel(0);
+        let mut output = Vec::new();
+        let (written, sent) = std::thread::scope(|s| {
+            let send = s.spawn(|| symbols.iter().take_while(|symbol| sender.send((*symbol).clone()).is_ok()).count());
+            let written = processor.decode_from_channel(receiver, &layout, &mut output).unwrap();
+            (written, send.join().unwrap())
+        });
+        assert_eq!(written, original_data.len() as u64);
+        assert_eq!(output, original_data);
+        assert!(sent < symbols.len(), "Sent {} of {} symbols", sent, symbols.len());
+
+        // Senders dropped before the end leave blocks undecoded
+        let (sender, receiver) = mpsc::sync_channel(symbols.len());
+        for symbol in symbols.iter().filter(|s| s.block_id != 2) {
+            sender.send(symbol.clone()).unwrap();
+        }
+        drop(sender);
+        match processor.decode_from_channel(receiver, &layout, Vec::new()) {
+            Err(ProcessError::InsufficientSymbols(shortfalls)) => {
+                assert_eq!(shortfalls, vec![BlockShortfall { block_id: 2, present: 0, required: 10 }]);
+            },
+            other => panic!("Expected InsufficientSymbols, got {:?}", other),
+        }
     }
 
-    // Limited check if a path exists - used for tests only
-    fn path_exists(path: &Path) -> bool {
-        let path_str = path.to_string_lossy().to_string();
-        file_io::open_file_reader(&path_str).is_ok()
+    #[test]
+    fn test_decode_from_source_spills_to_temp_dir() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let scratch_dir = dir_path.join("scratch");
+        let original_data: Vec<u8> = (0..1600 * 1024).map(|i| (i * 31 / 7 % 251) as u8).collect();
+        write_file(&input_path, &original_data).unwrap();
+
+        let encoder = RaptorQProcessor::builder().symbol_size(32 * 1024).build().unwrap();
+        let result = encoder.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 400 * 1024, false).unwrap();
+        let layout = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+        assert_eq!(layout.blocks.len(), 4);
+
+        // The last blocks come first, block 1 doesn't fit in the memory limit once
+        // blocks 3 and 2 wait
+        let processor = RaptorQProcessor::builder().max_memory_mb(1).temp_dir(&scratch_dir).build().unwrap();
+        assert_eq!(processor.temp_dir(), Some(scratch_dir.clone()));
+        let scratch_files = std::cell::Cell::new(0);
+        let symbols = layout.blocks.iter().rev().flat_map(|block_layout| {
+            let block_dir = symbols_dir.join(block_dir_name(block_layout.block_id));
+            block_layout.symbols.iter().map(move |symbol_id| (block_layout.block_id, block_dir.join(symbol_id)))
+        }).map(|(block_id, path)| {
+            if block_id == 0 {
+                scratch_files.set(scratch_files.get().max(std::fs::read_dir(&scratch_dir).map_or(0, |d| d.count())));
+            }
+            Ok((block_id, read_file(&path).unwrap()))
+        });
+        let mut output = Vec::new();
+        let written = processor.decode_from_source(symbols, &result.layout_file_path, &mut output).unwrap();
+        assert_eq!(written, original_data.len() as u64);
+        assert_eq!(output, original_data);
+        assert_eq!(scratch_files.get(), 1, "Block 1 should wait in a scratch file");
+        assert_eq!(std::fs::read_dir(&scratch_dir).unwrap().count(), 0, "The scratch file should be removed");
+
+        // The system temp directory by default
+        processor.set_temp_dir(None);
+        assert_eq!(processor.temp_dir(), Some(std::env::temp_dir()));
     }
 
-    // Helper function to create an empty file
-    fn create_empty_file(path: &Path) -> io::Result<()> {
-        write_file(path, &[]).map_err(|e| io::Error::new(io::ErrorKind::Other, e))
-    }
+    #[test]
+    fn test_scratch_file_is_private() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let mut first = ScratchFile::create(&dir_path).unwrap();
+        let second = ScratchFile::create(&dir_path).unwrap();
+        assert_ne!(first.path, second.path);
+        assert_eq!(first.append(b"data").unwrap(), 0);
+        assert_eq!(first.read(0, 4).unwrap(), b"data");
+        #[cfg(unix)]
+        {
+            use std::os::unix::fs::PermissionsExt;
+            let mode = std::fs::metadata(&first.path).unwrap().permissions().mode();
+            assert_eq!(mode & 0o777, 0o600, "Only the owner should access the scratch file");
+        }
 
-    // Creates a temporary directory and returns its path
-    fn create_temp_dir() -> (TempDir, PathBuf) {
-        let dir = tempdir().expect("Failed to create temp directory");
-        let path = dir.path().to_path_buf();
-        (dir, path)
+        // An existing file or symlink is never opened
+        let planted = dir_path.join("planted");
+        write_file(&planted, b"planted").unwrap();
+        assert_eq!(file_io::create_new_file_writer(&planted.to_string_lossy()).err().map(|e| e.kind()), Some(io::ErrorKind::AlreadyExists));
+        #[cfg(unix)]
+        {
+            let link = dir_path.join("link");
+            std::os::unix::fs::symlink(&planted, &link).unwrap();
+            assert_eq!(file_io::create_new_file_writer(&link.to_string_lossy()).err().map(|e| e.kind()), Some(io::ErrorKind::AlreadyExists));
+        }
+        assert_eq!(read_file(&planted).unwrap(), b"planted");
     }
 
-    // Creates a test file with the specified size at a given path
-    fn create_test_file(path: &Path, size: usize) -> io::Result<()> {
-        let data = generate_test_data(size);
-        write_file(path, &data).map_err(|e| io::Error::new(io::ErrorKind::Other, e))
+    #[test]
+    fn test_decode_from_source() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+
+        // 3 different blocks of 10 source symbols
+        let original_data: Vec<u8> = (0..30 * 1024).map(|i| (i % 251) as u8 ^ (i / 10240) as u8).collect();
+        write_file(&input_path, &original_data).expect("Failed to write the input file");
+
+        let config = ProcessorConfig { symbol_size: 1024, ..ProcessorConfig::default() };
+        let processor = RaptorQProcessor::new(config);
+        let result = processor.encode_file(
+            input_path.to_str().unwrap(),
+            symbols_dir.to_str().unwrap(),
+            10 * 1024,
+            false
+        ).expect("Failed to encode the file");
+        let layout: RaptorQLayout = serde_json::from_str(
+            &read_file_to_string(Path::new(&result.layout_file_path)).unwrap()
+        ).unwrap();
+
+        let mut all_symbols: Vec<(usize, Vec<u8>)> = layout.blocks.iter()
+            .flat_map(|b| b.symbols.iter().map(move |id| (b.block_id, id)))
+            .map(|(block_id, id)| {
+                let path = symbols_dir.join(format!("block_{}", block_id)).join(id);
+                (block_id, read_file(&path).unwrap())
+            })
+            .collect();
+        all_symbols.shuffle(&mut thread_rng());
+
+        // Symbols are pulled only until every block is decoded
+        let mut pulled = 0;
+        let source = all_symbols.iter().cloned().inspect(|_| pulled += 1).map(Ok);
+        let mut output = Vec::new();
+        let written = processor.decode_from_source(source, &result.layout_file_path, &mut output)
+            .expect("Failed to decode the symbols");
+        assert_eq!(written, original_data.len() as u64);
+        assert_eq!(output, original_data);
+        assert!(pulled < all_symbols.len(), "Pulled {} of {} symbols", pulled, all_symbols.len());
+
+        // Only 5 symbols of the second block, symbols of unknown blocks are ignored
+        let mut block_1_count = 0;
+        let source = std::iter::once((7, all_symbols[0].1.clone()))
+            .chain(all_symbols.iter().cloned())
+            .filter(|(block_id, _)| *block_id != 1 || { block_1_count += 1; block_1_count <= 5 })
+            .map(Ok);
+        let result_err = processor.decode_from_source(source, &result.layout_file_path, Vec::new());
+        match result_err {
+            Err(ProcessError::InsufficientSymbols(shortfalls)) => {
+                assert_eq!(shortfalls, vec![BlockShortfall { block_id: 1, present: 5, required: 10 }]);
+            },
+            other => panic!("Expected InsufficientSymbols, got {:?}", other),
+        }
+
+        // Symbols given again don't count, more are pulled until every block decodes
+        let mut pulled = 0;
+        let source = all_symbols.iter().flat_map(|s| [s.clone(), s.clone()]).inspect(|_| pulled += 1).map(Ok);
+        let mut output = Vec::new();
+        processor.decode_from_source(source, &result.layout_file_path, &mut output).unwrap();
+        assert_eq!(output, original_data);
+        assert!(pulled >= 2 * 30 - 1, "Pulled {} symbols", pulled);
+
+        // Without enough symbols, the whole source is read before giving up
+        let without_block_2: Vec<_> = all_symbols.iter().filter(|(block_id, _)| *block_id != 2).cloned().collect();
+        let mut pulled = 0;
+        let source = without_block_2.iter().cloned().inspect(|_| pulled += 1).map(Ok);
+        let result_err = processor.decode_from_source(source, &result.layout_file_path, Vec::new());
+        assert!(matches!(result_err, Err(ProcessError::InsufficientSymbols(_))));
+        assert_eq!(pulled, without_block_2.len());
+
+        // An endless source of useless symbols is read up to the limit
+        processor.set_max_source_symbols(Some(100));
+        let mut pulled = 0;
+        let source = std::iter::repeat(all_symbols[0].clone()).inspect(|_| pulled += 1).map(Ok);
+        let result_err = processor.decode_from_source(source, &result.layout_file_path, Vec::new());
+        assert!(matches!(result_err, Err(ProcessError::InsufficientSymbols(_))));
+        assert_eq!(pulled, 100);
+        assert_eq!(processor.get_last_shortfalls().len(), 3);
+        // The limit leaves enough symbols to decode
+        let mut output = Vec::new();
+        processor.decode_from_source(all_symbols.iter().cloned().map(Ok), &result.layout_file_path, &mut output).unwrap();
+        assert_eq!(output, original_data);
+        processor.set_max_source_symbols(None);
+
+        // Read errors of the source are returned
+        let source = vec![Err(io::Error::new(io::ErrorKind::Other, "fetch failed"))];
+        let result_err = processor.decode_from_source(source, &result.layout_file_path, Vec::new());
+        assert!(matches!(result_err, Err(ProcessError::IOError(_))));
     }
 
-    // Generates test data of specified size
-    fn generate_test_data(size: usize) -> Vec<u8> {
-        let mut data = Vec::with_capacity(size);
-        for i in 0..size {
-            data.push((i % 256) as u8);
+    #[test]
+    fn test_decode_from_store() {
+        use crate::store::MemoryStore;
+
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        // Blocks with different data, so they have no symbol in common
+        let original_data: Vec<u8> = (0..30 * 1024).map(|i| (i * 31 / 7 % 251) as u8).collect();
+        write_file(&input_path, &original_data).unwrap();
+
+        // 3 blocks of 10 source symbols each
+        let config = ProcessorConfig { symbol_size: 1024, ..ProcessorConfig::default() };
+        let processor = RaptorQProcessor::new(config);
+        let result = processor.encode_file(
+            input_path.to_str().unwrap(),
+            symbols_dir.to_str().unwrap(),
+            10 * 1024,
+            false
+        ).expect("Failed to encode the file");
+
+        // Copy the symbols directory into the store, under "objects/file"
+        let mut store = MemoryStore::new();
+        store.insert("objects/file/layout.json", std::fs::read(&result.layout_file_path).unwrap());
+        for block_id in 0..3 {
+            for entry in std::fs::read_dir(symbols_dir.join(block_dir_name(block_id))).unwrap() {
+                let path = entry.unwrap().path();
+                let name = path.file_name().unwrap().to_str().unwrap();
+                store.insert(&format!("objects/file/{}", symbol_path(block_id, name)), std::fs::read(&path).unwrap());
+            }
         }
-        data
+
+        let mut output = Vec::new();
+        let written = processor.decode_from_store(&store, "objects/file", "objects/file/layout.json", &mut output).unwrap();
+        assert_eq!(written, original_data.len() as u64);
+        assert_eq!(output, original_data);
+
+        // Symbols of a block found in the symbols directory itself
+        let layout = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+        let block_symbols = |block_id: usize| &layout.blocks.iter().find(|b| b.block_id == block_id).unwrap().symbols;
+        for symbol_id in block_symbols(2) {
+            let data = store.remove(&format!("objects/file/{}", symbol_path(2, symbol_id))).unwrap();
+            store.insert(&format!("objects/file/{}", symbol_id), data);
+        }
+        let mut output = Vec::new();
+        processor.decode_from_store(&store, "objects/file", "objects/file/layout.json", &mut output).unwrap();
+        assert_eq!(output, original_data);
+
+        // Keep 5 symbols of block 1
+        for symbol_id in block_symbols(1).iter().skip(5) {
+            store.remove(&format!("objects/file/{}", symbol_path(1, symbol_id)));
+        }
+        let err = processor.decode_from_store(&store, "objects/file", "objects/file/layout.json", io::sink()).unwrap_err();
+        assert!(matches!(err, ProcessError::InsufficientSymbols(_)));
+        let shortfalls = processor.get_last_shortfalls();
+        assert_eq!(shortfalls.len(), 1);
+        assert_eq!((shortfalls[0].block_id, shortfalls[0].present), (1, 5));
+
+        assert!(matches!(
+            processor.decode_from_store(&store, "objects/file", "objects/file/missing.json", io::sink()),
+            Err(ProcessError::FileNotFound(_))
+        ));
     }
 
-    // Creates encoded symbols for a given data vector
-    fn encode_test_data(data: &[u8], symbol_size: u16, repair_symbols: u64) -> (Vec<u8>, Vec<Vec<u8>>) {
-        let config = ObjectTransmissionInformation::with_defaults(
-            data.len() as u64,
-            symbol_size,
-        );
-        
-        let encoder = Encoder::new(data, config);
-        let packets = encoder.get_encoded_packets(repair_symbols as u32);
-        
-        let mut serialized_packets = Vec::new();
-        for packet in &packets {
-            serialized_packets.push(packet.serialize());
+    #[test]
+    fn test_decode_from_tar() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let output_path = dir_path.join("output.bin");
+
+        let original_data: Vec<u8> = (0..30 * 1024).map(|i| (i % 251) as u8 ^ (i / 10240) as u8).collect();
+        write_file(&input_path, &original_data).expect("Failed to write the input file");
+
+        let config = ProcessorConfig { symbol_size: 1024, ..ProcessorConfig::default() };
+        let processor = RaptorQProcessor::new(config);
+        let result = processor.encode_file(
+            input_path.to_str().unwrap(),
+            symbols_dir.to_str().unwrap(),
+            10 * 1024,
+            false
+        ).expect("Failed to encode the file");
+        let layout_content = read_file(Path::new(&result.layout_file_path)).unwrap();
+        let layout: RaptorQLayout = serde_json::from_slice(&layout_content).unwrap();
+
+        // Layout first, then the symbols block by block, under a top directory
+        let append = |builder: &mut tar::Builder<Vec<u8>>, name: String, data: &[u8]| {
+            let mut header = tar::Header::new_gnu();
+            header.set_size(data.len() as u64);
+            header.set_mode(0o644);
+            header.set_cksum();
+            builder.append_data(&mut header, name, data).unwrap();
+        };
+        let build_tar = |with_layout: bool| {
+            let mut builder = tar::Builder::new(Vec::new());
+            if with_layout {
+                append(&mut builder, format!("object/{}", LAYOUT_FILENAME), &layout_content);
+            }
+            for block in &layout.blocks {
+                for id in &block.symbols {
+                    let name = format!("object/block_{}/{}", block.block_id, id);
+                    let symbol = read_file(&symbols_dir.join(format!("block_{}", block.block_id)).join(id)).unwrap();
+                    append(&mut builder, name, &symbol);
+                }
+            }
+            builder.into_inner().unwrap()
+        };
+        let tar_path = dir_path.join("object.tar");
+        let tar_data = build_tar(true);
+        write_file(&tar_path, &tar_data).unwrap();
+
+        processor.decode_from_tar(tar_path.to_str().unwrap(), output_path.to_str().unwrap(), None)
+            .expect("Failed to decode the tar archive");
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
+
+        // Layout given as a file
+        let no_layout_path = dir_path.join("no_layout.tar");
+        write_file(&no_layout_path, &build_tar(false)).unwrap();
+        std::fs::remove_file(&output_path).unwrap();
+        processor.decode_from_tar(no_layout_path.to_str().unwrap(), output_path.to_str().unwrap(), Some(&result.layout_file_path))
+            .expect("Failed to decode the tar archive with the layout file");
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
+
+        let result_err = processor.decode_from_tar(no_layout_path.to_str().unwrap(), output_path.to_str().unwrap(), None);
+        assert!(matches!(result_err, Err(ProcessError::FileNotFound(_))), "Got {:?}", result_err);
+
+        // Cut in the middle of an entry, before the symbols of the last block
+        let truncated_path = dir_path.join("truncated.tar");
+        write_file(&truncated_path, &tar_data[..tar_data.len() / 2 + 100]).unwrap();
+        let result_err = processor.decode_from_tar(truncated_path.to_str().unwrap(), output_path.to_str().unwrap(), None);
+        match result_err {
+            Err(ProcessError::IOError(e)) => assert!(e.to_string().contains("tar archive"), "Got {}", e),
+            other => panic!("Expected IOError, got {:?}", other),
         }
-        
-        (encoder.get_config().serialize().to_vec(), serialized_packets)
+        assert!(processor.get_last_error().contains("tar archive"));
+
+        // A symbol entry whose header claims far more than a symbol, cut right after it
+        let mut builder = tar::Builder::new(Vec::new());
+        append(&mut builder, format!("object/{}", LAYOUT_FILENAME), &layout_content);
+        let mut oversized_data = builder.into_inner().unwrap();
+        oversized_data.truncate(oversized_data.len() - 1024); // Without the end of the archive
+        let mut header = tar::Header::new_gnu();
+        header.set_path(format!("object/block_0/{}", layout.blocks[0].symbols[0])).unwrap();
+        header.set_size(1 << 40);
+        header.set_mode(0o644);
+        header.set_cksum();
+        oversized_data.extend_from_slice(header.as_bytes());
+        oversized_data.extend_from_slice(&[0; 1024]);
+        let oversized_path = dir_path.join("oversized.tar");
+        write_file(&oversized_path, &oversized_data).unwrap();
+        let result_err = processor.decode_from_tar(oversized_path.to_str().unwrap(), output_path.to_str().unwrap(), None);
+        match result_err {
+            Err(ProcessError::IOError(e)) => assert!(e.to_string().contains("larger than the largest valid"), "Got {}", e),
+            other => panic!("Expected IOError, got {:?}", other),
+        }
+
+        let result_err = processor.decode_from_tar(dir_path.join("missing.tar").to_str().unwrap(), output_path.to_str().unwrap(), None);
+        assert!(matches!(result_err, Err(ProcessError::FileNotFound(_))));
     }
 
-    // Creates symbol files in a directory from serialized packets
-    fn create_symbol_files(dir: &Path, packets: &[Vec<u8>]) -> io::Result<Vec<String>> {
-        let mut file_paths = Vec::new();
-        
-        for (i, packet) in packets.iter().enumerate() {
-            let file_path = dir.join(format!("symbol_{}.bin", i));
-            write_file(&file_path, packet).map_err(|e| io::Error::new(io::ErrorKind::Other, e))?;
-            file_paths.push(file_path.to_string_lossy().into_owned());
+    #[test]
+    fn test_encode_file_to_archive() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let archive_path = dir_path.join("object.tar");
+        let output_path = dir_path.join("output.bin");
+
+        let original_data = generate_test_data(250 * 1024);
+        write_file(&input_path, &original_data).expect("Failed to write the input file");
+
+        let config = ProcessorConfig { symbol_size: 1024, ..ProcessorConfig::default() };
+        let processor = RaptorQProcessor::new(config);
+        let result = processor.encode_file_to_archive(
+            input_path.to_str().unwrap(),
+            archive_path.to_str().unwrap(),
+            100 * 1024,
+        ).expect("Failed to encode the file to an archive");
+        assert_eq!(result.blocks.as_ref().unwrap().len(), 3);
+        assert_eq!(result.symbols_directory, archive_path.to_str().unwrap());
+
+        // Symbols block after block, then the layout
+        let layout = result.layout.clone().unwrap();
+        let mut expected_names: Vec<String> = layout.blocks.iter()
+            .flat_map(|b| b.symbols.iter().map(move |id| format!("block_{}/{}", b.block_id, id)))
+            .collect();
+        expected_names.push(LAYOUT_FILENAME.to_string());
+        let mut archive = tar::Archive::new(std::fs::File::open(&archive_path).unwrap());
+        let mut names = Vec::new();
+        for entry in archive.entries().unwrap() {
+            let mut entry = entry.unwrap();
+            names.push(entry.path().unwrap().to_string_lossy().to_string());
+            let mut content = Vec::new();
+            entry.read_to_end(&mut content).unwrap();
+            if names.last().unwrap() != LAYOUT_FILENAME {
+                assert_eq!(&get_hash_as_b58(&content), names.last().unwrap().rsplit('/').next().unwrap());
+            } else {
+                assert_eq!(Some(String::from_utf8(content).unwrap()), result.layout_content);
+            }
         }
-        
-        Ok(file_paths)
-    }
+        assert_eq!(names, expected_names);
 
-    fn create_block_layout(original_data: &Vec<u8>, encoder_params: Vec<u8>, packets: Vec<Vec<u8>>) -> BlockLayout {
-        let block_layout = BlockLayout {
-            block_id: 0,
-            encoder_parameters: encoder_params.to_vec(),
-            original_offset: 0,
-            size: original_data.len() as u64,
-            symbols: packets.iter().enumerate().map(|(i, _)| format!("symbol_{}.bin", i)).collect(),
-            hash: get_hash_as_b58(&original_data),
-        };
-        block_layout
+        processor.decode_from_archive(archive_path.to_str().unwrap(), output_path.to_str().unwrap())
+            .expect("Failed to decode the archive");
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
+
+        let result_err = processor.encode_file_to_archive(
+            dir_path.join("missing.bin").to_str().unwrap(),
+            archive_path.to_str().unwrap(),
+            0,
+        );
+        assert!(matches!(result_err, Err(ProcessError::FileNotFound(_))));
     }
 
-    fn count_files_in_dir(dir: &Path) -> usize {
-        let dir_manager = file_io::get_dir_manager();
-        let dir = dir.to_string_lossy().to_string();
-        dir_manager.count_files(&dir).unwrap()
+    #[test]
+    fn test_check_path() {
+        for path in ["input.bin", "/data/my files/input.bin", "C:\\données\\文件 🎉.bin", "./a/../b"] {
+            assert!(check_path(path).is_ok(), "{} should be accepted", path);
+        }
+        assert!(check_path(&"a".repeat(MAX_PATH_COMPONENT_LEN_B)).is_ok());
+        assert!(check_path(&"a/".repeat(MAX_PATH_LEN_B / 2)).is_ok());
+
+        let long_name = format!("/data/{}/input.bin", "é".repeat(MAX_PATH_COMPONENT_LEN_B / 2 + 1));
+        let long_path = "a/".repeat(MAX_PATH_LEN_B / 2 + 1);
+        for path in ["", "input\0.bin", long_name.as_str(), long_path.as_str()] {
+            assert!(matches!(check_path(path), Err(ProcessError::InvalidPath(_))), "{:?} should be rejected", path);
+        }
+        assert!(check_path(&long_path).unwrap_err().to_string().contains("longer than 4095 bytes"));
     }
 
-    // Tests for ProcessorConfig
+    #[test]
+    fn test_unicode_and_long_paths() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let processor = RaptorQProcessor::new(ProcessorConfig { symbol_size: 1024, ..ProcessorConfig::default() });
+        let data = generate_test_data(5000);
+
+        // Spaces and Unicode round-trip, and paths close to the limit still leave room
+        // for the block directories and symbol ids below the output directory
+        let mut long_dir = dir_path.join("long");
+        while long_dir.to_str().unwrap().len() + 201 < MAX_PATH_LEN_B - 128 {
+            long_dir.push("d".repeat(200));
+        }
+        let remaining = MAX_PATH_LEN_B - 128 - long_dir.to_str().unwrap().len() - 1;
+        long_dir.push("l".repeat(remaining));
+        assert_eq!(long_dir.to_str().unwrap().len(), MAX_PATH_LEN_B - 128);
+        std::fs::create_dir_all(&long_dir).unwrap();
+
+        for dir in [dir_path.join("données 文件 🎉"), long_dir] {
+            std::fs::create_dir_all(&dir).unwrap();
+            let input_path = dir.join("entrée é.bin");
+            let symbols_dir = dir.join("symbols");
+            let output_path = dir.join("sortie é.bin");
+            write_file(&input_path, &data).unwrap();
+
+            processor
+                .encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 0, false)
+                .expect("Encoding should succeed");
+            processor
+                .decode_symbols(
+                    symbols_dir.to_str().unwrap(),
+                    output_path.to_str().unwrap(),
+                    symbols_dir.join(LAYOUT_FILENAME).to_str().unwrap(),
+                )
+                .expect("Decoding should succeed");
+            assert_eq!(read_file(&output_path).unwrap(), data);
+        }
+
+        // Paths over the limit fail before anything is written
+        let too_long = format!("{}/{}", dir_path.to_str().unwrap(), "a/".repeat(MAX_PATH_LEN_B / 2));
+        let input_path = dir_path.join("input.bin");
+        write_file(&input_path, &data).unwrap();
+        let result = processor.encode_file(input_path.to_str().unwrap(), &too_long, 0, false);
+        assert!(matches!(result, Err(ProcessError::InvalidPath(_))), "Unexpected result {:?}", result);
+        assert!(processor.get_last_error().contains("longer than"));
+        assert!(!path_exists(&dir_path.join("a")));
+
+        let result = processor.decode_symbols(&too_long, dir_path.join("output.bin").to_str().unwrap(), &too_long);
+        assert!(matches!(result, Err(ProcessError::InvalidPath(_))), "Unexpected result {:?}", result);
+    }
 
     #[test]
-    fn test_config_default() {
-        let config = ProcessorConfig::default();
-        
-        assert_eq!(config.symbol_size, DEFAULT_SYMBOL_SIZE_B);
-        assert_eq!(config.redundancy_factor, DEFAULT_REDUNDANCY_FACTOR);
-        assert_eq!(config.max_memory_mb, DEFAULT_MAX_MEMORY_MB);
-        assert_eq!(config.concurrency_limit, DEFAULT_CONCURRENCY_LIMIT);
+    fn test_symbol_naming() {
+        assert_eq!(block_dir_name(4), "block_4");
+        assert_eq!(symbol_path(4, "abc"), "block_4/abc");
+        assert_eq!(parse_symbol_path("block_4/abc").unwrap(), (4, "abc".to_string()));
+        assert_eq!(parse_symbol_path("./symbols/block_10/abc").unwrap(), (10, "abc".to_string()));
+        assert_eq!(parse_symbol_path("C:\\symbols\\block_2\\abc").unwrap(), (2, "abc".to_string()));
+        assert_eq!(parse_symbol_path("symbols\\block_3/abc").unwrap(), (3, "abc".to_string()));
+        for path in ["abc", "block_x/abc", "blocks_1/abc", "block_1/", "block_1\\", "block_1/..", "_raptorq_layout.json"] {
+            assert!(matches!(parse_symbol_path(path), Err(ProcessError::InvalidPath(_))), "{} should not parse", path);
+        }
+
+        let processor = RaptorQProcessor::new(ProcessorConfig { symbol_size: 1024, ..ProcessorConfig::default() });
+        let data = generate_test_data(20 * 1024);
+        let (result, symbols) = processor.encode_bytes(&data, 0).expect("Failed to encode the data");
+        let block_layout = &result.layout.unwrap().blocks[0];
+        let source_symbols = block_layout.source_symbols_count();
+        assert_eq!(source_symbols, 20);
+
+        let mut esis: Vec<u32> = symbols.iter().map(|s| symbol_esi(s).unwrap()).collect();
+        esis.sort();
+        assert_eq!(esis, (0..symbols.len() as u32).collect::<Vec<_>>());
+
+        let repair_count = symbols.iter().filter(|s| block_layout.is_repair_symbol(s).unwrap()).count();
+        assert_eq!(repair_count as u64, symbols.len() as u64 - source_symbols);
+        assert_eq!(symbol_esi(&[0, 1]), None);
+        assert_eq!(block_layout.is_repair_symbol(&[0, 1]), None);
+
+        // Split into source blocks of 11 and 10 source symbols, each numbering its symbols
+        let data = generate_test_data(21 * 1024);
+        let config = ObjectTransmissionInformation::new(21 * 1024, 1024, 2, 1, 8);
+        let split_layout = BlockLayout { encoder_parameters: config.serialize().to_vec(), ..block_layout.clone() };
+        let flags: Vec<(u8, u32, bool)> = Encoder::new(&data, config).get_encoded_packets(2).iter()
+            .map(|packet| packet.serialize())
+            .map(|symbol| (symbol[0], symbol_esi(&symbol).unwrap(), split_layout.is_repair_symbol(&symbol).unwrap()))
+            .collect();
+        let expected: Vec<(u8, u32, bool)> = [(0u8, 11u32), (1, 10)].iter()
+            .flat_map(|&(source_block, k)| (0..k + 2).map(move |esi| (source_block, esi, esi >= k)))
+            .collect();
+        assert_eq!(flags, expected);
+        assert_eq!(split_layout.is_repair_symbol(&[2, 0, 0, 0]), None, "No source block 2");
     }
 
     #[test]
-    fn test_config_custom() {
+    fn test_decode_symbols_to_writer_stops_at_failed_block() {
+        let (temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+
+        let original_data = generate_test_data(300 * 1024);
+        write_file(&input_path, &original_data).expect("Failed to write the input file");
+
         let config = ProcessorConfig {
-            symbol_size: 1000,
-            redundancy_factor: 20,
-            max_memory_mb: 512,
-            concurrency_limit: 8,
+            symbol_size: 1024,
+            ..ProcessorConfig::default()
         };
-        
-        assert_eq!(config.symbol_size, 1000);
-        assert_eq!(config.redundancy_factor, 20);
-        assert_eq!(config.max_memory_mb, 512);
-        assert_eq!(config.concurrency_limit, 8);
-    }
+        let processor = RaptorQProcessor::new(config);
+        let result = processor.encode_file(
+            input_path.to_str().unwrap(),
+            symbols_dir.to_str().unwrap(),
+            100 * 1024,
+            false
+        ).expect("Failed to encode the file");
+
+        // A layout missing the second block fails as soon as the third one is decoded
+        let mut gap_layout = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+        gap_layout.blocks.remove(1);
+        let gap_layout_path = dir_path.join("gap.json");
+        write_file(&gap_layout_path, serde_json::to_string(&gap_layout).unwrap().as_bytes()).unwrap();
+        let mut output = Vec::new();
+        let gap_result = processor.decode_symbols_to_writer(symbols_dir.to_str().unwrap(), gap_layout_path.to_str().unwrap(), &mut output);
+        match gap_result {
+            Err(ProcessError::DecodingFailed(e)) => assert!(e.contains("block 2 at offset 204800 after 102400 bytes"), "Got {}", e),
+            other => panic!("Expected DecodingFailed, got {:?}", other),
+        }
+        assert_eq!(output, original_data[..100 * 1024]);
 
-    // Tests for RaptorQProcessor::new
+        // Remove the symbols of the second and third blocks
+        for block_id in 1..3 {
+            std::fs::remove_dir_all(symbols_dir.join(block_dir_name(block_id))).expect("Failed to remove the block directory");
+            create_dir(&symbols_dir.join(block_dir_name(block_id))).expect("Failed to create the block directory");
+        }
 
-    #[test]
-    fn test_new_processor() {
-        let config = ProcessorConfig::default();
-        let processor = RaptorQProcessor::new(config.clone());
-        
-        // Verify the config is stored correctly
-        assert_eq!(processor.config.symbol_size, config.symbol_size);
-        assert_eq!(processor.config.redundancy_factor, config.redundancy_factor);
-        assert_eq!(processor.config.max_memory_mb, config.max_memory_mb);
-        assert_eq!(processor.config.concurrency_limit, config.concurrency_limit);
+        let mut output = Vec::new();
+        let result = processor.decode_symbols_to_writer(
+            symbols_dir.to_str().unwrap(),
+            &result.layout_file_path,
+            &mut output
+        );
+        // The third block isn't tried
+        match result {
+            Err(ProcessError::InsufficientSymbols(shortfalls)) => {
+                assert_eq!(shortfalls.iter().map(|s| s.block_id).collect::<Vec<_>>(), vec![1]);
+            },
+            other => panic!("Expected InsufficientSymbols, got {:?}", other),
+        }
+
+        // Only the data before the failed block was written
+        assert_eq!(output, original_data[..100 * 1024]);
+
+        // Ensure temp_dir isn't dropped early
+        drop(temp_dir);
     }
 
     #[test]
-    fn test_new_processor_initial_state() {
-        let processor = RaptorQProcessor::new(ProcessorConfig::default());
-        
-        // Verify initial state
-        assert_eq!(processor.active_tasks.load(Ordering::SeqCst), 0);
-        assert_eq!(processor.get_last_error(), "");
-    }
+    fn test_decode_insufficient_symbols_reports_block() {
+        let (temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let output_path = dir_path.join("output.bin");
 
-    // Tests for RaptorQProcessor::get_last_error
+        // 3 blocks of 100KB each
+        let original_data = generate_test_data(300 * 1024);
+        write_file(&input_path, &original_data).expect("Failed to write the input file");
 
-    #[test]
-    fn test_get_last_error_empty() {
-        let processor = RaptorQProcessor::new(ProcessorConfig::default());
-        
-        assert_eq!(processor.get_last_error(), "");
+        let config = ProcessorConfig {
+            symbol_size: 1024,
+            ..ProcessorConfig::default()
+        };
+        let processor = RaptorQProcessor::new(config);
+        processor.encode_file(
+            input_path.to_str().unwrap(),
+            symbols_dir.to_str().unwrap(),
+            100 * 1024,
+            false
+        ).expect("Failed to encode the file");
+
+        // Keep only 10 symbols of the second block
+        let block_dir = symbols_dir.join("block_1");
+        let mut entries: Vec<_> = std::fs::read_dir(&block_dir)
+            .expect("Failed to read the block directory")
+            .map(|e| e.unwrap().path())
+            .collect();
+        entries.sort();
+        for path in entries.iter().skip(10) {
+            std::fs::remove_file(path).expect("Failed to remove the symbol file");
+        }
+
+        let layout_path = symbols_dir.join(LAYOUT_FILENAME);
+        let result = processor.decode_symbols(
+            symbols_dir.to_str().unwrap(),
+            output_path.to_str().unwrap(),
+            layout_path.to_str().unwrap()
+        );
+
+        let expected = vec![BlockShortfall { block_id: 1, present: 10, required: 100 }];
+        match result {
+            Err(ProcessError::InsufficientSymbols(shortfalls)) => assert_eq!(shortfalls, expected),
+            other => panic!("Expected InsufficientSymbols, got {:?}", other),
+        }
+        assert_eq!(processor.get_last_shortfalls(), expected);
+        assert!(processor.get_last_error().contains("block 1 has 10 of 100 required symbols"));
+
+        // Ensure temp_dir isn't dropped early
+        drop(temp_dir);
     }
 
     #[test]
-    fn test_get_last_error_after_error() {
-        let processor = RaptorQProcessor::new(ProcessorConfig::default());
-        let error_message = "Test error message";
-        
-        // Set the error using the internal method
-        processor.set_last_error(error_message.to_string());
-        
-        // Verify the error message is returned correctly
-        assert_eq!(processor.get_last_error(), error_message);
-    }
+    fn test_concurrent_use() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let threads = 8;
+        let config = ProcessorConfig { symbol_size: 1024, concurrency_limit: threads, ..ProcessorConfig::default() };
+        let processor = RaptorQProcessor::new(config);
 
-    // Tests for RaptorQProcessor::get_recommended_block_size
+        std::thread::scope(|scope| {
+            for t in 0..threads as usize {
+                let processor = &processor;
+                let dir_path = &dir_path;
+                scope.spawn(move || {
+                    for round in 0..3 {
+                        // Different data for every operation
+                        let mut data = generate_test_data(20_000 + t * 1000 + round);
+                        data[0] = t as u8;
+                        data[1] = round as u8;
+
+                        let (result, symbols) = processor.encode_bytes(&data, 8192).unwrap();
+                        let layout = serde_json::to_vec(result.layout.as_ref().unwrap()).unwrap();
+                        assert_eq!(processor.decode_bytes(&symbols, &layout).unwrap(), data);
+
+                        let input_path = dir_path.join(format!("input_{}_{}.bin", t, round));
+                        let symbols_dir = dir_path.join(format!("symbols_{}_{}", t, round));
+                        let output_path = dir_path.join(format!("output_{}_{}.bin", t, round));
+                        write_file(&input_path, &data).unwrap();
+                        let result = processor.encode_file(
+                            input_path.to_str().unwrap(),
+                            symbols_dir.to_str().unwrap(),
+                            8192,
+                            false,
+                        ).unwrap();
+                        processor.decode_symbols(
+                            symbols_dir.to_str().unwrap(),
+                            output_path.to_str().unwrap(),
+                            &result.layout_file_path,
+                        ).unwrap();
+                        assert_eq!(read_file(&output_path).unwrap(), data);
+                    }
+                });
+            }
+        });
 
-    #[test]
-    fn test_block_size_small_file() {
-        let processor = RaptorQProcessor::new(ProcessorConfig::default());
-        let file_size = 10 * 1024 * 1024; // 10 MB file
-        
-        // With default config (max_memory_mb = 1024), a 10 MB file should not need splitting
-        let block_size = processor.get_recommended_block_size(file_size);
-        
-        assert_eq!(block_size, 0); // 0 means no splitting needed
+        assert_eq!(processor.active_tasks.load(Ordering::SeqCst), 0);
     }
 
     #[test]
-    fn test_block_size_large_file() {
-        let config = ProcessorConfig {
-            symbol_size: DEFAULT_SYMBOL_SIZE_B,
-            redundancy_factor: DEFAULT_REDUNDANCY_FACTOR,
-            max_memory_mb: 100, // Deliberately small to force splitting
-            concurrency_limit: 4,
-        };
+    fn test_last_error_per_thread() {
+        let threads = 6;
+        let config = ProcessorConfig { symbol_size: 1024, concurrency_limit: threads, ..ProcessorConfig::default() };
         let processor = RaptorQProcessor::new(config);
-        let file_size = 1024 * 1024 * 1024; // 1 GB file
-        
-        let block_size = processor.get_recommended_block_size(file_size);
-        
-        // Block size should be non-zero
-        assert!(block_size > 0);
-        // Block size should be a multiple of symbol size
-        assert_eq!(block_size % processor.config.symbol_size as usize, 0);
+        let data = generate_test_data(50 * 1024);
+        let (result, symbols) = processor.encode_bytes(&data, 0).unwrap();
+        let layout_content = result.layout_content.unwrap();
+        let layout: RaptorQLayout = serde_json::from_str(&layout_content).unwrap();
+
+        // Every thread fails, all before any reads its error back
+        let barrier = std::sync::Barrier::new(threads as usize);
+        std::thread::scope(|scope| {
+            for t in 0..threads as usize {
+                let (processor, barrier, symbols, layout, layout_content) = (&processor, &barrier, &symbols, &layout, &layout_content);
+                scope.spawn(move || {
+                    if t % 2 == 0 {
+                        // Decodes with a different number of symbols
+                        let kept: Vec<&[u8]> = symbols.iter()
+                            .filter(|s| layout.blocks[0].symbols[..t].contains(&get_hash_as_b58(s)))
+                            .map(|s| s.as_slice())
+                            .collect();
+                        assert!(processor.decode_bytes(&kept, layout_content.as_bytes()).is_err());
+                    } else {
+                        let layout_path = format!("missing_layout_{}.json", t);
+                        assert!(processor.decode_symbols("missing_symbols", "output.bin", &layout_path).is_err());
+                    }
+                    barrier.wait();
+
+                    if t % 2 == 0 {
+                        let shortfalls = processor.get_last_shortfalls();
+                        assert_eq!(shortfalls.len(), 1);
+                        assert_eq!(shortfalls[0].present, t as u64);
+                        assert!(processor.get_last_error().contains("Insufficient"), "{}", processor.get_last_error());
+                    } else {
+                        assert!(processor.get_last_shortfalls().is_empty());
+                        assert!(processor.get_last_error().contains(&format!("missing_layout_{}.json", t)), "{}", processor.get_last_error());
+                    }
+                });
+            }
+        });
+
+        // Nothing failed on this thread
+        assert!(processor.get_last_error().is_empty());
+        assert!(processor.get_last_shortfalls().is_empty());
+
+        // The errors of the threads that exited are dropped
+        let processor = Arc::new(processor);
+        let failing = processor.clone();
+        let thread = std::thread::spawn(move || assert!(failing.decode_symbols("missing_symbols", "output.bin", "missing.json").is_err()));
+        let thread_id = thread.thread().id();
+        thread.join().unwrap();
+        assert!(processor.get_last_error().is_empty());
+        assert!(!processor.last_error.values.lock().contains_key(&thread_id));
     }
 
     #[test]
-    fn test_block_size_edge_memory() {
-        // Create an array of processor configs with different memory limits
-        let configs = [
-            ProcessorConfig {
-                symbol_size: DEFAULT_SYMBOL_SIZE_B,
-                redundancy_factor: DEFAULT_REDUNDANCY_FACTOR,
-                max_memory_mb: 8_000, // 8GB
-                concurrency_limit: 4,
-            },
-            ProcessorConfig {
-                symbol_size: DEFAULT_SYMBOL_SIZE_B,
-                redundancy_factor: DEFAULT_REDUNDANCY_FACTOR,
-                max_memory_mb: 16_000, // 16GB
-                concurrency_limit: 4,
-            },
-            ProcessorConfig {
-                symbol_size: DEFAULT_SYMBOL_SIZE_B,
-                redundancy_factor: DEFAULT_REDUNDANCY_FACTOR,
-                max_memory_mb: 32_000, // 32GB
-                concurrency_limit: 4,
-            },
-            ProcessorConfig {
-                symbol_size: DEFAULT_SYMBOL_SIZE_B,
-                redundancy_factor: DEFAULT_REDUNDANCY_FACTOR,
-                max_memory_mb: 64_000, // 64GB
-                concurrency_limit: 4,
-            },
-        ];
+    fn test_concurrency_limit_is_not_exceeded() {
+        let config = ProcessorConfig { symbol_size: 1024, concurrency_limit: 2, ..ProcessorConfig::default() };
+        let processor = RaptorQProcessor::new(config);
+        let data = generate_test_data(100_000);
 
-        // Create processors from configs
-        let processors: Vec<RaptorQProcessor> = configs.iter()
-            .map(|config| RaptorQProcessor::new(config.clone()))
-            .collect();
+        let results: Vec<_> = std::thread::scope(|scope| {
+            let handles: Vec<_> = (0..16)
+                .map(|_| scope.spawn(|| processor.encode_bytes(&data, 0).map(|_| ())))
+                .collect();
+            handles.into_iter().map(|h| h.join().unwrap()).collect()
+        });
 
-        // Define file sizes to test
-        let file_sizes = [
-            10 * 1024 * 1024,          // 10 MB
-            100 * 1024 * 1024,         // 100 MB
-            1024 * 1024 * 1024,        // 1 GB
-            10 * 1024 * 1024 * 1024,   // 10 GB
-            100 * 1024 * 1024 * 1024,  // 100 GB
-        ];
+        assert!(results.iter().any(|r| r.is_ok()));
+        assert!(results.iter().all(|r| matches!(r, Ok(()) | Err(ProcessError::ConcurrencyLimitReached))));
+        assert_eq!(processor.active_tasks.load(Ordering::SeqCst), 0);
+    }
 
-        // Test each file size with each processor
-        for &file_size in &file_sizes {
-            println!("Testing file size: {} bytes", file_size);
+    #[test]
+    fn test_available_task_slots() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        write_file(&input_path, &generate_test_data(40 * 1024)).unwrap();
+        let input = input_path.to_str().unwrap();
+        let symbols_dir = dir_path.join("symbols");
 
-            // Get block sizes for all processors
-            // Changed type from Vec<u64> to Vec<usize> to match the return type
-            let block_sizes: Vec<usize> = processors.iter()
-                .map(|processor| processor.get_recommended_block_size(file_size))
-                .collect();
+        let processor = RaptorQProcessor::builder().symbol_size(1024).concurrency_limit(3).build().unwrap();
+        assert_eq!(processor.available_task_slots(), 3);
+        let result = processor.encode_file(input, symbols_dir.to_str().unwrap(), 10 * 1024, false).unwrap();
+        assert_eq!(processor.available_task_slots(), 3);
 
-            for (i, &block_size) in block_sizes.iter().enumerate() {
-                println!("  Processor with {} MB memory: block size = {} bytes",
-                         configs[i].max_memory_mb, block_size);
+        // A parallel decode runs on the slots left by a task in progress
+        let (sender, receiver) = mpsc::sync_channel(0);
+        std::thread::scope(|s| {
+            let encode = s.spawn(|| processor.encode_to_channel(input, 10 * 1024, sender));
+            receiver.recv().unwrap();
+            assert_eq!(processor.available_task_slots(), 2);
 
-                // For small files compared to memory, splitting might not be needed.
-                // Convert max_memory_mb to the same type as file_size for comparison
-                if file_size < (configs[i].max_memory_mb * 1024 * 1024 / 4) as usize {
-                    assert_eq!(block_size, 0,
-                               "File size of {} bytes should not need splitting with {} MB memory",
-                               file_size, configs[i].max_memory_mb);
-                }
+            let output_path = dir_path.join("output.bin");
+            processor.decode_symbols_parallel(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path).unwrap();
+            assert_eq!(processor.available_task_slots(), 2);
 
-                // For large files compared to memory, splitting is required
-                if file_size > (configs[i].max_memory_mb * 1024 * 1024) as usize {
-                    assert!(block_size > 0,
-                            "File size of {} bytes should need splitting with {} MB memory",
-                            file_size, configs[i].max_memory_mb);
-                }
+            drop(receiver);
+            assert!(matches!(encode.join().unwrap(), Err(ProcessError::Cancelled)));
+        });
+        assert_eq!(processor.available_task_slots(), 3);
+    }
 
-                // Compare with next processor (with more memory)
-                if i < block_sizes.len() - 1 {
-                    let next_block_size = block_sizes[i + 1];
+    #[test]
+    fn test_encode_files() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+
+        let mut jobs = Vec::new();
+        for i in 0..5 {
+            let input_path = dir_path.join(format!("input_{}.bin", i));
+            write_file(&input_path, &generate_test_data(1000 * (i + 1))).unwrap();
+            jobs.push(BatchEncodeJob {
+                input_path: input_path.to_string_lossy().to_string(),
+                output_dir: dir_path.join(format!("symbols_{}", i)).to_string_lossy().to_string(),
+                block_size: 0,
+            });
+        }
+        // A missing file fails without stopping the batch
+        jobs[2].input_path = dir_path.join("missing.bin").to_string_lossy().to_string();
 
-                    // If both need splitting, more memory should allow larger blocks
-                    if block_size > 0 && next_block_size > 0 {
-                        assert!(next_block_size >= block_size,
-                                "Processor with more memory should allow larger blocks");
-                    }
+        let config = ProcessorConfig { symbol_size: 1024, concurrency_limit: 2, ..ProcessorConfig::default() };
+        let processor = RaptorQProcessor::new(config);
+        let results = processor.encode_files(&jobs);
 
-                    // If current doesn't need splitting, next shouldn't either
-                    if block_size == 0 {
-                        assert_eq!(next_block_size, 0,
-                                   "If a processor with less memory doesn't need splitting, a processor with more memory shouldn't either");
-                    }
-                }
+        assert_eq!(results.len(), jobs.len());
+        for (i, (job, result)) in jobs.iter().zip(&results).enumerate() {
+            if i == 2 {
+                assert!(matches!(result, Err(ProcessError::FileNotFound(_))), "Unexpected result {:?}", result);
+                continue;
             }
+            let result = result.as_ref().expect("Encoding should succeed");
+            assert_eq!(result.symbols_directory, job.output_dir);
+            let blocks = result.blocks.as_ref().unwrap();
+            assert_eq!(blocks[0].size, 1000 * (i as u64 + 1));
+            assert!(path_exists(&Path::new(&job.output_dir).join(LAYOUT_FILENAME)));
         }
+        assert_eq!(processor.active_tasks.load(Ordering::SeqCst), 0);
     }
 
     #[test]
-    fn test_blcok_size_zero_file() {
-        let processor = RaptorQProcessor::new(ProcessorConfig::default());
-        let file_size = 0;
-        
-        let block_size = processor.get_recommended_block_size(file_size);
-        
-        assert_eq!(block_size, 0); // 0 means no splitting needed
-    }
-
-    // Tests for RaptorQProcessor::encode_file
+    fn test_encode_files_cancelled() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        write_file(&input_path, &generate_test_data(1000)).unwrap();
+        let job = BatchEncodeJob {
+            input_path: input_path.to_string_lossy().to_string(),
+            output_dir: dir_path.join("symbols").to_string_lossy().to_string(),
+            block_size: 0,
+        };
 
-    #[test]
-    fn test_encode_file_not_found() {
-        let processor = RaptorQProcessor::new(ProcessorConfig::default());
-        let result = processor.encode_file("non_existent_file.txt", "output_dir", 0, false);
-        
-        assert!(matches!(result, Err(ProcessError::FileNotFound(_))));
+        let processor = RaptorQProcessor::new(ProcessorConfig { concurrency_limit: 1, ..ProcessorConfig::default() });
+        processor.cancel();
+        let results = processor.encode_files(&vec![job.clone(); 3]);
+        assert!(results.iter().all(|r| r.is_ok()), "A cancel before the batch doesn't affect it");
+
+        // Once cancelled, the files not started yet fail. The input and the layout of the
+        // first file are FIFOs: the batch opens the input as the test opens it, then waits
+        // for the test to open the layout, which it does once it cancelled the processor
+        #[cfg(unix)]
+        {
+            let fifo_symbols_dir = dir_path.join("fifo_symbols");
+            std::fs::create_dir_all(&fifo_symbols_dir).unwrap();
+            let input_fifo = dir_path.join("input.fifo");
+            let layout_fifo = fifo_symbols_dir.join(LAYOUT_FILENAME);
+            for fifo in [&input_fifo, &layout_fifo] {
+                let status = std::process::Command::new("mkfifo").arg(fifo).status().expect("Failed to run mkfifo");
+                assert!(status.success());
+            }
+            let mut jobs = vec![job.clone(); 5];
+            jobs[0].input_path = input_fifo.to_string_lossy().to_string();
+            jobs[0].output_dir = fifo_symbols_dir.to_string_lossy().to_string();
+
+            let results = std::thread::scope(|scope| {
+                let handle = scope.spawn(|| processor.encode_files(&jobs));
+                let input = std::fs::OpenOptions::new().write(true).open(&input_fifo).unwrap();
+                processor.cancel();
+                drop(input);
+                let mut layout = std::fs::File::open(&layout_fifo).unwrap();
+                std::io::Read::read_to_end(&mut layout, &mut Vec::new()).unwrap();
+                handle.join().unwrap()
+            });
+            assert_eq!(results.len(), 5);
+            assert!(!matches!(results[0], Err(ProcessError::Cancelled)), "The first file was started");
+            assert!(results[1..].iter().all(|r| matches!(r, Err(ProcessError::Cancelled))));
+        }
     }
 
     #[test]
-    fn test_encode_empty_file() {
-        let (temp_dir, dir_path) = create_temp_dir();
-        let input_path = dir_path.join("empty.txt");
-        let output_dir = dir_path.join("output");
-        
-        // Create an empty file
-        create_empty_file(&input_path).expect("Failed to create empty file");
-        
-        let processor = RaptorQProcessor::new(ProcessorConfig::default());
-        let result = processor.encode_file(
-            input_path.to_str().unwrap(),
-            output_dir.to_str().unwrap(),
-            0,
-            false
-        );
-        
-        assert!(matches!(result, Err(ProcessError::EncodingFailed(_))));
-        
-        // Ensure temp_dir isn't dropped early
-        drop(temp_dir);
+    fn test_decode_files() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+
+        let config = ProcessorConfig { symbol_size: 1024, concurrency_limit: 2, ..ProcessorConfig::default() };
+        let processor = RaptorQProcessor::new(config);
+        let mut jobs = Vec::new();
+        for i in 0..4 {
+            let input_path = dir_path.join(format!("input_{}.bin", i));
+            let symbols_dir = dir_path.join(format!("symbols_{}", i));
+            write_file(&input_path, &generate_test_data(1000 * (i + 1))).unwrap();
+            processor
+                .encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 0, false)
+                .expect("Encoding should succeed");
+            jobs.push(BatchDecodeJob {
+                symbols_dir: symbols_dir.to_string_lossy().to_string(),
+                output_path: dir_path.join(format!("output_{}.bin", i)).to_string_lossy().to_string(),
+                layout_path: symbols_dir.join(LAYOUT_FILENAME).to_string_lossy().to_string(),
+            });
+        }
+        // A missing layout fails without stopping the batch
+        jobs[1].layout_path = dir_path.join("missing.json").to_string_lossy().to_string();
+
+        let results = processor.decode_files(&jobs);
+
+        assert_eq!(results.len(), jobs.len());
+        for (i, (job, result)) in jobs.iter().zip(&results).enumerate() {
+            if i == 1 {
+                assert!(matches!(result, Err(ProcessError::FileNotFound(_))), "Unexpected result {:?}", result);
+                assert!(!path_exists(Path::new(&job.output_path)));
+                continue;
+            }
+            assert!(result.is_ok(), "Decoding should succeed: {:?}", result);
+            assert_eq!(read_file(Path::new(&job.output_path)).unwrap(), generate_test_data(1000 * (i + 1)));
+        }
+        assert_eq!(processor.active_tasks.load(Ordering::SeqCst), 0);
     }
 
     #[test]
-    fn test_encode_success_no_splitting() {
-        let (temp_dir, dir_path) = create_temp_dir();
-        let input_path = dir_path.join("small_file.bin");
-        let output_dir = dir_path.join("output");
-        
-        // Create a small test file (100 KB)
-        create_test_file(&input_path, 100 * 1024).expect("Failed to create test file");
-        
-        let processor = RaptorQProcessor::new(ProcessorConfig::default());
+    fn test_get_oti() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        write_file(&input_path, &generate_test_data(25_000)).unwrap();
+
+        let config = ProcessorConfig { symbol_size: 1024, ..ProcessorConfig::default() };
+        let processor = RaptorQProcessor::new(config);
         let result = processor.encode_file(
             input_path.to_str().unwrap(),
-            output_dir.to_str().unwrap(),
-            0, // 0 means auto-determine if splitting is needed
-            false
-        );
-        
-        assert!(result.is_ok());
-        let result = result.unwrap();
+            symbols_dir.to_str().unwrap(),
+            10_000,
+            false,
+        ).unwrap();
+
+        let otis = processor.get_oti(&result.layout_file_path).unwrap();
         let blocks = result.blocks.unwrap();
-        assert_eq!(blocks.len(), 1);
-        let block = &blocks[0];
-        assert_eq!(result.total_symbols_count, block.symbols_count);
-        assert_eq!(result.total_repair_symbols, block.symbols_count - block.source_symbols_count);
+        assert_eq!(otis.len(), 3);
+        for (oti, block) in otis.iter().zip(&blocks) {
+            assert_eq!(oti.block_id, block.block_id);
+            assert_eq!(oti.oti, block.encoder_parameters);
+            assert_eq!(oti.transfer_length, block.size);
+            assert_eq!(oti.symbol_size, 1024);
+            assert_eq!(oti.source_blocks, 1);
+            assert_eq!(oti.sub_blocks, 1);
+            assert_eq!(oti.symbol_alignment, 8);
+
+            // The fields are the ones encoded in the OTI bytes
+            let params: [u8; 12] = oti.oti.as_slice().try_into().unwrap();
+            let config = ObjectTransmissionInformation::deserialize(&params);
+            assert_eq!(config.transfer_length(), oti.transfer_length);
+            assert_eq!(config.symbol_size(), oti.symbol_size);
+        }
 
-        // Verify output directory exists and contains symbol files
-        assert!(path_exists(&output_dir));
+        let missing = dir_path.join("missing.json");
+        assert!(processor.get_oti(missing.to_str().unwrap()).is_err());
+    }
 
-        let block_dir = output_dir.join(format!("{}0", BLOCK_DIR_PREFIX));
-        let file_count = count_files_in_dir(&block_dir);
-        assert!(file_count > 0);
-        
-        // Ensure temp_dir isn't dropped early
-        drop(temp_dir);
+    #[test]
+    fn test_layout_oti_conversion() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let data = generate_test_data(25_000);
+        write_file(&input_path, &data).unwrap();
+
+        let processor = RaptorQProcessor::builder().symbol_size(1024).build().unwrap();
+        let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 10_000, false).unwrap();
+        let layout = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+        let otis = layout.to_oti().unwrap();
+        assert_eq!(otis, processor.get_oti(&result.layout_file_path).unwrap());
+
+        // The blocks come back without their symbols and hashes
+        let converted = RaptorQLayout::from_oti(&otis, 25_000).unwrap();
+        assert_eq!(converted.blocks.len(), 3);
+        for (block, original) in converted.blocks.iter().zip(&layout.blocks) {
+            assert_eq!(
+                (block.block_id, &block.encoder_parameters, block.original_offset, block.size),
+                (original.block_id, &original.encoder_parameters, original.original_offset, original.size)
+            );
+            assert!(block.symbols.is_empty() && block.hash.is_empty());
+        }
+        assert_eq!(converted.to_oti().unwrap(), otis);
+
+        // An object of a standard encoder, one RaptorQ object per block, decodes once its
+        // symbols are named by their ids
+        let other_dir = dir_path.join("standard");
+        let mut otis = Vec::new();
+        let mut block_packets = Vec::new();
+        for (block_id, block) in data.chunks(12_500).enumerate() {
+            let (oti, packets) = encode_test_data(block, 512, 3);
+            let block_layout = BlockLayout {
+                block_id,
+                encoder_parameters: oti,
+                original_offset: 0,
+                size: 0,
+                symbols: Vec::new(),
+                hash: String::new(),
+            };
+            otis.push(block_layout.oti().unwrap());
+            block_packets.push(packets);
+        }
+        let mut standard = RaptorQLayout::from_oti(&otis, data.len() as u64).unwrap();
+        for (block, packets) in standard.blocks.iter_mut().zip(&block_packets) {
+            let block_dir = other_dir.join(block_dir_name(block.block_id));
+            std::fs::create_dir_all(&block_dir).unwrap();
+            for packet in packets {
+                block.symbols.push(symbol_id(packet));
+                write_file(&block_dir.join(symbol_id(packet)), packet).unwrap();
+            }
+        }
+        assert_eq!(standard.blocks[1].original_offset, 12_500);
+        let standard_layout_path = dir_path.join("standard_layout.json");
+        write_file(&standard_layout_path, serde_json::to_string(&standard).unwrap().as_bytes()).unwrap();
+        let output_path = dir_path.join("output.bin");
+        processor.decode_symbols(other_dir.to_str().unwrap(), output_path.to_str().unwrap(), standard_layout_path.to_str().unwrap()).unwrap();
+        assert_eq!(read_file(&output_path).unwrap(), data);
+
+        // OTIs that don't describe the object
+        let invalid = |otis: &[BlockOti], object_size: u64| match RaptorQLayout::from_oti(otis, object_size) {
+            Err(ProcessError::InvalidParameter(message)) => message,
+            other => panic!("Expected InvalidParameter, got {:?}", other),
+        };
+        assert!(invalid(&otis, data.len() as u64 + 1).contains("the object has 25001"));
+        let mut truncated = otis.clone();
+        truncated[0].oti.pop();
+        assert!(invalid(&truncated, data.len() as u64).contains("11 bytes"));
+        let mut mismatched = otis.clone();
+        mismatched[1].symbol_size = 1024;
+        assert!(invalid(&mismatched, data.len() as u64).contains("doesn't match"));
+        let mut duplicated = otis.clone();
+        duplicated[1].block_id = 0;
+        assert!(invalid(&duplicated, data.len() as u64).contains("several OTIs"));
+
+        assert!(RaptorQLayout::from_oti(&[], 0).unwrap().is_empty_object());
     }
 
     #[test]
-    fn test_encode_success_manual_no_splitting() {
-        let (temp_dir, dir_path) = create_temp_dir();
-        let input_path = dir_path.join("small_file.bin");
-        let output_dir = dir_path.join("output");
-        
-        // Create a small test file (100 KB)
-        create_test_file(&input_path, 100 * 1024).expect("Failed to create test file");
-        
-        let processor = RaptorQProcessor::new(ProcessorConfig::default());
+    fn test_layout_read_file() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        write_file(&input_path, &generate_test_data(25_000)).unwrap();
+
+        let config = ProcessorConfig { symbol_size: 1024, ..ProcessorConfig::default() };
+        let processor = RaptorQProcessor::new(config);
         let result = processor.encode_file(
             input_path.to_str().unwrap(),
-            output_dir.to_str().unwrap(),
-            500 * 1024, // Larger than file size
-            false
-        );
+            symbols_dir.to_str().unwrap(),
+            10_000,
+            false,
+        ).unwrap();
 
-        assert!(result.is_ok());
-        let result = result.unwrap();
-        let blocks = result.blocks.unwrap();
-        assert_eq!(blocks.len(), 1);
-        let block = &blocks[0];
-        assert_eq!(result.total_symbols_count, block.symbols_count);
-        assert_eq!(result.total_repair_symbols, block.symbols_count - block.source_symbols_count);
+        let layout = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+        assert_eq!(layout, RaptorQLayout::parse(&std::fs::read(&result.layout_file_path).unwrap()).unwrap());
+        assert_eq!(layout.total_size(), 25_000);
+        assert_eq!(layout.symbols_count(), result.total_symbols_count);
 
-        // Verify output directory exists and contains symbol files
-        assert!(path_exists(&output_dir));
+        let summary = layout.summary();
+        assert_eq!(summary.total_size, 25_000);
+        let blocks = result.blocks.unwrap();
+        assert_eq!(summary.blocks.len(), blocks.len());
+        for (block, info) in summary.blocks.iter().zip(&blocks) {
+            assert_eq!((block.block_id, block.original_offset, block.size), (info.block_id, info.original_offset, info.size));
+            assert_eq!(block.symbol_size, 1024);
+            assert_eq!(block.symbols_count, info.symbols_count);
+            assert_eq!(block.source_symbols_count, info.source_symbols_count);
+            assert_eq!(block.repair_symbols_count, info.symbols_count - info.source_symbols_count);
+        }
 
-        let block_dir = output_dir.join(format!("{}0", BLOCK_DIR_PREFIX));
-        let file_count = count_files_in_dir(&block_dir);
-        assert!(file_count > 0);
-        
-        // Ensure temp_dir isn't dropped early
-        drop(temp_dir);
+        assert_eq!(layout.block_range(0), Some((0, 10_000)));
+        assert_eq!(layout.block_range(2), Some((20_000, 5_000)));
+        assert_eq!(layout.block_range(3), None);
+        assert_eq!(layout.block_for_offset(0), Some(0));
+        assert_eq!(layout.block_for_offset(9_999), Some(0));
+        assert_eq!(layout.block_for_offset(10_000), Some(1));
+        assert_eq!(layout.block_for_offset(24_999), Some(2));
+        assert_eq!(layout.block_for_offset(25_000), None);
+
+        let missing = dir_path.join("missing.json");
+        assert!(matches!(RaptorQLayout::read_file(missing.to_str().unwrap()), Err(ProcessError::FileNotFound(_))));
+        assert!(matches!(RaptorQLayout::parse(b"{\"blocks\": 3}"), Err(ProcessError::DecodingFailed(_))));
+        assert!(matches!(RaptorQLayout::parse(&[0xff, 0xfe]), Err(ProcessError::DecodingFailed(_))));
     }
 
     #[test]
-    fn test_encode_success_auto_splitting() {
-        let (temp_dir, dir_path) = create_temp_dir();
-        let input_path = dir_path.join("large_file.bin");
-        let output_dir = dir_path.join("output");
-        
-        // Create a "large" test file (3 MB for test purposes)
-        create_test_file(&input_path, 3 * 1024 * 1024).expect("Failed to create test file");
-        
-        let config = ProcessorConfig {
-            symbol_size: DEFAULT_SYMBOL_SIZE_B,
-            redundancy_factor: DEFAULT_REDUNDANCY_FACTOR,
-            max_memory_mb: 1, // Very small memory limit to force splitting
-            concurrency_limit: 4,
-        };
-        
-        let processor = RaptorQProcessor::new(config);
-        let result = processor.encode_file(
-            input_path.to_str().unwrap(),
-            output_dir.to_str().unwrap(),
-            0, // Auto splitting
-            false
+    fn test_layout_version() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let output_path = dir_path.join("output.bin");
+        let data = generate_test_data(25_000);
+        write_file(&input_path, &data).unwrap();
+
+        let processor = RaptorQProcessor::new(ProcessorConfig { symbol_size: 1024, ..ProcessorConfig::default() });
+        let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 10_000, false).unwrap();
+        let layout_path = result.layout_file_path.as_str();
+        let content = read_file_to_string(Path::new(layout_path)).unwrap();
+        assert!(content.contains(&format!("\"layout_version\": {}", LAYOUT_VERSION)), "{}", content);
+        let layout = RaptorQLayout::read_file(layout_path).unwrap();
+        assert_eq!(layout.layout_version, LAYOUT_VERSION);
+        assert_eq!(layout.summary().layout_version, LAYOUT_VERSION);
+
+        // Layouts written before the version are version 0, decode and are migrated
+        let mut legacy: serde_json::Value = serde_json::from_str(&content).unwrap();
+        legacy.as_object_mut().unwrap().remove("layout_version");
+        write_file(Path::new(layout_path), legacy.to_string().as_bytes()).unwrap();
+        assert_eq!(RaptorQLayout::read_file(layout_path).unwrap().layout_version, 0);
+        processor.decode_symbols(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), layout_path).unwrap();
+        assert_eq!(read_file(&output_path).unwrap(), data);
+
+        // The layout is left as it is if the upgraded one can't be written next to it
+        let temp_path = format!("{}.{}.tmp", layout_path, std::process::id());
+        std::fs::create_dir(&temp_path).unwrap();
+        assert!(matches!(RaptorQLayout::migrate_file(layout_path), Err(ProcessError::IOError(_))));
+        assert_eq!(read_file_to_string(Path::new(layout_path)).unwrap(), legacy.to_string());
+        std::fs::remove_dir(&temp_path).unwrap();
+
+        assert!(RaptorQLayout::migrate_file(layout_path).unwrap(), "The layout should be upgraded");
+        assert_eq!(RaptorQLayout::read_file(layout_path).unwrap(), layout);
+        assert!(!Path::new(&temp_path).exists());
+        assert!(!RaptorQLayout::migrate_file(layout_path).unwrap(), "A current layout is left as it is");
+
+        // Newer layouts are rejected with their version, even if they don't parse as this one
+        let mut newer: serde_json::Value = serde_json::from_str(&content).unwrap();
+        newer["layout_version"] = (LAYOUT_VERSION + 1).into();
+        newer["blocks"] = "moved elsewhere".into();
+        write_file(Path::new(layout_path), newer.to_string().as_bytes()).unwrap();
+        let result = RaptorQLayout::read_file(layout_path);
+        assert!(
+            matches!(result, Err(ProcessError::UnsupportedLayoutVersion { version, supported: LAYOUT_VERSION }) if version == LAYOUT_VERSION + 1),
+            "Unexpected result {:?}", result
         );
-        
-        assert!(result.is_ok());
-        let result = result.unwrap();
-        assert!(result.blocks.is_some());
-        assert!(!result.blocks.unwrap().is_empty());
-        
-        // Verify block directories exist
-        assert!(path_exists(&output_dir));
+        assert!(matches!(RaptorQLayout::migrate_file(layout_path), Err(ProcessError::UnsupportedLayoutVersion { .. })));
+        let result = processor.decode_symbols(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), layout_path);
+        assert!(matches!(result, Err(ProcessError::UnsupportedLayoutVersion { .. })), "Unexpected result {:?}", result);
+        assert!(processor.get_last_error().contains("newer than version"));
+    }
 
-        // Ensure temp_dir isn't dropped early
-        drop(temp_dir);
+    #[test]
+    fn test_layout_format() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let output_path = dir_path.join("output.bin");
+        let data = generate_test_data(25_000);
+        write_file(&input_path, &data).unwrap();
+
+        let processor = RaptorQProcessor::builder()
+            .symbol_size(1024)
+            .layout_format(LayoutFormat::Binary)
+            .build()
+            .unwrap();
+        let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 10_000, false).unwrap();
+        let layout_path = result.layout_file_path.as_str();
+        let content = read_file(Path::new(layout_path)).unwrap();
+        assert_eq!(LayoutFormat::detect(&content), LayoutFormat::Binary);
+        let layout = RaptorQLayout::read_file(layout_path).unwrap();
+        assert_eq!(layout.layout_version, LAYOUT_VERSION);
+        assert!(content.len() < layout.to_bytes(LayoutFormat::Json).unwrap().len());
+
+        // Layouts of both formats decode whatever the format of the processor
+        let json_processor = RaptorQProcessor::new(ProcessorConfig { symbol_size: 1024, ..ProcessorConfig::default() });
+        json_processor.decode_symbols(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), layout_path).unwrap();
+        assert_eq!(read_file(&output_path).unwrap(), data);
+        processor.decode_from_dirs(&[symbols_dir.to_str().unwrap()], output_path.to_str().unwrap(), layout_path).unwrap();
+        assert_eq!(read_file(&output_path).unwrap(), data);
+
+        // Layouts rewritten by the processor take its format
+        let json_layout_path = dir_path.join("layout.json");
+        write_file(&json_layout_path, &layout.to_bytes(LayoutFormat::Json).unwrap()).unwrap();
+        processor.generate_repair_symbols(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), json_layout_path.to_str().unwrap(), 1, 2).unwrap();
+        let content = read_file(&json_layout_path).unwrap();
+        assert_eq!(LayoutFormat::detect(&content), LayoutFormat::Binary);
+        assert_eq!(RaptorQLayout::parse(&content).unwrap().blocks[1].symbols.len(), layout.blocks[1].symbols.len() + 2);
+
+        // Migrated layouts keep their format
+        let legacy = RaptorQLayout { layout_version: 0, ..layout.clone() };
+        write_file(Path::new(layout_path), &legacy.to_bytes(LayoutFormat::Binary).unwrap()).unwrap();
+        assert!(RaptorQLayout::migrate_file(layout_path).unwrap());
+        let content = read_file(Path::new(layout_path)).unwrap();
+        assert_eq!(LayoutFormat::detect(&content), LayoutFormat::Binary);
+        assert_eq!(RaptorQLayout::parse(&content).unwrap(), layout);
+
+        // The version of binary layouts is checked as the one of JSON layouts
+        let newer = RaptorQLayout { layout_version: LAYOUT_VERSION + 1, ..layout.clone() };
+        let result = RaptorQLayout::parse(&newer.to_bytes(LayoutFormat::Binary).unwrap());
+        assert!(matches!(result, Err(ProcessError::UnsupportedLayoutVersion { .. })), "Unexpected result {:?}", result);
+        let mut truncated = layout.to_bytes(LayoutFormat::Binary).unwrap();
+        truncated.pop();
+        let result = RaptorQLayout::parse(&truncated);
+        assert!(matches!(result, Err(ProcessError::DecodingFailed(_))), "Unexpected result {:?}", result);
+
+        // The layout returned in memory stays JSON
+        let result = processor.create_metadata(input_path.to_str().unwrap(), "", 10_000).unwrap();
+        assert!(result.layout_content.unwrap().starts_with('{'));
     }
 
     #[test]
-    fn test_encode_success_manual_splitting() {
-        let (temp_dir, dir_path) = create_temp_dir();
-        let input_path = dir_path.join("large_file.bin");
-        let output_dir = dir_path.join("output");
-        
-        // Create a "large" test file (3 MB for test purposes)
-        create_test_file(&input_path, 3 * 1024 * 1024).expect("Failed to create test file");
-        
+    fn test_decode_with_oti() {
+        let data = generate_test_data(10_000);
+        let (encoder_params, packets) = encode_test_data(&data, 1024, 4);
         let processor = RaptorQProcessor::new(ProcessorConfig::default());
-        let block_size = 1 * 1024 * 1024; // 1 MB blocks
-        
-        let result = processor.encode_file(
-            input_path.to_str().unwrap(),
-            output_dir.to_str().unwrap(),
-            block_size,
-            false
-        );
-        
-        assert!(result.is_ok());
-        let result = result.unwrap();
-        assert!(result.blocks.is_some());
-        let blocks = result.blocks.unwrap();
-        assert!(!blocks.is_empty());
-        assert_eq!(blocks.len(), 3); // 3 MB should create 3 blocks of 1 MB each
-        
-        // Ensure temp_dir isn't dropped early
-        drop(temp_dir);
+
+        // Any subset of enough packets decodes the object
+        let mut packets: Vec<_> = packets.into_iter().skip(3).collect();
+        packets.shuffle(&mut thread_rng());
+        assert_eq!(processor.decode_with_oti(&encoder_params, &packets).unwrap(), data);
+
+        // Too few packets
+        let result = processor.decode_with_oti(&encoder_params, &packets[..5]);
+        assert!(matches!(result, Err(ProcessError::InsufficientSymbols(_))), "Unexpected result {:?}", result);
+        assert_eq!(processor.get_last_shortfalls(), vec![BlockShortfall { block_id: 0, present: 5, required: 10 }]);
+
+        // Malformed OTI
+        let result = processor.decode_with_oti(&encoder_params[..11], &packets);
+        assert!(matches!(result, Err(ProcessError::InvalidParameter(_))));
+        assert!(processor.get_last_error().contains("OTI must be 12 bytes"));
     }
 
     #[test]
-    fn test_encode_output_dir_creation() {
-        let (temp_dir, dir_path) = create_temp_dir();
-        let input_path = dir_path.join("file.bin");
-        let output_dir = dir_path.join("nested/output/dir");
-        
-        // Create a test file
-        create_test_file(&input_path, 100 * 1024).expect("Failed to create test file");
-        
-        // Verify directory doesn't exist yet
-        assert!(!path_exists(&output_dir));
-        
+    fn test_decode_with_invalid_oti() {
+        let data = generate_test_data(10_000);
+        let (encoder_params, packets) = encode_test_data(&data, 1024, 4);
         let processor = RaptorQProcessor::new(ProcessorConfig::default());
-        let result = processor.encode_file(
+
+        // Each field zeroed or out of its range is rejected before reaching raptorq:
+        // transfer length, symbol size, source blocks, sub-blocks and alignment
+        let with = |offset: usize, bytes: &[u8]| {
+            let mut oti = encoder_params.clone();
+            oti[offset..offset + bytes.len()].copy_from_slice(bytes);
+            oti
+        };
+        let invalid = [
+            (with(0, &[0; 5]), "transfer length"),
+            (with(6, &[0, 0]), "symbol size"),
+            (with(6, &[4, 1]), "symbol size"),
+            (with(8, &[0]), "0 source blocks"),
+            (with(8, &[200]), "200 source blocks"),
+            (with(9, &[0, 0]), "0 sub-blocks"),
+            (with(9, &[1, 0]), "256 sub-blocks"),
+            (with(11, &[0]), "alignment 0"),
+        ];
+        for (oti, reason) in invalid {
+            let result = processor.decode_with_oti(&oti, &packets);
+            assert!(matches!(result, Err(ProcessError::InvalidParameter(_))), "{}: {:?}", reason, result);
+            assert!(processor.get_last_error().contains(reason), "{}", processor.get_last_error());
+        }
+
+        // The same fields in a layout fail its decode
+        for (oti, reason) in [(with(8, &[0]), "0 source blocks"), (with(9, &[0, 0]), "0 sub-blocks"), (with(11, &[0]), "alignment 0")] {
+            let block_layout = BlockLayout {
+                block_id: 0,
+                encoder_parameters: oti.clone(),
+                original_offset: 0,
+                size: data.len() as u64,
+                symbols: Vec::new(),
+                hash: String::new(),
+            };
+            let result = processor.block_decoder_config(&block_layout);
+            assert!(matches!(result, Err(ProcessError::DecodingFailed(_))), "{}: {:?}", reason, result);
+            assert!(processor.get_last_error().contains(reason), "{}", processor.get_last_error());
+        }
+    }
+
+    #[test]
+    fn test_can_decode() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        write_file(&input_path, &generate_test_data(30 * 1024)).expect("Failed to write the input file");
+
+        // 3 blocks of 10 source symbols each
+        let config = ProcessorConfig {
+            symbol_size: 1024,
+            ..ProcessorConfig::default()
+        };
+        let processor = RaptorQProcessor::new(config);
+        processor.encode_file(
             input_path.to_str().unwrap(),
-            output_dir.to_str().unwrap(),
-            0,
+            symbols_dir.to_str().unwrap(),
+            10 * 1024,
             false
-        );
-        
-        assert!(result.is_ok());
-        // Verify directory was created
-        assert!(path_exists(&output_dir));
-        
-        // Ensure temp_dir isn't dropped early
-        drop(temp_dir);
+        ).expect("Failed to encode the file");
+
+        let symbols_dir_str = symbols_dir.to_str().unwrap();
+        let layout_path = symbols_dir.join(LAYOUT_FILENAME);
+        let layout_path_str = layout_path.to_str().unwrap();
+        assert!(processor.can_decode(symbols_dir_str, layout_path_str).unwrap());
+
+        let keep_block_symbols = |count: usize| {
+            let mut entries: Vec<_> = std::fs::read_dir(symbols_dir.join("block_1"))
+                .expect("Failed to read the block directory")
+                .map(|e| e.unwrap().path())
+                .collect();
+            entries.sort();
+            for path in entries.iter().skip(count) {
+                std::fs::remove_file(path).expect("Failed to remove the symbol file");
+            }
+        };
+
+        // The source symbols plus the overhead are enough
+        keep_block_symbols(10 + DECODE_SYMBOL_OVERHEAD as usize);
+        assert!(processor.can_decode(symbols_dir_str, layout_path_str).unwrap());
+
+        keep_block_symbols(10 + DECODE_SYMBOL_OVERHEAD as usize - 1);
+        assert!(!processor.can_decode(symbols_dir_str, layout_path_str).unwrap());
+
+        // A missing symbols directory has no symbols
+        let missing_dir = dir_path.join("missing");
+        assert!(!processor.can_decode(missing_dir.to_str().unwrap(), layout_path_str).unwrap());
+
+        // A missing or malformed layout is an error
+        let missing_layout = dir_path.join("missing.json");
+        assert!(processor.can_decode(symbols_dir_str, missing_layout.to_str().unwrap()).is_err());
+        write_file(&input_path, b"not a layout").unwrap();
+        assert!(matches!(
+            processor.can_decode(symbols_dir_str, input_path.to_str().unwrap()),
+            Err(ProcessError::DecodingFailed(_))
+        ));
     }
 
     #[test]
-    fn test_encode_memory_limit_exceeded() {
-        let (temp_dir, dir_path) = create_temp_dir();
-        let input_path = dir_path.join("file.bin");
-        let output_dir = dir_path.join("output");
-        
-        // Create a test file (5 MB)
-        create_test_file(&input_path, 5 * 1024 * 1024).expect("Failed to create test file");
-        
-        // Create processor with tiny memory limit
-        let config = ProcessorConfig {
-            symbol_size: DEFAULT_SYMBOL_SIZE_B,
-            redundancy_factor: DEFAULT_REDUNDANCY_FACTOR,
-            max_memory_mb: 1, // 1 MB max memory
-            concurrency_limit: 4,
-        };
-        
+    fn test_encode_block() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let output_path = dir_path.join("output.bin");
+        let original_data: Vec<u8> = (0..30 * 1024).map(|i| (i % 251) as u8 ^ (i / 10240) as u8).collect();
+        write_file(&input_path, &original_data).expect("Failed to write the input file");
+
+        let config = ProcessorConfig { symbol_size: 1024, ..ProcessorConfig::default() };
         let processor = RaptorQProcessor::new(config);
         let result = processor.encode_file(
             input_path.to_str().unwrap(),
-            output_dir.to_str().unwrap(),
-            0, // block_size = 0
-            true // force_single_file = true, bypassing block size determination
+            symbols_dir.to_str().unwrap(),
+            10 * 1024,
+            false
+        ).expect("Failed to encode the file");
+        let layout: RaptorQLayout = serde_json::from_str(
+            &read_file_to_string(Path::new(&result.layout_file_path)).unwrap()
+        ).unwrap();
+        let block_dir = symbols_dir.join("block_1");
+        let original_symbols: Vec<Vec<u8>> = layout.blocks[1].symbols.iter()
+            .map(|id| read_file(&block_dir.join(id)).unwrap())
+            .collect();
+
+        // Regenerate the symbols of a lost block, with another redundancy factor
+        std::fs::remove_dir_all(&block_dir).unwrap();
+        let other_processor = RaptorQProcessor::new(ProcessorConfig { redundancy_factor: 2, ..ProcessorConfig::default() });
+        let block_result = other_processor.encode_block(
+            input_path.to_str().unwrap(),
+            symbols_dir.to_str().unwrap(),
+            &result.layout_file_path,
+            1,
+        ).expect("Failed to encode the block");
+        assert_eq!(
+            serde_json::to_value(block_result.blocks.as_ref().unwrap()).unwrap(),
+            serde_json::to_value([&result.blocks.as_ref().unwrap()[1]]).unwrap()
         );
-        
-        assert!(matches!(result, Err(ProcessError::MemoryLimitExceeded { .. })));
-        
-        // Ensure temp_dir isn't dropped early
-        drop(temp_dir);
+        assert_eq!(count_files_in_dir(&block_dir), layout.blocks[1].symbols.len());
+        for (id, symbol) in layout.blocks[1].symbols.iter().zip(&original_symbols) {
+            assert_eq!(&read_file(&block_dir.join(id)).unwrap(), symbol);
+        }
+
+        processor.decode_symbols(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path)
+            .expect("Failed to decode the file");
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
+
+        let result_err = processor.encode_block(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), &result.layout_file_path, 3);
+        assert!(matches!(result_err, Err(ProcessError::EncodingFailed(_))));
+
+        // The file changed since it was encoded
+        let mut changed_data = original_data.clone();
+        changed_data[15 * 1024] ^= 0xff;
+        write_file(&input_path, &changed_data).unwrap();
+        let result_err = processor.encode_block(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), &result.layout_file_path, 1);
+        assert!(matches!(result_err, Err(ProcessError::EncodingFailed(_))));
+        assert!(processor.get_last_error().contains("does not match"));
+
+        // The file is shorter than the layout
+        write_file(&input_path, &original_data[..25 * 1024]).unwrap();
+        let result_err = processor.encode_block(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), &result.layout_file_path, 2);
+        assert!(matches!(result_err, Err(ProcessError::EncodingFailed(_))));
     }
 
     #[test]
-    fn test_encode_concurrency_limit() {
-        let (temp_dir, dir_path) = create_temp_dir();
-        let input_path = dir_path.join("file.bin");
-        let output_dir = dir_path.join("output");
-        
-        // Create a test file
-        create_test_file(&input_path, 100 * 1024).expect("Failed to create test file");
-        
-        // Create processor with concurrency limit of 1
-        let config = ProcessorConfig {
-            symbol_size: DEFAULT_SYMBOL_SIZE_B,
-            redundancy_factor: DEFAULT_REDUNDANCY_FACTOR,
-            max_memory_mb: DEFAULT_MAX_MEMORY_MB,
-            concurrency_limit: 1,
-        };
-        
-        let processor = Arc::new(RaptorQProcessor::new(config));
-        
-        // Start a task that will keep the processor busy
-        // let processor_clone = Arc::clone(&processor);
-        let input_path_str = input_path.to_str().unwrap().to_string();
-        let output_dir_str = output_dir.to_str().unwrap().to_string();
-        
-        // Manually increment active_tasks to simulate a running task
-        processor.active_tasks.fetch_add(1, Ordering::SeqCst);
-        
-        // Attempt to start another task
-        let result = processor.encode_file(
-            &input_path_str,
-            &output_dir_str,
-            0,
-            false
+    fn test_decode_and_repair() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let output_path = dir_path.join("output.bin");
+        let original_data: Vec<u8> = (0..50 * 1024).map(|i| (i * 31 / 7 % 251) as u8).collect();
+        write_file(&input_path, &original_data).unwrap();
+
+        let processor = RaptorQProcessor::builder().symbol_size(1024).symbol_codec(SymbolCodec::Gzip).build().unwrap();
+        let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 20 * 1024, false).unwrap();
+        let layout = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+        assert_eq!(layout.blocks.len(), 3);
+
+        // Nothing to repair in a complete directory
+        let repaired = processor.decode_and_repair(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path).unwrap();
+        assert_eq!(repaired, 0);
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
+
+        // Source symbols lost in two blocks, and a repair symbol which isn't written back
+        let mut removed = HashMap::new();
+        for (block_id, count) in [(0, 3), (2, 1)] {
+            let block_layout = &layout.blocks[block_id];
+            let block_dir = symbols_dir.join(block_dir_name(block_id));
+            for symbol_id in &block_layout.symbols[1..1 + count] {
+                removed.insert(block_dir.join(symbol_id), read_file(&block_dir.join(symbol_id)).unwrap());
+                std::fs::remove_file(block_dir.join(symbol_id)).unwrap();
+            }
+        }
+        let repair_symbol = symbols_dir.join("block_1").join(layout.blocks[1].symbols.last().unwrap());
+        std::fs::remove_file(&repair_symbol).unwrap();
+
+        std::fs::remove_file(&output_path).unwrap();
+        let repaired = processor.decode_and_repair(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path).unwrap();
+        assert_eq!(repaired, 4);
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
+        for (path, content) in &removed {
+            assert_eq!(&read_file(path).unwrap(), content, "{:?} should be written back as it was", path);
+        }
+        assert!(!repair_symbol.exists());
+        assert_eq!(
+            processor.decode_and_repair(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path).unwrap(),
+            0
         );
-        
-        // This should fail with ConcurrencyLimitReached
-        assert!(matches!(result, Err(ProcessError::ConcurrencyLimitReached)));
-        
-        // Clean up
-        processor.active_tasks.fetch_sub(1, Ordering::SeqCst);
-        
-        // Ensure temp_dir isn't dropped early
-        drop(temp_dir);
+
+        // A directory that doesn't decode isn't touched
+        let block_dir = symbols_dir.join("block_0");
+        for symbol_id in &layout.blocks[0].symbols[..layout.blocks[0].symbols.len() - 5] {
+            std::fs::remove_file(block_dir.join(symbol_id)).unwrap();
+        }
+        let result = processor.decode_and_repair(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path);
+        assert!(matches!(result, Err(ProcessError::InsufficientSymbols(_))), "Unexpected result {:?}", result);
+        assert_eq!(std::fs::read_dir(&block_dir).unwrap().count(), 5);
     }
 
     #[test]
-    fn test_encode_verify_result() {
-        let (temp_dir, dir_path) = create_temp_dir();
-        let input_path = dir_path.join("file.bin");
-        let output_dir = dir_path.join("output");
-        
-        // Create a test file (200 KB)
-        let file_size = 200 * 1024;
-        create_test_file(&input_path, file_size).expect("Failed to create test file");
-        
-        let processor = RaptorQProcessor::new(ProcessorConfig::default());
+    fn test_decode_and_repair_source_blocks() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let output_path = dir_path.join("output.bin");
+        let original_data = generate_test_data(20 * 1024);
+        write_file(&input_path, &original_data).unwrap();
+
+        let processor = RaptorQProcessor::builder().symbol_size(1024).build().unwrap();
+        let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 0, false).unwrap();
+
+        // The block split into 2 source blocks of 10 source and 2 repair symbols, listed
+        // by source block, so the last source symbols come after the first repair ones
+        let block_dir = symbols_dir.join(block_dir_name(0));
+        std::fs::remove_dir_all(&block_dir).unwrap();
+        std::fs::create_dir_all(&block_dir).unwrap();
+        let config = ObjectTransmissionInformation::new(20 * 1024, 1024, 2, 1, 8);
+        let mut layout = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+        layout.blocks[0].encoder_parameters = config.serialize().to_vec();
+        layout.blocks[0].symbols.clear();
+        for packet in Encoder::new(&original_data, config).get_encoded_packets(2) {
+            let symbol = packet.serialize();
+            write_file(&block_dir.join(symbol_id(&symbol)), &symbol).unwrap();
+            layout.blocks[0].symbols.push(symbol_id(&symbol));
+        }
+        let layout_path = dir_path.join("split.json");
+        write_file(&layout_path, serde_json::to_string(&layout).unwrap().as_bytes()).unwrap();
+
+        // A source symbol lost in each source block, and a repair symbol
+        let symbols = &layout.blocks[0].symbols;
+        let source_paths: Vec<PathBuf> = [&symbols[3], &symbols[21]].iter().map(|id| block_dir.join(id)).collect();
+        let contents: Vec<Vec<u8>> = source_paths.iter().map(|path| read_file(path).unwrap()).collect();
+        for path in &source_paths {
+            std::fs::remove_file(path).unwrap();
+        }
+        let repair_path = block_dir.join(&symbols[10]);
+        std::fs::remove_file(&repair_path).unwrap();
+
+        let repaired = processor.decode_and_repair(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), layout_path.to_str().unwrap()).unwrap();
+        assert_eq!(repaired, 2);
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
+        for (path, content) in source_paths.iter().zip(&contents) {
+            assert_eq!(&read_file(path).unwrap(), content, "{:?} should be written back as it was", path);
+        }
+        assert!(!repair_path.exists());
+    }
+
+    #[test]
+    fn test_generate_repair_symbols() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let output_path = dir_path.join("output.bin");
+        let original_data = generate_test_data(20 * 1024);
+        write_file(&input_path, &original_data).expect("Failed to write the input file");
+
+        // A single block of 20 source symbols
+        let config = ProcessorConfig { symbol_size: 1024, ..ProcessorConfig::default() };
+        let processor = RaptorQProcessor::new(config);
         let result = processor.encode_file(
             input_path.to_str().unwrap(),
-            output_dir.to_str().unwrap(),
+            symbols_dir.to_str().unwrap(),
             0,
             false
-        );
-        
-        assert!(result.is_ok());
-        let result = result.unwrap();
-        
-        // Verify result fields
-        assert!(result.total_symbols_count > 0);
-        assert!(result.total_repair_symbols > 0);
-        assert_eq!(result.symbols_directory, output_dir.to_str().unwrap());
-        assert!(result.total_symbols_count > 0);
+        ).expect("Failed to encode the file");
+        let layout_path = Path::new(&result.layout_file_path);
+        let original_layout: RaptorQLayout = serde_json::from_str(&read_file_to_string(layout_path).unwrap()).unwrap();
+        let original_count = original_layout.blocks[0].symbols.len();
 
-        // Ensure temp_dir isn't dropped early
-        drop(temp_dir);
+        let new_ids = processor.generate_repair_symbols(
+            input_path.to_str().unwrap(),
+            symbols_dir.to_str().unwrap(),
+            &result.layout_file_path,
+            0,
+            5,
+        ).expect("Failed to generate repair symbols");
+        assert_eq!(new_ids.len(), 5);
+
+        // Recorded in the layout, with the ESIs following the existing ones
+        let layout: RaptorQLayout = serde_json::from_str(&read_file_to_string(layout_path).unwrap()).unwrap();
+        assert_eq!(layout.blocks[0].symbols[..original_count], original_layout.blocks[0].symbols[..]);
+        assert_eq!(layout.blocks[0].symbols[original_count..], new_ids[..]);
+        let block_dir = symbols_dir.join("block_0");
+        for (i, id) in new_ids.iter().enumerate() {
+            let symbol = read_file(&block_dir.join(id)).unwrap();
+            assert_eq!(symbol_esi(&symbol), Some((original_count + i) as u32));
+            assert_eq!(layout.blocks[0].is_repair_symbol(&symbol), Some(true));
+        }
+
+        // Minting again gives fresh symbols
+        let more_ids = processor.generate_repair_symbols(
+            input_path.to_str().unwrap(),
+            symbols_dir.to_str().unwrap(),
+            &result.layout_file_path,
+            0,
+            3,
+        ).expect("Failed to generate repair symbols");
+        assert!(more_ids.iter().all(|id| !new_ids.contains(id)));
+        let symbol = read_file(&block_dir.join(&more_ids[0])).unwrap();
+        assert_eq!(symbol_esi(&symbol), Some((original_count + 5) as u32));
+
+        // The new symbols replace lost ones
+        for id in &original_layout.blocks[0].symbols[..original_count - 17] {
+            std::fs::remove_file(block_dir.join(id)).unwrap();
+        }
+        processor.decode_symbols(symbols_dir.to_str().unwrap(), output_path.to_str().unwrap(), &result.layout_file_path)
+            .expect("Failed to decode with the new symbols");
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
+
+        let result_err = processor.generate_repair_symbols(
+            input_path.to_str().unwrap(),
+            symbols_dir.to_str().unwrap(),
+            &result.layout_file_path,
+            0,
+            MAX_ENCODING_SYMBOL_ID,
+        );
+        assert!(matches!(result_err, Err(ProcessError::InvalidParameter(_))));
+
+        // The ESIs of the symbols of a block split into several source blocks are unknown
+        let mut split_layout = layout.clone();
+        split_layout.blocks[0].encoder_parameters = ObjectTransmissionInformation::new(20 * 1024, 1024, 2, 1, 8).serialize().to_vec();
+        let split_layout_path = dir_path.join("split_layout.json");
+        write_file(&split_layout_path, &serde_json::to_vec(&split_layout).unwrap()).unwrap();
+        let result_err = processor.generate_repair_symbols(
+            input_path.to_str().unwrap(),
+            symbols_dir.to_str().unwrap(),
+            split_layout_path.to_str().unwrap(),
+            0,
+            1,
+        );
+        assert!(matches!(result_err, Err(ProcessError::InvalidParameter(_))));
+        assert!(processor.get_last_error().contains("split into 2 source blocks"), "{}", processor.get_last_error());
     }
 
     #[test]
-    fn test_encode_verify_symbols() {
-        let (temp_dir, dir_path) = create_temp_dir();
-        let input_path = dir_path.join("file.bin");
-        let output_dir = dir_path.join("output");
-        let block_dir = output_dir.join("block_0");
+    fn test_get_symbol() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        write_file(&input_path, &generate_test_data(20 * 1024)).expect("Failed to write the input file");
 
-        // Create a test file (200 KB)
-        create_test_file(&input_path, 200 * 1024).expect("Failed to create test file");
-        
-        let processor = RaptorQProcessor::new(ProcessorConfig::default());
+        // A single block of 20 source symbols
+        let config = ProcessorConfig { symbol_size: 1024, ..ProcessorConfig::default() };
+        let processor = RaptorQProcessor::new(config);
         let result = processor.encode_file(
             input_path.to_str().unwrap(),
-            output_dir.to_str().unwrap(),
+            symbols_dir.to_str().unwrap(),
             0,
             false
-        );
-        
-        assert!(result.is_ok());
-        let result = result.unwrap();
-        
-        // Check that symbol files are created in the output directory
-        // Use count_files_in_dir instead of fs::read_dir
-        let symbol_count = count_files_in_dir(&block_dir);
-        
-        assert!(symbol_count > 0);
-        assert_eq!(symbol_count, result.total_symbols_count as usize);
-        
-        // Ensure temp_dir isn't dropped early
-        drop(temp_dir);
-    }
+        ).expect("Failed to encode the file");
+        let symbols_dir_str = symbols_dir.to_str().unwrap();
+        let input_path_str = input_path.to_str().unwrap();
+        let layout_path = result.layout_file_path.as_str();
+        let layout = RaptorQLayout::read_file(layout_path).unwrap();
+        let block_dir = symbols_dir.join("block_0");
+        let count = layout.blocks[0].symbols.len() as u32;
+
+        // Stored symbols, source and repair
+        for esi in [0, 19, count - 1] {
+            let symbol = processor.get_symbol(symbols_dir_str, layout_path, 0, esi, None).unwrap();
+            assert_eq!(symbol_esi(&symbol), Some(esi));
+            assert!(layout.blocks[0].symbols.contains(&get_hash_as_b58(&symbol)));
+        }
 
-    // Tests for RaptorQProcessor::decode_symbols
+        // Symbols not stored yet are the ones generate_repair_symbols would write
+        let from_symbols = processor.get_symbol(symbols_dir_str, layout_path, 0, count + 2, None).unwrap();
+        let from_input = processor.get_symbol(symbols_dir_str, layout_path, 0, count + 2, Some(input_path_str)).unwrap();
+        assert_eq!(from_symbols, from_input);
+        assert_eq!(symbol_esi(&from_symbols), Some(count + 2));
+        let new_ids = processor.generate_repair_symbols(input_path_str, symbols_dir_str, layout_path, 0, 3).unwrap();
+        assert_eq!(read_file(&block_dir.join(&new_ids[2])).unwrap(), from_symbols);
+
+        // A lost source symbol is generated from the others
+        let source_symbol = processor.get_symbol(symbols_dir_str, layout_path, 0, 4, None).unwrap();
+        std::fs::remove_file(block_dir.join(get_hash_as_b58(&source_symbol))).unwrap();
+        assert_eq!(processor.get_symbol(symbols_dir_str, layout_path, 0, 4, None).unwrap(), source_symbol);
+
+        // Keep 5 symbols, too few to decode the block
+        let remaining: Vec<_> = std::fs::read_dir(&block_dir).unwrap().map(|e| e.unwrap().path()).collect();
+        for path in remaining.iter().skip(5) {
+            std::fs::remove_file(path).unwrap();
+        }
+        let stored = read_file(&remaining[0]).unwrap();
+        let stored_esi = symbol_esi(&stored).unwrap();
+        assert_eq!(processor.get_symbol(symbols_dir_str, layout_path, 0, stored_esi, None).unwrap(), stored);
+        let err = processor.get_symbol(symbols_dir_str, layout_path, 0, count + 10, None).unwrap_err();
+        assert!(matches!(err, ProcessError::SymbolNotFound { block_id: 0, esi } if esi == count + 10));
+        assert_eq!(processor.get_symbol(symbols_dir_str, layout_path, 0, 4, Some(input_path_str)).unwrap(), source_symbol);
+
+        assert!(matches!(
+            processor.get_symbol(symbols_dir_str, layout_path, 1, 0, None),
+            Err(ProcessError::InvalidParameter(_))
+        ));
+        assert!(matches!(
+            processor.get_symbol(symbols_dir_str, layout_path, 0, MAX_ENCODING_SYMBOL_ID + 1, None),
+            Err(ProcessError::InvalidParameter(_))
+        ));
+
+        // An ESI is ambiguous in a block split into several source blocks
+        let mut split_layout = layout.clone();
+        split_layout.blocks[0].encoder_parameters = ObjectTransmissionInformation::new(20 * 1024, 1024, 2, 1, 8).serialize().to_vec();
+        let split_layout_path = dir_path.join("split_layout.json");
+        write_file(&split_layout_path, &serde_json::to_vec(&split_layout).unwrap()).unwrap();
+        assert!(matches!(
+            processor.get_symbol(symbols_dir_str, split_layout_path.to_str().unwrap(), 0, 0, Some(input_path_str)),
+            Err(ProcessError::InvalidParameter(_))
+        ));
+        assert!(processor.get_last_error().contains("split into 2 source blocks"), "{}", processor.get_last_error());
+    }
 
     #[test]
-    fn test_decode_symbols_dir_not_found() {
-        let processor = RaptorQProcessor::new(ProcessorConfig::default());
-        let result = processor.decode_symbols(
-            "non_existent_dir",
-            "output.bin",
-            "layout.json"
-        );
-        
-        assert!(matches!(result, Err(ProcessError::FileNotFound(_))));
+    fn test_shard_symbols() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let original_data: Vec<u8> = (0..30 * 1024).map(|i| (i * 31 / 7 % 251) as u8).collect();
+        write_file(&input_path, &original_data).unwrap();
+
+        // 3 blocks of 10 source and 14 repair symbols, 8 symbols of each block per shard
+        let processor = RaptorQProcessor::builder().symbol_size(1024).repair_symbols_per_block(14).build().unwrap();
+        let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 10 * 1024, false).unwrap();
+        let layout = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+        let shard_paths: Vec<String> = (0..3).map(|i| dir_path.join(format!("shard_{}", i)).to_string_lossy().to_string()).collect();
+        let shard_dirs: Vec<&str> = shard_paths.iter().map(String::as_str).collect();
+
+        let map = processor.shard_symbols(symbols_dir.to_str().unwrap(), &result.layout_file_path, &shard_dirs).unwrap();
+        assert_eq!(map.symbols.len() as u64, layout.symbols_count());
+        assert!(map.decodable_shards.is_empty(), "No shard should decode a block on its own");
+        for block in &layout.blocks {
+            let mut counts = [0; 3];
+            for symbol_id in &block.symbols {
+                let shard = map.symbols[symbol_id];
+                counts[shard] += 1;
+                let path = Path::new(shard_dirs[shard]).join(block_dir_name(block.block_id)).join(symbol_id);
+                assert_eq!(read_file(&path).unwrap(), read_file(&symbols_dir.join(block_dir_name(block.block_id)).join(symbol_id)).unwrap());
+            }
+            assert_eq!(counts, [8, 8, 8]);
+        }
+        for shard_dir in &shard_dirs {
+            assert_eq!(ShardMap::read_dir(shard_dir).unwrap(), map);
+        }
+        let symbol_id = &layout.blocks[1].symbols[0];
+        assert_eq!(map.shard_dir(symbol_id), Some(shard_dirs[1]));
+        assert_eq!(map.shard_dir("unknown"), None);
+
+        // Any two shards decode the object
+        let output_path = dir_path.join("output.bin");
+        processor.decode_from_dirs(&shard_dirs[1..], output_path.to_str().unwrap(), &result.layout_file_path).unwrap();
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
+
+        // The same sharding again, a single shard holds everything
+        let other_dir = dir_path.join("other").to_string_lossy().to_string();
+        let other = processor.shard_symbols(symbols_dir.to_str().unwrap(), &result.layout_file_path, &[&other_dir]).unwrap();
+        assert_eq!(other.decodable_shards, vec![0]);
+        assert!(other.symbols.values().all(|&shard| shard == 0));
+
+        for bad_shards in [vec![], vec![shard_dirs[0], shard_dirs[0]]] {
+            let result = processor.shard_symbols(symbols_dir.to_str().unwrap(), &result.layout_file_path, &bad_shards);
+            assert!(matches!(result, Err(ProcessError::InvalidParameter(_))), "{:?}", result);
+        }
+        let missing_dir = dir_path.join("missing");
+        assert!(matches!(
+            processor.shard_symbols(missing_dir.to_str().unwrap(), &result.layout_file_path, &shard_dirs),
+            Err(ProcessError::InvalidPath(_))
+        ));
+        assert!(matches!(ShardMap::read_dir(missing_dir.to_str().unwrap()), Err(ProcessError::FileNotFound(_))));
     }
 
     #[test]
-    fn test_decode_no_symbols_in_dir() {
-        let (temp_dir, dir_path) = create_temp_dir();
+    fn test_shard_symbols_hostile_layout() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
         let symbols_dir = dir_path.join("symbols");
-        let output_path = dir_path.join("output.bin");
-        let layout_path = dir_path.join("layout.json");
-        
-        // Create an empty directory
-        create_dir(&symbols_dir).expect("Failed to create the symbols directory");
+        write_file(&input_path, &generate_test_data(10 * 1024)).unwrap();
+
+        let processor = RaptorQProcessor::builder().symbol_size(1024).build().unwrap();
+        let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 0, false).unwrap();
+        let mut layout = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+        let block_dir = symbols_dir.join(block_dir_name(0));
+        let genuine = layout.blocks[0].symbols.clone();
+
+        // A symbol outside the block directory, and a file holding another symbol than its id
+        let outside = read_file(&block_dir.join(&genuine[0])).unwrap();
+        write_file(&symbols_dir.join("outside"), &outside).unwrap();
+        write_file(&block_dir.join("forged"), &read_file(&block_dir.join(&genuine[1])).unwrap()).unwrap();
+        layout.blocks[0].symbols.extend(["../outside", "forged", "..", ""].map(String::from));
+        let layout_path = dir_path.join("hostile.json");
+        write_file(&layout_path, serde_json::to_string(&layout).unwrap().as_bytes()).unwrap();
+
+        let shard_paths: Vec<String> = (0..2).map(|i| dir_path.join(format!("shard_{}", i)).to_string_lossy().to_string()).collect();
+        let shard_dirs: Vec<&str> = shard_paths.iter().map(String::as_str).collect();
+        let map = processor.shard_symbols(symbols_dir.to_str().unwrap(), layout_path.to_str().unwrap(), &shard_dirs).unwrap();
+
+        // Only the genuine symbols are copied, within the block directories of the shards
+        let sharded: Vec<&String> = map.symbols.keys().collect();
+        let mut expected: Vec<&String> = genuine.iter().collect();
+        expected.sort();
+        assert_eq!(sharded, expected);
+        for shard_dir in &shard_dirs {
+            assert!(!path_exists(&Path::new(shard_dir).join("outside")));
+            assert!(!path_exists(&Path::new(shard_dir).join(block_dir_name(0)).join("forged")));
+        }
+    }
 
-        // create fake layout file
-        let config = ObjectTransmissionInformation::with_defaults(1024, 128);
-        let encoder_params = config.serialize().to_vec();
-        let packets = vec![vec![0; 128]; 10];
-        
-        // Create a single BlockLayout for the entire file
-        let block_layout = BlockLayout {
+    #[test]
+    fn test_audit_object() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        write_file(&input_path, &generate_test_data(30 * 1024)).expect("Failed to write the input file");
+
+        // 3 blocks of 10 source symbols each
+        let processor = RaptorQProcessor::builder().symbol_size(1024).build().unwrap();
+        let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 10 * 1024, false).unwrap();
+        let symbols_per_block = result.blocks.unwrap()[1].symbols_count;
+        let threshold = 10 + DECODE_SYMBOL_OVERHEAD;
+        let symbols_dir_str = symbols_dir.to_str().unwrap();
+        let layout_path_str = result.layout_file_path.as_str();
+
+        let report = processor.audit_object(symbols_dir_str, layout_path_str).unwrap();
+        assert!(report.recoverable);
+        assert_eq!(report.min_margin, (symbols_per_block - threshold) as i64);
+        assert_eq!(report.blocks.len(), 3);
+        assert_eq!(report.blocks[0], BlockAudit {
             block_id: 0,
-            encoder_parameters: encoder_params,
-            original_offset: 0,
-            size: 1024,
-            symbols: packets.iter().enumerate().map(|(i, _)| format!("symbol_{}.bin", i)).collect(),
-            hash: "dummy_hash".to_string(),
-        };
-        
-        let layout = RaptorQLayout {
-            blocks: vec![block_layout],
+            listed: symbols_per_block,
+            available: symbols_per_block,
+            source_symbols: 10,
+            threshold,
+            margin: (symbols_per_block - threshold) as i64,
+        });
+
+        // Keep 5 symbols of the second block, the others keep their margin
+        let entries: Vec<_> = std::fs::read_dir(symbols_dir.join("block_1")).unwrap().map(|e| e.unwrap().path()).collect();
+        for path in entries.iter().skip(5) {
+            std::fs::remove_file(path).unwrap();
+        }
+        let report = processor.audit_object(symbols_dir_str, layout_path_str).unwrap();
+        assert!(!report.recoverable);
+        assert_eq!((report.blocks[1].available, report.blocks[1].margin), (5, 5 - threshold as i64));
+        assert_eq!(report.min_margin, 5 - threshold as i64);
+        assert_eq!(report.blocks[2].available, symbols_per_block);
+        assert!(!processor.can_decode(symbols_dir_str, layout_path_str).unwrap());
+
+        // Nothing is written, a missing directory has no symbols
+        let missing_dir = dir_path.join("missing");
+        let report = processor.audit_object(missing_dir.to_str().unwrap(), layout_path_str).unwrap();
+        assert!(report.blocks.iter().all(|b| b.available == 0 && b.margin == -(threshold as i64)));
+        assert!(!missing_dir.exists());
+
+        let missing_layout = dir_path.join("missing.json");
+        assert!(matches!(
+            processor.audit_object(symbols_dir_str, missing_layout.to_str().unwrap()),
+            Err(ProcessError::FileNotFound(_))
+        ));
+    }
+
+    #[test]
+    fn test_symbol_inventory() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let original_data: Vec<u8> = (0..30 * 1024).map(|i| (i * 31 / 7 % 251) as u8).collect();
+        write_file(&input_path, &original_data).unwrap();
+
+        // 3 blocks of 10 source symbols each
+        let processor = RaptorQProcessor::builder().symbol_size(1024).build().unwrap();
+        let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 10 * 1024, false).unwrap();
+        let layout = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+        let repair_symbols = layout.blocks[0].repair_symbols_count();
+        assert!(repair_symbols > 2);
+        let symbols_dir_str = symbols_dir.to_str().unwrap();
+        let layout_path_str = result.layout_file_path.as_str();
+
+        let complete = BlockInventory {
+            source_present: 10,
+            repair_present: repair_symbols,
+            source_expected: 10,
+            repair_expected: repair_symbols,
         };
-        //write the layout file
-        let layout_json = serde_json::to_string_pretty(&layout).expect("Failed to serialize layout");
-        write_file(&layout_path, layout_json.as_bytes()).expect("Failed to write the layout file");
+        let inventory = processor.symbol_inventory(symbols_dir_str, layout_path_str).unwrap();
+        assert_eq!(inventory, (0..3).map(|block_id| (block_id, complete)).collect());
 
-        let processor = RaptorQProcessor::new(ProcessorConfig::default());
-        let result = processor.decode_symbols(
+        // 2 source symbols lost in the first block, 2 repair symbols in the last one
+        let symbols = &layout.blocks[0].symbols;
+        for symbol_id in &symbols[3..5] {
+            std::fs::remove_file(symbols_dir.join("block_0").join(symbol_id)).unwrap();
+        }
+        let symbols = &layout.blocks[2].symbols;
+        for symbol_id in &symbols[symbols.len() - 2..] {
+            std::fs::remove_file(symbols_dir.join("block_2").join(symbol_id)).unwrap();
+        }
+        let inventory = processor.symbol_inventory(symbols_dir_str, layout_path_str).unwrap();
+        assert_eq!(inventory[&0], BlockInventory { source_present: 8, ..complete });
+        assert_eq!(inventory[&1], complete);
+        assert_eq!(inventory[&2], BlockInventory { repair_present: repair_symbols - 2, ..complete });
+
+        // A missing directory has no symbols
+        let inventory = processor.symbol_inventory(dir_path.join("missing").to_str().unwrap(), layout_path_str).unwrap();
+        assert!(inventory.values().all(|b| b.source_present == 0 && b.repair_present == 0 && b.source_expected == 10));
+        assert!(matches!(
+            processor.symbol_inventory(symbols_dir_str, dir_path.join("missing.json").to_str().unwrap()),
+            Err(ProcessError::FileNotFound(_))
+        ));
+    }
+
+    #[test]
+    fn test_symbol_inventory_source_blocks() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let original_data = generate_test_data(21 * 1024);
+        write_file(&input_path, &original_data).unwrap();
+
+        let processor = RaptorQProcessor::builder().symbol_size(1024).build().unwrap();
+        let result = processor.encode_file(input_path.to_str().unwrap(), symbols_dir.to_str().unwrap(), 0, false).unwrap();
+
+        // The block split into source blocks of 11 and 10 source symbols with 2 repair
+        // symbols each, listed by source block
+        let block_dir = symbols_dir.join(block_dir_name(0));
+        std::fs::remove_dir_all(&block_dir).unwrap();
+        std::fs::create_dir_all(&block_dir).unwrap();
+        let config = ObjectTransmissionInformation::new(21 * 1024, 1024, 2, 1, 8);
+        let mut layout = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+        layout.blocks[0].encoder_parameters = config.serialize().to_vec();
+        layout.blocks[0].symbols.clear();
+        for packet in Encoder::new(&original_data, config).get_encoded_packets(2) {
+            let symbol = packet.serialize();
+            write_file(&block_dir.join(symbol_id(&symbol)), &symbol).unwrap();
+            layout.blocks[0].symbols.push(symbol_id(&symbol));
+        }
+        let layout_path = dir_path.join("layout.json");
+        write_file(&layout_path, serde_json::to_string(&layout).unwrap().as_bytes()).unwrap();
+
+        let complete = BlockInventory { source_present: 21, repair_present: 4, source_expected: 21, repair_expected: 4 };
+        let inventory = processor.symbol_inventory(symbols_dir.to_str().unwrap(), layout_path.to_str().unwrap()).unwrap();
+        assert_eq!(inventory[&0], complete);
+
+        // The repair symbols of the first source block, which the layout lists before
+        // the source symbols of the second one
+        let symbols = &layout.blocks[0].symbols;
+        std::fs::remove_file(block_dir.join(&symbols[11])).unwrap();
+        std::fs::remove_file(block_dir.join(&symbols[12])).unwrap();
+        let inventory = processor.symbol_inventory(symbols_dir.to_str().unwrap(), layout_path.to_str().unwrap()).unwrap();
+        assert_eq!(inventory[&0], BlockInventory { repair_present: 2, ..complete });
+    }
+
+    #[test]
+    fn test_missing_symbols() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        write_file(&input_path, &generate_test_data(30 * 1024)).expect("Failed to write the input file");
+
+        // 3 blocks of 10 source symbols each
+        let config = ProcessorConfig { symbol_size: 1024, ..ProcessorConfig::default() };
+        let processor = RaptorQProcessor::new(config);
+        let result = processor.encode_file(
+            input_path.to_str().unwrap(),
             symbols_dir.to_str().unwrap(),
-            output_path.to_str().unwrap(),
-            layout_path.to_str().unwrap()
-        );
-        
-        assert!(matches!(result, Err(ProcessError::DecodingFailed(_))));
-        
-        // Ensure temp_dir isn't dropped early
-        drop(temp_dir);
+            10 * 1024,
+            false
+        ).expect("Failed to encode the file");
+        let symbols_per_block = result.blocks.unwrap()[1].symbols_count as i64;
+        let required = 10 + DECODE_SYMBOL_OVERHEAD as i64;
+
+        let symbols_dir_str = symbols_dir.to_str().unwrap();
+        let layout_path_str = result.layout_file_path.as_str();
+        let missing = processor.missing_symbols(symbols_dir_str, layout_path_str).unwrap();
+        assert_eq!(missing.len(), 3);
+        assert!(missing.values().all(|&count| count == required - symbols_per_block));
+
+        // Keep 5 symbols of the second block
+        let entries: Vec<_> = std::fs::read_dir(symbols_dir.join("block_1"))
+            .expect("Failed to read the block directory")
+            .map(|e| e.unwrap().path())
+            .collect();
+        for path in entries.iter().skip(5) {
+            std::fs::remove_file(path).expect("Failed to remove the symbol file");
+        }
+        let missing = processor.missing_symbols(symbols_dir_str, layout_path_str).unwrap();
+        assert_eq!(missing[&1], required - 5);
+        assert_eq!(missing[&0], required - symbols_per_block);
+
+        let missing_dir = dir_path.join("missing");
+        let missing = processor.missing_symbols(missing_dir.to_str().unwrap(), layout_path_str).unwrap();
+        assert!(missing.values().all(|&count| count == required));
+
+        let missing_layout = dir_path.join("missing.json");
+        assert!(matches!(
+            processor.missing_symbols(symbols_dir_str, missing_layout.to_str().unwrap()),
+            Err(ProcessError::FileNotFound(_))
+        ));
+    }
+
+    #[test]
+    fn test_reconstruct_layout() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let original_data = generate_test_data(250 * 1024);
+        write_file(&input_path, &original_data).unwrap();
+        let symbols = symbols_dir.to_str().unwrap();
+
+        let processor = RaptorQProcessor::builder().symbol_size(1024).redundancy_factor(2).build().unwrap();
+        let result = processor.encode_file(input_path.to_str().unwrap(), symbols, 100 * 1024, false).unwrap();
+        let mut original = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+        std::fs::remove_file(&result.layout_file_path).unwrap();
+
+        // Files that are not symbols of their block are skipped
+        write_file(&symbols_dir.join("block_0").join("notes.txt"), b"not a symbol").unwrap();
+        let block_1 = symbols_dir.join("block_1");
+        let moved = &original.blocks[0].symbols[0];
+        std::fs::copy(symbols_dir.join("block_0").join(moved), block_1.join(moved)).unwrap();
+        let renamed = &original.blocks[1].symbols[0];
+        std::fs::rename(block_1.join(renamed), block_1.join("renamed")).unwrap();
+
+        let layout = processor.reconstruct_layout(symbols, original_data.len() as u64, 100 * 1024).unwrap();
+        original.blocks[1].symbols.remove(0);
+        for block in &mut original.blocks {
+            block.hash = String::new();
+        }
+        original.object_sha256 = String::new();
+        assert_eq!(layout, original);
+
+        let layout_path = dir_path.join("layout.json");
+        write_file(&layout_path, serde_json::to_string_pretty(&layout).unwrap().as_bytes()).unwrap();
+        let output_path = dir_path.join("output.bin");
+        processor.decode_symbols(symbols, output_path.to_str().unwrap(), layout_path.to_str().unwrap()).unwrap();
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
+
+        // Sizes or symbol sizes not matching the symbols
+        assert!(matches!(processor.reconstruct_layout(symbols, 0, 100 * 1024), Err(ProcessError::InvalidParameter(_))));
+        assert!(matches!(
+            processor.reconstruct_layout(symbols, 150 * 1024, 100 * 1024),
+            Err(ProcessError::InvalidParameter(_))
+        ));
+        let other = RaptorQProcessor::builder().symbol_size(512).build().unwrap();
+        let result = other.reconstruct_layout(symbols, original_data.len() as u64, 100 * 1024);
+        assert!(matches!(result, Err(ProcessError::DecodingFailed(_))), "{:?}", result);
+        assert!(matches!(
+            processor.reconstruct_layout(dir_path.join("missing").to_str().unwrap(), 1000, 0),
+            Err(ProcessError::InvalidPath(_))
+        ));
+
+        // A single block without block directory
+        let flat_dir = dir_path.join("flat");
+        processor.set_flat_symbol_layout(true);
+        let result = processor.encode_file(input_path.to_str().unwrap(), flat_dir.to_str().unwrap(), 0, false).unwrap();
+        let original = RaptorQLayout::read_file(&result.layout_file_path).unwrap();
+        let layout = processor.reconstruct_layout(flat_dir.to_str().unwrap(), original_data.len() as u64, 0).unwrap();
+        assert_eq!(layout.blocks.len(), 1);
+        assert_eq!(layout.blocks[0].symbols, original.blocks[0].symbols);
     }
 
     #[test]
-    fn test_decode_success_single_file() {
-        let (temp_dir, dir_path) = create_temp_dir();
+    fn test_validate_layout() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
         let symbols_dir = dir_path.join("symbols");
-        let output_path = dir_path.join("output.bin");
-        
-        // Create original data
-        let original_data = generate_test_data(100 * 1024);
-        
-        // Create symbols directory
-        create_dir(&symbols_dir).expect("Failed to create symbols directory");
-        
-        // Create encoded symbols
-        let (encoder_params, packets) = encode_test_data(
-            &original_data,
-            DEFAULT_SYMBOL_SIZE_B,
-            10
-        );
-        
-        // Create symbol files
-        create_symbol_files(&symbols_dir, &packets).expect("Failed to create symbol files");
-        
-        // Create layout file
-        let layout_path = symbols_dir.join(LAYOUT_FILENAME);
-        
-        // Create a single BlockLayout for the entire file
-        let block_layout = create_block_layout(&original_data, encoder_params, packets);
-        
-        let layout = RaptorQLayout {
-            blocks: vec![block_layout],
+        write_file(&input_path, &generate_test_data(30 * 1024)).expect("Failed to write the input file");
+
+        // 3 blocks of 10 source symbols each
+        let config = ProcessorConfig { symbol_size: 1024, ..ProcessorConfig::default() };
+        let processor = RaptorQProcessor::new(config);
+        let result = processor.encode_file(
+            input_path.to_str().unwrap(),
+            symbols_dir.to_str().unwrap(),
+            10 * 1024,
+            false
+        ).expect("Failed to encode the file");
+        let symbols_dir_str = symbols_dir.to_str().unwrap();
+        let layout_path_str = result.layout_file_path.as_str();
+        assert!(processor.validate_layout(symbols_dir_str, layout_path_str).unwrap().is_empty());
+
+        // Remove one symbol of block 0 and all but 5 symbols of block 1
+        let block_symbols = |block: &str| -> Vec<PathBuf> {
+            std::fs::read_dir(symbols_dir.join(block)).unwrap().map(|e| e.unwrap().path()).collect()
         };
-        
-        let layout_json = serde_json::to_string_pretty(&layout).expect("Failed to serialize layout");
-        write_file(&layout_path, layout_json.as_bytes()).expect("Failed to write layout file");
-        
-        let processor = RaptorQProcessor::new(ProcessorConfig::default());
-        
-        let result = processor.decode_symbols(
+        std::fs::remove_file(&block_symbols("block_0")[0]).unwrap();
+        for path in block_symbols("block_1").iter().skip(5) {
+            std::fs::remove_file(path).unwrap();
+        }
+        let issues = processor.validate_layout(symbols_dir_str, layout_path_str).unwrap();
+        let kinds: Vec<_> = issues.iter().map(|i| (i.block_id, i.kind)).collect();
+        assert_eq!(kinds, vec![
+            (Some(0), LayoutIssueKind::MissingSymbols),
+            (Some(1), LayoutIssueKind::InsufficientSymbols),
+        ]);
+        assert!(issues[1].message.contains("5 of its"), "{}", issues[1].message);
+
+        let issues = processor.validate_layout(dir_path.join("missing").to_str().unwrap(), layout_path_str).unwrap();
+        assert_eq!(issues.len(), 1);
+        assert_eq!(issues[0].kind, LayoutIssueKind::MissingSymbolsDirectory);
+
+        // Drop block 1 and move block 2, then drop source symbols of block 0
+        let mut layout = RaptorQLayout::read_file(layout_path_str).unwrap();
+        layout.blocks.remove(1);
+        layout.blocks[1].original_offset += 100;
+        layout.blocks[0].symbols.truncate(3);
+        layout.blocks[0].size -= 1;
+        let edited_layout = dir_path.join("edited.json");
+        std::fs::write(&edited_layout, serde_json::to_vec(&layout).unwrap()).unwrap();
+        let issues = processor.validate_layout(symbols_dir_str, edited_layout.to_str().unwrap()).unwrap();
+        let kinds: Vec<_> = issues.iter().map(|i| (i.block_id, i.kind)).collect();
+        assert_eq!(kinds, vec![
+            (Some(1), LayoutIssueKind::MissingBlock),
+            (Some(0), LayoutIssueKind::SizeMismatch),
+            (Some(0), LayoutIssueKind::TooFewSymbols),
+            (Some(2), LayoutIssueKind::OffsetMismatch),
+        ]);
+        let json = serde_json::to_value(&issues[0]).unwrap();
+        assert_eq!(json["kind"], "missing_block");
+
+        layout.blocks.clear();
+        std::fs::write(&edited_layout, serde_json::to_vec(&layout).unwrap()).unwrap();
+        let issues = processor.validate_layout(symbols_dir_str, edited_layout.to_str().unwrap()).unwrap();
+        assert_eq!(issues[0].kind, LayoutIssueKind::NoBlocks);
+        assert!(serde_json::to_value(&issues[0]).unwrap().get("block_id").is_none());
+
+        assert!(matches!(
+            processor.validate_layout(symbols_dir_str, dir_path.join("missing.json").to_str().unwrap()),
+            Err(ProcessError::FileNotFound(_))
+        ));
+    }
+
+    #[test]
+    fn test_scrub_symbols() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        write_file(&input_path, &generate_test_data(20 * 1024)).expect("Failed to write the input file");
+
+        // 2 blocks of 10 source symbols each
+        let config = ProcessorConfig { symbol_size: 1024, ..ProcessorConfig::default() };
+        let processor = RaptorQProcessor::new(config);
+        let result = processor.encode_file(
+            input_path.to_str().unwrap(),
             symbols_dir.to_str().unwrap(),
-            output_path.to_str().unwrap(),
-            layout_path.to_str().unwrap()
-        );
-        
-        assert!(result.is_ok());
-        
-        // Verify the decoded file matches the original
-        let decoded_data = read_file(&output_path).expect("Failed to read decoded file");
-        assert_eq!(decoded_data, original_data);
-        
-        // Ensure temp_dir isn't dropped early
-        drop(temp_dir);
+            10 * 1024,
+            false
+        ).expect("Failed to encode the file");
+        let symbols_dir_str = symbols_dir.to_str().unwrap();
+        let layout_path_str = result.layout_file_path.as_str();
+        let scrub = |layout_path: &str| {
+            let mut issues = Vec::new();
+            processor.scrub_symbols(symbols_dir_str, layout_path, |issue| issues.push(issue)).expect("Scrub should succeed");
+            issues
+        };
+        assert!(scrub(layout_path_str).is_empty());
+
+        // Remove a symbol, truncate another and swap two files of block 0, empty one of block 1
+        let layout = RaptorQLayout::read_file(layout_path_str).unwrap();
+        let block_0 = symbols_dir.join("block_0");
+        let ids = &layout.blocks[0].symbols;
+        std::fs::remove_file(block_0.join(&ids[0])).unwrap();
+        let truncated = read_file(&block_0.join(&ids[1])).unwrap();
+        write_file(&block_0.join(&ids[1]), &truncated[..100]).unwrap();
+        let third = read_file(&block_0.join(&ids[2])).unwrap();
+        let fourth = read_file(&block_0.join(&ids[3])).unwrap();
+        write_file(&block_0.join(&ids[2]), &fourth).unwrap();
+        write_file(&block_0.join(&ids[3]), &third).unwrap();
+        let emptied = &layout.blocks[1].symbols[0];
+        write_file(&symbols_dir.join("block_1").join(emptied), &[]).unwrap();
+
+        let issues = scrub(layout_path_str);
+        let found: Vec<_> = issues.iter().map(|i| (i.block_id, i.symbol_id.as_deref(), i.kind)).collect();
+        assert_eq!(found, vec![
+            (Some(0), Some(ids[0].as_str()), LayoutIssueKind::MissingSymbol),
+            (Some(0), Some(ids[1].as_str()), LayoutIssueKind::SymbolSizeMismatch),
+            (Some(0), Some(ids[2].as_str()), LayoutIssueKind::SymbolIdMismatch),
+            (Some(0), Some(ids[3].as_str()), LayoutIssueKind::SymbolIdMismatch),
+            (Some(1), Some(emptied.as_str()), LayoutIssueKind::MissingSymbol),
+        ]);
+        assert!(issues[2].message.contains(&ids[3]), "{}", issues[2].message);
+        let json = serde_json::to_value(&issues[1]).unwrap();
+        assert_eq!(json["kind"], "symbol_size_mismatch");
+        assert_eq!(json["symbol_id"], ids[1].as_str());
+
+        let mut issues = Vec::new();
+        processor.scrub_symbols(dir_path.join("missing").to_str().unwrap(), layout_path_str, |issue| issues.push(issue)).unwrap();
+        assert_eq!(issues.len(), 1);
+        assert_eq!(issues[0].kind, LayoutIssueKind::MissingSymbolsDirectory);
+
+        assert!(matches!(
+            processor.scrub_symbols(symbols_dir_str, dir_path.join("missing.json").to_str().unwrap(), |_| {}),
+            Err(ProcessError::FileNotFound(_))
+        ));
     }
 
     #[test]
-    fn test_decode_success_multi_block_file() {
-        let (temp_dir, dir_path) = create_temp_dir();
+    fn test_decode_symbols_verified() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
         let symbols_dir = dir_path.join("symbols");
         let output_path = dir_path.join("output.bin");
-        
-        // Create original data
-        let original_data = generate_test_data(300 * 1024);
-        
-        // Create main symbols directory
-        create_dir(&symbols_dir).expect("Failed to create symbols directory");
-        
-        // Split into blocks (3 blocks of 100KB each)
-        let block_size = 100 * 1024;
-        let mut block_layouts = Vec::with_capacity(3);
-        
-        for i in 0..3 {
-            let block_dir = symbols_dir.join(format!("block_{}", i));
-            create_dir(&block_dir).expect("Failed to create block directory");
-            
-            let start = i * block_size;
-            let end = start + block_size;
-            let block_data = original_data[start..end].to_vec();
-
-            //Get hash of block
-            let block_hash = get_hash_as_b58(&block_data);
+        let original_data = generate_test_data(20 * 1024);
+        write_file(&input_path, &original_data).unwrap();
 
-            // Encode block
-            let (params, packets) = encode_test_data(
-                &block_data,
-                DEFAULT_SYMBOL_SIZE_B,
-                5
-            );
+        // A single block of 20 source symbols
+        let config = ProcessorConfig { symbol_size: 1024, ..ProcessorConfig::default() };
+        let processor = RaptorQProcessor::new(config);
+        let result = processor.encode_file(
+            input_path.to_str().unwrap(),
+            symbols_dir.to_str().unwrap(),
+            0,
+            false,
+        ).unwrap();
+        let layout: RaptorQLayout = serde_json::from_str(
+            &read_file_to_string(Path::new(&result.layout_file_path)).unwrap()
+        ).unwrap();
+        let block_dir = symbols_dir.join("block_0");
+
+        // Corrupt the first symbols the decoder reads
+        let corrupted: Vec<_> = layout.blocks[0].symbols[..3].to_vec();
+        for symbol_id in &corrupted {
+            let symbol_path = block_dir.join(symbol_id);
+            let mut symbol = read_file(&symbol_path).unwrap();
+            let last = symbol.len() - 1;
+            symbol[last] ^= 0xff;
+            write_file(&symbol_path, &symbol).unwrap();
+        }
+        let expected: Vec<_> = corrupted.iter()
+            .map(|symbol_id| CorruptSymbol { block_id: 0, symbol_id: symbol_id.clone() })
+            .collect();
 
-            // Create symbol files for this block
-            create_symbol_files(&block_dir, &packets).expect("Failed to create symbol files");
+        // The decoder routes around the corrupt symbols
+        processor.decode_symbols_verified(
+            symbols_dir.to_str().unwrap(),
+            output_path.to_str().unwrap(),
+            &result.layout_file_path,
+        ).unwrap();
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
+        assert_eq!(processor.get_last_corrupt_symbols(), expected);
 
-            // Create layout file with block information
-            let block_layout = BlockLayout {
-                block_id: i,
-                encoder_parameters: params,
-                original_offset: (i * block_size) as u64,
-                size: block_size as u64,
-                symbols: (0..packets.len()).map(|j| format!("symbol_{}.bin", j)).collect(),
-                hash: block_hash,
-            };
-            block_layouts.push(block_layout);
+        // Not enough valid symbols left
+        for symbol_id in &layout.blocks[0].symbols[20..] {
+            std::fs::remove_file(block_dir.join(symbol_id)).unwrap();
         }
-        let layout = RaptorQLayout {
-            blocks: block_layouts,
-        };
-        
-        // Save layout file
-        let layout_path = symbols_dir.join(LAYOUT_FILENAME);
-        let layout_json = serde_json::to_string_pretty(&layout).expect("Failed to serialize layout");
-        write_file(&layout_path, layout_json.as_bytes()).expect("Failed to write layout file");
-        
-        let processor = RaptorQProcessor::new(ProcessorConfig::default());
-        
-        let result = processor.decode_symbols(
+        let result = processor.decode_symbols_verified(
             symbols_dir.to_str().unwrap(),
             output_path.to_str().unwrap(),
-            layout_path.to_str().unwrap()
+            &result.layout_file_path,
         );
-        
-        assert!(result.is_ok());
-        
-        // Verify the decoded file matches the original
-        let decoded_data = read_file(&output_path).expect("Failed to read decoded file");
-        assert_eq!(decoded_data, original_data);
-        
-        // Ensure temp_dir isn't dropped early
-        drop(temp_dir);
+        assert!(matches!(result, Err(ProcessError::InsufficientSymbols(_))), "Unexpected result {:?}", result);
+        assert_eq!(processor.get_last_corrupt_symbols(), expected);
+        let last_error = processor.get_last_error();
+        assert!(last_error.contains(&format!("symbol {} in block 0 failed checksum", corrupted[0])), "{}", last_error);
     }
 
     #[test]
-    fn test_decode_insufficient_symbols() {
-        let (temp_dir, dir_path) = create_temp_dir();
+    fn test_decode_symbols_checked() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
         let symbols_dir = dir_path.join("symbols");
         let output_path = dir_path.join("output.bin");
-        
-        // Create symbols directory
-        create_dir(&symbols_dir).expect("Failed to create symbols directory");
-        
-        // Create original data
-        let original_data = generate_test_data(100 * 1024);
-        
-        // Create encoded symbols (only source symbols, no repair symbols)
-        // This should not be enough for decoding
-        let (encoder_params, mut packets) = encode_test_data(
-            &original_data,
-            DEFAULT_SYMBOL_SIZE_B,
-            10
-        );
-        
-        // Remove all but one symbol to ensure decoding fails
-        if packets.len() > 1 {
-            packets = vec![packets[0].clone()];
-        }
-        
-        // Create symbol files
-        create_symbol_files(&symbols_dir, &packets).expect("Failed to create symbol files");
-        
-        // Create and save layout file
-        let layout_path = symbols_dir.join(LAYOUT_FILENAME);
-        
-        // Create a single BlockLayout for the entire file
-        let block_layout = create_block_layout(&original_data, encoder_params, packets);
+        let layout_path = dir_path.join("layout.json");
+        let original_data = generate_test_data(50 * 1024);
+        write_file(&input_path, &original_data).unwrap();
 
-        let layout = RaptorQLayout {
-            blocks: vec![block_layout],
-        };
-        
-        let layout_json = serde_json::to_string_pretty(&layout).expect("Failed to serialize layout");
-        write_file(&layout_path, layout_json.as_bytes()).expect("Failed to write layout file");
-        
-        let processor = RaptorQProcessor::new(ProcessorConfig::default());
-        
-        let result = processor.decode_symbols(
+        // Several blocks, the object hash covers all of them
+        let config = ProcessorConfig { symbol_size: 1024, ..ProcessorConfig::default() };
+        let processor = RaptorQProcessor::new(config);
+        let result = processor.encode_file(
+            input_path.to_str().unwrap(),
+            symbols_dir.to_str().unwrap(),
+            20 * 1024,
+            false,
+        ).unwrap();
+        let mut layout: RaptorQLayout = serde_json::from_str(
+            &read_file_to_string(Path::new(&result.layout_file_path)).unwrap()
+        ).unwrap();
+        assert!(layout.blocks.len() > 1);
+        let expected_hash: String = Sha256::digest(&original_data).iter().map(|b| format!("{:02x}", b)).collect();
+        assert_eq!(layout.object_sha256, expected_hash);
+
+        processor.decode_symbols_checked(
             symbols_dir.to_str().unwrap(),
             output_path.to_str().unwrap(),
-            layout_path.to_str().unwrap()
+            &result.layout_file_path,
+        ).unwrap();
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
+
+        // A hash the decoded file doesn't match, with no layout in the symbols directory
+        // telling the objects apart before decoding
+        std::fs::remove_file(symbols_dir.join(LAYOUT_FILENAME)).unwrap();
+        layout.object_sha256 = "00".repeat(32);
+        write_file(&layout_path, serde_json::to_string(&layout).unwrap().as_bytes()).unwrap();
+        let result = processor.decode_symbols_checked(
+            symbols_dir.to_str().unwrap(),
+            output_path.to_str().unwrap(),
+            layout_path.to_str().unwrap(),
         );
-        
-        assert!(matches!(result, Err(ProcessError::DecodingFailed(_))));
-        
-        // Ensure temp_dir isn't dropped early
-        drop(temp_dir);
+        assert!(matches!(result, Err(ProcessError::DecodingFailed(_))), "Unexpected result {:?}", result);
+        assert!(processor.get_last_error().contains(&expected_hash));
+        assert!(output_path.exists(), "The decoded file should be kept");
+
+        // No hash in the layout, nothing is decoded
+        std::fs::remove_file(&output_path).unwrap();
+        layout.object_sha256 = String::new();
+        let layout_json = serde_json::to_string(&layout).unwrap();
+        assert!(!layout_json.contains("object_sha256"));
+        write_file(&layout_path, layout_json.as_bytes()).unwrap();
+        let result = processor.decode_symbols_checked(
+            symbols_dir.to_str().unwrap(),
+            output_path.to_str().unwrap(),
+            layout_path.to_str().unwrap(),
+        );
+        assert!(matches!(result, Err(ProcessError::DecodingFailed(_))), "Unexpected result {:?}", result);
+        assert!(!output_path.exists());
     }
 
     #[test]
@@ -1932,7 +12789,13 @@ mod tests {
         let block_layout = create_block_layout(&original_data, encoder_params, packets);
 
         let layout = RaptorQLayout {
+            layout_version: LAYOUT_VERSION,
             blocks: vec![block_layout],
+            object_sha256: String::new(),
+            symbol_codec: SymbolCodec::None,
+            symbol_key_id: String::new(),
+            symbol_crc: false,
+            metadata: BTreeMap::new(),
         };
         
         let layout_json = serde_json::to_string_pretty(&layout).expect("Failed to serialize layout");
@@ -1989,7 +12852,13 @@ mod tests {
         let block_layout = create_block_layout(&original_data, invalid_params.to_vec(), packets);
 
         let layout = RaptorQLayout {
+            layout_version: LAYOUT_VERSION,
             blocks: vec![block_layout],
+            object_sha256: String::new(),
+            symbol_codec: SymbolCodec::None,
+            symbol_key_id: String::new(),
+            symbol_crc: false,
+            metadata: BTreeMap::new(),
         };
         
         let layout_json = serde_json::to_string_pretty(&layout).expect("Failed to serialize layout");
@@ -2007,6 +12876,70 @@ mod tests {
         drop(temp_dir);
     }
 
+    #[test]
+    fn test_decode_symbols_parallel() {
+        let (_temp_dir, dir_path) = create_temp_dir();
+        let input_path = dir_path.join("input.bin");
+        let symbols_dir = dir_path.join("symbols");
+        let output_path = dir_path.join("output.bin");
+        // Blocks with different data, to catch a block written at the wrong offset
+        let original_data: Vec<u8> = (0..100 * 1024).map(|i| (i * 31 / 7 % 251) as u8).collect();
+        write_file(&input_path, &original_data).unwrap();
+
+        let config = ProcessorConfig { symbol_size: 1024, concurrency_limit: 4, ..ProcessorConfig::default() };
+        let processor = RaptorQProcessor::new(config);
+        let result = processor.encode_file(
+            input_path.to_str().unwrap(),
+            symbols_dir.to_str().unwrap(),
+            10 * 1024,
+            false,
+        ).unwrap();
+        assert_eq!(result.blocks.as_ref().map(|b| b.len()), Some(10));
+
+        processor.decode_symbols_parallel(
+            symbols_dir.to_str().unwrap(),
+            output_path.to_str().unwrap(),
+            &result.layout_file_path,
+        ).unwrap();
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
+        assert_eq!(processor.active_tasks.load(Ordering::SeqCst), 0, "Worker slots should be released");
+
+        // A single free slot decodes without workers
+        std::fs::remove_file(&output_path).unwrap();
+        processor.active_tasks.fetch_add(3, Ordering::SeqCst);
+        processor.decode_symbols_parallel(
+            symbols_dir.to_str().unwrap(),
+            output_path.to_str().unwrap(),
+            &result.layout_file_path,
+        ).unwrap();
+        assert_eq!(read_file(&output_path).unwrap(), original_data);
+        processor.active_tasks.fetch_sub(3, Ordering::SeqCst);
+
+        // The first failing block stops the decode and is reported
+        let layout: RaptorQLayout = serde_json::from_str(
+            &read_file_to_string(Path::new(&result.layout_file_path)).unwrap()
+        ).unwrap();
+        for block_id in [7, 3] {
+            let block = layout.blocks.iter().find(|b| b.block_id == block_id).unwrap();
+            for symbol_id in &block.symbols[5..] {
+                std::fs::remove_file(symbols_dir.join(format!("block_{}", block_id)).join(symbol_id)).unwrap();
+            }
+        }
+        let result = processor.decode_symbols_parallel(
+            symbols_dir.to_str().unwrap(),
+            output_path.to_str().unwrap(),
+            &result.layout_file_path,
+        );
+        match result {
+            Err(ProcessError::InsufficientSymbols(shortfalls)) => {
+                assert_eq!(shortfalls.len(), 1);
+                assert!([3, 7].contains(&shortfalls[0].block_id), "Unexpected shortfall {:?}", shortfalls[0]);
+            },
+            other => panic!("Unexpected result {:?}", other),
+        }
+        assert_eq!(processor.active_tasks.load(Ordering::SeqCst), 0);
+    }
+
     #[test]
     fn test_decode_concurrency_limit() {
         let (temp_dir, dir_path) = create_temp_dir();
@@ -2041,7 +12974,13 @@ mod tests {
         // Create a single BlockLayout for the entire file
         let block_layout = create_block_layout(&original_data, encoder_params, packets);
         let layout = RaptorQLayout {
+            layout_version: LAYOUT_VERSION,
             blocks: vec![block_layout],
+            object_sha256: String::new(),
+            symbol_codec: SymbolCodec::None,
+            symbol_key_id: String::new(),
+            symbol_crc: false,
+            metadata: BTreeMap::new(),
         };
 
         // Attempt to start another task
@@ -2090,7 +13029,13 @@ mod tests {
         let block_layout = create_block_layout(&original_data, encoder_params, packets);
         
         let layout = RaptorQLayout {
+            layout_version: LAYOUT_VERSION,
             blocks: vec![block_layout],
+            object_sha256: String::new(),
+            symbol_codec: SymbolCodec::None,
+            symbol_key_id: String::new(),
+            symbol_crc: false,
+            metadata: BTreeMap::new(),
         };
         
         let layout_json = serde_json::to_string_pretty(&layout).expect("Failed to serialize layout");
@@ -2172,18 +13117,18 @@ mod tests {
         
         // Test with data smaller than symbol size
         let small_data_len = 500;
-        let small_repair = processor.calculate_repair_symbols(small_data_len);
+        let small_repair = processor.calculate_repair_symbols(small_data_len, processor.config.symbol_size);
         assert_eq!(small_repair, 10); // Should be equal to redundancy_factor
         
         // Test with data larger than symbol size
         let large_data_len = 10000;
-        let large_repair = processor.calculate_repair_symbols(large_data_len);
+        let large_repair = processor.calculate_repair_symbols(large_data_len, processor.config.symbol_size);
         assert!(large_repair > 0);
         assert!(large_repair < large_data_len); // Should be less than data length
         
         // Test with exactly symbol size
         let exact_size_data_len = 1000;
-        let exact_repair = processor.calculate_repair_symbols(exact_size_data_len);
+        let exact_repair = processor.calculate_repair_symbols(exact_size_data_len, processor.config.symbol_size);
         assert!(exact_repair > 0);
     }
 
@@ -2366,7 +13311,13 @@ mod tests {
         }
 
         let layout = RaptorQLayout {
+            layout_version: LAYOUT_VERSION,
             blocks: block_layouts,
+            object_sha256: String::new(),
+            symbol_codec: SymbolCodec::None,
+            symbol_key_id: String::new(),
+            symbol_crc: false,
+            metadata: BTreeMap::new(),
         };
         
         // Save layout file
@@ -2438,6 +13389,25 @@ mod tests {
         drop(temp_dir);
     }
     
+    #[test]
+    fn test_create_metadata_to_file_skips_layout() {
+        let (_temp_dir, temp_path) = create_temp_dir();
+        let input_file_path = temp_path.join("input.txt");
+        let layout_file_path = temp_path.join("layout.json");
+        write_file(&input_file_path, &generate_test_data(5000)).unwrap();
+
+        let processor = RaptorQProcessor::new(ProcessorConfig::default());
+        let result = processor.create_metadata(
+            input_file_path.to_str().unwrap(),
+            layout_file_path.to_str().unwrap(),
+            0,
+        ).unwrap();
+
+        assert!(result.layout_content.is_none());
+        assert!(result.layout.is_none());
+        assert!(path_exists(&layout_file_path));
+    }
+
     #[test]
     fn test_create_metadata_return_layout() {
         // Create the test environment
@@ -2464,10 +13434,17 @@ mod tests {
         
         // Parse layout content
         let layout: RaptorQLayout = serde_json::from_str(&result.layout_content.unwrap()).unwrap();
+
+        // The parsed layout is returned as well
+        assert_eq!(result.layout.as_ref(), Some(&layout));
         
         // Verify layout contains expected data
         assert!(!layout.blocks.is_empty());
         assert!(!layout.blocks[0].symbols.is_empty());
+        assert_eq!(layout.blocks[0].size, 5000);
+        assert_eq!(layout.blocks[0].symbol_size(), 65528);
+        assert_eq!(layout.blocks[0].source_symbols_count(), 1);
+        assert_eq!(layout.blocks[0].encoder_config().unwrap().transfer_length(), 5000);
         
         // Verify symbols were NOT created
         let symbol_id = &layout.blocks[0].symbols[0];
@@ -2479,4 +13456,36 @@ mod tests {
         let layout_file_path = Path::new(&result.layout_file_path);
         assert!(!path_exists(layout_file_path), "Layout file should not exist on disk when return_layout is true");
     }
+
+    #[test]
+    fn test_min_symbols_for_block() {
+        let (_temp_dir, temp_path) = create_temp_dir();
+        let input_file_path = temp_path.join("input.bin");
+        let layout_file_path = temp_path.join("layout.json");
+        write_file(&input_file_path, &generate_test_data(25_000)).unwrap();
+
+        let config = ProcessorConfig { symbol_size: 1024, ..ProcessorConfig::default() };
+        let processor = RaptorQProcessor::new(config);
+        processor.create_metadata(
+            input_file_path.to_str().unwrap(),
+            layout_file_path.to_str().unwrap(),
+            10_000,
+        ).unwrap();
+        let layout_path = layout_file_path.to_str().unwrap();
+
+        // Blocks of 10000, 10000 and 5000 bytes: 10, 10 and 5 source symbols
+        assert_eq!(processor.min_symbols_for_block(layout_path, 0).unwrap(), 10 + DECODE_SYMBOL_OVERHEAD);
+        assert_eq!(processor.min_symbols_for_block(layout_path, 2).unwrap(), 5 + DECODE_SYMBOL_OVERHEAD);
+
+        let per_block = processor.min_symbols_per_block(layout_path).unwrap();
+        let expected: BTreeMap<usize, u64> = [(0, 12), (1, 12), (2, 7)].into_iter().collect();
+        assert_eq!(per_block, expected);
+
+        assert!(matches!(
+            processor.min_symbols_for_block(layout_path, 3),
+            Err(ProcessError::DecodingFailed(_))
+        ));
+        assert!(processor.get_last_error().contains("Block 3 not found"));
+        assert!(processor.min_symbols_per_block(temp_path.join("missing.json").to_str().unwrap()).is_err());
+    }
 }
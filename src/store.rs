@@ -0,0 +1,96 @@
+//! Sources of symbols and layouts other than the local filesystem
+//!
+//! `decode_from_store` reads the layout and the symbols through a `SymbolStore`
+//! instead of opening files, so they can live in an embedded filesystem, an object
+//! storage bucket or in memory. Paths in a store are relative and slash separated,
+//! like the paths of the symbols directory written by `encode_file`; `.` is the root.
+
+use std::collections::BTreeMap;
+use std::io;
+
+/// Read-only set of files holding symbols and layouts
+///
+/// Called from the thread running the decode, once per symbol read.
+pub trait SymbolStore {
+    /// Read a whole file, with `io::ErrorKind::NotFound` if there is none at `path`
+    fn read(&self, path: &str) -> io::Result<Vec<u8>>;
+}
+
+impl<S: SymbolStore + ?Sized> SymbolStore for &S {
+    fn read(&self, path: &str) -> io::Result<Vec<u8>> {
+        (**self).read(path)
+    }
+}
+
+/// Store keeping its files in memory, e.g. to decode symbols received over the network
+#[derive(Debug, Clone, Default)]
+pub struct MemoryStore {
+    files: BTreeMap<String, Vec<u8>>,
+}
+
+impl MemoryStore {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Add or replace the file at `path`
+    pub fn insert(&mut self, path: &str, data: Vec<u8>) {
+        self.files.insert(path.trim_start_matches("./").to_string(), data);
+    }
+
+    pub fn remove(&mut self, path: &str) -> Option<Vec<u8>> {
+        self.files.remove(path.trim_start_matches("./"))
+    }
+
+    pub fn len(&self) -> usize {
+        self.files.len()
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.files.is_empty()
+    }
+}
+
+impl SymbolStore for MemoryStore {
+    fn read(&self, path: &str) -> io::Result<Vec<u8>> {
+        self.files.get(path.trim_start_matches("./")).cloned().ok_or_else(|| {
+            io::Error::new(io::ErrorKind::NotFound, format!("No file {:?} in the store", path))
+        })
+    }
+}
+
+/// Path of `name` in the directory `dir` of a store
+pub fn store_path(dir: &str, name: &str) -> String {
+    let dir = dir.trim_end_matches('/');
+    if dir.is_empty() || dir == "." {
+        name.to_string()
+    } else {
+        format!("{}/{}", dir, name)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_memory_store() {
+        let mut store = MemoryStore::new();
+        assert!(store.is_empty());
+        store.insert("symbols/block_0/abc", vec![1, 2, 3]);
+        store.insert("./layout.json", b"{}".to_vec());
+
+        assert_eq!(store.len(), 2);
+        assert_eq!(store.read("symbols/block_0/abc").unwrap(), vec![1, 2, 3]);
+        assert_eq!(store.read("layout.json").unwrap(), b"{}");
+        assert_eq!(store.read("./layout.json").unwrap(), b"{}");
+        assert_eq!(store.read("symbols/block_0/def").unwrap_err().kind(), io::ErrorKind::NotFound);
+
+        assert_eq!(store.remove("layout.json"), Some(b"{}".to_vec()));
+        assert_eq!(store.read("layout.json").unwrap_err().kind(), io::ErrorKind::NotFound);
+
+        assert_eq!(store_path(".", "layout.json"), "layout.json");
+        assert_eq!(store_path("", "layout.json"), "layout.json");
+        assert_eq!(store_path("symbols/", "block_0"), "symbols/block_0");
+    }
+}
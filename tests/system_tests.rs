@@ -6,7 +6,7 @@
 
 use rand::{Rng, SeedableRng};
 use rand::rngs::StdRng;
-use rq_library::processor::{RaptorQProcessor, ProcessorConfig, ProcessResult};
+use rq_library::processor::{RaptorQProcessor, ProcessorConfig, ProcessResult, block_dir_name, symbol_esi};
 use sha3::{Digest, Sha3_256};
 use std::collections::HashSet;
 use std::fs::{self, File};
@@ -14,6 +14,14 @@ use std::io::{Read, Write};
 use std::path::{Path, PathBuf};
 use tempfile::{tempdir, TempDir};
 
+/// Whether a symbol file holds a repair symbol, from its encoding symbol ID
+fn is_repair_symbol(path: &Path, source_symbols_count: u64) -> std::io::Result<bool> {
+    let symbol = fs::read(path)?;
+    let esi = symbol_esi(&symbol)
+        .ok_or_else(|| std::io::Error::new(std::io::ErrorKind::InvalidData, "symbol is too short"))?;
+    Ok(esi as u64 >= source_symbols_count)
+}
+
 /// Helper function to generate a random binary file of specified size
 fn generate_random_file(path: &Path, size_bytes: usize) -> std::io::Result<()> {
     let mut file = File::create(path)?;
@@ -130,20 +138,12 @@ impl TestContext {
         if let Some(blocks) = &result.blocks {
             // For multi-block encoding
             for block in blocks {
-                // Format the block_id (now a usize) to create the directory name
-                let block_dir_name = format!("block_{}", block.block_id);
-                let block_dir = self.symbols_dir.join(&block_dir_name);
-                let entries = fs::read_dir(&block_dir)?;
-                
-                // Keep only source symbols (based on count)
-                let mut files: Vec<_> = entries.collect::<Result<Vec<_>, _>>()?;
-                
-                // Sort files to ensure deterministic behavior
-                files.sort_by_key(|entry| entry.file_name());
-                
-                // Delete repair symbols (keep only source_symbols count)
-                for entry in files.iter().skip(block.source_symbols_count as usize) {
-                    fs::remove_file(entry.path())?;
+                let block_dir = self.symbols_dir.join(block_dir_name(block.block_id));
+                for entry in fs::read_dir(&block_dir)? {
+                    let path = entry?.path();
+                    if is_repair_symbol(&path, block.source_symbols_count)? {
+                        fs::remove_file(path)?;
+                    }
                 }
             }
         }
@@ -161,26 +161,18 @@ impl TestContext {
         if let Some(blocks) = &result.blocks {
             // For chunked encoding
             for block in blocks {
-                // Format the block_id (now a usize) as a string to create the directory name
-                let block_dir_name = format!("block_{}", block.block_id);
-                let block_dir = self.symbols_dir.join(block_dir_name);
+                let block_dir = self.symbols_dir.join(block_dir_name(block.block_id));
                 let entries = fs::read_dir(&block_dir)?;
                 // Collect file entries and their paths
                 let files: Vec<_> = entries.collect::<Result<Vec<_>, _>>()?;
-                let source_symbols = block.source_symbols_count as usize;
                 
                 // Keep paths instead of DirEntry objects
                 let mut to_keep_paths = Vec::new();
                 
-                // Always keep source symbols
-                for i in 0..std::cmp::min(source_symbols, files.len()) {
-                    to_keep_paths.push(files[i].path());
-                }
-                
-                // Add random repair symbols
-                for i in source_symbols..files.len() {
-                    if rng.gen_bool(percentage) {
-                        to_keep_paths.push(files[i].path());
+                // Always keep source symbols, add random repair symbols
+                for file in &files {
+                    if !is_repair_symbol(&file.path(), block.source_symbols_count)? || rng.gen_bool(percentage) {
+                        to_keep_paths.push(file.path());
                     }
                 }
                 // Create a set of paths to keep (using HashSet for O(1) lookups)